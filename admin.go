@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/limits"
+)
+
+// agentVersion is reported by /status, /admin/version and heartbeats
+const agentVersion = "1.0.0"
+
+// agentStarted is reported as uptime
+var agentStarted = time.Now()
+
+// Lines returned by /admin/logs/tail
+const (
+	defaultTailLines = 100
+	maxTailLines     = 2000
+	maxTailBytes     = 1 << 20
+)
+
+// restartAgent is set by main to stop the HTTP server and start a fresh
+// copy of the agent. Tests replace it.
+var restartAgent = func() {}
+
+// AdminConfig is the agent's effective configuration as reported by
+// /admin/config
+type AdminConfig struct {
+	Version          string `json:"version"`
+	AppDir           string `json:"appDir"`
+	ScannerPort      string `json:"scannerPort"` // Empty when auto-detected
+	ReadTimeout      string `json:"readTimeout"`
+	ScanIdleTimeout  string `json:"scanIdleTimeout"`
+	MaxScanTimeout   string `json:"maxScanTimeout"`
+	Printer          string `json:"printer"`
+	AgreementPrinter string `json:"agreementPrinter"`
+	PrinterFallback  string `json:"printerFallback,omitempty"` // The configured printer, when it isn't installed and Printer is the system default
+	TemplatesDir     string `json:"templatesDir"`
+	PrintRateLimit   int    `json:"printRateLimit"`
+	PrintBurst       int    `json:"printBurst"`
+	MaxBodyBytes     int64  `json:"maxBodyBytes"`
+	DisplayPort      string `json:"displayPort"`
+	SignaturePort    string `json:"signaturePort"`
+	Webhooks         bool   `json:"webhooks"`
+	MQTTTopic        string `json:"mqttTopic"`
+	Privacy          bool   `json:"privacy"`
+	DebugScans       bool   `json:"debugScans"`
+	DebugPayloads    bool   `json:"debugPayloads"`
+}
+
+// AdminConfigUpdate changes the settings that can be adjusted without a
+// restart; omitted fields are left alone
+type AdminConfigUpdate struct {
+	Privacy       *bool `json:"privacy,omitempty"`
+	DebugScans    *bool `json:"debugScans,omitempty"`
+	DebugPayloads *bool `json:"debugPayloads,omitempty"`
+}
+
+// requireAdmin lets requests through only with the -admin-token-file
+// token as a bearer token. Without a token the admin endpoints are off.
+func requireAdmin(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeJSONError(w, http.StatusForbidden, errors.New("admin endpoints are disabled; start the agent with -admin-token-file"))
+			return
+		}
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="GoScanRentalTide admin"`)
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loadAdminToken reads the admin token from a file
+func loadAdminToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read admin token: %v", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) < 16 {
+		return "", fmt.Errorf("admin token in %s is shorter than 16 characters", path)
+	}
+	return token, nil
+}
+
+// adminConfigHandler reports the configuration (GET) or adjusts the
+// runtime settings (POST)
+func adminConfigHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var update AdminConfigUpdate
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&update); err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if update.Privacy != nil {
+			privacyMode.Store(*update.Privacy)
+		}
+		if update.DebugScans != nil {
+			debugScans.Store(*update.DebugScans)
+		}
+		if update.DebugPayloads != nil {
+			debugPayloads.Store(*update.DebugPayloads)
+		}
+		logAdmin(r, "updated config: privacy=%v debugScans=%v debugPayloads=%v", privacyMode.Load(), debugScans.Load(), debugPayloads.Load())
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only GET and POST methods are allowed"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminConfig{
+		Version:          agentVersion,
+		AppDir:           opts.AppDir,
+		ScannerPort:      opts.PortOverride,
+		ReadTimeout:      opts.ReadTimeout.String(),
+		ScanIdleTimeout:  opts.ScanIdleTimeout.String(),
+		MaxScanTimeout:   opts.MaxScanTimeout.String(),
+		Printer:          opts.PrinterName,
+		AgreementPrinter: opts.AgreementPrinter,
+		PrinterFallback:  opts.PrinterFallback,
+		TemplatesDir:     opts.TemplatesDir,
+		PrintRateLimit:   opts.PrintRateLimit,
+		PrintBurst:       opts.PrintBurst,
+		MaxBodyBytes:     opts.MaxBodyBytes,
+		DisplayPort:      opts.DisplayPort,
+		SignaturePort:    opts.SignaturePort,
+		Webhooks:         webhooks != nil,
+		MQTTTopic:        mqttTopic,
+		Privacy:          privacyMode.Load(),
+		DebugScans:       debugScans.Load(),
+		DebugPayloads:    debugPayloads.Load(),
+	})
+}
+
+// adminVersionHandler reports the build and the platform
+func adminVersionHandler(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]interface{}{
+		"status":        "success",
+		"version":       agentVersion,
+		"goVersion":     runtime.Version(),
+		"os":            runtime.GOOS,
+		"arch":          runtime.GOARCH,
+		"startedAt":     agentStarted.Format(time.RFC3339),
+		"uptimeSeconds": int(time.Since(agentStarted).Seconds()),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				resp["revision"] = s.Value
+			case "vcs.time":
+				resp["buildTime"] = s.Value
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// adminLogsTailHandler returns the last lines of a day's log (default
+// today). Query parameters: lines and date (YYYY-MM-DD).
+func adminLogsTailHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	query := r.URL.Query()
+	lines := defaultTailLines
+	if v := query.Get("lines"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid lines %q", v))
+			return
+		}
+		lines = min(n, maxTailLines)
+	}
+	date := time.Now().Format("2006-01-02")
+	if v := query.Get("date"); v != "" {
+		if _, err := time.Parse("2006-01-02", v); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid date %q: use YYYY-MM-DD", v))
+			return
+		}
+		date = v
+	}
+
+	name := fmt.Sprintf("goscantide-%s.log", date)
+	tail, err := tailFile(filepath.Join(opts.AppDir, "logs", name), lines)
+	if errors.Is(err, os.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("no log for %s", date))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"file":   name,
+		"lines":  tail,
+	})
+}
+
+// tailFile returns up to n final lines, reading at most maxTailBytes from
+// the end of the file
+func tailFile(path string, n int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-maxTailBytes, 0)
+	data := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if offset > 0 {
+		// Drop the partial first line
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+
+	all := strings.Split(strings.TrimRight(string(data), "\r\n"), "\n")
+	if len(all) == 1 && all[0] == "" {
+		return []string{}, nil
+	}
+	return all[max(len(all)-n, 0):], nil
+}
+
+// adminRestartHandler restarts the agent once the response is sent
+func adminRestartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+	logAdmin(r, "requested a restart")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":  "success",
+		"message": "Agent is restarting",
+	})
+	go restartAgent()
+}
+
+func logAdmin(r *http.Request, format string, args ...interface{}) {
+	logf(r.Context(), "Admin request from %s %s", limits.ClientIP(r), fmt.Sprintf(format, args...))
+}
+
+// restartProcess starts a new copy of the agent with the same arguments
+// and exits. It runs after the HTTP server has shut down, so the new copy
+// can take over the port.
+func restartProcess() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Restart failed: %v", err)
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("Restart failed: %v", err)
+	}
+	log.Printf("Restarted as process %d", cmd.Process.Pid)
+	os.Exit(0)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/tmplcache"
+	"GoScanRentalTide/internal/tmplfuncs"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// Built-in document templates. A file of the same name in the templates
+// directory (-templates, default <app dir>/templates) replaces the
+// built-in one, so shops can restyle documents without a rebuild. The
+// receipt template, receipt.html, can be replaced the same way.
+//
+//go:embed templates/*.html
+var builtinTemplates embed.FS
+
+// builtinTemplate returns the source of a built-in template: the receipt
+// or a document
+func builtinTemplate(name string) (string, error) {
+	if name == "receipt.html" {
+		return receiptTemplate, nil
+	}
+	data, err := builtinTemplates.ReadFile("templates/" + name)
+	if err != nil {
+		return "", fmt.Errorf("no template named %s", name)
+	}
+	return string(data), nil
+}
+
+// newDocumentTemplates returns a cache of the receipt and document
+// templates, read from templatesDir when it has a replacement
+func newDocumentTemplates(templatesDir string) *tmplcache.Cache {
+	return tmplcache.New(templatesDir, builtinTemplate, templateFuncs)
+}
+
+// templateFuncs are the functions of the agent's templates in a language
+func templateFuncs(lang string) template.FuncMap {
+	tr := i18n.New(lang)
+	funcs := tmplfuncs.New(currencyFormat)
+	funcs["t"] = tr.T
+	funcs["lang"] = tr.Language
+	funcs["fonts"] = func() template.CSS { return embeddedFonts }
+	return funcs
+}
+
+// AgreementItem is a piece of rented equipment
+type AgreementItem struct {
+	Name         string      `json:"name"`
+	AssetTag     string      `json:"assetTag,omitempty"`
+	SerialNumber string      `json:"serialNumber,omitempty"`
+	Quantity     int         `json:"quantity"`
+	Rate         money.Cents `json:"rate"`
+	RateUnit     string      `json:"rateUnit,omitempty"` // e.g. "day" or "hour"
+	Deposit      money.Cents `json:"deposit,omitempty"`
+	Condition    string      `json:"condition,omitempty"` // Condition noted at checkout
+}
+
+// AgreementData is a rental contract to print
+type AgreementData struct {
+	AgreementNumber string          `json:"agreementNumber"`
+	TransactionID   string          `json:"transactionId,omitempty"`
+	Customer        LicenseData     `json:"customer"` // As returned by /scanner/scan
+	Phone           string          `json:"phone,omitempty"`
+	Email           string          `json:"email,omitempty"`
+	Items           []AgreementItem `json:"items"`
+	RentalStart     string          `json:"rentalStart"`
+	RentalEnd       string          `json:"rentalEnd"`
+	Terms           []string        `json:"terms,omitempty"`
+	WaiverText      string          `json:"waiverText,omitempty"`
+	Location        string          `json:"location,omitempty"`
+	LogoUrl         string          `json:"logoUrl,omitempty"`
+	Date            string          `json:"date,omitempty"`
+	PaperSize       string          `json:"paperSize,omitempty"` // "letter" (default) or "a4"
+	Copies          int             `json:"copies"`
+	Language        string          `json:"language,omitempty"`
+	SignatureID     string          `json:"signatureId,omitempty"`  // Captured with /signature/start
+	ScanID          string          `json:"scanId,omitempty"`       // ID scan from /scanner/scan; fills in the customer
+	OperatorName    string          `json:"operatorName,omitempty"` // Staff member, journaled with the print job
+	StationID       string          `json:"stationId,omitempty"`    // Till or counter, journaled with the print job
+	Output          string          `json:"output,omitempty"`       // pdf (default) or html
+	Renderer        string          `json:"renderer,omitempty"`     // Browser for pdf output (default: -renderer)
+
+	// Derived fields (calculated before template rendering)
+	PageSize       string       `json:"-"`
+	RentalTotal    money.Cents  `json:"-"`
+	DepositTotal   money.Cents  `json:"-"`
+	SignatureImage template.URL `json:"-"`
+	VerifiedName   string       `json:"-"` // Masked, from the referenced scan
+}
+
+// generateHTMLAgreement renders an agreement with the agreement template
+func generateHTMLAgreement(agreement AgreementData, templates *tmplcache.Cache) (string, error) {
+	language := agreement.Language
+	if language == "" {
+		language = receiptLanguage
+	}
+
+	tmpl, err := templates.Get("agreement.html", i18n.New(language).Language())
+	if err != nil {
+		return "", fmt.Errorf("error parsing agreement template: %v", err)
+	}
+
+	html, err := tmplfuncs.Render(tmpl, agreement)
+	if err != nil {
+		return "", fmt.Errorf("error executing agreement template: %v", err)
+	}
+	return html, nil
+}
+
+// prepareAgreement fills in the derived fields of an agreement
+func prepareAgreement(agreement *AgreementData) {
+	switch strings.ToLower(agreement.PaperSize) {
+	case "a4":
+		agreement.PageSize = "A4"
+	default:
+		agreement.PageSize = "letter"
+	}
+	if agreement.Date == "" {
+		agreement.Date = time.Now().Format("2006-01-02")
+	}
+	for i, item := range agreement.Items {
+		if item.Quantity <= 0 {
+			agreement.Items[i].Quantity = 1
+		}
+		agreement.RentalTotal += item.Rate.Times(float64(agreement.Items[i].Quantity))
+		agreement.DepositTotal += item.Deposit
+	}
+}
+
+// renderAgreement fills in the derived fields, attaches the signature and
+// renders the agreement
+func renderAgreement(agreement AgreementData, opts agentOptions) (string, error) {
+	prepareAgreement(&agreement)
+	if agreement.SignatureID != "" {
+		image, err := signatureDataURL(opts.AppDir, agreement.SignatureID)
+		if err != nil {
+			return "", fmt.Errorf("error attaching signature: %v", err)
+		}
+		agreement.SignatureImage = image
+	}
+	return generateHTMLAgreement(agreement, opts.Templates)
+}
+
+// printAgreement renders the agreement and prints it through the PDF
+// pipeline with renderer
+func printAgreement(ctx context.Context, agreement AgreementData, opts agentOptions, renderer string) error {
+	html, err := renderAgreement(agreement, opts)
+	if err != nil {
+		return err
+	}
+
+	transactionID := agreement.TransactionID
+	if transactionID == "" {
+		transactionID = agreement.AgreementNumber
+	}
+	return printHTMLDocument(ctx, html, "agreement", transactionID, opts.AgreementPrinter, renderer)
+}
+
+// printAgreementHandler prints a rental agreement on the document printer
+func printAgreementHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var agreement AgreementData
+	if err := json.NewDecoder(r.Body).Decode(&agreement); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if agreement.AgreementNumber == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("agreement number is required"))
+		return
+	}
+
+	// A referenced scan supplies the customer, with the licence number masked
+	var scan verifiedScan
+	if agreement.ScanID != "" {
+		var err error
+		scan, err = lookupScan(agreement.ScanID)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if agreement.Customer.LastName == "" && agreement.Customer.FirstName == "" {
+			agreement.Customer = scan.License
+			agreement.Customer.RawData = ""
+		}
+		agreement.Customer.LicenseNumber = maskLicenseNumber(scan.License.LicenseNumber)
+		agreement.Customer.LicenseNumberRaw = maskLicenseNumber(scan.License.LicenseNumberRaw)
+		agreement.VerifiedName = scan.maskedName()
+	}
+	if agreement.Customer.LastName == "" && agreement.Customer.FirstName == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("customer name is required"))
+		return
+	}
+	if agreement.Customer.FullName == "" {
+		nameLicense(&agreement.Customer)
+	}
+	if len(agreement.Items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("at least one item is required"))
+		return
+	}
+	if agreement.SignatureID != "" {
+		if _, err := loadSignaturePNG(opts.AppDir, agreement.SignatureID); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if err := copiesError(agreement.Copies); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if agreement.Copies <= 0 {
+		agreement.Copies = 1
+	}
+	pipeline, err := parsePipeline(agreement.Output, agreement.Renderer, opts.Pipeline.forDocuments())
+	if err == nil && pipeline.Output == outputThermal {
+		err = errors.New("agreements can't be printed on a thermal printer (use pdf or html output)")
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	transactionID := agreement.TransactionID
+	if transactionID == "" {
+		transactionID = agreement.AgreementNumber
+	}
+	ctx, jobID := startPrintJob(r.Context())
+	printEntry := journal.Entry{
+		JobID:         jobID,
+		RequestID:     tracing.RequestID(ctx),
+		Kind:          journal.Print,
+		Status:        "success",
+		Document:      "agreement",
+		TransactionID: transactionID,
+		Copies:        agreement.Copies,
+		Printer:       opts.AgreementPrinter,
+		ScanID:        agreement.ScanID,
+		Operator:      agreement.OperatorName,
+		Station:       agreement.StationID,
+		Output:        pipeline.Output,
+	}
+
+	// A caller that prints the agreement itself gets the HTML back
+	if pipeline.Output == outputHTML {
+		html, err := renderAgreement(agreement, opts)
+		if err != nil {
+			printEntry.Status, printEntry.Error = "failed", err.Error()
+			recordJournal(printEntry)
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		retainJobArtifact(ctx, "html", transactionID, []byte(html))
+		printEntry.Printer, printEntry.Printed = "", agreement.Copies
+		recordJournal(printEntry)
+		if agreement.ScanID != "" {
+			recordScanLink(transactionID, "agreement", scan)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Rendered agreement for printing",
+			"jobId":   jobID,
+			"html":    html,
+		})
+		return
+	}
+
+	for i := 1; i <= agreement.Copies; i++ {
+		logf(r.Context(), "Printing agreement %s copy %d/%d", agreement.AgreementNumber, i, agreement.Copies)
+		if err := printAgreement(ctx, agreement, opts, pipeline.Renderer); err != nil {
+			logf(r.Context(), "Agreement %s failed to print: %v", agreement.AgreementNumber, err)
+			printEntry.Status, printEntry.Error = "failed", err.Error()
+			var manual *manualPrintError
+			if errors.As(err, &manual) {
+				printEntry.Status = manualPrint
+				recordJournal(printEntry)
+				writeManualPrint(w, manual)
+				return
+			}
+			recordJournal(printEntry)
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("printed %d/%d copies: %v", i-1, agreement.Copies, err))
+			return
+		}
+		printEntry.Printed = i
+	}
+	recordJournal(printEntry)
+
+	if agreement.ScanID != "" {
+		recordScanLink(transactionID, "agreement", scan)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Printed %d/%d copies successfully", agreement.Copies, agreement.Copies),
+		"jobId":   jobID,
+	})
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"GoScanRentalTide/internal/money"
+)
+
+// Receipts a benchmark may render per backend
+const (
+	defaultBenchmarkCount = 10
+	maxBenchmarkCount     = 200
+)
+
+// BenchmarkRequest is the body of /admin/benchmark; an empty body renders
+// ten receipts through the configured output without printing them
+type BenchmarkRequest struct {
+	Count    int      `json:"count,omitempty"`    // Receipts per backend, default 10, at most 200
+	Backends []string `json:"backends,omitempty"` // Outputs to measure: pdf, thermal or html (default: -output)
+	Renderer string   `json:"renderer,omitempty"` // Browser for pdf (default: -renderer)
+	Print    bool     `json:"print,omitempty"`    // Also print every receipt on the receipt printer, which uses paper
+}
+
+// BenchmarkResponse is the result of /admin/benchmark
+type BenchmarkResponse struct {
+	Status       string            `json:"status"`
+	Count        int               `json:"count"`
+	Printed      bool              `json:"printed"`
+	WarmRenderer bool              `json:"warmRenderer"`
+	Concurrency  int               `json:"renderConcurrency"`
+	Results      []BenchmarkResult `json:"results"`
+}
+
+// BenchmarkResult is how one backend did
+type BenchmarkResult struct {
+	Backend           string          `json:"backend"`
+	Renderer          string          `json:"renderer,omitempty"`
+	Succeeded         int             `json:"succeeded"`
+	Failed            int             `json:"failed"`
+	Render            LatencySummary  `json:"render"`
+	Print             *LatencySummary `json:"print,omitempty"` // Only when printing; html is never printed by the agent
+	ReceiptsPerMinute float64         `json:"receiptsPerMinute"`
+	Error             string          `json:"error,omitempty"` // The first failure
+}
+
+// LatencySummary are percentiles of one step, in milliseconds
+type LatencySummary struct {
+	P50 float64 `json:"p50Ms"`
+	P95 float64 `json:"p95Ms"`
+	Max float64 `json:"maxMs"`
+}
+
+// adminBenchmarkHandler renders, and optionally prints, a batch of
+// synthetic receipts through each backend one after another, so a
+// station can be checked against its throughput targets before it is
+// needed
+func adminBenchmarkHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+	var req BenchmarkRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Count == 0 {
+		req.Count = defaultBenchmarkCount
+	}
+	if req.Count < 0 || req.Count > maxBenchmarkCount {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("count must be between 1 and %d", maxBenchmarkCount))
+		return
+	}
+	if len(req.Backends) == 0 {
+		defaults, _ := parsePipeline("", "", opts.Pipeline)
+		req.Backends = []string{defaults.Output}
+	}
+	pipelines := make([]renderPipeline, len(req.Backends))
+	for i, backend := range req.Backends {
+		renderer := ""
+		if backend == outputPDF {
+			renderer = req.Renderer
+		}
+		var err error
+		pipelines[i], err = parsePipeline(backend, renderer, opts.Pipeline)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	printerName := ""
+	if req.Print {
+		printerName = opts.PrinterName
+	}
+
+	logAdmin(r, "started a benchmark: %d receipts through %v, printing %v", req.Count, req.Backends, req.Print)
+	resp := BenchmarkResponse{
+		Status:       "success",
+		Count:        req.Count,
+		Printed:      req.Print,
+		WarmRenderer: warmRenderer,
+		Concurrency:  cap(renderSlots),
+	}
+	for _, pipeline := range pipelines {
+		resp.Results = append(resp.Results, runBenchmark(r.Context(), pipeline, req.Count, req.Print, printerName))
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runBenchmark times count receipts through one pipeline
+func runBenchmark(ctx context.Context, pipeline renderPipeline, count int, print bool, printerName string) BenchmarkResult {
+	result := BenchmarkResult{Backend: pipeline.Output, Renderer: pipeline.Renderer}
+	var renders, prints []time.Duration
+	started := time.Now()
+	for i := 1; i <= count && ctx.Err() == nil; i++ {
+		render, printed, err := benchmarkReceipt(ctx, pipeline, benchmarkReceiptData(i), print, printerName)
+		if err != nil {
+			result.Failed++
+			if result.Error == "" {
+				result.Error = err.Error()
+			}
+			continue
+		}
+		result.Succeeded++
+		renders = append(renders, render)
+		if print && pipeline.Output != outputHTML {
+			prints = append(prints, printed)
+		}
+	}
+
+	result.Render = summarizeLatency(renders)
+	if print && pipeline.Output != outputHTML {
+		summary := summarizeLatency(prints)
+		result.Print = &summary
+	}
+	if elapsed := time.Since(started); result.Succeeded > 0 {
+		result.ReceiptsPerMinute = math.Round(float64(result.Succeeded)/elapsed.Minutes()*10) / 10
+	}
+	return result
+}
+
+// benchmarkReceipt renders one receipt, and prints it when asked,
+// returning how long each step took
+func benchmarkReceipt(ctx context.Context, pipeline renderPipeline, receipt ReceiptData, print bool, printerName string) (render, printed time.Duration, err error) {
+	start := time.Now()
+	switch pipeline.Output {
+	case outputThermal:
+		prepareReceipt(&receipt)
+		data := formatThermalReceipt(receipt)
+		render = time.Since(start)
+		if print {
+			start = time.Now()
+			err = printRaw(ctx, data, "benchmark", receipt.TransactionID, printerName)
+			printed = time.Since(start)
+		}
+		return render, printed, err
+
+	case outputHTML:
+		_, err = renderReceipt(receipt)
+		return time.Since(start), 0, err
+	}
+
+	html, err := renderReceipt(receipt)
+	if err != nil {
+		return 0, 0, err
+	}
+	dir, err := os.MkdirTemp("", "benchmark-")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.RemoveAll(dir)
+	htmlPath, pdfPath := filepath.Join(dir, "receipt.html"), filepath.Join(dir, "receipt.pdf")
+	if err := os.WriteFile(htmlPath, []byte(html), 0644); err != nil {
+		return 0, 0, err
+	}
+	if err := convertToPDF(ctx, htmlPath, pdfPath, pipeline.Renderer); err != nil {
+		return 0, 0, err
+	}
+	render = time.Since(start)
+	if print {
+		start = time.Now()
+		err = printPDF(ctx, pdfPath, "benchmark", printerName)
+		printed = time.Since(start)
+	}
+	return render, printed, err
+}
+
+// benchmarkReceiptData is a typical rental sale: a few items, tax and a
+// card payment
+func benchmarkReceiptData(n int) ReceiptData {
+	now := time.Now()
+	return ReceiptData{
+		TransactionID: fmt.Sprintf("BENCH-%s-%03d", now.Format("20060102-150405"), n),
+		Items: []ReceiptItem{
+			{Name: "Mountain bike", Quantity: 1, Price: money.Cents(4500), SKU: "BIKE-MTN", ItemType: itemRental, RateUnit: "daily"},
+			{Name: "Helmet", Quantity: 1, Price: money.Cents(800), SKU: "HELMET", ItemType: itemRental, RateUnit: "daily"},
+			{Name: "Damage deposit", Quantity: 1, Price: money.Cents(10000), ItemType: itemDeposit},
+			{Name: "Water bottle", Quantity: 2, Price: money.Cents(350), SKU: "WATER"},
+		},
+		Subtotal:    money.Cents(6000),
+		Tax:         money.Cents(720),
+		Total:       money.Cents(16720),
+		Date:        now.Format("2006-01-02 15:04:05"),
+		Location:    "Benchmark",
+		PaymentType: "card",
+		Copies:      1,
+	}
+}
+
+// summarizeLatency returns the nearest-rank percentiles of durations
+func summarizeLatency(durations []time.Duration) LatencySummary {
+	if len(durations) == 0 {
+		return LatencySummary{}
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(sorted)))) - 1
+		return milliseconds(sorted[max(i, 0)])
+	}
+	return LatencySummary{P50: rank(0.50), P95: rank(0.95), Max: milliseconds(sorted[len(sorted)-1])}
+}
+
+// milliseconds rounds d to a tenth of a millisecond
+func milliseconds(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*10) / 10
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"time"
+)
+
+// Bluetooth scanners are paired with the serial port profile (SPP) and
+// appear as a serial port: /dev/rfcommN on Linux, /dev/cu.<name>-SerialPort
+// on macOS, and an outgoing virtual COM port on Windows, which looks like
+// any other and needs -bluetooth. Opening the port connects to the
+// scanner, which can take seconds and fails while it is asleep, out of
+// range or still reconnecting, so opens are retried.
+
+// bluetoothLink is how the agent connects to a Bluetooth scanner
+type bluetoothLink struct {
+	Always         bool          // -bluetooth: the port is Bluetooth whatever it is called
+	ConnectTimeout time.Duration // How long to keep trying to connect
+}
+
+// isBluetoothPort reports whether a port name is a Bluetooth SPP port
+func isBluetoothPort(name string) bool {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasPrefix(lower, "/dev/rfcomm"):
+		return true
+	case strings.HasPrefix(lower, "/dev/cu.") || strings.HasPrefix(lower, "/dev/tty."):
+		// Not the Mac's own port for incoming connections
+		return strings.Contains(lower, "serialport") || (strings.Contains(lower, "bluetooth") && !strings.Contains(lower, "incoming"))
+	}
+	return false
+}
+
+// bluetoothPortFor returns the first Bluetooth scanner port in a list, for
+// when no USB scanner is attached
+func bluetoothPortFor(ports []string) (string, bool) {
+	for _, port := range ports {
+		if isBluetoothPort(port) {
+			return port, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"GoScanRentalTide/internal/sanitize"
+)
+
+// Prefix of a logo in the assets directory, as in "local:storelogo.png",
+// for logos that can't be fetched without signing in
+const localAssetPrefix = "local:"
+
+// Helper function to get the directory served under /assets/
+func (s *Server) assetsDir() string {
+	if s.config.AssetsDir != "" {
+		return s.config.AssetsDir
+	}
+	return filepath.Join(s.config.DataDir, "assets")
+}
+
+// Helper function to check the name of a file in the assets directory:
+// a slash-separated path that stays inside it
+func checkAssetName(name string) error {
+	if !fs.ValidPath(name) || name == "." || strings.ContainsAny(name, `\:`) {
+		return fmt.Errorf("invalid asset name %q", name)
+	}
+	return nil
+}
+
+// Helper function to check a logo reference: an asset as local:NAME, or
+// an http(s) URL the server fetches
+func checkLogo(logo string) error {
+	if name, ok := strings.CutPrefix(logo, localAssetPrefix); ok {
+		return checkAssetName(name)
+	}
+	return sanitize.URL(logo)
+}
+
+// Helper function to get the address a receipt preview loads a logo from:
+// local:NAME becomes the asset's /assets/ path
+func logoSrc(logo string) string {
+	if name, ok := strings.CutPrefix(logo, localAssetPrefix); ok {
+		return "/assets/" + (&url.URL{Path: name}).EscapedPath()
+	}
+	return logo
+}
+
+// Helper function to get the raster commands of a logo, read from the
+// assets directory or downloaded
+func (s *Server) logoRaster(logo string, width int) ([]byte, error) {
+	name, ok := strings.CutPrefix(logo, localAssetPrefix)
+	if !ok {
+		return s.logos.Raster(logo, width)
+	}
+	if err := checkAssetName(name); err != nil {
+		return nil, err
+	}
+	return s.logos.RasterFile(filepath.Join(s.assetsDir(), filepath.FromSlash(name)), width)
+}
+
+// Handler: Serve a file of the assets directory (logos, fonts), so
+// receipt previews don't depend on where the store's images are hosted
+func (s *Server) handleAsset(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.PathValue("name")
+	if err := checkAssetName(name); err != nil {
+		s.sendErrorResponse(w, http.StatusNotFound, "Asset not found")
+		return
+	}
+	f, err := os.Open(filepath.Join(s.assetsDir(), filepath.FromSlash(name)))
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusNotFound, "Asset not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		s.sendErrorResponse(w, http.StatusNotFound, "Asset not found")
+		return
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"GoScanRentalTide/internal/audit"
+)
+
+// Header naming the staff member a request is made for, which the audit
+// log records
+const operatorHeader = "X-Operator-Id"
+
+// Audit log, as returned by /audit
+type AuditResponse struct {
+	Success bool          `json:"success"`
+	Valid   bool          `json:"valid"`             // The hash chain is intact
+	Problem string        `json:"problem,omitempty"` // Where the chain breaks when it isn't
+	Events  []audit.Event `json:"events"`            // Oldest first
+}
+
+// Helper function to open the audit log on first use. It has its own
+// file so the scanner agent, which may share the data directory, keeps
+// a separate chain. A failure isn't kept: the next event tries again.
+func (s *Server) auditLog() (*audit.Log, error) {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	if s.audit == nil {
+		trail, err := audit.Open(filepath.Join(s.config.DataDir, "audit", "receipt-server.jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		s.audit = trail
+	}
+	return s.audit, nil
+}
+
+// Record a cash-impacting event with the request's operator, or fallback
+// when the request names none. Failures are logged and returned; only
+// drawer opens are refused over them (see auditDrawer).
+func (s *Server) recordAudit(r *http.Request, fallback string, e audit.Event) error {
+	e.Operator = strings.TrimSpace(r.Header.Get(operatorHeader))
+	if e.Operator == "" {
+		e.Operator = fallback
+	}
+	trail, err := s.auditLog()
+	if err == nil {
+		_, err = trail.Append(e)
+	}
+	if err != nil {
+		s.logf(r.Context(), "⚠️  Failed to audit %s: %v", e.Action, err)
+	}
+	return err
+}
+
+// Record a drawer opening before it happens. Drawer opens fail closed:
+// one that can't be audited is refused rather than opened unrecorded.
+func (s *Server) auditDrawer(w http.ResponseWriter, r *http.Request, fallback string, e audit.Event) bool {
+	if err := s.recordAudit(r, fallback, e); err != nil {
+		s.sendErrorResponse(w, http.StatusServiceUnavailable, fmt.Sprintf("The cash drawer was not opened: it can't be audited: %v", err))
+		return false
+	}
+	return true
+}
+
+// Audit event for a no-sale or refund receipt; ok is false for receipts
+// that aren't audited
+func receiptAuditEvent(receipt ReceiptData) (e audit.Event, ok bool) {
+	e = audit.Event{
+		TransactionID: receipt.TransactionID,
+		Detail:        fmt.Sprintf("%d copies", receipt.Copies),
+	}
+	switch receipt.Type {
+	case "noSale":
+		e.Action = audit.NoSale
+	case "refund":
+		var method string
+		e.Action = audit.Refund
+		e.Amount, method = refundDetails(receipt)
+		if method != "" {
+			e.Detail += ", refunded to " + strings.ToLower(method)
+		}
+	default:
+		return e, false
+	}
+	if receipt.StationID != "" {
+		e.Detail += ", station " + receipt.StationID
+	}
+	return e, true
+}
+
+// Record a refund receipt once it printed. No-sales open the drawer, so
+// they are recorded before printing, with auditDrawer.
+func (s *Server) auditReceipt(r *http.Request, receipt ReceiptData) {
+	if e, ok := receiptAuditEvent(receipt); ok && e.Action != audit.NoSale {
+		s.recordAudit(r, receipt.OperatorName, e)
+	}
+}
+
+// Handler: Audit log of no-sales, drawer opens, refunds and reprints,
+// checked for tampering
+func (s *Server) handleAudit(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "GET" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	trail, err := s.auditLog()
+	var events []audit.Event
+	if err == nil {
+		events, err = trail.Events()
+	}
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := AuditResponse{Success: true, Valid: true, Events: events}
+	if resp.Events == nil {
+		resp.Events = []audit.Event{}
+	}
+	if err := audit.Verify(events); err != nil {
+		resp.Valid, resp.Problem = false, err.Error()
+	}
+	s.sendJSONResponse(w, http.StatusOK, resp)
+}
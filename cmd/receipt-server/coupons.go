@@ -0,0 +1,96 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/barcode"
+	"GoScanRentalTide/internal/coupons"
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/paper"
+)
+
+// Height of coupon barcodes, in modules in HTML and dots on thermal paper
+const (
+	couponBarcodeModules = 40
+	couponBarcodeDots    = 60
+)
+
+// A coupon as rendered, with its barcode drawn
+type CouponBlock struct {
+	coupons.Coupon
+	BarcodeImage template.URL
+}
+
+// Helper function to give a sale the coupons it prints: its own, or the
+// next ones of the configured rotation. Refunds and no-sales print none,
+// and neither do duplicates, so a reprint doesn't hand out a second coupon.
+func (s *Server) pickCoupons(receipt *ReceiptData) {
+	if receipt.Type == "noSale" || receipt.Type == "refund" || receipt.Duplicate {
+		receipt.Coupons = nil
+		return
+	}
+	if len(receipt.Coupons) > 0 || s.coupons == nil {
+		return
+	}
+	receipt.Coupons = s.coupons.Pick(time.Now(), receipt.Total)
+}
+
+// Helper function to draw the barcodes of coupons and fill in their
+// expiries
+func (s *Server) couponBlocks(list []coupons.Coupon) []CouponBlock {
+	var rendered []CouponBlock
+	for _, cp := range list {
+		cp.Expiry = cp.ExpiresOn(time.Now())
+		block := CouponBlock{Coupon: cp}
+		if cp.Barcode != "" {
+			url, err := barcode.DataURL(cp.Barcode, couponBarcodeModules)
+			if err != nil {
+				s.logger.Printf("Printing coupon %q without its barcode: %v", cp.Title, err)
+			}
+			block.BarcodeImage = template.URL(url)
+		}
+		rendered = append(rendered, block)
+	}
+	return rendered
+}
+
+// Helper function to write coupons for ESC/POS after the footer, each
+// between cut lines, with its barcode drawn by the printer
+func (s *Server) writeCoupons(builder *thermalBuilder, list []coupons.Coupon, tr i18n.Translator) {
+	for _, cp := range list {
+		builder.Align(escpos.AlignLeft)
+		builder.WriteString(cutLine(s.paper()))
+		builder.Align(escpos.AlignCenter)
+		if cp.Title != "" {
+			builder.Bold(true)
+			builder.WriteString(cp.Title + "\n")
+			builder.Bold(false)
+		}
+		for _, line := range cp.Lines() {
+			for _, wrapped := range wrapText(builder.page.Transliterate(line), s.paper().Columns) {
+				builder.WriteString(wrapped + "\n")
+			}
+		}
+		if cp.Barcode != "" {
+			if err := builder.Code128(cp.Barcode, couponBarcodeDots); err != nil {
+				builder.WriteString(cp.Barcode)
+			}
+			builder.WriteString("\n")
+		}
+		if expiry := cp.ExpiresOn(time.Now()); expiry != "" {
+			builder.WriteString(tr.T("coupon_expires", expiry) + "\n")
+		}
+	}
+	if len(list) > 0 {
+		builder.Align(escpos.AlignLeft)
+		builder.WriteString(cutLine(s.paper()))
+	}
+}
+
+// Helper function to get a dashed line to cut a coupon off along
+func cutLine(size paper.Size) string {
+	return strings.Repeat("- ", size.Columns/2) + "\n"
+}
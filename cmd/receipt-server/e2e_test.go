@@ -0,0 +1,2472 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"GoScanRentalTide/internal/apiversion"
+	"GoScanRentalTide/internal/audit"
+	"GoScanRentalTide/internal/coupons"
+	"GoScanRentalTide/internal/dedupe"
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/merchant"
+	"GoScanRentalTide/internal/messages"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/paper"
+	"GoScanRentalTide/internal/script"
+	"GoScanRentalTide/internal/sequence"
+	"GoScanRentalTide/internal/tax"
+	"GoScanRentalTide/internal/testharness"
+	"GoScanRentalTide/internal/validate"
+	"GoScanRentalTide/internal/webhook"
+)
+
+// startReceiptServer runs the receipt server's routes with an emulated
+// network printer
+func startReceiptServer(t *testing.T) (*testharness.Server, *testharness.PrinterEmulator) {
+	t.Helper()
+
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		Port:        0,
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		LogLevel:    "INFO",
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+	})
+	return testharness.Start(t, s.setupRoutes()), printer
+}
+
+var sampleReceipt = map[string]interface{}{
+	"transactionId": "TXN-2001",
+	"items": []map[string]interface{}{
+		{"name": "Bike Rental", "quantity": 2, "price": 30.00},
+		{"name": "Lock", "quantity": 1, "price": 5.00},
+	},
+	"subtotal":    65.00,
+	"tax":         7.80,
+	"total":       72.80,
+	"paymentType": "cash",
+	"cashGiven":   80.00,
+	"changeDue":   7.20,
+	"date":        "2025-06-01 10:15",
+	"location":    "Harbour",
+}
+
+func TestPrintReceiptToNetworkPrinter(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{"copies": 2}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	resp := server.PostJSON("/print/receipt", receipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	body := resp.JSON(t)
+	if body["success"] != true {
+		t.Fatalf("print failed: %s", resp.Body)
+	}
+	copies, _ := body["copies"].([]interface{})
+	for i, c := range copies {
+		if result := c.(map[string]interface{}); result["copy"] != float64(i+1) || result["status"] != "printed" || result["method"] != "tcp" {
+			t.Errorf("copy %d = %v", i+1, result)
+		}
+	}
+	if len(copies) != 2 {
+		t.Errorf("copies = %v", body["copies"])
+	}
+
+	jobs := printer.WaitForJobs(t, 2, 5*time.Second)
+	job := jobs[0]
+	if !bytes.HasPrefix(job, []byte("\x1b@")) {
+		t.Errorf("job does not start with an ESC/POS reset: %q", job[:8])
+	}
+	if !bytes.Contains(job, []byte("\x1dV")) {
+		t.Error("job does not cut the paper")
+	}
+	for _, want := range []string{"TXN-2001", "Bike Rental", "Lock", "$72.80", "GST (5%)", "$3.25", "PST (7%)", "$4.55", "$7.20"} {
+		if !bytes.Contains(job, []byte(want)) {
+			t.Errorf("printed receipt is missing %q", want)
+		}
+	}
+}
+
+func TestPrintRefundReceipt(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	refund := map[string]interface{}{
+		"type":                  "refund",
+		"originalTransactionId": "TXN-2001",
+		"refundAmount":          35.00,
+		"refundMethod":          "card",
+	}
+	for k, v := range sampleReceipt {
+		if _, ok := refund[k]; !ok {
+			refund[k] = v
+		}
+	}
+	refund["transactionId"] = "TXN-2002"
+
+	if resp := server.PostJSON("/print/receipt", refund); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"REFUND", "TXN-2001", "-$35.00"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("refund receipt is missing %q", want)
+		}
+	}
+}
+
+func TestPrintDuplicateReceipt(t *testing.T) {
+	for _, action := range []string{dedupe.Banner, dedupe.Reject} {
+		t.Run(action, func(t *testing.T) {
+			printer := testharness.NewPrinterEmulator(t)
+			s := NewServer(Config{
+				PrinterIP:       printer.Host(),
+				PrinterPort:     printer.Port(),
+				DataDir:         t.TempDir(),
+				Tax:             tax.DefaultConfig(),
+				Currency:        money.DefaultFormat(),
+				DuplicateWindow: time.Minute,
+				DuplicateAction: action,
+			})
+			server := testharness.Start(t, s.setupRoutes())
+
+			if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+				t.Fatalf("first print: status = %d, body %s", resp.StatusCode, resp.Body)
+			}
+			printer.WaitForJobs(t, 1, 5*time.Second)
+			resp := server.PostJSON("/print/receipt", sampleReceipt)
+			if action == dedupe.Reject {
+				if resp.StatusCode != http.StatusConflict {
+					t.Errorf("status = %d, want 409 (body %s)", resp.StatusCode, resp.Body)
+				}
+				return
+			}
+			if resp.StatusCode != 200 || resp.JSON(t)["duplicate"] != true {
+				t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+			}
+			jobs := printer.WaitForJobs(t, 2, 5*time.Second)
+			if bytes.Contains(jobs[0], []byte("DUPLICATE")) || !bytes.Contains(jobs[1], []byte("*** DUPLICATE ***")) {
+				t.Error("only the second copy should carry the DUPLICATE banner")
+			}
+		})
+	}
+}
+
+func TestPrintReceiptPrinterOffline(t *testing.T) {
+	events := make(chan webhook.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err == nil {
+			events <- event
+		}
+	}))
+	defer receiver.Close()
+	next := func() webhook.Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook delivered")
+			return webhook.Event{}
+		}
+	}
+
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		Webhooks:    webhook.Config{Endpoints: []webhook.Endpoint{{URL: receiver.URL}}},
+	})
+	defer s.webhooks.Close(context.Background())
+	server := testharness.Start(t, s.setupRoutes())
+	if err := printer.Close(); err != nil {
+		t.Fatalf("closing printer emulator: %v", err)
+	}
+
+	// The receipt is spooled rather than lost
+	receipt := map[string]interface{}{"copies": 2}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	resp := server.PostJSON("/print/receipt", receipt)
+	if resp.StatusCode != 202 {
+		t.Fatalf("status = %d, want 202 (body %s)", resp.StatusCode, resp.Body)
+	}
+	if body := resp.JSON(t); body["success"] != true || body["spooled"] != true {
+		t.Errorf("response = %s", resp.Body)
+	}
+	var body struct{ Copies []CopyResult }
+	json.Unmarshal(resp.Body, &body)
+	if len(body.Copies) != 2 || body.Copies[0].Status != "spooled" || body.Copies[0].Error == "" || body.Copies[1].Status != "spooled" {
+		t.Errorf("copies = %+v", body.Copies)
+	}
+	if spooled := next(); spooled.Type != webhook.PrintSpooled {
+		t.Errorf("event = %s, want %s", spooled.Type, webhook.PrintSpooled)
+	}
+	if health := server.Get("/health").JSON(t); health["status"] != "offline" || health["spooled"] != 1.0 {
+		t.Errorf("health while offline = %v", health)
+	}
+
+	// Once /health finds the printer back, both copies print
+	printer.Reopen(t)
+	if health := server.Get("/health").JSON(t); health["status"] != "online" {
+		t.Fatalf("health once back = %v", health)
+	}
+	for _, job := range printer.WaitForJobs(t, 2, 5*time.Second) {
+		if !bytes.Contains(job, []byte("TXN-2001")) {
+			t.Errorf("spooled job = %q", job)
+		}
+	}
+	flushed := next()
+	data, _ := flushed.Data.(map[string]interface{})
+	if flushed.Type != webhook.SpoolFlushed || data["printed"] != 1.0 || data["remaining"] != 0.0 {
+		t.Errorf("flush event = %+v", flushed)
+	}
+	if health := server.Get("/health").JSON(t); health["spooled"] != 0.0 {
+		t.Errorf("spool after the flush = %v", health["spooled"])
+	}
+}
+
+func TestPrintReceiptPrinterOfflineMidway(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{"copies": 3}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	done := make(chan testharness.Response)
+	go func() { done <- server.PostJSON("/print/receipt", receipt) }()
+
+	// The printer goes away after the first copy
+	printer.WaitForJobs(t, 1, 5*time.Second)
+	if err := printer.Close(); err != nil {
+		t.Fatalf("closing printer emulator: %v", err)
+	}
+	resp := <-done
+	if resp.StatusCode != 202 {
+		t.Fatalf("status = %d, want 202 (body %s)", resp.StatusCode, resp.Body)
+	}
+	var body PrintResponse
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(body.Message, "Printed 1 of 3 copies") {
+		t.Errorf("message = %q", body.Message)
+	}
+	want := []CopyResult{
+		{Copy: 1, Status: "printed", Method: "tcp"},
+		{Copy: 2, Status: "spooled", Method: "spool"},
+		{Copy: 3, Status: "spooled", Method: "spool"},
+	}
+	if len(body.Copies) != len(want) {
+		t.Fatalf("copies = %+v", body.Copies)
+	}
+	for i, got := range body.Copies {
+		got.Error = ""
+		if got != want[i] {
+			t.Errorf("copy %d = %+v, want %+v", i+1, got, want[i])
+		}
+	}
+	if body.Copies[1].Error == "" {
+		t.Error("the copy that failed has no error")
+	}
+}
+
+func TestPrinterStatusAlerts(t *testing.T) {
+	events := make(chan webhook.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err == nil {
+			events <- event
+		}
+	}))
+	defer receiver.Close()
+	next := func() webhook.Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("no webhook delivered")
+			return webhook.Event{}
+		}
+	}
+
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		Webhooks:    webhook.Config{Endpoints: []webhook.Endpoint{{URL: receiver.URL}}},
+	})
+	defer s.webhooks.Close(context.Background())
+	server := testharness.Start(t, s.setupRoutes())
+
+	// A healthy printer raises nothing
+	s.checkPrinters(context.Background())
+	if health := server.Get("/health").JSON(t); health["alerts"] != nil {
+		t.Errorf("healthy printer alerts = %v", health["alerts"])
+	}
+
+	// Roll paper near its end (DLE EOT 4) with the cover open (DLE EOT 2)
+	printer.SetStatus(map[byte]byte{2: 0x16, 3: 0x12, 4: 0x1E})
+	s.checkPrinters(context.Background())
+	for _, want := range []string{escpos.AlertPaperLow, escpos.AlertCoverOpen} {
+		event := next()
+		data, _ := event.Data.(map[string]interface{})
+		if event.Type != webhook.PrinterAlert || data["alert"] != want || data["printer"] != "receipt" {
+			t.Errorf("event = %+v, want a %s alert", event, want)
+		}
+	}
+	health := server.Get("/health").JSON(t)
+	if alerts, _ := health["alerts"].([]interface{}); len(alerts) != 2 {
+		t.Errorf("health alerts = %v", health["alerts"])
+	}
+	metrics := string(server.Get("/metrics").Body)
+	for _, line := range []string{
+		`receipt_printer_up{printer="receipt"} 1`,
+		`receipt_printer_alert{printer="receipt",alert="paper_low"} 1`,
+		`receipt_printer_alert{printer="receipt",alert="cutter_error"} 0`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("metrics lack %s:\n%s", line, metrics)
+		}
+	}
+
+	// The same status again raises nothing; closing the cover clears it
+	s.checkPrinters(context.Background())
+	printer.SetStatus(map[byte]byte{2: 0x12, 3: 0x12, 4: 0x1E})
+	s.checkPrinters(context.Background())
+	if event := next(); event.Type != webhook.PrinterAlertCleared || event.Data.(map[string]interface{})["alert"] != escpos.AlertCoverOpen {
+		t.Errorf("event = %+v, want cover_open cleared", event)
+	}
+
+	// A printer that doesn't answer goes offline once
+	printer.Close()
+	s.checkPrinters(context.Background())
+	s.checkPrinters(context.Background())
+	if event := next(); event.Type != webhook.PrinterOffline {
+		t.Errorf("event = %+v, want %s", event, webhook.PrinterOffline)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if metrics := string(server.Get("/metrics").Body); !strings.Contains(metrics, `receipt_printer_up{printer="receipt"} 0`) {
+		t.Errorf("metrics of an offline printer:\n%s", metrics)
+	}
+	if jobs := printer.Jobs(); len(jobs) != 0 {
+		t.Errorf("status requests were recorded as %d jobs", len(jobs))
+	}
+}
+
+func TestPrintCancelledWhilePrinterOffline(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	address := net.JoinHostPort(printer.Host(), strconv.Itoa(printer.Port()))
+	printer.Close()
+	s := NewServer(Config{DataDir: t.TempDir()})
+
+	// Retrying an offline printer waits seconds between attempts; a
+	// client that gives up ends the job at once
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := s.sendToPrinter(ctx, address, "test")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled print took %v", elapsed)
+	}
+
+	// So does shutting down
+	s.stop()
+	if err := s.sendToPrinter(s.stopping, address, "test"); !errors.Is(err, context.Canceled) {
+		t.Errorf("after shutdown: err = %v", err)
+	}
+}
+
+func TestPreviewReceipt(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	resp := server.PostJSON("/preview/receipt", sampleReceipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := string(resp.Body)
+	for _, want := range []string{"<html", "TXN-2001", "Bike Rental", "$72.80", "GST (5%)"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+	if len(printer.Jobs()) != 0 {
+		t.Error("preview sent a job to the printer")
+	}
+}
+
+func TestPreviewReceiptAmounts(t *testing.T) {
+	server, _ := startReceiptServer(t)
+
+	receipt := map[string]interface{}{"promoAmount": 5.00, "tip": 2.00, "paymentType": "credit"}
+	for k, v := range sampleReceipt {
+		if _, ok := receipt[k]; !ok {
+			receipt[k] = v
+		}
+	}
+	resp := server.PostJSON("/preview/receipt", receipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := string(resp.Body)
+	for _, want := range []string{"Promo Discount:", "-$5.00", "Tip:", "$2.00"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+	// Cash given on a card payment isn't change owed
+	if strings.Contains(html, "Change:") {
+		t.Error("card receipt shows cash given and change")
+	}
+
+	settlement := map[string]interface{}{
+		"transactionId":        "TXN-2010",
+		"accountId":            "ACC-7",
+		"isSettlement":         true,
+		"settlementAmount":     40.00,
+		"accountBalanceBefore": 40.00,
+		"accountBalanceAfter":  0,
+		"total":                40.00,
+		"paymentType":          "cash",
+		"location":             "Harbour",
+	}
+	resp = server.PostJSON("/preview/receipt", settlement)
+	if resp.StatusCode != 200 || !strings.Contains(string(resp.Body), "Fully Settled") {
+		t.Errorf("settled account is not marked fully settled: status = %d", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptAgentPayload(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	// The agent's payload shape: string quantities and a location object
+	receipt := map[string]interface{}{
+		"items": []map[string]interface{}{
+			{"name": "Bike Rental", "quantity": "2", "price": 30.00},
+			{"name": "Rope", "quantity": "1.5", "price": 4.00},
+		},
+		"subtotal": 66.00,
+		"tax":      0,
+		"total":    66.00,
+		"location": map[string]interface{}{"name": "Harbour", "id": 3},
+	}
+	for k, v := range sampleReceipt {
+		if _, ok := receipt[k]; !ok {
+			receipt[k] = v
+		}
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"Harbour\n", "  2 x ", "  1.5 x ", "6.00"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+
+	resp := server.PostJSON("/preview/receipt", receipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("preview: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html := string(resp.Body); !strings.Contains(html, "<h1>Harbour</h1>") || !strings.Contains(html, "1.5 ×") {
+		t.Errorf("preview is missing the location or quantity:\n%s", html)
+	}
+}
+
+func TestPrintReceiptFieldErrors(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{
+		"items": []map[string]interface{}{
+			{"name": "Kayak", "quantity": -2, "price": 72.80},
+			{"name": "Paddle", "quantity": 1, "price": "abc"},
+		},
+		"copies": "two",
+	}
+	for k, v := range sampleReceipt {
+		if _, ok := receipt[k]; !ok {
+			receipt[k] = v
+		}
+	}
+	for _, path := range []string{"/print/receipt", "/preview/receipt"} {
+		resp := server.PostJSON(path, receipt)
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: status = %d, want 400", path, resp.StatusCode)
+		}
+		var body struct{ Errors []validate.Error }
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		var fields []string
+		for _, e := range body.Errors {
+			fields = append(fields, e.Field)
+		}
+		if got := strings.Join(fields, ","); got != "items[0].quantity,items[1].price,copies" {
+			t.Errorf("%s: invalid fields = %s, body %s", path, got, resp.Body)
+		}
+	}
+	if resp := server.PostJSON("/print/receipt", "{not json"); resp.StatusCode != 400 {
+		t.Errorf("malformed JSON: status = %d, want 400", resp.StatusCode)
+	}
+	if jobs := printer.Jobs(); len(jobs) != 0 {
+		t.Errorf("rejected receipts printed %d jobs", len(jobs))
+	}
+}
+
+func TestPrintReceiptAmountOutOfRange(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	// Amounts that don't fit, or aren't plain decimals, are field errors
+	// rather than wrapping round to some other amount on the receipt
+	receipt := `{"transactionId": "TXN-9", "location": "Main Street",
+		"items": [{"name": "Kayak", "quantity": 1, "price": 1e999999}, {"name": "Paddle", "quantity": 1, "price": "1/3"}],
+		"subtotal": 99999999999999999999999, "tax": -10000000001, "total": 10000000000.00}`
+	resp := server.PostJSON("/print/receipt", receipt)
+	if resp.StatusCode != 400 {
+		t.Fatalf("status = %d, want 400, body %s", resp.StatusCode, resp.Body)
+	}
+	var body struct{ Errors []validate.Error }
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	var fields []string
+	for _, e := range body.Errors {
+		fields = append(fields, e.Field)
+	}
+	if got := strings.Join(fields, ","); got != "items[0].price,items[1].price,subtotal,tax" {
+		t.Errorf("invalid fields = %s, body %s", got, resp.Body)
+	}
+	if jobs := printer.Jobs(); len(jobs) != 0 {
+		t.Errorf("rejected receipt printed %d jobs", len(jobs))
+	}
+}
+
+func TestPrintReceiptSanitized(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{
+		// ESC @ and GS V A would reset the printer and cut the paper mid-receipt
+		"customerName": "Eve\x1b@\x1dVA\x00 Smith",
+		"items":        []map[string]interface{}{{"name": "Kayak\r\n" + strings.Repeat("x", 500), "quantity": 1, "price": 72.80}},
+	}
+	for k, v := range sampleReceipt {
+		if _, ok := receipt[k]; !ok {
+			receipt[k] = v
+		}
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	if !strings.Contains(job, "Eve@VA Smith") {
+		t.Error("customer name was not printed without its control characters")
+	}
+	if strings.Contains(job, "\x1dVA") || strings.Count(job, "\x1b@") != 1 {
+		t.Error("commands in the customer name reached the printer")
+	}
+	if strings.Contains(job, strings.Repeat("x", 120)) {
+		t.Error("item name was not cut to length")
+	}
+
+	ticket := map[string]interface{}{
+		"orderNumber":  "A12",
+		"customerName": "Bob\x1bp\x00\x19\xfa",
+		"items":        []map[string]interface{}{{"name": "Latte", "quantity": 1}},
+	}
+	if resp := server.PostJSON("/print/ticket", ticket); resp.StatusCode != 200 {
+		t.Fatalf("ticket: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if job := string(printer.WaitForJobs(t, 2, 5*time.Second)[1]); strings.Contains(job, "\x1bp") {
+		t.Error("a cash drawer kick in a ticket's customer name reached the printer")
+	}
+
+	for _, logo := range []string{"file:///etc/passwd", "javascript:alert(1)", "//example.com/logo.png"} {
+		receipt["logoUrl"] = logo
+		if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+			t.Errorf("print with logo %q: status = %d, want 400", logo, resp.StatusCode)
+		}
+		if resp := server.PostJSON("/preview/receipt", receipt); resp.StatusCode != 400 {
+			t.Errorf("preview with logo %q: status = %d, want 400", logo, resp.StatusCode)
+		}
+	}
+}
+
+func TestPrintReceiptTaxExemptItems(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-2003",
+		"items": []map[string]interface{}{
+			{"name": "Bike Rental", "quantity": 2, "price": 30.00},
+			{"name": "Bike Deposit", "quantity": 1, "price": 100.00, "taxExempt": true},
+		},
+		"subtotal":    160.00,
+		"tax":         7.20,
+		"total":       167.20,
+		"paymentType": "cash",
+		"location":    "Harbour",
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	// Only the rental is taxed
+	for _, want := range []string{"(Tax exempt)", "GST (5%): $3.00", "PST (7%): $4.20", "Taxable GST+PST:", "$60.00", "Tax exempt:", "$100.00"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed receipt is missing %q", want)
+		}
+	}
+
+	preview := server.PostJSON("/preview/receipt", receipt)
+	for _, want := range []string{"(Tax exempt)", "Taxable GST&#43;PST", "Tax exempt:"} {
+		if !strings.Contains(string(preview.Body), want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+}
+
+func TestPrintReceiptDeposit(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-2004",
+		"items": []map[string]interface{}{
+			{"name": "Bike Rental", "quantity": 2, "price": 30.00, "itemType": "rental"},
+			{"name": "Bike Deposit", "quantity": 1, "price": 100.00, "itemType": "deposit"},
+		},
+		"subtotal":    160.00,
+		"tax":         7.20,
+		"total":       167.20,
+		"paymentType": "cash",
+		"location":    "Harbour",
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	items, held, _ := strings.Cut(job, "HELD")
+	if strings.Contains(items, "Bike Deposit") || !strings.Contains(held, "Bike Deposit") || !strings.Contains(held, "$100.00") {
+		t.Errorf("deposit is not printed under HELD:\n%s", job)
+	}
+	for _, want := range []string{"Rental", "GST (5%): $3.00", "PST (7%): $4.20"} {
+		if !strings.Contains(items, want) {
+			t.Errorf("printed receipt is missing %q", want)
+		}
+	}
+
+	receipt["items"] = []map[string]interface{}{{"name": "Bike", "quantity": 1, "price": 30.00, "itemType": "lease"}}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("unknown item type: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptRentalPeriod(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-2005",
+		"items": []map[string]interface{}{
+			{"name": "Bike Rental", "quantity": 2, "price": 30.00, "itemType": "rental",
+				"startDate": "2026-07-01 09:00", "dueDate": "2026-07-02 09:00", "rateUnit": "daily"},
+		},
+		"subtotal":    60.00,
+		"tax":         7.20,
+		"total":       67.20,
+		"paymentType": "cash",
+		"location":    "Harbour",
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{
+		"PLEASE RETURN BY 2026-07-02 09:00",
+		"2 x $30.00 / day",
+		"Out: 2026-07-01 09:00",
+		"Due back: 2026-07-02 09:00",
+	} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed receipt is missing %q:\n%s", want, job)
+		}
+	}
+
+	preview := server.PostJSON("/preview/receipt", receipt)
+	if preview.StatusCode != 200 || !strings.Contains(string(preview.Body), "PLEASE RETURN BY 2026-07-02 09:00") {
+		t.Errorf("preview is missing the due-back banner: status = %d", preview.StatusCode)
+	}
+
+	receipt["items"] = []map[string]interface{}{{"name": "Bike", "quantity": 1, "price": 30.00, "startDate": "2026-07-02", "dueDate": "2026-07-01"}}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("due before start: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptSplitPayment(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-2006",
+		"items":         []map[string]interface{}{{"name": "Bike Rental", "quantity": 1, "price": 50.00}},
+		"subtotal":      50.00,
+		"tax":           6.00,
+		"total":         56.00,
+		"paymentType":   "split",
+		"payments": []map[string]interface{}{
+			{"type": "account", "amount": 16.00},
+			{"type": "debit", "amount": 40.00, "cardDetails": map[string]string{"cardBrand": "interac", "cardLast4": "9876", "authCode": "Z9Y8"}},
+		},
+		"location": "Harbour",
+	}
+	resp := server.PostJSON("/print/receipt", receipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if w := resp.JSON(t)["warning"]; w != nil {
+		t.Errorf("unexpected totals warning %v", w)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"Account", "$16.00", "Debit", "$40.00", "Interac ****9876", "Z9Y8"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed receipt is missing %q:\n%s", want, job)
+		}
+	}
+
+	preview := server.PostJSON("/preview/receipt", receipt)
+	for _, want := range []string{"Account", "Interac ****9876", "Z9Y8"} {
+		if !strings.Contains(string(preview.Body), want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+
+	receipt["payments"] = []map[string]interface{}{{"amount": 56.00}}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("tender without a type: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintSlip(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	slip := map[string]interface{}{
+		"transactionId": "TXN-2007",
+		"paymentType":   "credit",
+		"amount":        56.00,
+		"cardDetails":   map[string]string{"cardBrand": "visa", "cardLast4": "4242", "authCode": "A1B2C3"},
+		"location":      "Harbour",
+	}
+	resp := server.PostJSON("/print/slip", slip)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if copies := resp.JSON(t)["copies"]; copies != 2.0 {
+		t.Errorf("copies = %v, want 2", copies)
+	}
+	jobs := printer.WaitForJobs(t, 2, 5*time.Second)
+	merchant, customer := string(jobs[0]), string(jobs[1])
+	for _, want := range []string{"MERCHANT COPY", "Visa ****4242", "A1B2C3", "$56.00", "Tip:", "____________", "x____", "Customer signature"} {
+		if !strings.Contains(merchant, want) {
+			t.Errorf("merchant copy is missing %q:\n%s", want, merchant)
+		}
+	}
+	if !strings.Contains(customer, "CUSTOMER COPY") || strings.Contains(customer, "Customer signature") {
+		t.Errorf("customer copy should have no signature line:\n%s", customer)
+	}
+
+	// A tip entered on the terminal is printed instead of the blanks
+	slip["paymentType"] = "debit"
+	slip["tip"] = 8.40
+	if resp := server.PostJSON("/print/slip", slip); resp.StatusCode != 200 {
+		t.Fatalf("debit status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	debit := string(printer.WaitForJobs(t, 4, 5*time.Second)[2])
+	if !strings.Contains(debit, "$8.40") || !strings.Contains(debit, "$64.40") || strings.Contains(debit, "Customer signature") {
+		t.Errorf("debit slip:\n%s", debit)
+	}
+
+	// Cash gets no slip, but the request isn't an error
+	slip["paymentType"] = "cash"
+	resp = server.PostJSON("/print/slip", slip)
+	if resp.StatusCode != 200 || resp.JSON(t)["copies"] != 0.0 {
+		t.Errorf("cash: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	delete(slip, "transactionId")
+	if resp := server.PostJSON("/print/slip", slip); resp.StatusCode != 400 {
+		t.Errorf("missing transaction ID: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptMessages(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		LogLevel:    "INFO",
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		Messages: messages.Config{
+			Header: []messages.Block{{Text: "Wi-Fi password: paddle123"}},
+			Footer: []messages.Block{{Title: "Tell us how we did", QR: "https://survey.example.com/r/2001"}},
+		},
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"Wi-Fi password: paddle123", "Tell us how we did", "\x1d(k", "https://survey.example.com/r/2001"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed receipt is missing %q:\n%q", want, job)
+		}
+	}
+	if strings.Contains(job, "Thank you for your purchase") {
+		t.Error("the configured footer did not replace the thank-you lines")
+	}
+
+	preview := server.PostJSON("/preview/receipt", sampleReceipt)
+	for _, want := range []string{"Wi-Fi password", "data:image/svg"} {
+		if !strings.Contains(string(preview.Body), want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+
+	receipt := map[string]interface{}{"footerMessages": []map[string]string{{"qr": strings.Repeat("x", 300)}}}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("oversized QR: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptMerchantLegalBlock(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		LogLevel:    "INFO",
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		Merchant: merchant.Info{
+			LegalName:     "Harbour Rentals Ltd.",
+			Address:       []string{"12 Wharf Road", "Victoria BC V8W 1A1"},
+			Registrations: []merchant.Registration{{Label: "GST No.", Number: "123456789 RT0001"}},
+			VATFormat:     merchant.Table,
+		},
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"Harbour Rentals Ltd.", "12 Wharf Road", "GST No.: 123456789 RT0001", "Gross", "GST+PST\n", "$65.00", "$7.80", "$72.80"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed receipt is missing %q:\n%q", want, job)
+		}
+	}
+
+	preview := string(server.PostJSON("/preview/receipt", sampleReceipt).Body)
+	for _, want := range []string{"Harbour Rentals Ltd.", "Victoria BC V8W 1A1", "vat-table", "Gross"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+
+	// The default format leaves the tax lines as they are
+	s.config.Merchant.VATFormat = ""
+	preview = string(server.PostJSON("/preview/receipt", sampleReceipt).Body)
+	if !strings.Contains(preview, "123456789 RT0001") || strings.Contains(preview, "<table class=\"vat-table\">") {
+		t.Error("a lines format printed the VAT table or dropped the registration")
+	}
+}
+
+func TestPrintSettlementGLSummary(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	settlement := map[string]interface{}{
+		"transactionId":        "TXN-2050",
+		"items":                []map[string]interface{}{},
+		"subtotal":             0,
+		"tax":                  0,
+		"total":                250.00,
+		"paymentType":          "cheque",
+		"isSettlement":         true,
+		"accountId":            "ACME-7",
+		"accountBalanceBefore": 250.00,
+		"accountBalanceAfter":  0,
+		"settlementAmount":     250.00,
+		"glCodeSummary": []map[string]interface{}{
+			{"glCode": "4100", "description": "Equipment rental", "amount": 200.00},
+			{"glCode": "4200", "description": "Damage waiver", "amount": 50.00},
+		},
+	}
+	if resp := server.PostJSON("/print/receipt", settlement); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"GL Code Summary", "4100 Equipment rental", "$200.00", "4200 Damage waiver", "$50.00", "Subtotal:", "$250.00"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed settlement is missing %q", want)
+		}
+	}
+
+	preview := string(server.PostJSON("/preview/receipt", settlement).Body)
+	for _, want := range []string{"GL Code Summary", "4100 &middot; Equipment rental", "gl-subtotal", "$250.00"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+	if preview := string(server.PostJSON("/preview/receipt", sampleReceipt).Body); strings.Contains(preview, "GL Code Summary") {
+		t.Error("a receipt without GL codes printed the summary")
+	}
+}
+
+func TestPrintCombinedTransactionSections(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	combined := map[string]interface{}{
+		"transactionId":          "TXN-2060",
+		"items":                  []map[string]interface{}{{"name": "Bike Rental", "quantity": 1, "price": 50.00}},
+		"subtotal":               50.00,
+		"tax":                    6.00,
+		"total":                  156.00,
+		"paymentType":            "cash",
+		"hasCombinedTransaction": true,
+		"accountId":              "ACME-7",
+		"accountBalanceBefore":   100.00,
+		"accountBalanceAfter":    0,
+		"settlementAmount":       100.00,
+	}
+	if resp := server.PostJSON("/print/receipt", combined); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	retail, settlement := strings.Index(job, "RETAIL PURCHASE"), strings.Index(job, "ACCOUNT SETTLEMENT")
+	if retail < 0 || settlement < retail {
+		t.Fatalf("printed receipt does not have a retail then a settlement section:\n%q", job)
+	}
+	for _, want := range []string{"Retail Subtotal:", "$56.00", "Payment on Account:", "Settlement Subtotal:", "$100.00", "$156.00"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("printed receipt is missing %q", want)
+		}
+	}
+	if strings.Contains(job[:retail], "Bike Rental") || strings.Contains(job[settlement:], "Bike Rental") {
+		t.Error("the retail items are not in the retail section")
+	}
+
+	preview := string(server.PostJSON("/preview/receipt", combined).Body)
+	for _, want := range []string{"Retail Purchase", "Retail Subtotal", "Settlement Subtotal", "section-subtotal"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+}
+
+func TestPrintPaperWidth(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	ticketPrinter := testharness.NewPrinterEmulator(t)
+	wide, _ := paper.Parse("80mm")
+	narrow, _ := paper.Parse("58mm")
+	s := NewServer(Config{
+		PrinterIP:         printer.Host(),
+		PrinterPort:       printer.Port(),
+		TicketPrinterIP:   ticketPrinter.Host(),
+		TicketPrinterPort: ticketPrinter.Port(),
+		LogLevel:          "INFO",
+		DataDir:           t.TempDir(),
+		Tax:               tax.DefaultConfig(),
+		Currency:          money.DefaultFormat(),
+		Paper:             wide,
+		TicketPaper:       narrow,
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	if !strings.Contains(job, "\n"+strings.Repeat("=", 48)+"\n") {
+		t.Errorf("80mm receipt has no 48-column divider:\n%q", job)
+	}
+	if line := regexp.MustCompile(`Subtotal: +\S+\n`).FindString(job); len(line) != 48+1 {
+		t.Errorf("subtotal is not aligned to 48 columns:\n%q", job)
+	}
+
+	preview := string(server.PostJSON("/preview/receipt", sampleReceipt).Body)
+	for _, want := range []string{"size: 80mm auto", "width: 72mm"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+
+	resp := server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "60",
+		"items":       []map[string]interface{}{{"name": "Paddle Board Rental With Extra Fin", "quantity": 1}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	ticket := string(ticketPrinter.WaitForJobs(t, 1, 5*time.Second)[0])
+	if !strings.Contains(ticket, strings.Repeat("=", 32)+"\n") || strings.Contains(ticket, strings.Repeat("=", 33)) {
+		t.Errorf("58mm ticket has no 32-column divider:\n%q", ticket)
+	}
+	if !strings.Contains(ticket, "1x Paddle Board\nRental With\n") {
+		t.Errorf("large item name is not wrapped to 16 columns:\n%q", ticket)
+	}
+}
+
+func TestPrintReceiptCodePage(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		LogLevel:    "INFO",
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		CodePage:    "cp858",
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	receipt := map[string]interface{}{
+		"customerName": "Côté",
+		"location":     "Łódź “Harbour” Rentals",
+		"paymentType":  "credit",
+		"cardDetails":  map[string]interface{}{"cardBrand": "Visa", "cardLast4": "4242"},
+	}
+	for k, v := range sampleReceipt {
+		if _, ok := receipt[k]; !ok {
+			receipt[k] = v
+		}
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	if !strings.HasPrefix(job, "\x1b@\x1bt\x13") {
+		t.Errorf("receipt does not select cp858 after the reset: %q", job[:min(len(job), 8)])
+	}
+	for _, want := range []string{"C\x93t\x82", "\xa2dz \"Harbour\" Rentals",
+		// The card emoji is dropped and the line stays 32 columns wide
+		"Payment Method:" + strings.Repeat(" ", 11) + "Credit\n",
+	} {
+		if !strings.Contains(job, want) {
+			t.Errorf("receipt is missing %q:\n%q", want, job)
+		}
+	}
+	if strings.Contains(job, "ô") || strings.Contains(job, "\xf0\x9f") {
+		t.Errorf("receipt still has UTF-8 text or emoji:\n%q", job)
+	}
+}
+
+func TestPrintCutModes(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	ticketPrinter := testharness.NewPrinterEmulator(t)
+	fullCut, _ := escpos.ParseCut("full:5")
+	noCut, _ := escpos.ParseCut("none:0")
+	s := NewServer(Config{
+		PrinterIP:         printer.Host(),
+		PrinterPort:       printer.Port(),
+		TicketPrinterIP:   ticketPrinter.Host(),
+		TicketPrinterPort: ticketPrinter.Port(),
+		LogLevel:          "INFO",
+		DataDir:           t.TempDir(),
+		Tax:               tax.DefaultConfig(),
+		Currency:          money.DefaultFormat(),
+		Cut:               fullCut,
+		TicketCut:         noCut,
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0]); !strings.HasSuffix(job, "\x1ba\x00\n\n\n\n\n\x1dVA\x00") {
+		t.Errorf("receipt does not end with 5 feed lines and a full cut: %q", job[max(len(job)-40, 0):])
+	}
+
+	resp := server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "61",
+		"time":        "12:00",
+		"items":       []map[string]interface{}{{"name": "Lemonade"}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if job := string(ticketPrinter.WaitForJobs(t, 1, 5*time.Second)[0]); !strings.HasSuffix(job, "61 - 12:00\n") {
+		t.Errorf("ticket printer without a cutter was sent a feed or cut: %q", job[max(len(job)-40, 0):])
+	}
+
+	if _, err := escpos.ParseCut("guillotine"); err == nil {
+		t.Error("unknown cut mode was accepted")
+	}
+}
+
+func TestPrintBeeps(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	beep, _ := escpos.ParseBeep("2")
+	errorBeep, _ := escpos.ParseBeep("3:200")
+	s := NewServer(Config{
+		PrinterIP:       printer.Host(),
+		PrinterPort:     printer.Port(),
+		StationPrinters: map[string]string{"bar": "127.0.0.1:no-port"},
+		LogLevel:        "INFO",
+		DataDir:         t.TempDir(),
+		Tax:             tax.DefaultConfig(),
+		Currency:        money.DefaultFormat(),
+		Beep:            beep,
+		ErrorBeep:       errorBeep,
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0]); !strings.HasSuffix(job, "\x1dVB\x00\x1bB\x02\x02") {
+		t.Errorf("receipt does not beep twice after the cut: %q", job[max(len(job)-20, 0):])
+	}
+
+	// A ticket that can't print sounds the error beep at the counter
+	resp := server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "62",
+		"station":     "bar",
+		"items":       []map[string]interface{}{{"name": "Lemonade"}},
+	})
+	if resp.StatusCode != 500 {
+		t.Fatalf("status = %d, want 500", resp.StatusCode)
+	}
+	if job := string(printer.WaitForJobs(t, 2, 5*time.Second)[1]); job != "\x1bB\x03\x04" {
+		t.Errorf("error beep = %q", job)
+	}
+
+	if _, err := escpos.ParseBeep("12"); err == nil {
+		t.Error("12 beeps were accepted")
+	}
+}
+
+func TestPrinterSelfTest(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	resp := server.Do("POST", "/printers/receipt/selftest", nil)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	page := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{
+		"\x1b@\x1bt\x00", // Reset and code page
+		"SELF-TEST",
+		"Code page: cp437",
+		"12345678901234567890123456789012\n",
+		"8x  \x80\x81\x82",              // Upper half of the code page, as is
+		"\x1dk\x49\x10{BRT-SELFTEST-01", // CODE128
+		"\x1d(k",                        // QR code
+		"\x1dv0",                        // Raster test pattern
+		"\x1bp\x00\x19\xfa",             // Drawer kick
+		"\x1dVB",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("self-test page is missing %q", want)
+		}
+	}
+
+	server.Do("POST", "/printers/receipt/selftest?drawer=false", nil)
+	if page := string(printer.WaitForJobs(t, 2, 5*time.Second)[1]); strings.Contains(page, "\x1bp") {
+		t.Error("drawer=false still kicked the drawer")
+	}
+
+	if resp := server.Do("POST", "/printers/office/selftest", nil); resp.StatusCode != 404 {
+		t.Errorf("unknown printer: status = %d, want 404", resp.StatusCode)
+	}
+	if resp := server.Get("/printers/receipt/selftest"); resp.StatusCode != 405 {
+		t.Errorf("GET: status = %d, want 405", resp.StatusCode)
+	}
+}
+
+func TestTimeclockPunch(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	in := server.PostJSON("/timeclock/punch", map[string]string{
+		"employeeId": "E42", "employeeName": "Sam Lee", "action": "in",
+	})
+	if in.StatusCode != 200 {
+		t.Fatalf("clock-in status = %d, body %s", in.StatusCode, in.Body)
+	}
+	if body := in.JSON(t); body["printed"] != true {
+		t.Errorf("clock-in slip not printed: %s", in.Body)
+	}
+
+	again := server.PostJSON("/timeclock/punch", map[string]string{"employeeId": "E42", "action": "in"})
+	if again.StatusCode != 409 {
+		t.Errorf("double clock-in status = %d, want 409", again.StatusCode)
+	}
+
+	out := server.PostJSON("/timeclock/punch", map[string]string{"employeeId": "E42", "action": "out"})
+	if out.StatusCode != 200 {
+		t.Fatalf("clock-out status = %d, body %s", out.StatusCode, out.Body)
+	}
+
+	// The clock-out slip carries the name forward from the clock-in
+	for _, slip := range printer.WaitForJobs(t, 2, 5*time.Second) {
+		if !bytes.Contains(slip, []byte("Sam Lee")) {
+			t.Errorf("slip is missing the employee name: %q", slip)
+		}
+	}
+
+	export := server.Get("/timeclock/export?employeeId=E42")
+	if export.StatusCode != 200 {
+		t.Fatalf("export status = %d", export.StatusCode)
+	}
+	lines := strings.Split(strings.TrimSpace(string(export.Body)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("export has %d lines, want header + 2 punches:\n%s", len(lines), export.Body)
+	}
+}
+
+func TestPrintReceiptInRequestedLanguage(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{"language": "fr-CA"}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	// Printed in the default code page, cp437, where à is 0x85
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"ARTICLES", "Sous-total:", "Taxes:", "Merci de votre achat!", "Au plaisir de vous revoir \x85 Harbour"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("French receipt is missing %q", want)
+		}
+	}
+	if strings.Contains(job, "Subtotal") {
+		t.Error("French receipt still has English labels")
+	}
+
+	preview := server.PostJSON("/preview/receipt", receipt)
+	if !strings.Contains(string(preview.Body), `lang="fr"`) || !strings.Contains(string(preview.Body), "Sous-total") {
+		t.Error("French preview is not translated")
+	}
+}
+
+func TestPrintReceiptServedBy(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{"operatorName": "Alice", "stationId": "Till 2"}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	preview := string(server.PostJSON("/preview/receipt", receipt).Body)
+	for _, want := range []string{"Served by: Alice", "Station: Till 2"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+		if !strings.Contains(preview, want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+
+	// Without them, neither line is printed
+	if preview := string(server.PostJSON("/preview/receipt", sampleReceipt).Body); strings.Contains(preview, "Served by") {
+		t.Error("preview has a Served by line for a receipt without an operator")
+	}
+}
+
+func TestPrintReceiptTotalsMismatch(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := map[string]interface{}{"total": 80.00}
+	for k, v := range sampleReceipt {
+		if _, ok := receipt[k]; !ok {
+			receipt[k] = v
+		}
+	}
+	resp := server.PostJSON("/print/receipt", receipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	warning, _ := resp.JSON(t)["warning"].(string)
+	if !strings.Contains(warning, "off by 7.20") {
+		t.Errorf("warning = %q", warning)
+	}
+	// The receipt still prints
+	printer.WaitForJobs(t, 1, 5*time.Second)
+
+	// A cent of rounding is tolerated
+	receipt["total"] = 72.81
+	if w := server.PostJSON("/print/receipt", receipt).JSON(t)["warning"]; w != nil {
+		t.Errorf("rounding difference flagged: %q", w)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	server, printer := startReceiptServer(t)
+	post := func(path string, body interface{}, operator string) {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", server.URL()+path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if operator != "" {
+			req.Header.Set("X-Operator-Id", operator)
+		}
+		if resp := server.Send(req); resp.StatusCode != 200 {
+			t.Fatalf("%s: status = %d, body %s", path, resp.StatusCode, resp.Body)
+		}
+	}
+
+	post("/print/receipt", sampleReceipt, "emp-17")
+	post("/print/receipt", map[string]interface{}{"type": "noSale", "paymentType": "cash"}, "emp-17")
+	refund := map[string]interface{}{}
+	for k, v := range sampleReceipt {
+		refund[k] = v
+	}
+	refund["type"], refund["refundAmount"], refund["refundMethod"] = "refund", 12.50, "cash"
+	post("/print/receipt", refund, "emp-22")
+	report := map[string]interface{}{"type": "z", "shiftId": "2025-06-01-AM", "cashier": "Sam Lee"}
+	post("/print/report", report, "")
+	post("/print/report", report, "")
+	post("/printers/receipt/selftest", nil, "tech-1")
+	post("/printers/receipt/selftest?drawer=false", nil, "tech-1")
+	printer.WaitForJobs(t, 7, 5*time.Second)
+
+	// The sale, the first Z report and the self-test without the drawer
+	// aren't audited
+	var trail AuditResponse
+	if err := json.Unmarshal([]byte(server.Get("/audit").Body), &trail); err != nil {
+		t.Fatal(err)
+	}
+	if !trail.Valid || len(trail.Events) != 4 {
+		t.Fatalf("audit = %+v", trail)
+	}
+	want := []struct{ action, operator string }{
+		{audit.NoSale, "emp-17"},
+		{audit.Refund, "emp-22"},
+		{audit.Reprint, "Sam Lee"}, // The cashier when no operator is named
+		{audit.DrawerOpen, "tech-1"},
+	}
+	for i, w := range want {
+		if e := trail.Events[i]; e.Action != w.action || e.Operator != w.operator || e.Seq != i+1 {
+			t.Errorf("event %d = %+v, want %s by %s", i+1, e, w.action, w.operator)
+		}
+	}
+	if refunded := trail.Events[1]; refunded.Amount != 1250 || refunded.TransactionID != "TXN-2001" {
+		t.Errorf("refund = %+v", refunded)
+	}
+}
+
+func TestAuditLogRecovery(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	dataDir := t.TempDir()
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		LogLevel:    "INFO",
+		DataDir:     dataDir,
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+	noSale := map[string]interface{}{"type": "noSale", "paymentType": "cash"}
+	auditPath := filepath.Join(dataDir, "audit", "receipt-server.jsonl")
+
+	// While the log can't be opened, the drawer stays shut
+	os.MkdirAll(auditPath, 0755)
+	if resp := server.PostJSON("/print/receipt", noSale); resp.StatusCode != 503 {
+		t.Fatalf("unaudited no-sale: status = %d, want 503", resp.StatusCode)
+	}
+	if resp := server.Do("POST", "/printers/receipt/selftest", nil); resp.StatusCode != 503 {
+		t.Errorf("unaudited self-test drawer kick: status = %d, want 503", resp.StatusCode)
+	}
+	if jobs := printer.Jobs(); len(jobs) != 0 {
+		t.Fatalf("printed %d jobs without auditing them", len(jobs))
+	}
+
+	// An event cut short by a power cut is moved aside, and the log,
+	// opened again on the next event, keeps its chain
+	os.Remove(auditPath)
+	before, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before.Append(audit.Event{Action: audit.NoSale, Operator: "emp-17"})
+	f, _ := os.OpenFile(auditPath, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("{\"seq\":2,\"action\":\"no_sa")
+	f.Close()
+	if resp := server.PostJSON("/print/receipt", noSale); resp.StatusCode != 200 {
+		t.Fatalf("no-sale after a torn write: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	var trail AuditResponse
+	if err := json.Unmarshal([]byte(server.Get("/audit").Body), &trail); err != nil {
+		t.Fatal(err)
+	}
+	if !trail.Valid || len(trail.Events) != 3 || trail.Events[1].Action != audit.ChainBreak || trail.Events[2].Action != audit.NoSale || trail.Events[2].Seq != 3 {
+		t.Fatalf("audit after recovery = %+v", trail)
+	}
+	if torn, _ := os.ReadFile(auditPath + ".torn"); string(torn) != "{\"seq\":2,\"action\":\"no_sa\n" {
+		t.Errorf("partial event kept as %q", torn)
+	}
+}
+
+func TestPrintTicketToStationPrinter(t *testing.T) {
+	receiptPrinter := testharness.NewPrinterEmulator(t)
+	ticketPrinter := testharness.NewPrinterEmulator(t)
+	barPrinter := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:         receiptPrinter.Host(),
+		PrinterPort:       receiptPrinter.Port(),
+		TicketPrinterIP:   ticketPrinter.Host(),
+		TicketPrinterPort: ticketPrinter.Port(),
+		StationPrinters:   map[string]string{"bar": fmt.Sprintf("%s:%d", barPrinter.Host(), barPrinter.Port())},
+		LogLevel:          "INFO",
+		DataDir:           t.TempDir(),
+		Tax:               tax.DefaultConfig(),
+		Currency:          money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	resp := server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "57",
+		"station":     "Prep",
+		"items": []map[string]interface{}{
+			{"name": "Bike Tune-Up", "quantity": 2, "modifiers": []string{"Check brakes"}, "notes": "Customer waiting"},
+		},
+		"notes": "Ready by 3pm",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(ticketPrinter.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"PREP", "#57", "\x1d!\x11", "2x Bike Tune-Up", "+ Check brakes", "** Customer waiting", "NOTES:", "Ready by 3pm", "\x1dV"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("ticket is missing %q", want)
+		}
+	}
+
+	// Stations with their own printer bypass the ticket printer
+	resp = server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "58",
+		"station":     "BAR",
+		"items":       []map[string]interface{}{{"name": "Lemonade"}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if job := string(barPrinter.WaitForJobs(t, 1, 5*time.Second)[0]); !strings.Contains(job, "1x Lemonade") {
+		t.Errorf("bar ticket = %q", job)
+	}
+
+	if n := len(ticketPrinter.Jobs()); n != 1 {
+		t.Errorf("ticket printer received %d jobs, want 1", n)
+	}
+	if n := len(receiptPrinter.Jobs()); n != 0 {
+		t.Errorf("receipt printer received %d tickets", n)
+	}
+
+	if resp := server.PostJSON("/print/ticket", map[string]interface{}{"orderNumber": "59"}); resp.StatusCode != 400 {
+		t.Errorf("ticket without items: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+// usbPrinterFake stands in for the USB printer class driver's devices
+type usbPrinterFake struct {
+	mu       sync.Mutex
+	attached map[string]*bytes.Buffer
+}
+
+func (f *usbPrinterFake) open(device string) (io.WriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if buf, ok := f.attached[device]; ok {
+		return nopCloser{buf}, nil
+	}
+	return nil, fmt.Errorf("no USB printer matches %q", device)
+}
+
+func (f *usbPrinterFake) data(device string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.attached[device].String()
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func TestPrintOverUSB(t *testing.T) {
+	usb := &usbPrinterFake{attached: map[string]*bytes.Buffer{"lp0": {}, "lp1": {}}}
+	saved := openUSBPrinter
+	t.Cleanup(func() { openUSBPrinter = saved })
+	openUSBPrinter = usb.open
+
+	network := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:         "unreachable.invalid",
+		PrinterPort:       9100,
+		TicketPrinterIP:   network.Host(),
+		TicketPrinterPort: network.Port(),
+		Printers: map[string]PrinterTransport{
+			"receipt": {Transport: "usb", Device: "lp0"},
+			"bar":     {Transport: "usb", Device: "lp1"},
+		},
+		DataDir:  t.TempDir(),
+		Tax:      tax.DefaultConfig(),
+		Currency: money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("receipt: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if data := usb.data("lp0"); !strings.Contains(data, "TXN-2001") || !strings.HasPrefix(data, "\x1b@") {
+		t.Errorf("USB receipt printer received %q", data)
+	}
+
+	// A station's own USB printer bypasses the network ticket printer
+	resp := server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "58",
+		"station":     "Bar",
+		"items":       []map[string]interface{}{{"name": "Lemonade"}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("ticket: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if data := usb.data("lp1"); !strings.Contains(data, "1x Lemonade") {
+		t.Errorf("USB bar printer received %q", data)
+	}
+	if n := len(network.Jobs()); n != 0 {
+		t.Errorf("network ticket printer received %d jobs", n)
+	}
+
+	var health HealthResponse
+	json.Unmarshal(server.Get("/health").Body, &health)
+	if health.Status != "online" || health.Printer != "usb://lp0" {
+		t.Errorf("health = %+v, want the USB printer online", health)
+	}
+	usb.mu.Lock()
+	delete(usb.attached, "lp0")
+	usb.mu.Unlock()
+	json.Unmarshal(server.Get("/health").Body, &health)
+	if health.Status != "offline" {
+		t.Errorf("unplugged USB printer: status = %q, want offline", health.Status)
+	}
+}
+
+func TestPrintOverSerial(t *testing.T) {
+	port := testharness.NewMockSerial("")
+	saved := serialPorts
+	t.Cleanup(func() { serialPorts = saved })
+	serialPorts = port
+
+	_, printer, err := parseSerialPrinter("receipt=COM3:19200:xonxoff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(Config{
+		PrinterIP:   "unreachable.invalid",
+		PrinterPort: 9100,
+		Printers:    map[string]PrinterTransport{"receipt": printer},
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	// The printer sends XOFF as soon as it's initialised; nothing more may
+	// be written until it sends XON
+	port.Answer("\x1b@", "\x13")
+	held := make(chan int, 1)
+	go func() {
+		for len(port.Written()) == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		time.Sleep(200 * time.Millisecond)
+		held <- len(port.Written())
+		port.Send([]byte{0x11})
+	}()
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("receipt: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if n := <-held; n != 1 {
+		t.Errorf("%d writes before XON, want 1", n)
+	}
+	data := string(bytes.Join(port.Written(), nil))
+	if !strings.HasPrefix(data, "\x1b@") || !strings.Contains(data, "TXN-2001") {
+		t.Errorf("serial printer received %q", data)
+	}
+	if opened := port.Opened(); len(opened) == 0 || opened[0] != "COM3" {
+		t.Errorf("opened %v, want COM3", opened)
+	}
+	if mode := port.Modes()[0]; mode.BaudRate != 19200 || mode.DataBits != 8 {
+		t.Errorf("mode = %+v, want 19200 8N1", mode)
+	}
+
+	var health HealthResponse
+	json.Unmarshal(server.Get("/health").Body, &health)
+	if health.Status != "online" || health.Printer != "serial://COM3" {
+		t.Errorf("health = %+v, want the serial printer online", health)
+	}
+
+	for _, value := range []string{"receipt=", "receipt=COM3:fast", "receipt=COM3:9600:dtrdsr"} {
+		if _, _, err := parseSerialPrinter(value); err == nil {
+			t.Errorf("parseSerialPrinter(%q) succeeded", value)
+		}
+	}
+}
+
+func TestPrintStarPRNT(t *testing.T) {
+	star := testharness.NewPrinterEmulator(t)
+	epson := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:         star.Host(),
+		PrinterPort:       star.Port(),
+		TicketPrinterIP:   epson.Host(),
+		TicketPrinterPort: epson.Port(),
+		PrinterModels:     map[string]string{"receipt": "tsp143"},
+		DataDir:           t.TempDir(),
+		Tax:               tax.DefaultConfig(),
+		Currency:          money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	if resp := server.PostJSON("/print/receipt", sampleReceipt); resp.StatusCode != 200 {
+		t.Fatalf("receipt: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	receipt := string(star.WaitForJobs(t, 1, 5*time.Second)[0])
+	if !strings.HasPrefix(receipt, "\x1b@\x1b\x1dt\x01") {
+		t.Errorf("receipt starts %q, want ESC @ and the StarPRNT code page", receipt[:min(len(receipt), 8)])
+	}
+	for _, command := range []string{"\x1b\x1da\x01", "\x1bE", "\x1bF", "\x1bd\x03"} {
+		if !strings.Contains(receipt, command) {
+			t.Errorf("receipt lacks StarPRNT command %q", command)
+		}
+	}
+	for _, command := range []string{"\x1ba\x01", "\x1dVB\x00"} {
+		if strings.Contains(receipt, command) {
+			t.Errorf("receipt contains ESC/POS command %q", command)
+		}
+	}
+
+	// The ticket printer has no model, so it still gets ESC/POS
+	resp := server.PostJSON("/print/ticket", map[string]interface{}{
+		"orderNumber": "58",
+		"items":       []map[string]interface{}{{"name": "Lemonade"}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("ticket: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if ticket := string(epson.WaitForJobs(t, 1, 5*time.Second)[0]); !strings.Contains(ticket, "\x1ba\x01") || !strings.Contains(ticket, "\x1dVB\x00") {
+		t.Errorf("ticket is not ESC/POS: %q", ticket)
+	}
+
+	if _, err := lookupCommandSet("tsp999"); err == nil {
+		t.Error("lookupCommandSet accepted an unknown model")
+	}
+	if commands, err := lookupCommandSet("StarPRNT"); err != nil || commands.Name() != "StarPRNT" {
+		t.Errorf("lookupCommandSet(StarPRNT) = %v, %v", commands, err)
+	}
+}
+
+func TestPrintZReport(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	report := map[string]interface{}{
+		"shiftId":  "2025-06-01-AM",
+		"register": "Front",
+		"cashier":  "Sam Lee",
+		"sales": []map[string]interface{}{
+			{"type": "cash", "count": 3, "amount": 120.50},
+			{"type": "credit", "count": 5, "amount": 310.00},
+		},
+		"taxes":       []map[string]interface{}{{"name": "GST (5%)", "amount": 20.52}, {"name": "PST (7%)", "amount": 28.73}},
+		"refundCount": 1,
+		"refunds":     15.00,
+		"drawer":      map[string]interface{}{"openingFloat": 200.00, "cashRefunds": 15.00, "counted": 300.00},
+	}
+	resp := server.PostJSON("/print/report", report)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if body := resp.JSON(t); body["reportNumber"] != 1.0 || body["reprint"] != nil {
+		t.Errorf("first report: %s", resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	for _, want := range []string{"Z REPORT #1", "Cash (3):", "$120.50", "$430.50", "Net sales:", "$415.50", "Total tax:", "$49.25", "$305.50", "SHORT:", "-$5.50"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("report is missing %q", want)
+		}
+	}
+
+	// Reprinting the shift keeps its number; the next shift gets the next one
+	resp = server.PostJSON("/print/report", report)
+	if body := resp.JSON(t); body["reportNumber"] != 1.0 || body["reprint"] != true {
+		t.Errorf("reprint: %s", resp.Body)
+	}
+	if job := string(printer.WaitForJobs(t, 2, 5*time.Second)[1]); !strings.Contains(job, "REPRINT") {
+		t.Error("reprinted report is not marked as a reprint")
+	}
+	report["shiftId"] = "2025-06-01-PM"
+	if body := server.PostJSON("/print/report", report).JSON(t); body["reportNumber"] != 2.0 {
+		t.Errorf("next shift report number = %v, want 2", body["reportNumber"])
+	}
+
+	// X reports are unnumbered readings
+	xReport := server.PostJSON("/print/report", map[string]interface{}{"type": "x", "sales": report["sales"]})
+	if body := xReport.JSON(t); xReport.StatusCode != 200 || body["reportNumber"] != nil {
+		t.Errorf("X report: status %d, body %s", xReport.StatusCode, xReport.Body)
+	}
+	if resp := server.PostJSON("/print/report", map[string]interface{}{"type": "z"}); resp.StatusCode != 400 {
+		t.Errorf("Z report without shift: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintZReportSanitized(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	// A drawer kick (ESC p) hidden in the cashier's name doesn't reach the
+	// printer as a command
+	report := map[string]interface{}{
+		"shiftId":  "2025-06-02\x1b@AM",
+		"register": "Front\r\nBack",
+		"cashier":  "Sam\x1bp\x00\x19\xfaLee",
+		"openedAt": "08:00\x1dVA\x00",
+		"sales":    []map[string]interface{}{{"type": "cash\x1bp", "count": 1, "amount": 10.00}},
+		"taxes":    []map[string]interface{}{{"name": "GST\x1bp\x00", "amount": 0.50}},
+		"drawer":   map[string]interface{}{"openingFloat": 100.00, "denominations": []map[string]interface{}{{"label": "$10\x1bp", "count": 11, "value": 10.00}}},
+	}
+	if resp := server.PostJSON("/print/report", report); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := string(printer.WaitForJobs(t, 1, 5*time.Second)[0])
+	if strings.Contains(job, "\x1bp") || strings.Contains(job, "\x1dVA\x00\n") || strings.Count(job, "\x1b@") != 1 {
+		t.Errorf("control bytes reached the printer: %q", job)
+	}
+	for _, want := range []string{"Samp?Lee", "Front  Back", "GSTp:"} {
+		if !strings.Contains(job, want) {
+			t.Errorf("report is missing %q", want)
+		}
+	}
+}
+
+func TestPrintReceiptWithLogo(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	// A 100x40 logo, black on the left half and transparent on the right
+	logo := image.NewNRGBA(image.Rect(0, 0, 100, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 50; x++ {
+			logo.Set(x, y, color.Black)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatal(err)
+	}
+	var downloads atomic.Int32
+	logoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer logoServer.Close()
+
+	receipt := map[string]interface{}{"logoUrl": logoServer.URL + "/logo.png"}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	for i := 0; i < 2; i++ {
+		if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+
+	jobs := printer.WaitForJobs(t, 2, 5*time.Second)
+	// GS v 0, normal density, 13 bytes (100 dots) by 40 rows
+	header := []byte{0x1D, 'v', '0', 0, 13, 0, 40, 0}
+	start := bytes.Index(jobs[0], header)
+	if start < 0 {
+		t.Fatal("receipt has no raster logo")
+	}
+	row := jobs[0][start+len(header) : start+len(header)+13]
+	if !bytes.Equal(row, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xc0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("first logo row = % x", row)
+	}
+	if !bytes.Contains(jobs[1], header) {
+		t.Error("second receipt has no logo")
+	}
+	if n := downloads.Load(); n != 1 {
+		t.Errorf("logo downloaded %d times, want once", n)
+	}
+
+	// An unreachable logo doesn't stop the receipt
+	receipt["logoUrl"] = logoServer.URL + "/missing"
+	logoServer.Close()
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("unreachable logo: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestLocalAssetLogo(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	assets := t.TempDir()
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		DataDir:     t.TempDir(),
+		AssetsDir:   assets,
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	logo := image.NewNRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		logo.Set(x, 0, color.Black)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(assets, "storelogo.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := server.Get("/assets/storelogo.png")
+	if resp.StatusCode != 200 || !bytes.Equal(resp.Body, buf.Bytes()) {
+		t.Fatalf("asset: status = %d, %d bytes", resp.StatusCode, len(resp.Body))
+	}
+	for _, path := range []string{"/assets/missing.png", "/assets/", "/assets/..%2Fsecret"} {
+		if resp := server.Get(path); resp.StatusCode != 404 {
+			t.Errorf("%s: status = %d, want 404", path, resp.StatusCode)
+		}
+	}
+
+	receipt := map[string]interface{}{"logoUrl": "local:storelogo.png"}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	resp = server.PostJSON("/preview/receipt", receipt)
+	if resp.StatusCode != 200 || !strings.Contains(string(resp.Body), `src="/assets/storelogo.png"`) {
+		t.Errorf("preview does not load the logo from /assets/: status = %d", resp.StatusCode)
+	}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	// GS v 0, normal density, 2 bytes (16 dots) by 8 rows
+	if job := printer.WaitForJobs(t, 1, 5*time.Second)[0]; !bytes.Contains(job, []byte{0x1D, 'v', '0', 0, 2, 0, 8, 0, 0xff, 0xff}) {
+		t.Error("receipt has no raster logo from the assets directory")
+	}
+
+	for _, logo := range []string{"local:../secret.png", "local:/etc/logo.png", `local:a\b.png`, "local:"} {
+		receipt["logoUrl"] = logo
+		if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+			t.Errorf("print with logo %q: status = %d, want 400", logo, resp.StatusCode)
+		}
+	}
+}
+
+func TestLogoSizeLimits(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	encode := func(img image.Image) []byte {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	// A few hundred bytes declaring 100000x100000 pixels: the IHDR chunk
+	// of a 1x1 PNG with its dimensions and checksum rewritten
+	bomb := encode(image.NewGray(image.Rect(0, 0, 1, 1)))
+	binary.BigEndian.PutUint32(bomb[16:], 100000)
+	binary.BigEndian.PutUint32(bomb[20:], 100000)
+	binary.BigEndian.PutUint32(bomb[29:], crc32.ChecksumIEEE(bomb[12:29]))
+	logos := map[string][]byte{
+		"/bomb.png": bomb,
+		"/tall.png": encode(image.NewGray(image.Rect(0, 0, 1, 5000))),
+	}
+	logoServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(logos[r.URL.Path])
+	}))
+	defer logoServer.Close()
+
+	receipt := map[string]interface{}{"logoUrl": logoServer.URL + "/bomb.png"}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	// An oversized logo is refused before it is decoded, and the receipt
+	// prints without it
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if job := printer.WaitForJobs(t, 1, 5*time.Second)[0]; bytes.Contains(job, []byte{0x1D, 'v', '0'}) {
+		t.Error("receipt printed the oversized logo")
+	}
+
+	// A very tall logo is scaled down to 2048 rows: eight 256-row bands
+	receipt["logoUrl"] = logoServer.URL + "/tall.png"
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := printer.WaitForJobs(t, 2, 5*time.Second)[1]
+	if bands := bytes.Count(job, []byte{0x1D, 'v', '0'}); bands != 8 || bytes.Count(job, []byte{0x1D, 'v', '0', 0, 1, 0, 0, 1}) != 8 {
+		t.Errorf("logo printed in %d bands, want 8 of 256 rows", bands)
+	}
+}
+
+func TestPrintLabels(t *testing.T) {
+	labelPrinter := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:        "127.0.0.1",
+		PrinterPort:      9,
+		LabelPrinterIP:   labelPrinter.Host(),
+		LabelPrinterPort: labelPrinter.Port(),
+		LabelFormat:      "zpl",
+		LogLevel:         "INFO",
+		DataDir:          t.TempDir(),
+		Tax:              tax.DefaultConfig(),
+		Currency:         money.DefaultFormat(),
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	resp := server.PostJSON("/print/label", map[string]interface{}{
+		"layout": "sku",
+		"copies": 2,
+		"labels": []map[string]interface{}{
+			{"name": "Helmet^Large", "sku": "HLM-001", "price": 12.5},
+			{"name": "Lock", "sku": "LCK-002", "price": 5},
+		},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	zpl := string(labelPrinter.WaitForJobs(t, 1, 5*time.Second)[0])
+	if strings.Count(zpl, "^XA") != 2 || strings.Count(zpl, "^PQ2") != 2 {
+		t.Errorf("want two labels with two copies each:\n%s", zpl)
+	}
+	for _, want := range []string{"^FDHelmet_5ELarge^FS", "^BCN,70,Y,N,N^FH_^FDHLM-001^FS", "^FD$12.50^FS", "^FDLCK-002^FS"} {
+		if !strings.Contains(zpl, want) {
+			t.Errorf("ZPL is missing %q:\n%s", want, zpl)
+		}
+	}
+
+	// EPL asset tags leave out optional lines that have no data
+	resp = server.PostJSON("/print/label", map[string]interface{}{
+		"layout": "asset",
+		"format": "epl",
+		"labels": []map[string]interface{}{{"name": "Trek FX 2", "assetTag": "RT-00042"}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	epl := string(labelPrinter.WaitForJobs(t, 2, 5*time.Second)[1])
+	for _, want := range []string{"\nN\n", `B20,38,0,1,2,4,90,B,"RT-00042"`, `"Trek FX 2"`, "P1\n"} {
+		if !strings.Contains(epl, want) {
+			t.Errorf("EPL is missing %q:\n%s", want, epl)
+		}
+	}
+	if strings.Contains(epl, "PROPERTY OF") || strings.Contains(epl, "S/N") {
+		t.Errorf("EPL has empty optional lines:\n%s", epl)
+	}
+
+	// Line breaks in a field can't start commands of their own
+	for i, format := range []string{"epl", "zpl"} {
+		resp = server.PostJSON("/print/label", map[string]interface{}{
+			"layout": "asset",
+			"format": format,
+			"labels": []map[string]interface{}{{"name": "Trek\"\r\nP9999\n^XA^PQ9999\x1b", "assetTag": "RT-00043"}},
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: status = %d, body %s", format, resp.StatusCode, resp.Body)
+		}
+		job := string(labelPrinter.WaitForJobs(t, 3+i, 5*time.Second)[2+i])
+		if strings.Contains(job, "\nP9999") || strings.Contains(job, "\n^XA^PQ9999") || strings.Contains(job, "\x1b") {
+			t.Errorf("%s: a field injected commands:\n%q", format, job)
+		}
+	}
+
+	if resp := server.PostJSON("/print/label", map[string]interface{}{"layout": "shelf", "labels": []map[string]string{{"sku": "X"}}}); resp.StatusCode != 400 {
+		t.Errorf("unknown layout: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintCopiesLimit(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	receipt := make(map[string]interface{})
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	for path, request := range map[string]map[string]interface{}{
+		"/print/ticket":  {"orderNumber": "60", "items": []map[string]interface{}{{"name": "Lemonade"}}},
+		"/print/label":   {"layout": "sku", "labels": []map[string]interface{}{{"name": "Lock", "sku": "LCK-002"}}},
+		"/print/receipt": receipt,
+	} {
+		request["copies"] = 1000000000
+		resp := server.PostJSON(path, request)
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: status = %d, want 400", path, resp.StatusCode)
+		}
+		var body struct{ Errors []validate.Error }
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Errors) != 1 || body.Errors[0].Field != "copies" {
+			t.Errorf("%s: errors = %+v", path, body.Errors)
+		}
+	}
+	if n := len(printer.Jobs()); n != 0 {
+		t.Errorf("printer received %d jobs", n)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	// Over the 512 KB default, and still valid JSON
+	huge := map[string]interface{}{"transactionId": strings.Repeat("x", 600<<10)}
+	for _, path := range []string{"/print/receipt", "/preview/receipt", "/print/ticket", "/print/report", "/print/label", "/print/slip", "/timeclock/punch"} {
+		if resp := server.PostJSON(path, huge); resp.StatusCode != 413 {
+			t.Errorf("%s: status = %d, want 413; body %s", path, resp.StatusCode, resp.Body)
+		}
+	}
+	if n := len(printer.Jobs()); n != 0 {
+		t.Errorf("printer received %d jobs", n)
+	}
+}
+
+func TestPrintRateLimit(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:      printer.Host(),
+		PrinterPort:    printer.Port(),
+		DataDir:        t.TempDir(),
+		Tax:            tax.DefaultConfig(),
+		Currency:       money.DefaultFormat(),
+		PrintRateLimit: 30,
+		PrintBurst:     3,
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	// A till stuck in a loop is cut off once its burst is used up, across
+	// every route that prints
+	ticket := map[string]interface{}{"orderNumber": "61", "items": []map[string]interface{}{{"name": "Lemonade"}}}
+	for i, path := range []string{"/v1/print/ticket", "/print/ticket", "/v1/print/ticket"} {
+		if resp := server.PostJSON(path, ticket); resp.StatusCode != 200 {
+			t.Fatalf("request %d: status = %d, body %s", i+1, resp.StatusCode, resp.Body)
+		}
+	}
+	resp := server.PostJSON("/v1/print/label", map[string]interface{}{"layout": "sku"})
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("over the limit: status = %d, Retry-After = %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	// Status checks aren't limited
+	if resp := server.Get("/health"); resp.StatusCode == 429 {
+		t.Error("/health was rate limited")
+	}
+	if n := len(printer.Jobs()); n != 3 {
+		t.Errorf("printer received %d jobs, want 3", n)
+	}
+}
+
+func TestReceiptNumbers(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	dataDir := t.TempDir()
+	s := NewServer(Config{
+		PrinterIP:      printer.Host(),
+		PrinterPort:    printer.Port(),
+		DataDir:        dataDir,
+		Tax:            tax.DefaultConfig(),
+		Currency:       money.DefaultFormat(),
+		ReceiptNumbers: true,
+	})
+	server := testharness.Start(t, s.setupRoutes())
+	printAs := func(txID, station string) (int, PrintResponse) {
+		t.Helper()
+		receipt := map[string]interface{}{"transactionId": txID, "stationId": station}
+		for k, v := range sampleReceipt {
+			if k != "transactionId" {
+				receipt[k] = v
+			}
+		}
+		resp := server.PostJSON("/print/receipt", receipt)
+		var body PrintResponse
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, body
+	}
+
+	// Each station counts on its own
+	for i, want := range []struct {
+		station string
+		number  int64
+	}{{"", 1}, {"Till 2", 1}, {"", 2}} {
+		status, body := printAs(fmt.Sprintf("TXN-40%d", i), want.station)
+		if status != 200 || body.ReceiptNumber != want.number {
+			t.Errorf("receipt %d: status %d, number %d, want %d", i+1, status, body.ReceiptNumber, want.number)
+		}
+	}
+	jobs := printer.WaitForJobs(t, 3, 2*time.Second)
+	if !bytes.Contains(jobs[2], []byte("Receipt No.: 000002")) {
+		t.Errorf("receipt number not printed:\n%q", jobs[2])
+	}
+
+	// A receipt spooled while the printer is offline prints later with
+	// its number, so the number is kept
+	printer.Close()
+	if status, body := printAs("TXN-403", ""); status != 202 || body.ReceiptNumber != 3 {
+		t.Errorf("spooled receipt: status %d, number %d, want 3", status, body.ReceiptNumber)
+	}
+	numbers, err := sequence.Open(filepath.Join(dataDir, "receipt-server-numbers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := numbers.Last(); last[""] != 3 || last["Till 2"] != 1 {
+		t.Errorf("numbers saved = %v", last)
+	}
+}
+
+func TestCoupons(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		Coupons: coupons.Config{Coupons: []coupons.Coupon{
+			{Title: "10% OFF", Text: "Your next rental", Barcode: "SAVE10", Expiry: "2099-12-31"},
+			{Title: "FREE HELMET", Barcode: "HELMET"},
+		}},
+	})
+	server := testharness.Start(t, s.setupRoutes())
+	print := func(extra map[string]interface{}) {
+		t.Helper()
+		receipt := map[string]interface{}{}
+		for k, v := range sampleReceipt {
+			receipt[k] = v
+		}
+		for k, v := range extra {
+			receipt[k] = v
+		}
+		if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+
+	// The configured coupons take turns after the footer; a request's own
+	// replace them, and refunds get none
+	print(map[string]interface{}{"transactionId": "TXN-501"})
+	print(map[string]interface{}{"transactionId": "TXN-502"})
+	print(map[string]interface{}{"transactionId": "TXN-503", "coupons": []map[string]interface{}{{"title": "SUMMER SALE", "barcode": "SUMMER"}}})
+	print(map[string]interface{}{"transactionId": "TXN-504", "type": "refund", "refundAmount": 72.80})
+	jobs := printer.WaitForJobs(t, 4, 2*time.Second)
+	for i, want := range []string{"10% OFF", "FREE HELMET", "SUMMER SALE"} {
+		job := jobs[i]
+		if !bytes.Contains(job, []byte(want)) {
+			t.Errorf("receipt %d: coupon %q not printed", i+1, want)
+		}
+		if bytes.Index(job, []byte(want)) < bytes.Index(job, []byte("Transaction:")) {
+			t.Errorf("receipt %d: coupon printed before the footer", i+1)
+		}
+	}
+	if !bytes.Contains(jobs[0], []byte("Expires 2099-12-31")) || !bytes.Contains(jobs[0], []byte("\x1dk\x49")) {
+		t.Errorf("coupon expiry or CODE128 barcode missing:\n%q", jobs[0])
+	}
+	for _, name := range []string{"10% OFF", "FREE HELMET", "SUMMER"} {
+		if bytes.Contains(jobs[3], []byte(name)) {
+			t.Errorf("refund printed coupon %q", name)
+		}
+	}
+
+	// Previews show the request's coupons with their barcode drawn
+	receipt := map[string]interface{}{"coupons": []map[string]interface{}{{"title": "SUMMER SALE", "barcode": "SUMMER"}}}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+	preview := server.PostJSON("/preview/receipt", receipt)
+	if preview.StatusCode != 200 || !strings.Contains(string(preview.Body), "SUMMER SALE") || !strings.Contains(string(preview.Body), "<img src=\"data:image/svg") {
+		t.Errorf("preview: status %d, coupon missing", preview.StatusCode)
+	}
+
+	receipt["coupons"] = []map[string]interface{}{{"barcode": "NOT\tPRINTABLE"}}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("invalid coupon: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestLoyaltyPoints(t *testing.T) {
+	server, printer := startReceiptServer(t)
+	receipt := map[string]interface{}{"loyaltyPointsEarned": 73, "loyaltyPointsRedeemed": 20, "loyaltyPointsBalance": 1253}
+	for k, v := range sampleReceipt {
+		receipt[k] = v
+	}
+
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := printer.WaitForJobs(t, 1, 2*time.Second)[0]
+	for _, want := range []string{"Loyalty Points", "Points earned:", "73", "Points redeemed:", "-20", "Points balance:", "1253"} {
+		if !bytes.Contains(job, []byte(want)) {
+			t.Errorf("thermal receipt is missing %q", want)
+		}
+	}
+
+	preview := server.PostJSON("/preview/receipt", receipt)
+	for _, want := range []string{"Loyalty Points", "<span>-20</span>", "<span>1253</span>"} {
+		if !strings.Contains(string(preview.Body), want) {
+			t.Errorf("preview is missing %q", want)
+		}
+	}
+
+	// Receipts without points have no summary
+	if preview := server.PostJSON("/preview/receipt", sampleReceipt); strings.Contains(string(preview.Body), "Loyalty Points") {
+		t.Error("preview without points has a loyalty summary")
+	}
+
+	receipt["loyaltyPointsRedeemed"] = -5
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("negative points redeemed: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestScriptedFields(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	cfg := script.Config{Fields: []script.Field{
+		{Name: "unrounded", Expr: "total"},
+		{Name: "total", Expr: `paymentType == "cash" ? round(total, 0.05) : total`},
+		{Name: "rounding", Expr: "money(total - unrounded)", Label: "Cash rounding"},
+		{Name: "broken", Expr: "1 / (total - total)", Label: "Broken"},
+	}}
+	if err := cfg.Compile(scriptFuncs(money.DefaultFormat())); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(Config{
+		PrinterIP:      printer.Host(),
+		PrinterPort:    printer.Port(),
+		DataDir:        t.TempDir(),
+		Tax:            tax.DefaultConfig(),
+		Currency:       money.DefaultFormat(),
+		ScriptedFields: cfg,
+	})
+	server := testharness.Start(t, s.setupRoutes())
+
+	// The same rules as the agent's: the total is rounded for cash and the
+	// rounding printed under it; a failed field is left out
+	receipt := map[string]interface{}{"transactionId": "TXN-601", "subtotal": 40.00, "tax": 4.83, "total": 44.83,
+		"paymentType": "cash", "items": []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}}}
+	if resp := server.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	job := printer.WaitForJobs(t, 1, 2*time.Second)[0]
+	for _, want := range []string{"$44.85", "Cash rounding:", "$0.02"} {
+		if !bytes.Contains(job, []byte(want)) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	if bytes.Contains(job, []byte("$44.83")) {
+		t.Error("the total was not rounded")
+	}
+	if bytes.Contains(job, []byte("Broken")) {
+		t.Error("a failed scripted field was printed")
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	printer := testharness.NewPrinterEmulator(t)
+	s := NewServer(Config{
+		PrinterIP:   printer.Host(),
+		PrinterPort: printer.Port(),
+		LogLevel:    "INFO",
+		DataDir:     t.TempDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+	})
+	var logs bytes.Buffer
+	s.logger = log.New(&logs, "", 0)
+	server := testharness.Start(t, s.setupRoutes())
+	if err := printer.Close(); err != nil {
+		t.Fatalf("closing printer emulator: %v", err)
+	}
+
+	post := func(path, requestID string, body interface{}) testharness.Response {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", server.URL()+path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		return server.Send(req)
+	}
+
+	// The client's ID follows the print into the response, the logs and
+	// the spooled job
+	resp := post("/print/receipt", "till-3:0007", sampleReceipt)
+	if resp.StatusCode != 202 || resp.Header.Get("X-Request-ID") != "till-3:0007" {
+		t.Fatalf("status = %d, X-Request-ID = %q", resp.StatusCode, resp.Header.Get("X-Request-ID"))
+	}
+	jobs, err := s.spooledJobs()
+	if err != nil || len(jobs) != 1 || jobs[0].RequestID != "till-3:0007" {
+		t.Errorf("spooled jobs = %+v, %v", jobs, err)
+	}
+	for _, want := range []string{"[till-3:0007] POST /print/receipt 202", "[till-3:0007] 📄 Received print request for transaction TXN-2001"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs are missing %q:\n%s", want, logs.String())
+		}
+	}
+
+	// Without one, or with one unsafe to log, the server makes one up,
+	// and errors quote it
+	for _, given := range []string{"", "forged [admin] %s id", strings.Repeat("x", 65)} {
+		for _, path := range []string{"/print/receipt", "/print/report"} {
+			resp := post(path, given, map[string]interface{}{"items": "not a list", "type": "q"})
+			id := resp.Header.Get("X-Request-ID")
+			if !regexp.MustCompile(`^req-[0-9a-f]{16}$`).MatchString(id) {
+				t.Errorf("%s: request ID for %q = %q", path, given, id)
+			}
+			if body := resp.JSON(t); resp.StatusCode != 400 || body["requestId"] != id {
+				t.Errorf("%s: error response: status %d, body %s", path, resp.StatusCode, resp.Body)
+			}
+		}
+	}
+	if strings.Contains(logs.String(), "forged") {
+		t.Error("an unsafe request ID reached the logs")
+	}
+}
+
+func TestAPIVersioning(t *testing.T) {
+	server, printer := startReceiptServer(t)
+
+	// Versioned paths are current
+	resp := server.PostJSON("/v1/print/receipt", sampleReceipt)
+	if resp.StatusCode != 200 || resp.Header.Get("Deprecation") != "" {
+		t.Fatalf("/v1/print/receipt: status %d, Deprecation %q, body %s", resp.StatusCode, resp.Header.Get("Deprecation"), resp.Body)
+	}
+	printer.WaitForJobs(t, 1, 5*time.Second)
+
+	// Legacy paths answer the same, pointing at their successor
+	for _, path := range []string{"/audit", "/test/receipt", "/printers/nowhere/selftest"} {
+		legacy := server.Get(path)
+		if legacy.Header.Get("Deprecation") != fmt.Sprintf("@%d", apiversion.Deprecated.Unix()) {
+			t.Errorf("%s: Deprecation = %q", path, legacy.Header.Get("Deprecation"))
+		}
+		if link := legacy.Header.Get("Link"); link != `</v1`+path+`>; rel="successor-version"` {
+			t.Errorf("%s: Link = %q", path, link)
+		}
+		if versioned := server.Get("/v1" + path); versioned.StatusCode != legacy.StatusCode {
+			t.Errorf("%s: status %d, /v1 status %d", path, legacy.StatusCode, versioned.StatusCode)
+		}
+	}
+
+	// Probes, metrics and assets stay where they are
+	for _, path := range []string{"/health", "/metrics"} {
+		if resp := server.Get(path); resp.StatusCode != 200 || resp.Header.Get("Deprecation") != "" {
+			t.Errorf("%s: status %d, Deprecation %q", path, resp.StatusCode, resp.Header.Get("Deprecation"))
+		}
+		if resp := server.Get("/v1" + path); resp.StatusCode != 404 {
+			t.Errorf("/v1%s: status = %d, want 404", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server, _ := startReceiptServer(t)
+
+	resp := server.Get("/openapi.json")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	doc := resp.JSON(t)
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v", doc["openapi"])
+	}
+
+	paths := doc["paths"].(map[string]interface{})
+	for _, path := range []string{"/v1/print/receipt", "/v1/print/ticket", "/v1/print/report", "/v1/print/label", "/v1/print/slip",
+		"/v1/timeclock/punch", "/v1/timeclock/export", "/v1/printers/{name}/selftest", "/v1/audit", "/v1/preview/receipt"} {
+		if paths[path] == nil {
+			t.Errorf("%s is not documented", path)
+		}
+	}
+	// Every documented path is routed
+	for path := range paths {
+		routed := strings.Replace(path, "{name}", "receipt", 1)
+		if resp := server.Get(routed); resp.StatusCode == 404 {
+			t.Errorf("documented path %s is not routed", path)
+		}
+	}
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	property := func(schema, name string) map[string]interface{} {
+		s, _ := schemas[schema].(map[string]interface{})
+		props, _ := s["properties"].(map[string]interface{})
+		p, _ := props[name].(map[string]interface{})
+		return p
+	}
+	if p := property("ReceiptData", "items"); p["type"] != "array" {
+		t.Errorf("ReceiptData.items = %v", p)
+	}
+	if p := property("TicketRequest", "orderNumber"); p["type"] != "string" {
+		t.Errorf("TicketRequest.orderNumber = %v", p)
+	}
+	if p := property("PrintResponse", "receiptNumber"); p["type"] != "integer" {
+		t.Errorf("PrintResponse.receiptNumber = %v", p)
+	}
+}
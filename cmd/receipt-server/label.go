@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"GoScanRentalTide/internal/label"
+	"GoScanRentalTide/internal/money"
+)
+
+// One label's data. Fields adds or overrides values for custom layouts.
+type LabelData struct {
+	Name     string            `json:"name"`
+	SKU      string            `json:"sku"`
+	Price    *money.Cents      `json:"price"`
+	AssetTag string            `json:"assetTag"`
+	Serial   string            `json:"serial"`
+	Location string            `json:"location"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// Label print request
+type LabelRequest struct {
+	Layout string      `json:"layout"` // "sku", "price", "asset" or a custom layout
+	Format string      `json:"format"` // "zpl" or "epl"; defaults to the configured format
+	Copies int         `json:"copies"` // Copies of each label
+	Labels []LabelData `json:"labels"`
+}
+
+type LabelResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Labels  int    `json:"labels"`
+}
+
+// Layouts available to /print/label: the built-in ones plus any loaded
+// with -label-layouts, which replace built-ins of the same name
+func (s *Server) labelLayouts() map[string]label.Layout {
+	layouts := label.Builtin()
+	for name, layout := range s.config.LabelLayouts {
+		layouts[name] = layout
+	}
+	return layouts
+}
+
+// Template fields for a label, with the price in the configured currency
+func (s *Server) labelFields(data LabelData) map[string]string {
+	fields := map[string]string{
+		"name":     data.Name,
+		"sku":      data.SKU,
+		"assetTag": data.AssetTag,
+		"serial":   data.Serial,
+		"location": data.Location,
+	}
+	if data.Price != nil {
+		fields["price"] = s.money(*data.Price)
+	}
+	for key, value := range data.Fields {
+		fields[key] = value
+	}
+	return fields
+}
+
+// Handler: Print barcode labels
+func (s *Server) handlePrintLabel(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req LabelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendBodyError(w, err)
+		return
+	}
+
+	layouts := s.labelLayouts()
+	if req.Layout == "" {
+		req.Layout = "sku"
+	}
+	layout, ok := layouts[req.Layout]
+	if !ok {
+		s.sendErrorResponse(w, http.StatusBadRequest,
+			fmt.Sprintf("unknown label layout %q (available: %s)", req.Layout, strings.Join(label.Names(layouts), ", ")))
+		return
+	}
+	if len(req.Labels) == 0 {
+		s.sendErrorResponse(w, http.StatusBadRequest, "at least one label is required")
+		return
+	}
+	if errs := copiesError(req.Copies); errs != nil {
+		s.sendValidationError(w, errs)
+		return
+	}
+	format := strings.ToLower(req.Format)
+	if format == "" {
+		format = s.config.LabelFormat
+	}
+
+	labels := make([]map[string]string, len(req.Labels))
+	for i, data := range req.Labels {
+		labels[i] = s.labelFields(data)
+	}
+	content, err := layout.Render(format, labels, req.Copies)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if s.config.LabelPrinterIP == "" && !s.isAttached("label") {
+		s.sendErrorResponse(w, http.StatusServiceUnavailable, "no label printer configured (-label-printer-ip)")
+		return
+	}
+	host, err := s.resolvePrinterHost(s.printerHost("label", s.config.LabelPrinterIP))
+	if err == nil {
+		err = s.sendToPrinter(r.Context(), joinPrinterAddress(host, s.config.LabelPrinterPort), string(content))
+	}
+	if err != nil {
+		s.logf(r.Context(), "Label print failed: %v", err)
+		s.errorBeep()
+		s.sendJSONResponse(w, http.StatusInternalServerError, LabelResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to print labels: %v", err),
+		})
+		return
+	}
+
+	s.logf(r.Context(), "🏷️  Printed %d %s label(s) as %s", len(labels), layout.Name, strings.ToUpper(format))
+	s.sendJSONResponse(w, http.StatusOK, LabelResponse{
+		Success: true,
+		Message: fmt.Sprintf("Printed %d %s %s", len(labels), layout.Name,
+			map[bool]string{true: "label", false: "labels"}[len(labels) == 1]),
+		Labels: len(labels),
+	})
+}
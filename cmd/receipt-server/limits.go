@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+
+	"GoScanRentalTide/internal/limits"
+)
+
+// Largest request body when the config doesn't set MaxBodyBytes, the same
+// as the agent's -max-body-kb default
+const defaultMaxBodyBytes = 512 << 10
+
+// Body limit middleware: caps every request body, so one oversized POST
+// can't use up the print server's memory
+func (s *Server) limitBody(next http.Handler) http.Handler {
+	maxBody := s.config.MaxBodyBytes
+	if maxBody <= 0 {
+		maxBody = defaultMaxBodyBytes
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Rate limit middleware for the routes that print: a client over
+// PrintRateLimit is answered 429, as the agent does
+func (s *Server) limitPrints(next http.HandlerFunc) http.HandlerFunc {
+	return limits.Requests(s.printLimiter, 0, func(w http.ResponseWriter, status int, err error) {
+		s.sendErrorResponse(w, status, err.Error())
+	}, next)
+}
+
+// Status for a request body that could not be read or parsed: 413 for
+// bodies over the size limit, 400 for the rest
+func bodyErrorStatus(err error) int {
+	status, _ := limits.BodyError(err)
+	return status
+}
+
+// Helper function to send a request body that could not be read or parsed
+func (s *Server) sendBodyError(w http.ResponseWriter, err error) {
+	status, err := limits.BodyError(err)
+	s.sendErrorResponse(w, status, err.Error())
+}
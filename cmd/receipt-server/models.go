@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/starprnt"
+)
+
+// Command sets by name, and the printer models known to speak them. A
+// model that isn't listed can name its command set instead.
+var commandSets = map[string]escpos.CommandSet{
+	"escpos":   escpos.ESCPOS,
+	"starprnt": starprnt.Commands,
+}
+
+var printerModels = map[string]string{
+	"tm-t20":    "escpos",
+	"tm-t88":    "escpos",
+	"tm-m30":    "escpos",
+	"tsp100":    "starprnt",
+	"tsp143":    "starprnt",
+	"tsp650":    "starprnt",
+	"tsp700":    "starprnt",
+	"mc-print2": "starprnt",
+	"mc-print3": "starprnt",
+	"sm-l200":   "starprnt",
+}
+
+// Helper function to look up the command set of a printer model or
+// command set name
+func lookupCommandSet(model string) (escpos.CommandSet, error) {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if name, ok := printerModels[model]; ok {
+		model = name
+	}
+	if commands, ok := commandSets[model]; ok {
+		return commands, nil
+	}
+	var names []string
+	for name := range commandSets {
+		names = append(names, name)
+	}
+	for name := range printerModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown printer model %q (use %s)", model, strings.Join(names, ", "))
+}
+
+// Helper function to get the command set documents for a printer, by the
+// name used in /printers/{name}, are written in: its model's, or ESC/POS
+func (s *Server) commandSet(name string) escpos.CommandSet {
+	commands, err := lookupCommandSet(s.config.PrinterModels[name])
+	if s.config.PrinterModels[name] == "" || err != nil {
+		return escpos.ESCPOS
+	}
+	return commands
+}
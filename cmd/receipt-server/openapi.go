@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"GoScanRentalTide/internal/openapi"
+)
+
+// Version reported by /health and the OpenAPI document
+const serverVersion = "2.0.0"
+
+// operatorNote describes the header naming who opened the cash drawer
+const operatorNote = "Drawer opens are audited under the staff member in the " + operatorHeader + " header."
+
+// The OpenAPI document of the receipt server's endpoints, generated
+// from the request and response types so it can't drift from them
+var apiSpec = openapi.Spec{
+	Title:   "GoScanRentalTide receipt server",
+	Version: serverVersion,
+	Description: "Network receipt server for thermal receipts, kitchen tickets, shift reports, labels, card slips and the time clock. " +
+		"Print endpoints answer failures with their own response and success false; other errors are an ErrorResponse. " +
+		"Every /v1 path is also served without the prefix for older frontends, with Deprecation and Link headers pointing at the /v1 path.",
+	Error: ErrorResponse{},
+	Operations: []openapi.Operation{
+		{Method: "POST", Path: "/v1/print/receipt", Summary: "Print a receipt",
+			Description: "Offline printers get the receipt spooled, to print once they're back (spooled: true). " + operatorNote,
+			Request:     ReceiptData{},
+			Response:    PrintResponse{}},
+		{Method: "POST", Path: "/v1/preview/receipt", Summary: "Render a receipt as HTML without printing it",
+			Request: ReceiptData{}, ResponseType: "text/html"},
+		{Method: "GET", Path: "/v1/test/receipt", Summary: "Render a sample receipt as HTML", ResponseType: "text/html"},
+		{Method: "POST", Path: "/v1/print/ticket", Summary: "Print a kitchen or prep ticket",
+			Request: TicketRequest{}, Response: PrintResponse{}},
+		{Method: "POST", Path: "/v1/print/report", Summary: "Print an X or Z shift report",
+			Description: "Z reports are numbered; printing the same shift again is a reprint with the same number.",
+			Request:     ReportRequest{},
+			Response:    ReportResponse{}},
+		{Method: "POST", Path: "/v1/print/label", Summary: "Print SKU, price or asset labels on the label printer",
+			Request: LabelRequest{}, Response: LabelResponse{}},
+		{Method: "POST", Path: "/v1/print/slip", Summary: "Print a card slip for signing",
+			Request: SlipRequest{}, Response: SlipResponse{}},
+		{Method: "POST", Path: "/v1/printers/{name}/selftest", Summary: "Print a self-test page on a configured printer",
+			Description: operatorNote,
+			PathParams:  []openapi.Param{{Name: "name", Type: "string", Description: "\"receipt\" or a name from the printers config"}},
+			Query: []openapi.Param{
+				{Name: "drawer", Type: "boolean", Description: "Kick the cash drawer as part of the test (default true)"},
+				{Name: "logoUrl", Type: "string", Description: "Logo to print, to check it rasterises"},
+			},
+			Response: PrintResponse{}},
+		{Method: "POST", Path: "/v1/timeclock/punch", Summary: "Clock an employee in or out",
+			Request: PunchRequest{}, Response: PunchResponse{}},
+		{Method: "GET", Path: "/v1/timeclock/export", Summary: "Export time clock punches",
+			Description: "CSV by default; ?format=json returns the punches as a JSON array.",
+			Query: []openapi.Param{
+				{Name: "from", Type: "string", Description: "Start date (YYYY-MM-DD)"},
+				{Name: "to", Type: "string", Description: "End date (YYYY-MM-DD, inclusive)"},
+				{Name: "employeeId", Type: "string", Description: "Only this employee's punches"},
+				{Name: "format", Type: "string", Description: "\"json\" for JSON instead of CSV"},
+			},
+			ResponseType: "text/csv"},
+		{Method: "GET", Path: "/v1/audit", Summary: "Read the audit log of cash-impacting events and check its hash chain",
+			Response: AuditResponse{}},
+		{Method: "GET", Path: "/health", Summary: "Check the server and its receipt printer", Response: HealthResponse{}},
+		{Method: "GET", Path: "/metrics", Summary: "Printer status counters in the Prometheus text format", ResponseType: "text/plain"},
+		{Method: "GET", Path: "/openapi.json", Summary: "This document", ResponseType: "application/json"},
+	},
+}
+
+// Handler: Serve the OpenAPI document
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(apiSpec.Document())
+}
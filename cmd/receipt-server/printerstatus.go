@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/webhook"
+)
+
+// What the last status check of a printer found
+type printerState struct {
+	Address string
+	Online  bool
+	Status  escpos.Status
+	Checked time.Time
+}
+
+// Poll the thermal printers' status every StatusInterval until ctx ends,
+// so paper running low is reported before it runs out mid-sale
+func (s *Server) monitorPrinters(ctx context.Context) {
+	if s.config.StatusInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.config.StatusInterval)
+	defer ticker.Stop()
+	for {
+		s.checkPrinters(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ask each thermal printer for its status once and raise the alerts that
+// changed. A printer shared by several names is asked once, and USB
+// printers are skipped: the class driver only writes.
+func (s *Server) checkPrinters(ctx context.Context) {
+	checked := make(map[string]bool)
+	for _, name := range s.printerNames() {
+		printer, err := s.namedPrinter(name)
+		if err != nil {
+			continue
+		}
+		reporter, ok := printer.Commands.(escpos.StatusReporter)
+		if !ok {
+			continue
+		}
+		host, err := s.resolvePrinterHost(printer.Host)
+		if err != nil {
+			continue
+		}
+		address := joinPrinterAddress(host, printer.Port)
+		if _, ok := usbDevice(address); ok || checked[address] {
+			continue
+		}
+		checked[address] = true
+
+		status, err := s.queryPrinterStatus(ctx, address, reporter)
+		if ctx.Err() != nil {
+			return
+		}
+		s.updatePrinterState(name, address, status, err)
+	}
+}
+
+// Send a status request to a printer and decode its reply
+func (s *Server) queryPrinterStatus(ctx context.Context, address string, reporter escpos.StatusReporter) (escpos.Status, error) {
+	request, replyLen := reporter.StatusRequest()
+	reply := make([]byte, replyLen)
+	if port, ok := serialDevice(address); ok {
+		conn, _, err := s.openSerialPrinter(port)
+		if err != nil {
+			return escpos.Status{}, err
+		}
+		defer conn.Close()
+		if _, err := conn.Write(request); err != nil {
+			return escpos.Status{}, err
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for n := 0; n < replyLen; {
+			m, err := conn.Read(reply[n:])
+			if err != nil {
+				return escpos.Status{}, fmt.Errorf("no status reply: %v", err)
+			}
+			n += m
+		}
+		return reporter.ParseStatus(reply)
+	}
+
+	dialer := net.Dialer{Timeout: 2 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return escpos.Status{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write(request); err != nil {
+		return escpos.Status{}, err
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return escpos.Status{}, fmt.Errorf("no status reply: %v", err)
+	}
+	return reporter.ParseStatus(reply)
+}
+
+// Record a printer's status, logging and sending webhooks for the alerts
+// raised and cleared since the last check
+func (s *Server) updatePrinterState(name, address string, status escpos.Status, err error) {
+	state := printerState{Address: address, Online: err == nil, Status: status, Checked: time.Now()}
+
+	s.statusMu.Lock()
+	previous, seen := s.statuses[name]
+	if s.statuses == nil {
+		s.statuses = make(map[string]printerState)
+	}
+	s.statuses[name] = state
+	s.statusMu.Unlock()
+
+	switch {
+	case err != nil && (!seen || previous.Online):
+		s.logger.Printf("⚠️  Printer %s (%s) did not answer its status check: %v", name, address, err)
+		s.webhooks.Send(webhook.PrinterOffline, map[string]string{"printer": name, "address": address, "error": err.Error()})
+		return
+	case err != nil:
+		return
+	case seen && !previous.Online:
+		s.logger.Printf("✅ Printer %s (%s) is answering again", name, address)
+		s.webhooks.Send(webhook.PrinterOnline, map[string]string{"printer": name, "address": address})
+	}
+
+	was := make(map[string]bool)
+	for _, alert := range previous.Status.Alerts() {
+		was[alert] = true
+	}
+	for _, alert := range status.Alerts() {
+		if was[alert] {
+			delete(was, alert)
+			continue
+		}
+		s.logger.Printf("⚠️  Printer %s (%s): %s", name, address, alertMessage(alert))
+		s.webhooks.Send(webhook.PrinterAlert, map[string]string{"printer": name, "address": address, "alert": alert, "message": alertMessage(alert)})
+	}
+	for _, alert := range escpos.AlertNames {
+		if was[alert] {
+			s.logger.Printf("✅ Printer %s (%s): %s cleared", name, address, strings.ReplaceAll(alert, "_", " "))
+			s.webhooks.Send(webhook.PrinterAlertCleared, map[string]string{"printer": name, "address": address, "alert": alert})
+		}
+	}
+}
+
+// Helper function to describe an alert to staff
+func alertMessage(alert string) string {
+	switch alert {
+	case escpos.AlertPaperLow:
+		return "paper is running low; replace the roll"
+	case escpos.AlertPaperOut:
+		return "out of paper"
+	case escpos.AlertCoverOpen:
+		return "cover is open"
+	case escpos.AlertCutterError:
+		return "cutter error; open the cover and clear the jam"
+	}
+	return "printer error; switch it off and on again"
+}
+
+// Helper function to get the alerts of a printer's last status check
+func (s *Server) printerAlerts(name string) []string {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.statuses[name].Status.Alerts()
+}
+
+// Handler: Printer status in the Prometheus text format, for dashboards
+// that alert across stores
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.statusMu.Lock()
+	names := make([]string, 0, len(s.statuses))
+	states := make(map[string]printerState, len(s.statuses))
+	for name, state := range s.statuses {
+		names = append(names, name)
+		states[name] = state
+	}
+	s.statusMu.Unlock()
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("# HELP receipt_printer_up Whether the printer answered its last status check.\n")
+	b.WriteString("# TYPE receipt_printer_up gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "receipt_printer_up{printer=%q} %d\n", name, gauge(states[name].Online))
+	}
+	b.WriteString("# HELP receipt_printer_alert Whether the printer reported the condition at its last status check.\n")
+	b.WriteString("# TYPE receipt_printer_alert gauge\n")
+	for _, name := range names {
+		active := make(map[string]bool)
+		for _, alert := range states[name].Status.Alerts() {
+			active[alert] = true
+		}
+		for _, alert := range escpos.AlertNames {
+			fmt.Fprintf(&b, "receipt_printer_alert{printer=%q,alert=%q} %d\n", name, alert, gauge(active[alert]))
+		}
+	}
+	b.WriteString("# HELP receipt_printer_last_check_seconds When the printer's status was last checked.\n")
+	b.WriteString("# TYPE receipt_printer_last_check_seconds gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "receipt_printer_last_check_seconds{printer=%q} %d\n", name, states[name].Checked.Unix())
+	}
+	b.WriteString("# HELP receipt_spooled_receipts Receipts waiting for the printer to come back.\n")
+	b.WriteString("# TYPE receipt_spooled_receipts gauge\n")
+	fmt.Fprintf(&b, "receipt_spooled_receipts %d\n", s.spoolLength())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, b.String())
+}
+
+func gauge(on bool) int {
+	if on {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// How a printer is connected. Printers are on the network unless
+// Config.Printers says otherwise.
+type PrinterTransport struct {
+	Transport string `json:"transport"`        // tcp (the default), usb or serial
+	Device    string `json:"device,omitempty"` // usb: the device path or part of it, e.g. lp1 or vid_04b8&pid_0202 (default: the only USB printer); serial: the port, e.g. COM3 or /dev/ttyUSB0
+
+	// Serial settings (default: 9600 baud, 8 data bits, no parity, no
+	// flow control)
+	Baud        int    `json:"baud,omitempty"`
+	DataBits    int    `json:"data_bits,omitempty"`
+	Parity      string `json:"parity,omitempty"`       // none, odd or even
+	FlowControl string `json:"flow_control,omitempty"` // none, rtscts or xonxoff
+}
+
+// Helper function to check whether a printer, by the name used in
+// /printers/{name}, is attached to this machine by USB or a serial port
+// rather than on the network
+func (s *Server) isAttached(name string) bool {
+	switch strings.ToLower(s.config.Printers[name].Transport) {
+	case "usb", "serial":
+		return true
+	}
+	return false
+}
+
+// Helper function to get the host a printer's jobs go to: a usb:// or
+// serial:// address when it is attached, or else host
+func (s *Server) printerHost(name, host string) string {
+	printer := s.config.Printers[name]
+	switch strings.ToLower(printer.Transport) {
+	case "usb":
+		return usbScheme + printer.Device
+	case "serial":
+		return serialScheme + printer.Device
+	}
+	return host
+}
+
+// Helper function to check whether an address is a usb:// or serial://
+// address, which has no host to resolve and no port
+func isAttachedAddress(address string) bool {
+	return strings.HasPrefix(address, usbScheme) || strings.HasPrefix(address, serialScheme)
+}
+
+// Helper function to join a printer host and port into the address
+// sendToPrinter takes; an attached printer has no port
+func joinPrinterAddress(host string, port int) string {
+	if isAttachedAddress(host) {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// Helper function to send raw data to an attached printer; ok is false
+// for a network address, which sendToPrinter dials
+func (s *Server) sendToAttached(ctx context.Context, address, content string) (ok bool, err error) {
+	if device, ok := usbDevice(address); ok {
+		return true, s.sendToUSB(ctx, device, content)
+	}
+	if port, ok := serialDevice(address); ok {
+		return true, s.sendToSerial(ctx, port, content)
+	}
+	return false, nil
+}
+
+// Helper function to check that an attached printer can be opened,
+// without printing anything; ok is false for a network address
+func (s *Server) attachedPrinterOnline(address string) (ok bool, err error) {
+	if device, ok := usbDevice(address); ok {
+		return true, usbPrinterOnline(device)
+	}
+	if port, ok := serialDevice(address); ok {
+		return true, s.serialPrinterOnline(port)
+	}
+	return false, nil
+}
+
+// Helper function to name the transport jobs to an address go over, as
+// PrinterTransport.Transport does: tcp, usb or serial
+func addressTransport(address string) string {
+	switch {
+	case strings.HasPrefix(address, usbScheme):
+		return "usb"
+	case strings.HasPrefix(address, serialScheme):
+		return "serial"
+	}
+	return "tcp"
+}
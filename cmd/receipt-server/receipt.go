@@ -0,0 +1,3973 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"GoScanRentalTide/internal/apiversion"
+	"GoScanRentalTide/internal/audit"
+	"GoScanRentalTide/internal/coupons"
+	"GoScanRentalTide/internal/dedupe"
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/glcode"
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/label"
+	"GoScanRentalTide/internal/limits"
+	"GoScanRentalTide/internal/listen"
+	"GoScanRentalTide/internal/merchant"
+	"GoScanRentalTide/internal/messages"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/paper"
+	"GoScanRentalTide/internal/qr"
+	"GoScanRentalTide/internal/rental"
+	"GoScanRentalTide/internal/sanitize"
+	"GoScanRentalTide/internal/script"
+	"GoScanRentalTide/internal/sequence"
+	"GoScanRentalTide/internal/tax"
+	"GoScanRentalTide/internal/tmplcache"
+	"GoScanRentalTide/internal/tmplfuncs"
+	"GoScanRentalTide/internal/tracing"
+	"GoScanRentalTide/internal/validate"
+	"GoScanRentalTide/internal/webhook"
+)
+
+// Configuration
+type Config struct {
+	Port        int          `json:"port"`
+	PrinterIP   string       `json:"printer_ip"`
+	PrinterPort int          `json:"printer_port"`
+	LogLevel    string       `json:"log_level"`
+	DataDir     string       `json:"data_dir"`
+	Tax         tax.Config   `json:"tax"`
+	Currency    money.Format `json:"currency"`
+	Language    string       `json:"language"`
+
+	// Following ports to try when Port is taken; the port used is
+	// written to <data dir>/receipt-server-port.json
+	PortFallback int `json:"port_fallback"`
+
+	// Kitchen/prep tickets go to this printer, or to the receipt printer
+	// when it isn't set. StationPrinters sends a station's tickets to its
+	// own printer, as "host" or "host:port".
+	TicketPrinterIP   string            `json:"ticket_printer_ip"`
+	TicketPrinterPort int               `json:"ticket_printer_port"`
+	StationPrinters   map[string]string `json:"station_printers"`
+
+	// Printers attached by USB or a serial port instead of the network, by
+	// the name used in /printers/{name}: receipt, ticket, label or a
+	// station. They are written to through the USB printer class driver or
+	// the port, with no spooler.
+	Printers map[string]PrinterTransport `json:"printers"`
+
+	// Width in dots that receipt logos are scaled to fit (default: the
+	// printable width of Paper)
+	LogoWidth int `json:"logo_width"`
+
+	// Logos and fonts served under /assets/, where a receipt's
+	// "local:NAME" logo is read from (default: <data dir>/assets)
+	AssetsDir string `json:"assets_dir"`
+
+	// Code page thermal text is printed in (default: cp437); characters it
+	// lacks are spelled out without accents
+	CodePage string `json:"code_page"`
+
+	// Printer models by the name used in /printers/{name}, e.g. tsp143 or
+	// tm-t88, or the command set they speak: escpos or starprnt. Printers
+	// without one are sent ESC/POS.
+	PrinterModels map[string]string `json:"printer_models"`
+
+	// How the receipt printer, and the ticket and station printers when
+	// TicketCut isn't set, finish each document: full, partial or no cut,
+	// after feeding some lines. Zero values use a partial cut after 3.
+	Cut       escpos.Cut `json:"cut"`
+	TicketCut escpos.Cut `json:"ticket_cut"`
+
+	// Buzzer patterns: Beep after each document on the receipt printer and
+	// TicketBeep on the ticket and station printers, so staff at a noisy
+	// counter notice it; ErrorBeep on the receipt printer when a print
+	// fails. Zero values are silent.
+	Beep       escpos.Beep `json:"beep"`
+	TicketBeep escpos.Beep `json:"ticket_beep"`
+	ErrorBeep  escpos.Beep `json:"error_beep"`
+
+	// Paper in the receipt printer, and in the ticket and station printers
+	// when TicketPaper isn't set. Zero values lay receipts out for 80mm
+	// pages and 32-column thermal lines.
+	Paper       paper.Size `json:"paper"`
+	TicketPaper paper.Size `json:"ticket_paper"`
+
+	// ZPL/EPL label printer and custom label layouts
+	LabelPrinterIP   string                  `json:"label_printer_ip"`
+	LabelPrinterPort int                     `json:"label_printer_port"`
+	LabelFormat      string                  `json:"label_format"`
+	LabelLayouts     map[string]label.Layout `json:"label_layouts"`
+
+	// Card slips for /print/slip by payment type, over the defaults
+	// (credit and debit); nil turns a type's slip off
+	Slips map[string]*SlipOptions `json:"slips"`
+
+	// Custom header and footer blocks printed on every receipt; a footer
+	// replaces the thank-you lines
+	Messages messages.Config `json:"messages"`
+
+	// Fields computed from each receipt before it prints, such as cash
+	// rounding or custom labels, compiled with scriptFuncs
+	ScriptedFields script.Config `json:"scripted_fields"`
+
+	// Promotional coupons printed after the footer of sales that don't
+	// send their own, taking turns by the rotation rules
+	Coupons coupons.Config `json:"coupons"`
+
+	// Legal name, address and tax registrations printed in a legal block
+	// on every receipt, with the tax summary format
+	Merchant merchant.Info `json:"merchant"`
+
+	// Receivers told when receipts are spooled because the printer is
+	// offline, and when the spool is printed
+	Webhooks webhook.Config `json:"webhooks"`
+
+	// The same receipt printed again within DuplicateWindow is marked
+	// DUPLICATE, or refused when DuplicateAction is "reject". Zero turns
+	// detection off.
+	DuplicateWindow time.Duration `json:"duplicate_window"`
+	DuplicateAction string        `json:"duplicate_action"`
+
+	// How often the thermal printers are asked for their paper, cover and
+	// cutter status, which /health, /metrics and the webhooks report.
+	// Zero turns the checks off.
+	StatusInterval time.Duration `json:"status_interval"`
+
+	// Largest request body accepted, in bytes; larger ones are refused
+	// with 413. Zero uses 512 KB.
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+
+	// Number receipts in sequence per station (stationId, or the server's
+	// own when it has none), for jurisdictions that require it. The number
+	// is printed on the receipt and returned as receiptNumber.
+	ReceiptNumbers bool `json:"receipt_numbers"`
+
+	// Print requests allowed per minute from one client, after a burst of
+	// PrintBurst; more are refused with 429. Zero turns the limit off.
+	PrintRateLimit int `json:"print_rate_limit"`
+	PrintBurst     int `json:"print_burst"`
+}
+
+// Receipt item structure
+type ReceiptItem struct {
+	Name      string      `json:"name" validate:"required"`
+	Quantity  Quantity    `json:"quantity" validate:"nonnegative"`
+	Price     money.Cents `json:"price"`
+	SKU       string      `json:"sku"`
+	TaxCode   string      `json:"taxCode"`
+	TaxExempt bool        `json:"taxExempt"` // No tax whatever the code
+	ItemType  string      `json:"itemType"`  // sale (default), rental, deposit, waiver or fee
+	StartDate string      `json:"startDate"` // Rentals: when the item goes out
+	DueDate   string      `json:"dueDate"`   // Rentals: when it is due back
+	RateUnit  string      `json:"rateUnit"`  // Rentals: hourly, daily, weekly or monthly
+	LineTotal money.Cents `json:"-"`         // Price x quantity, set before rendering
+}
+
+// Quantity of an item. The frontends send it as a number or as a numeric
+// string ("2", "1.5"), which the agent also accepts.
+type Quantity float64
+
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	text := strings.TrimSpace(string(data))
+	if text == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(text); err == nil {
+		text = strings.TrimSpace(unquoted)
+		if text == "" {
+			*q = 0
+			return nil
+		}
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("invalid quantity %s", data)
+	}
+	*q = Quantity(f)
+	return nil
+}
+
+// String shows whole quantities without decimals: 2, 1.5
+func (q Quantity) String() string {
+	return strconv.FormatFloat(float64(q), 'f', -1, 64)
+}
+
+// Item types. Deposits are held rather than charged: they are never taxed
+// and print in a section of their own.
+const (
+	itemSale    = "sale"
+	itemRental  = "rental"
+	itemDeposit = "deposit"
+	itemWaiver  = "waiver"
+	itemFee     = "fee"
+)
+
+// IsDeposit reports whether the item is a held deposit
+func (item ReceiptItem) IsDeposit() bool {
+	return item.ItemType == itemDeposit
+}
+
+// TypeKey returns the translation key of the marker printed under the
+// item; sales and deposits have none
+func (item ReceiptItem) TypeKey() string {
+	switch item.ItemType {
+	case itemRental, itemWaiver, itemFee:
+		return "item_" + item.ItemType
+	}
+	return ""
+}
+
+// Out returns when a rental goes out, as printed
+func (item ReceiptItem) Out() string {
+	return rental.Format(item.StartDate)
+}
+
+// DueBack returns when a rental is due back, as printed
+func (item ReceiptItem) DueBack() string {
+	return rental.Format(item.DueDate)
+}
+
+// UnitKey returns the translation key of the unit the price is charged
+// per, or "" when it isn't a rate
+func (item ReceiptItem) UnitKey() string {
+	return rental.UnitKey(item.RateUnit)
+}
+
+// Helper function to reject items with an unknown type or a rental period
+// that doesn't make sense
+func itemTypeError(items []ReceiptItem) error {
+	for i, item := range items {
+		switch item.ItemType {
+		case "", itemSale, itemRental, itemDeposit, itemWaiver, itemFee:
+		default:
+			return fmt.Errorf("item %d has unknown itemType %q (use sale, rental, deposit, waiver or fee)", i+1, item.ItemType)
+		}
+		if err := rental.Validate(item.StartDate, item.DueDate, item.RateUnit); err != nil {
+			return fmt.Errorf("item %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Helper function to find when the first rental on a receipt is due back
+func earliestDueBack(items []ReceiptItem) string {
+	var dues []string
+	for _, item := range items {
+		if item.DueDate != "" {
+			dues = append(dues, item.DueDate)
+		}
+	}
+	return rental.Earliest(dues)
+}
+
+// Helper function to collect the deposits held (or returned) by a receipt
+func receiptDeposits(items []ReceiptItem) ([]ReceiptItem, money.Cents) {
+	var deposits []ReceiptItem
+	var total money.Cents
+	for _, item := range items {
+		if item.IsDeposit() {
+			item.LineTotal = item.Price.Times(float64(item.Quantity))
+			deposits = append(deposits, item)
+			total += item.LineTotal
+		}
+	}
+	return deposits, total
+}
+
+// Location of the store. The frontends send a name or, like the agent
+// accepts, an object with a name field: {"name": "Main Street"}.
+type Location string
+
+func (l *Location) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*l = Location(name)
+		return nil
+	}
+	var object struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return fmt.Errorf("location must be a name or an object with a name, not %s", data)
+	}
+	*l = Location(object.Name)
+	return nil
+}
+
+// Card details structure
+type CardDetails struct {
+	CardBrand string `json:"cardBrand"`
+	CardLast4 string `json:"cardLast4"`
+	AuthCode  string `json:"authCode"`
+}
+
+// A tender of a split payment
+type Payment struct {
+	Type        string      `json:"type" validate:"required"` // cash, credit, debit, account, cheque
+	Amount      money.Cents `json:"amount" validate:"positive"`
+	CardDetails CardDetails `json:"cardDetails"`
+}
+
+// A tender as printed
+type TenderLine struct {
+	Icon     string
+	Display  string
+	Amount   money.Cents
+	Card     string
+	AuthCode string
+}
+
+// Helper function to reject split payments with a tender that has no type
+// or no amount
+func paymentsError(payments []Payment) error {
+	for i, p := range payments {
+		if p.Type == "" {
+			return fmt.Errorf("payment %d has no type", i+1)
+		}
+		if p.Amount <= 0 {
+			return fmt.Errorf("payment %d (%s) must have a positive amount", i+1, p.Type)
+		}
+	}
+	return nil
+}
+
+// Helper function to format a card as printed, e.g. "Visa ****1234"
+func cardText(details CardDetails, tr i18n.Translator) string {
+	text := tr.T("card")
+	if details.CardBrand != "" {
+		text = strings.Title(details.CardBrand)
+	}
+	if details.CardLast4 != "" {
+		text += fmt.Sprintf(" ****%s", details.CardLast4)
+	}
+	return text
+}
+
+// Helper function to lay out the tenders of a split payment
+func tenderLines(payments []Payment, tr i18n.Translator) []TenderLine {
+	var lines []TenderLine
+	for _, p := range payments {
+		line := TenderLine{
+			Icon:     getPaymentEmoji(p.Type),
+			Display:  formatPaymentType(p.Type, false, false),
+			Amount:   p.Amount,
+			AuthCode: p.CardDetails.AuthCode,
+		}
+		if p.CardDetails.CardBrand != "" || p.CardDetails.CardLast4 != "" {
+			line.Card = cardText(p.CardDetails, tr)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Receipt data structure matching your React frontend
+type ReceiptData struct {
+	TransactionID           string        `json:"transactionId"`
+	Items                  []ReceiptItem `json:"items"`
+	Subtotal               money.Cents   `json:"subtotal"`
+	Tax                    money.Cents   `json:"tax"`
+	Total                  money.Cents   `json:"total"`
+	Tip                    money.Cents   `json:"tip" validate:"nonnegative"`
+	PaymentType            string        `json:"paymentType"`
+	Payments               []Payment     `json:"payments"` // Split payments, one per tender
+	CustomerName           string        `json:"customerName"`
+	Date                   string        `json:"date"`
+	Location               Location      `json:"location"`
+	Copies                 int           `json:"copies" validate:"nonnegative"`
+	CashGiven              money.Cents   `json:"cashGiven" validate:"nonnegative"`
+	ChangeDue              money.Cents   `json:"changeDue"`
+	DiscountAmount         money.Cents   `json:"discountAmount"`
+	DiscountPercentage     float64       `json:"discountPercentage" validate:"nonnegative"`
+	PromoAmount            money.Cents   `json:"promoAmount"`
+	RefundAmount           money.Cents   `json:"refundAmount"`
+	TerminalId             string        `json:"terminalId"`
+	AccountId              string        `json:"accountId"`
+	AccountName            string        `json:"accountName"`
+	AccountBalanceBefore   money.Cents   `json:"accountBalanceBefore"`
+	AccountBalanceAfter    money.Cents   `json:"accountBalanceAfter"`
+	SettlementAmount       money.Cents   `json:"settlementAmount"`
+	GLCodeSummary          []glcode.Line `json:"glCodeSummary"` // Amount posted to each GL code, on settlements
+	IsSettlement           bool          `json:"isSettlement"`
+	IsRetail               bool          `json:"isRetail"`
+	HasCombinedTransaction bool          `json:"hasCombinedTransaction"`
+	SkipTaxCalculation     bool          `json:"skipTaxCalculation"`
+	HasNoTax               bool          `json:"hasNoTax"`
+	LogoUrl                string        `json:"logoUrl"`
+	CardDetails            CardDetails   `json:"cardDetails"`
+	Type                   string        `json:"type"`
+	OriginalTransactionID  string        `json:"originalTransactionId"`
+	RefundMethod           string        `json:"refundMethod"`
+	TaxBreakdown           []tax.Line    `json:"taxBreakdown"`
+	Language               string        `json:"language"`
+	HeaderMessages         []messages.Block `json:"headerMessages"` // Replace the configured header on this receipt
+	FooterMessages         []messages.Block `json:"footerMessages"` // Replace the configured footer on this receipt
+	OperatorName           string        `json:"operatorName"` // Printed as "Served by"
+	StationID              string        `json:"stationId"`    // Till or counter the sale was rung up on
+	Coupons                []coupons.Coupon `json:"coupons"`    // Replace the configured rotation on this receipt
+	LoyaltyPointsEarned    int64         `json:"loyaltyPointsEarned" validate:"nonnegative"`
+	LoyaltyPointsRedeemed  int64         `json:"loyaltyPointsRedeemed" validate:"nonnegative"`
+	LoyaltyPointsBalance   int64         `json:"loyaltyPointsBalance"` // Customer's balance after this sale
+	Scripted               map[string]interface{} `json:"-"` // ScriptedFields values, by name
+	ScriptedLines          []ScriptedLine `json:"-"`          // Labelled ScriptedFields, printed under the total
+	ReceiptNumber          int64         `json:"-"`            // Station's sequence number, with ReceiptNumbers
+	Duplicate              bool          `json:"-"`            // Printed again within the duplicate window
+}
+
+// A custom message as rendered, with its QR code drawn
+type MessageBlock struct {
+	messages.Block
+	QRImage template.URL
+}
+
+// Helper function to check a receipt's own header and footer messages
+// and coupons
+func messagesError(receipt ReceiptData) error {
+	if err := messages.Validate(receipt.HeaderMessages); err != nil {
+		return fmt.Errorf("headerMessages: %v", err)
+	}
+	if err := messages.Validate(receipt.FooterMessages); err != nil {
+		return fmt.Errorf("footerMessages: %v", err)
+	}
+	if err := coupons.Validate(receipt.Coupons); err != nil {
+		return fmt.Errorf("coupons: %v", err)
+	}
+	return nil
+}
+
+// Helper function to clean the text of a receipt before it reaches the
+// printer or the preview: control characters would reach the printer as
+// ESC/POS commands. The logo must be an http(s) URL, since it is fetched
+// by the server, or a local: asset.
+func sanitizeReceipt(receipt *ReceiptData) error {
+	if err := checkLogo(receipt.LogoUrl); err != nil {
+		return fmt.Errorf("logoUrl: %v", err)
+	}
+	sanitize.Lines(sanitize.MaxName, &receipt.TransactionID, &receipt.CustomerName, &receipt.Date, (*string)(&receipt.Location),
+		&receipt.PaymentType, &receipt.TerminalId, &receipt.AccountId, &receipt.AccountName, &receipt.Type,
+		&receipt.OriginalTransactionID, &receipt.RefundMethod, &receipt.Language, &receipt.OperatorName, &receipt.StationID,
+		&receipt.CardDetails.CardBrand, &receipt.CardDetails.CardLast4, &receipt.CardDetails.AuthCode)
+	for i := range receipt.Items {
+		item := &receipt.Items[i]
+		sanitize.Lines(sanitize.MaxName, &item.Name, &item.SKU, &item.TaxCode, &item.ItemType, &item.StartDate, &item.DueDate, &item.RateUnit)
+	}
+	for i := range receipt.Payments {
+		p := &receipt.Payments[i]
+		sanitize.Lines(sanitize.MaxName, &p.Type, &p.CardDetails.CardBrand, &p.CardDetails.CardLast4, &p.CardDetails.AuthCode)
+	}
+	for i := range receipt.TaxBreakdown {
+		sanitize.Lines(sanitize.MaxName, &receipt.TaxBreakdown[i].Code, &receipt.TaxBreakdown[i].Name)
+	}
+	for i := range receipt.GLCodeSummary {
+		sanitize.Lines(sanitize.MaxName, &receipt.GLCodeSummary[i].Code, &receipt.GLCodeSummary[i].Description)
+	}
+	messages.Clean(receipt.HeaderMessages)
+	messages.Clean(receipt.FooterMessages)
+	coupons.Clean(receipt.Coupons)
+	return nil
+}
+
+// Helper function to check whether a receipt has loyalty points to
+// summarize
+func hasLoyalty(receipt ReceiptData) bool {
+	return receipt.LoyaltyPointsEarned != 0 || receipt.LoyaltyPointsRedeemed != 0 || receipt.LoyaltyPointsBalance != 0
+}
+
+// Helper function to draw the QR codes of custom messages
+func messageBlocks(blocks []messages.Block) []MessageBlock {
+	var rendered []MessageBlock
+	for _, b := range blocks {
+		block := MessageBlock{Block: b}
+		if b.QR != "" {
+			url, _ := qr.DataURL(b.QR) // Validated with the request
+			block.QRImage = template.URL(url)
+		}
+		rendered = append(rendered, block)
+	}
+	return rendered
+}
+
+// Template data structure for enhanced rendering
+type TemplateData struct {
+	ReceiptData
+	CleanDate          string
+	PaymentIcon        string
+	PaymentDisplay     string
+	ShowCardDetails    bool
+	CardDisplay        string
+	Tenders            []TenderLine
+	ShowTaxBreakdown   bool
+	TaxLines           []tax.Line
+	TaxSubtotals       []tax.Subtotal
+	VATTable           []tax.Subtotal
+	Merchant           merchant.Info
+	GLCodeTotal        money.Cents
+	RetailTotal        money.Cents
+	Deposits           []ReceiptItem
+	DepositTotal       money.Cents
+	DueBack            string
+	Header             []MessageBlock
+	Footer             []MessageBlock
+	CouponBlocks       []CouponBlock
+	HasLoyalty         bool // Any loyalty points to summarize
+	Paper              paper.Size
+	IsRefund           bool
+	RefundTotal        money.Cents
+	RefundMethodDisplay string
+}
+
+// Response structures
+type PrintResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// Set when the receipt printed but its amounts don't add up
+	Warning string `json:"warning,omitempty"`
+	// Every invalid field, when the receipt failed validation
+	Errors validate.Errors `json:"errors,omitempty"`
+	// Set when the printer was offline and the receipt will print once
+	// it is back
+	Spooled bool `json:"spooled,omitempty"`
+	// Set when the receipt printed with a DUPLICATE banner
+	Duplicate bool `json:"duplicate,omitempty"`
+	// The station's sequence number printed on the receipt, with
+	// ReceiptNumbers
+	ReceiptNumber int64 `json:"receiptNumber,omitempty"`
+	// What became of each copy, once printing was tried
+	Copies []CopyResult `json:"copies,omitempty"`
+}
+
+// What became of one copy of a receipt
+type CopyResult struct {
+	Copy   int    `json:"copy"`             // From 1
+	Status string `json:"status"`           // printed, spooled, failed, or skipped after an earlier copy failed
+	Method string `json:"method,omitempty"` // tcp, usb or serial; spool for copies kept for later
+	Error  string `json:"error,omitempty"`  // Why the copy didn't print
+}
+
+type HealthResponse struct {
+	Status    string   `json:"status"`
+	Printer   string   `json:"printer"`
+	Spooled   int      `json:"spooled"`          // Receipts waiting for the printer
+	Alerts    []string `json:"alerts,omitempty"` // paper_low, paper_out, cover_open, cutter_error or error, at the last status check
+	Timestamp string   `json:"timestamp"`
+	Version   string   `json:"version"`
+}
+
+type ErrorResponse struct {
+	Error   string          `json:"error"`
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Errors  validate.Errors `json:"errors,omitempty"` // Every invalid field, when a request failed validation
+}
+
+// Time clock punch recorded in the punch journal
+type Punch struct {
+	ID           string    `json:"id"`
+	EmployeeID   string    `json:"employeeId"`
+	EmployeeName string    `json:"employeeName"`
+	Action       string    `json:"action"` // "in" or "out"
+	Time         time.Time `json:"time"`
+	Note         string    `json:"note,omitempty"`
+}
+
+type PunchRequest struct {
+	EmployeeID   string `json:"employeeId"`
+	EmployeeName string `json:"employeeName"`
+	Action       string `json:"action"`
+	Note         string `json:"note"`
+}
+
+type PunchResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Punch   Punch  `json:"punch"`
+	Printed bool   `json:"printed"`
+	// Time worked since the matching clock-in, only set on clock-out
+	HoursWorked float64 `json:"hoursWorked,omitempty"`
+}
+
+// Global configuration
+var config Config
+
+// Server instance
+type Server struct {
+	config     Config
+	httpServer *http.Server
+	logger     *log.Logger
+	punchMu    sync.Mutex
+	reportMu   sync.Mutex
+	logos      *escpos.LogoCache
+	templates  *tmplcache.Cache
+	stopping   context.Context // Cancelled by Shutdown, ending print jobs still running
+	stop       context.CancelFunc
+	auditMu    sync.Mutex
+	audit      *audit.Log // nil until it opens
+	webhooks   *webhook.Dispatcher // nil when no receivers are configured
+	printLimiter *limits.RateLimiter // nil when print requests aren't rate limited
+	coupons    *coupons.Rotator    // nil when no coupons are configured
+	numbersMu  sync.Mutex
+	numbers    *sequence.Counters // nil until they open
+	recent     *dedupe.Window      // Receipts printed within the duplicate window
+	spoolMu    sync.Mutex
+	flushing   atomic.Bool
+	statusMu   sync.Mutex
+	statuses   map[string]printerState // Last status check of each thermal printer, by name
+}
+
+// Modern HTML Receipt Template - Updated to use the new design
+const receiptTemplate = `<!DOCTYPE html>
+<html lang="{{lang}}">
+<head>
+    <meta charset="UTF-8">
+    <title>{{t "receipt"}}</title>
+    <style>
+        @page {
+            size: {{.Paper.Width}}mm auto;
+            margin: 0;
+        }
+        
+        * {
+            box-sizing: border-box;
+        }
+        
+        body {
+            font-family: -webkit-system-font, "Segoe UI", Roboto, "Helvetica Neue", Arial, sans-serif;
+            padding: 12px;
+            margin: 0;
+            width: {{.Paper.PrintWidth}}mm;
+            font-size: 13px;
+            line-height: 1.4;
+            color: #1a1a1a;
+            background: #ffffff;
+            -webkit-font-smoothing: antialiased;
+            -moz-osx-font-smoothing: grayscale;
+        }
+        
+        .receipt-container {
+            width: 100%;
+            background: #ffffff;
+            border-radius: 8px;
+            overflow: hidden;
+        }
+        
+        /* Header Styles */
+        .header {
+            text-align: center;
+            margin-bottom: 20px;
+            padding-bottom: 16px;
+        }
+        
+        .header h1 {
+            font-size: 20px;
+            font-weight: 700;
+            margin: 0 0 12px 0;
+            color: #2563eb;
+            letter-spacing: -0.025em;
+        }
+        
+        .header .logo {
+            max-width: 100%;
+            max-height: 60px;
+            height: auto;
+            margin-bottom: 12px;
+            border-radius: 4px;
+        }
+        
+        .date-style {
+            font-size: 13px;
+            color: #6b7280;
+            margin-bottom: 6px;
+            font-weight: 500;
+        }
+        
+        .customer-name {
+            font-size: 13px;
+            margin-bottom: 6px;
+            color: #374151;
+            font-weight: 500;
+        }
+
+        .served-by {
+            font-size: 12px;
+            margin-bottom: 4px;
+            color: #6b7280;
+        }
+        
+        /* Modern Dividers */
+        .divider {
+            border: none;
+            height: 1px;
+            background: linear-gradient(90deg, transparent, #e5e7eb 20%, #e5e7eb 80%, transparent);
+            margin: 16px 0;
+        }
+        
+        .divider.dashed {
+            background: none;
+            border-top: 2px dashed #d1d5db;
+            margin: 18px 0;
+        }
+        
+        /* Transaction Type Badge */
+        .transaction-type {
+            background: linear-gradient(135deg, #f0f9ff 0%, #e0f2fe 100%);
+            border: 1px solid #bae6fd;
+            padding: 12px;
+            border-radius: 8px;
+            text-align: center;
+            margin-bottom: 16px;
+            box-shadow: 0 1px 3px rgba(0, 0, 0, 0.1);
+        }
+        
+        .transaction-type h3 {
+            margin: 0;
+            font-size: 13px;
+            font-weight: 600;
+            color: #0369a1;
+        }
+        
+        /* Refund Banner */
+        .refund-banner {
+            background: #fef2f2;
+            border: 2px solid #dc2626;
+            padding: 12px;
+            border-radius: 8px;
+            text-align: center;
+            margin-bottom: 16px;
+        }
+        
+        .refund-banner h2 {
+            margin: 0;
+            font-size: 20px;
+            font-weight: 800;
+            color: #dc2626;
+            letter-spacing: 0.1em;
+        }
+        
+        .refund-banner .original-ref {
+            font-size: 12px;
+            color: #374151;
+            margin-top: 6px;
+        }
+        
+        .due-back-banner {
+            border: 2px solid #111827;
+            padding: 12px;
+            border-radius: 8px;
+            text-align: center;
+            margin-bottom: 16px;
+            font-size: 16px;
+            font-weight: 800;
+            letter-spacing: 0.05em;
+        }
+        
+        /* Section Headers */
+        .section-header {
+            font-size: 14px;
+            font-weight: 700;
+            margin: 0 0 12px 0;
+            color: #111827;
+            text-transform: uppercase;
+            letter-spacing: 0.025em;
+        }
+        
+        /* Items Section */
+        .items-section {
+            margin-bottom: 16px;
+        }
+        
+        .item {
+            margin-bottom: 16px;
+            padding: 12px;
+            background: #f9fafb;
+            border-radius: 6px;
+            border-left: 3px solid #3b82f6;
+        }
+        
+        .item-name {
+            font-weight: 600;
+            font-size: 13px;
+            margin-bottom: 4px;
+            color: #111827;
+        }
+        
+        .item-details {
+            display: flex;
+            justify-content: space-between;
+            padding-left: 8px;
+            font-size: 12px;
+            color: #6b7280;
+            margin-bottom: 2px;
+        }
+        
+        .item-sku {
+            padding-left: 8px;
+            font-size: 11px;
+            color: #9ca3af;
+            font-family: "SF Mono", "Monaco", "Inconsolata", "Roboto Mono", monospace;
+        }
+        
+        /* Totals Section */
+        .totals-section {
+            margin-bottom: 16px;
+            background: #f8fafc;
+            padding: 16px;
+            border-radius: 8px;
+            border: 1px solid #e2e8f0;
+        }
+        
+        .total-line {
+            display: flex;
+            justify-content: space-between;
+            margin-bottom: 8px;
+            font-size: 13px;
+            color: #374151;
+        }
+        
+        .total-line:last-child {
+            margin-bottom: 0;
+        }
+        
+        .tax-breakdown {
+            margin-left: 20px;
+            font-size: 11px;
+            color: #6b7280;
+            margin-bottom: 8px;
+            padding: 8px;
+            background: #ffffff;
+            border-radius: 4px;
+            border-left: 2px solid #d1d5db;
+        }
+        
+        .final-total {
+            background: linear-gradient(135deg, #1e40af 0%, #3b82f6 100%);
+            color: white;
+            padding: 12px 16px;
+            border-radius: 8px;
+            font-weight: 700;
+            display: flex;
+            justify-content: space-between;
+            font-size: 16px;
+            margin-top: 12px;
+            box-shadow: 0 4px 6px rgba(59, 130, 246, 0.2);
+        }
+        
+        /* Payment Section */
+        .payment-section {
+            background: #ffffff;
+            padding: 16px;
+            border-radius: 8px;
+            border: 1px solid #e5e7eb;
+            margin-bottom: 16px;
+        }
+        
+        .payment-section h3 {
+            font-size: 14px;
+            font-weight: 700;
+            margin: 0 0 12px 0;
+            color: #111827;
+            text-transform: uppercase;
+            letter-spacing: 0.025em;
+        }
+        
+        .payment-line {
+            display: flex;
+            justify-content: space-between;
+            margin-bottom: 6px;
+            font-size: 13px;
+            color: #374151;
+        }
+        
+        .payment-line:last-child {
+            margin-bottom: 0;
+        }
+        
+        .payment-method {
+            font-weight: 600;
+            color: #059669;
+        }
+        
+        .cash-details {
+            background: linear-gradient(135deg, #f0fdf4 0%, #ecfdf5 100%);
+            border: 1px solid #bbf7d0;
+            padding: 12px;
+            border-radius: 6px;
+            margin-top: 12px;
+        }
+        
+        /* Account Section */
+        .account-section {
+            background: #ffffff;
+            padding: 16px;
+            border-radius: 8px;
+            border: 1px solid #e5e7eb;
+            margin-bottom: 16px;
+        }
+        
+        .account-section h3 {
+            font-size: 14px;
+            font-weight: 700;
+            margin: 0 0 12px 0;
+            color: #111827;
+            text-transform: uppercase;
+            letter-spacing: 0.025em;
+        }
+        
+        .account-line {
+            display: flex;
+            justify-content: space-between;
+            margin-bottom: 6px;
+            font-size: 13px;
+            color: #374151;
+        }
+        
+        .account-line:last-child {
+            margin-bottom: 0;
+        }
+        
+        .section-subtotal {
+            border-top: 1px dashed #d1d5db;
+            padding-top: 6px;
+            font-weight: 700;
+        }
+        
+        .gl-subtotal {
+            border-top: 1px dashed #d1d5db;
+            padding-top: 6px;
+            font-weight: 700;
+        }
+        
+        .fully-settled {
+            color: #059669;
+            font-weight: 700;
+        }
+        
+        /* Merchant's legal details */
+        .legal-section {
+            text-align: center;
+            font-size: 11px;
+            color: #374151;
+            margin-bottom: 16px;
+        }
+        
+        .legal-name {
+            font-weight: 700;
+        }
+        
+        .vat-table {
+            width: 100%;
+            margin-top: 8px;
+            border-collapse: collapse;
+        }
+        
+        .vat-table th, .vat-table td {
+            text-align: right;
+            padding: 2px 4px;
+        }
+        
+        .vat-table td:first-child {
+            text-align: left;
+        }
+        
+        /* Custom messages */
+        .message {
+            text-align: center;
+            margin-bottom: 16px;
+            font-size: 12px;
+            color: #374151;
+        }
+        
+        .message-title {
+            font-weight: 700;
+            margin-bottom: 4px;
+        }
+        
+        .message img {
+            width: 30mm;
+            height: 30mm;
+            margin-top: 8px;
+        }
+        
+        /* Footer */
+        .footer {
+            text-align: center;
+            margin-top: 24px;
+            padding: 20px;
+            background: linear-gradient(135deg, #f8fafc 0%, #f1f5f9 100%);
+            border-radius: 8px;
+            border: 1px solid #e2e8f0;
+        }
+        
+        .footer-main {
+            font-weight: 700;
+            font-size: 15px;
+            margin-bottom: 8px;
+            color: #1e40af;
+        }
+        
+        .footer-sub {
+            font-size: 12px;
+            color: #6b7280;
+            font-weight: 500;
+        }
+        
+        /* Barcode Section */
+        .barcode-section {
+            text-align: center;
+            margin-top: 20px;
+            padding: 16px;
+            background: #ffffff;
+            border-radius: 8px;
+            border: 1px solid #e5e7eb;
+        }
+        
+        .transaction-id {
+            font-family: "SF Mono", "Monaco", "Inconsolata", "Roboto Mono", monospace;
+            font-size: 11px;
+            margin-top: 8px;
+            color: #6b7280;
+            font-weight: 500;
+            letter-spacing: 0.05em;
+        }
+        
+        /* Coupons */
+        .coupon {
+            text-align: center;
+            margin-top: 16px;
+            padding: 12px;
+            font-size: 12px;
+            border: 1px dashed #6b7280;
+            border-radius: 8px;
+        }
+        
+        .coupon img {
+            width: 100%;
+            height: 12mm;
+            margin-top: 8px;
+        }
+        
+        /* Status Colors */
+        .error-text {
+            color: #dc2626;
+            font-weight: 600;
+        }
+        
+        .success-text {
+            color: #059669;
+            font-weight: 600;
+        }
+        
+        /* Enhanced spacing and typography */
+        .amount {
+            font-family: "SF Mono", "Monaco", "Inconsolata", "Roboto Mono", monospace;
+            font-weight: 600;
+        }
+        
+        /* Payment emoji styling */
+        .payment-emoji {
+            font-size: 16px;
+            margin-right: 6px;
+        }
+        
+        /* Modern card styling */
+        .card-info {
+            background: linear-gradient(135deg, #fefefe 0%, #f8fafc 100%);
+            border: 1px solid #e2e8f0;
+            padding: 8px 12px;
+            border-radius: 6px;
+            margin: 4px 0;
+            font-family: "SF Mono", "Monaco", "Inconsolata", "Roboto Mono", monospace;
+            font-size: 12px;
+        }
+        
+        /* Responsive adjustments */
+        @media (max-width: 80mm) {
+            body {
+                padding: 8px;
+                font-size: 12px;
+            }
+            
+            .header h1 {
+                font-size: 18px;
+            }
+            
+            .final-total {
+                font-size: 14px;
+                padding: 10px 12px;
+            }
+        }
+    </style>
+</head>
+<body>
+    <div class="receipt-container">
+        <!-- Header -->
+        <div class="header">
+            {{if .LogoUrl}}
+                <img src="{{logoSrc .LogoUrl}}" alt="{{.Location}} logo" class="logo">
+            {{else}}
+                <h1>{{.Location}}</h1>
+            {{end}}
+            
+            <div class="date-style">{{.CleanDate}}</div>
+            
+            {{if .CustomerName}}
+                <div class="customer-name">{{t "customer"}}: {{.CustomerName}}</div>
+            {{end}}
+            {{if .OperatorName}}
+                <div class="served-by">{{t "served_by"}}: {{.OperatorName}}</div>
+            {{end}}
+            {{if .StationID}}
+                <div class="served-by">{{t "station"}}: {{.StationID}}</div>
+            {{end}}
+            {{if .ReceiptNumber}}
+                <div class="served-by">{{t "receipt_number"}}: {{printf "%06d" .ReceiptNumber}}</div>
+            {{end}}
+        </div>
+
+        <div class="divider dashed"></div>
+
+        <!-- Header Messages -->
+        {{template "messages" .Header}}
+
+        <!-- Refund Banner -->
+        {{if .IsRefund}}
+        <div class="refund-banner">
+            <h2>{{t "refund"}}</h2>
+            {{if .OriginalTransactionID}}
+            <div class="original-ref">{{t "original_transaction"}}: {{.OriginalTransactionID}}</div>
+            {{end}}
+        </div>
+        {{end}}
+
+        <!-- Due Back Banner -->
+        {{if and (not .IsRefund) .DueBack}}
+        <div class="due-back-banner">{{t "due_back_banner" .DueBack}}</div>
+        {{end}}
+
+        <!-- Transaction Type Indicator -->
+        {{if and (not .IsRefund) (or .IsSettlement .IsRetail .HasCombinedTransaction)}}
+        <div class="transaction-type">
+            <h3>
+                {{if .IsSettlement}}
+                    ✓ {{t "settlement_transaction"}}
+                {{else if .HasCombinedTransaction}}
+                    ✓ {{t "combined_transaction"}}
+                {{else}}
+                    ✓ {{t "retail_transaction"}}
+                {{end}}
+            </h3>
+        </div>
+        {{end}}
+
+        <!-- Items -->
+        <div class="items-section">
+            <h2 class="section-header">{{if .IsRefund}}{{t "returned_items"}}{{else if .HasCombinedTransaction}}{{t "retail_purchase"}}{{else}}{{t "items"}}{{end}}</h2>
+            {{range .Items}}{{if not .IsDeposit}}
+            <div class="item">
+                <div class="item-name">{{.Name}}</div>
+                <div class="item-details">
+                    <span>{{.Quantity}} × <span class="amount">{{money .Price}}</span>{{with .UnitKey}} / {{t .}}{{end}}</span>
+                    <span class="amount">{{if $.IsRefund}}-{{end}}{{money .LineTotal}}</span>
+                </div>
+                <div class="item-sku">{{t "sku"}}: {{.SKU}}</div>
+                {{with .TypeKey}}<div class="item-sku">{{t .}}</div>{{end}}
+                {{if .DueDate}}<div class="item-sku">{{if .StartDate}}{{t "out"}}: {{.Out}} {{end}}{{t "due_back"}}: {{.DueBack}}</div>{{end}}
+                {{if .TaxExempt}}<div class="item-sku">({{t "tax_exempt"}})</div>{{else if .TaxCode}}<div class="item-sku">{{t "tax_code"}}: {{.TaxCode}}</div>{{end}}
+            </div>
+            {{end}}{{end}}
+        </div>
+
+        <!-- Totals -->
+        <div class="totals-section">
+            <div class="total-line">
+                <span>{{t "subtotal"}}:</span>
+                <span class="amount">{{if .IsRefund}}-{{end}}{{money .Subtotal}}</span>
+            </div>
+
+            {{if gt .DiscountPercentage 0.0}}
+            <div class="total-line">
+                <span>{{t "discount"}} ({{printf "%.0f" .DiscountPercentage}}%):</span>
+                <span class="error-text amount">-{{money .DiscountAmount}}</span>
+            </div>
+            {{end}}
+
+            {{if gt .PromoAmount 0}}
+            <div class="total-line">
+                <span>{{t "promo_discount"}}:</span>
+                <span class="error-text amount">-{{money .PromoAmount}}</span>
+            </div>
+            {{end}}
+
+            <div class="total-line">
+                <span>{{t "tax"}}:</span>
+                <span class="amount">{{if .IsRefund}}-{{end}}{{money .Tax}}</span>
+            </div>
+
+            <!-- Tax Breakdown -->
+            {{if .ShowTaxBreakdown}}
+            <div class="tax-breakdown">
+                {{range .TaxLines}}
+                <div>{{.Label}}: <span class="amount">{{if $.IsRefund}}-{{end}}{{money .Amount}}</span></div>
+                {{end}}
+            </div>
+            {{end}}
+
+            {{if .TaxSubtotals}}
+            <div class="tax-breakdown">
+                {{range .TaxSubtotals}}
+                {{if .Exempt}}
+                <div>{{t "tax_exempt"}}{{if .Code}} ({{.Code}}){{end}}: <span class="amount">{{if $.IsRefund}}-{{end}}{{money .Amount}}</span></div>
+                {{else}}
+                <div>{{t "taxable"}} {{.Label}}: <span class="amount">{{if $.IsRefund}}-{{end}}{{money .Amount}}</span> ({{t "tax"}} <span class="amount">{{if $.IsRefund}}-{{end}}{{money .Tax}}</span>)</div>
+                {{end}}
+                {{end}}
+            </div>
+            {{end}}
+
+            {{if gt .Tip 0}}
+            <div class="total-line">
+                <span>{{t "tip"}}:</span>
+                <span class="amount">{{money .Tip}}</span>
+            </div>
+            {{end}}
+
+            {{if .HasCombinedTransaction}}
+            <div class="total-line section-subtotal">
+                <span>{{t "retail_subtotal"}}:</span>
+                <span class="amount">{{money .RetailTotal}}</span>
+            </div>
+            {{else if gt .SettlementAmount 0}}
+            <div class="total-line">
+                <span>{{t "account_settlement"}}:</span>
+                <span class="amount">{{money .SettlementAmount}}</span>
+            </div>
+            {{end}}
+        </div>
+
+        <!-- The settlement part of a combined transaction, as the POS shows it -->
+        {{if .HasCombinedTransaction}}
+        <div class="items-section">
+            <h2 class="section-header">{{t "account_settlement"}}</h2>
+            {{if .AccountId}}
+            <div class="total-line">
+                <span>{{t "account_id"}}:</span>
+                <span>{{.AccountId}}</span>
+            </div>
+            {{end}}
+            <div class="total-line">
+                <span>{{t "payment_on_account"}}:</span>
+                <span class="amount">{{money .SettlementAmount}}</span>
+            </div>
+            <div class="total-line section-subtotal">
+                <span>{{t "settlement_subtotal"}}:</span>
+                <span class="amount">{{money .SettlementAmount}}</span>
+            </div>
+        </div>
+        {{end}}
+
+        <!-- Total Amount -->
+        {{if .IsRefund}}
+        <div class="final-total" style="background: #dc2626;">
+            <span>{{t "refund_total"}}</span>
+            <span class="amount">-{{money .RefundTotal}}</span>
+        </div>
+        {{else}}
+        <div class="final-total">
+            <span>{{t "total"}}</span>
+            <span class="amount">{{money .Total}}</span>
+        </div>
+        {{end}}
+        {{range .ScriptedLines}}
+        <div class="total-line">
+            <span>{{.Label}}:</span>
+            <span>{{.Value}}</span>
+        </div>
+        {{end}}
+
+        <!-- Deposits are held, or returned on a refund -->
+        {{if .Deposits}}
+        <div class="items-section">
+            <h2 class="section-header">{{if .IsRefund}}{{t "deposits_returned"}}{{else}}{{t "held"}}{{end}}</h2>
+            {{range .Deposits}}
+            <div class="total-line">
+                <span>{{.Name}}</span>
+                <span class="amount">{{if $.IsRefund}}-{{end}}{{money .LineTotal}}</span>
+            </div>
+            {{end}}
+            <div class="total-line">
+                <span>{{if .IsRefund}}{{t "total_returned"}}{{else}}{{t "total_held"}}{{end}}:</span>
+                <span class="amount">{{if .IsRefund}}-{{end}}{{money .DepositTotal}}</span>
+            </div>
+        </div>
+        {{end}}
+
+        <div class="divider"></div>
+
+        <!-- Payment Information -->
+        <div class="payment-section">
+            <h3>{{if .IsRefund}}{{t "refund_details"}}{{else}}{{t "payment_details"}}{{end}}</h3>
+
+            {{if .IsRefund}}
+            <div class="payment-line">
+                <span>{{t "refunded_to"}}:</span>
+                <span class="payment-method">{{.RefundMethodDisplay}}</span>
+            </div>
+            {{else if .Tenders}}
+            {{range .Tenders}}
+            <div class="payment-line">
+                <span class="payment-method">
+                    <span class="payment-emoji">{{.Icon}}</span>{{.Display}}
+                </span>
+                <span class="amount">{{money .Amount}}</span>
+            </div>
+            {{if .Card}}
+            <div class="card-info">
+                <div class="payment-line" style="margin-bottom: 0;">
+                    <span>{{t "card"}}:</span>
+                    <span>{{.Card}}</span>
+                </div>
+            </div>
+            {{end}}
+            {{if .AuthCode}}
+            <div class="payment-line">
+                <span>{{t "auth_code"}}:</span>
+                <span>{{.AuthCode}}</span>
+            </div>
+            {{end}}
+            {{end}}
+            {{else}}
+            <div class="payment-line">
+                <span>{{t "payment_method"}}:</span>
+                <span class="payment-method">
+                    <span class="payment-emoji">{{.PaymentIcon}}</span>{{.PaymentDisplay}}
+                </span>
+            </div>
+            {{end}}
+
+            <!-- Card payment details -->
+            {{if .ShowCardDetails}}
+                {{if or .CardDetails.CardBrand .CardDetails.CardLast4}}
+                <div class="card-info">
+                    <div class="payment-line" style="margin-bottom: 0;">
+                        <span>{{t "card"}}:</span>
+                        <span>{{.CardDisplay}}</span>
+                    </div>
+                </div>
+                {{end}}
+
+                {{if .CardDetails.AuthCode}}
+                <div class="payment-line">
+                    <span>{{t "auth_code"}}:</span>
+                    <span>{{.CardDetails.AuthCode}}</span>
+                </div>
+                {{end}}
+
+                {{if .TerminalId}}
+                <div class="payment-line">
+                    <span>{{t "terminal_id"}}:</span>
+                    <span>{{.TerminalId}}</span>
+                </div>
+                {{end}}
+            {{end}}
+
+            {{if and (not .IsRefund) (eq .PaymentType "cash") (gt .CashGiven 0)}}
+            <div class="cash-details">
+                <div class="payment-line">
+                    <span>{{t "cash_given"}}:</span>
+                    <span class="amount">{{money .CashGiven}}</span>
+                </div>
+                <div class="payment-line">
+                    <span>{{t "change"}}:</span>
+                    <span class="amount">{{money .ChangeDue}}</span>
+                </div>
+            </div>
+            {{end}}
+        </div>
+
+        <!-- Loyalty points -->
+        {{if .HasLoyalty}}
+        <div class="account-section">
+            <h3>{{t "loyalty_points"}}</h3>
+            {{if .LoyaltyPointsEarned}}
+            <div class="account-line">
+                <span>{{t "points_earned"}}:</span>
+                <span>{{.LoyaltyPointsEarned}}</span>
+            </div>
+            {{end}}
+            {{if .LoyaltyPointsRedeemed}}
+            <div class="account-line">
+                <span>{{t "points_redeemed"}}:</span>
+                <span>-{{.LoyaltyPointsRedeemed}}</span>
+            </div>
+            {{end}}
+            <div class="account-line gl-subtotal">
+                <span>{{t "points_balance"}}:</span>
+                <span>{{.LoyaltyPointsBalance}}</span>
+            </div>
+        </div>
+        {{end}}
+
+        <!-- Account Information -->
+        {{if .AccountId}}
+        <div class="account-section">
+            <h3>{{t "account_information"}}</h3>
+
+            <div class="account-line">
+                <span>{{t "account_id"}}:</span>
+                <span>{{.AccountId}}</span>
+            </div>
+
+            {{if .AccountName}}
+            <div class="account-line">
+                <span>{{t "account_name"}}:</span>
+                <span>{{.AccountName}}</span>
+            </div>
+            {{end}}
+
+            {{if or .IsSettlement .HasCombinedTransaction}}
+            <div class="account-line">
+                <span>{{t "previous_balance"}}:</span>
+                <span class="amount">{{money .AccountBalanceBefore}}</span>
+            </div>
+
+            <div class="account-line">
+                <span>{{t "new_balance"}}:</span>
+                <span {{if eq .AccountBalanceAfter 0}}class="fully-settled"{{end}}>
+                    <span class="amount">{{money .AccountBalanceAfter}}</span>{{if eq .AccountBalanceAfter 0}} ({{t "fully_settled"}}){{end}}
+                </span>
+            </div>
+            {{end}}
+        </div>
+        {{end}}
+
+        <!-- GL code summary, for the bookkeeper -->
+        {{if .GLCodeSummary}}
+        <div class="account-section">
+            <h3>{{t "gl_summary"}}</h3>
+            {{range .GLCodeSummary}}
+            <div class="account-line">
+                <span>{{.Code}}{{with .Description}} &middot; {{.}}{{end}}</span>
+                <span class="amount">{{money .Amount}}</span>
+            </div>
+            {{end}}
+            <div class="account-line gl-subtotal">
+                <span>{{t "subtotal"}}:</span>
+                <span class="amount">{{money .GLCodeTotal}}</span>
+            </div>
+        </div>
+        {{end}}
+
+        <!-- Merchant's legal details -->
+        {{if or (not .Merchant.Empty) .VATTable}}
+        <div class="legal-section">
+            {{with .Merchant.LegalName}}<div class="legal-name">{{.}}</div>{{end}}
+            {{range .Merchant.Address}}<div>{{.}}</div>{{end}}
+            {{range .Merchant.Registrations}}<div>{{.Label}}: {{.Number}}</div>{{end}}
+            {{if .VATTable}}
+            <table class="vat-table">
+                <tr><th></th><th>{{t "net"}}</th><th>{{t "tax"}}</th><th>{{t "gross"}}</th></tr>
+                {{range .VATTable}}
+                <tr>
+                    <td>{{if .Exempt}}{{t "tax_exempt"}}{{if .Code}} ({{.Code}}){{end}}{{else}}{{.Label}}{{end}}</td>
+                    <td class="amount">{{if $.IsRefund}}-{{end}}{{money .Amount}}</td>
+                    <td class="amount">{{if $.IsRefund}}-{{end}}{{money .Tax}}</td>
+                    <td class="amount">{{if $.IsRefund}}-{{end}}{{money .Gross}}</td>
+                </tr>
+                {{end}}
+            </table>
+            {{end}}
+        </div>
+        {{end}}
+
+        <!-- Footer -->
+        <div class="footer">
+            {{if .IsRefund}}
+            <div class="footer-main">{{t "refund_processed"}}</div>
+            <div class="footer-sub">{{t "keep_receipt"}}</div>
+            {{template "messages" .Footer}}
+            {{else if .Footer}}
+            {{template "messages" .Footer}}
+            {{else}}
+            <div class="footer-main">{{t "thank_you"}}</div>
+            <div class="footer-sub">{{t "visit_again" .Location}}</div>
+            {{end}}
+        </div>
+
+        <!-- Barcode/Transaction ID -->
+        <div class="barcode-section">
+            <div class="transaction-id">{{t "transaction"}}: {{.TransactionID}}</div>
+        </div>
+        {{range .CouponBlocks}}
+        <div class="coupon">
+            {{with .Title}}<div class="message-title">{{.}}</div>{{end}}
+            {{range .Lines}}<div>{{.}}</div>{{end}}
+            {{with .BarcodeImage}}<img src="{{.}}" alt="">{{end}}
+            {{with .Barcode}}<div class="transaction-id">{{.}}</div>{{end}}
+            {{with .Expiry}}<div>{{t "coupon_expires" .}}</div>{{end}}
+        </div>
+        {{end}}
+    </div>
+</body>
+</html>
+{{define "messages"}}{{range .}}
+        <div class="message">
+            {{with .Title}}<div class="message-title">{{.}}</div>{{end}}
+            {{range .Lines}}<div>{{.}}</div>{{end}}
+            {{with .QRImage}}<img src="{{.}}" alt="">{{end}}
+        </div>
+{{end}}{{end}}`
+
+// NewServer creates a new server instance
+func NewServer(cfg Config) *Server {
+	logger := log.New(os.Stdout, "[RECEIPT-SERVER] ", log.LstdFlags|log.Lshortfile)
+	
+	s := &Server{
+		config: cfg,
+		logger: logger,
+		logos:  escpos.NewLogoCache(filepath.Join(cfg.DataDir, "logos")),
+	}
+	s.templates = tmplcache.New("", func(string) (string, error) { return receiptTemplate, nil }, s.templateFuncs)
+	s.stopping, s.stop = context.WithCancel(context.Background())
+	if len(cfg.Webhooks.Endpoints) > 0 {
+		s.webhooks = webhook.New(cfg.Webhooks)
+	}
+	s.recent = dedupe.New(cfg.DuplicateWindow, cfg.DuplicateAction)
+	s.printLimiter = limits.NewRateLimiter(cfg.PrintRateLimit, cfg.PrintBurst)
+	if len(cfg.Coupons.Coupons) > 0 {
+		s.coupons = coupons.NewRotator(cfg.Coupons)
+	}
+	return s
+}
+
+// Template functions of the HTML receipt in a language
+func (s *Server) templateFuncs(lang string) template.FuncMap {
+	tr := i18n.New(lang)
+	funcs := tmplfuncs.New(s.config.Currency)
+	funcs["t"] = tr.T
+	funcs["lang"] = tr.Language
+	funcs["logoSrc"] = logoSrc
+	return funcs
+}
+
+// CORS middleware
+func (s *Server) enableCORS(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+operatorHeader+", "+tracing.Header)
+	w.Header().Set("Access-Control-Expose-Headers", tracing.Header+", Deprecation, Link")
+}
+
+// Logging middleware
+func (s *Server) loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		
+		// Create a response writer wrapper to capture status code
+		wrapper := &responseWriterWrapper{ResponseWriter: w, statusCode: 200}
+		
+		next.ServeHTTP(wrapper, r)
+		
+		duration := time.Since(start)
+		s.logf(r.Context(), "%s %s %d %v %s", 
+			r.Method, 
+			r.URL.Path, 
+			wrapper.statusCode, 
+			duration,
+			r.RemoteAddr,
+		)
+	}
+}
+
+// Response writer wrapper to capture status code
+type responseWriterWrapper struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (rw *responseWriterWrapper) WriteHeader(code int) {
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Helper function to send JSON responses
+func (s *Server) sendJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	if id := w.Header().Get(tracing.Header); id != "" && statusCode >= 400 {
+		data = withRequestID(data, id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		s.logger.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// Helper function to send error responses
+func (s *Server) sendErrorResponse(w http.ResponseWriter, statusCode int, message string) {
+	s.sendJSONResponse(w, statusCode, ErrorResponse{
+		Error:   http.StatusText(statusCode),
+		Code:    statusCode,
+		Message: message,
+	})
+}
+
+// Helper function to send a request's invalid fields
+func (s *Server) sendValidationError(w http.ResponseWriter, errs validate.Errors) {
+	s.sendJSONResponse(w, http.StatusBadRequest, ErrorResponse{
+		Error:   http.StatusText(http.StatusBadRequest),
+		Code:    http.StatusBadRequest,
+		Message: errs.Error(),
+		Errors:  errs,
+	})
+}
+
+// The most copies one request may print
+const maxCopies = 20
+
+// Helper function to reject a copies count over maxCopies
+func copiesError(copies int) validate.Errors {
+	if copies > maxCopies {
+		return validate.Errors{{Field: "copies", Message: fmt.Sprintf("can't be more than %d (%d)", maxCopies, copies)}}
+	}
+	return nil
+}
+
+// Helper function to get payment emoji
+func getPaymentEmoji(paymentType string) string {
+	paymentEmojis := map[string]string{
+		"cash":    "💵",
+		"credit":  "💳",
+		"debit":   "💳",
+		"account": "📒",
+		"cheque":  "🧾",
+	}
+	
+	baseType := strings.Split(paymentType, "-")[0]
+	if emoji, exists := paymentEmojis[baseType]; exists {
+		return emoji
+	}
+	return "💰"
+}
+
+// Helper function to split a combined transaction: whatever isn't the
+// settlement was spent on the retail purchase
+func retailTotal(receipt ReceiptData) money.Cents {
+	if !receipt.HasCombinedTransaction {
+		return 0
+	}
+	return receipt.Total - receipt.SettlementAmount
+}
+
+// Helper function to format payment type display
+func formatPaymentType(paymentType string, isSettlement, hasCombinedTransaction bool) string {
+	baseType := strings.Split(paymentType, "-")[0]
+	displayType := strings.Title(baseType)
+	
+	if hasCombinedTransaction {
+		return displayType + " (Combined Transaction)"
+	} else if isSettlement {
+		return displayType + " (Account Settlement)"
+	}
+	return displayType
+}
+
+// Helper function to format an amount in the configured currency
+func (s *Server) money(amount money.Cents) string {
+	return s.config.Currency.FormatCents(amount)
+}
+
+// Helper function to check that subtotal + tax + tip adds up to the total,
+// and that split payments add up to it. No-sale, refund and settlement receipts follow other rules and aren't
+// checked.
+func totalsWarning(receipt ReceiptData) string {
+	if receipt.Type == "noSale" || receipt.Type == "refund" || receipt.IsSettlement || receipt.HasCombinedTransaction {
+		return ""
+	}
+	var warnings []string
+	if diff, ok := money.CheckTotal(receipt.Subtotal, receipt.DiscountAmount+receipt.PromoAmount,
+		receipt.Tax, receipt.Tip, receipt.Total); !ok {
+		warnings = append(warnings, fmt.Sprintf("total %s does not match subtotal %s + tax %s + tip %s (off by %s)",
+			receipt.Total, receipt.Subtotal, receipt.Tax, receipt.Tip, diff))
+	}
+	if len(receipt.Payments) > 0 {
+		tenders := make([]money.Cents, len(receipt.Payments))
+		for i, p := range receipt.Payments {
+			tenders[i] = p.Amount
+		}
+		if diff, ok := money.CheckTendered(receipt.Total, tenders...); !ok {
+			warnings = append(warnings, fmt.Sprintf("payments add up to %s, not the total %s", receipt.Total+diff, receipt.Total))
+		}
+	}
+	return strings.Join(warnings, "; ")
+}
+
+// Helper function to pick the translator for a receipt: the request's
+// language if given, otherwise the configured one
+func (s *Server) translator(receipt ReceiptData) i18n.Translator {
+	return s.translatorFor(receipt.Language)
+}
+
+// Helper function to pick the translator for a requested language,
+// falling back to the configured one
+func (s *Server) translatorFor(lang string) i18n.Translator {
+	if lang != "" {
+		return i18n.New(lang)
+	}
+	return i18n.New(s.config.Language)
+}
+
+// Helper function to compute the tax breakdown lines for a receipt.
+// An explicit taxBreakdown from the frontend always wins; otherwise the
+// configured rates are applied per item tax code (or to the subtotal).
+func (s *Server) taxLines(receipt ReceiptData) []tax.Line {
+	if len(receipt.TaxBreakdown) > 0 {
+		return receipt.TaxBreakdown
+	}
+	if receipt.IsSettlement || receipt.SkipTaxCalculation || receipt.HasNoTax {
+		return nil
+	}
+	return s.config.Tax.Breakdown(taxBases(receipt))
+}
+
+// Helper function to break the tax down by tax code, for receipts that
+// mix codes or exempt items and whose tax the server computed
+func (s *Server) taxSubtotals(receipt ReceiptData) []tax.Subtotal {
+	if len(receipt.TaxBreakdown) > 0 || receipt.IsSettlement || receipt.SkipTaxCalculation || receipt.HasNoTax {
+		return nil
+	}
+	if s.config.Merchant.ShowTable() {
+		// The VAT table replaces them
+		return nil
+	}
+	bases := taxBases(receipt)
+	if len(bases) < 2 && !bases[0].Exempt {
+		return nil
+	}
+	return s.config.Tax.Subtotals(bases)
+}
+
+// Helper function to compute the net / tax / gross table printed in the
+// legal block when the merchant config asks for one, with a row per tax
+// code even when there is only one
+func (s *Server) vatTable(receipt ReceiptData) []tax.Subtotal {
+	if !s.config.Merchant.ShowTable() || len(receipt.TaxBreakdown) > 0 ||
+		receipt.IsSettlement || receipt.SkipTaxCalculation || receipt.HasNoTax {
+		return nil
+	}
+	return s.config.Tax.Subtotals(taxBases(receipt))
+}
+
+// Helper function to group the taxable amounts by item tax code, with
+// exempt items in a base of their own. Receipts without item tax codes
+// are taxed on the subtotal so discounts are honoured.
+func taxBases(receipt ReceiptData) []tax.Base {
+	hasCodes := false
+	var exempt money.Cents
+	for _, item := range receipt.Items {
+		if item.TaxExempt || item.IsDeposit() {
+			exempt += item.Price.Times(float64(item.Quantity))
+		} else if item.TaxCode != "" {
+			hasCodes = true
+		}
+	}
+	if !hasCodes {
+		if exempt == 0 {
+			return []tax.Base{{Amount: receipt.Subtotal}}
+		}
+		return []tax.Base{{Amount: max(receipt.Subtotal-exempt, 0)}, {Amount: exempt, Exempt: true}}
+	}
+	
+	var bases []tax.Base
+	index := make(map[tax.Base]int)
+	for _, item := range receipt.Items {
+		key := tax.Base{Code: item.TaxCode}
+		if item.TaxExempt || item.IsDeposit() {
+			key = tax.Base{Exempt: true}
+		}
+		amount := item.Price.Times(float64(item.Quantity))
+		if i, ok := index[key]; ok {
+			bases[i].Amount += amount
+			continue
+		}
+		index[key] = len(bases)
+		key.Amount = amount
+		bases = append(bases, key)
+	}
+	return bases
+}
+
+// Helper function to describe who rang up a receipt for the log, e.g.
+// " (Alice at Till 2)"; empty when the frontend didn't say
+func servedBy(receipt ReceiptData) string {
+	switch {
+	case receipt.OperatorName != "" && receipt.StationID != "":
+		return fmt.Sprintf(" (%s at %s)", receipt.OperatorName, receipt.StationID)
+	case receipt.OperatorName != "":
+		return fmt.Sprintf(" (%s)", receipt.OperatorName)
+	case receipt.StationID != "":
+		return fmt.Sprintf(" (at %s)", receipt.StationID)
+	}
+	return ""
+}
+
+// Helper function to resolve the refunded amount and where it went
+func refundDetails(receipt ReceiptData) (money.Cents, string) {
+	// Prefer the explicit refund amount; older frontends only send the total
+	total := receipt.RefundAmount
+	if total <= 0 {
+		total = receipt.Total
+	}
+	
+	method := receipt.RefundMethod
+	if method == "" {
+		method = receipt.PaymentType
+	}
+	return total, strings.Title(method)
+}
+
+// Enhanced thermal printer function with better error handling. The
+// results say what became of each copy, also when printing stopped early.
+func (s *Server) sendToThermalPrinter(ctx context.Context, receipt ReceiptData, copies int) ([]CopyResult, error) {
+	textContent := s.formatReceiptForThermalPrinter(receipt)
+	results := make([]CopyResult, copies)
+	for i := range results {
+		results[i] = CopyResult{Copy: i + 1, Status: "skipped"}
+	}
+	
+	printerAddress, err := s.resolvePrinterAddress()
+	if err != nil {
+		results[0].Status, results[0].Error = "failed", err.Error()
+		return results, &printerOfflineError{content: textContent, copies: copies, err: err}
+	}
+	method := addressTransport(printerAddress)
+	
+	// Print each copy
+	for i := 1; i <= copies; i++ {
+		results[i-1].Method = method
+		if err := s.printSingleCopy(ctx, printerAddress, textContent, i); err != nil {
+			results[i-1].Status, results[i-1].Error = "failed", err.Error()
+			err = fmt.Errorf("failed to print copy %d: %w", i, err)
+			if ctx.Err() != nil {
+				return results, err
+			}
+			return results, &printerOfflineError{content: textContent, copies: copies - i + 1, err: err}
+		}
+		results[i-1].Status = "printed"
+		
+		s.logf(ctx, "✓ Copy %d sent to printer successfully", i)
+		
+		// Small delay between copies
+		if i < copies {
+			if err := waitContext(ctx, time.Second); err != nil {
+				return results, fmt.Errorf("printing cancelled after copy %d: %w", i, err)
+			}
+		}
+	}
+	
+	return results, nil
+}
+
+// Helper function to count the copies with a status
+func countCopies(results []CopyResult, status string) int {
+	n := 0
+	for _, result := range results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Resolve the receipt printer's address
+func (s *Server) resolvePrinterAddress() (string, error) {
+	return s.resolvePrinterHost(s.printerHost("receipt", s.config.PrinterIP))
+}
+
+// Resolve a printer host, looking up host names such as ESDPRT001
+func (s *Server) resolvePrinterHost(host string) (string, error) {
+	if isAttachedAddress(host) {
+		return host, nil
+	}
+	printerAddress := host
+	if !strings.Contains(printerAddress, ".") {
+		ips, err := net.LookupIP(printerAddress)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve printer name '%s': %v", printerAddress, err)
+		}
+		if len(ips) > 0 {
+			printerAddress = ips[0].String()
+			s.logger.Printf("Resolved %s to %s", host, printerAddress)
+		}
+	}
+	return printerAddress, nil
+}
+
+// Print single copy on the receipt printer
+func (s *Server) printSingleCopy(ctx context.Context, printerAddress, content string, copyNum int) error {
+	return s.sendToPrinter(ctx, joinPrinterAddress(printerAddress, s.config.PrinterPort), content)
+}
+
+// Send raw ESC/POS data to a printer with timeout and retry logic. A
+// cancelled ctx (the client hung up, or the server is shutting down)
+// stops the dial, the write and the waits between attempts.
+func (s *Server) sendToPrinter(ctx context.Context, address, content string) error {
+	if ok, err := s.sendToAttached(ctx, address, content); ok {
+		return err
+	}
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	// Attempt with retry
+	for attempt := 1; attempt <= 3; attempt++ {
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("print cancelled: %w", ctx.Err())
+			}
+			if attempt == 3 {
+				return fmt.Errorf("failed to connect after %d attempts: %v", attempt, err)
+			}
+			s.logf(ctx, "Connection attempt %d failed, retrying...", attempt)
+			if err := waitContext(ctx, time.Duration(attempt)*time.Second); err != nil {
+				return fmt.Errorf("print cancelled: %w", err)
+			}
+			continue
+		}
+		defer conn.Close()
+		// Closing the connection ends a write in progress
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		defer stop()
+
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		
+		_, err = conn.Write([]byte(content))
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("print cancelled: %w", ctx.Err())
+			}
+			if attempt == 3 {
+				return fmt.Errorf("failed to send data after %d attempts: %v", attempt, err)
+			}
+			s.logf(ctx, "Send attempt %d failed, retrying...", attempt)
+			conn.Close()
+			if err := waitContext(ctx, time.Duration(attempt)*time.Second); err != nil {
+				return fmt.Errorf("print cancelled: %w", err)
+			}
+			continue
+		}
+		
+		return nil // Success
+	}
+	
+	return fmt.Errorf("max retry attempts exceeded")
+}
+
+// Helper function to wait for d, returning ctx's error early if it is
+// cancelled first
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enhanced thermal printer formatting
+func (s *Server) formatReceiptForThermalPrinter(receipt ReceiptData) string {
+	builder := s.newThermalBuilder(s.commandSet("receipt"))
+	tr := s.translator(receipt)
+	
+	// Header
+	builder.Align(escpos.AlignCenter)
+	if receipt.LogoUrl != "" {
+		// A logo that can't be fetched shouldn't stop the receipt printing
+		if logo, err := s.logoRaster(receipt.LogoUrl, s.logoWidth()); err != nil {
+			s.logger.Printf("Printing receipt without logo: %v", err)
+		} else {
+			builder.Raster(logo)
+			builder.WriteString("\n")
+		}
+	}
+	builder.Bold(true)
+	
+	location := string(receipt.Location)
+	if location == "" {
+		location = tr.T("store")
+	}
+	builder.WriteString(fmt.Sprintf("%s\n", location))
+	builder.Bold(false)
+	
+	// Date formatting
+	date := receipt.Date
+	if date == "" {
+		date = time.Now().Format("2006-01-02 15:04:05")
+	}
+	if len(date) > 16 {
+		date = date[:16]
+	}
+	builder.WriteString(fmt.Sprintf("%s\n", date))
+	
+	if receipt.CustomerName != "" {
+		builder.WriteString(fmt.Sprintf("%s: %s\n", tr.T("customer"), receipt.CustomerName))
+	}
+	if receipt.OperatorName != "" {
+		builder.WriteString(fmt.Sprintf("%s: %s\n", tr.T("served_by"), receipt.OperatorName))
+	}
+	if receipt.StationID != "" {
+		builder.WriteString(fmt.Sprintf("%s: %s\n", tr.T("station"), receipt.StationID))
+	}
+	if receipt.ReceiptNumber != 0 {
+		builder.WriteString(fmt.Sprintf("%s: %06d\n", tr.T("receipt_number"), receipt.ReceiptNumber))
+	}
+	
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString(divider("=", s.paper()))
+	
+	// Custom header messages
+	if header := messages.Pick(receipt.HeaderMessages, s.config.Messages.Header); len(header) > 0 {
+		s.writeMessages(builder, header)
+		builder.WriteString("\n")
+	}
+	
+	isRefund := receipt.Type == "refund"
+	refundTotal, refundMethod := refundDetails(receipt)
+	
+	// Duplicate banner, so a second copy of a sale isn't counted twice
+	if receipt.Duplicate {
+		builder.Align(escpos.AlignCenter)
+		builder.Size(2, 2)
+		builder.WriteString("*** " + tr.T("duplicate") + " ***\n")
+		builder.Size(1, 1)
+		builder.Align(escpos.AlignLeft)
+		builder.WriteString("\n")
+	}
+	
+	// Refund banner
+	if isRefund {
+		builder.Align(escpos.AlignCenter)
+		builder.Size(2, 2)
+		builder.WriteString(tr.T("refund") + "\n")
+		builder.Size(1, 1)
+		if receipt.OriginalTransactionID != "" {
+			builder.WriteString(fmt.Sprintf("%s: %s\n", tr.T("original_transaction"), receipt.OriginalTransactionID))
+		}
+		builder.Align(escpos.AlignLeft)
+		builder.WriteString("\n")
+	}
+	
+	// Due-back banner, so the receipt doubles as the return reminder
+	if dueBack := earliestDueBack(receipt.Items); !isRefund && dueBack != "" {
+		builder.Align(escpos.AlignCenter)
+		builder.Size(1, 2) // Double height; the date doesn't fit at double width
+		builder.WriteString(tr.T("due_back_banner", dueBack) + "\n")
+		builder.Size(1, 1)
+		builder.Align(escpos.AlignLeft)
+		builder.WriteString("\n")
+	}
+	
+	// Transaction type
+	if !isRefund && (receipt.IsSettlement || receipt.IsRetail || receipt.HasCombinedTransaction) {
+		builder.Align(escpos.AlignCenter)
+		if receipt.IsSettlement {
+			builder.WriteString("✓ " + tr.T("settlement_transaction") + "\n")
+		} else if receipt.HasCombinedTransaction {
+			builder.WriteString("✓ " + tr.T("combined_transaction") + "\n")
+		} else {
+			builder.WriteString("✓ " + tr.T("retail_transaction") + "\n")
+		}
+		builder.Align(escpos.AlignLeft)
+		builder.WriteString("\n")
+	}
+	
+	// Items
+	builder.Bold(true)
+	if isRefund {
+		builder.WriteString(strings.ToUpper(tr.T("returned_items")) + "\n")
+	} else if receipt.HasCombinedTransaction {
+		builder.WriteString(strings.ToUpper(tr.T("retail_purchase")) + "\n")
+	} else {
+		builder.WriteString(strings.ToUpper(tr.T("items")) + "\n")
+	}
+	builder.Bold(false)
+	
+	// Refunded amounts are printed as negatives
+	sign := ""
+	if isRefund {
+		sign = "-"
+	}
+	
+	for _, item := range receipt.Items {
+		if item.IsDeposit() {
+			continue
+		}
+		itemTotal := item.Price.Times(float64(item.Quantity))
+		
+		builder.Bold(true)
+		builder.WriteString(fmt.Sprintf("%s\n", item.Name))
+		builder.Bold(false)
+		
+		price := s.money(item.Price)
+		if key := item.UnitKey(); key != "" {
+			price += " / " + tr.T(key)
+		}
+		builder.WriteString(s.formatReceiptLine(
+			fmt.Sprintf("  %s x %s", item.Quantity, price),
+			sign + s.money(itemTotal),
+		))
+		
+		if item.SKU != "" {
+			builder.WriteString(fmt.Sprintf("  %s: %s\n", tr.T("sku"), item.SKU))
+		}
+		if key := item.TypeKey(); key != "" {
+			builder.WriteString(fmt.Sprintf("  %s\n", tr.T(key)))
+		}
+		if item.DueDate != "" {
+			if item.StartDate != "" {
+				builder.WriteString(fmt.Sprintf("  %s: %s\n", tr.T("out"), item.Out()))
+			}
+			builder.WriteString(fmt.Sprintf("  %s: %s\n", tr.T("due_back"), item.DueBack()))
+		}
+		if item.TaxExempt {
+			builder.WriteString(fmt.Sprintf("  (%s)\n", tr.T("tax_exempt")))
+		} else if item.TaxCode != "" {
+			builder.WriteString(fmt.Sprintf("  %s: %s\n", tr.T("tax_code"), item.TaxCode))
+		}
+		builder.WriteString("\n")
+	}
+	
+	builder.WriteString(divider("=", s.paper()))
+	
+	// Totals
+	builder.WriteString(s.formatReceiptLine(tr.T("subtotal")+":", sign + s.money(receipt.Subtotal)))
+	
+	if receipt.DiscountPercentage > 0 {
+		builder.WriteString(s.formatReceiptLine(
+			fmt.Sprintf("%s (%.0f%%):", tr.T("discount"), receipt.DiscountPercentage),
+			"-" + s.money(receipt.DiscountAmount),
+		))
+	}
+	
+	if receipt.PromoAmount > 0 {
+		builder.WriteString(s.formatReceiptLine(tr.T("promo_discount")+":", "-" + s.money(receipt.PromoAmount)))
+	}
+	
+	builder.WriteString(s.formatReceiptLine(tr.T("tax")+":", sign + s.money(receipt.Tax)))
+	
+	// Tax breakdown
+	for _, line := range s.taxLines(receipt) {
+		builder.WriteString(fmt.Sprintf("  %s: %s%s\n", line.Label(), sign, s.money(line.Amount)))
+	}
+	for _, st := range s.taxSubtotals(receipt) {
+		if st.Exempt {
+			label := tr.T("tax_exempt")
+			if st.Code != "" {
+				label += " (" + st.Code + ")"
+			}
+			builder.WriteString(s.formatReceiptLine("  "+label+":", sign+s.money(st.Amount)))
+			continue
+		}
+		builder.WriteString(s.formatReceiptLine("  "+tr.T("taxable")+" "+st.Label()+":", sign+s.money(st.Amount)))
+		builder.WriteString(s.formatReceiptLine("    "+tr.T("tax")+":", sign+s.money(st.Tax)))
+	}
+	
+	if receipt.Tip > 0 {
+		builder.WriteString(s.formatReceiptLine(tr.T("tip")+":", s.money(receipt.Tip)))
+	}
+	
+	if receipt.HasCombinedTransaction {
+		// The purchase and the settlement each get their own subtotal
+		builder.Bold(true)
+		builder.WriteString(s.formatReceiptLine(tr.T("retail_subtotal")+":", s.money(retailTotal(receipt))))
+		builder.Bold(false)
+		builder.WriteString(divider("-", s.paper()))
+		builder.Bold(true)
+		builder.WriteString(strings.ToUpper(tr.T("account_settlement")) + "\n")
+		builder.Bold(false)
+		if receipt.AccountId != "" {
+			builder.WriteString(s.formatReceiptLine(tr.T("account_id")+":", receipt.AccountId))
+		}
+		builder.WriteString(s.formatReceiptLine(tr.T("payment_on_account")+":", s.money(receipt.SettlementAmount)))
+		builder.Bold(true)
+		builder.WriteString(s.formatReceiptLine(tr.T("settlement_subtotal")+":", s.money(receipt.SettlementAmount)))
+		builder.Bold(false)
+	} else if receipt.SettlementAmount > 0 {
+		builder.WriteString(s.formatReceiptLine(tr.T("account_settlement")+":", s.money(receipt.SettlementAmount)))
+	}
+	
+	// Total
+	builder.WriteString("\n")
+	builder.Bold(true)
+	if isRefund {
+		builder.WriteString(s.formatReceiptLine(tr.T("refund_total")+":", "-" + s.money(refundTotal)))
+	} else {
+		builder.WriteString(s.formatReceiptLine(tr.T("total")+":", s.money(receipt.Total)))
+	}
+	builder.Bold(false)
+	for _, l := range receipt.ScriptedLines {
+		builder.WriteString(s.formatReceiptLine(l.Label+":", l.Value))
+	}
+	
+	builder.WriteString(divider("=", s.paper()))
+	
+	// Deposits are held, or returned on a refund
+	if deposits, depositTotal := receiptDeposits(receipt.Items); len(deposits) > 0 {
+		builder.Bold(true)
+		if isRefund {
+			builder.WriteString(strings.ToUpper(tr.T("deposits_returned")) + "\n")
+		} else {
+			builder.WriteString(strings.ToUpper(tr.T("held")) + "\n")
+		}
+		builder.Bold(false)
+		for _, item := range deposits {
+			builder.WriteString(s.formatReceiptLine(item.Name, sign+s.money(item.LineTotal)))
+		}
+		if isRefund {
+			builder.WriteString(s.formatReceiptLine(tr.T("total_returned")+":", sign+s.money(depositTotal)))
+		} else {
+			builder.WriteString(s.formatReceiptLine(tr.T("total_held")+":", s.money(depositTotal)))
+		}
+		builder.WriteString(divider("=", s.paper()))
+	}
+	
+	// Payment details
+	builder.WriteString("\n")
+	builder.Bold(true)
+	if isRefund {
+		builder.WriteString(tr.T("refund_details") + "\n")
+	} else {
+		builder.WriteString(tr.T("payment_details") + "\n")
+	}
+	builder.Bold(false)
+	
+	if isRefund {
+		builder.WriteString(s.formatReceiptLine(tr.T("refunded_to")+":", refundMethod))
+	} else if len(receipt.Payments) > 0 {
+		for _, tender := range tenderLines(receipt.Payments, tr) {
+			builder.WriteString(s.formatReceiptLine(fmt.Sprintf("%s %s", tender.Icon, tender.Display), s.money(tender.Amount)))
+			if tender.Card != "" {
+				builder.WriteString(s.formatReceiptLine("  "+tr.T("card")+":", tender.Card))
+			}
+			if tender.AuthCode != "" {
+				builder.WriteString(s.formatReceiptLine("  "+tr.T("auth_code")+":", tender.AuthCode))
+			}
+		}
+	} else {
+		paymentEmoji := getPaymentEmoji(receipt.PaymentType)
+		paymentDisplay := formatPaymentType(receipt.PaymentType, receipt.IsSettlement, receipt.HasCombinedTransaction)
+		builder.WriteString(s.formatReceiptLine(tr.T("payment_method")+":", fmt.Sprintf("%s %s", paymentEmoji, paymentDisplay)))
+	}
+	
+	// Card details; split payments carry them per tender
+	if len(receipt.Payments) == 0 && (strings.Contains(receipt.PaymentType, "credit") || strings.Contains(receipt.PaymentType, "debit")) {
+		if receipt.CardDetails.CardBrand != "" || receipt.CardDetails.CardLast4 != "" {
+			builder.WriteString(s.formatReceiptLine(tr.T("card")+":", cardText(receipt.CardDetails, tr)))
+		}
+		
+		if receipt.CardDetails.AuthCode != "" {
+			builder.WriteString(s.formatReceiptLine(tr.T("auth_code")+":", receipt.CardDetails.AuthCode))
+		}
+		
+		if receipt.TerminalId != "" {
+			builder.WriteString(s.formatReceiptLine(tr.T("terminal_id")+":", receipt.TerminalId))
+		}
+	}
+	
+	// Cash details
+	if !isRefund && receipt.PaymentType == "cash" && receipt.CashGiven > 0 {
+		builder.WriteString("\n--- " + tr.T("cash_details") + " ---\n")
+		builder.WriteString(s.formatReceiptLine(tr.T("cash")+":", s.money(receipt.CashGiven)))
+		builder.WriteString(s.formatReceiptLine(tr.T("change")+":", s.money(receipt.ChangeDue)))
+		builder.WriteString("----------------------\n")
+	}
+	
+	// Loyalty points
+	if hasLoyalty(receipt) {
+		builder.WriteString("\n")
+		builder.Bold(true)
+		builder.WriteString(tr.T("loyalty_points") + "\n")
+		builder.Bold(false)
+		if receipt.LoyaltyPointsEarned != 0 {
+			builder.WriteString(s.formatReceiptLine(tr.T("points_earned")+":", fmt.Sprint(receipt.LoyaltyPointsEarned)))
+		}
+		if receipt.LoyaltyPointsRedeemed != 0 {
+			builder.WriteString(s.formatReceiptLine(tr.T("points_redeemed")+":", fmt.Sprint(-receipt.LoyaltyPointsRedeemed)))
+		}
+		builder.Bold(true)
+		builder.WriteString(s.formatReceiptLine(tr.T("points_balance")+":", fmt.Sprint(receipt.LoyaltyPointsBalance)))
+		builder.Bold(false)
+	}
+	
+	// Account information
+	if receipt.AccountId != "" {
+		builder.WriteString("\n")
+		builder.Bold(true)
+		builder.WriteString(tr.T("account_information") + "\n")
+		builder.Bold(false)
+		
+		builder.WriteString(s.formatReceiptLine(tr.T("account_id")+":", receipt.AccountId))
+		if receipt.AccountName != "" {
+			builder.WriteString(s.formatReceiptLine(tr.T("account_name")+":", receipt.AccountName))
+		}
+		
+		if receipt.IsSettlement || receipt.HasCombinedTransaction {
+			builder.WriteString(s.formatReceiptLine(tr.T("previous_balance")+":", s.money(receipt.AccountBalanceBefore)))
+			
+			balanceText := s.money(receipt.AccountBalanceAfter)
+			if receipt.AccountBalanceAfter == 0 {
+				balanceText += " (" + tr.T("fully_settled") + ")"
+			}
+			builder.WriteString(s.formatReceiptLine(tr.T("new_balance")+":", balanceText))
+		}
+	}
+	
+	// GL code summary
+	if len(receipt.GLCodeSummary) > 0 {
+		builder.WriteString("\n")
+		builder.Bold(true)
+		builder.WriteString(tr.T("gl_summary") + "\n")
+		builder.Bold(false)
+		for _, l := range receipt.GLCodeSummary {
+			builder.WriteString(s.formatReceiptLine(strings.TrimSpace(l.Code+" "+l.Description), s.money(l.Amount)))
+		}
+		builder.WriteString(s.formatReceiptLine(tr.T("subtotal")+":", s.money(glcode.Total(receipt.GLCodeSummary))))
+	}
+	
+	// Merchant's legal details
+	s.writeLegal(builder, receipt, tr)
+	
+	builder.WriteString(divider("=", s.paper()))
+	
+	// Footer
+	builder.Align(escpos.AlignCenter)
+	builder.WriteString("\n")
+	builder.Bold(true)
+	footer := messages.Pick(receipt.FooterMessages, s.config.Messages.Footer)
+	if isRefund {
+		builder.WriteString(tr.T("refund_processed") + "\n")
+		builder.Bold(false)
+		builder.WriteString(tr.T("keep_receipt") + "\n")
+		if len(footer) > 0 {
+			builder.WriteString("\n")
+			s.writeMessages(builder, footer)
+		}
+	} else if len(footer) > 0 {
+		builder.Bold(false)
+		s.writeMessages(builder, footer)
+	} else {
+		builder.WriteString(tr.T("thank_you") + "\n")
+		builder.Bold(false)
+		builder.WriteString(tr.T("visit_again", location) + "\n")
+	}
+	
+	// Transaction ID
+	builder.WriteString("\n")
+	builder.WriteString(fmt.Sprintf("%s: %s\n", tr.T("transaction"), receipt.TransactionID))
+	builder.Align(escpos.AlignLeft)
+	
+	// Coupons, to tear off
+	s.writeCoupons(builder, receipt.Coupons, tr)
+	
+	// Feed and cut paper, then beep
+	builder.Cut(s.cut())
+	builder.Beep(s.config.Beep)
+	
+	return builder.String()
+}
+
+// Write the merchant's legal block for ESC/POS: name, address and tax
+// registrations, centered, then the VAT table when one is configured
+func (s *Server) writeLegal(builder *thermalBuilder, receipt ReceiptData, tr i18n.Translator) {
+	info := s.config.Merchant
+	table := s.vatTable(receipt)
+	if info.Empty() && len(table) == 0 {
+		return
+	}
+
+	builder.WriteString(divider("-", s.paper()))
+	builder.Align(escpos.AlignCenter)
+	if info.LegalName != "" {
+		builder.Bold(true)
+		builder.WriteString(info.LegalName + "\n")
+		builder.Bold(false)
+	}
+	for _, line := range info.Address {
+		for _, wrapped := range wrapText(builder.page.Transliterate(line), s.paper().Columns) {
+			builder.WriteString(wrapped + "\n")
+		}
+	}
+	for _, r := range info.Registrations {
+		builder.WriteString(r.Label + ": " + r.Number + "\n")
+	}
+	builder.Align(escpos.AlignLeft)
+
+	if len(table) == 0 {
+		return
+	}
+	sign := ""
+	if receipt.Type == "refund" {
+		sign = "-"
+	}
+	// Three right-aligned columns under each row's label
+	width := s.paper().Columns / 3
+	builder.WriteString(fmt.Sprintf("%*s%*s%*s\n", width, tr.T("net"), width, tr.T("tax"), width, tr.T("gross")))
+	for _, row := range table {
+		label := row.Label()
+		if row.Exempt {
+			label = tr.T("tax_exempt")
+			if row.Code != "" {
+				label += " (" + row.Code + ")"
+			}
+		}
+		builder.WriteString(label + "\n")
+		builder.WriteString(fmt.Sprintf("%*s%*s%*s\n",
+			width, sign+s.money(row.Amount), width, sign+s.money(row.Tax), width, sign+s.money(row.Gross())))
+	}
+}
+
+// Write custom message blocks for ESC/POS, centered, with their QR codes
+// drawn by the printer
+func (s *Server) writeMessages(builder *thermalBuilder, blocks []messages.Block) {
+
+	builder.Align(escpos.AlignCenter)
+	for i, b := range blocks {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		if b.Title != "" {
+			builder.Bold(true)
+			builder.WriteString(b.Title + "\n")
+			builder.Bold(false)
+		}
+		for _, line := range b.Lines() {
+			for _, wrapped := range wrapText(builder.page.Transliterate(line), s.paper().Columns) {
+				builder.WriteString(wrapped + "\n")
+			}
+		}
+		if b.QR != "" {
+			builder.QRCode(b.QR, 6)
+			builder.WriteString("\n")
+		}
+	}
+	builder.Align(escpos.AlignLeft)
+}
+
+// Helper function to format receipt lines
+func (s *Server) formatReceiptLine(label, value string) string {
+	totalWidth := s.paper().Columns
+	// Measure the text as it will print, without emoji or accents the
+	// code page lacks
+	label, value = s.codePage().Transliterate(label), s.codePage().Transliterate(value)
+	// Count characters rather than bytes so symbols like € stay aligned
+	padding := totalWidth - utf8.RuneCountInString(label) - utf8.RuneCountInString(value)
+	if padding < 1 {
+		// Too long for one line: the value goes below, still right-aligned,
+		// rather than wherever the printer would wrap it
+		padding = max(totalWidth-utf8.RuneCountInString(value), 0)
+		return label + "\n" + strings.Repeat(" ", padding) + value + "\n"
+	}
+	return label + strings.Repeat(" ", padding) + value + "\n"
+}
+
+// Helper function to draw a line of char across the paper
+func divider(char string, size paper.Size) string {
+	return strings.Repeat(char, size.Columns) + "\n"
+}
+
+// Helper function to get the paper in the receipt printer
+func (s *Server) paper() paper.Size {
+	return s.config.Paper.OrDefault()
+}
+
+// Helper function to get the paper in the ticket and station printers
+func (s *Server) ticketPaper() paper.Size {
+	if s.config.TicketPaper.Width == 0 {
+		return s.paper()
+	}
+	return s.config.TicketPaper.OrDefault()
+}
+
+// Helper function to get how the receipt printer cuts
+func (s *Server) cut() escpos.Cut {
+	return s.config.Cut.OrDefault()
+}
+
+// Helper function to get how the ticket and station printers cut
+func (s *Server) ticketCut() escpos.Cut {
+	if s.config.TicketCut.Mode == "" {
+		return s.cut()
+	}
+	return s.config.TicketCut
+}
+
+// Helper function to sound the error beep on the receipt printer, so
+// staff notice a print that failed. It runs in the background, and a
+// printer that can't be reached is only logged.
+func (s *Server) errorBeep() {
+	commands := s.commandSet("receipt").Beep(s.config.ErrorBeep)
+	if commands == "" {
+		return
+	}
+	go func() {
+		printerAddress, err := s.resolvePrinterAddress()
+		if err == nil {
+			err = s.printSingleCopy(s.stopping, printerAddress, commands, 1)
+		}
+		if err != nil {
+			s.logger.Printf("Error beep failed: %v", err)
+		}
+	}()
+}
+
+// Helper function to get the printer's code page
+func (s *Server) codePage() *escpos.CodePage {
+	page, err := escpos.LookupCodePage(s.config.CodePage)
+	if err != nil {
+		page, _ = escpos.LookupCodePage(escpos.DefaultCodePage)
+	}
+	return page
+}
+
+// Thermal printer output being built in a printer's command set. Text is
+// converted to the printer's code page as it is written; commands go
+// through the methods below, and Write passes bytes through unchanged.
+type thermalBuilder struct {
+	strings.Builder
+	page     *escpos.CodePage
+	commands escpos.CommandSet
+}
+
+// Helper function to start a document in a printer's command set: reset
+// the printer and select its code page, which the reset clears
+func (s *Server) newThermalBuilder(commands escpos.CommandSet) *thermalBuilder {
+	builder := &thermalBuilder{page: s.codePage(), commands: commands}
+	builder.Builder.WriteString(builder.commands.Init(builder.page))
+	return builder
+}
+
+func (b *thermalBuilder) WriteString(text string) (int, error) {
+	return b.Builder.Write(b.page.Encode(text))
+}
+
+func (b *thermalBuilder) Align(align escpos.Alignment) { b.Builder.WriteString(b.commands.Align(align)) }
+
+func (b *thermalBuilder) Bold(on bool) { b.Builder.WriteString(b.commands.Bold(on)) }
+
+func (b *thermalBuilder) Underline(on bool) { b.Builder.WriteString(b.commands.Underline(on)) }
+
+func (b *thermalBuilder) Invert(on bool) { b.Builder.WriteString(b.commands.Invert(on)) }
+
+func (b *thermalBuilder) Size(width, height int) {
+	b.Builder.WriteString(b.commands.Size(width, height))
+}
+
+func (b *thermalBuilder) Cut(cut escpos.Cut) { b.Builder.WriteString(b.commands.Cut(cut)) }
+
+func (b *thermalBuilder) Beep(beep escpos.Beep) { b.Builder.WriteString(b.commands.Beep(beep)) }
+
+// Raster prints GS v 0 raster commands, such as a logo's
+func (b *thermalBuilder) Raster(raster []byte) { b.Builder.Write(b.commands.Raster(raster)) }
+
+func (b *thermalBuilder) QRCode(data string, moduleSize int) {
+	b.Builder.Write(b.commands.QRCode(data, moduleSize))
+}
+
+func (b *thermalBuilder) Code128(data string, height int) error {
+	barcode, err := b.commands.Code128(data, height)
+	b.Builder.Write(barcode)
+	return err
+}
+
+func (b *thermalBuilder) DrawerKick(pin int) { b.Builder.Write(b.commands.DrawerKick(pin)) }
+
+// Helper function to get the width logos are scaled to
+func (s *Server) logoWidth() int {
+	if s.config.LogoWidth > 0 {
+		return s.config.LogoWidth
+	}
+	return s.paper().Dots()
+}
+
+// Render HTML receipt
+func (s *Server) renderHTMLReceipt(receipt ReceiptData) (string, error) {
+	// Line totals are computed here rather than in the template so they
+	// match the thermal receipt to the cent
+	items := make([]ReceiptItem, len(receipt.Items))
+	for i, item := range receipt.Items {
+		item.LineTotal = item.Price.Times(float64(item.Quantity))
+		items[i] = item
+	}
+	receipt.Items = items
+	
+	data := TemplateData{
+		ReceiptData: receipt,
+		Paper:       s.paper(),
+	}
+	tr := s.translator(receipt)
+	
+	// Clean date
+	if len(receipt.Date) > 16 {
+		data.CleanDate = receipt.Date[:16]
+	} else {
+		data.CleanDate = receipt.Date
+	}
+	
+	// Payment formatting
+	data.PaymentIcon = getPaymentEmoji(receipt.PaymentType)
+	data.PaymentDisplay = formatPaymentType(receipt.PaymentType, receipt.IsSettlement, receipt.HasCombinedTransaction)
+	
+	// Card details; split payments carry them per tender
+	data.Tenders = tenderLines(receipt.Payments, tr)
+	data.ShowCardDetails = len(data.Tenders) == 0 && (strings.Contains(receipt.PaymentType, "credit") || strings.Contains(receipt.PaymentType, "debit"))
+	if data.ShowCardDetails {
+		data.CardDisplay = cardText(receipt.CardDetails, tr)
+	}
+	
+	// Refund layout
+	data.IsRefund = receipt.Type == "refund"
+	if data.IsRefund {
+		data.RefundTotal, data.RefundMethodDisplay = refundDetails(receipt)
+	}
+	
+	// Tax breakdown
+	data.TaxLines = s.taxLines(receipt)
+	data.ShowTaxBreakdown = len(data.TaxLines) > 0
+	data.TaxSubtotals = s.taxSubtotals(receipt)
+	data.VATTable = s.vatTable(receipt)
+	data.Merchant = s.config.Merchant
+	data.GLCodeTotal = glcode.Total(receipt.GLCodeSummary)
+	data.RetailTotal = retailTotal(receipt)
+	data.Deposits, data.DepositTotal = receiptDeposits(receipt.Items)
+	data.DueBack = earliestDueBack(receipt.Items)
+	data.Header = messageBlocks(messages.Pick(receipt.HeaderMessages, s.config.Messages.Header))
+	data.Footer = messageBlocks(messages.Pick(receipt.FooterMessages, s.config.Messages.Footer))
+	data.CouponBlocks = s.couponBlocks(receipt.Coupons)
+	data.HasLoyalty = hasLoyalty(receipt)
+	
+	tmpl, err := s.templates.Get("receipt", tr.Language())
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %v", err)
+	}
+	
+	html, err := tmplfuncs.Render(tmpl, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template: %v", err)
+	}
+	
+	return html, nil
+}
+
+// Helper function to decode a receipt request, checking every field
+// first so the error names each one that is missing or invalid
+func decodeReceipt(r *http.Request, receipt *ReceiptData) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading request body: %w", err)
+	}
+	if err := validate.JSON(body, receipt); err != nil {
+		var errs validate.Errors
+		if errors.As(err, &errs) {
+			return errs
+		}
+		return fmt.Errorf("Invalid JSON data: %v", err)
+	}
+	return json.Unmarshal(body, receipt)
+}
+
+// Handler: Preview receipt
+func (s *Server) handlePreviewReceipt(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+	
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	
+	var receipt ReceiptData
+	if err := decodeReceipt(r, &receipt); err != nil {
+		var errs validate.Errors
+		errors.As(err, &errs)
+		status := bodyErrorStatus(err)
+		s.sendJSONResponse(w, status, ErrorResponse{
+			Error:   http.StatusText(status),
+			Code:    status,
+			Message: err.Error(),
+			Errors:  errs,
+		})
+		return
+	}
+	if err := sanitizeReceipt(&receipt); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := itemTypeError(receipt.Items); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := paymentsError(receipt.Payments); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := messagesError(receipt); err != nil {
+		s.sendErrorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	
+	htmlContent, err := s.renderHTMLReceipt(receipt)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Template error: %v", err))
+		return
+	}
+	
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(htmlContent))
+}
+
+// Handler: Test receipt
+func (s *Server) handleTestReceipt(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+	
+	testReceipt := ReceiptData{
+		TransactionID:    "TEST-" + time.Now().Format("20060102-150405"),
+		Location:        "My Store",
+		Date:            time.Now().Format("2006-01-02 15:04:05"),
+		CustomerName:    "John Doe",
+		PaymentType:     "credit",
+		Subtotal:        2000, // Amounts are in cents
+		Tax:             260,
+		Tip:             300,
+		Total:           2560,
+		IsRetail:        true,
+		Items: []ReceiptItem{
+			{Name: "Premium Coffee", Quantity: 2, Price: 850, SKU: "COFFEE-001"},
+			{Name: "Blueberry Muffin", Quantity: 1, Price: 300, SKU: "MUFFIN-002"},
+		},
+		CardDetails: CardDetails{
+			CardBrand: "visa",
+			CardLast4: "1234",
+			AuthCode:  "ABC123",
+		},
+		TerminalId: "TERM001",
+	}
+	
+	htmlContent, err := s.renderHTMLReceipt(testReceipt)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, fmt.Sprintf("Template error: %v", err))
+		return
+	}
+	
+	w.Header().Set("Content-Type", "text/html")
+	w.Write([]byte(htmlContent))
+}
+
+// Handler: Print receipt
+func (s *Server) handlePrintReceipt(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.sendJSONResponse(w, http.StatusMethodNotAllowed, PrintResponse{
+			Success: false,
+			Message: "Method not allowed",
+		})
+		return
+	}
+
+	var receipt ReceiptData
+	if err := decodeReceipt(r, &receipt); err != nil {
+		s.logf(r.Context(), "Error parsing JSON: %v", err)
+		var errs validate.Errors
+		errors.As(err, &errs)
+		s.sendJSONResponse(w, bodyErrorStatus(err), PrintResponse{
+			Success: false,
+			Message: err.Error(),
+			Errors:  errs,
+		})
+		return
+	}
+
+	if err := sanitizeReceipt(&receipt); err != nil {
+		s.sendJSONResponse(w, http.StatusBadRequest, PrintResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	s.logf(r.Context(), "📄 Received print request for transaction %s%s", receipt.TransactionID, servedBy(receipt))
+
+	if err := itemTypeError(receipt.Items); err != nil {
+		s.sendJSONResponse(w, http.StatusBadRequest, PrintResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err := paymentsError(receipt.Payments); err != nil {
+		s.sendJSONResponse(w, http.StatusBadRequest, PrintResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err := messagesError(receipt); err != nil {
+		s.sendJSONResponse(w, http.StatusBadRequest, PrintResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	// Before any per-copy results are allocated
+	if errs := copiesError(receipt.Copies); errs != nil {
+		s.sendJSONResponse(w, http.StatusBadRequest, PrintResponse{
+			Success: false,
+			Message: errs.Error(),
+			Errors:  errs,
+		})
+		return
+	}
+
+	if receipt.Copies <= 0 {
+		receipt.Copies = 1
+	}
+
+	// The same receipt again soon after it printed is most likely a
+	// double click or a retry: mark it DUPLICATE, or refuse it
+	contents, _ := json.Marshal(receipt)
+	if printed, ok := s.recent.Check(receipt.TransactionID, contents); ok {
+		if s.recent.Action == dedupe.Reject {
+			s.sendJSONResponse(w, http.StatusConflict, PrintResponse{
+				Success: false,
+				Message: fmt.Sprintf("Receipt %s was already printed at %s", receipt.TransactionID, printed.Format("15:04:05")),
+			})
+			return
+		}
+		s.logf(r.Context(), "⚠️  Transaction %s is a duplicate of one printed at %s", receipt.TransactionID, printed.Format("15:04:05"))
+		receipt.Duplicate = true
+	}
+
+	// Mismatched totals still print, but the frontend is told about it
+	warning := totalsWarning(receipt)
+	if warning != "" {
+		s.logf(r.Context(), "⚠️  Transaction %s: %s", receipt.TransactionID, warning)
+	}
+	
+	// Store rules run on the totals as checked, e.g. rounding the total
+	// for cash
+	s.applyScriptedFields(r.Context(), &receipt)
+
+	if e, ok := receiptAuditEvent(receipt); ok && e.Action == audit.NoSale {
+		if !s.auditDrawer(w, r, receipt.OperatorName, e) {
+			return
+		}
+	}
+
+	number, err := s.numberReceipt(r.Context(), &receipt)
+	if err != nil {
+		s.sendJSONResponse(w, http.StatusInternalServerError, PrintResponse{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	// The number is kept once the receipt printed or was spooled, and
+	// handed back otherwise
+	numbered := false
+	defer func() { s.finishReceiptNumber(r.Context(), number, numbered) }()
+	s.pickCoupons(&receipt)
+
+	copies, err := s.sendToThermalPrinter(r.Context(), receipt, receipt.Copies)
+	if err != nil {
+		s.logf(r.Context(), "Print job failed: %v", err)
+		printed := countCopies(copies, "printed")
+		numbered = printed > 0
+		// Rather than lose the receipt, keep it to print once the
+		// printer is back
+		var offline *printerOfflineError
+		if errors.As(err, &offline) {
+			if job, spoolErr := s.spoolReceipt(r.Context(), receipt, offline); spoolErr != nil {
+				s.logf(r.Context(), "⚠️  %v", spoolErr)
+			} else {
+				s.logf(r.Context(), "📥 Transaction %s spooled as %s", receipt.TransactionID, job.ID)
+				numbered = true
+				s.recent.Record(receipt.TransactionID, contents)
+				s.auditReceipt(r, receipt)
+				for i := printed; i < len(copies); i++ {
+					copies[i].Status, copies[i].Method = "spooled", "spool"
+				}
+				message := "Printer is offline; the receipt will print when it is back online"
+				if printed > 0 {
+					message = fmt.Sprintf("Printed %d of %d copies; the printer went offline and the other %d will print when it is back online",
+						printed, len(copies), len(copies)-printed)
+				}
+				s.sendJSONResponse(w, http.StatusAccepted, PrintResponse{
+					Success:       true,
+					Spooled:       true,
+					Message:       message,
+					Warning:       warning,
+					ReceiptNumber: receipt.ReceiptNumber,
+					Copies:        copies,
+				})
+				return
+			}
+		}
+		s.errorBeep()
+		s.sendJSONResponse(w, http.StatusInternalServerError, PrintResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to print receipt (%d of %d copies printed): %v", printed, len(copies), err),
+			Copies:  copies,
+		})
+		return
+	}
+
+	s.logf(r.Context(), "✅ Print job completed successfully")
+	numbered = true
+	s.recent.Record(receipt.TransactionID, contents)
+	s.auditReceipt(r, receipt)
+	s.sendJSONResponse(w, http.StatusOK, PrintResponse{
+		Success: true,
+		Message: fmt.Sprintf("Receipt printed successfully (%d %s)", receipt.Copies, 
+			map[bool]string{true: "copy", false: "copies"}[receipt.Copies == 1]),
+		Warning:       warning,
+		Duplicate:     receipt.Duplicate,
+		ReceiptNumber: receipt.ReceiptNumber,
+		Copies:        copies,
+	})
+}
+
+// Handler: Health check
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+	
+	// Test printer connectivity
+	printerStatus := "offline"
+	address := joinPrinterAddress(s.printerHost("receipt", s.config.PrinterIP), s.config.PrinterPort)
+	
+	if ok, err := s.attachedPrinterOnline(address); ok {
+		if err == nil {
+			printerStatus = "online"
+		}
+	} else {
+		dialer := net.Dialer{Timeout: 2 * time.Second}
+		conn, err := dialer.DialContext(r.Context(), "tcp", address)
+		if err == nil {
+			printerStatus = "online"
+			conn.Close()
+		}
+	}
+	
+	// Receipts spooled while the printer was offline print now it's back
+	spooled := s.spoolLength()
+	if printerStatus == "online" && spooled > 0 {
+		go s.flushSpool(s.stopping)
+	}
+	
+	s.sendJSONResponse(w, http.StatusOK, HealthResponse{
+		Status:    printerStatus,
+		Printer:   address,
+		Spooled:   spooled,
+		Alerts:    s.printerAlerts("receipt"),
+		Timestamp: time.Now().Format(time.RFC3339),
+		Version:   serverVersion,
+	})
+}
+
+// Test printer connection
+func (s *Server) testPrinter() error {
+	s.logger.Printf("Testing printer connection...")
+	builder := s.newThermalBuilder(s.commandSet("receipt"))
+	builder.WriteString("\n")
+	builder.Align(escpos.AlignCenter)
+	builder.WriteString("TEST PRINT")
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString("\n" +
+		"================================\n" +
+		"Date: " + time.Now().Format("2006-01-02 15:04:05") + "\n" +
+		"Test from Go print server v2.0\n" +
+		"================================\n")
+	builder.Cut(s.cut())
+	testReceipt := builder.String()
+
+	if address := s.printerHost("receipt", s.config.PrinterIP); isAttachedAddress(address) {
+		s.logger.Printf("Sending test print to %s...", address)
+		if _, err := s.sendToAttached(context.Background(), address, testReceipt); err != nil {
+			return err
+		}
+		s.logger.Printf("✅ Test print sent successfully")
+		return nil
+	}
+
+	address := net.JoinHostPort(s.config.PrinterIP, strconv.Itoa(s.config.PrinterPort))
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("cannot reach printer at %s: %v", address, err)
+	}
+	defer conn.Close()
+
+	s.logger.Printf("✅ Printer is reachable at %s", address)
+
+	// Send test print
+	s.logger.Printf("Sending test print...")
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte(testReceipt))
+	if err != nil {
+		return fmt.Errorf("failed to send test print: %v", err)
+	}
+
+	s.logger.Printf("✅ Test print sent successfully")
+	return nil
+}
+
+// Default data directory, shared with the scanner agent's app directory
+func defaultDataDir() string {
+	if runtime.GOOS == "windows" {
+		return "C:\\GoScanRentalTide-main\\data"
+	}
+	return filepath.Join("/", "opt", "GoScanRentalTide-main", "data")
+}
+
+// Path of the append-only time clock journal
+func (s *Server) punchJournalPath() (string, error) {
+	if err := os.MkdirAll(s.config.DataDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %v", err)
+	}
+	return filepath.Join(s.config.DataDir, "timeclock.jsonl"), nil
+}
+
+// Read all punches from the journal, oldest first
+func (s *Server) readPunches() ([]Punch, error) {
+	path, err := s.punchJournalPath()
+	if err != nil {
+		return nil, err
+	}
+	
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open punch journal: %v", err)
+	}
+	defer file.Close()
+	
+	var punches []Punch
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var p Punch
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			s.logger.Printf("Skipping corrupt punch journal entry: %v", err)
+			continue
+		}
+		punches = append(punches, p)
+	}
+	return punches, scanner.Err()
+}
+
+// Append a punch to the journal
+func (s *Server) appendPunch(p Punch) error {
+	path, err := s.punchJournalPath()
+	if err != nil {
+		return err
+	}
+	
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open punch journal: %v", err)
+	}
+	defer file.Close()
+	
+	line, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write punch journal: %v", err)
+	}
+	return nil
+}
+
+// Format a punch slip for the thermal printer
+func (s *Server) formatPunchSlip(p Punch, worked time.Duration) string {
+	builder := s.newThermalBuilder(s.commandSet("receipt"))
+	
+	builder.Align(escpos.AlignCenter)
+	builder.Bold(true)
+	builder.WriteString("TIME CLOCK\n")
+	builder.Bold(false)
+	builder.Size(2, 2)
+	if p.Action == "in" {
+		builder.WriteString("CLOCK IN\n")
+	} else {
+		builder.WriteString("CLOCK OUT\n")
+	}
+	builder.Size(1, 1)
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString(divider("=", s.paper()))
+	
+	employee := p.EmployeeName
+	if employee == "" {
+		employee = p.EmployeeID
+	}
+	builder.WriteString(s.formatReceiptLine("Employee:", employee))
+	if p.EmployeeName != "" {
+		builder.WriteString(s.formatReceiptLine("Employee ID:", p.EmployeeID))
+	}
+	builder.WriteString(s.formatReceiptLine("Date:", p.Time.Format("2006-01-02")))
+	builder.WriteString(s.formatReceiptLine("Time:", p.Time.Format("15:04:05")))
+	if worked > 0 {
+		builder.WriteString(s.formatReceiptLine("Time worked:",
+			fmt.Sprintf("%dh %02dm", int(worked.Hours()), int(worked.Minutes())%60)))
+	}
+	if p.Note != "" {
+		builder.WriteString(fmt.Sprintf("Note: %s\n", p.Note))
+	}
+	
+	builder.WriteString(divider("=", s.paper()))
+	builder.WriteString(fmt.Sprintf("Punch: %s\n", p.ID))
+	builder.Cut(s.cut())
+	builder.Beep(s.config.Beep)
+	
+	return builder.String()
+}
+
+// Handler: Record a time clock punch and print a slip
+func (s *Server) handleTimeclockPunch(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+	
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	
+	var req PunchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.sendBodyError(w, err)
+		return
+	}
+	
+	req.EmployeeID = strings.TrimSpace(req.EmployeeID)
+	req.Action = strings.ToLower(strings.TrimSpace(req.Action))
+	if req.EmployeeID == "" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "employeeId is required")
+		return
+	}
+	if req.Action != "in" && req.Action != "out" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "action must be \"in\" or \"out\"")
+		return
+	}
+	
+	s.punchMu.Lock()
+	defer s.punchMu.Unlock()
+	
+	punches, err := s.readPunches()
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	
+	// Find the employee's last punch to catch double punches and compute time worked
+	var last *Punch
+	for i := len(punches) - 1; i >= 0; i-- {
+		if punches[i].EmployeeID == req.EmployeeID {
+			last = &punches[i]
+			break
+		}
+	}
+	if last != nil && last.Action == req.Action {
+		s.sendErrorResponse(w, http.StatusConflict,
+			fmt.Sprintf("employee %s is already clocked %s since %s", req.EmployeeID, req.Action, last.Time.Format("2006-01-02 15:04")))
+		return
+	}
+	
+	now := time.Now()
+	punch := Punch{
+		ID:           fmt.Sprintf("%s-%s-%s", now.Format("20060102-150405"), req.EmployeeID, req.Action),
+		EmployeeID:   req.EmployeeID,
+		EmployeeName: strings.TrimSpace(req.EmployeeName),
+		Action:       req.Action,
+		Time:         now,
+		Note:         req.Note,
+	}
+	
+	if punch.EmployeeName == "" && last != nil {
+		punch.EmployeeName = last.EmployeeName
+	}
+	
+	var worked time.Duration
+	if punch.Action == "out" && last != nil {
+		worked = punch.Time.Sub(last.Time)
+	}
+	
+	if err := s.appendPunch(punch); err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.logf(r.Context(), "⏱️  Recorded clock-%s for employee %s", punch.Action, punch.EmployeeID)
+	
+	resp := PunchResponse{
+		Success:     true,
+		Message:     fmt.Sprintf("Clock-%s recorded", punch.Action),
+		Punch:       punch,
+		HoursWorked: worked.Hours(),
+	}
+	
+	// The punch is already recorded, so a printer failure only loses the slip
+	printerAddress, err := s.resolvePrinterAddress()
+	if err == nil {
+		err = s.printSingleCopy(r.Context(), printerAddress, s.formatPunchSlip(punch, worked), 1)
+	}
+	if err != nil {
+		s.logf(r.Context(), "Punch slip failed to print: %v", err)
+		s.errorBeep()
+		resp.Message += fmt.Sprintf(", but the slip could not be printed: %v", err)
+	} else {
+		resp.Printed = true
+	}
+	
+	s.sendJSONResponse(w, http.StatusOK, resp)
+}
+
+// Handler: Export time clock punches as CSV (default) or JSON
+func (s *Server) handleTimeclockExport(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+	
+	if r.Method != "GET" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	
+	query := r.URL.Query()
+	var from, to time.Time
+	if v := query.Get("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			s.sendErrorResponse(w, http.StatusBadRequest, "from must be a date (YYYY-MM-DD)")
+			return
+		}
+		from = t
+	}
+	if v := query.Get("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			s.sendErrorResponse(w, http.StatusBadRequest, "to must be a date (YYYY-MM-DD)")
+			return
+		}
+		to = t.AddDate(0, 0, 1) // Inclusive of the whole day
+	}
+	employeeID := query.Get("employeeId")
+	
+	s.punchMu.Lock()
+	punches, err := s.readPunches()
+	s.punchMu.Unlock()
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	
+	filtered := []Punch{}
+	for _, p := range punches {
+		if employeeID != "" && p.EmployeeID != employeeID {
+			continue
+		}
+		if !from.IsZero() && p.Time.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !p.Time.Before(to) {
+			continue
+		}
+		filtered = append(filtered, p)
+	}
+	
+	if query.Get("format") == "json" {
+		s.sendJSONResponse(w, http.StatusOK, filtered)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", "attachment; filename=timeclock.csv")
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "employee_id", "employee_name", "action", "time", "note"})
+	for _, p := range filtered {
+		writer.Write([]string{p.ID, p.EmployeeID, p.EmployeeName, p.Action, p.Time.Format(time.RFC3339), p.Note})
+	}
+	writer.Flush()
+}
+
+// Setup routes
+func (s *Server) setupRoutes() http.Handler {
+	mux := http.NewServeMux()
+	// /health, /metrics, /assets and /openapi.json are not versioned
+	api := apiversion.New(mux, s.logger)
+	
+	api.HandleFunc("/print/receipt", s.loggingMiddleware(s.limitPrints(s.handlePrintReceipt)))
+	api.HandleFunc("/preview/receipt", s.loggingMiddleware(s.handlePreviewReceipt))
+	api.HandleFunc("/test/receipt", s.loggingMiddleware(s.limitPrints(s.handleTestReceipt)))
+	mux.HandleFunc("/health", s.loggingMiddleware(s.handleHealth))
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	api.HandleFunc("/timeclock/punch", s.loggingMiddleware(s.handleTimeclockPunch))
+	api.HandleFunc("/timeclock/export", s.loggingMiddleware(s.handleTimeclockExport))
+	api.HandleFunc("/print/ticket", s.loggingMiddleware(s.limitPrints(s.handlePrintTicket)))
+	api.HandleFunc("/print/report", s.loggingMiddleware(s.limitPrints(s.handlePrintReport)))
+	api.HandleFunc("/print/label", s.loggingMiddleware(s.limitPrints(s.handlePrintLabel)))
+	api.HandleFunc("/print/slip", s.loggingMiddleware(s.limitPrints(s.handlePrintSlip)))
+	api.HandleFunc("/printers/{name}/selftest", s.loggingMiddleware(s.limitPrints(s.handlePrinterSelfTest)))
+	api.HandleFunc("/audit", s.loggingMiddleware(s.handleAudit))
+	mux.HandleFunc("/assets/{name...}", s.loggingMiddleware(s.handleAsset))
+	mux.HandleFunc("/openapi.json", s.handleOpenAPI)
+	
+	return tracing.Middleware(s.limitBody(mux), s.logger)
+}
+
+// Start server
+func (s *Server) Start() error {
+	s.httpServer = &http.Server{
+		Handler:      s.setupRoutes(),
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		BaseContext:  func(net.Listener) context.Context { return s.stopping },
+	}
+	
+	listener, err := listen.Listen(s.config.Port, s.config.PortFallback)
+	if err != nil {
+		return err
+	}
+	if port := listen.Port(listener); port != s.config.Port {
+		s.logger.Printf("⚠️  Port %d is in use, using port %d instead", s.config.Port, port)
+		s.config.Port = port
+	}
+	portFile := filepath.Join(s.config.DataDir, "receipt-server-port.json")
+	if err := listen.Register(portFile, "receipt-server", s.config.Port); err != nil {
+		s.logger.Printf("⚠️  Failed to record the port in %s: %v", portFile, err)
+	}
+	
+	s.logger.Printf("🚀 Starting receipt print server on port %d", s.config.Port)
+	s.logger.Printf("🖨️  Printer configured: %s:%d", s.config.PrinterIP, s.config.PrinterPort)
+	go s.monitorPrinters(s.stopping)
+	
+	return s.httpServer.Serve(listener)
+}
+
+// Graceful shutdown
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	
+	s.logger.Printf("Shutting down server...")
+	// Print jobs still running give up rather than hold the shutdown
+	s.stop()
+	err := s.httpServer.Shutdown(ctx)
+	// Queued webhook events get a few seconds; a receiver that is down
+	// mustn't hold the shutdown up through its retries
+	drain, cancelDrain := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelDrain()
+	if werr := s.webhooks.Close(drain); werr != nil {
+		s.logger.Printf("⚠️  %v", werr)
+	}
+	return err
+}
+
+// Show usage information
+func showUsage() {
+	fmt.Println("Receipt Print Server v2.0")
+	fmt.Println("Usage: go run ./cmd/receipt-server [options]")
+	fmt.Println("")
+	fmt.Println("Options:")
+	fmt.Println("  -port PORT            Set server port (default: 3600)")
+	fmt.Println("  -port-fallback N      When the port is taken, try the next N ports (default: 0, fail)")
+	fmt.Println("  -printer-ip IP        Set printer IP address (default: ESDPRT001)")
+	fmt.Println("  -printer-port PORT    Set printer port (default: 9100)")
+	fmt.Println("  -ticket-printer-ip IP Printer for kitchen/prep tickets (default: the receipt printer)")
+	fmt.Println("  -ticket-printer-port PORT Ticket printer port (default: 9100)")
+	fmt.Println("  -station-printer STATION=HOST[:PORT] Send a station's tickets to its own printer (repeatable)")
+	fmt.Println("  -usb-printer NAME[=DEVICE] Print to receipt, ticket, label or a station's printer over USB, with no")
+	fmt.Println("                        network or spooler; DEVICE is its path or part of it, e.g. lp1 (repeatable)")
+	fmt.Println("  -serial-printer NAME=PORT[:BAUD[:FLOW]] Print to receipt, ticket, label or a station's printer on a")
+	fmt.Println("                        serial port, e.g. receipt=COM3:19200:rtscts; FLOW is none, rtscts or xonxoff")
+	fmt.Println("                        (default: 9600 baud, 8N1, none) (repeatable)")
+	fmt.Println("  -slip TYPE=OPTIONS    Card slips for a payment type: tip,signature,copy or off (repeatable;")
+	fmt.Println("                        default: credit=tip,signature,copy debit=tip,copy)")
+	fmt.Println("  -tax-config FILE      Load tax rates from a JSON file (default: BC GST 5% / PST 7%)")
+	fmt.Println("  -messages FILE        Load header and footer blocks (return policy, Wi-Fi, survey QR code) from a JSON file")
+	fmt.Println("  -scripted-fields FILE Load fields computed from each receipt before it prints, such as cash rounding or custom labels")
+	fmt.Println("  -coupons FILE         Load promotional coupons (text, barcode, expiry) printed after the footer of sales, with rotation rules")
+	fmt.Println("  -merchant FILE        Load the legal name, address, tax registrations and VAT format printed on receipts")
+	fmt.Println("  -data-dir DIR         Directory for the time clock and Z report journals, audit log, receipt spool and logo cache")
+	fmt.Println("  -webhook-config FILE  Post print.spooled, spool.flushed and printer status events to the receivers in a JSON file")
+	fmt.Println("  -status-interval DURATION How often printers are asked about paper, cover and cutter (default: 1m; 0 turns it off)")
+	fmt.Println("  -receipt-numbers      Number receipts in sequence per station, printed and returned as receiptNumber")
+	fmt.Println("  -print-rate-limit N   Print requests allowed per minute from one client (default: 30; 0 turns it off)")
+	fmt.Println("  -print-burst N        Print requests one client may send at once before the rate limit applies (default: 10)")
+	fmt.Println("  -max-body-kb KB       Largest request body in kilobytes (default: 512)")
+	fmt.Println("  -duplicate-window DURATION The same receipt printed again within it is a duplicate (default: 2m; 0 turns detection off)")
+	fmt.Println("  -duplicate-action ACTION What to do with a duplicate: banner (print it marked DUPLICATE) or reject (default: banner)")
+	fmt.Println("  -label-printer-ip IP  Zebra-class label printer for /print/label")
+	fmt.Println("  -label-printer-port PORT Label printer port (default: 9100)")
+	fmt.Println("  -label-format FORMAT  Label printer language, zpl or epl (default: zpl)")
+	fmt.Println("  -label-layouts DIR    Load custom label layouts from the JSON files in DIR")
+	fmt.Println("  -paper WIDTH[:COLUMNS] Receipt paper, " + paper.Widths() + " (default: 80mm pages, 32-column lines)")
+	fmt.Println("  -ticket-paper WIDTH[:COLUMNS] Ticket and station printer paper (default: -paper)")
+	fmt.Println("  -cut MODE[:FEED]      Receipt printer cut, full, partial or none, after FEED lines (default: partial:3)")
+	fmt.Println("  -ticket-cut MODE[:FEED] Ticket and station printer cut (default: -cut)")
+	fmt.Println("  -beep COUNT[:MS]      Beep after each receipt printer document, 1-9 beeps of 50-450ms (default: off)")
+	fmt.Println("  -ticket-beep COUNT[:MS] Beep after each ticket on the ticket and station printers (default: off)")
+	fmt.Println("  -error-beep COUNT[:MS] Beep on the receipt printer when a print fails (default: off)")
+	fmt.Println("  -printer-model NAME=MODEL Printer model of receipt, ticket or a station's printer, e.g. tsp143 or tm-t88,")
+	fmt.Println("                        or its command set: escpos (the default) or starprnt (repeatable)")
+	fmt.Println("  -code-page NAME       Printer code page (" + strings.Join(escpos.CodePages(), ", ") + "; default: " + escpos.DefaultCodePage + ")")
+	fmt.Println("  -logo-width DOTS      Width thermal receipt logos are scaled to (default: the paper's printable width)")
+	fmt.Println("  -assets-dir DIR       Logos and fonts served under /assets/; logoUrl local:NAME reads NAME from it (default: DATA_DIR/assets)")
+	fmt.Println("  -locale LOCALE        Currency locale for amounts (default: en-CA)")
+	fmt.Println("  -currency-config FILE Load a custom currency format from a JSON file")
+	fmt.Println("  -language LANG        Default receipt language (" + strings.Join(i18n.Languages(), ", ") + "; default: en)")
+	fmt.Println("  -translations FILE    Load extra or replacement receipt strings from a JSON file")
+	fmt.Println("  -test                 Test printer connection")
+	fmt.Println("  -help                 Show this help message")
+	fmt.Println("")
+	fmt.Println("Examples:")
+	fmt.Println("  go run ./cmd/receipt-server                                      # Start with default settings")
+	fmt.Println("  go run ./cmd/receipt-server -port 8080 -printer-ip 192.168.1.50 # Custom port and printer IP")
+	fmt.Println("  go run ./cmd/receipt-server -test                               # Test printer connection")
+	fmt.Println("")
+	fmt.Println("Endpoints (the /v1 paths also answer without /v1, marked deprecated):")
+	fmt.Println("  POST /v1/print/receipt   # Print receipt")
+	fmt.Println("  POST /v1/preview/receipt # Preview receipt in browser")
+	fmt.Println("  GET  /v1/test/receipt    # Test receipt for preview")
+	fmt.Println("  GET  /health             # Health check; prints receipts spooled while the printer was offline")
+	fmt.Println("  POST /v1/timeclock/punch # Record a clock-in/out and print a slip")
+	fmt.Println("  GET  /v1/timeclock/export # Export punches (CSV, or ?format=json)")
+	fmt.Println("  POST /v1/print/ticket    # Print a kitchen/prep ticket")
+	fmt.Println("  POST /v1/print/report    # Print an X (mid-shift) or Z (end-of-day) report")
+	fmt.Println("  POST /v1/print/label     # Print SKU, price or asset tag labels (ZPL/EPL)")
+	fmt.Println("  POST /v1/print/slip      # Print a card slip with tip and signature lines")
+	fmt.Println("  POST /v1/printers/NAME/selftest # Print a self-test page on receipt, ticket or a station's printer")
+	fmt.Println("  GET  /v1/audit           # No-sales, drawer opens, refunds and reprints, checked for tampering")
+	fmt.Println("  GET  /assets/NAME        # A logo or font of the assets directory, for receipt previews")
+	fmt.Println("  GET  /openapi.json       # OpenAPI document of these endpoints")
+}
+
+func main() {
+	// Default configuration
+	config = Config{
+		Port:        3600,
+		PrinterIP:   "ESDPRT001",
+		PrinterPort: 9100,
+		LogLevel:    "INFO",
+		DataDir:     defaultDataDir(),
+		Tax:         tax.DefaultConfig(),
+		Currency:    money.DefaultFormat(),
+		Language:    i18n.DefaultLanguage,
+		CodePage:    escpos.DefaultCodePage,
+
+		TicketPrinterPort: 9100,
+		LabelPrinterPort:  9100,
+		LabelFormat:       label.ZPL,
+		DuplicateWindow:   2 * time.Minute,
+		DuplicateAction:   dedupe.Banner,
+		StatusInterval:    time.Minute,
+		PrintRateLimit:    30,
+		PrintBurst:        10,
+	}
+
+	// Parse command line arguments
+	var scriptedFieldsPath string
+	args := os.Args[1:]
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-port":
+			if i+1 < len(args) {
+				port, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid port: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.Port = port
+				i++
+			}
+		case "-port-fallback":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 0 {
+					fmt.Printf("Invalid port fallback: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.PortFallback = n
+				i++
+			}
+		case "-printer-ip":
+			if i+1 < len(args) {
+				config.PrinterIP = args[i+1]
+				i++
+			}
+		case "-printer-port":
+			if i+1 < len(args) {
+				port, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid printer port: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.PrinterPort = port
+				i++
+			}
+		case "-ticket-printer-ip":
+			if i+1 < len(args) {
+				config.TicketPrinterIP = args[i+1]
+				i++
+			}
+		case "-ticket-printer-port":
+			if i+1 < len(args) {
+				port, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid ticket printer port: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.TicketPrinterPort = port
+				i++
+			}
+		case "-station-printer":
+			if i+1 < len(args) {
+				station, address, ok := strings.Cut(args[i+1], "=")
+				if !ok || strings.TrimSpace(station) == "" {
+					fmt.Printf("Invalid station printer %q, expected STATION=HOST[:PORT]\n", args[i+1])
+					os.Exit(1)
+				}
+				if _, _, err := splitPrinterAddress(address, config.TicketPrinterPort); err != nil {
+					fmt.Printf("Invalid station printer: %v\n", err)
+					os.Exit(1)
+				}
+				if config.StationPrinters == nil {
+					config.StationPrinters = make(map[string]string)
+				}
+				config.StationPrinters[strings.ToLower(strings.TrimSpace(station))] = address
+				i++
+			}
+		case "-usb-printer":
+			if i+1 < len(args) {
+				name, device, _ := strings.Cut(args[i+1], "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "" {
+					fmt.Printf("Invalid USB printer %q, expected NAME[=DEVICE]\n", args[i+1])
+					os.Exit(1)
+				}
+				if config.Printers == nil {
+					config.Printers = make(map[string]PrinterTransport)
+				}
+				config.Printers[name] = PrinterTransport{Transport: "usb", Device: strings.TrimSpace(device)}
+				i++
+			}
+		case "-serial-printer":
+			if i+1 < len(args) {
+				name, printer, err := parseSerialPrinter(args[i+1])
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				if config.Printers == nil {
+					config.Printers = make(map[string]PrinterTransport)
+				}
+				config.Printers[name] = printer
+				i++
+			}
+		case "-slip":
+			if i+1 < len(args) {
+				paymentType, value, ok := strings.Cut(args[i+1], "=")
+				if !ok || strings.TrimSpace(paymentType) == "" {
+					fmt.Printf("Invalid slip %q, expected TYPE=OPTIONS\n", args[i+1])
+					os.Exit(1)
+				}
+				options, err := parseSlipOptions(value)
+				if err != nil {
+					fmt.Printf("Invalid slip: %v\n", err)
+					os.Exit(1)
+				}
+				if config.Slips == nil {
+					config.Slips = make(map[string]*SlipOptions)
+				}
+				config.Slips[strings.ToLower(strings.TrimSpace(paymentType))] = options
+				i++
+			}
+		case "-status-interval":
+			if i+1 < len(args) {
+				d, err := time.ParseDuration(args[i+1])
+				if err != nil || d < 0 {
+					fmt.Printf("Invalid status interval: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.StatusInterval = d
+				i++
+			}
+		case "-print-rate-limit":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 0 {
+					fmt.Printf("Invalid print rate limit: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.PrintRateLimit = n
+				i++
+			}
+		case "-print-burst":
+			if i+1 < len(args) {
+				n, err := strconv.Atoi(args[i+1])
+				if err != nil || n < 1 {
+					fmt.Printf("Invalid print burst: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.PrintBurst = n
+				i++
+			}
+		case "-receipt-numbers":
+			config.ReceiptNumbers = true
+		case "-max-body-kb":
+			if i+1 < len(args) {
+				kb, err := strconv.Atoi(args[i+1])
+				if err != nil || kb <= 0 {
+					fmt.Printf("Invalid body limit: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.MaxBodyBytes = int64(kb) << 10
+				i++
+			}
+		case "-duplicate-window":
+			if i+1 < len(args) {
+				d, err := time.ParseDuration(args[i+1])
+				if err != nil || d < 0 {
+					fmt.Printf("Invalid duplicate window: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.DuplicateWindow = d
+				i++
+			}
+		case "-duplicate-action":
+			if i+1 < len(args) {
+				action, err := dedupe.ParseAction(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid duplicate action: %v\n", err)
+					os.Exit(1)
+				}
+				config.DuplicateAction = action
+				i++
+			}
+		case "-webhook-config":
+			if i+1 < len(args) {
+				cfg, err := webhook.Load(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid webhook config: %v\n", err)
+					os.Exit(1)
+				}
+				config.Webhooks = cfg
+				i++
+			}
+		case "-messages":
+			if i+1 < len(args) {
+				cfg, err := messages.Load(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid messages: %v\n", err)
+					os.Exit(1)
+				}
+				config.Messages = cfg
+				i++
+			}
+		case "-scripted-fields":
+			if i+1 < len(args) {
+				scriptedFieldsPath = args[i+1]
+				i++
+			}
+		case "-coupons":
+			if i+1 < len(args) {
+				cfg, err := coupons.Load(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid coupons: %v\n", err)
+					os.Exit(1)
+				}
+				config.Coupons = cfg
+				i++
+			}
+		case "-merchant":
+			if i+1 < len(args) {
+				info, err := merchant.Load(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid merchant config: %v\n", err)
+					os.Exit(1)
+				}
+				config.Merchant = info
+				i++
+			}
+		case "-label-printer-ip":
+			if i+1 < len(args) {
+				config.LabelPrinterIP = args[i+1]
+				i++
+			}
+		case "-label-printer-port":
+			if i+1 < len(args) {
+				port, err := strconv.Atoi(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid label printer port: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.LabelPrinterPort = port
+				i++
+			}
+		case "-label-format":
+			if i+1 < len(args) {
+				format := strings.ToLower(args[i+1])
+				if format != label.ZPL && format != label.EPL {
+					fmt.Printf("Invalid label format: %s (use zpl or epl)\n", args[i+1])
+					os.Exit(1)
+				}
+				config.LabelFormat = format
+				i++
+			}
+		case "-label-layouts":
+			if i+1 < len(args) {
+				layouts, err := label.LoadLayouts(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid label layouts: %v\n", err)
+					os.Exit(1)
+				}
+				config.LabelLayouts = layouts
+				i++
+			}
+		case "-cut", "-ticket-cut":
+			if i+1 < len(args) {
+				cut, err := escpos.ParseCut(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid %s: %v\n", args[i], err)
+					os.Exit(1)
+				}
+				if args[i] == "-cut" {
+					config.Cut = cut
+				} else {
+					config.TicketCut = cut
+				}
+				i++
+			}
+		case "-beep", "-ticket-beep", "-error-beep":
+			if i+1 < len(args) {
+				beep, err := escpos.ParseBeep(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid %s: %v\n", args[i], err)
+					os.Exit(1)
+				}
+				switch args[i] {
+				case "-beep":
+					config.Beep = beep
+				case "-ticket-beep":
+					config.TicketBeep = beep
+				default:
+					config.ErrorBeep = beep
+				}
+				i++
+			}
+		case "-printer-model":
+			if i+1 < len(args) {
+				name, model, _ := strings.Cut(args[i+1], "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "" {
+					fmt.Printf("Invalid printer model %q, expected NAME=MODEL\n", args[i+1])
+					os.Exit(1)
+				}
+				if _, err := lookupCommandSet(model); err != nil {
+					fmt.Printf("Invalid printer model: %v\n", err)
+					os.Exit(1)
+				}
+				if config.PrinterModels == nil {
+					config.PrinterModels = make(map[string]string)
+				}
+				config.PrinterModels[name] = strings.ToLower(strings.TrimSpace(model))
+				i++
+			}
+		case "-code-page":
+			if i+1 < len(args) {
+				page, err := escpos.LookupCodePage(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid code page: %v\n", err)
+					os.Exit(1)
+				}
+				config.CodePage = page.Name
+				i++
+			}
+		case "-paper", "-ticket-paper":
+			if i+1 < len(args) {
+				size, err := paper.Parse(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid %s: %v\n", args[i], err)
+					os.Exit(1)
+				}
+				if args[i] == "-paper" {
+					config.Paper = size
+				} else {
+					config.TicketPaper = size
+				}
+				i++
+			}
+		case "-logo-width":
+			if i+1 < len(args) {
+				width, err := strconv.Atoi(args[i+1])
+				if err != nil || width <= 0 {
+					fmt.Printf("Invalid logo width: %s\n", args[i+1])
+					os.Exit(1)
+				}
+				config.LogoWidth = width
+				i++
+			}
+		case "-data-dir":
+			if i+1 < len(args) {
+				config.DataDir = args[i+1]
+				i++
+			}
+		case "-assets-dir":
+			if i+1 < len(args) {
+				config.AssetsDir = args[i+1]
+				i++
+			}
+		case "-locale":
+			if i+1 < len(args) {
+				format, err := money.Preset(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid locale: %v\n", err)
+					os.Exit(1)
+				}
+				config.Currency = format
+				i++
+			}
+		case "-currency-config":
+			if i+1 < len(args) {
+				format, err := money.LoadFormat(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid currency config: %v\n", err)
+					os.Exit(1)
+				}
+				config.Currency = format
+				i++
+			}
+		case "-language":
+			if i+1 < len(args) {
+				config.Language = args[i+1]
+				i++
+			}
+		case "-translations":
+			if i+1 < len(args) {
+				if err := i18n.Load(args[i+1]); err != nil {
+					fmt.Printf("Invalid translations: %v\n", err)
+					os.Exit(1)
+				}
+				i++
+			}
+		case "-tax-config":
+			if i+1 < len(args) {
+				taxConfig, err := tax.Load(args[i+1])
+				if err != nil {
+					fmt.Printf("Invalid tax config: %v\n", err)
+					os.Exit(1)
+				}
+				config.Tax = taxConfig
+				i++
+			}
+		case "-test":
+			server := NewServer(config)
+			if err := server.testPrinter(); err != nil {
+				fmt.Printf("❌ Printer test failed: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "-help":
+			showUsage()
+			return
+		default:
+			fmt.Printf("Unknown option: %s\n", args[i])
+			showUsage()
+			os.Exit(1)
+		}
+	}
+
+	// Compiled once every flag is read, since money() formats in the
+	// -locale or -currency-config currency
+	if scriptedFieldsPath != "" {
+		cfg, err := script.Load(scriptedFieldsPath, scriptFuncs(config.Currency))
+		if err != nil {
+			fmt.Printf("Invalid scripted fields: %v\n", err)
+			os.Exit(1)
+		}
+		config.ScriptedFields = cfg
+	}
+
+	if !i18n.Supported(config.Language) {
+		fmt.Printf("Warning: no strings for language %q, receipts will print in English\n", config.Language)
+	}
+
+	// Create server
+	server := NewServer(config)
+	if err := server.templates.Preload(i18n.New(config.Language).Language(), "receipt"); err != nil {
+		fmt.Printf("Error in the receipt template: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Receipt Print Server v2.0 Starting...\n")
+	fmt.Printf("Printer: %s:%d\n", config.PrinterIP, config.PrinterPort)
+	fmt.Printf("Press Ctrl+C to stop\n\n")
+
+	// Test printer connectivity
+	if ok, err := server.attachedPrinterOnline(server.printerHost("receipt", config.PrinterIP)); ok {
+		if err != nil {
+			server.logger.Printf("⚠️  Warning: Cannot open the receipt printer: %v", err)
+		} else {
+			server.logger.Printf("✅ Printer connection test successful")
+		}
+	} else if conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.PrinterIP, strconv.Itoa(config.PrinterPort)), 2*time.Second); err != nil {
+		server.logger.Printf("⚠️  Warning: Cannot reach printer at %s:%d", config.PrinterIP, config.PrinterPort)
+	} else {
+		conn.Close()
+		server.logger.Printf("✅ Printer connection test successful")
+	}
+
+	// Setup graceful shutdown
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		<-c
+		
+		server.logger.Printf("Received shutdown signal")
+		if err := server.Shutdown(); err != nil {
+			server.logger.Printf("Error during shutdown: %v", err)
+		}
+		os.Exit(0)
+	}()
+
+	// Start server
+	if err := server.Start(); err != nil && err != http.ErrServerClosed {
+		log.Fatal("Server failed to start:", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"GoScanRentalTide/internal/sequence"
+)
+
+// Helper function to open the receipt numbers on first use. They have
+// their own file so the scanner agent, which may share the data
+// directory, keeps a separate count. A failure isn't kept: the next
+// receipt tries again.
+func (s *Server) receiptNumbers() (*sequence.Counters, error) {
+	s.numbersMu.Lock()
+	defer s.numbersMu.Unlock()
+	if s.numbers == nil {
+		numbers, err := sequence.Open(filepath.Join(s.config.DataDir, "receipt-server-numbers.json"))
+		if err != nil {
+			return nil, err
+		}
+		s.numbers = numbers
+	}
+	return s.numbers, nil
+}
+
+// Give a receipt the next number of its station, when ReceiptNumbers is
+// on. No-sales aren't receipts of a sale, so they aren't numbered. The
+// station's other receipts wait until finishReceiptNumber says whether
+// this one printed.
+func (s *Server) numberReceipt(ctx context.Context, receipt *ReceiptData) (*sequence.Reservation, error) {
+	if !s.config.ReceiptNumbers || receipt.Type == "noSale" {
+		return nil, nil
+	}
+	numbers, err := s.receiptNumbers()
+	var number *sequence.Reservation
+	if err == nil {
+		number, err = numbers.Reserve(ctx, receipt.StationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Receipt not printed, it could not be numbered: %v", err)
+	}
+	receipt.ReceiptNumber = number.Number
+	return number, nil
+}
+
+// Keep the number of a receipt that printed or was spooled, and hand
+// back the number of one that didn't, so the sequence doesn't skip it
+func (s *Server) finishReceiptNumber(ctx context.Context, number *sequence.Reservation, printed bool) {
+	if err := number.Done(printed); err != nil {
+		s.logf(ctx, "⚠️  Failed to release receipt number %d: %v", number.Number, err)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/audit"
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/sanitize"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// Sales for one payment type over a shift
+type ReportPayment struct {
+	Type   string      `json:"type"`
+	Count  int         `json:"count"`
+	Amount money.Cents `json:"amount"`
+}
+
+// Tax collected under one rate
+type ReportTax struct {
+	Name   string      `json:"name"`
+	Amount money.Cents `json:"amount"`
+}
+
+// Count of one coin or bill in the drawer
+type ReportDenomination struct {
+	Label string      `json:"label"` // e.g. "$20" or "Quarters"
+	Count int         `json:"count"`
+	Value money.Cents `json:"value"` // Value of one coin or bill
+}
+
+// Cash drawer reconciliation for a shift
+type ReportDrawer struct {
+	OpeningFloat  money.Cents          `json:"openingFloat"`
+	CashRefunds   money.Cents          `json:"cashRefunds"`
+	PaidOuts      money.Cents          `json:"paidOuts"`
+	Counted       money.Cents          `json:"counted"`
+	Denominations []ReportDenomination `json:"denominations"`
+	// Expected cash in the drawer; computed from the float, cash sales,
+	// refunds and paid-outs when not given
+	Expected *money.Cents `json:"expected"`
+}
+
+// Shift summary for an X (mid-shift) or Z (end-of-day) report
+type ReportRequest struct {
+	Type        string          `json:"type"` // "x" or "z" (default)
+	ShiftID     string          `json:"shiftId"`
+	Register    string          `json:"register"`
+	Cashier     string          `json:"cashier"`
+	Location    string          `json:"location"`
+	OpenedAt    string          `json:"openedAt"`
+	ClosedAt    string          `json:"closedAt"`
+	Sales       []ReportPayment `json:"sales"`
+	Taxes       []ReportTax     `json:"taxes"`
+	Discounts   money.Cents     `json:"discounts"`
+	RefundCount int             `json:"refundCount"`
+	Refunds     money.Cents     `json:"refunds"`
+	NoSaleCount int             `json:"noSaleCount"`
+	Drawer      *ReportDrawer   `json:"drawer"`
+}
+
+type ReportResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	// Z reports only: the same shift always gets the same number
+	ReportNumber int  `json:"reportNumber,omitempty"`
+	Reprint      bool `json:"reprint,omitempty"`
+}
+
+// Clean the text of a report, so control characters don't reach the
+// printer as commands
+func sanitizeReport(report *ReportRequest) {
+	sanitize.Lines(sanitize.MaxName, &report.Type, &report.ShiftID, &report.Register, &report.Cashier, &report.Location,
+		&report.OpenedAt, &report.ClosedAt)
+	for i := range report.Sales {
+		sanitize.Lines(sanitize.MaxName, &report.Sales[i].Type)
+	}
+	for i := range report.Taxes {
+		sanitize.Lines(sanitize.MaxName, &report.Taxes[i].Name)
+	}
+	if report.Drawer != nil {
+		for i := range report.Drawer.Denominations {
+			sanitize.Lines(sanitize.MaxName, &report.Drawer.Denominations[i].Label)
+		}
+	}
+}
+
+// Z report number assigned to a shift, kept in the report journal
+type reportEntry struct {
+	Number    int       `json:"number"`
+	ShiftID   string    `json:"shiftId"`
+	PrintedAt time.Time `json:"printedAt"`
+	RequestID string    `json:"requestId,omitempty"` // The request that printed it first
+}
+
+// Path of the Z report number journal
+func (s *Server) reportJournalPath() (string, error) {
+	if err := os.MkdirAll(s.config.DataDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create data directory: %v", err)
+	}
+	return filepath.Join(s.config.DataDir, "zreports.jsonl"), nil
+}
+
+// Find the Z report number for a shift, assigning the next one the first
+// time the shift is reported. Reprints reuse the original number so the
+// sequence has no gaps or duplicates.
+func (s *Server) reportNumber(ctx context.Context, shiftID string) (int, bool, error) {
+	s.reportMu.Lock()
+	defer s.reportMu.Unlock()
+
+	path, err := s.reportJournalPath()
+	if err != nil {
+		return 0, false, err
+	}
+
+	last := 0
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, false, fmt.Errorf("failed to open report journal: %v", err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var entry reportEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				s.logf(ctx, "Skipping corrupt report journal entry: %v", err)
+				continue
+			}
+			if entry.ShiftID == shiftID {
+				file.Close()
+				return entry.Number, true, nil
+			}
+			if entry.Number > last {
+				last = entry.Number
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return 0, false, fmt.Errorf("failed to read report journal: %v", err)
+		}
+	}
+
+	entry := reportEntry{Number: last + 1, ShiftID: shiftID, PrintedAt: time.Now(), RequestID: tracing.RequestID(ctx)}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return 0, false, err
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to open report journal: %v", err)
+	}
+	defer out.Close()
+	if _, err := out.Write(append(line, '\n')); err != nil {
+		return 0, false, fmt.Errorf("failed to write report journal: %v", err)
+	}
+	return entry.Number, false, nil
+}
+
+// Expected cash in the drawer: the float plus cash sales, less cash paid
+// back out
+func expectedCash(report ReportRequest) money.Cents {
+	if report.Drawer.Expected != nil {
+		return *report.Drawer.Expected
+	}
+	expected := report.Drawer.OpeningFloat - report.Drawer.CashRefunds - report.Drawer.PaidOuts
+	for _, sale := range report.Sales {
+		if strings.EqualFold(sale.Type, "cash") {
+			expected += sale.Amount
+		}
+	}
+	return expected
+}
+
+// Format an X/Z report for ESC/POS
+func (s *Server) formatReport(report ReportRequest, number int, reprint bool) string {
+	builder := s.newThermalBuilder(s.commandSet("receipt"))
+
+	builder.Align(escpos.AlignCenter)
+	if report.Location != "" {
+		builder.Bold(true)
+		builder.WriteString(report.Location + "\n")
+		builder.Bold(false)
+	}
+	builder.Size(2, 2)
+	if report.Type == "x" {
+		builder.WriteString("X REPORT\n")
+	} else {
+		builder.WriteString(fmt.Sprintf("Z REPORT #%d\n", number))
+	}
+	builder.Size(1, 1)
+	if report.Type == "x" {
+		builder.WriteString("Shift in progress - not a close\n")
+	}
+	if reprint {
+		builder.Bold(true)
+		builder.WriteString("*** REPRINT ***\n")
+		builder.Bold(false)
+	}
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString(divider("=", s.paper()))
+
+	if report.ShiftID != "" {
+		builder.WriteString(s.formatReceiptLine("Shift:", report.ShiftID))
+	}
+	if report.Register != "" {
+		builder.WriteString(s.formatReceiptLine("Register:", report.Register))
+	}
+	if report.Cashier != "" {
+		builder.WriteString(s.formatReceiptLine("Cashier:", report.Cashier))
+	}
+	if report.OpenedAt != "" {
+		builder.WriteString(s.formatReceiptLine("Opened:", report.OpenedAt))
+	}
+	if report.ClosedAt != "" {
+		builder.WriteString(s.formatReceiptLine("Closed:", report.ClosedAt))
+	}
+	builder.WriteString(s.formatReceiptLine("Printed:", time.Now().Format("2006-01-02 15:04")))
+
+	// Sales by payment type
+	builder.WriteString(divider("-", s.paper()))
+	builder.Bold(true)
+	builder.WriteString("SALES\n")
+	builder.Bold(false)
+	var salesTotal money.Cents
+	var salesCount int
+	for _, sale := range report.Sales {
+		label := fmt.Sprintf("%s (%d):", formatPaymentType(sale.Type, false, false), sale.Count)
+		builder.WriteString(s.formatReceiptLine(label, s.money(sale.Amount)))
+		salesTotal += sale.Amount
+		salesCount += sale.Count
+	}
+	builder.Bold(true)
+	builder.WriteString(s.formatReceiptLine(fmt.Sprintf("Total sales (%d):", salesCount), s.money(salesTotal)))
+	builder.Bold(false)
+	if report.Discounts != 0 {
+		builder.WriteString(s.formatReceiptLine("Discounts:", "-"+s.money(report.Discounts)))
+	}
+	if report.RefundCount > 0 || report.Refunds != 0 {
+		builder.WriteString(s.formatReceiptLine(fmt.Sprintf("Refunds (%d):", report.RefundCount), "-"+s.money(report.Refunds)))
+	}
+	builder.WriteString(s.formatReceiptLine("Net sales:", s.money(salesTotal-report.Refunds)))
+	if report.NoSaleCount > 0 {
+		builder.WriteString(s.formatReceiptLine("No-sale opens:", fmt.Sprintf("%d", report.NoSaleCount)))
+	}
+
+	// Tax collected
+	if len(report.Taxes) > 0 {
+		builder.WriteString(divider("-", s.paper()))
+		builder.Bold(true)
+		builder.WriteString("TAX COLLECTED\n")
+		builder.Bold(false)
+		var taxTotal money.Cents
+		for _, line := range report.Taxes {
+			builder.WriteString(s.formatReceiptLine(line.Name+":", s.money(line.Amount)))
+			taxTotal += line.Amount
+		}
+		builder.WriteString(s.formatReceiptLine("Total tax:", s.money(taxTotal)))
+	}
+
+	// Drawer count and over/short
+	if report.Drawer != nil {
+		drawer := report.Drawer
+		builder.WriteString(divider("-", s.paper()))
+		builder.Bold(true)
+		builder.WriteString("CASH DRAWER\n")
+		builder.Bold(false)
+		builder.WriteString(s.formatReceiptLine("Opening float:", s.money(drawer.OpeningFloat)))
+		if drawer.CashRefunds != 0 {
+			builder.WriteString(s.formatReceiptLine("Cash refunds:", "-"+s.money(drawer.CashRefunds)))
+		}
+		if drawer.PaidOuts != 0 {
+			builder.WriteString(s.formatReceiptLine("Paid outs:", "-"+s.money(drawer.PaidOuts)))
+		}
+		for _, d := range drawer.Denominations {
+			builder.WriteString(s.formatReceiptLine(fmt.Sprintf("  %s x %d", d.Label, d.Count), s.money(d.Value.Times(float64(d.Count)))))
+		}
+		expected := expectedCash(report)
+		builder.WriteString(s.formatReceiptLine("Expected:", s.money(expected)))
+		builder.WriteString(s.formatReceiptLine("Counted:", s.money(drawer.Counted)))
+
+		overShort := drawer.Counted - expected
+		label := "Over/short:"
+		switch {
+		case overShort > 0:
+			label = "OVER:"
+		case overShort < 0:
+			label = "SHORT:"
+		}
+		builder.Bold(true)
+		builder.WriteString(s.formatReceiptLine(label, s.money(overShort)))
+		builder.Bold(false)
+	}
+
+	builder.WriteString(divider("=", s.paper()))
+	if report.Type != "x" {
+		builder.WriteString("\n")
+		builder.WriteString("Manager: ______________________\n")
+	}
+	builder.Cut(s.cut()) // Feed and cut paper
+	builder.Beep(s.config.Beep)
+
+	return builder.String()
+}
+
+// Handler: Print X/Z report
+func (s *Server) handlePrintReport(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var report ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		s.sendBodyError(w, err)
+		return
+	}
+
+	sanitizeReport(&report)
+	report.Type = strings.ToLower(strings.TrimSpace(report.Type))
+	if report.Type == "" {
+		report.Type = "z"
+	}
+	if report.Type != "x" && report.Type != "z" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "type must be \"x\" or \"z\"")
+		return
+	}
+	report.ShiftID = strings.TrimSpace(report.ShiftID)
+	if report.Type == "z" && report.ShiftID == "" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "shiftId is required for a Z report")
+		return
+	}
+
+	// X reports are readings only; Z reports are numbered once per shift
+	resp := ReportResponse{Success: true}
+	if report.Type == "z" {
+		number, reprint, err := s.reportNumber(r.Context(), report.ShiftID)
+		if err != nil {
+			s.sendErrorResponse(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp.ReportNumber = number
+		resp.Reprint = reprint
+	}
+
+	printerAddress, err := s.resolvePrinterAddress()
+	if err == nil {
+		err = s.printSingleCopy(r.Context(), printerAddress, s.formatReport(report, resp.ReportNumber, resp.Reprint), 1)
+	}
+	if err != nil {
+		s.logf(r.Context(), "Report failed to print: %v", err)
+		s.errorBeep()
+		s.sendJSONResponse(w, http.StatusInternalServerError, ReportResponse{
+			Success:      false,
+			Message:      fmt.Sprintf("Failed to print report: %v", err),
+			ReportNumber: resp.ReportNumber,
+		})
+		return
+	}
+
+	switch {
+	case report.Type == "x":
+		resp.Message = "X report printed successfully"
+	case resp.Reprint:
+		resp.Message = fmt.Sprintf("Z report #%d reprinted", resp.ReportNumber)
+		s.recordAudit(r, report.Cashier, audit.Event{
+			Action: audit.Reprint,
+			Detail: fmt.Sprintf("Z report #%d for shift %s", resp.ReportNumber, report.ShiftID),
+		})
+	default:
+		resp.Message = fmt.Sprintf("Z report #%d printed successfully", resp.ReportNumber)
+	}
+	s.logf(r.Context(), "📊 %s for shift %s", resp.Message, report.ShiftID)
+	s.sendJSONResponse(w, http.StatusOK, resp)
+}
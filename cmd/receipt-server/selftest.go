@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/audit"
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/paper"
+)
+
+// An ESC/POS printer and how it is set up
+type thermalPrinter struct {
+	Host  string
+	Port  int
+	Paper paper.Size
+	Cut   escpos.Cut
+	Beep  escpos.Beep
+	// Commands is the command set of the printer's model
+	Commands escpos.CommandSet
+}
+
+// Find a printer by the name used in /printers/{name}: "receipt",
+// "ticket" or a station with its own printer
+func (s *Server) namedPrinter(name string) (thermalPrinter, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	printer := thermalPrinter{Paper: s.ticketPaper(), Cut: s.ticketCut(), Beep: s.config.TicketBeep}
+	var err error
+	switch {
+	case name == "receipt":
+		return thermalPrinter{s.printerHost("receipt", s.config.PrinterIP), s.config.PrinterPort, s.paper(), s.cut(), s.config.Beep, s.commandSet("receipt")}, nil
+	case name == "ticket":
+		printer.Host, printer.Port, err = s.ticketPrinter("")
+		printer.Commands = s.commandSet(s.ticketPrinterName(""))
+	case s.config.StationPrinters[name] != "" || s.isAttached(name):
+		printer.Host, printer.Port, err = s.ticketPrinter(name)
+		printer.Commands = s.commandSet(s.ticketPrinterName(name))
+	default:
+		err = fmt.Errorf("unknown printer %q (printers: %s)", name, strings.Join(s.printerNames(), ", "))
+	}
+	return printer, err
+}
+
+// Names of the ESC/POS printers, for messages
+func (s *Server) printerNames() []string {
+	var stations []string
+	for station := range s.config.StationPrinters {
+		stations = append(stations, station)
+	}
+	for name := range s.config.Printers {
+		if _, ok := s.config.StationPrinters[name]; !ok && name != "receipt" && name != "ticket" && name != "label" {
+			stations = append(stations, name)
+		}
+	}
+	sort.Strings(stations)
+	return append([]string{"receipt", "ticket"}, stations...)
+}
+
+// What the self-test barcodes hold, so a scanner can check them
+const selfTestBarcode = "RT-SELFTEST-01"
+
+// A test pattern for raster support: a frame around a checkerboard,
+// as wide as the paper's printable area
+func selfTestPattern(width int) image.Image {
+	const height = 48
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			frame := x < 3 || y < 3 || x >= width-3 || y >= height-3
+			checker := (x/12+y/12)%2 == 0
+			if frame || checker {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// Format the self-test page: what the printer is set up as, then a
+// sample of each feature receipts rely on, so an installer can see what
+// the printer supports
+func (s *Server) formatSelfTest(name, address string, printer thermalPrinter, logoURL string, drawer bool) string {
+	builder := s.newThermalBuilder(printer.Commands)
+	size := printer.Paper
+
+	page := builder.page
+
+	heading := func(title string) {
+		builder.WriteString("\n")
+		builder.Bold(true)
+		builder.WriteString(title + "\n")
+		builder.Bold(false)
+	}
+
+	builder.Align(escpos.AlignCenter)
+	builder.Size(2, 2)
+	builder.WriteString("SELF-TEST\n")
+	builder.Size(1, 1)
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString(divider("=", size))
+	builder.WriteString(fmt.Sprintf("Printer:   %s\n", name))
+	builder.WriteString(fmt.Sprintf("Address:   %s\n", address))
+	builder.WriteString(fmt.Sprintf("Paper:     %s\n", size))
+	builder.WriteString(fmt.Sprintf("Cut:       %s\n", printer.Cut))
+	builder.WriteString(fmt.Sprintf("Beep:      %s\n", printer.Beep))
+	builder.WriteString(fmt.Sprintf("Commands:  %s\n", printer.Commands.Name()))
+	builder.WriteString(fmt.Sprintf("Code page: %s (ESC t %d)\n", page.Name, page.Number))
+	builder.WriteString(fmt.Sprintf("Printed:   %s\n", time.Now().Format("2006-01-02 15:04")))
+
+	// Every column should print, with nothing wrapping onto a second line
+	heading("COLUMN RULER")
+	var tens, units strings.Builder
+	for i := 1; i <= size.Columns; i++ {
+		if i%10 == 0 {
+			tens.WriteString(strconv.Itoa(i / 10 % 10))
+		} else {
+			tens.WriteString(" ")
+		}
+		units.WriteString(strconv.Itoa(i % 10))
+	}
+	builder.WriteString(tens.String() + "\n")
+	builder.WriteString(units.String() + "\n")
+
+	// ASCII is safe on any firmware; the upper half shows the code page
+	// as the printer has it, which should match the name above
+	heading("CHARACTER SET")
+	for row := 0x20; row < 0x100; row += 0x10 {
+		builder.WriteString(fmt.Sprintf("%Xx  ", row>>4))
+		for b := row; b < row+0x10; b++ {
+			if b == 0x7F {
+				builder.WriteString(" ")
+				continue
+			}
+			builder.Write([]byte{byte(b)})
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("Accents: Côté, Ça coûte 5 €\n")
+
+	heading("TEXT STYLES")
+	builder.WriteString("Normal\n")
+	builder.Bold(true)
+	builder.WriteString("Bold")
+	builder.Bold(false)
+	builder.WriteString("\n")
+	builder.Underline(true)
+	builder.WriteString("Underline")
+	builder.Underline(false)
+	builder.WriteString("\n")
+	builder.Invert(true)
+	builder.WriteString(" Inverted ")
+	builder.Invert(false)
+	builder.WriteString("\n")
+	builder.Size(1, 2)
+	builder.WriteString("Double height")
+	builder.Size(1, 1)
+	builder.WriteString("\n")
+	builder.Size(2, 1)
+	builder.WriteString("Double width")
+	builder.Size(1, 1)
+	builder.WriteString("\n")
+
+	heading("BARCODES")
+	builder.Align(escpos.AlignCenter)
+	builder.Code128(selfTestBarcode, 80)
+	builder.WriteString("\n")
+	builder.QRCode(selfTestBarcode, 6)
+	builder.WriteString("\n")
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString("CODE128 and QR code of " + selfTestBarcode + "\n")
+
+	heading("LOGO")
+	builder.Align(escpos.AlignCenter)
+	builder.Raster(escpos.Raster(selfTestPattern(size.Dots()), size.Dots()))
+	builder.WriteString("\n")
+	if logoURL != "" {
+		if logo, err := s.logoRaster(logoURL, min(s.logoWidth(), size.Dots())); err != nil {
+			builder.Align(escpos.AlignLeft)
+			for _, line := range wrapText(page.Transliterate(fmt.Sprintf("Logo failed: %v", err)), size.Columns) {
+				builder.WriteString(line + "\n")
+			}
+		} else {
+			builder.Raster(logo)
+			builder.WriteString("\n")
+		}
+	}
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString("Framed checkerboard, full width\n")
+
+	heading("CASH DRAWER")
+	if drawer {
+		builder.DrawerKick(2)
+		builder.DrawerKick(5)
+		builder.WriteString("Kick sent on pins 2 and 5\n")
+	} else {
+		builder.WriteString("Skipped\n")
+	}
+
+	builder.WriteString(divider("=", size))
+	builder.Cut(printer.Cut) // Feed and cut paper
+	builder.Beep(printer.Beep)
+
+	return builder.String()
+}
+
+// Handler: Print a self-test page on a printer. ?logoUrl= adds a logo to
+// the logo test and ?drawer=false leaves the cash drawer shut.
+func (s *Server) handlePrinterSelfTest(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.PathValue("name")
+	printer, err := s.namedPrinter(name)
+	if err != nil {
+		s.sendErrorResponse(w, http.StatusNotFound, err.Error())
+		return
+	}
+	drawer := true
+	if value := r.URL.Query().Get("drawer"); value != "" {
+		if drawer, err = strconv.ParseBool(value); err != nil {
+			s.sendErrorResponse(w, http.StatusBadRequest, fmt.Sprintf("Invalid drawer value %q", value))
+			return
+		}
+	}
+
+	if drawer && !s.auditDrawer(w, r, "", audit.Event{Action: audit.DrawerOpen, Detail: "self-test page on " + name}) {
+		return
+	}
+	host, err := s.resolvePrinterHost(printer.Host)
+	if err == nil {
+		address := joinPrinterAddress(host, printer.Port)
+		s.logf(r.Context(), "🔧 Printing self-test page on %s (%s)", name, address)
+		err = s.sendToPrinter(r.Context(), address, s.formatSelfTest(name, address, printer, r.URL.Query().Get("logoUrl"), drawer))
+	}
+	if err != nil {
+		s.logf(r.Context(), "Self-test failed to print: %v", err)
+		s.sendJSONResponse(w, http.StatusInternalServerError, PrintResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to print self-test page: %v", err),
+		})
+		return
+	}
+
+	s.sendJSONResponse(w, http.StatusOK, PrintResponse{
+		Success: true,
+		Message: fmt.Sprintf("Self-test page printed on %s", name),
+	})
+}
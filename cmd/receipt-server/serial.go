@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/transport"
+
+	"go.bug.st/serial"
+)
+
+// Printers with Transport "serial" get addresses of this scheme in place
+// of host names, followed by their port
+const serialScheme = "serial://"
+
+// How long a serial printer may hold off with flow control (out of paper,
+// cover open, a slow logo) before the print fails
+const serialFlowTimeout = 30 * time.Second
+
+// Opens serial ports, as the agent opens its scanner and display; tests
+// replace it
+var serialPorts transport.Transport = transport.Serial(serial.Open)
+
+// Helper function to get the port of a serial:// address
+func serialDevice(address string) (string, bool) {
+	return strings.CutPrefix(address, serialScheme)
+}
+
+// Helper function to parse a -serial-printer value, NAME=PORT[:BAUD[:FLOW]]
+func parseSerialPrinter(value string) (string, PrinterTransport, error) {
+	name, settings, _ := strings.Cut(value, "=")
+	name = strings.ToLower(strings.TrimSpace(name))
+	parts := strings.Split(settings, ":")
+	printer := PrinterTransport{Transport: "serial", Device: strings.TrimSpace(parts[0])}
+	if name == "" || printer.Device == "" || len(parts) > 3 {
+		return "", PrinterTransport{}, fmt.Errorf("invalid serial printer %q, expected NAME=PORT[:BAUD[:FLOW]]", value)
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		baud, err := strconv.Atoi(parts[1])
+		if err != nil || baud <= 0 {
+			return "", PrinterTransport{}, fmt.Errorf("invalid baud rate %q", parts[1])
+		}
+		printer.Baud = baud
+	}
+	if len(parts) > 2 {
+		printer.FlowControl = parts[2]
+	}
+	if err := checkSerialPrinter(printer); err != nil {
+		return "", PrinterTransport{}, err
+	}
+	return name, printer, nil
+}
+
+// Helper function to check a serial printer's settings
+func checkSerialPrinter(printer PrinterTransport) error {
+	if _, err := serialMode(printer); err != nil {
+		return err
+	}
+	_, err := transport.ParseFlowControl(printer.FlowControl)
+	return err
+}
+
+// Helper function to get a serial printer's port settings: 9600 baud,
+// 8 data bits, no parity and one stop bit unless it sets them
+func serialMode(printer PrinterTransport) (*serial.Mode, error) {
+	mode := &serial.Mode{
+		BaudRate: 9600,
+		DataBits: 8,
+		Parity:   serial.NoParity,
+		StopBits: serial.OneStopBit,
+	}
+	if printer.Baud > 0 {
+		mode.BaudRate = printer.Baud
+	}
+	if printer.DataBits != 0 {
+		if printer.DataBits < 5 || printer.DataBits > 8 {
+			return nil, fmt.Errorf("invalid data bits %d (use 5 to 8)", printer.DataBits)
+		}
+		mode.DataBits = printer.DataBits
+	}
+	switch strings.ToLower(printer.Parity) {
+	case "", "none":
+	case "odd":
+		mode.Parity = serial.OddParity
+	case "even":
+		mode.Parity = serial.EvenParity
+	default:
+		return nil, fmt.Errorf("unknown parity %q (use none, odd or even)", printer.Parity)
+	}
+	return mode, nil
+}
+
+// Helper function to open the printer on a serial port with the settings
+// Config.Printers has for it
+func (s *Server) openSerialPrinter(port string) (transport.Conn, transport.FlowControl, error) {
+	printer := PrinterTransport{Transport: "serial", Device: port}
+	for _, p := range s.config.Printers {
+		if strings.EqualFold(p.Transport, "serial") && p.Device == port {
+			printer = p
+			break
+		}
+	}
+	mode, err := serialMode(printer)
+	if err != nil {
+		return nil, "", err
+	}
+	flow, err := transport.ParseFlowControl(printer.FlowControl)
+	if err != nil {
+		return nil, "", err
+	}
+	conn, err := serialPorts.Open(port, mode)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to open serial printer on %s: %v", port, err)
+	}
+	return conn, flow, nil
+}
+
+// Send raw data to a printer on a serial port, pausing whenever its flow
+// control says its buffer is full. A cancelled ctx closes the port to end
+// the write.
+func (s *Server) sendToSerial(ctx context.Context, port, content string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("print cancelled: %w", err)
+	}
+	conn, flow, err := s.openSerialPrinter(port)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	err = transport.WriteFlow(conn, []byte(content), flow, serialFlowTimeout)
+	if !stop() {
+		return fmt.Errorf("print cancelled: %w", ctx.Err())
+	}
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send data to serial printer on %s: %v", port, err)
+	}
+	return nil
+}
+
+// Helper function to check that a serial printer's port can be opened,
+// without printing anything
+func (s *Server) serialPrinterOnline(port string) error {
+	conn, _, err := s.openSerialPrinter(port)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/sanitize"
+)
+
+// What a payment type's slip carries, for terminals that don't print
+// their own
+type SlipOptions struct {
+	Tip          bool `json:"tip"`           // Tip and total lines, left blank to fill in
+	Signature    bool `json:"signature"`     // Signature line on the merchant copy
+	CustomerCopy bool `json:"customer_copy"` // A second copy for the customer
+}
+
+// Card slip request
+type SlipRequest struct {
+	TransactionID string      `json:"transactionId"`
+	PaymentType   string      `json:"paymentType"`
+	Amount        money.Cents `json:"amount"` // Before tip
+	Tip           money.Cents `json:"tip"`    // Tip already entered on the terminal; blanks are printed when 0
+	CardDetails   CardDetails `json:"cardDetails"`
+	TerminalId    string      `json:"terminalId"`
+	Location      string      `json:"location"`
+	Date          string      `json:"date"`
+	Language      string      `json:"language"`
+}
+
+type SlipResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message"`
+	Copies  int    `json:"copies"` // 0 when the payment type has no slip
+}
+
+// Slips printed when none are configured: signed credit slips, and debit
+// slips without a signature since debit cards are verified by PIN
+func defaultSlips() map[string]SlipOptions {
+	return map[string]SlipOptions{
+		"credit": {Tip: true, Signature: true, CustomerCopy: true},
+		"debit":  {Tip: true, CustomerCopy: true},
+	}
+}
+
+// Parse the options of -slip TYPE=OPTIONS: a comma-separated list of tip,
+// signature and copy, or "off" (nil) for no slip
+func parseSlipOptions(value string) (*SlipOptions, error) {
+	options := &SlipOptions{}
+	for _, option := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(option)) {
+		case "":
+		case "off":
+			return nil, nil
+		case "tip":
+			options.Tip = true
+		case "signature":
+			options.Signature = true
+		case "copy":
+			options.CustomerCopy = true
+		default:
+			return nil, fmt.Errorf("unknown slip option %q (use tip, signature, copy or off)", option)
+		}
+	}
+	return options, nil
+}
+
+// Slips by payment type: the defaults plus any set with -slip, which
+// replace the default for their type or turn it off
+func (s *Server) slips() map[string]SlipOptions {
+	slips := defaultSlips()
+	for paymentType, options := range s.config.Slips {
+		if options == nil {
+			delete(slips, paymentType)
+			continue
+		}
+		slips[paymentType] = *options
+	}
+	return slips
+}
+
+// Find the slip options for a payment type. Types such as "credit-visa"
+// fall back to their base type.
+func (s *Server) slipOptions(paymentType string) (SlipOptions, bool) {
+	slips := s.slips()
+	paymentType = strings.ToLower(strings.TrimSpace(paymentType))
+	if options, ok := slips[paymentType]; ok {
+		return options, true
+	}
+	options, ok := slips[strings.Split(paymentType, "-")[0]]
+	return options, ok
+}
+
+// Payment types that get a slip, for messages
+func (s *Server) slipTypes() string {
+	var types []string
+	for paymentType := range s.slips() {
+		types = append(types, paymentType)
+	}
+	sort.Strings(types)
+	return strings.Join(types, ", ")
+}
+
+// Format one copy of a card slip for ESC/POS
+func (s *Server) formatSlip(slip SlipRequest, options SlipOptions, merchantCopy bool) string {
+	builder := s.newThermalBuilder(s.commandSet("receipt"))
+	tr := s.translatorFor(slip.Language)
+
+	builder.Align(escpos.AlignCenter)
+	if slip.Location != "" {
+		builder.Bold(true)
+		builder.WriteString(slip.Location + "\n")
+		builder.Bold(false)
+	}
+	builder.Size(1, 2)
+	if merchantCopy {
+		builder.WriteString(tr.T("merchant_copy") + "\n")
+	} else {
+		builder.WriteString(tr.T("customer_copy") + "\n")
+	}
+	builder.Size(1, 1)
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString(divider("=", s.paper()))
+
+	builder.WriteString(s.formatReceiptLine(tr.T("transaction_id")+":", slip.TransactionID))
+	builder.WriteString(s.formatReceiptLine(tr.T("date")+":", slip.Date))
+	builder.WriteString(s.formatReceiptLine(tr.T("payment_method")+":", formatPaymentType(slip.PaymentType, false, false)))
+	if slip.CardDetails.CardBrand != "" || slip.CardDetails.CardLast4 != "" {
+		builder.WriteString(s.formatReceiptLine(tr.T("card")+":", cardText(slip.CardDetails, tr)))
+	}
+	if slip.CardDetails.AuthCode != "" {
+		builder.WriteString(s.formatReceiptLine(tr.T("auth_code")+":", slip.CardDetails.AuthCode))
+	}
+	if slip.TerminalId != "" {
+		builder.WriteString(s.formatReceiptLine(tr.T("terminal_id")+":", slip.TerminalId))
+	}
+	builder.WriteString(divider("-", s.paper()))
+
+	builder.WriteString(s.formatReceiptLine(tr.T("amount")+":", s.money(slip.Amount)))
+	if options.Tip {
+		// Blanks are long enough to write an amount in by hand
+		tip, total := "____________", "____________"
+		if slip.Tip > 0 {
+			tip, total = s.money(slip.Tip), s.money(slip.Amount+slip.Tip)
+		}
+		builder.WriteString("\n")
+		builder.WriteString(s.formatReceiptLine(tr.T("tip")+":", tip))
+		builder.WriteString("\n")
+		builder.Bold(true)
+		builder.WriteString(s.formatReceiptLine(tr.T("total")+":", total))
+		builder.Bold(false)
+	} else {
+		builder.Bold(true)
+		builder.WriteString(s.formatReceiptLine(tr.T("total")+":", s.money(slip.Amount+slip.Tip)))
+		builder.Bold(false)
+	}
+
+	if merchantCopy && options.Signature {
+		builder.WriteString("\n\n\n")
+		builder.WriteString("x" + strings.Repeat("_", s.paper().Columns-1) + "\n")
+		builder.WriteString(tr.T("customer_signature") + "\n")
+		for _, line := range wrapText(tr.T("agree_to_pay"), s.paper().Columns) {
+			builder.WriteString(line + "\n")
+		}
+	}
+
+	builder.WriteString(divider("=", s.paper()))
+	builder.Cut(s.cut()) // Feed and cut paper
+	builder.Beep(s.config.Beep)
+
+	return builder.String()
+}
+
+// Handler: Print a card slip
+func (s *Server) handlePrintSlip(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var slip SlipRequest
+	if err := json.NewDecoder(r.Body).Decode(&slip); err != nil {
+		s.sendBodyError(w, err)
+		return
+	}
+
+	sanitize.Lines(sanitize.MaxName, &slip.TransactionID, &slip.PaymentType, &slip.TerminalId, &slip.Location, &slip.Date, &slip.Language,
+		&slip.CardDetails.CardBrand, &slip.CardDetails.CardLast4, &slip.CardDetails.AuthCode)
+	if slip.TransactionID == "" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "transactionId is required")
+		return
+	}
+	if slip.Amount <= 0 {
+		s.sendErrorResponse(w, http.StatusBadRequest, "amount must be positive")
+		return
+	}
+	if slip.Tip < 0 {
+		s.sendErrorResponse(w, http.StatusBadRequest, "tip cannot be negative")
+		return
+	}
+	if slip.Date == "" {
+		slip.Date = time.Now().Format("2006-01-02 15:04")
+	}
+
+	// Payment types without a slip are answered, not refused, so the
+	// frontend can ask after every payment
+	options, ok := s.slipOptions(slip.PaymentType)
+	if !ok {
+		s.sendJSONResponse(w, http.StatusOK, SlipResponse{
+			Success: true,
+			Message: fmt.Sprintf("No slip is printed for %q payments (slips are printed for: %s)", slip.PaymentType, s.slipTypes()),
+		})
+		return
+	}
+
+	copies := []string{s.formatSlip(slip, options, true)}
+	if options.CustomerCopy {
+		copies = append(copies, s.formatSlip(slip, options, false))
+	}
+
+	printerAddress, err := s.resolvePrinterAddress()
+	for i := 0; err == nil && i < len(copies); i++ {
+		err = s.printSingleCopy(r.Context(), printerAddress, copies[i], i+1)
+	}
+	if err != nil {
+		s.logf(r.Context(), "Slip failed to print: %v", err)
+		s.errorBeep()
+		s.sendJSONResponse(w, http.StatusInternalServerError, SlipResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to print slip: %v", err),
+		})
+		return
+	}
+
+	s.logf(r.Context(), "🧾 Printed %d slip copies for transaction %s", len(copies), slip.TransactionID)
+	s.sendJSONResponse(w, http.StatusOK, SlipResponse{
+		Success: true,
+		Message: fmt.Sprintf("Slip for transaction %s printed successfully", slip.TransactionID),
+		Copies:  len(copies),
+	})
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/tracing"
+	"GoScanRentalTide/internal/webhook"
+)
+
+// A receipt that couldn't reach the printer, kept in <data dir>/spool
+// until /health finds the printer online again
+type SpooledJob struct {
+	ID            string    `json:"id"`
+	TransactionID string    `json:"transactionId"`
+	RequestID     string    `json:"requestId,omitempty"` // The print request that spooled it
+	Copies        int       `json:"copies"`              // Copies still to print
+	Spooled       time.Time `json:"spooled"`
+	Error         string    `json:"error"` // Why it didn't print
+	Data          []byte    `json:"data"`  // ESC/POS, as formatted when it was spooled
+}
+
+// Error from sendToThermalPrinter when the printer can't be reached,
+// with what is left to print
+type printerOfflineError struct {
+	content string
+	copies  int
+	err     error
+}
+
+func (e *printerOfflineError) Error() string {
+	return e.err.Error()
+}
+
+func (e *printerOfflineError) Unwrap() error {
+	return e.err
+}
+
+// Helper function to return the spool directory
+func (s *Server) spoolDir() string {
+	return filepath.Join(s.config.DataDir, "spool")
+}
+
+// Helper function to spool a receipt the printer didn't take. The file is
+// written under a temporary name and renamed, so a flush never reads half
+// a job.
+func (s *Server) spoolReceipt(ctx context.Context, receipt ReceiptData, offline *printerOfflineError) (SpooledJob, error) {
+	s.spoolMu.Lock()
+	defer s.spoolMu.Unlock()
+
+	job := SpooledJob{
+		ID:            "job-" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		TransactionID: receipt.TransactionID,
+		RequestID:     tracing.RequestID(ctx),
+		Copies:        offline.copies,
+		Spooled:       time.Now().UTC(),
+		Error:         offline.err.Error(),
+		Data:          []byte(offline.content),
+	}
+	if err := s.writeSpooledJob(job); err != nil {
+		return SpooledJob{}, err
+	}
+	s.webhooks.Send(webhook.PrintSpooled, map[string]interface{}{
+		"id":            job.ID,
+		"transactionId": job.TransactionID,
+		"requestId":     job.RequestID,
+		"copies":        job.Copies,
+		"error":         job.Error,
+	})
+	return job, nil
+}
+
+// Helper function to write a job to the spool, replacing an earlier
+// version of it
+func (s *Server) writeSpooledJob(job SpooledJob) error {
+	if err := os.MkdirAll(s.spoolDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create spool directory: %v", err)
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.spoolDir(), job.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to spool receipt: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to spool receipt: %v", err)
+	}
+	return nil
+}
+
+// Helper function to read the spool, oldest job first. IDs are the time
+// in nanoseconds, so they sort in the order the jobs were spooled.
+func (s *Server) spooledJobs() ([]SpooledJob, error) {
+	paths, err := filepath.Glob(filepath.Join(s.spoolDir(), "job-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var jobs []SpooledJob
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spooled receipt: %v", err)
+		}
+		var job SpooledJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			s.logger.Printf("⚠️  Skipping unreadable spooled receipt %s: %v", filepath.Base(path), err)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+	return jobs, nil
+}
+
+// Helper function to count the receipts waiting in the spool
+func (s *Server) spoolLength() int {
+	paths, _ := filepath.Glob(filepath.Join(s.spoolDir(), "job-*.json"))
+	return len(paths)
+}
+
+// Print the spooled receipts, oldest first, stopping at the first that
+// fails so the rest keep their order. Only one flush runs at a time; a
+// call while one is running returns at once.
+func (s *Server) flushSpool(ctx context.Context) {
+	if !s.flushing.CompareAndSwap(false, true) {
+		return
+	}
+	defer s.flushing.Store(false)
+	s.spoolMu.Lock()
+	defer s.spoolMu.Unlock()
+
+	jobs, err := s.spooledJobs()
+	if err != nil || len(jobs) == 0 {
+		if err != nil {
+			s.logf(ctx, "⚠️  Failed to read the spool: %v", err)
+		}
+		return
+	}
+	printerAddress, err := s.resolvePrinterAddress()
+	if err != nil {
+		s.logf(ctx, "⚠️  Spooled receipts not printed: %v", err)
+		return
+	}
+	address := joinPrinterAddress(printerAddress, s.config.PrinterPort)
+
+	s.logf(ctx, "🖨️  Printer is back online, printing %d spooled receipts", len(jobs))
+	var printed []string
+	var failure error
+	for _, job := range jobs {
+		for job.Copies > 0 {
+			if failure = s.sendToPrinter(ctx, address, string(job.Data)); failure != nil {
+				break
+			}
+			job.Copies--
+		}
+		if failure != nil {
+			// Copies that did print aren't printed again
+			job.Error = failure.Error()
+			if err := s.writeSpooledJob(job); err != nil {
+				s.logf(ctx, "⚠️  %v", err)
+			}
+			break
+		}
+		if err := os.Remove(filepath.Join(s.spoolDir(), job.ID+".json")); err != nil {
+			s.logf(ctx, "⚠️  Failed to remove spooled receipt %s: %v", job.ID, err)
+		}
+		printed = append(printed, job.TransactionID)
+	}
+
+	remaining := len(jobs) - len(printed)
+	if failure != nil {
+		s.logf(ctx, "⚠️  Spool flush stopped with %d receipts left: %v", remaining, failure)
+	} else {
+		s.logf(ctx, "✅ Spooled receipts printed: %s", strings.Join(printed, ", "))
+	}
+	if len(printed) > 0 {
+		s.webhooks.Send(webhook.SpoolFlushed, map[string]interface{}{
+			"printer":      address,
+			"printed":      len(printed),
+			"transactions": printed,
+			"remaining":    remaining,
+		})
+	}
+}
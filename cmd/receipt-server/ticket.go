@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/sanitize"
+)
+
+// Ticket item structure: what to prepare, without prices
+type TicketItem struct {
+	Name      string   `json:"name"`
+	Quantity  int      `json:"quantity"`
+	Modifiers []string `json:"modifiers"`
+	Notes     string   `json:"notes"`
+}
+
+// Kitchen/prep ticket request
+type TicketRequest struct {
+	OrderNumber  string       `json:"orderNumber"`
+	Station      string       `json:"station"`
+	Items        []TicketItem `json:"items"`
+	Notes        string       `json:"notes"`
+	CustomerName string       `json:"customerName"`
+	Time         string       `json:"time"`
+	Copies       int          `json:"copies"`
+	Language     string       `json:"language"`
+}
+
+// Clean the text of a ticket, so control characters don't reach the
+// printer as commands
+func sanitizeTicket(ticket *TicketRequest) {
+	sanitize.Lines(sanitize.MaxName, &ticket.OrderNumber, &ticket.Station, &ticket.CustomerName, &ticket.Time, &ticket.Language)
+	sanitize.Lines(sanitize.MaxText, &ticket.Notes)
+	for i := range ticket.Items {
+		item := &ticket.Items[i]
+		sanitize.Lines(sanitize.MaxName, &item.Name)
+		sanitize.Lines(sanitize.MaxText, &item.Notes)
+		for j := range item.Modifiers {
+			sanitize.Lines(sanitize.MaxName, &item.Modifiers[j])
+		}
+	}
+}
+
+// Split "host" or "host:port" into its parts
+func splitPrinterAddress(address string, defaultPort int) (string, int, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return "", 0, fmt.Errorf("empty printer address")
+	}
+	if !strings.Contains(address, ":") {
+		return address, defaultPort, nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid printer address '%s': %v", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid printer port in '%s'", address)
+	}
+	return host, port, nil
+}
+
+// Pick the printer for a station: its own printer, then the ticket
+// printer, then the receipt printer
+func (s *Server) ticketPrinter(station string) (string, int, error) {
+	switch name := s.ticketPrinterName(station); name {
+	case "receipt":
+		return s.printerHost("receipt", s.config.PrinterIP), s.config.PrinterPort, nil
+	case "ticket":
+		return s.printerHost("ticket", s.config.TicketPrinterIP), s.config.TicketPrinterPort, nil
+	default:
+		if s.isAttached(name) {
+			return s.printerHost(name, ""), 0, nil
+		}
+		return splitPrinterAddress(s.config.StationPrinters[name], s.config.TicketPrinterPort)
+	}
+}
+
+// Helper function to get the name of the printer a station's tickets go
+// to, as ticketPrinter picks it: the station, ticket or receipt
+func (s *Server) ticketPrinterName(station string) string {
+	station = strings.ToLower(strings.TrimSpace(station))
+	if _, ok := s.config.StationPrinters[station]; ok || s.isAttached(station) {
+		return station
+	}
+	if s.config.TicketPrinterIP != "" || s.isAttached("ticket") {
+		return "ticket"
+	}
+	return "receipt"
+}
+
+// Wrap text into lines of at most width characters, breaking at spaces
+func wrapText(text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		for utf8.RuneCountInString(word) > width {
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			runes := []rune(word)
+			lines = append(lines, string(runes[:width]))
+			word = string(runes[width:])
+		}
+		switch {
+		case line == "":
+			line = word
+		case utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) <= width:
+			line += " " + word
+		default:
+			lines = append(lines, line)
+			line = word
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Format a kitchen/prep ticket for ESC/POS, in large text so it can be
+// read from across a counter
+func (s *Server) formatTicket(ticket TicketRequest) string {
+	builder := s.newThermalBuilder(s.commandSet(s.ticketPrinterName(ticket.Station)))
+	tr := s.translatorFor(ticket.Language)
+	size := s.ticketPaper()
+	largeWidth := size.Columns / 2 // Double width halves the columns
+
+	builder.Align(escpos.AlignCenter)
+	if ticket.Station != "" {
+		builder.Bold(true)
+		builder.Size(2, 2)
+		builder.WriteString(strings.ToUpper(ticket.Station) + "\n")
+		builder.Bold(false)
+	}
+	builder.Size(3, 3)
+	builder.WriteString(fmt.Sprintf("#%s\n", ticket.OrderNumber))
+	builder.Size(1, 1)
+	if ticket.CustomerName != "" {
+		builder.Size(2, 2)
+		for _, line := range wrapText(ticket.CustomerName, largeWidth) {
+			builder.WriteString(line + "\n")
+		}
+		builder.Size(1, 1)
+	}
+	builder.Align(escpos.AlignLeft)
+	builder.WriteString(divider("=", size))
+
+	for _, item := range ticket.Items {
+		quantity := item.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		builder.Bold(true)
+		builder.Size(2, 2)
+		for _, line := range wrapText(fmt.Sprintf("%dx %s", quantity, item.Name), largeWidth) {
+			builder.WriteString(line + "\n")
+		}
+		builder.Bold(false)
+
+		// Modifiers and notes in double height only, to fit longer text
+		builder.Size(1, 2)
+		for _, modifier := range item.Modifiers {
+			for _, line := range wrapText("+ "+modifier, size.Columns-2) {
+				builder.WriteString("  " + line + "\n")
+			}
+		}
+		if item.Notes != "" {
+			builder.Bold(true)
+			for _, line := range wrapText("** "+item.Notes, size.Columns-2) {
+				builder.WriteString("  " + line + "\n")
+			}
+			builder.Bold(false)
+		}
+		builder.Size(1, 1)
+		builder.WriteString(divider("-", size))
+	}
+
+	if ticket.Notes != "" {
+		builder.Bold(true)
+		builder.Size(1, 2)
+		builder.WriteString(strings.ToUpper(tr.T("notes")) + ":\n")
+		for _, line := range wrapText(ticket.Notes, size.Columns) {
+			builder.WriteString(line + "\n")
+		}
+		builder.Size(1, 1)
+		builder.Bold(false)
+		builder.WriteString(divider("=", size))
+	}
+
+	builder.Align(escpos.AlignCenter)
+	builder.WriteString(fmt.Sprintf("%s %s - %s\n", tr.T("order"), ticket.OrderNumber, ticket.Time))
+	builder.Cut(s.ticketCut()) // Feed and cut paper
+	builder.Beep(s.config.TicketBeep)
+
+	return builder.String()
+}
+
+// Handler: Print kitchen/prep ticket
+func (s *Server) handlePrintTicket(w http.ResponseWriter, r *http.Request) {
+	s.enableCORS(w)
+
+	if r.Method == "OPTIONS" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if r.Method != "POST" {
+		s.sendErrorResponse(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var ticket TicketRequest
+	if err := json.NewDecoder(r.Body).Decode(&ticket); err != nil {
+		s.sendBodyError(w, err)
+		return
+	}
+
+	sanitizeTicket(&ticket)
+	if ticket.OrderNumber == "" {
+		s.sendErrorResponse(w, http.StatusBadRequest, "orderNumber is required")
+		return
+	}
+	if len(ticket.Items) == 0 {
+		s.sendErrorResponse(w, http.StatusBadRequest, "at least one item is required")
+		return
+	}
+	if errs := copiesError(ticket.Copies); errs != nil {
+		s.sendValidationError(w, errs)
+		return
+	}
+	if ticket.Copies <= 0 {
+		ticket.Copies = 1
+	}
+	if ticket.Time == "" {
+		ticket.Time = time.Now().Format("15:04")
+	}
+
+	host, port, err := s.ticketPrinter(ticket.Station)
+	if err == nil {
+		host, err = s.resolvePrinterHost(host)
+	}
+	if err != nil {
+		s.errorBeep()
+		s.sendJSONResponse(w, http.StatusInternalServerError, PrintResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to print ticket: %v", err),
+		})
+		return
+	}
+	address := joinPrinterAddress(host, port)
+
+	s.logf(r.Context(), "🍳 Printing ticket for order %s (station %q) on %s", ticket.OrderNumber, ticket.Station, address)
+
+	content := s.formatTicket(ticket)
+	for i := 1; i <= ticket.Copies; i++ {
+		if err := s.sendToPrinter(r.Context(), address, content); err != nil {
+			s.logf(r.Context(), "Ticket failed to print: %v", err)
+			s.errorBeep()
+			s.sendJSONResponse(w, http.StatusInternalServerError, PrintResponse{
+				Success: false,
+				Message: fmt.Sprintf("Failed to print ticket: %v", err),
+			})
+			return
+		}
+	}
+
+	s.sendJSONResponse(w, http.StatusOK, PrintResponse{
+		Success: true,
+		Message: fmt.Sprintf("Ticket for order %s printed successfully (%d %s)", ticket.OrderNumber, ticket.Copies,
+			map[bool]string{true: "copy", false: "copies"}[ticket.Copies == 1]),
+	})
+}
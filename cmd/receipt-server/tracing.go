@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"GoScanRentalTide/internal/tracing"
+)
+
+// Log a line prefixed with the ID of the request ctx belongs to (see
+// internal/tracing), if there is one
+func (s *Server) logf(ctx context.Context, format string, args ...interface{}) {
+	tracing.Logf(s.logger, ctx, format, args...)
+}
+
+// Helper function to add the request ID to a failure's JSON body, so a
+// client can quote it when reporting the problem
+func withRequestID(data interface{}, id string) interface{} {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(raw, &fields) != nil {
+		return data // Not an object
+	}
+	fields["requestId"], _ = json.Marshal(id)
+	return fields
+}
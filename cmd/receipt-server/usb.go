@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"GoScanRentalTide/internal/usbprint"
+)
+
+// Printers with Transport "usb" get addresses of this scheme in place of
+// host names, followed by their device
+const usbScheme = "usb://"
+
+// Opens a USB printer for writing; tests replace it
+var openUSBPrinter = usbprint.Open
+
+// Helper function to get the device of a usb:// address
+func usbDevice(address string) (string, bool) {
+	return strings.CutPrefix(address, usbScheme)
+}
+
+// Send raw data straight to a USB printer through the USB printer class
+// driver, with no spooler in between. A write blocks until the printer
+// takes the data, so a cancelled ctx closes the device to end it.
+func (s *Server) sendToUSB(ctx context.Context, device, content string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("print cancelled: %w", err)
+	}
+	printer, err := openUSBPrinter(device)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { printer.Close() })
+	_, err = printer.Write([]byte(content))
+	if !stop() {
+		return fmt.Errorf("print cancelled: %w", ctx.Err())
+	}
+	if closeErr := printer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send data to USB printer: %v", err)
+	}
+	return nil
+}
+
+// Helper function to check that a USB printer is attached and can be
+// opened, without printing anything
+func usbPrinterOnline(device string) error {
+	printer, err := openUSBPrinter(device)
+	if err != nil {
+		return err
+	}
+	return printer.Close()
+}
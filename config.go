@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The config file holds flag values keyed by flag name, e.g.
+//
+//	{"port": "COM4", "printer": "Receipt1", "print-rate-limit": 30}
+//
+// Flags given on the command line win over the file. `setup` writes it.
+// A setting that is an object or a list, like "printers", is given to its
+// flag as JSON.
+const configFileName = "config.json"
+
+// readConfigFile returns the settings in a config file; a missing file
+// has none
+func readConfigFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	values := make(map[string]interface{})
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&values); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	return values, nil
+}
+
+// applyConfigFile sets the flags named in the config file that were not
+// given on the command line
+func applyConfigFile(path string, flags *flag.FlagSet) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	given := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for name, value := range values {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("config %s: unknown setting %q", path, name)
+		}
+		if given[name] {
+			continue
+		}
+		text := fmt.Sprint(value)
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("config %s: invalid %s: %v", path, name, err)
+			}
+			text = string(data)
+		}
+		if err := flags.Set(name, text); err != nil {
+			return fmt.Errorf("config %s: invalid %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// writeConfigFile merges settings into the config file, keeping the
+// others, and replaces it atomically
+func writeConfigFile(path string, updates map[string]interface{}) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	for name, value := range updates {
+		values[name] = value
+	}
+
+	// encoding/json sorts map keys, which keeps diffs of the file small
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	return nil
+}
+
+// configKeys lists the settings in a config file, sorted
+func configKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
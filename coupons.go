@@ -0,0 +1,93 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/barcode"
+	"GoScanRentalTide/internal/coupons"
+	"GoScanRentalTide/internal/escpos"
+)
+
+// receiptCoupons rotates the -coupons config; nil when no coupons are
+// configured
+var receiptCoupons *coupons.Rotator
+
+// Height of coupon barcodes, in modules in HTML and dots on thermal paper
+const (
+	couponBarcodeModules = 40
+	couponBarcodeDots    = 60
+)
+
+// couponBlock is a coupon as rendered, with its barcode drawn
+type couponBlock struct {
+	coupons.Coupon
+	BarcodeImage template.URL
+}
+
+// pickCoupons gives a sale the coupons it prints: its own, or the next
+// ones of the -coupons rotation. Refunds and no-sales print none, and
+// neither do duplicates, so a reprint doesn't hand out a second coupon.
+func pickCoupons(receipt *ReceiptData) {
+	if receipt.Type == "noSale" || receipt.Type == "refund" || receipt.IsDuplicate {
+		receipt.Coupons = nil
+		return
+	}
+	if len(receipt.Coupons) > 0 || receiptCoupons == nil {
+		return
+	}
+	receipt.Coupons = receiptCoupons.Pick(time.Now(), receipt.Total)
+}
+
+// couponBlocks draws the barcodes of coupons and fills in their expiries
+func couponBlocks(list []coupons.Coupon) []couponBlock {
+	var rendered []couponBlock
+	for _, cp := range list {
+		cp.Expiry = cp.ExpiresOn(time.Now())
+		block := couponBlock{Coupon: cp}
+		if cp.Barcode != "" {
+			url, err := barcode.DataURL(cp.Barcode, couponBarcodeModules)
+			if err != nil {
+				log.Printf("Printing coupon %q without its barcode: %v", cp.Title, err)
+			}
+			block.BarcodeImage = template.URL(url)
+		}
+		rendered = append(rendered, block)
+	}
+	return rendered
+}
+
+// writeCoupons writes coupon blocks after the footer, each between cut
+// lines, with its barcode drawn by the printer
+func (b *thermalReceipt) writeCoupons(blocks []couponBlock) {
+	for _, block := range blocks {
+		b.WriteString(escLeft)
+		b.divider("- ")
+		b.WriteString(escCenter)
+		if block.Title != "" {
+			b.WriteString(escBoldOn)
+			b.text(block.Title)
+			b.WriteString(escBoldOff)
+		}
+		for _, line := range block.Lines() {
+			b.text(line)
+		}
+		if block.Barcode != "" {
+			code, err := escpos.Code128(block.Barcode, couponBarcodeDots)
+			if err != nil {
+				b.text(block.Barcode)
+			} else {
+				b.WriteString(string(code) + "\n")
+			}
+		}
+		if block.Expiry != "" {
+			b.text(b.tr.T("coupon_expires", block.Expiry))
+		}
+	}
+	if len(blocks) > 0 {
+		b.WriteString(escLeft)
+		b.text(strings.Repeat("- ", b.columns/2))
+	}
+}
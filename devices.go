@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// printerState is what the agent knows about a printer from its jobs.
+// The spooler only reports whether a job was accepted, so a printer
+// counts as offline when a job fails and online again when one succeeds;
+// paper levels are not visible through it.
+type printerState struct {
+	Offline   bool
+	LastError string
+	LastJob   time.Time
+}
+
+var (
+	printersMu sync.Mutex
+	printers   = make(map[string]printerState)
+)
+
+// printerKey names the printer of a job; "" is the system default
+func printerKey(name string) string {
+	if name == "" {
+		return "default"
+	}
+	return name
+}
+
+// trackPrinter updates the printer's state from a journaled print job and
+// reports whether it went offline or came back online
+func trackPrinter(e journal.Entry) bool {
+	failed := e.Status == "failed"
+	key := printerKey(e.Printer)
+
+	printersMu.Lock()
+	defer printersMu.Unlock()
+	old := printers[key]
+	printers[key] = printerState{Offline: failed, LastError: e.Error, LastJob: e.Time}
+	return old.Offline != failed
+}
+
+// printerStatus returns what the last job said about a printer; a
+// printer that hasn't printed yet is assumed to be online
+func printerStatus(name string) printerState {
+	printersMu.Lock()
+	defer printersMu.Unlock()
+	return printers[printerKey(name)]
+}
+
+// deviceSummary describes the scanner and printers in a few short lines,
+// looking for the scanner port afresh
+func deviceSummary(opts agentOptions) []string {
+	scanner := "Scanner: not found"
+	if port, err := findScannerPort(opts.PortOverride); err == nil {
+		scanner = "Scanner: " + port
+		if opts.PortOverride != "" && !portPresent(port) {
+			scanner += " (not connected)"
+		}
+	}
+	lines := []string{scanner, printerSummary("Printer", opts.PrinterName)}
+	if opts.AgreementPrinter != opts.PrinterName {
+		lines = append(lines, printerSummary("Agreements", opts.AgreementPrinter))
+	}
+	return lines
+}
+
+func printerSummary(label, name string) string {
+	state := "online"
+	if printerStatus(name).Offline {
+		state = "offline"
+	}
+	return fmt.Sprintf("%s: %s %s", label, printerKey(name), state)
+}
+
+// portPresent reports whether the OS lists a serial port
+func portPresent(port string) bool {
+	ports, err := listSerialPorts()
+	if err != nil {
+		return false
+	}
+	for _, p := range ports {
+		if strings.EqualFold(p, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// printTestPage prints a short page on the receipt printer and journals
+// it like any other job
+func printTestPage(ctx context.Context, opts agentOptions) error {
+	now := time.Now()
+	transactionID := "TEST-" + now.Format("20060102-150405")
+	html := fmt.Sprintf(`<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>Test print</title>
+<style>body { font-family: Arial, sans-serif; width: 72mm; font-size: 12px; }</style>
+</head><body>
+<h2>GoScanRentalTide</h2>
+<p>Test print</p>
+<p>%s<br>Agent %s<br>Printer %s</p>
+</body></html>`, now.Format("2006-01-02 15:04:05"), agentVersion, printerKey(opts.PrinterName))
+
+	ctx, jobID := startPrintJob(ctx)
+	err := printHTMLDocument(ctx, html, "test", transactionID, opts.PrinterName, opts.Pipeline.forDocuments().Renderer)
+	entry := journal.Entry{
+		JobID:         jobID,
+		RequestID:     tracing.RequestID(ctx),
+		Kind:          journal.Print,
+		Status:        "success",
+		Document:      "test",
+		TransactionID: transactionID,
+		Copies:        1,
+		Printed:       1,
+		Printer:       opts.PrinterName,
+	}
+	if err != nil {
+		entry.Status, entry.Printed, entry.Error = "failed", 0, err.Error()
+	}
+	recordJournal(entry)
+	return err
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/aamva"
+	"GoScanRentalTide/internal/magstripe"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// License formats told apart by licenseFormat
+const (
+	formatBC        = "bc"               // BC magstripe
+	formatAlberta   = "alberta"          // Alberta magstripe, laid out as BC's
+	formatAAMVA     = "aamva"            // PDF417 barcode with its ANSI header
+	formatAAMVABare = "aamva-headerless" // AAMVA elements without the header, e.g. a scanner that drops it
+	formatUnknown   = "unknown"
+)
+
+// licenseFormat detects which layout a license scan is in
+func licenseFormat(raw string) string {
+	cleanRaw := strings.TrimPrefix(raw, "\x15")
+	switch {
+	case strings.Contains(cleanRaw, "%BC"):
+		return formatBC
+	case strings.Contains(cleanRaw, "%AB"):
+		return formatAlberta
+	case strings.Contains(cleanRaw, "ANSI "):
+		return formatAAMVA
+	case strings.Contains(cleanRaw, "DCS") || strings.Contains(cleanRaw, "DAQ"):
+		return formatAAMVABare
+	}
+	return formatUnknown
+}
+
+// ScanDiagnostics describes how a license scan was read, so support can
+// tell a bad swipe (a known format with fields missing) from a card in a
+// format the agent doesn't read. It holds no license data.
+type ScanDiagnostics struct {
+	Format       string         `json:"format"`                         // bc, alberta, aamva, aamva-headerless or unknown
+	Bytes        int            `json:"bytes"`                          // Length of the scan
+	ControlBytes int            `json:"controlBytes"`                   // Non-printing bytes other than line breaks
+	Subfiles     map[string]int `json:"subfiles,omitempty"`             // AAMVA subfile sizes by type, from the header
+	Found        []string       `json:"found"`                          // License fields that were filled in
+	Missing      []string       `json:"missing"`                        // License fields that were not
+	Unrecognized []string       `json:"unrecognizedElements,omitempty"` // AAMVA element IDs in the scan that the parser doesn't read
+	Hint         string         `json:"hint"`
+}
+
+// AAMVA elements the parser reads; the license class is read from DCA
+var knownElements = map[string]bool{
+	"DCS": true, "DAC": true, "DAD": true, "DBA": true, "DBD": true,
+	"DBB": true, "DBC": true, "DAU": true, "DAG": true, "DAI": true,
+	"DAJ": true, "DAK": true, "DCF": true, "DAQ": true, "DCA": true,
+	"DAW": true, "DAX": true, "DAY": true, "DCU": true, "DAA": true,
+	"DDE": true, "DDF": true, "DDG": true, "DCD": true, "DCB": true,
+	"DDK": true, "DDL": true,
+}
+
+// An element ID at the start of a line, after the subfile type on the
+// first line of a subfile ("DLDAQ...")
+var elementPattern = regexp.MustCompile(`^(?:DL|ID|Z[A-Z])?([DZ][A-Z]{2})`)
+
+// essentialFields are the fields a scan is incomplete without
+var essentialFields = []string{"firstName", "lastName", "licenseNumber", "dob", "expiryDate"}
+
+// licenseFields lists a license's fields by their JSON names
+func licenseFields(license LicenseData) [][2]string {
+	return [][2]string{
+		{"firstName", license.FirstName},
+		{"middleName", license.MiddleName},
+		{"lastName", license.LastName},
+		{"address", license.Address},
+		{"city", license.City},
+		{"state", license.State},
+		{"postal", license.Postal},
+		{"licenseNumber", license.LicenseNumber},
+		{"issueDate", license.IssueDate},
+		{"expiryDate", license.ExpiryDate},
+		{"height", license.Height},
+		{"sex", license.Sex},
+		{"dob", license.Dob},
+	}
+}
+
+// incompleteLicense reports whether a parsed license is missing any of
+// the essential fields
+func incompleteLicense(license LicenseData) bool {
+	for _, field := range licenseFields(license) {
+		if field[1] == "" && slices.Contains(essentialFields, field[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnoseScan describes the scan raw and what was parsed from it
+func diagnoseScan(raw string, license LicenseData) *ScanDiagnostics {
+	d := &ScanDiagnostics{
+		Format:  licenseFormat(raw),
+		Bytes:   len(raw),
+		Found:   []string{},
+		Missing: []string{},
+	}
+	for i := 0; i < len(raw); i++ {
+		if c := raw[i]; (c < 0x20 && c != '\n' && c != '\r') || c == 0x7F {
+			d.ControlBytes++
+		}
+	}
+	for _, field := range licenseFields(license) {
+		if field[1] != "" {
+			d.Found = append(d.Found, field[0])
+		} else {
+			d.Missing = append(d.Missing, field[0])
+		}
+	}
+
+	if d.Format == formatAAMVA || d.Format == formatAAMVABare {
+		for _, subfile := range aamva.Subfiles([]byte(raw)) {
+			if d.Subfiles == nil {
+				d.Subfiles = make(map[string]int)
+			}
+			d.Subfiles[subfile.Type] += len(subfile.Data)
+		}
+		seen := make(map[string]bool)
+		for _, line := range strings.Split(raw, "\n") {
+			m := elementPattern.FindStringSubmatch(strings.TrimSpace(line))
+			if m == nil || knownElements[m[1]] || seen[m[1]] {
+				continue
+			}
+			seen[m[1]] = true
+			d.Unrecognized = append(d.Unrecognized, m[1])
+		}
+	}
+
+	switch {
+	case len(d.Found) == 0 && d.Format == formatUnknown:
+		d.Hint = "Unsupported format: the scan is not a license layout this agent reads"
+	case len(d.Found) == 0:
+		d.Hint = "Bad read: the format was recognised but no fields could be read; scan the card again"
+	case incompleteLicense(license):
+		d.Hint = "Partial read: some fields are missing; scan the card again, or check the card if it keeps happening"
+	default:
+		d.Hint = "Complete read"
+	}
+	return d
+}
+
+// recordComplete reports whether a scan holds a whole record: all the
+// bytes its AAMVA header lists, or an end sentinel on every track of a
+// swipe. Other scans have no way to tell and count as complete.
+func recordComplete(raw []byte) bool {
+	if n, ok := aamva.Length(raw); ok {
+		return len(raw) >= n
+	}
+	switch licenseFormat(string(raw)) {
+	case formatAAMVA, formatAAMVABare:
+		return true
+	}
+	_, open := magstripe.OpenTrack(string(raw))
+	return !open
+}
+
+// errorSwipeAgain is the error code of a scan rejected as misread
+const errorSwipeAgain = "swipe_again"
+
+// checkScan looks for signs that a scan was misread: a track that failed
+// its checks on a swipe, or an element that breaks the AAMVA rules in a
+// barcode
+func checkScan(raw string) error {
+	switch licenseFormat(raw) {
+	case formatAAMVA, formatAAMVABare:
+		return aamva.Check([]byte(raw))
+	}
+	return magstripe.Check(raw)
+}
+
+// checkLicenseDates checks the dates read from a BC or Alberta swipe,
+// which come from a run of digits on track 2 and turn into nonsense such
+// as month 13 when a digit is misread
+func checkLicenseDates(license LicenseData) error {
+	for _, date := range [][2]string{{"expiryDate", license.ExpiryDate}, {"dob", license.Dob}} {
+		if date[1] == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", date[1]); err != nil {
+			return fmt.Errorf("%s %q is not a date", date[0], date[1])
+		}
+	}
+	return nil
+}
+
+// writeSwipeAgain rejects a misread scan with the swipe_again code, so
+// the frontend can ask for the card to be scanned again
+func writeSwipeAgain(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Status:    "error",
+		Code:      errorSwipeAgain,
+		Message:   fmt.Sprintf("The card was not read cleanly, please swipe again (%v)", err),
+		RequestID: w.Header().Get(tracing.Header),
+	})
+}
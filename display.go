@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"GoScanRentalTide/internal/display"
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/money"
+
+	"go.bug.st/serial"
+)
+
+// DisplayRequest is the text to show on the customer pole display. Line1
+// and Line2 are shown as given; otherwise the lines are built from the
+// item and price, the total due or the change.
+type DisplayRequest struct {
+	Line1    string       `json:"line1"`
+	Line2    string       `json:"line2"`
+	Item     string       `json:"item"`
+	Quantity int          `json:"quantity,omitempty"`
+	Price    *money.Cents `json:"price"`
+	Total    *money.Cents `json:"total"`
+	Paid     *money.Cents `json:"paid"`
+	Change   *money.Cents `json:"change"`
+	Language string       `json:"language,omitempty"`
+}
+
+// displayMu serialises writes so two checkouts can't interleave on the port
+var displayMu sync.Mutex
+
+// displayLines works out the two lines for a display request
+func displayLines(req DisplayRequest, width int) (string, string, error) {
+	if req.Line1 != "" || req.Line2 != "" {
+		return req.Line1, req.Line2, nil
+	}
+
+	lang := req.Language
+	if lang == "" {
+		lang = receiptLanguage
+	}
+	tr := i18n.New(lang)
+
+	switch {
+	case req.Change != nil:
+		change := currencyFormat.FormatCents(*req.Change)
+		if req.Paid == nil {
+			return strings.ToUpper(tr.T("change")), display.Justify("", change, width), nil
+		}
+		return display.Justify(strings.ToUpper(tr.T("cash")), currencyFormat.FormatCents(*req.Paid), width),
+			display.Justify(strings.ToUpper(tr.T("change")), change, width), nil
+	case req.Total != nil:
+		return strings.ToUpper(tr.T("total")), display.Justify("", currencyFormat.FormatCents(*req.Total), width), nil
+	case req.Item != "":
+		line2 := ""
+		if req.Price != nil {
+			amount := *req.Price
+			qty := ""
+			if req.Quantity > 1 {
+				amount = req.Price.Times(float64(req.Quantity))
+				qty = fmt.Sprintf("%d @ %s", req.Quantity, currencyFormat.FormatCents(*req.Price))
+			}
+			line2 = display.Justify(qty, currencyFormat.FormatCents(amount), width)
+		}
+		return req.Item, line2, nil
+	}
+	return "", "", errors.New("nothing to display: send line1/line2, item, total or change")
+}
+
+// writeDisplay sends commands to the pole display's serial port
+func writeDisplay(opts agentOptions, data []byte) error {
+	if opts.DisplayPort == "" {
+		return errors.New("no customer display configured (-display-port)")
+	}
+
+	displayMu.Lock()
+	defer displayMu.Unlock()
+
+	port, err := serialTransport.Open(opts.DisplayPort, &serial.Mode{
+		BaudRate: opts.DisplayBaud,
+		DataBits: 8,
+		Parity:   serial.NoParity,
+		StopBits: serial.OneStopBit,
+	})
+	if err != nil {
+		return fmt.Errorf("open display port %s failed: %w", opts.DisplayPort, err)
+	}
+	defer port.Close()
+
+	if _, err := port.Write(data); err != nil {
+		return fmt.Errorf("write to display failed: %w", err)
+	}
+	return nil
+}
+
+// displayShowHandler pushes two lines of text to the pole display
+func displayShowHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var req DisplayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error parsing JSON data: %v", err))
+		return
+	}
+
+	line1, line2, err := displayLines(req, opts.DisplayWidth)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := writeDisplay(opts, display.Show(line1, line2, opts.DisplayWidth)); err != nil {
+		logf(r.Context(), "Customer display error: %v", err)
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"line1":  line1,
+		"line2":  line2,
+	})
+}
+
+// displayClearHandler blanks the pole display
+func displayClearHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	if err := writeDisplay(opts, display.Clear()); err != nil {
+		logf(r.Context(), "Customer display error: %v", err)
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+// workflowPrompts shows prompts on the pole display as scans and prints go
+// by, in the languages -display-prompts configures; nil when it isn't set
+var workflowPrompts *promptDisplay
+
+type promptDisplay struct {
+	prompter *display.Prompter
+	opts     agentOptions
+}
+
+// showPrompt puts a workflow prompt on the pole display. location is the
+// receipt's, or empty for the agent's own. A display that can't be
+// reached is logged and doesn't hold up the scan or print.
+func showPrompt(ctx context.Context, prompt, location string, data display.PromptData) {
+	p := workflowPrompts
+	if p == nil {
+		return
+	}
+	if location == "" {
+		location = p.prompter.Location()
+	}
+	line1, line2, err := p.prompter.Lines(prompt, location, data)
+	if err == nil {
+		err = writeDisplay(p.opts, display.Show(line1, line2, p.opts.DisplayWidth))
+	}
+	if err != nil {
+		logf(ctx, "Customer display prompt %s: %v", prompt, err)
+	}
+}
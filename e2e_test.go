@@ -0,0 +1,4447 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"GoScanRentalTide/internal/apiversion"
+	"GoScanRentalTide/internal/archive"
+	"GoScanRentalTide/internal/audit"
+	"GoScanRentalTide/internal/cdp"
+	"GoScanRentalTide/internal/coupons"
+	"GoScanRentalTide/internal/dedupe"
+	"GoScanRentalTide/internal/display"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/listen"
+	"GoScanRentalTide/internal/merchant"
+	"GoScanRentalTide/internal/messages"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/mqtt"
+	"GoScanRentalTide/internal/paper"
+	"GoScanRentalTide/internal/payloadlog"
+	"GoScanRentalTide/internal/script"
+	"GoScanRentalTide/internal/seal"
+	"GoScanRentalTide/internal/sequence"
+	"GoScanRentalTide/internal/signature"
+	"GoScanRentalTide/internal/simulate"
+	"GoScanRentalTide/internal/testharness"
+	"GoScanRentalTide/internal/tmplfuncs"
+	"GoScanRentalTide/internal/tracing"
+	"GoScanRentalTide/internal/txstore"
+	"GoScanRentalTide/internal/webhook"
+)
+
+const testAdminToken = "test-admin-token-0123456789"
+
+const (
+	bcSwipe = "%BCVANCOUVER^DOE,$JANE MARIE^123 MAIN ST$VANCOUVER BC  V6B 1A1^?" +
+		";6360281234567=271229900115=?" +
+		"_%0AV6B1A1  M180"
+
+	aamvaScan = "@\n\x1e\rANSI 636014080001DL00310145DL\n" +
+		"DCSSMITH\nDACJOHN\nDADQUINCY\nDBB19850704\nDBA20290704\nDBD20210704\n" +
+		"DBC1\nDAU180 cm\nDAG42 ELM ST\nDAISACRAMENTO\nDAJCA\nDAK958140000\n" +
+		"DAQD1234567\nDCAGC\n"
+)
+
+// agent is the scanner agent wired to emulated hardware
+type agent struct {
+	*testharness.Server
+	appDir  string
+	scanner *testharness.MockSerial
+	printer *testharness.PDFPrinter
+}
+
+// startAgent runs the agent's routes against a mock scanner and PDF
+// printer, restoring the real backends when the test finishes
+func startAgent(t *testing.T, scanResponse string) *agent {
+	t.Helper()
+
+	a := &agent{
+		appDir:  t.TempDir(),
+		scanner: testharness.NewMockSerial(scanResponse),
+		printer: testharness.NewPDFPrinter(),
+	}
+
+	origTransport, origList, origRun, origDir, origArchive, origStore, origJournal, origAudit, origNumbers := serialTransport, listSerialPorts, runCommand, appDirOverride, receiptArchive, transactionStore, eventJournal, auditLog, receiptNumbers
+	t.Cleanup(func() {
+		serialTransport, listSerialPorts, runCommand, appDirOverride, receiptArchive, transactionStore, eventJournal, auditLog, receiptNumbers = origTransport, origList, origRun, origDir, origArchive, origStore, origJournal, origAudit, origNumbers
+	})
+	printers = make(map[string]printerState)
+	serialTransport = a.scanner
+	listSerialPorts = a.scanner.Ports
+	runCommand = a.printer.Run
+	appDirOverride = a.appDir
+
+	var err error
+	receiptArchive, err = archive.Open(filepath.Join(a.appDir, "archive"))
+	if err != nil {
+		t.Fatalf("opening archive: %v", err)
+	}
+	transactionStore, err = txstore.Open(filepath.Join(a.appDir, "transactions"))
+	if err != nil {
+		t.Fatalf("opening transaction store: %v", err)
+	}
+	eventJournal, err = journal.Open(filepath.Join(a.appDir, "journal"))
+	if err != nil {
+		t.Fatalf("opening journal: %v", err)
+	}
+	t.Cleanup(func() { eventJournal.Close() })
+	auditLog, err = audit.Open(filepath.Join(a.appDir, "audit", "agent.jsonl"))
+	if err != nil {
+		t.Fatalf("opening audit log: %v", err)
+	}
+	payloadLog, err = payloadlog.Open(filepath.Join(a.appDir, "logs", "payloads.jsonl"), payloadLogMaxBytes, payloadLogKeep)
+	if err != nil {
+		t.Fatalf("opening payload log: %v", err)
+	}
+
+	mux := setupRoutes(agentOptions{
+		ScannerPort:     "4",
+		ReadTimeout:     time.Second,
+		ScanIdleTimeout: 100 * time.Millisecond,
+		MaxScanTimeout:  3 * time.Second,
+		PrinterName:     "Receipt_Printer",
+		AppDir:          a.appDir,
+		DisplayPort:     "COM5",
+		DisplayBaud:     9600,
+		DisplayWidth:    20,
+		SignaturePort:   "COM6",
+		SignatureBaud:   19200,
+		PrintRateLimit:  60,
+		PrintBurst:      20,
+		MaxBodyBytes:    64 << 10,
+		DuplicateWindow: time.Minute,
+		DuplicateAction: dedupe.Banner,
+
+		AgreementPrinter: "Office_Printer",
+		TemplatesDir:     filepath.Join(a.appDir, "templates"),
+		Templates:        newDocumentTemplates(filepath.Join(a.appDir, "templates")),
+		AdminToken:       testAdminToken,
+	})
+	a.Server = testharness.Start(t, corsMiddleware(tracing.Middleware(journalErrors(payloadMiddleware(mux)), log.Default())))
+	return a
+}
+
+func TestScanBCLicense(t *testing.T) {
+	a := startAgent(t, bcSwipe)
+
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	body := resp.JSON(t)
+	if body["status"] != "success" {
+		t.Fatalf("status = %v, body %s", body["status"], resp.Body)
+	}
+
+	license := body["licenseData"].(map[string]interface{})
+	want := map[string]string{
+		"firstName":     "JANE",
+		"middleName":    "MARIE",
+		"lastName":      "DOE",
+		"city":          "VANCOUVER",
+		"state":         "BC",
+		"postal":        "V6B 1A1",
+		"licenseNumber": "1234567",
+		"expiryDate":    "2029-12-27",
+		"dob":           "1990-01-15",
+	}
+	for field, value := range want {
+		if license[field] != value {
+			t.Errorf("%s = %v, want %q", field, license[field], value)
+		}
+	}
+
+	// The agent asks COM4 for a scan using the Windows line settings
+	if opened := a.scanner.Opened(); len(opened) != 1 || opened[0] != "COM4" {
+		t.Errorf("opened ports = %v, want [COM4]", opened)
+	}
+	if modes := a.scanner.Modes(); len(modes) != 1 || modes[0].BaudRate != 1200 || modes[0].DataBits != 7 {
+		t.Errorf("serial modes = %+v", modes)
+	}
+	written := a.scanner.Written()
+	if len(written) != 1 || !bytes.Equal(written[0], []byte("\x01<TXPING,4>\x04")) {
+		t.Errorf("scanner command = %q", written)
+	}
+}
+
+func TestScanAAMVALicense(t *testing.T) {
+	a := startAgent(t, aamvaScan)
+
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	license := resp.JSON(t)["licenseData"].(map[string]interface{})
+	want := map[string]string{
+		"firstName":     "JOHN",
+		"lastName":      "SMITH",
+		"address":       "42 ELM ST",
+		"state":         "CA",
+		"licenseNumber": "D1234567",
+		"dob":           "1985/07/04",
+		"sex":           "M",
+		"licenseClass":  "C",
+	}
+	for field, value := range want {
+		if license[field] != value {
+			t.Errorf("%s = %v, want %q", field, license[field], value)
+		}
+	}
+}
+
+func TestScanDiagnostics(t *testing.T) {
+	// A barcode cut off after the license number, with elements the
+	// parser doesn't read
+	scan := "@\n\x1e\rANSI 636014080002DL00410288ZC03290024DL\n" +
+		"DCSSMITH\nDACJOHN\nDCJX\nDAQD1234567\nZCAWHITE\n"
+	a := startAgent(t, scan)
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	var body ScanResponse
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	d := body.Diagnostics
+	if body.Status != "success" || d == nil {
+		t.Fatalf("partial scan has no diagnostics: %s", resp.Body)
+	}
+	if d.Format != "aamva" || d.Bytes != len(scan) || d.ControlBytes != 1 {
+		t.Errorf("format %q, %d bytes, %d control bytes", d.Format, d.Bytes, d.ControlBytes)
+	}
+	if got := strings.Join(d.Unrecognized, ","); got != "DCJ,ZCA" {
+		t.Errorf("unrecognized elements = %s, want DCJ,ZCA", got)
+	}
+	if !slices.Contains(d.Found, "licenseNumber") || !slices.Contains(d.Missing, "dob") {
+		t.Errorf("found %v, missing %v", d.Found, d.Missing)
+	}
+	if !strings.HasPrefix(d.Hint, "Partial read") {
+		t.Errorf("hint = %q", d.Hint)
+	}
+	if data, _ := json.Marshal(d); strings.Contains(string(data), "SMITH") {
+		t.Errorf("diagnostics include license data: %s", data)
+	}
+
+	// Something that isn't a license at all
+	a = startAgent(t, "HELLO WORLD 12345")
+	resp = a.PostJSON("/scanner/scan", "")
+	body = ScanResponse{}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "warning" || body.Diagnostics == nil || body.Diagnostics.Format != "unknown" ||
+		!strings.HasPrefix(body.Diagnostics.Hint, "Unsupported format") {
+		t.Errorf("unsupported scan: %s", resp.Body)
+	}
+
+	// A complete read carries no diagnostics
+	a = startAgent(t, aamvaScan)
+	if body := a.PostJSON("/scanner/scan", "").JSON(t); body["diagnostics"] != nil {
+		t.Errorf("complete scan has diagnostics: %v", body["diagnostics"])
+	}
+}
+
+func TestScanLicenseNumberNormalized(t *testing.T) {
+	wa, _ := simulate.Fixture("wa")
+	for _, tc := range []struct {
+		name, scan, number, raw string
+	}{
+		{"BC", bcSwipe, "1234567", "6360281234567"},
+		{"AB", strings.NewReplacer("%BCVANCOUVER", "%ABEDMONTON", ";6360281234567=", ";604432123456789=").Replace(bcSwipe),
+			"123456-789", "604432123456789"},
+		{"ON", strings.NewReplacer("ANSI 636014", "ANSI 636012", "DAQD1234567", "DAQA12345678901234").Replace(aamvaScan),
+			"A1234-56789-01234", "A12345678901234"},
+		{"ON with dashes", strings.NewReplacer("ANSI 636014", "ANSI 636012", "DAQD1234567", "DAQA1234-56789-01234").Replace(aamvaScan),
+			"A1234-56789-01234", "A1234-56789-01234"},
+		{"WA", strings.Replace(wa, "DAQWDL0ALEXS123", "DAQwdl 0alexs-123", 1), "WDL0ALEXS123", "wdl 0alexs-123"},
+		{"other", aamvaScan, "D1234567", "D1234567"},
+	} {
+		a := startAgent(t, tc.scan)
+		resp := a.PostJSON("/scanner/scan", "")
+		license, _ := resp.JSON(t)["licenseData"].(map[string]interface{})
+		if license["licenseNumber"] != tc.number || license["licenseNumberRaw"] != tc.raw {
+			t.Errorf("%s: licenseNumber %v, licenseNumberRaw %v, want %s and %s (body %s)",
+				tc.name, license["licenseNumber"], license["licenseNumberRaw"], tc.number, tc.raw, resp.Body)
+		}
+	}
+}
+
+func TestScanPhysicalDescription(t *testing.T) {
+	us := strings.Replace(aamvaScan, "DAU180 cm\n", "DAU070 in\nDAW165\nDAYBRO\n", 1)
+	us = strings.Replace(us, "DL00310145", fmt.Sprintf("DL0031%04d", 145+len(us)-len(aamvaScan)), 1)
+	for _, tc := range []struct {
+		name, scan string
+		want       map[string]interface{}
+	}{
+		{"BC", bcSwipe, map[string]interface{}{"height": "180cm", "heightCm": 180.0, "heightFtIn": `5'11"`}},
+		{"US", us, map[string]interface{}{
+			"height": "070in", "heightCm": 178.0, "heightFtIn": `5'10"`,
+			"weightLb": 165.0, "weightKg": 75.0, "eyeColor": "Brown",
+		}},
+	} {
+		a := startAgent(t, tc.scan)
+		resp := a.PostJSON("/scanner/scan", "")
+		license, _ := resp.JSON(t)["licenseData"].(map[string]interface{})
+		for field, value := range tc.want {
+			if license[field] != value {
+				t.Errorf("%s: %s = %v, want %v", tc.name, field, license[field], value)
+			}
+		}
+	}
+}
+
+func TestScanNameSuffix(t *testing.T) {
+	withAAMVA := func(old, new string) string {
+		scan := strings.Replace(aamvaScan, old, new, 1)
+		return strings.Replace(scan, "DL00310145", fmt.Sprintf("DL0031%04d", 145+len(scan)-len(aamvaScan)), 1)
+	}
+	for _, tc := range []struct {
+		name, scan string
+		want       map[string]interface{}
+		truncated  string
+	}{
+		{"DCU", withAAMVA("DADQUINCY\n", "DADQUINCY\nDCUJR\nDDET\nDDFN\n"), map[string]interface{}{
+			"lastName": "SMITH", "suffix": "JR", "fullName": "JOHN QUINCY SMITH JR",
+		}, "[lastName]"},
+		{"in family name", withAAMVA("DCSSMITH\n", "DCSSMITH III\n"), map[string]interface{}{
+			"lastName": "SMITH", "suffix": "III", "fullName": "JOHN QUINCY SMITH III",
+		}, "<nil>"},
+		{"version 1", withAAMVA("DCSSMITH\nDACJOHN\nDADQUINCY\n", "DAASMITH,JOHN,QUINCY,SR\n"), map[string]interface{}{
+			"firstName": "JOHN", "lastName": "SMITH", "suffix": "SR", "fullName": "JOHN QUINCY SMITH SR",
+		}, "<nil>"},
+		{"BC", strings.Replace(bcSwipe, "DOE,", "DOE JR.,", 1), map[string]interface{}{
+			"lastName": "DOE", "suffix": "JR", "fullName": "JANE MARIE DOE JR",
+		}, "<nil>"},
+	} {
+		a := startAgent(t, tc.scan)
+		resp := a.PostJSON("/scanner/scan", "")
+		license, _ := resp.JSON(t)["licenseData"].(map[string]interface{})
+		for field, value := range tc.want {
+			if license[field] != value {
+				t.Errorf("%s: %s = %v, want %v", tc.name, field, license[field], value)
+			}
+		}
+		if truncated := fmt.Sprint(license["truncated"]); truncated != tc.truncated {
+			t.Errorf("%s: truncated = %s, want %s", tc.name, truncated, tc.truncated)
+		}
+	}
+
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	resp := a.PostJSON("/print/agreement", map[string]interface{}{
+		"agreementNumber": "RA-3001",
+		"customer":        map[string]interface{}{"firstName": "JOHN", "lastName": "SMITH", "suffix": "Jr."},
+		"items":           []map[string]interface{}{{"name": "Kayak", "quantity": 1, "rate": 60.00, "rateUnit": "day"}},
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 1 || !strings.Contains(jobs[0].HTML, "JOHN SMITH JR") {
+		t.Error("agreement does not name the customer JOHN SMITH JR")
+	}
+}
+
+func TestScanEndorsementsAndRestrictions(t *testing.T) {
+	extra := "DCDM T\nDCBBJ\nDDK1\nDDL0\n"
+	scan := strings.Replace(aamvaScan, "DCAGC\n", "DCAGC\n"+extra, 1)
+	scan = strings.Replace(scan, "DL00310145", fmt.Sprintf("DL0031%04d", 145+len(extra)), 1)
+	a := startAgent(t, scan)
+	license, _ := a.PostJSON("/scanner/scan", "").JSON(t)["licenseData"].(map[string]interface{})
+	want := map[string]interface{}{
+		"endorsements": "[M T]",
+		"restrictions": "[B J]",
+		"organDonor":   "true",
+		"veteran":      "<nil>",
+	}
+	for field, value := range want {
+		if got := fmt.Sprint(license[field]); got != value {
+			t.Errorf("%s = %s, want %s", field, got, value)
+		}
+	}
+
+	// NONE is no codes at all
+	none := strings.Replace(aamvaScan, "DCAGC\n", "DCAGC\nDCDNONE\nDCBNONE\n", 1)
+	none = strings.Replace(none, "DL00310145", "DL00310161", 1)
+	a = startAgent(t, none)
+	license, _ = a.PostJSON("/scanner/scan", "").JSON(t)["licenseData"].(map[string]interface{})
+	if license["endorsements"] != nil || license["restrictions"] != nil {
+		t.Errorf("endorsements = %v, restrictions = %v, want neither", license["endorsements"], license["restrictions"])
+	}
+}
+
+func TestValidateLicenseClass(t *testing.T) {
+	ontario := strings.Replace(aamvaScan, "ANSI 636014", "ANSI 636012", 1)
+	ontario = strings.Replace(ontario, "DCAGC\n", "DCAG1\n", 1)
+	motorcycle := strings.Replace(aamvaScan, "DCAGC\n", "DCAGC\nDCDM\n", 1)
+	motorcycle = strings.Replace(motorcycle, "DL00310145", "DL00310150", 1)
+
+	a := startAgent(t, aamvaScan)
+	scanID := func(scan string) string {
+		a.scanner.SetResponse(scan)
+		id, _ := a.PostJSON("/scanner/scan", "").JSON(t)["scanId"].(string)
+		if id == "" {
+			t.Fatal("scan has no scanId")
+		}
+		return id
+	}
+	for _, tc := range []struct {
+		name, scan, class    string
+		eligible, verified   bool
+		covers, licenseClass string
+	}{
+		{"car", aamvaScan, "car", true, true, "[car]", "C"},
+		{"no motorcycle class", aamvaScan, "motorcycle", false, true, "[car]", "C"},
+		{"motorcycle endorsement", motorcycle, "motorcycle", true, true, "[car motorcycle]", "C"},
+		{"Ontario learner", ontario, "car", false, true, "[car]", "G1"},
+		{"BC swipes have no class", bcSwipe, "car", false, false, "[]", "NA"},
+	} {
+		resp := a.PostJSON("/scanner/validate", map[string]string{"scanId": scanID(tc.scan), "class": tc.class})
+		var body LicenseValidateResponse
+		if err := json.Unmarshal(resp.Body, &body); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("%s: status = %d, body %s", tc.name, resp.StatusCode, resp.Body)
+		}
+		if body.Eligible != tc.eligible || body.Verified != tc.verified || fmt.Sprint(body.Covers) != tc.covers || body.LicenseClass != tc.licenseClass {
+			t.Errorf("%s: got %s", tc.name, resp.Body)
+		}
+		if !body.Eligible && len(body.Reasons) == 0 {
+			t.Errorf("%s: no reason given: %s", tc.name, resp.Body)
+		}
+	}
+
+	// Without a scanId the agent scans first
+	a.scanner.SetResponse(motorcycle)
+	resp := a.PostJSON("/scanner/validate", map[string]interface{}{"class": "motorcycle", "endorsements": []string{"M"}})
+	if body := resp.JSON(t); resp.StatusCode != 200 || body["eligible"] != true || body["scanId"] == "" {
+		t.Errorf("validate with a scan: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	a.scanner.SetResponse(aamvaScan)
+	resp = a.PostJSON("/scanner/validate", map[string]interface{}{"class": "car", "endorsements": []string{"T"}})
+	if body := resp.JSON(t); body["eligible"] != false || !strings.Contains(string(resp.Body), "no T endorsement") {
+		t.Errorf("missing endorsement: body %s", resp.Body)
+	}
+	// A scan that fails is answered with the scan's error
+	if resp := a.PostJSON("/scanner/validate?timeout=soon", map[string]string{"class": "car"}); resp.StatusCode != 400 || !strings.Contains(string(resp.Body), "invalid timeout") {
+		t.Errorf("failed scan: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	if resp := a.PostJSON("/scanner/validate", map[string]string{"class": "boat"}); resp.StatusCode != 400 || !strings.Contains(string(resp.Body), `"field":"class"`) {
+		t.Errorf("unknown class: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestScannerProfile(t *testing.T) {
+	newFlags := func(args ...string) *flag.FlagSet {
+		flags := flag.NewFlagSet("agent", flag.ContinueOnError)
+		flags.String("scanner-profile", defaultScannerProfile, "")
+		flags.String("scan-prefix", "", "")
+		flags.String("scan-suffix", "", "")
+		flags.String("scan-trigger", "", "")
+		if err := flags.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		return flags
+	}
+	scan := func(a *agent, profile scannerProfile) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		opts := agentOptions{ScannerProfile: profile, UseSimpleCommand: true, ReadTimeout: time.Second, ScanIdleTimeout: 100 * time.Millisecond}
+		scannerHandler(rec, httptest.NewRequest("POST", "/scanner/scan", nil), opts)
+		return rec
+	}
+
+	// Another brand: ESC S CR LF
+	custom, err := scannerProfileFromFlags(newFlags("-scan-prefix", "1b", "-scan-suffix", "0x0d0a", "-scan-trigger", "S"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := startAgent(t, bcSwipe)
+	if rec := scan(a, custom); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"scanId"`) {
+		t.Fatalf("custom profile: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if written := a.scanner.Written(); len(written) != 1 || string(written[0]) != "\x1bS\r\n" {
+		t.Errorf("custom profile wrote %q, want ESC S CR LF", written)
+	}
+
+	// A passive scanner is sent nothing and swipes on its own
+	passive, err := scannerProfileFromFlags(newFlags("-scanner-profile", "passive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = startAgent(t, "")
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		a.scanner.Send([]byte(bcSwipe))
+	}()
+	if rec := scan(a, passive); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"scanId"`) {
+		t.Fatalf("passive profile: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if written := a.scanner.Written(); len(written) != 0 {
+		t.Errorf("passive profile wrote %q", written)
+	}
+
+	// The default is the TX protocol
+	if written := (agentOptions{UseSimpleCommand: true}).scanner().frame("<TXPING>"); string(written) != "\x01<TXPING>\x04" {
+		t.Errorf("default framing = %q", written)
+	}
+	for _, args := range [][]string{{"-scanner-profile", "acme"}, {"-scan-prefix", "zz"}} {
+		if _, err := scannerProfileFromFlags(newFlags(args...)); err == nil {
+			t.Errorf("%v: no error", args)
+		}
+	}
+}
+
+func TestPassiveScanner(t *testing.T) {
+	a := startAgent(t, "")
+	t.Cleanup(func() { simulatedPrintDir = "" })
+	opts := agentOptions{ScannerProfile: scannerProfiles["passive"], ReadTimeout: 2 * time.Second, ScanIdleTimeout: 100 * time.Millisecond}
+
+	// The simulated reader swipes on its own once the port opens
+	if err := startSimulation("bc", 50*time.Millisecond, a.appDir, true); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	scannerHandler(rec, httptest.NewRequest("POST", "/scanner/scan", nil), opts)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"state":"BC"`) {
+		t.Fatalf("passive scan: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	// A passive reader takes no queries
+	rec = httptest.NewRecorder()
+	scannerInfoHandler(rec, httptest.NewRequest("GET", "/scanner/info", nil), opts)
+	var info ScannerInfoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil || info.CommandFormat != "passive" || info.Status != "warning" || len(info.Replies) != 0 {
+		t.Errorf("passive info: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestBluetoothScanner(t *testing.T) {
+	a := startAgent(t, bcSwipe)
+	scan := func(port string, link bluetoothLink) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		opts := agentOptions{PortOverride: port, Bluetooth: link, UseSimpleCommand: true, ReadTimeout: time.Second, ScanIdleTimeout: 100 * time.Millisecond}
+		scannerHandler(rec, httptest.NewRequest("POST", "/scanner/scan", nil), opts)
+		return rec
+	}
+
+	// The scanner wakes up and connects while the agent retries
+	a.scanner.FailOpen(errors.New("host is down"))
+	time.AfterFunc(600*time.Millisecond, func() { a.scanner.FailOpen(nil) })
+	if rec := scan("/dev/rfcomm0", bluetoothLink{ConnectTimeout: 5 * time.Second}); rec.Code != 200 {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	// A virtual COM port is only retried with -bluetooth
+	a.scanner.FailOpen(errors.New("the semaphore timeout period has expired"))
+	start := time.Now()
+	if rec := scan("COM7", bluetoothLink{ConnectTimeout: 5 * time.Second}); rec.Code != 500 || time.Since(start) > time.Second {
+		t.Errorf("COM port without -bluetooth: status = %d after %v", rec.Code, time.Since(start))
+	}
+	rec := scan("COM7", bluetoothLink{Always: true, ConnectTimeout: 800 * time.Millisecond})
+	if rec.Code != 500 || !strings.Contains(rec.Body.String(), "did not connect within 800ms") {
+		t.Errorf("unreachable scanner: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if opened := a.scanner.Opened(); len(opened) != 1 {
+		t.Errorf("opened %v, want only the first connect", opened)
+	}
+
+	for port, want := range map[string]bool{
+		"/dev/rfcomm0":                    true,
+		"/dev/cu.Socket-SerialPort":       true,
+		"/dev/cu.Bluetooth-Incoming-Port": false,
+		"/dev/ttyUSB0":                    false,
+		"COM7":                            false,
+	} {
+		if isBluetoothPort(port) != want {
+			t.Errorf("isBluetoothPort(%q) = %v", port, !want)
+		}
+	}
+}
+
+func TestNetworkScanner(t *testing.T) {
+	startAgent(t, "")
+
+	// A serial device server: the scan command comes in over TCP and the
+	// swipe goes back the same way
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	commands := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				commands <- string(buf[:n])
+				conn.Write([]byte(bcSwipe))
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	address := "tcp://" + ln.Addr().String()
+
+	rec := httptest.NewRecorder()
+	opts := agentOptions{PortOverride: address, UseSimpleCommand: true, ReadTimeout: time.Second, ScanIdleTimeout: 100 * time.Millisecond}
+	scannerHandler(rec, httptest.NewRequest("POST", "/scanner/scan", nil), opts)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"state":"BC"`) {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if command := <-commands; command != "\x01<TXPING>\x04" {
+		t.Errorf("device server received %q", command)
+	}
+
+	if check := checkScanner(address); !check.OK {
+		t.Errorf("reachable scanner is not ready: %s", check.Detail)
+	}
+	ln.Close()
+	if check := checkScanner(address); check.OK {
+		t.Error("unreachable scanner is ready")
+	}
+	rec = httptest.NewRecorder()
+	scannerHandler(rec, httptest.NewRequest("POST", "/scanner/scan", nil), opts)
+	if rec.Code != 500 || !strings.Contains(rec.Body.String(), "refused") {
+		t.Errorf("unreachable scanner: status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSendScannerCommand(t *testing.T) {
+	// The scan loop runs against a mock transport, no agent needed
+	scanner := testharness.NewMockSerial(aamvaScan)
+	orig := serialTransport
+	t.Cleanup(func() { serialTransport = orig })
+	serialTransport = scanner
+
+	// A barcode ends as soon as its last subfile is in, not after the idle timeout
+	start := time.Now()
+	result, err := sendScannerCommand(context.Background(), []byte("\x01<TXPING>\x04"), "COM9", false, 5*time.Second, 5*time.Second, bluetoothLink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != aamvaScan {
+		t.Errorf("result = %q", result)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("complete barcode waited %v", elapsed)
+	}
+	if written := scanner.Written(); len(written) != 1 || string(written[0]) != "\x01<TXPING>\x04" {
+		t.Errorf("written = %q", written)
+	}
+
+	// Nobody swipes: nothing comes back once the read timeout passes
+	scanner.SetResponse("")
+	result, err = sendScannerCommand(context.Background(), []byte("\x01<TXPING>\x04"), "COM9", false, 300*time.Millisecond, 100*time.Millisecond, bluetoothLink{})
+	if err != nil || result != "" {
+		t.Errorf("no swipe: result %q, err %v", result, err)
+	}
+
+	// The client gives up: the scan ends without waiting out its timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	_, err = sendScannerCommand(ctx, []byte("\x01<TXPING>\x04"), "COM9", false, 10*time.Second, 100*time.Millisecond, bluetoothLink{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("cancelled scan: err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled scan took %v", elapsed)
+	}
+}
+
+func TestScannerInfo(t *testing.T) {
+	a := startAgent(t, "\x15")
+	a.scanner.Answer("TXMODEL", "\x02<IDW-M200>\x03")
+	a.scanner.Answer("TXVER", "3.14.1\r\n")
+	a.scanner.Answer("TXCFG", "TRACKS=123;BEEP=ON\nAAMVA:1")
+
+	resp := a.PostJSON("/scanner/info", nil)
+	if resp.StatusCode != 405 {
+		t.Errorf("POST: status = %d, want 405", resp.StatusCode)
+	}
+	resp = a.Get("/scanner/info")
+	var info ScannerInfoResponse
+	if err := json.Unmarshal(resp.Body, &info); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if info.Status != "success" || info.Model != "IDW-M200" || info.Firmware != "3.14.1" || info.Port == "" || info.BaudRate == 0 {
+		t.Errorf("info = %s", resp.Body)
+	}
+	want := map[string]string{"TRACKS": "123", "BEEP": "ON", "AAMVA": "1"}
+	if fmt.Sprint(info.Configuration) != fmt.Sprint(want) {
+		t.Errorf("configuration = %v, want %v", info.Configuration, want)
+	}
+	if len(info.Replies) != 3 || info.Replies["<TXVER,4>"] != "3.14.1\r\n" {
+		t.Errorf("replies = %q", info.Replies)
+	}
+
+	// A scanner that refuses every query
+	a = startAgent(t, "\x15")
+	resp = a.Get("/scanner/info")
+	if body := resp.JSON(t); resp.StatusCode != 200 || body["status"] != "warning" || body["message"] == nil {
+		t.Errorf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestScanGenericCard(t *testing.T) {
+	// A payment card: the number is masked and discretionary data dropped
+	a := startAgent(t, "%B4111111111111111^DOE/JOHN Q.MR^2612101123456789?;4111111111111111=26121011234567890?")
+
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	body := resp.JSON(t)
+	if body["cardType"] != "generic" {
+		t.Fatalf("cardType = %v, body %s", body["cardType"], resp.Body)
+	}
+	card := body["cardData"].(map[string]interface{})
+	want := map[string]interface{}{
+		"number":      "************1111",
+		"masked":      true,
+		"lastName":    "DOE",
+		"firstName":   "JOHN Q",
+		"expiry":      "2026-12",
+		"serviceCode": "101",
+	}
+	for field, value := range want {
+		if card[field] != value {
+			t.Errorf("%s = %v, want %v", field, card[field], value)
+		}
+	}
+	if strings.Contains(string(resp.Body), "4111111111111111") || strings.Contains(string(resp.Body), "123456789") {
+		t.Error("response leaks the card number or discretionary data")
+	}
+
+	// A membership card with only a number on track 2 is returned as is
+	a.scanner.SetResponse(";60123456789?")
+	card, _ = a.PostJSON("/scanner/scan", "").JSON(t)["cardData"].(map[string]interface{})
+	if card["number"] != "60123456789" || card["masked"] != false {
+		t.Errorf("membership card = %v", card)
+	}
+}
+
+func TestScanPartialRead(t *testing.T) {
+	// The reader pauses mid-track for longer than the idle timeout; the
+	// agent keeps reading to the end sentinel
+	cut := strings.Index(bcSwipe, "123 MAIN")
+	a := startAgent(t, bcSwipe[:cut])
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		a.scanner.Send([]byte(bcSwipe[cut:]))
+	}()
+	body := a.PostJSON("/scanner/scan", "").JSON(t)
+	license, _ := body["licenseData"].(map[string]interface{})
+	if body["complete"] != true || license["licenseNumber"] != "1234567" {
+		t.Errorf("resumed swipe: %v", body)
+	}
+
+	// A barcode that never gets to the length its header gives
+	a.scanner.SetResponse(aamvaScan[:len(aamvaScan)-20])
+	started := time.Now()
+	body = a.PostJSON("/scanner/scan", "").JSON(t)
+	if body["complete"] != false {
+		t.Errorf("cut barcode: complete = %v", body["complete"])
+	}
+	if waited := time.Since(started); waited < 900*time.Millisecond {
+		t.Errorf("gave up on a cut barcode after %v, want the 1s read timeout", waited)
+	}
+
+	// A whole barcode ends without waiting for the idle timeout
+	a.scanner.SetResponse(aamvaScan)
+	if body := a.PostJSON("/scanner/scan", "").JSON(t); body["complete"] != true {
+		t.Errorf("whole barcode: complete = %v", body["complete"])
+	}
+}
+
+func TestScanMisread(t *testing.T) {
+	a := startAgent(t, "")
+
+	for name, scan := range map[string]string{
+		"reader error":         "%E?;E?",
+		"garbled name":         strings.Replace(bcSwipe, "$JANE", "$J\x8eNE", 1),
+		"track 1 cut short":    strings.Replace(bcSwipe, "1A1^?", "1A1", 1),
+		"BC date out of range": strings.Replace(bcSwipe, "=271229900115=", "=271329900115=", 1),
+		"track 2 LRC mismatch": ";5012345678902=2612?9",
+		"AAMVA birth date":     strings.Replace(aamvaScan, "DBB19850704", "DBB19851344", 1),
+		"AAMVA sex code":       strings.Replace(aamvaScan, "DBC1", "DBC7", 1),
+		"AAMVA name":           strings.Replace(aamvaScan, "DACJOHN", "DACJ0HN", 1),
+		"AAMVA issuer number":  strings.Replace(aamvaScan, "ANSI 636014", "ANSI 63#014", 1),
+		"AAMVA state code":     strings.Replace(aamvaScan, "DAJCA", "DAJC", 1),
+	} {
+		a.scanner.SetResponse(scan)
+		resp := a.PostJSON("/scanner/scan", "")
+		if resp.StatusCode != 422 {
+			t.Errorf("%s: status = %d, want 422 (body %s)", name, resp.StatusCode, resp.Body)
+			continue
+		}
+		if body := resp.JSON(t); body["code"] != "swipe_again" || !strings.Contains(body["message"].(string), "swipe again") {
+			t.Errorf("%s: body %s", name, resp.Body)
+		}
+	}
+
+	// A matching LRC after the end sentinel is accepted
+	a.scanner.SetResponse(";5012345678902=2612?8")
+	if resp := a.PostJSON("/scanner/scan", ""); resp.StatusCode != 200 {
+		t.Errorf("valid LRC: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestScanPortrait(t *testing.T) {
+	var portrait bytes.Buffer
+	if err := jpeg.Encode(&portrait, image.NewGray(image.Rect(0, 0, 8, 10)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A DL subfile and a jurisdiction subfile holding the portrait
+	dl := "DL\nDCSSMITH\nDACJOHN\nDAQD1234567\n"
+	zd := "ZD" + portrait.String()
+	header := fmt.Sprintf("@\n\x1e\rANSI 636014080002DL%04d%04dZD%04d%04d", 41, len(dl), 41+len(dl), len(zd))
+	a := startAgent(t, header+dl+zd)
+
+	resp := a.PostJSON("/scanner/scan?photo=true", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	body := resp.JSON(t)
+	if license := body["licenseData"].(map[string]interface{}); license["lastName"] != "SMITH" {
+		t.Errorf("lastName = %v", license["lastName"])
+	}
+	if body["portraitType"] != "image/jpeg" {
+		t.Errorf("portraitType = %v", body["portraitType"])
+	}
+	data, err := base64.StdEncoding.DecodeString(body["portrait"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, portrait.Bytes()) {
+		t.Errorf("portrait is %d bytes, want the %d byte JPEG", len(data), portrait.Len())
+	}
+
+	// Without ?photo the image is left out
+	if _, ok := a.PostJSON("/scanner/scan", "").JSON(t)["portrait"]; ok {
+		t.Error("portrait returned without ?photo=true")
+	}
+}
+
+func TestScanPrivacyMode(t *testing.T) {
+	a := startAgent(t, bcSwipe)
+	origKey, origDir := scanKey, scanStoreDir
+	t.Cleanup(func() {
+		privacyMode.Store(false)
+		scanKey, scanStoreDir = origKey, origDir
+	})
+	privacyMode.Store(true)
+	scanKey = bytes.Repeat([]byte{0x42}, seal.KeySize)
+	scanStoreDir = filepath.Join(a.appDir, "scans")
+
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	body := resp.JSON(t)
+	license := body["licenseData"].(map[string]interface{})
+	if license["licenseNumber"] != "***4567" {
+		t.Errorf("licenseNumber = %v, want it masked", license["licenseNumber"])
+	}
+	if _, ok := license["rawData"]; ok {
+		t.Error("rawData returned without -debug-scans")
+	}
+
+	// The scan is saved encrypted and still resolves after a restart
+	scanID := body["scanId"].(string)
+	sealed, err := os.ReadFile(filepath.Join(scanStoreDir, scanID+".sealed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("1234567")) || bytes.Contains(sealed, []byte("DOE")) {
+		t.Error("saved scan is not encrypted")
+	}
+	scansMu.Lock()
+	delete(recentScans, scanID)
+	scansMu.Unlock()
+	scan, err := lookupScan(scanID)
+	if err != nil {
+		t.Fatalf("saved scan did not load: %v", err)
+	}
+	if scan.License.LicenseNumber != "1234567" {
+		t.Errorf("saved scan licenseNumber = %q", scan.License.LicenseNumber)
+	}
+}
+
+func TestScanTimeout(t *testing.T) {
+	a := startAgent(t, "")
+
+	if resp := a.PostJSON("/scanner/scan?timeout=soon", ""); resp.StatusCode != 400 {
+		t.Errorf("invalid timeout: status = %d, want 400", resp.StatusCode)
+	}
+
+	// The swipe comes after the default one second wait but within the
+	// timeout the request asked for
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		a.scanner.Send([]byte(bcSwipe))
+	}()
+	start := time.Now()
+	resp := a.PostJSON("/scanner/scan?timeout=30", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if license := resp.JSON(t)["licenseData"].(map[string]interface{}); license["lastName"] != "DOE" {
+		t.Errorf("lastName = %v", license["lastName"])
+	}
+	if elapsed := time.Since(start); elapsed > 2500*time.Millisecond {
+		t.Errorf("scan took %v; the swipe should end after the idle timeout", elapsed)
+	}
+
+	// A timeout too long to be a Duration is held to -max-timeout rather
+	// than overflowing into a negative wait
+	go func() {
+		time.Sleep(1500 * time.Millisecond)
+		a.scanner.Send([]byte(bcSwipe))
+	}()
+	if resp := a.PostJSON("/scanner/scan?timeout=9999999999", ""); resp.StatusCode != 200 {
+		t.Errorf("huge timeout: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestConcurrentScans(t *testing.T) {
+	a := startAgent(t, "")
+
+	// Nobody swipes, so the first scan holds the scanner for a second
+	first := make(chan testharness.Response)
+	go func() { first <- a.PostJSON("/scanner/scan", "") }()
+	deadline := time.Now().Add(time.Second)
+	for a.Get("/scanner/status").JSON(t)["scanning"] != true {
+		if time.Now().After(deadline) {
+			t.Fatal("status never reported the scan in progress")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if resp := a.PostJSON("/scanner/scan", ""); resp.StatusCode != 409 {
+		t.Errorf("concurrent scan: status = %d, want 409", resp.StatusCode)
+	}
+
+	// A queued scan runs once the first one finishes
+	queued := make(chan testharness.Response)
+	go func() { queued <- a.PostJSON("/scanner/scan?queue=true", "") }()
+	if resp := <-first; resp.StatusCode != 404 {
+		t.Errorf("first scan: status = %d, want 404 (nothing swiped)", resp.StatusCode)
+	}
+	if resp := <-queued; resp.StatusCode != 404 {
+		t.Errorf("queued scan: status = %d, want 404 (nothing swiped)", resp.StatusCode)
+	}
+	if opened := a.scanner.Opened(); len(opened) != 2 {
+		t.Errorf("scanner opened %d times, want 2", len(opened))
+	}
+	if a.Get("/scanner/status").JSON(t)["scanning"] != false {
+		t.Error("status still reports a scan in progress")
+	}
+}
+
+func TestScanWithoutLicense(t *testing.T) {
+	a := startAgent(t, "\x15")
+
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 404 {
+		t.Fatalf("status = %d, want 404 (body %s)", resp.StatusCode, resp.Body)
+	}
+	if msg := resp.JSON(t)["message"]; !strings.Contains(msg.(string), "NAK") {
+		t.Errorf("message = %q", msg)
+	}
+}
+
+func TestScanPortUnavailable(t *testing.T) {
+	a := startAgent(t, bcSwipe)
+	a.scanner.FailOpen(errors.New("port busy"))
+
+	resp := a.PostJSON("/scanner/scan", "")
+	if resp.StatusCode != 500 {
+		t.Fatalf("status = %d, want 500 (body %s)", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestPrintReceipt(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1001",
+		"items": []map[string]interface{}{
+			{"name": "Ski Rental", "quantity": 2, "price": 25.00},
+			{"name": "Helmet", "quantity": 1, "price": 10.00},
+		},
+		"subtotal":    60.00,
+		"tax":         7.20,
+		"total":       67.20,
+		"paymentType": "credit",
+		"date":        "2025-06-01",
+		"location":    "Main Street",
+		"copies":      2,
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	body := resp.JSON(t)
+	if body["message"] != "Printed 2/2 copies successfully" {
+		t.Errorf("message = %q", body["message"])
+	}
+	if w, ok := body["warning"]; ok {
+		t.Errorf("unexpected totals warning %q", w)
+	}
+
+	// Both copies are rendered once and printed as one job
+	jobs := a.printer.Jobs()
+	if len(jobs) != 1 {
+		t.Fatalf("printed %d jobs, want 1", len(jobs))
+	}
+	if runs := a.printer.Commands(); len(runs) != 2 {
+		t.Errorf("ran %d commands, want one conversion and one lp: %v", len(runs), runs)
+	}
+	job := jobs[0]
+	first, second := strings.Index(job.HTML, "Copy 1 of 2"), strings.Index(job.HTML, "Copy 2 of 2")
+	if first < 0 || second < first || strings.Count(job.HTML, "Ski Rental") != 2 {
+		t.Error("the job doesn't hold two numbered copies")
+	}
+	if job.Printer != "Receipt_Printer" {
+		t.Errorf("printer = %q", job.Printer)
+	}
+	if dir := filepath.Dir(job.Path); dir != filepath.Join(a.appDir, "temp") {
+		t.Errorf("PDF written to %s, want the app temp directory", dir)
+	}
+	for _, want := range []string{"TXN-1001", "Ski Rental", "Helmet", "$50.00", "$67.20", "GST (5%)", "$3.00", "PST (7%)", "$4.20"} {
+		if !strings.Contains(job.HTML, want) {
+			t.Errorf("rendered receipt is missing %q", want)
+		}
+	}
+
+	// Rendered files are archived against the transaction
+	archived := a.Get("/archive/receipt?transactionId=TXN-1001&kind=html")
+	if archived.StatusCode != 200 || !strings.Contains(string(archived.Body), "TXN-1001") {
+		t.Errorf("archived receipt: status %d", archived.StatusCode)
+	}
+
+	// Thermal copies are one raw job, each copy numbered and cut
+	resp = a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1002",
+		"items":         []map[string]interface{}{{"name": "Helmet", "quantity": 1, "price": 10.00}},
+		"total":         10.00,
+		"copies":        3,
+		"output":        "thermal",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	jobs = a.printer.Jobs()
+	if len(jobs) != 2 {
+		t.Fatalf("printed %d jobs, want 2", len(jobs))
+	}
+	raw := string(jobs[1].Raw)
+	if strings.Count(raw, "TXN-1002") != 3 || strings.Count(raw, "\x1dV") != 3 || !strings.Contains(raw, "Copy 3 of 3") {
+		t.Errorf("thermal job doesn't hold three numbered copies:\n%q", raw)
+	}
+}
+
+func TestPrintDuplicateReceipt(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1001",
+		"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 50.00}},
+		"total":         50.00,
+		"location":      "Main Street",
+	}
+
+	first := a.PostJSON("/print/receipt", receipt).JSON(t)
+	second := a.PostJSON("/print/receipt", receipt).JSON(t)
+	if first["duplicate"] != nil || second["duplicate"] != true {
+		t.Errorf("responses = %v, %v", first, second)
+	}
+	jobs := a.printer.Jobs()
+	if len(jobs) != 2 {
+		t.Fatalf("printed %d jobs, want 2", len(jobs))
+	}
+	if strings.Contains(jobs[0].HTML, "DUPLICATE") || !strings.Contains(jobs[1].HTML, "*** DUPLICATE ***") {
+		t.Error("only the second copy should carry the DUPLICATE banner")
+	}
+	entries, _ := a.Get("/history/prints").JSON(t)["entries"].([]interface{})
+	if len(entries) != 2 || entries[0].(map[string]interface{})["duplicate"] != true {
+		t.Errorf("print history = %v", entries)
+	}
+
+	// A corrected receipt for the same transaction isn't a duplicate
+	receipt["total"] = 55.00
+	if corrected := a.PostJSON("/print/receipt", receipt).JSON(t); corrected["duplicate"] != nil {
+		t.Errorf("corrected receipt = %v", corrected)
+	}
+
+	// Set to reject, the duplicate isn't printed at all
+	strict := testharness.Start(t, setupRoutes(agentOptions{
+		PrinterName:     "Receipt_Printer",
+		AppDir:          a.appDir,
+		DuplicateWindow: time.Minute,
+		DuplicateAction: dedupe.Reject,
+	}))
+	strict.PostJSON("/print/receipt", receipt)
+	if resp := strict.PostJSON("/print/receipt", receipt); resp.StatusCode != http.StatusConflict {
+		t.Errorf("duplicate with reject: status = %d, want 409 (body %s)", resp.StatusCode, resp.Body)
+	}
+	if len(a.printer.Jobs()) != 4 {
+		t.Errorf("printed %d jobs, want 4", len(a.printer.Jobs()))
+	}
+}
+
+func TestPrintJobArtifacts(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1001",
+		"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 50.00}},
+		"total":         50.00,
+	}
+
+	// Without -job-artifact-days nothing is kept
+	body := a.PostJSON("/print/receipt", receipt).JSON(t)
+	jobID, _ := body["jobId"].(string)
+	if !strings.HasPrefix(jobID, "job-") {
+		t.Fatalf("jobId = %v", body["jobId"])
+	}
+	if resp := a.Get("/print/jobs/" + jobID + "/artifact"); resp.StatusCode != 503 {
+		t.Errorf("artifact without retention: status = %d, want 503", resp.StatusCode)
+	}
+
+	var err error
+	jobArtifacts, err = archive.Open(filepath.Join(a.appDir, "jobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { jobArtifacts = nil })
+
+	// A PDF job keeps the PDF the printer got and the HTML it came from
+	receipt["total"] = 55.00
+	jobID = a.PostJSON("/print/receipt", receipt).JSON(t)["jobId"].(string)
+	jobs := a.printer.Jobs()
+	pdf, err := os.ReadFile(jobs[len(jobs)-1].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := a.Get("/print/jobs/" + jobID + "/artifact")
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/pdf" || !bytes.Equal(resp.Body, pdf) {
+		t.Errorf("PDF artifact: status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("X-Transaction-Id") != "TXN-1001" {
+		t.Errorf("X-Transaction-Id = %q", resp.Header.Get("X-Transaction-Id"))
+	}
+	if html := a.Get("/print/jobs/" + jobID + "/artifact?kind=html"); html.StatusCode != 200 || !strings.Contains(string(html.Body), "$55.00") {
+		t.Errorf("HTML artifact: status %d", html.StatusCode)
+	}
+
+	// A thermal job keeps the exact ESC/POS bytes
+	receipt["total"], receipt["output"] = 60.00, "thermal"
+	jobID = a.PostJSON("/print/receipt", receipt).JSON(t)["jobId"].(string)
+	jobs = a.printer.Jobs()
+	resp = a.Get("/print/jobs/" + jobID + "/artifact")
+	if resp.StatusCode != 200 || !bytes.Equal(resp.Body, jobs[len(jobs)-1].Raw) {
+		t.Errorf("ESC/POS artifact: status %d, %d bytes", resp.StatusCode, len(resp.Body))
+	}
+
+	// The journal ties the job to its transaction
+	entries, _ := a.Get("/history/prints").JSON(t)["entries"].([]interface{})
+	if len(entries) != 3 || entries[0].(map[string]interface{})["jobId"] != jobID {
+		t.Errorf("print history = %v", entries)
+	}
+
+	if resp := a.Get("/print/jobs/job-0000000000000000/artifact"); resp.StatusCode != 404 {
+		t.Errorf("unknown job: status = %d, want 404", resp.StatusCode)
+	}
+	if resp := a.Get("/print/jobs/..%2Findex/artifact"); resp.StatusCode != 400 {
+		t.Errorf("invalid job ID: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestRetryManualPrint(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	t.Cleanup(func() { manualPrints = make(map[string]*manualPrintError) })
+
+	// On Windows a PDF that no silent method printed is opened on screen;
+	// the request is answered as needing someone, not as printed
+	rec := httptest.NewRecorder()
+	manual := &manualPrintError{JobID: "job-00000000000000aa", Path: filepath.Join(a.appDir, "receipt.pdf"), Kind: "receipt", TransactionID: "TXN-1001", Printer: "Receipt_Printer"}
+	writeManualPrint(rec, manual)
+	var body map[string]interface{}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != 202 || body["status"] != "manual_intervention_required" || body["pdfPath"] != manual.Path || body["retryUrl"] != "/v1/print/jobs/job-00000000000000aa/retry" {
+		t.Errorf("manual print response: %d %v", rec.Code, body)
+	}
+
+	if err := os.WriteFile(manual.Path, []byte("%PDF-1.4 testharness\n<p>TXN-1001</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rememberManualPrint(manual)
+
+	// A retry prints the same PDF silently, once
+	resp := a.PostJSON("/print/jobs/job-00000000000000aa/retry", nil)
+	if resp.StatusCode != 200 || resp.JSON(t)["status"] != "success" {
+		t.Fatalf("retry: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 1 || jobs[0].Printer != "Receipt_Printer" {
+		t.Errorf("jobs = %+v", jobs)
+	}
+	entries, _ := a.Get("/history/prints").JSON(t)["entries"].([]interface{})
+	if len(entries) != 1 || entries[0].(map[string]interface{})["jobId"] != manual.JobID || entries[0].(map[string]interface{})["status"] != "success" {
+		t.Errorf("print history = %v", entries)
+	}
+	if resp := a.PostJSON("/print/jobs/job-00000000000000aa/retry", nil); resp.StatusCode != 404 {
+		t.Errorf("second retry: status = %d, want 404", resp.StatusCode)
+	}
+
+	// A retry that still fails leaves the job waiting
+	rememberManualPrint(manual)
+	a.printer.Fail("lp")
+	if resp := a.PostJSON("/print/jobs/job-00000000000000aa/retry", nil); resp.StatusCode != 502 {
+		t.Errorf("failed retry: status = %d, want 502", resp.StatusCode)
+	}
+	os.Remove(manual.Path)
+	if resp := a.PostJSON("/print/jobs/job-00000000000000aa/retry", nil); resp.StatusCode != 410 {
+		t.Errorf("retry without the PDF: status = %d, want 410", resp.StatusCode)
+	}
+}
+
+func TestReceiptNumbers(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	path := filepath.Join(a.appDir, receiptNumbersFile)
+	var err error
+	if receiptNumbers, err = sequence.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	printAs := func(txID, station, receiptType string) testharness.Response {
+		t.Helper()
+		return a.PostJSON("/print/receipt", map[string]interface{}{
+			"transactionId": txID,
+			"type":          receiptType,
+			"stationId":     station,
+			"total":         10.00,
+			"location":      "Main Street",
+		})
+	}
+
+	// Each station counts on its own; no-sales aren't numbered
+	for i, want := range []struct {
+		station, receiptType string
+		number               interface{}
+	}{
+		{"", "", 1.0},
+		{"Till 2", "", 1.0},
+		{"", "noSale", nil},
+		{"", "refund", 2.0},
+	} {
+		resp := printAs(fmt.Sprintf("TXN-%d", i+1), want.station, want.receiptType)
+		if got := resp.JSON(t)["receiptNumber"]; got != want.number {
+			t.Errorf("receipt %d: number = %v, want %v", i+1, got, want.number)
+		}
+	}
+	jobs := a.printer.Jobs()
+	if !strings.Contains(jobs[0].HTML, "Receipt No.: 000001") || !strings.Contains(jobs[3].HTML, "Receipt No.: 000002") {
+		t.Error("receipt numbers are not printed")
+	}
+	entries, _ := a.Get("/history/prints?limit=1").JSON(t)["entries"].([]interface{})
+	if len(entries) != 1 || entries[0].(map[string]interface{})["receiptNumber"] != 2.0 {
+		t.Errorf("journal = %v", entries)
+	}
+
+	// A receipt that doesn't print gives its number back
+	a.printer.Fail("lp")
+	if resp := printAs("TXN-5", "", ""); resp.StatusCode != 500 {
+		t.Fatalf("status = %d, want 500", resp.StatusCode)
+	}
+
+	// The numbers survive a restart
+	reopened, err := sequence.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := reopened.Last(); last[""] != 2 || last["Till 2"] != 1 {
+		t.Errorf("after a restart: %v", last)
+	}
+
+	// A receipt numbered while another of its station is printing waits
+	// for it, so the first failing can't leave a gap behind the second
+	first, err := reopened.Reserve(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := make(chan int64)
+	go func() {
+		next, err := reopened.Reserve(context.Background(), "")
+		if err != nil {
+			t.Error(err)
+			close(second)
+			return
+		}
+		second <- next.Number
+		next.Done(true)
+	}()
+	select {
+	case n := <-second:
+		t.Fatalf("second receipt numbered %d while the first was printing", n)
+	case <-time.After(100 * time.Millisecond):
+	}
+	first.Done(false)
+	if n := <-second; n != first.Number {
+		t.Errorf("second receipt numbered %d, want %d handed back by the first", n, first.Number)
+	}
+}
+
+func TestPrintReceiptFallsBackToChromium(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	a.printer.Fail("chrome")
+	a.printer.Fail("google-chrome")
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1002",
+		"items":         []map[string]interface{}{{"name": "Boots", "quantity": 1, "price": 15.00}},
+		"subtotal":      15.00,
+		"total":         16.80,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	var browsers []string
+	for _, cmd := range a.printer.Commands() {
+		if cmd[0] != "lp" {
+			browsers = append(browsers, cmd[0])
+		}
+	}
+	if strings.Join(browsers, ",") != "chrome,google-chrome,chromium-browser" {
+		t.Errorf("browsers tried = %v", browsers)
+	}
+	if len(a.printer.Jobs()) != 1 {
+		t.Errorf("printed %d jobs, want 1", len(a.printer.Jobs()))
+	}
+}
+
+func TestPrintAgreement(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	agreement := map[string]interface{}{
+		"agreementNumber": "RA-2001",
+		"customer": map[string]interface{}{
+			"firstName":     "JANE",
+			"lastName":      "DOE",
+			"licenseNumber": "1234567",
+			"dob":           "1985-03-14",
+		},
+		"items": []map[string]interface{}{
+			{"name": "Mountain Bike", "assetTag": "BK-0042", "quantity": 1, "rate": 45.00, "rateUnit": "day", "deposit": 200.00},
+			{"name": "Helmet", "quantity": 2, "rate": 5.00, "rateUnit": "day"},
+		},
+		"rentalStart": "2025-06-01 09:00",
+		"rentalEnd":   "2025-06-03 17:00",
+		"terms":       []string{"Equipment must be returned clean."},
+		"waiverText":  "I accept the risks of cycling.",
+		"paperSize":   "a4",
+		"location":    "Main Street",
+	}
+	resp := a.PostJSON("/print/agreement", agreement)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	jobs := a.printer.Jobs()
+	if len(jobs) != 1 {
+		t.Fatalf("printed %d jobs, want 1", len(jobs))
+	}
+	if jobs[0].Printer != "Office_Printer" {
+		t.Errorf("printer = %q, want the agreement printer", jobs[0].Printer)
+	}
+	for _, want := range []string{"RA-2001", "DOE", "1234567", "BK-0042", "Helmet", "$55.00", "$200.00", "size: A4", "Equipment must be returned clean.", "I accept the risks of cycling."} {
+		if !strings.Contains(jobs[0].HTML, want) {
+			t.Errorf("rendered agreement is missing %q", want)
+		}
+	}
+
+	// A template in the templates directory replaces the built-in one
+	dir := filepath.Join(a.appDir, "templates")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	custom := `<html><body>Custom agreement {{.AgreementNumber}} for {{.Customer.LastName}}</body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "agreement.html"), []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if resp := a.PostJSON("/print/agreement", agreement); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 2 || !strings.Contains(jobs[1].HTML, "Custom agreement RA-2001 for DOE") {
+		t.Error("custom agreement template was not used")
+	}
+
+	missing := a.PostJSON("/print/agreement", map[string]interface{}{"agreementNumber": "RA-2002", "customer": map[string]string{"lastName": "DOE"}})
+	if missing.StatusCode != 400 {
+		t.Errorf("agreement without items: status = %d, want 400", missing.StatusCode)
+	}
+}
+
+func TestPrintEmbeddedFonts(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	saved := embeddedFonts
+	t.Cleanup(func() { embeddedFonts = saved })
+
+	dir := t.TempDir()
+	files := map[string]string{"receipt-mono.woff2": "mono font", "receipt-mono-bold.ttf": "bold font", "receipt-sans.otf": "sans font", "notes.txt": "not a font"}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fonts, found, bundled, err := loadFonts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(found, ","); got != "receipt-mono.woff2,receipt-mono-bold.ttf,receipt-sans.otf" {
+		t.Errorf("found %s", got)
+	}
+	if got := strings.Join(bundled, ","); got != "receipt-sans-bold" {
+		t.Errorf("bundled %s", got)
+	}
+	embeddedFonts = fonts
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1200",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("receipt: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	agreement := map[string]interface{}{
+		"agreementNumber": "RA-1200",
+		"customer":        map[string]interface{}{"lastName": "DOE"},
+		"items":           []map[string]interface{}{{"name": "Kayak", "quantity": 1, "rate": 40.00}},
+	}
+	if resp := a.PostJSON("/print/agreement", agreement); resp.StatusCode != 200 {
+		t.Fatalf("agreement: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	jobs := a.printer.Jobs()
+	mono := "@font-face { font-family: 'Receipt Mono'; font-weight: normal; src: url(data:font/woff2;base64," + base64.StdEncoding.EncodeToString([]byte("mono font")) + ") format('woff2'); }"
+	for _, want := range []string{mono, "font-weight: bold; src: url(data:font/ttf;base64,", "font-family: 'Receipt Mono', 'Courier New', monospace;"} {
+		if !strings.Contains(jobs[0].HTML, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	for _, want := range []string{"font-family: 'Receipt Sans'; font-weight: normal; src: url(data:font/otf;base64,", "font-family: 'Receipt Sans'; font-weight: bold; src: url(data:font/woff2;base64,d09GMg", "font-family: 'Receipt Sans', Arial"} {
+		if !strings.Contains(jobs[1].HTML, want) {
+			t.Errorf("agreement is missing %q", want)
+		}
+	}
+}
+
+func TestBundledFonts(t *testing.T) {
+	fonts, found, bundled, err := loadFonts(filepath.Join(t.TempDir(), "none"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 || strings.Join(bundled, ",") != "receipt-mono,receipt-mono-bold,receipt-sans,receipt-sans-bold" {
+		t.Errorf("found %v, bundled %v", found, bundled)
+	}
+	for _, face := range fontFaces {
+		rule := fmt.Sprintf("font-family: '%s'; font-weight: %s; src: url(data:font/woff2;base64,d09GMg", face.Family, face.Weight)
+		if !strings.Contains(string(fonts), rule) {
+			t.Errorf("bundled fonts have no %s %s face", face.Family, face.Weight)
+		}
+	}
+	if license, err := bundledFonts.ReadFile("fonts/OFL.txt"); err != nil || !strings.Contains(string(license), "SIL OPEN FONT LICENSE Version 1.1") {
+		t.Errorf("bundled font license: %v", err)
+	}
+}
+
+func TestPrintInvoice(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	invoice := map[string]interface{}{
+		"invoiceNumber": "INV-1001",
+		"dueDate":       "2025-07-31",
+		"periodStart":   "2025-06-01",
+		"periodEnd":     "2025-06-30",
+		"paymentTerms":  "Net 30",
+		"account": map[string]interface{}{
+			"id":      "ACME-7",
+			"name":    "Acme Landscaping",
+			"address": []string{"12 Cedar Road", "Vancouver, BC V5K 0A1"},
+		},
+		"customer": map[string]interface{}{"firstName": "JANE", "lastName": "DOE"},
+		"items": []map[string]interface{}{
+			{"description": "Excavator rental", "date": "2025-06-04", "quantity": 2, "unitPrice": 150.00},
+			{"description": "Safety course", "quantity": 1, "unitPrice": 40.00, "taxExempt": true},
+		},
+		"amountPaid": 100.00,
+		"terms":      []string{"Interest of 2% a month on overdue balances."},
+		"paperSize":  "a4",
+	}
+	resp := a.PostJSON("/print/invoice", invoice)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	jobs := a.printer.Jobs()
+	if len(jobs) != 1 {
+		t.Fatalf("printed %d jobs, want 1", len(jobs))
+	}
+	if jobs[0].Printer != "Office_Printer" {
+		t.Errorf("printer = %q, want the document printer", jobs[0].Printer)
+	}
+	// $340.00 with GST and PST on the $300.00 taxable: $376.00, less $100.00 paid
+	for _, want := range []string{"INV-1001", "Acme Landscaping", "12 Cedar Road", "ACME-7", "2025-06-01", "Net 30",
+		"Excavator rental", "$300.00", "$340.00", "$15.00", "$21.00", "$376.00", "$276.00", "size: A4", "Interest of 2%"} {
+		if !strings.Contains(jobs[0].HTML, want) {
+			t.Errorf("rendered invoice is missing %q", want)
+		}
+	}
+	if strings.Contains(jobs[0].HTML, "DOE") {
+		t.Error("the account should be billed, not the customer")
+	}
+
+	// Without an account the customer is billed; html output comes back
+	delete(invoice, "account")
+	invoice["output"] = "html"
+	resp = a.PostJSON("/print/invoice", invoice)
+	if resp.StatusCode != 200 {
+		t.Fatalf("html output: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html, _ := resp.JSON(t)["html"].(string); !strings.Contains(html, "JANE DOE") {
+		t.Errorf("html output does not bill the customer: %s", html)
+	}
+	if len(a.printer.Jobs()) != 1 {
+		t.Error("html output was printed")
+	}
+
+	for name, body := range map[string]map[string]interface{}{
+		"no bill-to": {"invoiceNumber": "INV-1002", "items": invoice["items"]},
+		"no items":   {"invoiceNumber": "INV-1003", "account": map[string]string{"name": "Acme"}},
+		"thermal":    {"invoiceNumber": "INV-1004", "account": map[string]string{"name": "Acme"}, "items": invoice["items"], "output": "thermal"},
+	} {
+		if resp := a.PostJSON("/print/invoice", body); resp.StatusCode != 400 {
+			t.Errorf("%s: status = %d, want 400", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestPrintStatement(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	statement := map[string]interface{}{
+		"accountId":      "ACME-7",
+		"account":        map[string]interface{}{"name": "Acme Landscaping"},
+		"statementDate":  "2025-07-01",
+		"periodStart":    "2025-06-01",
+		"periodEnd":      "2025-06-30",
+		"openingBalance": 120.00,
+		"transactions": []map[string]interface{}{
+			{"date": "2025-06-20", "description": "Excavator rental", "reference": "TXN-3002", "amount": 300.00},
+			{"date": "2025-06-04", "description": "Trailer rental", "reference": "TXN-3001", "amount": 80.00},
+		},
+		"payments": []map[string]interface{}{
+			{"date": "2025-06-10", "description": "Cheque", "reference": "PAY-88", "amount": 150.00},
+		},
+		"balanceHistory": []map[string]interface{}{{"period": "2025-05", "balance": 120.00}},
+	}
+	resp := a.PostJSON("/print/statement", statement)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	jobs := a.printer.Jobs()
+	if len(jobs) != 1 || jobs[0].Printer != "Office_Printer" {
+		t.Fatalf("jobs = %+v, want one on the document printer", jobs)
+	}
+	html := jobs[0].HTML
+	// Entries by date, the balance running from $120.00: $200.00, $50.00, $350.00
+	trailer, cheque, excavator := strings.Index(html, "TXN-3001"), strings.Index(html, "PAY-88"), strings.Index(html, "TXN-3002")
+	if trailer < 0 || cheque < trailer || excavator < cheque {
+		t.Error("statement entries are not in date order")
+	}
+	for _, want := range []string{"ACME-7", "Acme Landscaping", "$200.00", "$50.00", "$380.00", "$150.00", "$350.00", "2025-05", "size: letter"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("rendered statement is missing %q", want)
+		}
+	}
+
+	// Thermal output goes raw to the receipt printer
+	statement["output"] = "thermal"
+	if resp := a.PostJSON("/print/statement", statement); resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	jobs = a.printer.Jobs()
+	if len(jobs) != 2 || jobs[1].Printer != "Receipt_Printer" || jobs[1].Raw == nil {
+		t.Fatalf("thermal statement was not printed raw on the receipt printer: %+v", jobs)
+	}
+	for _, want := range []string{"ACME-7", "PAY-88", "-$150.00", "$350.00"} {
+		if !bytes.Contains(jobs[1].Raw, []byte(want)) {
+			t.Errorf("thermal statement is missing %q", want)
+		}
+	}
+
+	// A drawer kick (ESC p) or a cut (GS V) in the account's text doesn't
+	// reach the printer as a command
+	statement["accountId"] = "ACME-7\x1bp\x00\x19\xfa"
+	statement["account"] = map[string]interface{}{"name": "Acme\x1dVA\x00 Landscaping"}
+	statement["notes"] = "Thanks\x1b@\nSee you soon"
+	statement["balanceHistory"] = []map[string]interface{}{{"period": "2025-05\x1bp", "balance": 120.00}}
+	if resp := a.PostJSON("/print/statement", statement); resp.StatusCode != 200 {
+		t.Fatalf("thermal with control bytes: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	raw := a.printer.Jobs()[2].Raw
+	if bytes.Contains(raw, []byte("\x1bp")) || bytes.Contains(raw, []byte("\x1dVA\x00 ")) || bytes.Count(raw, []byte("\x1b@")) != 1 {
+		t.Errorf("control bytes reached the printer: %q", raw)
+	}
+	if !bytes.Contains(raw, []byte("See you soon")) {
+		t.Error("statement notes lost their text")
+	}
+
+	if resp := a.PostJSON("/print/statement", map[string]interface{}{"statementDate": "2025-07-01"}); resp.StatusCode != 400 {
+		t.Errorf("statement without an account: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDocumentTemplateCache(t *testing.T) {
+	dir := t.TempDir()
+	templates := newDocumentTemplates(dir)
+	render := func(lang string) string {
+		t.Helper()
+		tmpl, err := templates.Get("agreement.html", lang)
+		if err != nil {
+			t.Fatalf("Get(%s): %v", lang, err)
+		}
+		html, err := tmplfuncs.Render(tmpl, AgreementData{})
+		if err != nil {
+			t.Fatalf("rendering: %v", err)
+		}
+		return html
+	}
+
+	if err := templates.Preload("en", "agreement.html"); err != nil {
+		t.Fatalf("built-in agreement template: %v", err)
+	}
+	first, _ := templates.Get("agreement.html", "en")
+	if again, _ := templates.Get("agreement.html", "en"); again != first {
+		t.Error("template was parsed again")
+	}
+
+	// The strings are bound per language
+	file := filepath.Join(dir, "agreement.html")
+	if err := os.WriteFile(file, []byte(`{{t "thank_you"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := render("en"); got != "Thank you for your purchase!" {
+		t.Errorf("English = %q", got)
+	}
+	if got := render("fr"); got != "Merci de votre achat!" {
+		t.Errorf("French = %q", got)
+	}
+
+	// A broken edit fails until it's fixed, and removing the file brings
+	// back the built-in template
+	if err := os.WriteFile(file, []byte(`{{if}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := templates.Get("agreement.html", "en"); err == nil {
+		t.Error("broken template parsed")
+	}
+	if err := os.WriteFile(file, []byte(`Fixed {{lang}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := render("fr"); got != "Fixed fr" {
+		t.Errorf("fixed template = %q", got)
+	}
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+	if got := render("en"); !strings.Contains(got, "<html") {
+		t.Errorf("built-in template was not restored: %.40q", got)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	a := startAgent(t, "")
+	check := func(body map[string]interface{}) TemplateValidateResponse {
+		t.Helper()
+		resp := a.PostJSON("/templates/validate", body)
+		if resp.StatusCode != 200 {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+		var result TemplateValidateResponse
+		if err := json.Unmarshal(resp.Body, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// The built-in templates pass
+	builtins := map[string]string{"receipt.html": receiptTemplate}
+	for _, name := range []string{"agreement.html", "invoice.html", "statement.html"} {
+		text, err := builtinTemplate(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		builtins[name] = text
+	}
+	for name, text := range builtins {
+		if result := check(map[string]interface{}{"name": name, "template": text}); !result.Valid {
+			t.Errorf("built-in %s: %+v", name, result.Problems)
+		}
+	}
+
+	broken := strings.Join([]string{
+		`<p>{{.Totl}}</p>`,
+		`<p>{{frobnicate .Total}} {{wibble}}</p>`,
+		`<p>{{.CardDetails.card.last4}}</p>`,
+		`<p>{{.Location.name}}</p>`,
+		`{{if .IsRefund}}<p>{{.RefundTotl}}</p>{{end}}`,
+		`{{with .Location}}<p>{{.name}}</p>{{end}}`,
+		`{{if and .CardDetails .CardDetails.card}}<p>{{.CardDetails.card.last4}}</p>{{end}}`,
+	}, "\n")
+	result := check(map[string]interface{}{"template": broken})
+	if result.Valid {
+		t.Fatal("a broken template is valid")
+	}
+	var got []string
+	for _, p := range result.Problems {
+		got = append(got, fmt.Sprintf("%d:%s", p.Line, p.Kind))
+	}
+	want := []string{"1:missing_field", "2:unknown_function", "2:unknown_function", "3:nil_dereference", "4:nil_dereference", "5:missing_field"}
+	if !slices.Equal(got, want) {
+		t.Errorf("problems = %v, want %v\n%+v", got, want, result.Problems)
+	}
+
+	// Mistakes that only show when rendering, with the request's sample
+	result = check(map[string]interface{}{
+		"template": `<p>{{money .CardDetails.cardType}}</p>`,
+		"sample":   map[string]interface{}{"location": "Main Street", "cardDetails": map[string]interface{}{"cardType": "VISA"}},
+	})
+	if len(result.Problems) != 1 || result.Problems[0].Kind != "execute" {
+		t.Errorf("money of text: %+v", result.Problems)
+	}
+	result = check(map[string]interface{}{"name": "invoice.html", "template": `{{if .Total}}`})
+	if len(result.Problems) != 1 || result.Problems[0].Kind != "syntax" {
+		t.Errorf("unclosed if: %+v", result.Problems)
+	}
+	result = check(map[string]interface{}{"name": "statement.html", "template": `{{.Account.Name}}{{template "nope" .}}`})
+	if len(result.Problems) != 2 || result.Problems[0].Kind != "nil_dereference" || result.Problems[1].Kind != "unknown_template" {
+		t.Errorf("statement: %+v", result.Problems)
+	}
+
+	if resp := a.PostJSON("/templates/validate", map[string]interface{}{"name": "label.zpl", "template": "x"}); resp.StatusCode != 400 {
+		t.Errorf("unknown template name: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestTemplateUpload(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	dir := filepath.Join(a.appDir, "templates")
+	saved := receiptTemplates
+	t.Cleanup(func() { receiptTemplates = saved })
+	receiptTemplates = newDocumentTemplates(dir)
+
+	send := func(method, name, token, body string) testharness.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, a.URL()+"/templates/"+name, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return a.Send(req)
+	}
+
+	if resp := send("GET", "receipt.html", "", ""); resp.StatusCode != 401 {
+		t.Errorf("no token: status = %d, want 401", resp.StatusCode)
+	}
+	resp := send("GET", "receipt.html", testAdminToken, "")
+	if resp.StatusCode != 200 || resp.Header.Get("X-Template-Source") != "builtin" || string(resp.Body) != receiptTemplate {
+		t.Fatalf("built-in receipt: status %d, source %q", resp.StatusCode, resp.Header.Get("X-Template-Source"))
+	}
+
+	// A branded receipt replaces the built-in one from the next print
+	branded := strings.Replace(receiptTemplate, "<body>", `<body><div class="brand">Paddle Co.</div>`, 1)
+	if resp := send("PUT", "receipt.html", testAdminToken, branded); resp.StatusCode != 200 {
+		t.Fatalf("PUT: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	resp = send("GET", "receipt.html", testAdminToken, "")
+	if resp.Header.Get("X-Template-Source") != "custom" || string(resp.Body) != branded {
+		t.Errorf("GET after PUT: source %q", resp.Header.Get("X-Template-Source"))
+	}
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1100",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("print: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html := a.printer.Jobs()[0].HTML; !strings.Contains(html, `<div class="brand">Paddle Co.</div>`) {
+		t.Error("the receipt did not print with the uploaded template")
+	}
+
+	// A broken template is refused and the one in use stays
+	resp = send("PUT", "invoice.html", testAdminToken, `<p>{{.InvoiceNumbr}}</p>`)
+	if resp.StatusCode != 422 || !strings.Contains(string(resp.Body), "missing_field") {
+		t.Errorf("broken template: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "invoice.html")); !os.IsNotExist(err) {
+		t.Error("the broken template was saved")
+	}
+	if resp := send("PUT", "../config.json", testAdminToken, "{}"); resp.StatusCode != 404 {
+		t.Errorf("path outside the templates: status = %d, want 404", resp.StatusCode)
+	}
+	if resp := send("PUT", "label.zpl", testAdminToken, "x"); resp.StatusCode != 404 {
+		t.Errorf("unknown template: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestPrintLimits(t *testing.T) {
+	a := startAgent(t, "")
+
+	huge := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1006",
+		"location":      strings.Repeat("x", 100<<10),
+	})
+	if huge.StatusCode != 413 {
+		t.Errorf("oversized receipt: status = %d, want 413", huge.StatusCode)
+	}
+
+	// A frontend stuck in a loop is cut off once its burst is used up
+	for i := 0; ; i++ {
+		resp := a.Get("/print/receipt")
+		if resp.StatusCode == 429 {
+			if resp.Header.Get("Retry-After") == "" {
+				t.Error("429 without Retry-After")
+			}
+			break
+		}
+		if i == 25 {
+			t.Fatal("never rate limited")
+		}
+	}
+	if len(a.printer.Jobs()) != 0 {
+		t.Errorf("printed %d jobs", len(a.printer.Jobs()))
+	}
+}
+
+func TestScanLinkedReceipt(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, bcSwipe)
+
+	scan := a.PostJSON("/scanner/scan", "")
+	if scan.StatusCode != 200 {
+		t.Fatalf("scan status = %d, body %s", scan.StatusCode, scan.Body)
+	}
+	scanID, _ := scan.JSON(t)["scanId"].(string)
+	if scanID == "" {
+		t.Fatalf("scan response has no scanId: %s", scan.Body)
+	}
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1004",
+		"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 50.00}},
+		"subtotal":      50.00,
+		"tax":           6.00,
+		"total":         56.00,
+		"paymentType":   "credit",
+		"location":      "Main Street",
+		"scanId":        scanID,
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	if !strings.Contains(html, "JANE D.") || !strings.Contains(html, "***4567") {
+		t.Error("receipt is missing the verified customer")
+	}
+	if strings.Contains(html, "1234567") || strings.Contains(html, "DOE") {
+		t.Error("receipt shows the unmasked licence")
+	}
+
+	// The agreement is filled in from the same scan
+	agreement := a.PostJSON("/print/agreement", map[string]interface{}{
+		"agreementNumber": "RA-2003",
+		"transactionId":   "TXN-1004",
+		"items":           []map[string]interface{}{{"name": "Canoe", "quantity": 1, "rate": 50.00}},
+		"scanId":          scanID,
+	})
+	if agreement.StatusCode != 200 {
+		t.Fatalf("agreement status = %d, body %s", agreement.StatusCode, agreement.Body)
+	}
+	if html := a.printer.Jobs()[1].HTML; !strings.Contains(html, "VANCOUVER") || strings.Contains(html, "1234567") {
+		t.Error("agreement does not carry the masked scanned customer")
+	}
+
+	links := a.Get("/transactions/scans?transactionId=TXN-1004")
+	if links.StatusCode != 200 {
+		t.Fatalf("links status = %d, body %s", links.StatusCode, links.Body)
+	}
+	recorded, _ := links.JSON(t)["links"].([]interface{})
+	if len(recorded) != 2 {
+		t.Fatalf("recorded %d links, want 2: %s", len(recorded), links.Body)
+	}
+	first := recorded[0].(map[string]interface{})
+	if first["document"] != "receipt" || first["scanId"] != scanID || first["licenseNumber"] != "***4567" {
+		t.Errorf("receipt link = %v", first)
+	}
+
+	stale := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1005",
+		"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 50.00}},
+		"total":         56.00,
+		"scanId":        "scan-unknown",
+	})
+	if stale.StatusCode != 400 {
+		t.Errorf("unknown scan: status = %d, want 400", stale.StatusCode)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, bcSwipe)
+
+	scanID, _ := a.PostJSON("/scanner/scan", "").JSON(t)["scanId"].(string)
+	a.scanner.SetResponse("")
+	a.PostJSON("/scanner/scan", "")
+	for _, txID := range []string{"TXN-2001", "TXN-2002"} {
+		resp := a.PostJSON("/print/receipt", map[string]interface{}{
+			"transactionId": txID,
+			"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 50.00}},
+			"total":         50.00,
+			"location":      "Main Street",
+		})
+		if resp.StatusCode != 200 {
+			t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+
+	scans := a.Get("/history/scans").JSON(t)
+	entries, _ := scans["entries"].([]interface{})
+	if scans["total"] != 2.0 || len(entries) != 2 {
+		t.Fatalf("scan history = %v", scans)
+	}
+	// Newest first: the empty swipe, then the licence
+	failed, scanned := entries[0].(map[string]interface{}), entries[1].(map[string]interface{})
+	if failed["status"] != "failed" || failed["error"] == nil {
+		t.Errorf("failed scan entry = %v", failed)
+	}
+	if scanned["status"] != "success" || scanned["scanId"] != scanID || scanned["licenseNumber"] != "***4567" {
+		t.Errorf("licence scan entry = %v", scanned)
+	}
+
+	prints := a.Get("/history/prints?limit=1&offset=1").JSON(t)
+	entries, _ = prints["entries"].([]interface{})
+	if prints["total"] != 2.0 || len(entries) != 1 {
+		t.Fatalf("print history = %v", prints)
+	}
+	if entry := entries[0].(map[string]interface{}); entry["transactionId"] != "TXN-2001" || entry["document"] != "receipt" || entry["printed"] != 1.0 {
+		t.Errorf("print entry = %v", entry)
+	}
+
+	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+	if future := a.Get("/history/prints?from=" + tomorrow).JSON(t); future["total"] != 0.0 {
+		t.Errorf("from %s: total = %v, want 0", tomorrow, future["total"])
+	}
+	if bad := a.Get("/history/prints?to=yesterday"); bad.StatusCode != 400 {
+		t.Errorf("bad date: status = %d, want 400", bad.StatusCode)
+	}
+
+	// The journal is a SQLite database, kept across restarts
+	dbPath := filepath.Join(a.appDir, "journal", "journal.db")
+	if header, err := os.ReadFile(dbPath); err != nil || !bytes.HasPrefix(header, []byte("SQLite format 3\x00")) {
+		t.Fatalf("%s is not a SQLite database: %v", dbPath, err)
+	}
+	eventJournal.Close()
+	var err error
+	if eventJournal, err = journal.Open(filepath.Join(a.appDir, "journal")); err != nil {
+		t.Fatalf("reopening journal: %v", err)
+	}
+	if reopened := a.Get("/history/prints").JSON(t); reopened["total"] != 2.0 {
+		t.Errorf("after reopening: print total = %v, want 2", reopened["total"])
+	}
+}
+
+func TestHistoryDrawerAndErrors(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	if resp := a.PostJSON("/print/receipt", map[string]interface{}{"type": "noSale", "paymentType": "cash", "location": "Main Street"}); resp.StatusCode != 200 {
+		t.Fatalf("no-sale status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	drawer := a.Get("/history/drawer").JSON(t)
+	entries, _ := drawer["entries"].([]interface{})
+	if drawer["total"] != 1.0 || len(entries) != 1 {
+		t.Fatalf("drawer history = %v", drawer)
+	}
+	if entry := entries[0].(map[string]interface{}); entry["kind"] != "drawer" || entry["status"] != "success" || entry["requestId"] == nil {
+		t.Errorf("drawer entry = %v", entry)
+	}
+
+	req, _ := http.NewRequest("POST", a.URL()+"/print/receipt", strings.NewReader("{not json"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Operator-Id", "emp-17")
+	if resp := a.Send(req); resp.StatusCode != 400 {
+		t.Fatalf("bad receipt status = %d, want 400", resp.StatusCode)
+	}
+	errs := a.Get("/history/errors").JSON(t)
+	entries, _ = errs["entries"].([]interface{})
+	if errs["total"] != 1.0 || len(entries) != 1 {
+		t.Fatalf("error history = %v", errs)
+	}
+	entry := entries[0].(map[string]interface{})
+	if entry["kind"] != "error" || entry["status"] != "failed" || entry["request"] != "POST /print/receipt" ||
+		entry["httpStatus"] != 400.0 || entry["operator"] != "emp-17" || entry["error"] == nil {
+		t.Errorf("error entry = %v", entry)
+	}
+	// Successful requests, and the sale itself, are not errors
+	if prints := a.Get("/history/prints").JSON(t); prints["total"] != 1.0 {
+		t.Errorf("print total = %v, want 1", prints["total"])
+	}
+}
+
+func TestRequestTracing(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	post := func(path, requestID string, body interface{}) testharness.Response {
+		t.Helper()
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest("POST", a.URL()+path, bytes.NewReader(data))
+		req.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		return a.Send(req)
+	}
+
+	// The frontend's ID follows the print into the response, the logs and
+	// the journal
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1501",
+		"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 55.00}},
+		"subtotal":      55.00,
+		"tax":           6.60,
+		"total":         61.60,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	}
+	resp := post("/print/receipt", "till-7:0042", receipt)
+	if resp.StatusCode != 200 || resp.Header.Get("X-Request-ID") != "till-7:0042" {
+		t.Fatalf("status = %d, X-Request-ID = %q", resp.StatusCode, resp.Header.Get("X-Request-ID"))
+	}
+	entries, _ := a.Get("/history/prints?requestId=till-7:0042").JSON(t)["entries"].([]interface{})
+	if len(entries) != 1 || entries[0].(map[string]interface{})["transactionId"] != "TXN-1501" {
+		t.Errorf("journal entries of the request = %v", entries)
+	}
+	if !strings.Contains(logs.String(), "[till-7:0042] POST /print/receipt 200") || !strings.Contains(logs.String(), "[till-7:0042] Successfully printed receipt") ||
+		!strings.Contains(logs.String(), "[till-7:0042] PDF generated") {
+		t.Errorf("request ID missing from the logs:\n%s", logs.String())
+	}
+
+	// Without one, or with one unsafe to log, the agent makes one up, and
+	// an error quotes it
+	for _, given := range []string{"", "forged [admin] %s id", strings.Repeat("x", 65)} {
+		resp := post("/print/receipt", given, map[string]interface{}{"items": "not a list"})
+		id := resp.Header.Get("X-Request-ID")
+		if !regexp.MustCompile(`^req-[0-9a-f]{16}$`).MatchString(id) {
+			t.Errorf("request ID for %q = %q", given, id)
+		}
+		if body := resp.JSON(t); resp.StatusCode != 400 || body["requestId"] != id {
+			t.Errorf("error response: status %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+	if strings.Contains(logs.String(), "forged") {
+		t.Error("an unsafe request ID reached the logs")
+	}
+
+	// Browsers may read the header across origins
+	if exposed := a.Get("/v1/status").Header.Get("Access-Control-Expose-Headers"); !strings.Contains(exposed, "X-Request-ID") {
+		t.Errorf("Access-Control-Expose-Headers = %q", exposed)
+	}
+}
+
+func TestPayloadLogging(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "HELLO WORLD D9876543")
+	t.Cleanup(func() {
+		debugPayloads.Store(false)
+		debugScans.Store(false)
+	})
+
+	setDebugPayloads := func(on bool) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", a.URL()+"/v1/admin/config", strings.NewReader(fmt.Sprintf(`{"debugPayloads": %v}`, on)))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		if resp := a.Send(req); resp.StatusCode != 200 || resp.JSON(t)["debugPayloads"] != on {
+			t.Fatalf("switching payload logging: status %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+	receipt := func(transactionID string) map[string]interface{} {
+		return map[string]interface{}{
+			"transactionId": transactionID,
+			"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 36.00}},
+			"subtotal":      36.00,
+			"total":         36.00,
+			"location":      "Main Street",
+			"payments": []map[string]interface{}{
+				{"type": "credit", "amount": 36.00, "cardDetails": map[string]string{"cardBrand": "visa", "cardLast4": "4242", "authCode": "A1B2C3"}},
+			},
+		}
+	}
+	readLog := func() string {
+		data, _ := os.ReadFile(filepath.Join(a.appDir, "logs", "payloads.jsonl"))
+		return string(data)
+	}
+
+	// Off by default
+	a.PostJSON("/v1/print/receipt", receipt("TXN-1601"))
+	if logged := readLog(); logged != "" {
+		t.Fatalf("payloads logged while switched off:\n%s", logged)
+	}
+
+	setDebugPayloads(true)
+	resp := a.PostJSON("/v1/print/receipt", receipt("TXN-1602"))
+	if resp.StatusCode != 200 {
+		t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	var printed payloadlog.Exchange
+	for _, line := range strings.Split(strings.TrimSpace(readLog()), "\n") {
+		var exchange payloadlog.Exchange
+		if err := json.Unmarshal([]byte(line), &exchange); err != nil {
+			t.Fatalf("log line is not JSON: %s", line)
+		}
+		if exchange.Path == "/v1/print/receipt" {
+			printed = exchange
+		}
+	}
+	if printed.RequestID != resp.Header.Get("X-Request-ID") || printed.Status != 200 || printed.Method != "POST" {
+		t.Errorf("logged exchange = %+v", printed)
+	}
+	if !strings.Contains(printed.Request, "TXN-1602") || printed.Response == "" {
+		t.Errorf("bodies missing from the log: %+v", printed)
+	}
+	if logged := readLog(); strings.Contains(logged, "4242") || strings.Contains(logged, "A1B2C3") || !strings.Contains(logged, "[redacted]") {
+		t.Errorf("card details not redacted:\n%s", logged)
+	}
+
+	// With -debug-scans, an unrecognised scan is returned raw and in hex;
+	// neither is logged
+	debugScans.Store(true)
+	if raw := a.PostJSON("/v1/scanner/scan", "").JSON(t)["rawResponse"]; raw != "HELLO WORLD D9876543" {
+		t.Fatalf("rawResponse = %v", raw)
+	}
+	if logged := readLog(); strings.Contains(logged, "D9876543") || strings.Contains(logged, hex.EncodeToString([]byte("D9876543"))) {
+		t.Errorf("raw scan not redacted:\n%s", logged)
+	}
+
+	setDebugPayloads(false)
+	before := readLog()
+	a.PostJSON("/v1/print/receipt", receipt("TXN-1603"))
+	if readLog() != before {
+		t.Error("payloads logged after switching off")
+	}
+}
+
+func TestOperatorAndStation(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, bcSwipe)
+
+	a.PostJSON("/scanner/scan?operatorName=Alice&stationId=Till+2", "")
+	for _, receipt := range []map[string]interface{}{
+		{"transactionId": "TXN-2001", "total": 50.00, "location": "Main Street", "operatorName": "Alice", "stationId": "Till 2"},
+		{"transactionId": "TXN-2002", "total": 20.00, "location": "Main Street", "operatorName": "Bob", "stationId": "Till 1"},
+		{"transactionId": "TXN-2003", "type": "refund", "refundAmount": 20.00, "location": "Main Street", "operatorName": "Bob", "stationId": "Till 1"},
+	} {
+		if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+			t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"Served by: Alice", "Station: Till 2"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+
+	scans := a.Get("/history/scans").JSON(t)
+	entries, _ := scans["entries"].([]interface{})
+	if len(entries) != 1 {
+		t.Fatalf("scan history = %v", scans)
+	}
+	if scan := entries[0].(map[string]interface{}); scan["operator"] != "Alice" || scan["station"] != "Till 2" {
+		t.Errorf("scan entry = %v", scan)
+	}
+
+	till1 := a.Get("/history/prints?station=Till+1").JSON(t)
+	entries, _ = till1["entries"].([]interface{})
+	if till1["total"] != 2.0 || len(entries) != 2 {
+		t.Fatalf("Till 1 prints = %v", till1)
+	}
+	for _, e := range entries {
+		if entry := e.(map[string]interface{}); entry["operator"] != "Bob" {
+			t.Errorf("Till 1 print entry = %v", entry)
+		}
+	}
+	if alice := a.Get("/history/prints?operator=Alice").JSON(t); alice["total"] != 1.0 {
+		t.Errorf("Alice's prints: total = %v, want 1", alice["total"])
+	}
+
+	// The refund is audited under the operator the receipt names
+	events, err := auditLog.Events()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("audit events = %v, %v", events, err)
+	}
+	if events[0].Operator != "Bob" || !strings.Contains(events[0].Detail, "station Till 1") {
+		t.Errorf("refund audit event = %+v", events[0])
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, bcSwipe)
+
+	const secret = "s3cret"
+	events := make(chan webhook.Event, 10)
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + webhook.Sign(secret, r.Header.Get("X-Webhook-Timestamp"), body)
+		if r.Header.Get("X-Webhook-Signature") != want {
+			t.Errorf("bad signature on %s", r.Header.Get("X-Webhook-Event"))
+		}
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable) // The first delivery is retried
+			return
+		}
+		var event webhook.Event
+		json.Unmarshal(body, &event)
+		events <- event
+	}))
+	defer receiver.Close()
+
+	origWebhooks := webhooks
+	webhooks = webhook.New(webhook.Config{
+		Station:   "station-1",
+		Endpoints: []webhook.Endpoint{{URL: receiver.URL, Secret: secret}},
+		Backoff:   "10ms",
+	})
+	t.Cleanup(func() { webhooks.Close(context.Background()); webhooks = origWebhooks })
+
+	next := func() webhook.Event {
+		t.Helper()
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("no webhook delivered")
+			return webhook.Event{}
+		}
+	}
+
+	a.PostJSON("/scanner/scan", "")
+	scan := next()
+	data, _ := scan.Data.(map[string]interface{})
+	if scan.Type != webhook.ScanCompleted || scan.Station != "station-1" || data["licenseNumber"] != "***4567" {
+		t.Errorf("scan event = %+v", scan)
+	}
+
+	a.printer.Fail("lp")
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-3001",
+		"items":         []map[string]interface{}{{"name": "Canoe", "quantity": 1, "price": 50.00}},
+		"total":         50.00,
+		"location":      "Main Street",
+	})
+	if resp.StatusCode != 500 {
+		t.Fatalf("print status = %d, want 500", resp.StatusCode)
+	}
+	if failed := next(); failed.Type != webhook.PrintFailed {
+		t.Errorf("first print event = %s, want %s", failed.Type, webhook.PrintFailed)
+	}
+	if offline := next(); offline.Type != webhook.PrinterOffline {
+		t.Errorf("second print event = %s, want %s", offline.Type, webhook.PrinterOffline)
+	}
+}
+
+func TestWebhookEndpointsDeliverIndependently(t *testing.T) {
+	// One receiver hangs on every delivery; the other must still get its
+	// events straight away
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer stuck.Close()
+	events := make(chan webhook.Event, 10)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		events <- event
+	}))
+	defer healthy.Close()
+
+	d := webhook.New(webhook.Config{
+		Endpoints: []webhook.Endpoint{{URL: stuck.URL}, {URL: healthy.URL}},
+		Attempts:  1,
+	})
+	defer d.Close(context.Background())
+	defer close(release)
+
+	d.Send(webhook.PrinterOffline, nil)
+	d.Send(webhook.PrinterOnline, nil)
+	for _, want := range []string{webhook.PrinterOffline, webhook.PrinterOnline} {
+		select {
+		case event := <-events:
+			if event.Type != want {
+				t.Errorf("event = %s, want %s", event.Type, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s was held up by the stuck endpoint", want)
+		}
+	}
+}
+
+func TestWebhookCloseDeadline(t *testing.T) {
+	var posts atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	// Minutes of retries are queued for a receiver that is down
+	d := webhook.New(webhook.Config{
+		Endpoints: []webhook.Endpoint{{URL: down.URL}},
+		Attempts:  5,
+		Backoff:   "1m",
+	})
+	for i := 0; i < 3; i++ {
+		d.Send(webhook.PrintFailed, nil)
+	}
+	for deadline := time.Now().Add(2 * time.Second); posts.Load() == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := d.Close(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v past its deadline", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "3 webhook deliveries dropped") {
+		t.Errorf("Close error = %v", err)
+	}
+	d.Send(webhook.PrintFailed, nil) // Dropped, not a panic
+}
+
+func TestMQTTPublishing(t *testing.T) {
+	a := startAgent(t, bcSwipe)
+	broker := testharness.NewMQTTBroker(t)
+
+	origPublisher, origTopic := mqttPublisher, mqttTopic
+	startMQTT(mqtt.Options{Broker: "tcp://" + broker.Addr(), ClientID: "goscan-station-1"}, "rentaltide/station-1")
+	t.Cleanup(func() { mqttPublisher.Close(); mqttPublisher, mqttTopic = origPublisher, origTopic })
+
+	status := broker.WaitForMessage(t, "rentaltide/station-1/status", 2*time.Second)
+	if string(status.Payload) != "online" || !status.Retain {
+		t.Errorf("status message = %q (retain %v), want retained online", status.Payload, status.Retain)
+	}
+
+	a.PostJSON("/scanner/scan", "")
+	msg := broker.WaitForMessage(t, "rentaltide/station-1/scan", 2*time.Second)
+	var entry journal.Entry
+	if err := json.Unmarshal(msg.Payload, &entry); err != nil {
+		t.Fatalf("scan message is not JSON: %s", msg.Payload)
+	}
+	if entry.Status != "success" || entry.LicenseNumber != "***4567" || entry.Time.IsZero() {
+		t.Errorf("scan message = %+v", entry)
+	}
+	if clients := broker.Clients(); len(clients) != 1 || clients[0] != "goscan-station-1" {
+		t.Errorf("clients = %v", clients)
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	a := startAgent(t, "")
+	origRestart := restartAgent
+	restarted := make(chan struct{}, 1)
+	restartAgent = func() { restarted <- struct{}{} }
+	t.Cleanup(func() {
+		restartAgent = origRestart
+		privacyMode.Store(false)
+	})
+
+	admin := func(method, path, token string, body string) testharness.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, a.URL()+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return a.Send(req)
+	}
+
+	if resp := admin("GET", "/admin/version", "", ""); resp.StatusCode != 401 {
+		t.Errorf("no token: status = %d, want 401", resp.StatusCode)
+	}
+	if resp := admin("GET", "/admin/version", "wrong-token-0123456789", ""); resp.StatusCode != 401 {
+		t.Errorf("wrong token: status = %d, want 401", resp.StatusCode)
+	}
+	version := admin("GET", "/admin/version", testAdminToken, "")
+	if body := version.JSON(t); version.StatusCode != 200 || body["version"] != agentVersion || body["os"] != runtime.GOOS {
+		t.Errorf("version: status %d, body %s", version.StatusCode, version.Body)
+	}
+
+	config := admin("GET", "/admin/config", testAdminToken, "").JSON(t)
+	if config["printer"] != "Receipt_Printer" || config["privacy"] != false {
+		t.Errorf("config = %v", config)
+	}
+	updated := admin("POST", "/admin/config", testAdminToken, `{"privacy": true}`).JSON(t)
+	if updated["privacy"] != true || !privacyMode.Load() {
+		t.Errorf("privacy was not switched on: %v", updated)
+	}
+
+	logs := filepath.Join(a.appDir, "logs")
+	os.MkdirAll(logs, 0755)
+	var logText strings.Builder
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(&logText, "line %d\n", i)
+	}
+	os.WriteFile(filepath.Join(logs, "goscantide-2026-01-02.log"), []byte(logText.String()), 0644)
+	tail := admin("GET", "/admin/logs/tail?date=2026-01-02&lines=2", testAdminToken, "")
+	if lines, _ := tail.JSON(t)["lines"].([]interface{}); len(lines) != 2 || lines[0] != "line 4" || lines[1] != "line 5" {
+		t.Errorf("tail: status %d, body %s", tail.StatusCode, tail.Body)
+	}
+	if resp := admin("GET", "/admin/logs/tail?date=2026-01-03", testAdminToken, ""); resp.StatusCode != 404 {
+		t.Errorf("missing log: status = %d, want 404", resp.StatusCode)
+	}
+
+	if resp := admin("POST", "/admin/restart", testAdminToken, ""); resp.StatusCode != 202 {
+		t.Fatalf("restart: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	select {
+	case <-restarted:
+	case <-time.After(time.Second):
+		t.Error("restart was not triggered")
+	}
+}
+
+func TestAdminBenchmark(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	benchmark := func(body string) testharness.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", a.URL()+"/admin/benchmark", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		return a.Send(req)
+	}
+
+	// Rendering alone prints nothing
+	resp := benchmark("")
+	var result BenchmarkResponse
+	if err := json.Unmarshal(resp.Body, &result); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if result.Count != 10 || len(result.Results) != 1 || result.Results[0].Backend != outputPDF || result.Results[0].Succeeded != 10 || result.Results[0].Print != nil {
+		t.Errorf("default benchmark = %+v", result)
+	}
+	if render := result.Results[0].Render; render.Max <= 0 || render.P95 < render.P50 || render.Max < render.P95 {
+		t.Errorf("render latency = %+v", render)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 0 {
+		t.Errorf("benchmark without print printed %d jobs", len(jobs))
+	}
+
+	resp = benchmark(`{"count": 3, "backends": ["pdf", "thermal", "html"], "print": true}`)
+	result = BenchmarkResponse{}
+	if err := json.Unmarshal(resp.Body, &result); err != nil || resp.StatusCode != 200 || len(result.Results) != 3 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	for _, backend := range result.Results {
+		if backend.Succeeded != 3 || backend.Failed != 0 || backend.ReceiptsPerMinute <= 0 {
+			t.Errorf("%s: %+v", backend.Backend, backend)
+		}
+		if (backend.Print == nil) != (backend.Backend == outputHTML) {
+			t.Errorf("%s: print latency = %v", backend.Backend, backend.Print)
+		}
+	}
+	var pdfs, raw int
+	for _, job := range a.printer.Jobs() {
+		if job.Printer != "Receipt_Printer" {
+			t.Errorf("benchmark printed to %q", job.Printer)
+		}
+		if job.Raw != nil {
+			raw++
+		} else if strings.Contains(job.HTML, "Mountain bike") {
+			pdfs++
+		}
+	}
+	if pdfs != 3 || raw != 3 {
+		t.Errorf("printed %d PDFs and %d raw receipts, want 3 of each", pdfs, raw)
+	}
+
+	// A failing renderer is reported, not fatal
+	a.printer.Fail("chrome")
+	resp = benchmark(`{"count": 2, "renderer": "chrome"}`)
+	result = BenchmarkResponse{}
+	if json.Unmarshal(resp.Body, &result); resp.StatusCode != 200 || result.Results[0].Failed != 2 || result.Results[0].Error == "" {
+		t.Errorf("failing renderer: status %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	for _, body := range []string{`{"count": 500}`, `{"backends": ["fax"]}`, `{"renderer": "escpos", "backends": ["pdf"]}`} {
+		if resp := benchmark(body); resp.StatusCode != 400 {
+			t.Errorf("%s: status = %d, want 400", body, resp.StatusCode)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	printAs := func(receipt map[string]interface{}, operator string) {
+		t.Helper()
+		body, _ := json.Marshal(receipt)
+		req, _ := http.NewRequest("POST", a.URL()+"/print/receipt", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Operator-Id", operator)
+		if resp := a.Send(req); resp.StatusCode != 200 {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+	}
+	auditTrail := func() AuditResponse {
+		t.Helper()
+		req, _ := http.NewRequest("GET", a.URL()+"/admin/audit", nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		resp := a.Send(req)
+		var trail AuditResponse
+		if err := json.Unmarshal([]byte(resp.Body), &trail); err != nil || resp.StatusCode != 200 {
+			t.Fatalf("audit: status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+		return trail
+	}
+
+	sale := map[string]interface{}{
+		"transactionId": "TXN-1401",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	}
+	printAs(sale, "emp-17")
+	printAs(map[string]interface{}{"type": "noSale", "paymentType": "cash", "location": "Main Street"}, "emp-17")
+	refund := map[string]interface{}{}
+	for k, v := range sale {
+		refund[k] = v
+	}
+	refund["type"], refund["refundAmount"], refund["refundMethod"] = "refund", 25.00, "cash"
+	printAs(refund, "emp-22")
+
+	// The sale isn't audited; the no-sale and refund are, chained
+	trail := auditTrail()
+	if !trail.Valid || len(trail.Events) != 2 {
+		t.Fatalf("audit = %+v", trail)
+	}
+	noSale, refunded := trail.Events[0], trail.Events[1]
+	if noSale.Action != audit.NoSale || noSale.Operator != "emp-17" || noSale.Seq != 1 || noSale.Prev != "" {
+		t.Errorf("no-sale = %+v", noSale)
+	}
+	if refunded.Action != audit.Refund || refunded.Operator != "emp-22" || refunded.Amount != 2500 ||
+		refunded.TransactionID != "TXN-1401" || refunded.Prev != noSale.Hash || !strings.Contains(refunded.Detail, "refunded to cash") {
+		t.Errorf("refund = %+v", refunded)
+	}
+
+	// Lowering the refund in the file breaks the chain
+	path := filepath.Join(a.appDir, "audit", "agent.jsonl")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(path, bytes.Replace(data, []byte(`"amount":25`), []byte(`"amount":5`), 1), 0644)
+	if trail := auditTrail(); trail.Valid || !strings.Contains(trail.Problem, "event 2") {
+		t.Errorf("tampered audit = valid %v, problem %q", trail.Valid, trail.Problem)
+	}
+
+	// Without an audit log, no-sales are refused rather than opening the
+	// drawer unrecorded
+	auditLog = nil
+	jobs := len(a.printer.Jobs())
+	if resp := a.PostJSON("/print/receipt", map[string]interface{}{"type": "noSale", "paymentType": "cash", "location": "Main Street"}); resp.StatusCode != 503 {
+		t.Errorf("unaudited no-sale: status = %d, want 503", resp.StatusCode)
+	}
+	if len(a.printer.Jobs()) != jobs {
+		t.Error("an unaudited no-sale printed")
+	}
+}
+
+func TestDeviceSummary(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	opts := agentOptions{PortOverride: "COM4", PrinterName: "Receipt_Printer"}
+
+	if err := printTestPage(context.Background(), opts); err != nil {
+		t.Fatalf("test page: %v", err)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 1 || !strings.Contains(jobs[0].HTML, "Test print") {
+		t.Fatalf("test page jobs = %v", jobs)
+	}
+	want := "Scanner: COM4 | Printer: Receipt_Printer online | Agreements: default online"
+	if got := strings.Join(deviceSummary(opts), " | "); got != want {
+		t.Errorf("summary = %q, want %q", got, want)
+	}
+
+	a.printer.Fail("lp")
+	if err := printTestPage(context.Background(), opts); err == nil {
+		t.Fatal("test page printed with lp failing")
+	}
+	if summary := deviceSummary(opts); summary[1] != "Printer: Receipt_Printer offline" {
+		t.Errorf("summary after failure = %v", summary)
+	}
+	if history := a.Get("/history/prints").JSON(t); history["total"] != 2.0 {
+		t.Errorf("test pages journaled = %v, want 2", history["total"])
+	}
+}
+
+func TestPrintCancelled(t *testing.T) {
+	a := startAgent(t, "")
+	a.printer.Hang("chrome")
+
+	// The browser hangs; the caller giving up kills it rather than leave
+	// it running
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := printTestPage(ctx, agentOptions{PrinterName: "Receipt_Printer"})
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled print took %v", elapsed)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 0 {
+		t.Errorf("cancelled print delivered %v", jobs)
+	}
+}
+
+func TestRenderTimeout(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	a.printer.Hang("chrome")
+	origTimeout := renderTimeout
+	t.Cleanup(func() { renderTimeout = origTimeout })
+	renderTimeout = 200 * time.Millisecond
+
+	// Chrome stuck on the page is killed at the timeout, and the next
+	// browser renders the document
+	start := time.Now()
+	if err := printTestPage(context.Background(), agentOptions{PrinterName: "Receipt_Printer"}); err != nil {
+		t.Fatalf("print with chrome hanging: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("print took %v", elapsed)
+	}
+	jobs := a.printer.Jobs()
+	if len(jobs) != 1 || !strings.Contains(jobs[0].HTML, "Test print") {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	var browsers []string
+	for _, command := range a.printer.Commands() {
+		if command[0] != "lp" {
+			browsers = append(browsers, command[0])
+		}
+	}
+	if !slices.Equal(browsers, []string{"chrome", "google-chrome"}) {
+		t.Errorf("browsers run = %v", browsers)
+	}
+
+	// With the only renderer allowed hanging, the print fails in time
+	a.printer.Fail("google-chrome")
+	a.printer.Fail("chromium-browser")
+	err := printTestPage(context.Background(), agentOptions{PrinterName: "Receipt_Printer"})
+	if err == nil || !strings.Contains(err.Error(), "no compatible browser") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestWarmRenderer(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	devtools := testharness.NewDevTools(t)
+	origWarm, origLaunch, origLookPath := warmRenderer, launchWarmBrowser, lookPath
+	t.Cleanup(func() {
+		closeWarmBrowsers()
+		warmRenderer, launchWarmBrowser, lookPath = origWarm, origLaunch, origLookPath
+	})
+	warmRenderer = true
+	launches := 0
+	launchWarmBrowser = func(ctx context.Context, command string) (*cdp.Browser, error) {
+		launches++
+		return cdp.Connect(ctx, devtools.URL())
+	}
+	lookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+
+	// Both prints go through tabs of the one browser; none is started
+	for i := 0; i < 2; i++ {
+		if err := printTestPage(context.Background(), agentOptions{PrinterName: "Receipt_Printer"}); err != nil {
+			t.Fatalf("print %d: %v", i+1, err)
+		}
+	}
+	if devtools.Renders() != 2 || launches != 1 {
+		t.Errorf("renders = %d, launches = %d", devtools.Renders(), launches)
+	}
+	for _, command := range a.printer.Commands() {
+		if command[0] != "lp" {
+			t.Errorf("ran %v with the warm renderer", command)
+		}
+	}
+	jobs := a.printer.Jobs()
+	if len(jobs) != 2 || !strings.Contains(jobs[1].HTML, "Test print") {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+
+	// Once the browser is gone, the document is rendered the cold way
+	devtools.Close()
+	if err := printTestPage(context.Background(), agentOptions{PrinterName: "Receipt_Printer"}); err != nil {
+		t.Fatalf("print after the browser exited: %v", err)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 3 || !strings.Contains(jobs[2].HTML, "Test print") {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	if commands := a.printer.Commands(); commands[len(commands)-2][0] != "chrome" {
+		t.Errorf("commands = %v", commands)
+	}
+}
+
+// TestWarmRendererLaunch starts the warm browser the way the agent does,
+// with a script standing in for Chrome that announces the emulator
+func TestWarmRendererLaunch(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("the fake browser is a shell script")
+	}
+	devtools := testharness.NewDevTools(t)
+	dir := t.TempDir()
+	browser := filepath.Join(dir, "fake-chrome")
+	script := "#!/bin/sh\necho \"DevTools listening on " + devtools.URL() + "\" >&2\nexec sleep 60\n"
+	if err := os.WriteFile(browser, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	htmlPath := filepath.Join(dir, "receipt.html")
+	os.WriteFile(htmlPath, []byte("<p>Warm</p>"), 0644)
+	t.Cleanup(closeWarmBrowsers)
+
+	err := renderWarm(context.Background(), pdfBrowser{label: "Fake Chrome", command: browser}, htmlPath, filepath.Join(dir, "receipt.pdf"))
+	if err != nil {
+		t.Fatalf("rendering with the launched browser: %v", err)
+	}
+	if devtools.Renders() != 1 {
+		t.Errorf("renders = %d, want 1", devtools.Renders())
+	}
+	warmBrowserMu.Lock()
+	process := warmBrowsers[browser].Process()
+	warmBrowserMu.Unlock()
+
+	// Shutting down leaves no browser behind
+	closeWarmBrowsers()
+	if err := process.Signal(syscall.Signal(0)); err == nil {
+		t.Error("the warm browser is still running after closeWarmBrowsers")
+	}
+}
+
+func TestSetup(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	configPath := filepath.Join(a.appDir, "config.json")
+	os.WriteFile(configPath, []byte(`{"http-port": 4000}`), 0644)
+
+	// COM4, Office_Printer, print a test page, it printed, default
+	// agreement printer, save
+	answers := "1\n2\ny\ny\n\n\n"
+	var out bytes.Buffer
+	if err := runSetup(strings.NewReader(answers), &out, configPath); err != nil {
+		t.Fatalf("setup: %v\n%s", err, out.String())
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 1 || jobs[0].Printer != "Office_Printer" {
+		t.Errorf("test page jobs = %+v", jobs)
+	}
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	port := fs.String("port", "COM1", "")
+	printer := fs.String("printer", "Receipt1", "")
+	agreementPrinter := fs.String("agreement-printer", "x", "")
+	httpPort := fs.Int("http-port", 3500, "")
+	fs.Parse([]string{"-printer", "Cmdline_Printer"})
+	if err := applyConfigFile(configPath, fs); err != nil {
+		t.Fatalf("applying config: %v", err)
+	}
+	if *port != "COM4" || *agreementPrinter != "" || *httpPort != 4000 {
+		t.Errorf("config applied port=%q agreement-printer=%q http-port=%d", *port, *agreementPrinter, *httpPort)
+	}
+	if *printer != "Cmdline_Printer" {
+		t.Errorf("printer = %q, want the command-line value", *printer)
+	}
+
+	os.WriteFile(configPath, []byte(`{"no-such-flag": 1}`), 0644)
+	if err := applyConfigFile(configPath, fs); err == nil {
+		t.Error("unknown setting was accepted")
+	}
+}
+
+func TestPrinterAliasesAndFallback(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("the printer list is emulated through lpstat")
+	}
+	a := startAgent(t, "")
+	saved := printerAliases
+	t.Cleanup(func() { printerAliases = saved })
+
+	// The config file gives the aliases to their flag as JSON
+	configPath := filepath.Join(a.appDir, "config.json")
+	os.WriteFile(configPath, []byte(`{"printer": "receipt", "printers": {"receipt": {"windowsName": "EPSON TM-T88V Receipt", "cupsName": "Receipt_Printer"}}}`), 0644)
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	printer := fs.String("printer", "Receipt1", "")
+	fs.Func("printers", "", func(value string) error {
+		aliases, err := parsePrinterAliases(value)
+		printerAliases = aliases
+		return err
+	})
+	if err := applyConfigFile(configPath, fs); err != nil {
+		t.Fatalf("applying config: %v", err)
+	}
+
+	if name, fellBack := resolvePrinter(*printer); name != "Receipt_Printer" || fellBack {
+		t.Errorf("alias resolved to %q (fell back %v), want the CUPS name", name, fellBack)
+	}
+	if name, fellBack := resolvePrinter("Office_Printer"); name != "Office_Printer" || fellBack {
+		t.Errorf("installed printer resolved to %q (fell back %v)", name, fellBack)
+	}
+	if name, fellBack := resolvePrinter("Receipt1"); name != "" || !fellBack {
+		t.Errorf("missing printer resolved to %q (fell back %v), want the system default", name, fellBack)
+	}
+	if name, fellBack := resolvePrinter(""); name != "" || fellBack {
+		t.Errorf("system default resolved to %q (fell back %v)", name, fellBack)
+	}
+	if _, err := parsePrinterAliases(`{"receipt": {}}`); err == nil {
+		t.Error("an alias without names was accepted")
+	}
+
+	// Status and readiness say the receipts go to the default printer
+	opts := agentOptions{AppDir: a.appDir, PortOverride: "COM4", PrinterFallback: "Receipt1", AgreementPrinter: "Office_Printer"}
+	server := testharness.Start(t, setupRoutes(opts))
+	if resp := server.Get("/status"); resp.JSON(t)["printerFallback"] != true {
+		t.Errorf("status = %s, want printerFallback", resp.Body)
+	}
+	var ready ReadinessResponse
+	json.Unmarshal(server.Get("/readyz").Body, &ready)
+	if c := ready.Checks["printer"]; !c.OK || c.Detail != "default (in place of Receipt1, which is not installed)" {
+		t.Errorf("printer check = %+v", c)
+	}
+	if c := ready.Checks["agreementPrinter"]; !c.OK || c.Detail != "Office_Printer" {
+		t.Errorf("agreement printer check = %+v", c)
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	a := startAgent(t, "")
+	t.Cleanup(func() { simulatedPrintDir = "" })
+	if err := startSimulation("nosuchcard", 0, a.appDir, false); err == nil {
+		t.Error("unknown fixture was accepted")
+	}
+	if err := startSimulation(simulate.Cycle, 10*time.Millisecond, a.appDir, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each scan returns the next fixture: bc, ca, card, wa
+	for _, want := range []string{"BC", "CA", "generic", "WA"} {
+		resp := a.PostJSON("/scanner/scan", "")
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s scan status = %d, body %s", want, resp.StatusCode, resp.Body)
+		}
+		body := resp.JSON(t)
+		if want == "generic" {
+			if body["cardType"] != want {
+				t.Errorf("cardType = %v, want generic", body["cardType"])
+			}
+			continue
+		}
+		if license := body["licenseData"].(map[string]interface{}); license["state"] != want || license["licenseNumber"] == "" {
+			t.Errorf("license = %v, want one from %s", license, want)
+		}
+	}
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN/2001",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "credit",
+		"location":      "Main Street",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if cmds := a.printer.Commands(); len(cmds) != 0 {
+		t.Errorf("simulation ran commands %v", cmds)
+	}
+	files, _ := filepath.Glob(filepath.Join(a.appDir, "simulated-prints", "receipt-TXN_2001-*.html"))
+	if len(files) != 1 {
+		t.Fatalf("simulated prints = %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if !strings.HasPrefix(string(data), "<!-- printer: Receipt_Printer -->") || !strings.Contains(string(data), "Kayak") {
+		t.Errorf("simulated print = %.200s", data)
+	}
+}
+
+func TestScanReplay(t *testing.T) {
+	a := startAgent(t, "")
+
+	for name, req := range map[string]ScanReplayRequest{
+		"data":    {Data: aamvaScan},
+		"hex":     {Hex: hex.EncodeToString([]byte(bcSwipe))},
+		"fixture": {Fixture: "wa"},
+	} {
+		resp := a.PostJSON("/scanner/simulate", req)
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: status = %d, body %s", name, resp.StatusCode, resp.Body)
+		}
+		body := resp.JSON(t)
+		if body["status"] != "success" || body["scanId"] == nil || body["licenseData"].(map[string]interface{})["licenseNumber"] == "" {
+			t.Errorf("%s: body %s", name, resp.Body)
+		}
+	}
+	if card := a.PostJSON("/scanner/simulate", ScanReplayRequest{Fixture: "card"}).JSON(t); card["cardType"] != "generic" {
+		t.Errorf("card replay = %v", card)
+	}
+	if len(a.scanner.Opened()) != 0 {
+		t.Error("replay opened the scanner")
+	}
+	if history := a.Get("/history/scans").JSON(t); history["total"] != 0.0 {
+		t.Errorf("replays journaled = %v, want 0", history["total"])
+	}
+
+	for name, req := range map[string]ScanReplayRequest{
+		"nothing": {},
+		"both":    {Data: bcSwipe, Fixture: "bc"},
+		"bad hex": {Hex: "zz"},
+		"unknown": {Fixture: "xx"},
+	} {
+		if resp := a.PostJSON("/scanner/simulate", req); resp.StatusCode != 400 {
+			t.Errorf("%s: status = %d, want 400", name, resp.StatusCode)
+		}
+	}
+	if resp := a.PostJSON("/scanner/simulate", ScanReplayRequest{Fixture: "none"}); resp.StatusCode != 404 {
+		t.Errorf("empty fixture: status = %d, want 404 like an empty scan", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptTaxExemptItems(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1004",
+		"items": []map[string]interface{}{
+			{"name": "Kayak", "quantity": 1, "price": 40.00, "taxCode": "RENTAL"},
+			{"name": "Kayak Deposit", "quantity": 1, "price": 200.00, "taxExempt": true},
+		},
+		"subtotal":    240.00,
+		"tax":         4.80,
+		"total":       244.80,
+		"paymentType": "credit",
+		"location":    "Main Street",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"(Tax exempt)", "Tax code: RENTAL", "Taxable RENTAL (GST&#43;PST):", "$40.00 (Tax $4.80)", "Tax exempt:", "$200.00"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	if !strings.Contains(html, "$2.00") || !strings.Contains(html, "$2.80") {
+		t.Error("GST and PST were not charged on the rental alone")
+	}
+}
+
+func TestPrintReceiptItemTypes(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1005",
+		"items": []map[string]interface{}{
+			{"name": "Kayak", "quantity": 1, "price": 40.00, "itemType": "rental"},
+			{"name": "Damage Waiver", "quantity": 1, "price": 10.00, "itemType": "waiver"},
+			{"name": "Kayak Deposit", "quantity": 1, "price": 200.00, "itemType": "deposit"},
+		},
+		"subtotal":    250.00,
+		"tax":         6.00,
+		"total":       256.00,
+		"paymentType": "credit",
+		"location":    "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"Rental", "Damage waiver", "HELD", "Total Held:", "$200.00"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	// The deposit is held, not charged: it is left out of the items and tax
+	items, held, _ := strings.Cut(html, "HELD")
+	if strings.Contains(items, "Kayak Deposit") || !strings.Contains(held, "Kayak Deposit") {
+		t.Error("deposit is not printed under Held")
+	}
+	if !strings.Contains(html, "$2.50") || !strings.Contains(html, "$3.50") {
+		t.Error("GST and PST were not charged on the rental and waiver alone")
+	}
+
+	receipt["type"] = "refund"
+	receipt["transactionId"] = "TXN-1006"
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("refund status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html := a.printer.Jobs()[1].HTML; !strings.Contains(html, "DEPOSITS RETURNED") || !strings.Contains(html, "-$200.00") {
+		t.Error("refund receipt does not return the deposit")
+	}
+
+	receipt["items"] = []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00, "itemType": "lease"}}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("unknown item type: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptRentalPeriod(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1007",
+		"items": []map[string]interface{}{
+			{"name": "Kayak", "quantity": 2, "price": 40.00, "itemType": "rental",
+				"startDate": "2026-07-01 09:00", "dueDate": "2026-07-03 17:00", "rateUnit": "daily"},
+			{"name": "Paddle Board", "quantity": 1, "price": 15.00, "itemType": "rental",
+				"startDate": "2026-06-30", "dueDate": "2026-07-01", "rateUnit": "hourly"},
+		},
+		"subtotal":    95.00,
+		"tax":         11.40,
+		"total":       106.40,
+		"paymentType": "credit",
+		"location":    "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{
+		"$40.00 / day",
+		"$15.00 / hr",
+		"Out: 2026-07-01 09:00 Due back: 2026-07-03 17:00",
+		"Out: 2026-06-30 Due back: 2026-07-01",
+		"PLEASE RETURN BY 2026-07-01</div>", // The earliest due date
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+
+	receipt["type"] = "refund"
+	receipt["transactionId"] = "TXN-1008"
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("refund status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html := a.printer.Jobs()[1].HTML; strings.Contains(html, "PLEASE RETURN BY") {
+		t.Error("refund receipt carries the due-back banner")
+	}
+
+	for name, item := range map[string]map[string]interface{}{
+		"due before start": {"name": "Kayak", "quantity": 1, "price": 40.00, "startDate": "2026-07-03", "dueDate": "2026-07-01"},
+		"bad date":         {"name": "Kayak", "quantity": 1, "price": 40.00, "dueDate": "next Tuesday"},
+		"unknown unit":     {"name": "Kayak", "quantity": 1, "price": 40.00, "rateUnit": "fortnightly"},
+	} {
+		receipt["items"] = []map[string]interface{}{item}
+		if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+			t.Errorf("%s: status = %d, want 400", name, resp.StatusCode)
+		}
+	}
+}
+
+func TestPrintReceiptSplitPayment(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1009",
+		"items":         []map[string]interface{}{{"name": "Canoe Rental", "quantity": 1, "price": 50.00}},
+		"subtotal":      50.00,
+		"tax":           6.00,
+		"total":         56.00,
+		"paymentType":   "split",
+		"payments": []map[string]interface{}{
+			{"type": "cash", "amount": 20.00},
+			{"type": "credit", "amount": 36.00, "cardDetails": map[string]string{"cardBrand": "visa", "cardLast4": "4242", "authCode": "A1B2C3"}},
+		},
+		"location": "Main Street",
+	}
+	resp := a.PostJSON("/print/receipt", receipt)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if w, ok := resp.JSON(t)["warning"]; ok {
+		t.Errorf("unexpected totals warning %q", w)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"Cash:", "$20.00", "Credit:", "$36.00", "Visa **** 4242", "A1B2C3"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+
+	// Tenders that don't cover the total still print, with a warning
+	receipt["payments"] = []map[string]interface{}{{"type": "cash", "amount": 20.00}}
+	resp = a.PostJSON("/print/receipt", receipt)
+	if w, _ := resp.JSON(t)["warning"].(string); !strings.Contains(w, "payments add up to 20.00") {
+		t.Errorf("warning = %q", w)
+	}
+
+	receipt["payments"] = []map[string]interface{}{{"type": "cash", "amount": 0}}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("empty tender: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptMessages(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	saved := receiptMessages
+	t.Cleanup(func() { receiptMessages = saved })
+	receiptMessages = messages.Config{
+		Header: []messages.Block{{Title: "Free Wi-Fi", Text: "Network: RentalTide\nPassword: paddle123"}},
+		Footer: []messages.Block{{Title: "Tell us how we did", QR: "https://survey.example.com/r/1010"}},
+	}
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1010",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"Free Wi-Fi", "<div>Password: paddle123</div>", "Tell us how we did", "data:image/svg"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	if strings.Contains(html, "Thank you for your purchase") {
+		t.Error("the configured footer did not replace the thank-you lines")
+	}
+
+	// A receipt's own footer replaces the configured one
+	receipt["footerMessages"] = []map[string]string{{"title": "Returns", "text": "Unused gear within 30 days"}}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html := a.printer.Jobs()[1].HTML; !strings.Contains(html, "Unused gear within 30 days") || strings.Contains(html, "Tell us how we did") {
+		t.Error("the request's footer did not replace the configured one")
+	}
+
+	receipt["footerMessages"] = []map[string]string{{}}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("empty message: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptMerchantLegalBlock(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	saved := merchantInfo
+	t.Cleanup(func() { merchantInfo = saved })
+	merchantInfo = merchant.Info{
+		LegalName:     "Main Street Outfitters Inc.",
+		Address:       []string{"400 Main Street", "Halifax NS B3H 1A1"},
+		Registrations: []merchant.Registration{{Label: "GST/HST No.", Number: "987654321 RT0001"}},
+		VATFormat:     merchant.Table,
+	}
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1030",
+		"items": []map[string]interface{}{
+			{"name": "Kayak", "quantity": 1, "price": 40.00},
+			{"name": "Trail map", "quantity": 1, "price": 10.00, "taxExempt": true},
+		},
+		"subtotal":    50.00,
+		"tax":         4.80,
+		"total":       54.80,
+		"paymentType": "cash",
+		"location":    "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{
+		"Main Street Outfitters Inc.", "<div>Halifax NS B3H 1A1</div>", "GST/HST No.: 987654321 RT0001",
+		`<table class="vat-table">`, "<td>GST&#43;PST</td>", "<td>$40.00</td>", "<td>$4.80</td>", "<td>$44.80</td>",
+		"<td>Tax exempt</td>", "<td>$10.00</td>",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	if strings.Contains(html, "Taxable GST&#43;PST") {
+		t.Error("the VAT table did not replace the tax subtotals")
+	}
+
+	// Without a table the legal details print above the usual tax lines
+	merchantInfo.VATFormat = merchant.Lines
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html = a.printer.Jobs()[1].HTML
+	if !strings.Contains(html, "987654321 RT0001") || strings.Contains(html, `<table class="vat-table">`) || !strings.Contains(html, "Taxable GST&#43;PST") {
+		t.Error("a lines format printed the VAT table or dropped the registration")
+	}
+}
+
+func TestPrintSettlementGLSummary(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	settlement := map[string]interface{}{
+		"transactionId":        "TXN-1050",
+		"location":             "Main Street",
+		"items":                []map[string]interface{}{},
+		"total":                250.00,
+		"paymentType":          "cheque",
+		"isSettlement":         true,
+		"accountId":            "ACME-7",
+		"accountBalanceBefore": 250.00,
+		"settlementAmount":     250.00,
+		"glCodeSummary": []map[string]interface{}{
+			{"glCode": "4100", "description": "Equipment rental", "amount": 200.00},
+			{"glCode": "4200", "description": "Damage waiver", "amount": 50.00},
+		},
+	}
+	if resp := a.PostJSON("/print/receipt", settlement); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"GL Code Summary", "<span>4100 Equipment rental</span>", "<span>$200.00</span>", "<span>4200 Damage waiver</span>", "<span>$250.00</span>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("settlement receipt is missing %q", want)
+		}
+	}
+
+	settlement["output"] = "thermal"
+	if resp := a.PostJSON("/print/receipt", settlement); resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	raw := a.printer.Jobs()[1].Raw
+	for _, want := range []string{"GL CODE SUMMARY", "4100 Equipment rental", "$50.00", "$250.00"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("thermal settlement is missing %q", want)
+		}
+	}
+}
+
+func TestPrintCombinedTransactionSections(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	combined := map[string]interface{}{
+		"transactionId":          "TXN-1060",
+		"location":               "Main Street",
+		"items":                  []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 50.00}},
+		"subtotal":               50.00,
+		"tax":                    6.00,
+		"total":                  156.00,
+		"paymentType":            "cash",
+		"hasCombinedTransaction": true,
+		"accountId":              "ACME-7",
+		"settlementAmount":       100.00,
+	}
+	if resp := a.PostJSON("/print/receipt", combined); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	retail, settlement := strings.Index(html, "RETAIL PURCHASE"), strings.Index(html, "ACCOUNT SETTLEMENT")
+	if retail < 0 || settlement < retail || strings.Index(html, "Kayak") > settlement {
+		t.Fatal("the receipt does not have a retail then a settlement section")
+	}
+	for _, want := range []string{"<span>Retail Subtotal:</span>\n        <span>$56.00</span>", "<span>Payment on Account:</span>", "<span>Settlement Subtotal:</span>\n        <span>$100.00</span>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+
+	combined["output"] = "thermal"
+	if resp := a.PostJSON("/print/receipt", combined); resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	raw := a.printer.Jobs()[1].Raw
+	for _, want := range []string{"RETAIL PURCHASE", "Retail Subtotal:", "ACCOUNT SETTLEMENT", "Settlement Subtotal:"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("thermal receipt is missing %q", want)
+		}
+	}
+}
+
+func TestPrintReceiptCoupons(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	saved := receiptCoupons
+	t.Cleanup(func() { receiptCoupons = saved })
+	receiptCoupons = coupons.NewRotator(coupons.Config{Coupons: []coupons.Coupon{
+		{Title: "10% off your next rental", Barcode: "RT-10OFF", ValidDays: 30},
+		{Title: "Free helmet", Barcode: "RT-HELMET", Expiry: "2099-12-31"},
+		{Title: "Big spender", Barcode: "RT-BIG", MinTotal: 500_00},
+		{Title: "Last summer", Barcode: "RT-OLD", End: "2020-08-31"},
+	}})
+
+	sale := func(id string) map[string]interface{} {
+		return map[string]interface{}{
+			"transactionId": id,
+			"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+			"subtotal":      40.00,
+			"tax":           4.80,
+			"total":         44.80,
+			"paymentType":   "cash",
+			"location":      "Main Street",
+		}
+	}
+	// Coupons take turns, skipping those the receipt doesn't qualify for
+	var printed []string
+	for i, id := range []string{"TXN-1070", "TXN-1071", "TXN-1072"} {
+		if resp := a.PostJSON("/print/receipt", sale(id)); resp.StatusCode != 200 {
+			t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+		}
+		html := a.printer.Jobs()[i].HTML
+		if strings.Count(html, `<div class="coupon">`) != 1 {
+			t.Fatalf("receipt %s has %d coupons, want 1", id, strings.Count(html, `<div class="coupon">`))
+		}
+		for _, code := range []string{"RT-10OFF", "RT-HELMET", "RT-BIG", "RT-OLD"} {
+			if strings.Contains(html, "<div>"+code+"</div>") {
+				printed = append(printed, code)
+			}
+		}
+	}
+	if want := []string{"RT-10OFF", "RT-HELMET", "RT-10OFF"}; !slices.Equal(printed, want) {
+		t.Errorf("coupons printed = %v, want %v", printed, want)
+	}
+	html := a.printer.Jobs()[0].HTML
+	expires := "Expires " + time.Now().AddDate(0, 0, 30).Format("2006-01-02")
+	if !strings.Contains(html, "data:image/svg&#43;xml;base64,") {
+		t.Error("the coupon barcode was not drawn")
+	}
+	if !strings.Contains(html, expires) {
+		t.Errorf("coupon is missing %q", expires)
+	}
+	if strings.Index(html, `<div class="coupon">`) < strings.Index(html, `<div class="footer">`) {
+		t.Error("the coupon is not after the footer")
+	}
+
+	// A request's own coupons replace the rotation; thermal output has the
+	// printer draw the barcode
+	own := sale("TXN-1073")
+	own["coupons"] = []map[string]interface{}{{"text": "Bring a friend", "barcode": "FRIEND-2", "expiry": "2099-01-31"}}
+	own["output"] = "thermal"
+	if resp := a.PostJSON("/print/receipt", own); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	raw := a.printer.Jobs()[3].Raw
+	for _, want := range []string{"Bring a friend", "\x1dk\x49\x0a{BFRIEND-2", "Expires 2099-01-31"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("thermal receipt is missing %q", want)
+		}
+	}
+	if bytes.Contains(raw, []byte("RT-")) {
+		t.Error("the rotation printed beside the request's coupons")
+	}
+
+	refund := sale("TXN-1074")
+	refund["type"] = "refund"
+	refund["originalTransactionId"] = "TXN-1070"
+	if resp := a.PostJSON("/print/receipt", refund); resp.StatusCode != 200 {
+		t.Fatalf("refund: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if strings.Contains(a.printer.Jobs()[4].HTML, `class="coupon"`) {
+		t.Error("a refund printed a coupon")
+	}
+
+	bad := sale("TXN-1075")
+	bad["coupons"] = []map[string]interface{}{{"barcode": "CAFÉ"}}
+	if resp := a.PostJSON("/print/receipt", bad); resp.StatusCode != 400 {
+		t.Errorf("non-ASCII barcode: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptLoyaltyPoints(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	receipt := map[string]interface{}{
+		"transactionId":         "TXN-1080",
+		"items":                 []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":              40.00,
+		"tax":                   4.80,
+		"total":                 44.80,
+		"paymentType":           "cash",
+		"location":              "Main Street",
+		"loyaltyPointsEarned":   45,
+		"loyaltyPointsRedeemed": 200,
+		"loyaltyPointsBalance":  1250,
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{`<div class="loyalty">`, "Loyalty Points", "<span>45</span>", "<span>-200</span>", "<span>1250</span>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+
+	receipt["transactionId"] = "TXN-1081"
+	receipt["output"] = "thermal"
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	raw := a.printer.Jobs()[1].Raw
+	for _, want := range []string{"LOYALTY POINTS", "Points earned:", "-200", "Points balance:"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("thermal receipt is missing %q", want)
+		}
+	}
+
+	// Receipts of customers outside the programme print no box
+	delete(receipt, "loyaltyPointsEarned")
+	delete(receipt, "loyaltyPointsRedeemed")
+	delete(receipt, "loyaltyPointsBalance")
+	receipt["transactionId"] = "TXN-1082"
+	delete(receipt, "output")
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if strings.Contains(a.printer.Jobs()[2].HTML, `class="loyalty"`) {
+		t.Error("a receipt without points printed a loyalty box")
+	}
+
+	receipt["loyaltyPointsEarned"] = -5
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("negative points: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptScriptedFields(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	saved := scriptedFields
+	t.Cleanup(func() { scriptedFields = saved })
+	cfg := script.Config{Fields: []script.Field{
+		{Name: "unrounded", Expr: "total"},
+		{Name: "total", Expr: `paymentType == "cash" ? round(total, 0.05) : total`},
+		{Name: "rounding", Expr: "money(total - unrounded)", Label: "Cash rounding"},
+		{Name: "tier", Expr: `total >= 40 ? "Gold member" : ""`, Label: "Tier"},
+		{Name: "broken", Expr: "1 / (total - total)", Label: "Broken"},
+	}}
+	if err := cfg.Compile(scriptFuncs); err != nil {
+		t.Fatal(err)
+	}
+	scriptedFields = cfg
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1090",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.83,
+		"total":         44.83,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"$44.85", "<span>Cash rounding:</span>", "<span>$0.02</span>", "<span>Gold member</span>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	if strings.Contains(html, "$44.83") {
+		t.Error("the total was not rounded")
+	}
+	if strings.Contains(html, "Broken") {
+		t.Error("a failed scripted field was printed")
+	}
+
+	receipt["transactionId"] = "TXN-1091"
+	receipt["paymentType"] = "card"
+	receipt["output"] = "thermal"
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	raw := a.printer.Jobs()[1].Raw
+	for _, want := range []string{"$44.83", "Cash rounding:", "$0.00", "Tier:"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Errorf("thermal receipt is missing %q", want)
+		}
+	}
+
+	if _, err := script.Compile("round(total,", nil); err == nil {
+		t.Error("an unfinished expression compiled")
+	}
+}
+
+func TestPrintOutputAndRenderer(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	receipt := func(id, output, renderer string) map[string]interface{} {
+		return map[string]interface{}{
+			"transactionId": id,
+			"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+			"subtotal":      40.00,
+			"tax":           4.80,
+			"total":         44.80,
+			"paymentType":   "cash",
+			"location":      "Main Street",
+			"output":        output,
+			"renderer":      renderer,
+		}
+	}
+	ran := func(since int) []string {
+		var names []string
+		for _, cmd := range a.printer.Commands()[since:] {
+			names = append(names, strings.Join(cmd, " "))
+		}
+		return names
+	}
+
+	// Thermal output sends ESC/POS straight to the printer, no browser
+	before := len(a.printer.Commands())
+	if resp := a.PostJSON("/print/receipt", receipt("TXN-1040", "thermal", "")); resp.StatusCode != 200 {
+		t.Fatalf("thermal: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	commands := ran(before)
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "lp -d Receipt_Printer -o raw ") {
+		t.Errorf("thermal ran %q, want only lp -o raw", commands)
+	}
+	job := a.printer.Jobs()[0]
+	for _, want := range []string{"\x1b@", "Kayak", "TXN-1040", "$44.80", "\x1dV"} {
+		if !strings.Contains(string(job.Raw), want) {
+			t.Errorf("thermal receipt is missing %q:\n%q", want, job.Raw)
+		}
+	}
+
+	// A named renderer is the only browser tried
+	before = len(a.printer.Commands())
+	if resp := a.PostJSON("/print/receipt", receipt("TXN-1041", "pdf", "chromium")); resp.StatusCode != 200 {
+		t.Fatalf("pdf: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if commands := ran(before); len(commands) != 2 || !strings.HasPrefix(commands[0], "chromium-browser ") {
+		t.Errorf("chromium renderer ran %q", commands)
+	}
+	if html := a.printer.Jobs()[1].HTML; !strings.Contains(html, "TXN-1041") {
+		t.Error("the PDF job is not the receipt")
+	}
+
+	// HTML output comes back to the caller and nothing prints
+	resp := a.PostJSON("/print/receipt", receipt("TXN-1042", "html", ""))
+	if resp.StatusCode != 200 {
+		t.Fatalf("html: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html, _ := resp.JSON(t)["html"].(string); !strings.Contains(html, "TXN-1042") || !strings.Contains(html, "<!DOCTYPE html>") {
+		t.Errorf("html output returned %q", html)
+	}
+	if n := len(a.printer.Jobs()); n != 2 {
+		t.Errorf("html output printed: %d jobs, want 2", n)
+	}
+
+	for _, bad := range [][2]string{{"fax", ""}, {"thermal", "chrome"}, {"pdf", "escpos"}} {
+		if resp := a.PostJSON("/print/receipt", receipt("TXN-1043", bad[0], bad[1])); resp.StatusCode != 400 {
+			t.Errorf("output %q renderer %q: status = %d, want 400", bad[0], bad[1], resp.StatusCode)
+		}
+	}
+
+	agreement := map[string]interface{}{
+		"agreementNumber": "RA-1040",
+		"customer":        map[string]interface{}{"firstName": "JANE", "lastName": "DOE"},
+		"items":           []map[string]interface{}{{"name": "Kayak", "quantity": 1, "rate": 40.00, "rateUnit": "day"}},
+		"rentalStart":     "2025-06-01 09:00",
+		"rentalEnd":       "2025-06-02 17:00",
+		"output":          "thermal",
+	}
+	if resp := a.PostJSON("/print/agreement", agreement); resp.StatusCode != 400 {
+		t.Errorf("thermal agreement: status = %d, want 400", resp.StatusCode)
+	}
+	agreement["output"] = "html"
+	resp = a.PostJSON("/print/agreement", agreement)
+	if html, _ := resp.JSON(t)["html"].(string); resp.StatusCode != 200 || !strings.Contains(html, "RA-1040") {
+		t.Errorf("html agreement: status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestPrintReceiptPaperWidth(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+	saved := receiptPaper
+	t.Cleanup(func() { receiptPaper = saved })
+	size, err := paper.Parse("58mm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiptPaper = size
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1011",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if html := a.printer.Jobs()[0].HTML; !strings.Contains(html, "width: 58mm;") {
+		t.Error("receipt is not laid out for 58mm paper")
+	}
+
+	if _, err := paper.Parse("75mm"); err == nil {
+		t.Error("75mm paper was accepted")
+	}
+}
+
+func TestPrintReceiptCardHidesCash(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1012",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"total":         40.00,
+		"paymentType":   "credit",
+		"cashGiven":     50.00,
+		"changeDue":     10.00,
+		"location":      "Main Street",
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	receipt["transactionId"] = "TXN-1013"
+	receipt["paymentType"] = "cash"
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	jobs := a.printer.Jobs()
+	if strings.Contains(jobs[0].HTML, "<span>Change:</span>") {
+		t.Error("card receipt shows cash given and change")
+	}
+	if !strings.Contains(jobs[1].HTML, "<span>Change:</span>") {
+		t.Error("cash receipt is missing its change")
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	cases := []struct {
+		template string
+		data     interface{}
+		want     string
+	}{
+		{`{{money .}}`, money.Cents(123450), "$1,234.50"},
+		{`{{money .}}`, 2.5, "$2.50"},
+		{`{{money .}}`, json.Number("7"), "$7.00"},
+		{`{{number .}} {{formatPrice .}}`, money.Cents(-99), "-0.99 -0.99"},
+		{`{{money (multiply .Price .Qty)}}`, map[string]interface{}{"Price": money.Cents(333), "Qty": 3}, "$9.99"},
+		{`{{money (multiply .Qty .Price)}}`, map[string]interface{}{"Price": money.Cents(1999), "Qty": 1.5}, "$29.99"},
+		{`{{multiply 2 2.5}}`, nil, "5"},
+		{`{{date "Jan 2, 2006" .}}`, "2025-06-01 10:15", "Jun 1, 2025"},
+		{`{{. | date "02/01/2006"}}`, "2025-06-01T10:15:00Z", "01/06/2025"},
+		{`{{date "Jan 2" .}}`, time.Date(2025, 12, 24, 0, 0, 0, 0, time.UTC), "Dec 24"},
+		{`{{date "Jan 2" .}}`, "next Tuesday", "next Tuesday"},
+		{`{{date "Jan 2" .}}`, time.Time{}, ""},
+		{`{{truncate 8 .}}`, "Sea kayak, double", "Sea kay…"},
+		{`{{truncate 10 .}}`, "Crème brûlée", "Crème brû…"},
+		{`{{truncate 20 .}}`, "Kayak", "Kayak"},
+		{`[{{padLeft 6 .}}][{{padRight 6 .}}]`, "Côté", "[  Côté][Côté  ]"},
+		{`{{padLeft 2 .}}`, "Kayak", "Kayak"},
+		{`{{pluralize . "item" "items"}}`, 1, "item"},
+		{`{{pluralize . "item" "items"}}`, 0, "items"},
+		{`{{pluralize . "day" "days"}}`, 2.5, "days"},
+		{`{{title .}}`, "sea kayak (2-seat)", "Sea Kayak (2-Seat)"},
+		{`{{upper .}} {{lower .}}`, "Kayak", "KAYAK kayak"},
+		{`{{if contains . "credit"}}card{{end}}`, "credit", "card"},
+		{`{{if isString .}}yes{{else}}no{{end}}`, 5, "no"},
+		// The builtin comparisons, no longer shadowed by numeric-only ones
+		{`{{if eq . "cash"}}cash{{else}}other{{end}}`, "credit", "other"},
+		{`{{if eq . "cash" "check"}}paper{{end}}`, "check", "paper"},
+		{`{{if gt . 0}}owing{{end}}`, money.Cents(5), "owing"},
+		{`{{if and (gt .A 0.0) (lt .B 10) (ne .C "")}}all{{end}}`, map[string]interface{}{"A": 1.5, "B": 3, "C": "x"}, "all"},
+	}
+	for _, c := range cases {
+		tmpl, err := template.New("t").Funcs(tmplfuncs.New(money.DefaultFormat())).Parse(c.template)
+		if err != nil {
+			t.Errorf("%s: %v", c.template, err)
+			continue
+		}
+		got, err := tmplfuncs.Render(tmpl, c.data)
+		if err != nil {
+			t.Errorf("%s with %v: %v", c.template, c.data, err)
+		} else if got != c.want {
+			t.Errorf("%s with %v = %q, want %q", c.template, c.data, got, c.want)
+		}
+	}
+
+	url := `<img src="{{barcodeURL .}}">`
+	tmpl := template.Must(template.New("t").Funcs(tmplfuncs.New(money.DefaultFormat())).Parse(url))
+	if got, err := tmplfuncs.Render(tmpl, "https://example.com/survey"); err != nil || !strings.Contains(got, `src="data:image/svg`) {
+		t.Errorf("barcodeURL = %q, %v", got, err)
+	}
+
+	// Bad values fail the render rather than printing $0.00
+	for _, bad := range []string{`{{money "lots"}}`, `{{money .Missing}}`, `{{multiply .Price .Price}}`, `{{date "Jan" 5}}`} {
+		tmpl := template.Must(template.New("t").Funcs(tmplfuncs.New(money.DefaultFormat())).Parse(bad))
+		if _, err := tmplfuncs.Render(tmpl, map[string]interface{}{"Price": money.Cents(100)}); err == nil {
+			t.Errorf("%s rendered without an error", bad)
+		}
+	}
+
+	runaway := template.Must(template.New("t").Parse(`{{range .}}{{range $}}0123456789{{end}}{{end}}`))
+	if _, err := tmplfuncs.Render(runaway, make([]int, 1000)); !errors.Is(err, tmplfuncs.ErrTooLarge) {
+		t.Errorf("runaway template: err = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestPrintReceiptSanitized(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	receipt := map[string]interface{}{
+		"transactionId": "TXN-1014",
+		"customerName":  "Eve\u202e\x00 Smith\n",
+		"items":         []map[string]interface{}{{"name": "Kayak\x1b" + strings.Repeat("x", 500), "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"total":         40.00,
+		"paymentType":   "cash",
+		"location":      map[string]interface{}{"name": "Main\x07 Street"},
+	}
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	html := a.printer.Jobs()[0].HTML
+	for _, want := range []string{"Eve Smith", "Main Street", "Kayak" + strings.Repeat("x", 115)} {
+		if !strings.Contains(html, want) {
+			t.Errorf("receipt is missing %q", want)
+		}
+	}
+	if strings.Contains(html, strings.Repeat("x", 120)) {
+		t.Error("item name was not cut to length")
+	}
+
+	receipt["logoUrl"] = "file:///etc/passwd"
+	if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 400 {
+		t.Errorf("file: logo: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestPrintReceiptFieldErrors(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1015",
+		"items": []map[string]interface{}{
+			{"name": "Kayak", "quantity": -1, "price": 40.00},
+			{"name": "Paddle", "quantity": 1, "price": "abc"},
+		},
+		"total":    40.00,
+		"location": 7,
+	})
+	if resp.StatusCode != 400 {
+		t.Fatalf("status = %d, want 400", resp.StatusCode)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	fields := make(map[string]string)
+	for _, e := range body.Errors {
+		fields[e.Field] = e.Message
+	}
+	for _, field := range []string{"items[0].quantity", "items[1].price", "location"} {
+		if fields[field] == "" {
+			t.Errorf("no error for %s in %s", field, resp.Body)
+		}
+	}
+	if len(fields) != 3 {
+		t.Errorf("errors = %v, want 3", body.Errors)
+	}
+	if !strings.Contains(body.Message, `items[1].price`) {
+		t.Errorf("message = %q", body.Message)
+	}
+
+	// Both payload shapes the frontends send are accepted
+	for _, receipt := range []map[string]interface{}{
+		{
+			"transactionId": "TXN-1016",
+			"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 2, "price": 40.00}},
+			"total":         80.00,
+			"location":      "Main Street",
+		},
+		{
+			"transactionId": "TXN-1017",
+			"items":         []map[string]interface{}{{"name": "Rope", "quantity": "1.5", "price": "4.00"}},
+			"total":         "6.00",
+			"location":      map[string]interface{}{"name": "Main Street"},
+			"payments":      []map[string]interface{}{{"type": "credit", "amount": "6.00", "cardDetails": map[string]interface{}{"cardLast4": "4242"}}},
+		},
+	} {
+		if resp := a.PostJSON("/print/receipt", receipt); resp.StatusCode != 200 {
+			t.Errorf("%s: status = %d, body %s", receipt["transactionId"], resp.StatusCode, resp.Body)
+		}
+	}
+}
+
+func TestPrintCopiesLimit(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	customer := map[string]interface{}{"firstName": "JANE", "lastName": "DOE"}
+	for path, request := range map[string]map[string]interface{}{
+		"/print/receipt":   {"transactionId": "TXN-1016", "items": []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}}, "total": 40.00},
+		"/print/agreement": {"agreementNumber": "RA-2002", "customer": customer, "items": []map[string]interface{}{{"name": "Kayak", "quantity": 1, "rate": 40.00}}},
+		"/print/invoice":   {"invoiceNumber": "INV-1002", "customer": customer, "items": []map[string]interface{}{{"description": "Kayak", "quantity": 1, "unitPrice": 40.00}}},
+		"/print/statement": {"accountId": "ACME-7"},
+	} {
+		request["copies"] = 1000000000
+		resp := a.PostJSON(path, request)
+		if resp.StatusCode != 400 {
+			t.Fatalf("%s: status = %d, want 400, body %s", path, resp.StatusCode, resp.Body)
+		}
+		var body ErrorResponse
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Errors) != 1 || body.Errors[0].Field != "copies" {
+			t.Errorf("%s: errors = %+v", path, body.Errors)
+		}
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 0 {
+		t.Errorf("jobs = %+v, want none", jobs)
+	}
+}
+
+func TestPrintReceiptValidation(t *testing.T) {
+	a := startAgent(t, "")
+
+	if resp := a.PostJSON("/print/receipt", map[string]interface{}{"total": 5}); resp.StatusCode != 400 {
+		t.Errorf("missing transaction ID: status = %d, want 400", resp.StatusCode)
+	}
+	if resp := a.PostJSON("/print/receipt", "{not json"); resp.StatusCode != 400 {
+		t.Errorf("malformed JSON: status = %d, want 400", resp.StatusCode)
+	}
+	if resp := a.Get("/print/receipt"); resp.StatusCode != 405 {
+		t.Errorf("GET: status = %d, want 405", resp.StatusCode)
+	}
+	if len(a.printer.Commands()) != 0 {
+		t.Errorf("rejected requests ran %v", a.printer.Commands())
+	}
+}
+
+func TestStatus(t *testing.T) {
+	a := startAgent(t, "")
+
+	resp := a.Get("/status")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("CORS header = %q", got)
+	}
+	body := resp.JSON(t)
+	if body["status"] != "ok" || body["appDir"] != a.appDir {
+		t.Errorf("status body = %s", resp.Body)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("printers are listed through lpstat")
+	}
+	a := startAgent(t, "")
+	origLookPath := lookPath
+	t.Cleanup(func() { lookPath = origLookPath })
+	lookPath = func(name string) (string, error) {
+		if name == "google-chrome" {
+			return "/usr/bin/google-chrome", nil
+		}
+		return "", exec.ErrNotFound
+	}
+
+	if resp := a.Get("/healthz"); resp.StatusCode != 200 || resp.JSON(t)["status"] != "ok" {
+		t.Errorf("healthz: status %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	resp := a.Get("/readyz")
+	if resp.StatusCode != 200 {
+		t.Fatalf("readyz status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	var ready ReadinessResponse
+	if err := json.Unmarshal(resp.Body, &ready); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"scanner":          "COM4",
+		"printer":          "Receipt_Printer",
+		"agreementPrinter": "Office_Printer",
+		"renderer":         "/usr/bin/google-chrome",
+	}
+	for name, detail := range want {
+		if c := ready.Checks[name]; !c.OK || c.Detail != detail {
+			t.Errorf("%s check = %+v, want ok with %q", name, c, detail)
+		}
+	}
+	if !ready.Checks["disk"].OK {
+		t.Errorf("disk check = %+v", ready.Checks["disk"])
+	}
+
+	// A failed job takes the printer out of service until one succeeds
+	a.printer.Fail("lp")
+	printTestPage(context.Background(), agentOptions{PrinterName: "Receipt_Printer"})
+	lookPath = func(string) (string, error) { return "", exec.ErrNotFound }
+	resp = a.Get("/readyz")
+	if resp.StatusCode != 503 {
+		t.Fatalf("readyz status = %d, want 503", resp.StatusCode)
+	}
+	json.Unmarshal(resp.Body, &ready)
+	if ready.Status != "not ready" || ready.Checks["printer"].OK || ready.Checks["renderer"].OK || !ready.Checks["scanner"].OK {
+		t.Errorf("readyz = %+v", ready)
+	}
+}
+
+func TestPortFallback(t *testing.T) {
+	held, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	port := listen.Port(held)
+
+	var inUse *listen.InUseError
+	if _, err := listen.Listen(port, 0); !errors.As(err, &inUse) || inUse.Port != port {
+		t.Fatalf("listening on a taken port: %v", err)
+	}
+	l, err := listen.Listen(port, 10)
+	if err != nil {
+		t.Fatalf("fallback: %v", err)
+	}
+	defer l.Close()
+	if got := listen.Port(l); got <= port || got > port+10 {
+		t.Errorf("fallback port = %d, want one after %d", got, port)
+	}
+
+	path := filepath.Join(t.TempDir(), portFileName)
+	if err := listen.Register(path, "GoScanRentalTide", listen.Port(l)); err != nil {
+		t.Fatal(err)
+	}
+	reg, err := listen.Lookup(path)
+	if err != nil || reg.Port != listen.Port(l) || reg.PID != os.Getpid() || reg.URL != fmt.Sprintf("http://localhost:%d", reg.Port) {
+		t.Errorf("registration = %+v, %v", reg, err)
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	a := startAgent(t, "")
+
+	resp := a.Get("/openapi.json")
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	doc := resp.JSON(t)
+	if doc["openapi"] != "3.0.3" {
+		t.Errorf("openapi = %v", doc["openapi"])
+	}
+
+	schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	property := func(schema, name string) map[string]interface{} {
+		s, _ := schemas[schema].(map[string]interface{})
+		props, _ := s["properties"].(map[string]interface{})
+		p, _ := props[name].(map[string]interface{})
+		return p
+	}
+	if p := property("LicenseData", "licenseNumber"); p["type"] != "string" {
+		t.Errorf("LicenseData.licenseNumber = %v", p)
+	}
+	if p := property("ReceiptData", "total"); p["type"] != "number" {
+		t.Errorf("ReceiptData.total = %v, want a number", p)
+	}
+	if p := property("ReceiptData", "items"); p["type"] != "array" {
+		t.Errorf("ReceiptData.items = %v", p)
+	}
+	if p := property("ReceiptData", "isNoSale"); p != nil {
+		t.Errorf("derived field ReceiptData.isNoSale is documented: %v", p)
+	}
+
+	// Every documented path is routed
+	mux := setupRoutes(agentOptions{})
+	for path := range doc["paths"].(map[string]interface{}) {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		if _, pattern := mux.Handler(req); pattern != path {
+			t.Errorf("documented path %s is not routed", path)
+		}
+	}
+
+	if docs := a.Get("/docs"); docs.StatusCode != 200 || !strings.Contains(string(docs.Body), "/openapi.json") {
+		t.Errorf("docs page: status %d", docs.StatusCode)
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	a := startAgent(t, "")
+
+	resp := a.Get("/v1/schema")
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/schema+json" {
+		t.Fatalf("status = %d, Content-Type = %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	doc := resp.JSON(t)
+	if doc["$schema"] != "http://json-schema.org/draft-04/schema#" {
+		t.Errorf("$schema = %v", doc["$schema"])
+	}
+	definitions := doc["definitions"].(map[string]interface{})
+	for _, name := range []string{"LicenseData", "ReceiptData", "PrintResponse", "StatusResponse", "ScannerStatusResponse", "ErrorResponse"} {
+		if definitions[name] == nil {
+			t.Errorf("%s has no schema", name)
+		}
+	}
+
+	// References point within the document, not at the OpenAPI components
+	scan := definitions["ScanResponse"].(map[string]interface{})["properties"].(map[string]interface{})
+	if ref := scan["licenseData"].(map[string]interface{})["$ref"]; ref != "#/definitions/LicenseData" {
+		t.Errorf("ScanResponse.licenseData = %v", scan["licenseData"])
+	}
+
+	// One body on its own, for generators that take a single root type
+	receipt := a.Get("/v1/schema?type=ReceiptData").JSON(t)
+	if receipt["$ref"] != "#/definitions/ReceiptData" || receipt["title"] != "ReceiptData" {
+		t.Errorf("ReceiptData schema = %v %v", receipt["$ref"], receipt["title"])
+	}
+	if resp := a.Get("/v1/schema?type=Nope"); resp.StatusCode != 404 {
+		t.Errorf("unknown type: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestAPIVersioning(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	// Versioned paths are current
+	current := a.Get("/v1/status")
+	if current.StatusCode != 200 || current.JSON(t)["status"] != "ok" || current.Header.Get("Deprecation") != "" {
+		t.Errorf("/v1/status: status %d, Deprecation %q", current.StatusCode, current.Header.Get("Deprecation"))
+	}
+
+	// Legacy paths answer the same, pointing at their successor
+	for _, path := range []string{"/status", "/print/jobs/job-0123456789abcdef/artifact"} {
+		legacy := a.Get(path)
+		if legacy.Header.Get("Deprecation") != fmt.Sprintf("@%d", apiversion.Deprecated.Unix()) {
+			t.Errorf("%s: Deprecation = %q", path, legacy.Header.Get("Deprecation"))
+		}
+		if link := legacy.Header.Get("Link"); link != `</v1`+path+`>; rel="successor-version"` {
+			t.Errorf("%s: Link = %q", path, link)
+		}
+		if versioned := a.Get("/v1" + path); versioned.StatusCode != legacy.StatusCode {
+			t.Errorf("%s: status %d, /v1 status %d", path, legacy.StatusCode, versioned.StatusCode)
+		}
+	}
+	resp := a.PostJSON("/v1/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1601",
+		"items":         []map[string]interface{}{{"name": "Paddle", "quantity": 1, "price": 10.00}},
+		"subtotal":      10.00,
+		"tax":           1.20,
+		"total":         11.20,
+		"paymentType":   "cash",
+		"location":      "Main Street",
+	})
+	if resp.StatusCode != 200 {
+		t.Errorf("/v1/print/receipt: status %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	// Probes and documentation stay where they are
+	if resp := a.Get("/healthz"); resp.StatusCode != 200 || resp.Header.Get("Deprecation") != "" {
+		t.Errorf("/healthz: status %d, Deprecation %q", resp.StatusCode, resp.Header.Get("Deprecation"))
+	}
+	if resp := a.Get("/v1/healthz"); resp.StatusCode != 404 {
+		t.Errorf("/v1/healthz: status = %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestCustomerDisplay(t *testing.T) {
+	a := startAgent(t, "")
+
+	resp := a.PostJSON("/display/show", map[string]interface{}{"item": "Bike Rental", "quantity": 2, "price": 30.00})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	resp = a.PostJSON("/display/show", map[string]interface{}{"paid": 80.00, "change": 7.20})
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if resp := a.PostJSON("/display/clear", ""); resp.StatusCode != 200 {
+		t.Fatalf("clear status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	if opened := a.scanner.Opened(); len(opened) != 3 || opened[0] != "COM5" {
+		t.Errorf("opened ports = %v, want COM5 for each update", opened)
+	}
+	written := a.scanner.Written()
+	if len(written) != 3 {
+		t.Fatalf("display received %d writes, want 3", len(written))
+	}
+	item := "\x1b@\x1f\x01\x0c\x0b" + "Bike Rental         " + "\x1f$\x01\x02" + "2 @ $30.00    $60.00"
+	if string(written[0]) != item {
+		t.Errorf("item display = %q, want %q", written[0], item)
+	}
+	if !bytes.Contains(written[1], []byte("CASH          $80.00")) || !bytes.Contains(written[1], []byte("CHANGE         $7.20")) {
+		t.Errorf("change display = %q", written[1])
+	}
+	if string(written[2]) != "\x1b@\x0c" {
+		t.Errorf("clear = %q", written[2])
+	}
+
+	if resp := a.PostJSON("/display/show", map[string]interface{}{}); resp.StatusCode != 400 {
+		t.Errorf("empty display request: status = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestDisplayPrompts(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, bcSwipe)
+	prompter, err := display.NewPrompter(display.PromptConfig{
+		Location: "Harbour",
+		Prompts: display.PromptSet{
+			"welcome": {"en": "Welcome to {{.Location}}"},
+		},
+		Locations: map[string]display.PromptSet{
+			"airport": {"thank_you": {"en": "Safe travels!", "fr": "Bon voyage!"}},
+		},
+	}, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workflowPrompts = &promptDisplay{prompter: prompter, opts: agentOptions{DisplayPort: "COM5", DisplayBaud: 9600, DisplayWidth: 20}}
+	t.Cleanup(func() { workflowPrompts = nil })
+
+	if resp := a.PostJSON("/scanner/scan", ""); resp.StatusCode != 200 {
+		t.Fatalf("scan status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-PROMPT",
+		"items":         []map[string]interface{}{{"name": "Kayak Rental", "quantity": 1, "price": 45.00}},
+		"subtotal":      45.00,
+		"total":         45.00,
+		"paymentType":   "cash",
+		"date":          "2025-06-01",
+		"location":      "Airport",
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+
+	// Line 1 in English and line 2 in French, in the order the checkout
+	// went; the Airport's own thank-you replaces the default one
+	var shown []string
+	for i, port := range a.scanner.Opened() {
+		if port == "COM5" {
+			shown = append(shown, string(a.scanner.Written()[i]))
+		}
+	}
+	want := [][2]string{
+		{"PLEASE SCAN YOUR ID ", "SCANNEZ VOTRE ID    "},
+		{"Welcome to Harbour  ", "BIENVENUE           "},
+		{"TOTAL         $45.00", "TOTAL         $45.00"},
+		{"Safe travels!       ", "Bon voyage!         "},
+	}
+	if len(shown) != len(want) {
+		t.Fatalf("display showed %d prompts, want %d: %q", len(shown), len(want), shown)
+	}
+	for i, lines := range want {
+		if !strings.Contains(shown[i], lines[0]) || !strings.Contains(shown[i], lines[1]) {
+			t.Errorf("prompt %d = %q, want %q", i, shown[i], lines)
+		}
+	}
+
+	if _, err := display.NewPrompter(display.PromptConfig{Languages: []string{"en", "fr", "es"}}, 20); err == nil {
+		t.Error("three languages for a two-line display were accepted")
+	}
+	if _, err := display.NewPrompter(display.PromptConfig{Prompts: display.PromptSet{"goodbye": {"en": "Bye"}}}, 20); err == nil {
+		t.Error("an unknown prompt was accepted")
+	}
+}
+
+func TestSignatureCapture(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("print delivery is emulated through lp")
+	}
+	a := startAgent(t, "")
+
+	start := a.PostJSON("/signature/start", "")
+	if start.StatusCode != 200 {
+		t.Fatalf("start status = %d, body %s", start.StatusCode, start.Body)
+	}
+	id, _ := start.JSON(t)["id"].(string)
+	if opened := a.scanner.Opened(); len(opened) != 1 || opened[0] != "COM6" {
+		t.Errorf("opened ports = %v, want [COM6]", opened)
+	}
+
+	stream, err := http.Get(a.URL() + "/signature/stream?id=" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	events := bufio.NewScanner(stream.Body)
+	nextEvent := func() (string, string) {
+		var event, data string
+		for events.Scan() {
+			line := events.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			case line == "" && event != "":
+				return event, data
+			}
+		}
+		return "", ""
+	}
+	if event, _ := nextEvent(); event != "update" {
+		t.Fatalf("first event = %q, want the initial update", event)
+	}
+
+	// Two strokes from the pad, with a pen-up packet between them
+	var pad []byte
+	for _, p := range []struct {
+		x, y int
+		down bool
+	}{{100, 300, true}, {400, 200, true}, {700, 320, true}, {700, 320, false}, {300, 400, true}, {900, 380, true}} {
+		pad = append(pad, signature.Packet(signature.Point{X: p.x, Y: p.y}, p.down)...)
+	}
+	a.scanner.Send(pad)
+
+	for {
+		event, data := nextEvent()
+		if event != "update" {
+			t.Fatalf("stream ended with %q before all points arrived", event)
+		}
+		if strings.Contains(data, `"points":5`) {
+			break
+		}
+	}
+
+	finish := a.PostJSON("/signature/finish", map[string]string{"id": id})
+	if finish.StatusCode != 200 {
+		t.Fatalf("finish status = %d, body %s", finish.StatusCode, finish.Body)
+	}
+	body := finish.JSON(t)
+	if body["strokes"] != 2.0 {
+		t.Errorf("strokes = %v, want 2", body["strokes"])
+	}
+	if svg, _ := body["svg"].(string); strings.Count(svg, "<path") != 2 {
+		t.Errorf("svg = %s", svg)
+	}
+	pngData, err := base64.StdEncoding.DecodeString(body["png"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(pngData)); err != nil {
+		t.Errorf("signature PNG does not decode: %v", err)
+	}
+	if event, _ := nextEvent(); event != "done" {
+		t.Errorf("stream did not end with done, got %q", event)
+	}
+
+	if img := a.Get("/signature/image?id=" + id + "&format=svg"); img.StatusCode != 200 || img.Header.Get("Content-Type") != "image/svg+xml" {
+		t.Errorf("saved SVG: status %d, type %q", img.StatusCode, img.Header.Get("Content-Type"))
+	}
+	if img := a.Get("/signature/image?id=../../etc/passwd"); img.StatusCode != 400 {
+		t.Errorf("path traversal: status = %d, want 400", img.StatusCode)
+	}
+
+	// The receipt carries the signature image above the signature line
+	resp := a.PostJSON("/print/receipt", map[string]interface{}{
+		"transactionId": "TXN-1003",
+		"items":         []map[string]interface{}{{"name": "Kayak", "quantity": 1, "price": 40.00}},
+		"subtotal":      40.00,
+		"tax":           4.80,
+		"total":         44.80,
+		"paymentType":   "credit",
+		"location":      "Main Street",
+		"signatureId":   id,
+	})
+	if resp.StatusCode != 200 {
+		t.Fatalf("print status = %d, body %s", resp.StatusCode, resp.Body)
+	}
+	if jobs := a.printer.Jobs(); len(jobs) != 1 || !strings.Contains(jobs[0].HTML, `src="data:image/png;base64,`) {
+		t.Error("printed receipt has no signature image")
+	}
+}
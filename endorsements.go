@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+)
+
+// licenseCodes splits an endorsement (DCD) or restriction (DCB) element
+// into its codes. Jurisdictions separate them with spaces or commas, or
+// run single-letter codes together ("HT" is H and T); NONE means there
+// are none.
+func licenseCodes(value string) []string {
+	var codes []string
+	for _, field := range strings.FieldsFunc(strings.ToUpper(value), isCodeSeparator) {
+		switch {
+		case field == "NONE":
+		case isLetters(field):
+			for _, c := range field {
+				codes = append(codes, string(c))
+			}
+		default:
+			codes = append(codes, field)
+		}
+	}
+	return codes
+}
+
+func isCodeSeparator(r rune) bool {
+	return r == ' ' || r == ',' || r == ';' || r == '/'
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return s != ""
+}
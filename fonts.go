@@ -0,0 +1,95 @@
+package main
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Fonts built into the agent, used for any face the fonts directory
+// doesn't have: Fira Mono and Fira Sans, under the SIL Open Font License
+// kept beside them in fonts/OFL.txt. The medium weights stand in for bold.
+//
+//go:embed fonts/*.woff2 fonts/OFL.txt
+var bundledFonts embed.FS
+
+// embeddedFonts are the @font-face rules of the -fonts directory and the
+// bundled fonts, which the templates write into their style sheet with {{fonts}}. The fonts
+// travel inside the HTML as data URLs, so the browser that makes the PDF
+// draws every document with the same fonts whatever the till has
+// installed, and an archived receipt still renders the way it printed.
+var embeddedFonts template.CSS
+
+// fontFace is a font file looked for in the fonts directory. Templates
+// name the family first in their font-family lists, before the system
+// fonts, which a browser only falls back to for characters the font lacks.
+type fontFace struct {
+	Family string
+	File   string // Name without its extension
+	Weight string
+}
+
+var fontFaces = []fontFace{
+	{Family: "Receipt Mono", File: "receipt-mono", Weight: "normal"},
+	{Family: "Receipt Mono", File: "receipt-mono-bold", Weight: "bold"},
+	{Family: "Receipt Sans", File: "receipt-sans", Weight: "normal"},
+	{Family: "Receipt Sans", File: "receipt-sans-bold", Weight: "bold"},
+}
+
+// fontFormats are the font file extensions read, most compact first, with
+// their CSS format names
+var fontFormats = []struct{ ext, format, mime string }{
+	{".woff2", "woff2", "font/woff2"},
+	{".woff", "woff", "font/woff"},
+	{".ttf", "truetype", "font/ttf"},
+	{".otf", "opentype", "font/otf"},
+}
+
+// maxFontBytes keeps a stray file from bloating every document
+const maxFontBytes = 4 << 20
+
+// loadFonts reads the fonts of dir into @font-face rules and returns the
+// files it found there, and the faces it took from the bundled fonts
+// because dir doesn't have them. dir need not exist.
+func loadFonts(dir string) (template.CSS, []string, []string, error) {
+	var css strings.Builder
+	var found, bundled []string
+	addFace := func(face fontFace, mime, format string, data []byte) {
+		fmt.Fprintf(&css, "@font-face { font-family: '%s'; font-weight: %s; src: url(data:%s;base64,%s) format('%s'); }\n",
+			face.Family, face.Weight, mime, base64.StdEncoding.EncodeToString(data), format)
+	}
+	for _, face := range fontFaces {
+		local := false
+		for _, f := range fontFormats {
+			path := filepath.Join(dir, face.File+f.ext)
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return "", nil, nil, fmt.Errorf("failed to read font: %v", err)
+			}
+			if len(data) > maxFontBytes {
+				return "", nil, nil, fmt.Errorf("font %s is larger than %d MB", path, maxFontBytes>>20)
+			}
+			addFace(face, f.mime, f.format, data)
+			found = append(found, face.File+f.ext)
+			local = true
+			break
+		}
+		if local {
+			continue
+		}
+		data, err := bundledFonts.ReadFile("fonts/" + face.File + ".woff2")
+		if err != nil {
+			return "", nil, nil, fmt.Errorf("bundled font %s is missing from this build: %v", face.File, err)
+		}
+		addFace(face, "font/woff2", "woff2", data)
+		bundled = append(bundled, face.File)
+	}
+	return template.CSS(css.String()), found, bundled, nil
+}
@@ -2,9 +2,22 @@ module GoScanRentalTide
 
 go 1.24.1
 
-require go.bug.st/serial v1.6.4
+require (
+	github.com/klauspost/compress v1.18.0
+	go.bug.st/serial v1.6.4
+	golang.org/x/sys v0.36.0
+	modernc.org/sqlite v1.40.1
+)
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
-	golang.org/x/sys v0.19.0 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
+	modernc.org/libc v1.66.10 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
 )
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"GoScanRentalTide/internal/transport"
+)
+
+// HealthCheck is the state of one dependency in /readyz
+type HealthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// ReadinessResponse is the result of /readyz
+type ReadinessResponse struct {
+	Status string                 `json:"status"` // "ready" or "not ready"
+	Checks map[string]HealthCheck `json:"checks"` // scanner, printer, agreementPrinter, renderer, disk
+	Time   string                 `json:"time"`
+}
+
+// HealthResponse is the result of /healthz
+type HealthResponse struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	UptimeSeconds int    `json:"uptimeSeconds"`
+}
+
+// healthzHandler reports that the process is up and serving. It checks
+// nothing else, so a service monitor only restarts an agent that hangs.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:        "ok",
+		Version:       agentVersion,
+		UptimeSeconds: int(time.Since(agentStarted).Seconds()),
+	})
+}
+
+// readyzHandler checks every device and resource a scan or print needs,
+// answering 503 if any of them is missing
+func readyzHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	checks := map[string]HealthCheck{
+		"scanner":  checkScanner(opts.PortOverride),
+		"printer":  fallbackCheck(checkPrinter(opts.PrinterName), opts.PrinterFallback),
+		"renderer": checkRenderer(),
+		"disk":     checkDisk(opts.AppDir),
+	}
+	if opts.AgreementPrinter != opts.PrinterName || opts.AgreementFallback != opts.PrinterFallback {
+		checks["agreementPrinter"] = fallbackCheck(checkPrinter(opts.AgreementPrinter), opts.AgreementFallback)
+	}
+
+	resp := ReadinessResponse{Status: "ready", Checks: checks, Time: time.Now().Format(time.RFC3339)}
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			resp.Status, status = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func checkScanner(portOverride string) HealthCheck {
+	port, err := findScannerPort(portOverride)
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	// A network scanner is ready when it takes a connection, unless a
+	// scan holds it: device servers often allow only one
+	if transport.IsAddress(port) {
+		device := scannerDevice(portOverride)
+		if !device.tryAcquire() {
+			return HealthCheck{OK: true, Detail: port + " (scanning)"}
+		}
+		defer device.release()
+		conn, err := transport.TCP{DialTimeout: 2 * time.Second}.Open(port, nil)
+		if err != nil {
+			return HealthCheck{Detail: fmt.Sprintf("%s is not reachable: %v", port, err)}
+		}
+		conn.Close()
+		return HealthCheck{OK: true, Detail: port}
+	}
+	if portOverride != "" && !portPresent(port) {
+		return HealthCheck{Detail: port + " is not connected"}
+	}
+	return HealthCheck{OK: true, Detail: port}
+}
+
+// checkPrinter looks for the printer in the spooler and at the outcome of
+// its last job; "" is the system default, which only needs some printer
+// to be installed
+func checkPrinter(name string) HealthCheck {
+	if simulatedPrintDir != "" {
+		return HealthCheck{OK: true, Detail: "simulated: documents are written to " + simulatedPrintDir}
+	}
+	installed, err := listPrinters()
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	found := name == "" && len(installed) > 0
+	for _, p := range installed {
+		if p == name {
+			found = true
+		}
+	}
+	if !found {
+		return HealthCheck{Detail: printerKey(name) + " is not installed"}
+	}
+	if state := printerStatus(name); state.Offline {
+		return HealthCheck{Detail: fmt.Sprintf("%s failed its last job: %s", printerKey(name), state.LastError)}
+	}
+	return HealthCheck{OK: true, Detail: printerKey(name)}
+}
+
+// fallbackCheck notes on a printer's check that it stands in for the
+// configured printer, which isn't installed
+func fallbackCheck(c HealthCheck, configured string) HealthCheck {
+	if configured != "" {
+		c.Detail += fmt.Sprintf(" (in place of %s, which is not installed)", configured)
+	}
+	return c
+}
+
+// checkRenderer looks for a browser that printHTMLDocument can convert
+// documents to PDF with, in the same order
+func checkRenderer() HealthCheck {
+	if simulatedPrintDir != "" {
+		return HealthCheck{OK: true, Detail: "not needed for simulated prints"}
+	}
+	if runtime.GOOS == "windows" {
+		for _, edge := range []string{
+			"C:\\Program Files (x86)\\Microsoft\\Edge\\Application\\msedge.exe",
+			"C:\\Program Files\\Microsoft\\Edge\\Application\\msedge.exe",
+		} {
+			if _, err := os.Stat(edge); err == nil {
+				return HealthCheck{OK: true, Detail: edge}
+			}
+		}
+	}
+	for _, browser := range []string{"chrome", "google-chrome", "chromium-browser"} {
+		if path, err := lookPath(browser); err == nil {
+			return HealthCheck{OK: true, Detail: path}
+		}
+	}
+	return HealthCheck{Detail: "no Edge, Chrome or Chromium found to render documents"}
+}
+
+// checkDisk writes and removes a file where documents are rendered
+func checkDisk(appDir string) HealthCheck {
+	dir := filepath.Join(appDir, "temp")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	file, err := os.CreateTemp(dir, "readyz-*")
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	_, err = file.Write([]byte("ok"))
+	file.Close()
+	os.Remove(file.Name())
+	if err != nil {
+		return HealthCheck{Detail: err.Error()}
+	}
+	return HealthCheck{OK: true, Detail: dir}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/sanitize"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// eventJournal records scans, print jobs, drawer opens and failed
+// requests; nil when it could not be opened
+var eventJournal *journal.Journal
+
+// Page size limits for the history endpoints
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// recordJournal appends to the journal, raises the matching webhook
+// events and publishes the entry over MQTT. Failures are logged but never fail the request.
+func recordJournal(e journal.Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	printerChanged := e.Kind == journal.Print && trackPrinter(e)
+	notifyWebhooks(e, printerChanged)
+	publishJournalEntry(e)
+	if eventJournal == nil {
+		return
+	}
+	if err := eventJournal.Record(e); err != nil {
+		log.Printf("Error journaling %s: %v", e.Kind, err)
+	}
+}
+
+// parseHistoryTime reads a from/to filter: a date (YYYY-MM-DD, covering
+// the whole day) or an RFC 3339 time
+func parseHistoryTime(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			return day.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+		}
+		return day, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+// historyHandler lists journaled entries of one kind, newest first.
+// Query parameters: from, to, operator, station, requestId, limit
+// (default 50, at most 500) and offset.
+func historyHandler(w http.ResponseWriter, r *http.Request, kind string) {
+	if eventJournal == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, errors.New("journal is not available"))
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseHistoryTime(query.Get("from"), false)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseHistoryTime(query.Get("to"), true)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit, offset := defaultHistoryLimit, 0
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+			return
+		}
+		limit = min(limit, maxHistoryLimit)
+	}
+	if v := query.Get("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid offset %q", v))
+			return
+		}
+	}
+
+	page, err := eventJournal.Find(journal.Query{
+		Kind:      kind,
+		From:      from,
+		To:        to,
+		Operator:  query.Get("operator"),
+		Station:   query.Get("station"),
+		RequestID: query.Get("requestId"),
+		Offset:    offset,
+		Limit:     limit,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"total":   page.Total,
+		"offset":  page.Offset,
+		"limit":   page.Limit,
+		"entries": page.Entries,
+	})
+}
+
+// journalErrors journals every request answered with an error status,
+// with the message of its error body, so failures show up in
+// /history/errors beside the scans and prints they held up
+func journalErrors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		recorder := &errorRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status < http.StatusBadRequest {
+			return
+		}
+
+		entry := journal.Entry{
+			Kind:       journal.Error,
+			RequestID:  tracing.RequestID(r.Context()),
+			Status:     "failed",
+			Request:    r.Method + " " + r.URL.Path,
+			HTTPStatus: recorder.status,
+			Error:      http.StatusText(recorder.status),
+			DurationMs: time.Since(started).Milliseconds(),
+			Operator:   sanitize.Line(r.Header.Get(operatorHeader), sanitize.MaxName),
+		}
+		var body ErrorResponse
+		if json.Unmarshal(recorder.body.Bytes(), &body) == nil && body.Message != "" {
+			entry.Error = body.Message
+		}
+		recordJournal(entry)
+	})
+}
+
+// errorRecorder keeps the status of a response, and its body when the
+// status is an error
+type errorRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (e *errorRecorder) WriteHeader(status int) {
+	e.status = status
+	e.ResponseWriter.WriteHeader(status)
+}
+
+func (e *errorRecorder) Write(data []byte) (int, error) {
+	if e.status >= http.StatusBadRequest && e.body.Len() < maxPayloadCapture {
+		e.body.Write(data)
+	}
+	return e.ResponseWriter.Write(data)
+}
+
+// Flush keeps server-sent events, such as the signature stream, working
+func (e *errorRecorder) Flush() {
+	if flusher, ok := e.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (e *errorRecorder) Unwrap() http.ResponseWriter {
+	return e.ResponseWriter
+}
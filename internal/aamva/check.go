@@ -0,0 +1,101 @@
+package aamva
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// CheckError is an element whose value breaks the AAMVA rules for it,
+// which in a barcode that decoded means the scan was misread
+type CheckError struct {
+	Element string // Element ID, e.g. "DBB", or "header"
+	Reason  string
+}
+
+func (e *CheckError) Error() string {
+	return fmt.Sprintf("%s %s", e.Element, e.Reason)
+}
+
+// Date elements: expiry, issue and birth dates
+var dateElements = map[string]bool{"DBA": true, "DBD": true, "DBB": true}
+
+// Name elements: family, first and middle names, in the current and the
+// version 1 forms
+var nameElements = map[string]bool{
+	"DCS": true, "DAC": true, "DAD": true, "DCT": true, "DAB": true, "DAA": true,
+}
+
+// Check applies the AAMVA rules to the elements the agent reads: the
+// issuer number in the header is six digits, dates are real dates in
+// MMDDCCYY or CCYYMMDD, sex is 1, 2, 9, M, F or X, the state is two
+// letters and names are letters, spaces and - ' , . only. Elements that
+// are absent aren't checked; binary subfiles, such as the portrait, are
+// skipped.
+func Check(raw []byte) error {
+	if start := bytes.Index(raw, []byte("ANSI ")); start >= 0 {
+		header := raw[start+5:]
+		if len(header) < 6 || !isDigits(header[:6]) {
+			return &CheckError{"header", "has no six-digit issuer number"}
+		}
+	}
+	for _, line := range bytes.Split(raw, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		// The first element of a subfile follows the subfile type
+		if len(line) > 5 && (bytes.HasPrefix(line, []byte("DL")) || bytes.HasPrefix(line, []byte("ID"))) && line[2] == 'D' {
+			line = line[2:]
+		}
+		if len(line) < 3 {
+			continue
+		}
+		id, value := string(line[:3]), line[3:]
+		switch {
+		case dateElements[id]:
+			if !isDate(value) {
+				return &CheckError{id, fmt.Sprintf("is not a date (%q)", value)}
+			}
+		case id == "DBC":
+			if len(value) != 1 || !bytes.ContainsAny(value, "129MFX") {
+				return &CheckError{id, fmt.Sprintf("is not a sex code (%q)", value)}
+			}
+		case id == "DAJ":
+			if len(value) != 2 || !isLetter(value[0]) || !isLetter(value[1]) {
+				return &CheckError{id, fmt.Sprintf("is not a state or province code (%q)", value)}
+			}
+		case nameElements[id]:
+			for _, c := range value {
+				if !isLetter(c) && !bytes.ContainsRune([]byte(" -',."), rune(c)) {
+					return &CheckError{id, fmt.Sprintf("has a character that can't be in a name (%q)", c)}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isDate reports whether value is a date in MMDDCCYY (US) or CCYYMMDD
+// (Canada)
+func isDate(value []byte) bool {
+	if len(value) != 8 || !isDigits(value) {
+		return false
+	}
+	for _, layout := range []string{"01022006", "20060102"} {
+		if _, err := time.Parse(layout, string(value)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(b) > 0
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
@@ -0,0 +1,167 @@
+// Package aamva reads the subfile directory of AAMVA driver's license
+// barcodes and extracts binary subfiles such as the customer portrait.
+package aamva
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// Subfile is one entry of the barcode's subfile directory
+type Subfile struct {
+	Type string // "DL", "ID", or a jurisdiction subfile such as "ZD"
+	Data []byte // Contents after the two-letter type
+}
+
+// Subfiles parses the header that follows the "ANSI " (or "AAMVA")
+// file type and returns the subfiles it lists. Offsets in the header are
+// counted from the compliance indicator "@", which some scanners drop, so
+// entries that don't start with their type are looked up relative to the
+// header instead. It returns nil when there is no usable header.
+func Subfiles(raw []byte) []Subfile {
+	origin, entries, _ := directory(raw)
+	var subfiles []Subfile
+	for _, e := range entries {
+		if data, ok := subfileAt(raw, origin+e.offset, e.length, e.typ); ok {
+			subfiles = append(subfiles, Subfile{Type: e.typ, Data: data})
+		}
+	}
+	return subfiles
+}
+
+// Length returns how many bytes a complete scan has, up to the end of the
+// last subfile the header lists, so a reader can tell a scan that was cut
+// short; a scan that ends inside the header needs at least one more byte.
+// It reports false when there is no header, or one that can't be read.
+func Length(raw []byte) (int, bool) {
+	origin, entries, cut := directory(raw)
+	if cut {
+		return len(raw) + 1, true
+	}
+	if len(entries) == 0 {
+		return 0, false
+	}
+	end := 0
+	for _, e := range entries {
+		end = max(end, origin+e.offset+e.length)
+	}
+	return end, true
+}
+
+// entry is a subfile listed in the header, at offset from the origin
+type entry struct {
+	typ            string
+	offset, length int
+}
+
+// directory reads the header's subfile directory. It returns the origin
+// the offsets are counted from and the entries up to the first that
+// can't be read, and whether raw ends inside the header.
+func directory(raw []byte) (origin int, entries []entry, cut bool) {
+	start := fileType(raw)
+	if start < 0 {
+		return 0, nil, false
+	}
+	header := raw[start+5:]
+
+	// IIN (6), AAMVA version (2), jurisdiction version (2, version 2 and
+	// later), number of entries (2)
+	if len(header) < 10 {
+		return 0, nil, true
+	}
+	version, err := strconv.Atoi(string(header[6:8]))
+	if err != nil {
+		return 0, nil, false
+	}
+	pos := 8
+	if version >= 2 {
+		pos += 2
+	}
+	if len(header) < pos+2 {
+		return 0, nil, true
+	}
+	count, err := strconv.Atoi(string(header[pos : pos+2]))
+	if err != nil {
+		return 0, nil, false
+	}
+	pos += 2
+
+	// The "@" sits 4 bytes before the file type in a complete scan
+	origin = start - 4
+	if at := bytes.LastIndexByte(raw[:start], '@'); at >= 0 {
+		origin = at
+	}
+
+	for i := 0; i < count; i++ {
+		e := header[pos:]
+		if len(e) < 10 {
+			return origin, entries, true
+		}
+		pos += 10
+		offset, err1 := strconv.Atoi(string(e[2:6]))
+		length, err2 := strconv.Atoi(string(e[6:10]))
+		if err1 != nil || err2 != nil {
+			break
+		}
+		entries = append(entries, entry{string(e[:2]), offset, length})
+	}
+	return origin, entries, false
+}
+
+// fileType returns where the "ANSI " or "AAMVA" file type starts, or -1
+func fileType(raw []byte) int {
+	start := bytes.Index(raw, []byte("ANSI "))
+	if start < 0 {
+		start = bytes.Index(raw, []byte("AAMVA"))
+	}
+	return start
+}
+
+// subfileAt returns the subfile's contents if it starts with its type at
+// offset, searching forward a little for scanners that drop the prefix
+func subfileAt(raw []byte, offset, length int, typ string) ([]byte, bool) {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(raw) {
+		return nil, false
+	}
+	i := bytes.Index(raw[offset:], []byte(typ))
+	if i < 0 || i > 8 {
+		return nil, false
+	}
+	begin := offset + i + len(typ)
+	end := min(offset+i+length, len(raw))
+	if end < begin {
+		return nil, false
+	}
+	return raw[begin:end], true
+}
+
+// Portrait returns the JPEG portrait embedded in the barcode, if any.
+// Jurisdiction subfiles are searched first; scans without a usable header
+// are searched whole.
+func Portrait(raw []byte) ([]byte, bool) {
+	for _, sf := range Subfiles(raw) {
+		if sf.Type == "DL" || sf.Type == "ID" {
+			continue
+		}
+		if img, ok := findJPEG(sf.Data); ok {
+			return img, true
+		}
+	}
+	return findJPEG(raw)
+}
+
+// findJPEG finds a JPEG stream by its start and end of image markers
+func findJPEG(data []byte) ([]byte, bool) {
+	start := bytes.Index(data, []byte{0xFF, 0xD8, 0xFF})
+	if start < 0 {
+		return nil, false
+	}
+	end := bytes.LastIndex(data[start:], []byte{0xFF, 0xD9})
+	if end < 0 {
+		return nil, false
+	}
+	return data[start : start+end+2], true
+}
@@ -0,0 +1,56 @@
+// Package apiversion serves the API routes of the agent and the receipt
+// server under a version prefix, so a later version can change the shape
+// of LicenseData or ReceiptData under /v2 while clients keep calling /v1.
+// Clients written before versioning call the same routes without the
+// prefix. They still work, answered with the Deprecation (RFC 9745) and
+// Link headers that point at the versioned path.
+package apiversion
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/limits"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// Prefix of the versioned routes
+const Prefix = "/v1"
+
+// Deprecated is when the unversioned routes were deprecated
+var Deprecated = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// Mux registers every route twice: under Prefix, and at its legacy path
+// with deprecation headers. Routes that aren't part of the API, such as
+// health checks, are registered on the ServeMux directly.
+type Mux struct {
+	*http.ServeMux
+	logger *log.Logger
+
+	// Each legacy path is logged the first time it is called, to find
+	// the clients that still need updating without logging every request
+	seen sync.Map
+}
+
+// New registers versioned routes on mux, logging legacy calls to logger
+func New(mux *http.ServeMux, logger *log.Logger) *Mux {
+	return &Mux{ServeMux: mux, logger: logger}
+}
+
+// HandleFunc registers handler for pattern under Prefix and, for older
+// clients, as it is
+func (m *Mux) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	m.ServeMux.HandleFunc(Prefix+pattern, handler)
+	m.ServeMux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		successor := Prefix + r.URL.Path
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", Deprecated.Unix()))
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		if _, seen := m.seen.LoadOrStore(pattern, true); !seen {
+			tracing.Logf(m.logger, r.Context(), "Legacy API path %s called by %s; clients should move to %s", r.URL.Path, limits.ClientIP(r), successor)
+		}
+		handler(w, r)
+	})
+}
@@ -0,0 +1,376 @@
+// Package archive keeps rendered receipt artifacts (HTML, PDF) compressed
+// on disk with an index for looking them up by name or transaction.
+package archive
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const indexFile = "index.jsonl"
+
+// ErrNotFound is returned when no archived artifact matches a lookup
+var ErrNotFound = errors.New("archived receipt not found")
+
+// Entry describes one archived artifact
+type Entry struct {
+	Name           string    `json:"name"`
+	TransactionID  string    `json:"transactionId,omitempty"`
+	Kind           string    `json:"kind"` // file extension without the dot, e.g. "pdf"
+	Path           string    `json:"path"` // relative to the archive directory
+	Size           int64     `json:"size"`
+	CompressedSize int64     `json:"compressedSize"`
+	Created        time.Time `json:"created"`
+}
+
+// Usage summarises the disk used by the archive
+type Usage struct {
+	Entries         int     `json:"entries"`
+	OriginalBytes   int64   `json:"originalBytes"`
+	CompressedBytes int64   `json:"compressedBytes"`
+	Ratio           float64 `json:"ratio"` // compressed / original
+}
+
+// CompactResult reports what a compaction pass did
+type CompactResult struct {
+	Archived int `json:"archived"` // loose files compressed into the archive
+	Removed  int `json:"removed"`  // loose files deleted after archiving
+	Expired  int `json:"expired"`  // archived entries past retention
+}
+
+// Archive is a directory of zstd-compressed artifacts
+type Archive struct {
+	dir     string
+	mu      sync.Mutex
+	entries map[string]Entry
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// Open opens (creating if needed) the archive in dir and loads its index
+func Open(dir string) (*Archive, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Archive{
+		dir:     dir,
+		entries: make(map[string]Entry),
+		encoder: encoder,
+		decoder: decoder,
+	}
+	if err := a.loadIndex(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// loadIndex replays the index; later lines for the same name win
+func (a *Archive) loadIndex() error {
+	file, err := os.Open(filepath.Join(a.dir, indexFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open archive index: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // A torn last line after a crash is not fatal
+		}
+		if e.Path == "" {
+			delete(a.entries, e.Name) // Tombstone
+			continue
+		}
+		a.entries[e.Name] = e
+	}
+	return scanner.Err()
+}
+
+func (a *Archive) appendIndex(e Entry) error {
+	file, err := os.OpenFile(filepath.Join(a.dir, indexFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive index: %v", err)
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// Store compresses data into the archive under name (e.g.
+// "receipt-20250601-101500.pdf"), replacing any previous artifact
+func (a *Archive) Store(name, transactionID string, data []byte, created time.Time) (Entry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.store(name, transactionID, data, created)
+}
+
+func (a *Archive) store(name, transactionID string, data []byte, created time.Time) (Entry, error) {
+	rel := filepath.Join(created.Format("2006-01"), name+".zst")
+	path := filepath.Join(a.dir, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Entry{}, fmt.Errorf("failed to create archive directory: %v", err)
+	}
+
+	compressed := a.encoder.EncodeAll(data, nil)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, compressed, 0644); err != nil {
+		return Entry{}, fmt.Errorf("failed to write archived receipt: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return Entry{}, fmt.Errorf("failed to write archived receipt: %v", err)
+	}
+
+	e := Entry{
+		Name:           name,
+		TransactionID:  transactionID,
+		Kind:           strings.TrimPrefix(filepath.Ext(name), "."),
+		Path:           rel,
+		Size:           int64(len(data)),
+		CompressedSize: int64(len(compressed)),
+		Created:        created,
+	}
+	if err := a.appendIndex(e); err != nil {
+		return Entry{}, fmt.Errorf("failed to update archive index: %v", err)
+	}
+	a.entries[name] = e
+	return e, nil
+}
+
+// StoreFile archives an existing file, keeping the original in place
+func (a *Archive) StoreFile(path, transactionID string) (Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to read %s for archiving: %v", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	return a.Store(filepath.Base(path), transactionID, data, info.ModTime())
+}
+
+// Has reports whether an artifact with this name is archived
+func (a *Archive) Has(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.entries[name]
+	return ok
+}
+
+// Get returns the decompressed artifact stored under name
+func (a *Archive) Get(name string) (Entry, []byte, error) {
+	a.mu.Lock()
+	e, ok := a.entries[name]
+	a.mu.Unlock()
+	if !ok {
+		return Entry{}, nil, ErrNotFound
+	}
+
+	compressed, err := os.ReadFile(filepath.Join(a.dir, e.Path))
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("failed to read archived receipt: %v", err)
+	}
+	data, err := a.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return Entry{}, nil, fmt.Errorf("failed to decompress archived receipt: %v", err)
+	}
+	return e, data, nil
+}
+
+// Find lists the artifacts archived for a transaction, newest first,
+// optionally restricted to one kind ("html" or "pdf")
+func (a *Archive) Find(transactionID, kind string) []Entry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var found []Entry
+	for _, e := range a.entries {
+		if e.TransactionID == transactionID && (kind == "" || e.Kind == kind) {
+			found = append(found, e)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Created.After(found[j].Created) })
+	return found
+}
+
+// Usage totals the archive's original and compressed sizes
+func (a *Archive) Usage() Usage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var u Usage
+	for _, e := range a.entries {
+		u.Entries++
+		u.OriginalBytes += e.Size
+		u.CompressedBytes += e.CompressedSize
+	}
+	if u.OriginalBytes > 0 {
+		u.Ratio = float64(u.CompressedBytes) / float64(u.OriginalBytes)
+	}
+	return u
+}
+
+// Compact archives loose files in looseDir older than minAge (matching
+// the given extensions) and deletes them, drops archived entries older
+// than retention (zero keeps everything), and rewrites the index without
+// superseded lines.
+func (a *Archive) Compact(looseDir string, exts []string, minAge, retention time.Duration) (CompactResult, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var result CompactResult
+	now := time.Now()
+
+	loose, err := os.ReadDir(looseDir)
+	if err != nil && !os.IsNotExist(err) {
+		return result, fmt.Errorf("failed to read %s: %v", looseDir, err)
+	}
+	for _, d := range loose {
+		if d.IsDir() || !hasExt(d.Name(), exts) {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil || now.Sub(info.ModTime()) < minAge {
+			continue
+		}
+
+		path := filepath.Join(looseDir, d.Name())
+		if _, ok := a.entries[d.Name()]; !ok {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				continue
+			}
+			if _, err := a.store(d.Name(), "", data, info.ModTime()); err != nil {
+				return result, err
+			}
+			result.Archived++
+		}
+		if err := os.Remove(path); err == nil {
+			result.Removed++
+		}
+	}
+
+	result.Expired = a.expire(now, retention)
+	return result, a.rewriteIndex()
+}
+
+// Expire drops archived entries older than retention and returns how
+// many it dropped
+func (a *Archive) Expire(retention time.Duration) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	expired := a.expire(time.Now(), retention)
+	if expired == 0 {
+		return 0, nil
+	}
+	return expired, a.rewriteIndex()
+}
+
+func (a *Archive) expire(now time.Time, retention time.Duration) int {
+	if retention <= 0 {
+		return 0
+	}
+	expired := 0
+	for name, e := range a.entries {
+		if now.Sub(e.Created) > retention {
+			os.Remove(filepath.Join(a.dir, e.Path))
+			delete(a.entries, name)
+			expired++
+		}
+	}
+	return expired
+}
+
+// rewriteIndex replaces the append-only index with one line per entry
+func (a *Archive) rewriteIndex() error {
+	path := filepath.Join(a.dir, indexFile)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite archive index: %v", err)
+	}
+
+	names := make([]string, 0, len(a.entries))
+	for name := range a.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(file)
+	for _, name := range names {
+		line, err := json.Marshal(a.entries[name])
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to rewrite archive index: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rewrite archive index: %v", err)
+	}
+	return nil
+}
+
+func hasExt(name string, exts []string) bool {
+	for _, ext := range exts {
+		if strings.EqualFold(filepath.Ext(name), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// DirSize returns the number of files and total bytes under dir
+func DirSize(dir string) (int, int64) {
+	var files int
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := d.Info(); err == nil {
+			files++
+			size += info.Size()
+		}
+		return nil
+	})
+	return files, size
+}
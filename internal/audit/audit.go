@@ -0,0 +1,242 @@
+// Package audit keeps the loss-prevention log of events that move cash
+// or open the drawer: no-sales, drawer opens, refunds and reprints. The
+// log is one JSON-lines file that is only ever appended to. Each event
+// carries the hash of the one before it, so an event that is edited,
+// removed or reordered breaks the chain from that point on.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/money"
+)
+
+// Actions
+const (
+	NoSale     = "no_sale"
+	DrawerOpen = "drawer_open"
+	Refund     = "refund"
+	Reprint    = "reprint"
+	// A partial event, left by a crash or power cut in the middle of
+	// writing it, was moved out of the log when it was next opened
+	ChainBreak = "chain_break"
+)
+
+// Event is one audited event
+type Event struct {
+	Seq           int         `json:"seq"` // 1 for the first event in the file
+	Time          time.Time   `json:"time"`
+	Action        string      `json:"action"`
+	Operator      string      `json:"operator"` // Who asked for it, as the client named them; "" when it didn't
+	TransactionID string      `json:"transactionId,omitempty"`
+	Amount        money.Cents `json:"amount,omitempty"`
+	Detail        string      `json:"detail,omitempty"`
+	Prev          string      `json:"prev"` // Hash of the event before; "" for the first
+	Hash          string      `json:"hash"` // SHA-256 of this event with Hash empty
+}
+
+// hash returns the event's hash
+func (e Event) hash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Log is an open audit file
+type Log struct {
+	path string
+	mu   sync.Mutex
+	last Event // Zero before the first event
+}
+
+// Open opens (creating if needed) the audit log at path, picking up the
+// chain where the file ends. A partial last line, left by a crash while
+// an event was written, is moved to path+".torn" and a ChainBreak event
+// records it, so the log keeps recording rather than failing to open.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %v", err)
+	}
+	torn, err := removeTornLine(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	events, err := Read(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l := &Log{path: path}
+	if len(events) > 0 {
+		l.last = events[len(events)-1]
+	}
+	if torn > 0 {
+		_, err := l.Append(Event{
+			Action: ChainBreak,
+			Detail: fmt.Sprintf("%d bytes of an event cut short by a crash were moved to %s", torn, filepath.Base(path)+".torn"),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// removeTornLine moves the last line of the log to path+".torn" when it
+// isn't a whole event, returning its length. Only the last line can be
+// cut short by a crash; a bad line before it is left for Read to report.
+func removeTornLine(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	start := bytes.LastIndexByte(bytes.TrimRight(data, "\n"), '\n') + 1
+	last := bytes.TrimRight(data[start:], "\n")
+	if len(last) == 0 {
+		return 0, nil
+	}
+	var e Event
+	if json.Unmarshal(last, &e) == nil {
+		if data[len(data)-1] != '\n' {
+			// The event was written whole but for its line break
+			return 0, appendSynced(path, []byte("\n"))
+		}
+		return 0, nil
+	}
+
+	if err := appendSynced(path+".torn", append(last, '\n')); err != nil {
+		return 0, fmt.Errorf("failed to keep the partial audit event: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(int64(start)); err != nil {
+		return 0, fmt.Errorf("failed to remove the partial audit event: %v", err)
+	}
+	return len(last), f.Sync()
+}
+
+// appendSynced appends data to a file and syncs it to disk
+func appendSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Path returns the file the log is written to
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append stamps an event with its sequence number, time (if not set) and
+// hashes, and writes it to the file before returning it
+func (l *Log) Append(e Event) (Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	e.Seq, e.Prev = l.last.Seq+1, l.last.Hash
+	e.Hash = e.hash()
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return Event{}, err
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return Event{}, fmt.Errorf("failed to write audit log: %v", err)
+	}
+	// Audited events must survive a crash or power cut straight after
+	if err := f.Sync(); err != nil {
+		return Event{}, fmt.Errorf("failed to write audit log: %v", err)
+	}
+	l.last = e
+	return e, nil
+}
+
+// Events returns every event in the log, oldest first
+func (l *Log) Events() ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events, err := Read(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return events, err
+}
+
+// Read reads the events of an audit file, oldest first
+func Read(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("audit log line %d is not an event: %v", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// BrokenError reports where an audit chain stops holding together
+type BrokenError struct {
+	Seq    int // The first event that fails
+	Reason string
+}
+
+func (e *BrokenError) Error() string {
+	return fmt.Sprintf("audit event %d: %s", e.Seq, e.Reason)
+}
+
+// Verify checks the chain of events read from a log, returning a
+// *BrokenError at the first event that was altered, removed or moved
+func Verify(events []Event) error {
+	var prev Event
+	for i, e := range events {
+		switch {
+		case e.Seq != i+1:
+			return &BrokenError{Seq: e.Seq, Reason: fmt.Sprintf("expected event %d here; events were removed or reordered", i+1)}
+		case e.Prev != prev.Hash:
+			return &BrokenError{Seq: e.Seq, Reason: "does not follow the event before it"}
+		case e.Hash != e.hash():
+			return &BrokenError{Seq: e.Seq, Reason: "its contents were changed"}
+		}
+		prev = e
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+// Package cdp drives a headless Chrome or Edge over the DevTools protocol.
+// Keeping one browser running and opening a tab per document renders a
+// PDF in well under a second, where starting the browser for each one
+// takes several.
+package cdp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned by calls on a browser that has exited or whose
+// connection dropped
+var ErrClosed = errors.New("browser connection closed")
+
+// Browser is a connection to a browser's DevTools endpoint, and the
+// browser process when Launch started it
+type Browser struct {
+	conn *wsConn
+	cmd  *exec.Cmd
+	dir  string // Throwaway profile of a launched browser
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan message
+	waiters map[string][]chan struct{} // By session and event name
+	done    chan struct{}
+	err     error
+}
+
+// message is any DevTools message: a reply has an ID, an event a method
+type message struct {
+	ID        int64           `json:"id,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    interface{}     `json:"params,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Launch starts cmd, a Chrome or Edge command without arguments, as a
+// headless browser with a throwaway profile, and connects to it. The
+// caller may have set cmd's process attributes; Close ends the process.
+func Launch(ctx context.Context, cmd *exec.Cmd) (*Browser, error) {
+	dir, err := os.MkdirTemp("", "cdp-profile-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create browser profile: %v", err)
+	}
+	cmd.Args = append(cmd.Args,
+		"--headless", "--disable-gpu", "--no-first-run", "--no-default-browser-check",
+		"--remote-debugging-port=0", "--user-data-dir="+dir, "about:blank")
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start %s: %v", cmd.Path, err)
+	}
+
+	// The browser prints its DevTools address on stderr once it listens
+	found := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			if address, ok := devToolsURL(scanner.Text()); ok {
+				found <- address
+				break
+			}
+		}
+		io.Copy(io.Discard, stderr)
+	}()
+	fail := func(err error) (*Browser, error) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	var address string
+	select {
+	case address = <-found:
+	case <-ctx.Done():
+		return fail(fmt.Errorf("%s did not start: %w", cmd.Path, ctx.Err()))
+	}
+
+	b, err := Connect(ctx, address)
+	if err != nil {
+		return fail(err)
+	}
+	b.cmd, b.dir = cmd, dir
+	return b, nil
+}
+
+// Connect attaches to a browser already listening at a DevTools ws:// URL
+func Connect(ctx context.Context, address string) (*Browser, error) {
+	conn, err := dialWebSocket(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	b := &Browser{
+		conn:    conn,
+		pending: make(map[int64]chan message),
+		waiters: make(map[string][]chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go b.read()
+	return b, nil
+}
+
+// read dispatches replies and events until the connection drops
+func (b *Browser) read() {
+	var err error
+	for {
+		var data []byte
+		data, err = b.conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var m message
+		if json.Unmarshal(data, &m) != nil {
+			continue
+		}
+
+		b.mu.Lock()
+		if m.ID != 0 {
+			if reply, ok := b.pending[m.ID]; ok {
+				delete(b.pending, m.ID)
+				reply <- m
+			}
+		} else if m.Method != "" {
+			key := m.SessionID + " " + m.Method
+			for _, waiter := range b.waiters[key] {
+				close(waiter)
+			}
+			delete(b.waiters, key)
+		}
+		b.mu.Unlock()
+	}
+
+	b.mu.Lock()
+	b.err = fmt.Errorf("%w: %v", ErrClosed, err)
+	b.mu.Unlock()
+	close(b.done)
+}
+
+// Alive reports whether the browser is still connected
+func (b *Browser) Alive() bool {
+	select {
+	case <-b.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// call sends a command, to a tab's session or to the browser when
+// sessionID is empty, and decodes its result into result
+func (b *Browser) call(ctx context.Context, sessionID, method string, params, result interface{}) error {
+	reply := make(chan message, 1)
+	b.mu.Lock()
+	if b.err != nil {
+		b.mu.Unlock()
+		return b.err
+	}
+	b.nextID++
+	id := b.nextID
+	b.pending[id] = reply
+	b.mu.Unlock()
+
+	data, err := json.Marshal(message{ID: id, SessionID: sessionID, Method: method, Params: params})
+	if err == nil {
+		err = b.conn.WriteText(data)
+	}
+	if err != nil {
+		b.mu.Lock()
+		delete(b.pending, id)
+		b.mu.Unlock()
+		return fmt.Errorf("%s: %v", method, err)
+	}
+
+	select {
+	case m := <-reply:
+		if m.Error != nil {
+			return fmt.Errorf("%s: %s", method, m.Error.Message)
+		}
+		if result != nil {
+			return json.Unmarshal(m.Result, result)
+		}
+		return nil
+	case <-b.done:
+		return fmt.Errorf("%s: %w", method, b.err)
+	case <-ctx.Done():
+		b.mu.Lock()
+		delete(b.pending, id)
+		b.mu.Unlock()
+		return fmt.Errorf("%s: %w", method, ctx.Err())
+	}
+}
+
+// expect registers for the next event of a session before the command
+// that causes it is sent
+func (b *Browser) expect(sessionID, method string) chan struct{} {
+	event := make(chan struct{})
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := sessionID + " " + method
+	b.waiters[key] = append(b.waiters[key], event)
+	return event
+}
+
+// PDFOptions lay out the printed page. Without a paper size the
+// document's CSS @page size is used.
+type PDFOptions struct {
+	PaperWidth  float64 // Inches
+	PaperHeight float64
+}
+
+// PrintToPDF opens url in a new tab, waits for it to load and prints it,
+// with no margins and with backgrounds, as --print-to-pdf --no-margins
+// does
+func (b *Browser) PrintToPDF(ctx context.Context, url string, opts PDFOptions) ([]byte, error) {
+	var target struct {
+		TargetID string `json:"targetId"`
+	}
+	if err := b.call(ctx, "", "Target.createTarget", map[string]interface{}{"url": "about:blank"}, &target); err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		b.call(closeCtx, "", "Target.closeTarget", map[string]interface{}{"targetId": target.TargetID}, nil)
+	}()
+
+	var session struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := b.call(ctx, "", "Target.attachToTarget", map[string]interface{}{"targetId": target.TargetID, "flatten": true}, &session); err != nil {
+		return nil, err
+	}
+	if err := b.call(ctx, session.SessionID, "Page.enable", nil, nil); err != nil {
+		return nil, err
+	}
+
+	loaded := b.expect(session.SessionID, "Page.loadEventFired")
+	var navigation struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := b.call(ctx, session.SessionID, "Page.navigate", map[string]interface{}{"url": url}, &navigation); err != nil {
+		return nil, err
+	}
+	if navigation.ErrorText != "" {
+		return nil, fmt.Errorf("failed to open %s: %s", url, navigation.ErrorText)
+	}
+	select {
+	case <-loaded:
+	case <-b.done:
+		return nil, b.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s did not load: %w", url, ctx.Err())
+	}
+
+	params := map[string]interface{}{
+		"printBackground":   true,
+		"preferCSSPageSize": opts.PaperWidth == 0,
+		"marginTop":         0,
+		"marginBottom":      0,
+		"marginLeft":        0,
+		"marginRight":       0,
+	}
+	if opts.PaperWidth > 0 && opts.PaperHeight > 0 {
+		params["paperWidth"], params["paperHeight"] = opts.PaperWidth, opts.PaperHeight
+	}
+	var printed struct {
+		Data string `json:"data"`
+	}
+	if err := b.call(ctx, session.SessionID, "Page.printToPDF", params, &printed); err != nil {
+		return nil, err
+	}
+	pdf, err := base64.StdEncoding.DecodeString(printed.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Page.printToPDF: %v", err)
+	}
+	return pdf, nil
+}
+
+// Close asks the browser to exit, and kills it if it doesn't within a
+// few seconds
+func (b *Browser) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	b.call(ctx, "", "Browser.close", nil, nil)
+	b.conn.Close()
+	if b.cmd == nil {
+		return nil
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- b.cmd.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(3 * time.Second):
+		b.cmd.Process.Kill()
+		<-exited
+	}
+	return os.RemoveAll(b.dir)
+}
+
+// Process returns the process of a launched browser, or nil
+func (b *Browser) Process() *os.Process {
+	if b.cmd == nil {
+		return nil
+	}
+	return b.cmd.Process
+}
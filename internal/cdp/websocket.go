@@ -0,0 +1,192 @@
+package cdp
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocket opcodes (RFC 6455)
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Largest message accepted; a PDF comes back base64-encoded in one
+const maxMessage = 256 << 20
+
+// wsConn is the client end of a WebSocket: text messages only, as the
+// DevTools protocol uses
+type wsConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	writeMu sync.Mutex
+}
+
+// dialWebSocket opens a WebSocket to a ws:// URL
+func dialWebSocket(ctx context.Context, rawURL string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "ws" {
+		return nil, fmt.Errorf("invalid DevTools URL %q", rawURL)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the browser: %v", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var nonce [16]byte
+	rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	request := "GET " + u.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to the browser: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to the browser: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("browser refused the DevTools connection: %s", resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, reader: reader}, nil
+}
+
+// acceptKey is the Sec-WebSocket-Accept answer to a Sec-WebSocket-Key
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// WriteText sends one text message, masked as clients must
+func (c *wsConn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+func (c *wsConn) writeFrame(opcode byte, data []byte) error {
+	header := []byte{0x80 | opcode}
+	switch n := len(data); {
+	case n < 126:
+		header = append(header, 0x80|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 0x80|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 0x80|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	header = append(header, mask[:]...)
+	masked := make([]byte, len(data))
+	for i, b := range data {
+		masked[i] = b ^ mask[i%4]
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_, err := c.conn.Write(append(header, masked...))
+	return err
+}
+
+// ReadMessage returns the next text message, joining fragments and
+// answering pings. A close from the browser is io.EOF.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+			return nil, err
+		}
+		final, opcode := head[0]&0x80 != 0, head[0]&0x0F
+		length := uint64(head[1] & 0x7F)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+				return nil, err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+		}
+		if length > maxMessage || uint64(len(message))+length > maxMessage {
+			return nil, errors.New("DevTools message too large")
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, payload); err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, nil)
+			return nil, io.EOF
+		case opText, opContinuation:
+			message = append(message, payload...)
+		default:
+			return nil, fmt.Errorf("unexpected WebSocket frame %#x", opcode)
+		}
+		if final {
+			return message, nil
+		}
+	}
+}
+
+// Close ends the connection
+func (c *wsConn) Close() error {
+	c.writeFrame(opClose, nil)
+	return c.conn.Close()
+}
+
+// devToolsURL picks the browser's DevTools address out of the line it
+// prints on start, e.g. "DevTools listening on ws://127.0.0.1:9222/..."
+func devToolsURL(line string) (string, bool) {
+	const prefix = "DevTools listening on "
+	i := strings.Index(line, prefix)
+	if i < 0 {
+		return "", false
+	}
+	return strings.TrimSpace(line[i+len(prefix):]), true
+}
@@ -0,0 +1,257 @@
+// Package coupons holds the promotional coupons printed at the tail of
+// receipts, after the footer: return-visit offers with a barcode the till
+// scans and an expiry date. Configured coupons take turns by rotation
+// rules; a request can send its own instead.
+package coupons
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/sanitize"
+)
+
+// Date layout of expiries and campaign dates
+const DateLayout = "2006-01-02"
+
+// Longest barcode printed; longer ones don't fit across receipt paper
+const MaxBarcode = 40
+
+// How configured coupons take turns on receipts
+const (
+	RoundRobin = "round-robin" // In order, one receipt after another (the default)
+	Random     = "random"      // At random, in proportion to their weights
+	All        = "all"         // Every eligible coupon on every receipt
+)
+
+// Coupon is one coupon block
+type Coupon struct {
+	Title   string `json:"title,omitempty"`
+	Text    string `json:"text,omitempty"`    // Line breaks are kept
+	Barcode string `json:"barcode,omitempty"` // Printed as CODE128, printable ASCII
+	Expiry  string `json:"expiry,omitempty"`  // Last day the coupon is good for, YYYY-MM-DD
+
+	// Rotation rules of configured coupons
+	ValidDays int         `json:"validDays,omitempty"` // Expiry this many days after printing, without an expiry
+	Start     string      `json:"start,omitempty"`     // First day the coupon prints
+	End       string      `json:"end,omitempty"`       // Last day the coupon prints
+	MinTotal  money.Cents `json:"minTotal,omitempty"`  // Only on receipts of at least this total
+	Weight    int         `json:"weight,omitempty"`    // Share of random rotation (default 1)
+}
+
+// Lines returns the text split at its line breaks
+func (cp Coupon) Lines() []string {
+	if cp.Text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(cp.Text, "\r\n", "\n"), "\n")
+}
+
+// Config is the coupons printed on receipts that don't send their own
+type Config struct {
+	Rotation   string   `json:"rotation,omitempty"`   // RoundRobin (the default), Random or All
+	PerReceipt int      `json:"perReceipt,omitempty"` // Coupons per receipt with rotation (default 1)
+	Coupons    []Coupon `json:"coupons"`
+}
+
+// Load reads a coupons config from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read coupons config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse coupons config %s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid coupons config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the rotation and every coupon
+func (c Config) Validate() error {
+	switch c.Rotation {
+	case "", RoundRobin, Random, All:
+	default:
+		return fmt.Errorf("unknown rotation %q (use %s, %s or %s)", c.Rotation, RoundRobin, Random, All)
+	}
+	if c.PerReceipt < 0 {
+		return fmt.Errorf("perReceipt can't be negative")
+	}
+	if err := Validate(c.Coupons); err != nil {
+		return err
+	}
+	for i, cp := range c.Coupons {
+		for _, date := range []string{cp.Start, cp.End} {
+			if _, err := parseDate(date); err != nil {
+				return fmt.Errorf("coupon %d: %v", i+1, err)
+			}
+		}
+		if cp.ValidDays < 0 || cp.Weight < 0 {
+			return fmt.Errorf("coupon %d: validDays and weight can't be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// Validate checks that every coupon prints something, that its barcode
+// can be drawn and that its expiry is a date
+func Validate(coupons []Coupon) error {
+	for i, cp := range coupons {
+		if cp.Title == "" && cp.Text == "" && cp.Barcode == "" {
+			return fmt.Errorf("coupon %d is empty", i+1)
+		}
+		for _, r := range cp.Barcode {
+			if r < 0x20 || r > 0x7E {
+				return fmt.Errorf("coupon %d: barcode %q is not printable ASCII", i+1, cp.Barcode)
+			}
+		}
+		if len(cp.Barcode) > MaxBarcode {
+			return fmt.Errorf("coupon %d: barcode is longer than %d characters", i+1, MaxBarcode)
+		}
+		if _, err := parseDate(cp.Expiry); err != nil {
+			return fmt.Errorf("coupon %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// Clean removes control characters from coupons sent with a request and
+// caps their length
+func Clean(coupons []Coupon) {
+	for i := range coupons {
+		cp := &coupons[i]
+		cp.Title = sanitize.Line(cp.Title, sanitize.MaxName)
+		cp.Text = sanitize.Text(cp.Text, sanitize.MaxText)
+	}
+}
+
+// ExpiresOn returns the coupon's expiry when printed on day now: its own,
+// or validDays later. Empty when it doesn't expire.
+func (cp Coupon) ExpiresOn(now time.Time) string {
+	if cp.Expiry != "" {
+		return cp.Expiry
+	}
+	if cp.ValidDays > 0 {
+		return now.AddDate(0, 0, cp.ValidDays).Format(DateLayout)
+	}
+	return ""
+}
+
+// eligible reports whether a configured coupon prints on a receipt of
+// total on day now
+func (cp Coupon) eligible(now time.Time, total money.Cents) bool {
+	today := now.Format(DateLayout)
+	if cp.Start != "" && today < cp.Start {
+		return false
+	}
+	if cp.End != "" && today > cp.End {
+		return false
+	}
+	if cp.Expiry != "" && today > cp.Expiry {
+		return false
+	}
+	return total >= cp.MinTotal
+}
+
+// Rotator picks the configured coupons of each receipt. It is safe for
+// concurrent use.
+type Rotator struct {
+	cfg  Config
+	mu   sync.Mutex
+	next int // Round robin position in cfg.Coupons
+	rand *rand.Rand
+}
+
+// NewRotator returns a rotator of the configured coupons
+func NewRotator(cfg Config) *Rotator {
+	if cfg.PerReceipt == 0 {
+		cfg.PerReceipt = 1
+	}
+	return &Rotator{cfg: cfg, rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// Pick returns the coupons to print on a receipt of total on day now,
+// with their expiries filled in
+func (r *Rotator) Pick(now time.Time, total money.Cents) []Coupon {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var eligible []int
+	for i, cp := range r.cfg.Coupons {
+		if cp.eligible(now, total) {
+			eligible = append(eligible, i)
+		}
+	}
+	if len(eligible) == 0 {
+		return nil
+	}
+
+	var picked []int
+	switch r.cfg.Rotation {
+	case All:
+		picked = eligible
+	case Random:
+		picked = r.weighted(eligible, min(r.cfg.PerReceipt, len(eligible)))
+	default:
+		// Take the next eligible coupons after the last one printed
+		for n := 0; n < len(r.cfg.Coupons) && len(picked) < r.cfg.PerReceipt; n++ {
+			i := (r.next + n) % len(r.cfg.Coupons)
+			if r.cfg.Coupons[i].eligible(now, total) {
+				picked = append(picked, i)
+			}
+		}
+		r.next = (picked[len(picked)-1] + 1) % len(r.cfg.Coupons)
+	}
+
+	coupons := make([]Coupon, len(picked))
+	for n, i := range picked {
+		coupons[n] = r.cfg.Coupons[i]
+		coupons[n].Expiry = coupons[n].ExpiresOn(now)
+	}
+	return coupons
+}
+
+// weighted draws n different coupons of eligible, each in proportion to
+// its weight
+func (r *Rotator) weighted(eligible []int, n int) []int {
+	remaining := append([]int(nil), eligible...)
+	var picked []int
+	for len(picked) < n {
+		total := 0
+		for _, i := range remaining {
+			total += max(r.cfg.Coupons[i].Weight, 1)
+		}
+		draw := r.rand.Intn(total)
+		for k, i := range remaining {
+			draw -= max(r.cfg.Coupons[i].Weight, 1)
+			if draw < 0 {
+				picked = append(picked, i)
+				remaining = append(remaining[:k], remaining[k+1:]...)
+				break
+			}
+		}
+	}
+	return picked
+}
+
+// parseDate checks an optional YYYY-MM-DD date
+func parseDate(date string) (time.Time, error) {
+	if date == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(DateLayout, date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("date %q is not YYYY-MM-DD", date)
+	}
+	return t, nil
+}
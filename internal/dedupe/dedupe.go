@@ -0,0 +1,86 @@
+// Package dedupe spots a receipt sent to print twice in quick succession,
+// such as a double-clicked print button or a frontend retrying a request
+// that had in fact succeeded. Two receipts count as duplicates when they
+// have the same transaction ID and identical contents; a corrected
+// receipt for the same transaction is not a duplicate.
+package dedupe
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// What to do with a duplicate
+const (
+	Banner = "banner" // Print it with a DUPLICATE banner
+	Reject = "reject" // Refuse to print it
+)
+
+// ParseAction checks an action name; "" is Banner
+func ParseAction(name string) (string, error) {
+	switch name {
+	case "", Banner:
+		return Banner, nil
+	case Reject:
+		return Reject, nil
+	}
+	return "", fmt.Errorf("unknown duplicate action %q (use %s or %s)", name, Banner, Reject)
+}
+
+// Window remembers the receipts printed in the last Duration
+type Window struct {
+	Duration time.Duration // Zero turns detection off
+	Action   string        // Banner or Reject
+
+	mu      sync.Mutex
+	printed map[string]print
+}
+
+type print struct {
+	sum  [sha256.Size]byte
+	time time.Time
+}
+
+// New returns a window of d that handles duplicates with action
+func New(d time.Duration, action string) *Window {
+	return &Window{Duration: d, Action: action, printed: make(map[string]print)}
+}
+
+// Check reports whether a receipt with the same contents was recorded
+// for transactionID within the window, and when. Receipts without a
+// transaction ID, such as no-sales, are never duplicates.
+func (w *Window) Check(transactionID string, contents []byte) (time.Time, bool) {
+	if w == nil || w.Duration <= 0 || transactionID == "" {
+		return time.Time{}, false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire(time.Now())
+	last, ok := w.printed[transactionID]
+	if !ok || last.sum != sha256.Sum256(contents) {
+		return time.Time{}, false
+	}
+	return last.time, true
+}
+
+// Record notes that a receipt printed. Only receipts that printed are
+// recorded, so retrying one that failed isn't a duplicate.
+func (w *Window) Record(transactionID string, contents []byte) {
+	if w == nil || w.Duration <= 0 || transactionID == "" {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.printed[transactionID] = print{sum: sha256.Sum256(contents), time: time.Now()}
+}
+
+// expire forgets receipts older than the window
+func (w *Window) expire(now time.Time) {
+	for id, p := range w.printed {
+		if now.Sub(p.time) > w.Duration {
+			delete(w.printed, id)
+		}
+	}
+}
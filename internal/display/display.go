@@ -0,0 +1,85 @@
+// Package display builds commands for customer-facing pole displays
+// (two-line VFD/LCD units such as the Epson DM-D or Logic Controls
+// PD3000 in ESC/POS emulation).
+package display
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultWidth is the number of characters per line on a 2x20 display
+const DefaultWidth = 20
+
+// ESC/POS customer display commands
+const (
+	initialize    = "\x1b@"         // ESC @: reset and clear
+	overwriteMode = "\x1f\x01"      // US MD1: overwrite rather than scroll
+	clearScreen   = "\x0c"          // CLR
+	cursorHome    = "\x0b"          // HOM: top left
+	cursorLine2   = "\x1f$\x01\x02" // US $ x y: column 1, row 2
+)
+
+// Show returns the commands that replace the display contents with two
+// lines, each padded or cut to width characters
+func Show(line1, line2 string, width int) []byte {
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	var b strings.Builder
+	b.WriteString(initialize)
+	b.WriteString(overwriteMode)
+	b.WriteString(clearScreen)
+	b.WriteString(cursorHome)
+	b.WriteString(fit(line1, width))
+	b.WriteString(cursorLine2)
+	b.WriteString(fit(line2, width))
+	return []byte(b.String())
+}
+
+// Clear returns the commands that blank the display
+func Clear() []byte {
+	return []byte(initialize + clearScreen)
+}
+
+// Justify puts left and right at either end of a width-character line,
+// cutting left short when both don't fit
+func Justify(left, right string, width int) string {
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	space := width - utf8.RuneCountInString(right)
+	if space <= 0 {
+		return fit(right, width)
+	}
+	left = truncate(left, space-1)
+	if left == "" {
+		return strings.Repeat(" ", space) + right
+	}
+	return left + strings.Repeat(" ", space-utf8.RuneCountInString(left)) + right
+}
+
+// fit pads or cuts s to exactly width characters, so a shorter line
+// overwrites everything left over from the previous one
+func fit(s string, width int) string {
+	s = truncate(s, width)
+	return s + strings.Repeat(" ", width-utf8.RuneCountInString(s))
+}
+
+// truncate cuts s to at most n characters, dropping control characters
+// that would be taken as display commands
+func truncate(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
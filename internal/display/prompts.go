@@ -0,0 +1,181 @@
+package display
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"GoScanRentalTide/internal/i18n"
+)
+
+// Prompts the agent shows on its own as a checkout moves along
+const (
+	PromptScanID   = "scan_id"   // Waiting for an ID to be swiped
+	PromptWelcome  = "welcome"   // The ID was read
+	PromptTotal    = "total"     // The receipt is printing
+	PromptThankYou = "thank_you" // It printed
+)
+
+// Templates of the prompts in every language, over which a prompt
+// configuration's own are laid. t looks a string up in the line's
+// language; upper capitalises; justify puts two strings at either end
+// of the line.
+var defaultPrompts = map[string]string{
+	PromptScanID:   `{{t "display_scan_id"}}`,
+	PromptWelcome:  `{{t "display_welcome"}}`,
+	PromptTotal:    `{{justify (upper (t "total")) .Total}}`,
+	PromptThankYou: `{{t "display_thank_you"}}`,
+}
+
+// PromptConfig configures the workflow prompts, e.g.
+//
+//	{"languages": ["en", "fr"], "location": "Harbour",
+//	 "prompts": {"welcome": {"en": "Welcome to {{.Location}}"}},
+//	 "locations": {"Airport": {"thank_you": {"en": "Safe travels!", "fr": "Bon voyage!"}}}}
+type PromptConfig struct {
+	// The first language's prompt is shown on line 1, the second's on
+	// line 2 (default: English and French)
+	Languages []string `json:"languages"`
+	// Where the agent is, for prompts that aren't about a receipt
+	Location string `json:"location"`
+	// Templates by prompt and then language, over the defaults
+	Prompts PromptSet `json:"prompts"`
+	// Templates for receipts of a location, by its name, over Prompts
+	Locations map[string]PromptSet `json:"locations"`
+}
+
+// PromptSet holds prompt templates by prompt and then language
+type PromptSet map[string]map[string]string
+
+// PromptData is what prompt templates can show
+type PromptData struct {
+	Location string
+	Total    string // Formatted in the receipt currency
+}
+
+// Prompter renders the workflow prompts for a display width
+type Prompter struct {
+	languages []string
+	location  string
+	width     int
+	// Templates by location ("" for every location), prompt and language
+	templates map[string]map[string]map[string]*template.Template
+}
+
+// LoadPrompts reads a prompt configuration from a JSON file
+func LoadPrompts(path string, width int) (*Prompter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read display prompts: %v", err)
+	}
+	var cfg PromptConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse display prompts %s: %v", path, err)
+	}
+	p, err := NewPrompter(cfg, width)
+	if err != nil {
+		return nil, fmt.Errorf("invalid display prompts %s: %v", path, err)
+	}
+	return p, nil
+}
+
+// NewPrompter parses a prompt configuration's templates
+func NewPrompter(cfg PromptConfig, width int) (*Prompter, error) {
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	p := &Prompter{languages: cfg.Languages, location: cfg.Location, width: width,
+		templates: make(map[string]map[string]map[string]*template.Template)}
+	if len(p.languages) == 0 {
+		p.languages = []string{"en", "fr"}
+	}
+	if len(p.languages) > 2 {
+		return nil, fmt.Errorf("a display has two lines, for at most two languages; got %d", len(p.languages))
+	}
+	for _, lang := range p.languages {
+		if !i18n.Supported(lang) {
+			return nil, fmt.Errorf("unknown language %q", lang)
+		}
+	}
+
+	defaults := make(PromptSet)
+	for prompt, text := range defaultPrompts {
+		defaults[prompt] = make(map[string]string)
+		for _, lang := range p.languages {
+			defaults[prompt][lang] = text
+		}
+	}
+	sets := map[string]PromptSet{"": defaults}
+	for name, set := range cfg.Locations {
+		sets[locationKey(name)] = set
+	}
+	for prompt := range cfg.Prompts {
+		if _, ok := defaultPrompts[prompt]; !ok {
+			return nil, fmt.Errorf("unknown prompt %q", prompt)
+		}
+	}
+	for prompt, texts := range cfg.Prompts {
+		for lang, text := range texts {
+			defaults[prompt][lang] = text
+		}
+	}
+
+	for location, set := range sets {
+		p.templates[location] = make(map[string]map[string]*template.Template)
+		for prompt, texts := range set {
+			if _, ok := defaultPrompts[prompt]; !ok {
+				return nil, fmt.Errorf("unknown prompt %q", prompt)
+			}
+			p.templates[location][prompt] = make(map[string]*template.Template)
+			for lang, text := range texts {
+				tr := i18n.New(lang)
+				tmpl, err := template.New(prompt).Funcs(template.FuncMap{
+					"t":       tr.T,
+					"upper":   strings.ToUpper,
+					"justify": func(left, right string) string { return Justify(left, right, width) },
+				}).Parse(text)
+				if err != nil {
+					return nil, fmt.Errorf("prompt %s (%s): %v", prompt, lang, err)
+				}
+				p.templates[location][prompt][lang] = tmpl
+			}
+		}
+	}
+	return p, nil
+}
+
+// Location is where the agent is, for prompts that aren't about a receipt
+func (p *Prompter) Location() string {
+	return p.location
+}
+
+// Lines renders a prompt for a location, one line per language. A
+// location's own template is used when it has one.
+func (p *Prompter) Lines(prompt, location string, data PromptData) (string, string, error) {
+	if data.Location == "" {
+		data.Location = location
+	}
+	var lines [2]string
+	for i, lang := range p.languages {
+		tmpl := p.templates[locationKey(location)][prompt][lang]
+		if tmpl == nil {
+			tmpl = p.templates[""][prompt][lang]
+		}
+		if tmpl == nil {
+			return "", "", fmt.Errorf("unknown prompt %q", prompt)
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, data); err != nil {
+			return "", "", fmt.Errorf("prompt %s (%s): %v", prompt, lang, err)
+		}
+		lines[i] = b.String()
+	}
+	return lines[0], lines[1], nil
+}
+
+// locationKey matches location names whatever their case and spacing
+func locationKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,44 @@
+package escpos
+
+import "fmt"
+
+// Code128 returns the commands that print data as a CODE128 barcode in
+// code set B, height dots tall, with its text printed below. Code set B
+// holds printable ASCII only.
+func Code128(data string, height int) ([]byte, error) {
+	if err := CheckCode128(data); err != nil {
+		return nil, err
+	}
+
+	var cmd []byte
+	cmd = append(cmd, 0x1D, 'h', byte(min(max(height, 1), 255)))  // Height
+	cmd = append(cmd, 0x1D, 'w', 2)                               // Module width
+	cmd = append(cmd, 0x1D, 'H', 2)                               // Text below
+	cmd = append(cmd, 0x1D, 'k', 73, byte(len(data)+2), '{', 'B') // CODE128, code set B
+	cmd = append(cmd, data...)
+	return cmd, nil
+}
+
+// CheckCode128 checks that data can be printed as a CODE128 barcode in
+// code set B
+func CheckCode128(data string) error {
+	for _, r := range data {
+		if r < 0x20 || r > 0x7E {
+			return fmt.Errorf("CODE128 data %q is not printable ASCII", data)
+		}
+	}
+	if len(data) == 0 || len(data) > 253 {
+		return fmt.Errorf("CODE128 data must be 1 to 253 characters")
+	}
+	return nil
+}
+
+// DrawerKick returns the command that pulses a cash drawer connected to
+// the printer: pin 2 for the first drawer, pin 5 for the second
+func DrawerKick(pin int) []byte {
+	m := byte(0)
+	if pin == 5 {
+		m = 1
+	}
+	return []byte{0x1B, 'p', m, 25, 250} // 50ms on, 500ms off
+}
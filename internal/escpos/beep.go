@@ -0,0 +1,53 @@
+package escpos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Beep is a buzzer pattern, sounded with ESC B n t, which most printers
+// with a buzzer accept
+type Beep struct {
+	Count    int `json:"count"`    // Beeps, 1 to 9; 0 is silent
+	Duration int `json:"duration"` // Length of each beep in ms, in 50ms steps up to 450ms
+}
+
+// ParseBeep reads a pattern such as "2" or "3:200", beeps and their
+// length in ms (default: 100), or "off"
+func ParseBeep(s string) (Beep, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "off" {
+		return Beep{}, nil
+	}
+	count, duration, hasDuration := strings.Cut(s, ":")
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 || n > 9 {
+		return Beep{}, fmt.Errorf("invalid beep count %q (use 1 to 9, or off)", count)
+	}
+	beep := Beep{Count: n, Duration: 100}
+	if hasDuration {
+		ms, err := strconv.Atoi(duration)
+		if err != nil || ms < 50 || ms > 450 {
+			return Beep{}, fmt.Errorf("invalid beep length %q (use 50 to 450ms)", duration)
+		}
+		beep.Duration = ms
+	}
+	return beep, nil
+}
+
+// Commands returns the buzzer command, or "" for a silent pattern
+func (b Beep) Commands() string {
+	if b.Count <= 0 {
+		return ""
+	}
+	steps := min(max((b.Duration+25)/50, 1), 9)
+	return string([]byte{0x1B, 'B', byte(min(b.Count, 9)), byte(steps)})
+}
+
+func (b Beep) String() string {
+	if b.Count <= 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d x %dms", b.Count, b.Duration)
+}
@@ -0,0 +1,223 @@
+package escpos
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// DefaultCodePage is the code page printers select at power on
+const DefaultCodePage = "cp437"
+
+// CodePage is a character table a printer selects with ESC t. Receipt text
+// is UTF-8; Encode converts it to the table's bytes, spelling out or
+// dropping the characters the table lacks.
+type CodePage struct {
+	Name   string
+	Number byte   // n of ESC t n on Epson-compatible printers
+	high   string // Characters of bytes 0x80-0xFF; U+FFFD where a byte is unused
+	bytes  map[rune]byte
+}
+
+// Code pages by name, with the characters of their upper halves
+var codePages = map[string]*CodePage{
+	"cp437": { // PC437, the power-on default of most printers
+		Number: 0,
+		high: "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒ" +
+			"áíóúñÑªº¿⌐¬½¼¡«»░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+			"└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+			"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0",
+	},
+	"cp850": { // PC850, Western Europe
+		Number: 2,
+		high: "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜø£Ø×ƒ" +
+			"áíóúñÑªº¿®¬½¼¡«»░▒▓│┤ÁÂÀ©╣║╗╝¢¥┐" +
+			"└┴┬├─┼ãÃ╚╔╩╦╠═╬¤ðÐÊËÈıÍÎÏ┘┌█▄¦Ì▀" +
+			"ÓßÔÒõÕµþÞÚÛÙýÝ¯´\u00ad±‗¾¶§÷¸°¨·¹³²■\u00a0",
+	},
+	"cp852": { // PC852, Central Europe
+		Number: 18,
+		high: "ÇüéâäůćçłëŐőîŹÄĆÉĹĺôöĽľŚśÖÜŤťŁ×č" +
+			"áíóúĄąŽžĘę¬źČş«»░▒▓│┤ÁÂĚŞ╣║╗╝Żż┐" +
+			"└┴┬├─┼Ăă╚╔╩╦╠═╬¤đĐĎËďŇÍÎě┘┌█▄ŢŮ▀" +
+			"ÓßÔŃńňŠšŔÚŕŰýÝţ´\u00ad˝˛ˇ˘§÷¸°¨˙űŘř■\u00a0",
+	},
+	"cp858": { // PC858, PC850 with the euro sign
+		Number: 19,
+		high: "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜø£Ø×ƒ" +
+			"áíóúñÑªº¿®¬½¼¡«»░▒▓│┤ÁÂÀ©╣║╗╝¢¥┐" +
+			"└┴┬├─┼ãÃ╚╔╩╦╠═╬¤ðÐÊËÈ€ÍÎÏ┘┌█▄¦Ì▀" +
+			"ÓßÔÒõÕµþÞÚÛÙýÝ¯´\u00ad±‗¾¶§÷¸°¨·¹³²■\u00a0",
+	},
+	"cp863": { // PC863, Canadian French
+		Number: 4,
+		high: "ÇüéâÂà¶çêëèïî‗À§ÉÈÊôËÏûù¤ÔÜ¢£ÙÛƒ" +
+			"¦´óú¨¸³¯Î⌐¬½¼¾«»░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+			"└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+			"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0",
+	},
+	"cp866": { // PC866, Cyrillic
+		Number: 17,
+		high: "АБВГДЕЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯ" +
+			"абвгдежзийклмноп░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+			"└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+			"рстуфхцчшщъыьэюяЁёЄєЇїЎў°∙·√№¤■\u00a0",
+	},
+	"cp1252": { // WPC1252, Windows Latin 1
+		Number: 16,
+		high: "€\ufffd‚ƒ„…†‡ˆ‰Š‹Œ\ufffdŽ\ufffd\ufffd‘’“”•–—˜™š›œ\ufffdžŸ" +
+			"\u00a0¡¢£¤¥¦§¨©ª«¬\u00ad®¯°±²³´µ¶·¸¹º»¼½¾¿" +
+			"ÀÁÂÃÄÅÆÇÈÉÊËÌÍÎÏÐÑÒÓÔÕÖ×ØÙÚÛÜÝÞß" +
+			"àáâãäåæçèéêëìíîïðñòóôõö÷øùúûüýþÿ",
+	},
+}
+
+func init() {
+	for name, page := range codePages {
+		page.Name = name
+		page.bytes = make(map[rune]byte, 128)
+		b := 0x80
+		for _, r := range page.high {
+			if r != utf8.RuneError {
+				page.bytes[r] = byte(b)
+			}
+			b++
+		}
+		if b != 0x100 {
+			panic(fmt.Sprintf("escpos: code page %s has %d characters", name, b-0x80))
+		}
+	}
+}
+
+// fallbacks spell out letters and symbols a code page lacks: accents are
+// dropped and typographic punctuation becomes ASCII
+var fallbacks = map[rune]string{
+	'\u00a0': " ", '¡': "!", '¢': "c", '£': "GBP", '¥': "JPY", '§': "S",
+	'©': "(c)", '«': "<<", '\u00ad': "", '®': "(R)", '°': "o", '·': ".",
+	'»': ">>", '¼': "1/4", '½': "1/2", '¾': "3/4", '¿': "?", 'À': "A",
+	'Á': "A", 'Â': "A", 'Ã': "A", 'Ä': "A", 'Å': "A", 'Æ': "AE",
+	'Ç': "C", 'È': "E", 'É': "E", 'Ê': "E", 'Ë': "E", 'Ì': "I", 'Í': "I",
+	'Î': "I", 'Ï': "I", 'Ð': "D", 'Ñ': "N", 'Ò': "O", 'Ó': "O", 'Ô': "O",
+	'Õ': "O", 'Ö': "O", '×': "x", 'Ø': "O", 'Ù': "U", 'Ú': "U", 'Û': "U",
+	'Ü': "U", 'Ý': "Y", 'Þ': "Th", 'ß': "ss", 'à': "a", 'á': "a",
+	'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'æ': "ae", 'ç': "c",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ì': "i", 'í': "i", 'î': "i",
+	'ï': "i", 'ð': "d", 'ñ': "n", 'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o",
+	'ö': "o", '÷': "/", 'ø': "o", 'ù': "u", 'ú': "u", 'û': "u", 'ü': "u",
+	'ý': "y", 'þ': "th", 'ÿ': "y", 'Ā': "A", 'ā': "a", 'Ă': "A",
+	'ă': "a", 'Ą': "A", 'ą': "a", 'Ć': "C", 'ć': "c", 'Ĉ': "C", 'ĉ': "c",
+	'Ċ': "C", 'ċ': "c", 'Č': "C", 'č': "c", 'Ď': "D", 'ď': "d", 'Đ': "D",
+	'đ': "d", 'Ē': "E", 'ē': "e", 'Ĕ': "E", 'ĕ': "e", 'Ė': "E", 'ė': "e",
+	'Ę': "E", 'ę': "e", 'Ě': "E", 'ě': "e", 'Ĝ': "G", 'ĝ': "g", 'Ğ': "G",
+	'ğ': "g", 'Ġ': "G", 'ġ': "g", 'Ģ': "G", 'ģ': "g", 'Ĥ': "H", 'ĥ': "h",
+	'Ĩ': "I", 'ĩ': "i", 'Ī': "I", 'ī': "i", 'Ĭ': "I", 'ĭ': "i", 'Į': "I",
+	'į': "i", 'İ': "I", 'ı': "i", 'Ĵ': "J", 'ĵ': "j", 'Ķ': "K", 'ķ': "k",
+	'Ĺ': "L", 'ĺ': "l", 'Ļ': "L", 'ļ': "l", 'Ľ': "L", 'ľ': "l", 'Ł': "L",
+	'ł': "l", 'Ń': "N", 'ń': "n", 'Ņ': "N", 'ņ': "n", 'Ň': "N", 'ň': "n",
+	'Ō': "O", 'ō': "o", 'Ŏ': "O", 'ŏ': "o", 'Ő': "O", 'ő': "o",
+	'Œ': "OE", 'œ': "oe", 'Ŕ': "R", 'ŕ': "r", 'Ŗ': "R", 'ŗ': "r",
+	'Ř': "R", 'ř': "r", 'Ś': "S", 'ś': "s", 'Ŝ': "S", 'ŝ': "s", 'Ş': "S",
+	'ş': "s", 'Š': "S", 'š': "s", 'Ţ': "T", 'ţ': "t", 'Ť': "T", 'ť': "t",
+	'Ũ': "U", 'ũ': "u", 'Ū': "U", 'ū': "u", 'Ŭ': "U", 'ŭ': "u", 'Ů': "U",
+	'ů': "u", 'Ű': "U", 'ű': "u", 'Ų': "U", 'ų': "u", 'Ŵ': "W", 'ŵ': "w",
+	'Ŷ': "Y", 'ŷ': "y", 'Ÿ': "Y", 'Ź': "Z", 'ź': "z", 'Ż': "Z", 'ż': "z",
+	'Ž': "Z", 'ž': "z", 'Ơ': "O", 'ơ': "o", 'Ư': "U", 'ư': "u", 'Ǎ': "A",
+	'ǎ': "a", 'Ǐ': "I", 'ǐ': "i", 'Ǒ': "O", 'ǒ': "o", 'Ǔ': "U", 'ǔ': "u",
+	'Ǖ': "U", 'ǖ': "u", 'Ǘ': "U", 'ǘ': "u", 'Ǚ': "U", 'ǚ': "u", 'Ǜ': "U",
+	'ǜ': "u", 'Ǟ': "A", 'ǟ': "a", 'Ǡ': "A", 'ǡ': "a", 'Ǧ': "G", 'ǧ': "g",
+	'Ǩ': "K", 'ǩ': "k", 'Ǫ': "O", 'ǫ': "o", 'Ǭ': "O", 'ǭ': "o", 'ǰ': "j",
+	'Ǵ': "G", 'ǵ': "g", 'Ǹ': "N", 'ǹ': "n", 'Ǻ': "A", 'ǻ': "a", 'Ȁ': "A",
+	'ȁ': "a", 'Ȃ': "A", 'ȃ': "a", 'Ȅ': "E", 'ȅ': "e", 'Ȇ': "E", 'ȇ': "e",
+	'Ȉ': "I", 'ȉ': "i", 'Ȋ': "I", 'ȋ': "i", 'Ȍ': "O", 'ȍ': "o", 'Ȏ': "O",
+	'ȏ': "o", 'Ȑ': "R", 'ȑ': "r", 'Ȓ': "R", 'ȓ': "r", 'Ȕ': "U", 'ȕ': "u",
+	'Ȗ': "U", 'ȗ': "u", 'Ș': "S", 'ș': "s", 'Ț': "T", 'ț': "t", 'Ȟ': "H",
+	'ȟ': "h", 'Ȧ': "A", 'ȧ': "a", 'Ȩ': "E", 'ȩ': "e", 'Ȫ': "O", 'ȫ': "o",
+	'Ȭ': "O", 'ȭ': "o", 'Ȯ': "O", 'ȯ': "o", 'Ȱ': "O", 'ȱ': "o", 'Ȳ': "Y",
+	'ȳ': "y", '–': "-", '—': "-", '‘': "'", '’': "'", '‚': "'",
+	'“': "\"", '”': "\"", '„': "\"", '•': "*", '…': "...", '′': "'",
+	'″': "\"", '€': "EUR", '™': "TM", '−': "-", '✓': "*", '✔': "*",
+	'✗': "x", '✘': "x",
+}
+
+// CodePages lists the supported code page names, for help text
+func CodePages() []string {
+	var names []string
+	for name := range codePages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupCodePage finds a code page by name, such as "cp858" or "858"
+func LookupCodePage(name string) (*CodePage, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if !strings.HasPrefix(key, "cp") {
+		key = "cp" + key
+	}
+	page, ok := codePages[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown code page %q (use %s)", name, strings.Join(CodePages(), ", "))
+	}
+	return page, nil
+}
+
+// Select returns the command that switches the printer to the code page.
+// ESC @ resets it, so it goes after every reset.
+func (p *CodePage) Select() string {
+	return string([]byte{0x1b, 't', p.Number})
+}
+
+// Transliterate returns text with only the characters the code page can
+// print, still as UTF-8, so lines can be measured and padded before they
+// are encoded. Characters without a fallback print as "?", and emoji are
+// dropped along with the space that follows them.
+func (p *CodePage) Transliterate(text string) string {
+	var b strings.Builder
+	skipSpace := false
+	for _, r := range text {
+		if skipSpace && r == ' ' {
+			skipSpace = false
+			continue
+		}
+		skipSpace = false
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case p.bytes[r] != 0:
+			b.WriteRune(r)
+		case fallbacks[r] != "" || r == '\u00ad':
+			b.WriteString(p.Transliterate(fallbacks[r]))
+		case isEmoji(r):
+			skipSpace = true
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Encode converts UTF-8 text to the code page's bytes
+func (p *CodePage) Encode(text string) []byte {
+	text = p.Transliterate(text)
+	encoded := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			encoded = append(encoded, byte(r))
+		} else {
+			encoded = append(encoded, p.bytes[r])
+		}
+	}
+	return encoded
+}
+
+// isEmoji reports pictographs and the joiners and modifiers emoji are
+// built from, none of which thermal printers have
+func isEmoji(r rune) bool {
+	return r >= 0x1F000 || // Emoji, pictographs and skin tones
+		(r >= 0x2600 && r <= 0x27BF) || // Miscellaneous symbols and dingbats
+		(r >= 0x2B00 && r <= 0x2BFF) || // Arrows and stars
+		(r >= 0xFE00 && r <= 0xFE0F) || // Variation selectors
+		r == 0x200D || r == 0x20E3 // Zero width joiner, keycap
+}
@@ -0,0 +1,80 @@
+package escpos
+
+// Alignment is the justification of the lines that follow it
+type Alignment byte
+
+const (
+	AlignLeft Alignment = iota
+	AlignCenter
+	AlignRight
+)
+
+// CommandSet is the command language of a printer. Documents are built
+// from its commands rather than from raw bytes, so a printer that doesn't
+// speak ESC/POS only needs a CommandSet of its own.
+type CommandSet interface {
+	// Name is the command set's name for messages, e.g. "ESC/POS"
+	Name() string
+	// Init resets the printer and selects the code page, which the reset
+	// clears
+	Init(page *CodePage) string
+	Align(align Alignment) string
+	Bold(on bool) string
+	Underline(on bool) string
+	// Invert prints white on black
+	Invert(on bool) string
+	// Size magnifies characters width and height times, 1 to 8; 1, 1 is
+	// the normal size
+	Size(width, height int) string
+	Cut(cut Cut) string
+	Beep(beep Beep) string
+	// Raster converts GS v 0 raster commands, as Raster and LogoCache
+	// return them, into the printer's own
+	Raster(raster []byte) []byte
+	QRCode(data string, moduleSize int) []byte
+	Code128(data string, height int) ([]byte, error)
+	DrawerKick(pin int) []byte
+}
+
+// ESCPOS is the command set of Epson printers and the many printers that
+// emulate them
+var ESCPOS CommandSet = escPOS{}
+
+type escPOS struct{}
+
+func (escPOS) Name() string { return "ESC/POS" }
+
+func (escPOS) Init(page *CodePage) string { return "\x1B@" + page.Select() }
+
+func (escPOS) Align(align Alignment) string { return "\x1Ba" + string(rune(align)) }
+
+func (escPOS) Bold(on bool) string { return "\x1BE" + flag(on) }
+
+func (escPOS) Underline(on bool) string { return "\x1B-" + flag(on) }
+
+func (escPOS) Invert(on bool) string { return "\x1DB" + flag(on) }
+
+// Size is GS !, with the width in the high nibble
+func (escPOS) Size(width, height int) string {
+	width, height = min(max(width, 1), 8), min(max(height, 1), 8)
+	return string([]byte{0x1D, '!', byte((width-1)<<4 | (height - 1))})
+}
+
+func (escPOS) Cut(cut Cut) string { return cut.Commands() }
+
+func (escPOS) Beep(beep Beep) string { return beep.Commands() }
+
+func (escPOS) Raster(raster []byte) []byte { return raster }
+
+func (escPOS) QRCode(data string, moduleSize int) []byte { return QRCode(data, moduleSize) }
+
+func (escPOS) Code128(data string, height int) ([]byte, error) { return Code128(data, height) }
+
+func (escPOS) DrawerKick(pin int) []byte { return DrawerKick(pin) }
+
+func flag(on bool) string {
+	if on {
+		return "\x01"
+	}
+	return "\x00"
+}
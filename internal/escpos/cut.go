@@ -0,0 +1,69 @@
+package escpos
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Cut modes
+const (
+	FullCut    = "full"
+	PartialCut = "partial" // Leaves a tab so the receipt doesn't fall
+	NoCut      = "none"    // For printers without a cutter, torn off by hand
+)
+
+// Cut is how a printer finishes a document: lines fed so the last line
+// clears the print head, then the cut
+type Cut struct {
+	Mode string `json:"mode"` // full, partial or none
+	Feed int    `json:"feed"` // Lines fed before the cut
+}
+
+// DefaultCut is what documents have always ended with
+var DefaultCut = Cut{Mode: PartialCut, Feed: 3}
+
+// ParseCut reads a cut such as "partial", "full:5" or "none:6", where
+// the number is the lines fed first (default: 3)
+func ParseCut(s string) (Cut, error) {
+	mode, feed, hasFeed := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	cut := Cut{Mode: mode, Feed: DefaultCut.Feed}
+	switch mode {
+	case FullCut, PartialCut, NoCut:
+	default:
+		return Cut{}, fmt.Errorf("unknown cut mode %q (use full, partial or none)", mode)
+	}
+	if hasFeed {
+		n, err := strconv.Atoi(feed)
+		if err != nil || n < 0 || n > 20 {
+			return Cut{}, fmt.Errorf("invalid feed %q (use 0 to 20 lines)", feed)
+		}
+		cut.Feed = n
+	}
+	return cut, nil
+}
+
+// OrDefault returns the cut, or DefaultCut when it isn't set
+func (c Cut) OrDefault() Cut {
+	if c.Mode == "" {
+		return DefaultCut
+	}
+	return c
+}
+
+// Commands returns the feed and the cut. The cut is GS V function B,
+// which also advances the paper to the cutter.
+func (c Cut) Commands() string {
+	commands := strings.Repeat("\n", max(c.Feed, 0))
+	switch c.Mode {
+	case FullCut:
+		commands += "\x1DVA\x00"
+	case PartialCut:
+		commands += "\x1DVB\x00"
+	}
+	return commands
+}
+
+func (c Cut) String() string {
+	return fmt.Sprintf("%s, %d feed lines", c.Mode, c.Feed)
+}
@@ -0,0 +1,142 @@
+package escpos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"  // Register GIF logos
+	_ "image/jpeg" // Register JPEG logos
+	_ "image/png"  // Register PNG logos
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxLogoBytes guards against downloading something that isn't a logo
+const maxLogoBytes = 5 << 20
+
+// maxLogoPixels bounds the decoded size of a logo. A small compressed
+// file can declare an enormous image, which decoding would allocate.
+const maxLogoPixels = 16 << 20
+
+// LogoCache downloads logos and keeps their converted raster commands on
+// disk, so each logo is only fetched and dithered once per width
+type LogoCache struct {
+	dir    string
+	client *http.Client
+	mu     sync.Mutex
+}
+
+// NewLogoCache stores converted logos in dir, which is created on first use
+func NewLogoCache(dir string) *LogoCache {
+	return &LogoCache{
+		dir:    dir,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Raster returns the GS v 0 commands for the logo at url scaled to fit
+// width dots, converting and caching it on first use
+func (c *LogoCache) Raster(url string, width int) ([]byte, error) {
+	return c.raster(url, width, func() (image.Image, error) { return c.download(url) })
+}
+
+// RasterFile is Raster for a logo in a local file. The file's size and
+// modification time are part of the cache key, so a replaced logo is
+// converted again.
+func (c *LogoCache) RasterFile(path string, width int) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logo: %v", err)
+	}
+	key := "file:" + path + "\x00" + strconv.FormatInt(info.Size(), 10) + "\x00" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	return c.raster(key, width, func() (image.Image, error) { return readLogo(path) })
+}
+
+// raster returns the cached commands for the logo named key, loading and
+// converting it when they aren't cached yet
+func (c *LogoCache) raster(key string, width int, load func() (image.Image, error)) ([]byte, error) {
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	sum := sha256.Sum256([]byte(key + "\x00" + strconv.Itoa(width)))
+	path := filepath.Join(c.dir, hex.EncodeToString(sum[:])+".bin")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	}
+
+	img, err := load()
+	if err != nil {
+		return nil, err
+	}
+	data := Raster(img, width)
+
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create logo cache: %v", err)
+	}
+	// Write then rename so a crash never leaves a truncated logo behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to cache logo: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("failed to cache logo: %v", err)
+	}
+	return data, nil
+}
+
+// download fetches and decodes a logo image
+func (c *LogoCache) download(url string) (image.Image, error) {
+	resp, err := c.client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download logo: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download logo %s: %s", url, resp.Status)
+	}
+
+	return decodeLogo(resp.Body, url)
+}
+
+// readLogo decodes a logo image file
+func readLogo(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logo: %v", err)
+	}
+	defer f.Close()
+
+	return decodeLogo(f, filepath.Base(path))
+}
+
+// decodeLogo decodes a logo image named name, checking the dimensions
+// its header declares before decoding it
+func decodeLogo(r io.Reader, name string) (image.Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxLogoBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read logo %s: %v", name, err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode logo %s: %v", name, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxLogoPixels/config.Height {
+		return nil, fmt.Errorf("logo %s is %dx%d pixels, more than the %d megapixels a logo may be", name, config.Width, config.Height, maxLogoPixels>>20)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode logo %s: %v", name, err)
+	}
+	return img, nil
+}
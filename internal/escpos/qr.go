@@ -0,0 +1,18 @@
+package escpos
+
+// QRCode returns the commands that print data as a QR code (model 2,
+// error correction level M) using the printer's own encoder. moduleSize
+// is the width of a module in dots, 1 to 16.
+func QRCode(data string, moduleSize int) []byte {
+	moduleSize = min(max(moduleSize, 1), 16)
+	store := len(data) + 3
+
+	var cmd []byte
+	cmd = append(cmd, 0x1D, '(', 'k', 4, 0, 49, 65, 50, 0)                     // Model 2
+	cmd = append(cmd, 0x1D, '(', 'k', 3, 0, 49, 67, byte(moduleSize))          // Module size
+	cmd = append(cmd, 0x1D, '(', 'k', 3, 0, 49, 69, 49)                        // Error correction M
+	cmd = append(cmd, 0x1D, '(', 'k', byte(store), byte(store>>8), 49, 80, 48) // Store the data
+	cmd = append(cmd, data...)
+	cmd = append(cmd, 0x1D, '(', 'k', 3, 0, 49, 81, 48) // Print it
+	return cmd
+}
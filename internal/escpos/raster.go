@@ -0,0 +1,155 @@
+// Package escpos converts images for ESC/POS thermal printers, caches
+// the converted logos on disk, builds barcode, QR code, cash drawer,
+// paper cut and buzzer commands and encodes text in the printers' code
+// pages. CommandSet lets printers with other command languages print
+// the same documents.
+package escpos
+
+import (
+	"image"
+	"image/color"
+)
+
+// DefaultWidth is the printable width in dots of a 58mm printer, the
+// narrowest the receipt layout targets (32 columns of 12-dot text)
+const DefaultWidth = 384
+
+// maxHeight bounds how tall an image prints, in dots (about 25cm on a
+// 203 dpi printer); a taller one is scaled down to fit
+const maxHeight = 2048
+
+// bandHeight keeps each raster command small enough for printers with
+// limited receive buffers
+const bandHeight = 256
+
+// Raster scales img down to at most maxWidth dots, dithers it to black
+// and white and returns GS v 0 raster bit-image commands that print it.
+// Transparent areas print as white paper.
+func Raster(img image.Image, maxWidth int) []byte {
+	if maxWidth <= 0 {
+		maxWidth = DefaultWidth
+	}
+	gray := scaleGray(img, maxWidth)
+	bits := dither(gray)
+	return rasterCommands(bits, gray.Rect.Dx(), gray.Rect.Dy())
+}
+
+// scaleGray converts img to grayscale, shrinking it by area averaging so
+// it fits in maxWidth and maxHeight. Images are never enlarged.
+func scaleGray(img image.Image, maxWidth int) *image.Gray {
+	src := img.Bounds()
+	width, height := src.Dx(), src.Dy()
+	if width > maxWidth {
+		height = height * maxWidth / width
+		width = maxWidth
+	}
+	if height > maxHeight {
+		width = width * maxHeight / height
+		height = maxHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	out := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := src.Min.Y + y*src.Dy()/height
+		y1 := src.Min.Y + (y+1)*src.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := src.Min.X + x*src.Dx()/width
+			x1 := src.Min.X + (x+1)*src.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					sum += luminance(img.At(sx, sy))
+					n++
+				}
+			}
+			out.SetGray(x, y, color.Gray{Y: uint8(sum / n)})
+		}
+	}
+	return out
+}
+
+// luminance returns the 8-bit brightness of c composited over white
+func luminance(c color.Color) uint32 {
+	r, g, b, a := c.RGBA()
+	// Premultiplied: add the white showing through transparent areas
+	white := 0xffff - a
+	y := (299*(r+white) + 587*(g+white) + 114*(b+white)) / 1000
+	return y >> 8
+}
+
+// dither reduces gray to one bit per pixel with Floyd-Steinberg error
+// diffusion; true means a black dot
+func dither(gray *image.Gray) [][]bool {
+	width, height := gray.Rect.Dx(), gray.Rect.Dy()
+	errs := make([][]int, height)
+	for y := range errs {
+		errs[y] = make([]int, width)
+		for x := range errs[y] {
+			errs[y][x] = int(gray.GrayAt(x, y).Y)
+		}
+	}
+
+	bits := make([][]bool, height)
+	for y := 0; y < height; y++ {
+		bits[y] = make([]bool, width)
+		for x := 0; x < width; x++ {
+			old := errs[y][x]
+			value := 255
+			if old < 128 {
+				value = 0
+				bits[y][x] = true
+			}
+			diff := old - value
+			if x+1 < width {
+				errs[y][x+1] += diff * 7 / 16
+			}
+			if y+1 < height {
+				if x > 0 {
+					errs[y+1][x-1] += diff * 3 / 16
+				}
+				errs[y+1][x] += diff * 5 / 16
+				if x+1 < width {
+					errs[y+1][x+1] += diff * 1 / 16
+				}
+			}
+		}
+	}
+	return bits
+}
+
+// rasterCommands packs the dots into GS v 0 commands, one per band of rows
+func rasterCommands(bits [][]bool, width, height int) []byte {
+	bytesPerRow := (width + 7) / 8
+	var out []byte
+	for top := 0; top < height; top += bandHeight {
+		rows := height - top
+		if rows > bandHeight {
+			rows = bandHeight
+		}
+		out = append(out, 0x1D, 'v', '0', 0,
+			byte(bytesPerRow), byte(bytesPerRow>>8),
+			byte(rows), byte(rows>>8))
+		for y := top; y < top+rows; y++ {
+			row := make([]byte, bytesPerRow)
+			for x, black := range bits[y] {
+				if black {
+					row[x/8] |= 0x80 >> (x % 8)
+				}
+			}
+			out = append(out, row...)
+		}
+	}
+	return out
+}
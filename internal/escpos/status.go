@@ -0,0 +1,76 @@
+package escpos
+
+import "fmt"
+
+// Status is what a printer reports about its paper, cover and cutter
+type Status struct {
+	PaperLow    bool `json:"paperLow"`    // The roll is near its end
+	PaperOut    bool `json:"paperOut"`    // Printing stopped for paper
+	CoverOpen   bool `json:"coverOpen"`   // Printing stopped until it's closed
+	CutterError bool `json:"cutterError"` // Usually a jam; cleared by opening the cover
+	Error       bool `json:"error"`       // Another error, e.g. an overheated head
+}
+
+// Alert names, as Alerts returns them
+const (
+	AlertPaperLow    = "paper_low"
+	AlertPaperOut    = "paper_out"
+	AlertCoverOpen   = "cover_open"
+	AlertCutterError = "cutter_error"
+	AlertError       = "error"
+)
+
+// AlertNames lists every alert, in the order Alerts returns them
+var AlertNames = []string{AlertPaperLow, AlertPaperOut, AlertCoverOpen, AlertCutterError, AlertError}
+
+// Alerts returns the names of the conditions that need staff, most
+// routine first
+func (st Status) Alerts() []string {
+	var alerts []string
+	for i, on := range []bool{st.PaperLow, st.PaperOut, st.CoverOpen, st.CutterError, st.Error} {
+		if on {
+			alerts = append(alerts, AlertNames[i])
+		}
+	}
+	return alerts
+}
+
+// StatusReporter is a CommandSet whose printers answer status requests
+// over a two-way connection (a TCP socket or serial port)
+type StatusReporter interface {
+	// StatusRequest returns the bytes that ask for the status, and how
+	// many bytes the printer answers with
+	StatusRequest() ([]byte, int)
+	ParseStatus(reply []byte) (Status, error)
+}
+
+// DLE EOT n asks for one status byte in real time, even while the printer
+// is offline or waiting for paper, without enabling Automatic Status Back
+const dleEOT = "\x10\x04"
+
+// StatusRequest asks for the offline cause (n = 2), the error cause
+// (n = 3) and the roll paper sensor (n = 4)
+func (escPOS) StatusRequest() ([]byte, int) {
+	return []byte(dleEOT + "\x02" + dleEOT + "\x03" + dleEOT + "\x04"), 3
+}
+
+// ParseStatus decodes the replies to StatusRequest. Each has bits 1 and 4
+// set and bits 0 and 7 clear, which tells a status byte from stray data.
+func (escPOS) ParseStatus(reply []byte) (Status, error) {
+	if len(reply) != 3 {
+		return Status{}, fmt.Errorf("status reply has %d bytes, want 3", len(reply))
+	}
+	for _, b := range reply {
+		if b&0x93 != 0x12 {
+			return Status{}, fmt.Errorf("invalid status reply % x", reply)
+		}
+	}
+	offline, cause, paper := reply[0], reply[1], reply[2]
+	return Status{
+		CoverOpen:   offline&0x04 != 0,
+		PaperOut:    offline&0x20 != 0 || paper&0x60 != 0,
+		PaperLow:    paper&0x0C != 0,
+		CutterError: cause&0x08 != 0,
+		Error:       cause&0x60 != 0,
+	}, nil
+}
@@ -0,0 +1,22 @@
+// Package glcode holds the general ledger summary of an account
+// settlement: the amount posted to each GL code, which bookkeepers
+// reconcile settlement receipts against.
+package glcode
+
+import "GoScanRentalTide/internal/money"
+
+// Line is the part of a settlement posted to one GL code
+type Line struct {
+	Code        string      `json:"glCode"`
+	Description string      `json:"description,omitempty"`
+	Amount      money.Cents `json:"amount"`
+}
+
+// Total adds up the lines, for the subtotal printed under them
+func Total(lines []Line) money.Cents {
+	var total money.Cents
+	for _, l := range lines {
+		total += l.Amount
+	}
+	return total
+}
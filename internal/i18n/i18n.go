@@ -0,0 +1,492 @@
+// Package i18n holds the per-language strings printed on receipts.
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultLanguage is used when a receipt doesn't ask for a language, and
+// for any string missing from another language's table
+const DefaultLanguage = "en"
+
+var tables = map[string]map[string]string{
+	"en": {
+		"receipt":                "Receipt",
+		"receipt_number":         "Receipt No.",
+		"store":                  "Store",
+		"no_sale":                "NO SALE",
+		"duplicate":              "DUPLICATE",
+		"copy_of":                "Copy %d of %d",
+		"refund":                 "REFUND",
+		"customer":               "Customer",
+		"served_by":              "Served by",
+		"station":                "Station",
+		"verified_id":            "Verified ID",
+		"transaction":            "Transaction",
+		"transaction_id":         "Transaction ID",
+		"refund_id":              "Refund ID",
+		"original_transaction":   "Original Transaction",
+		"payment":                "Payment",
+		"items":                  "Items",
+		"returned_items":         "Returned Items",
+		"sku":                    "SKU",
+		"tax_exempt":             "Tax exempt",
+		"tax_code":               "Tax code",
+		"taxable":                "Taxable",
+		"net":                    "Net",
+		"gross":                  "Gross",
+		"held":                   "Held",
+		"total_held":             "Total Held",
+		"deposits_returned":      "Deposits Returned",
+		"total_returned":         "Total Returned",
+		"item_rental":            "Rental",
+		"item_waiver":            "Damage waiver",
+		"item_fee":               "Fee",
+		"out":                    "Out",
+		"due_back":               "Due back",
+		"due_back_banner":        "PLEASE RETURN BY %s",
+		"unit_hour":              "hr",
+		"unit_day":               "day",
+		"unit_week":              "wk",
+		"unit_month":             "mo",
+		"merchant_copy":          "MERCHANT COPY",
+		"customer_copy":          "CUSTOMER COPY",
+		"amount":                 "Amount",
+		"agree_to_pay":           "I agree to pay the above total according to my card issuer agreement",
+		"subtotal":               "Subtotal",
+		"discount":               "Discount",
+		"promo_discount":         "Promo Discount",
+		"tax":                    "Tax",
+		"tip":                    "Tip",
+		"account_settlement":     "Account Settlement",
+		"total":                  "TOTAL",
+		"refund_total":           "REFUND TOTAL",
+		"cash":                   "Cash",
+		"cash_given":             "Cash Given",
+		"cash_details":           "Cash Details",
+		"change":                 "Change",
+		"payment_details":        "Payment Details",
+		"refund_details":         "Refund Details",
+		"payment_method":         "Payment Method",
+		"refunded_to":            "Refunded To",
+		"card":                   "Card",
+		"auth_code":              "Auth Code",
+		"terminal_id":            "Terminal ID",
+		"account_information":    "Account Information",
+		"account_id":             "Account ID",
+		"account_name":           "Account Name",
+		"previous_balance":       "Previous Balance",
+		"new_balance":            "New Balance",
+		"gl_summary":             "GL Code Summary",
+		"loyalty_points":         "Loyalty Points",
+		"points_earned":          "Points earned",
+		"points_redeemed":        "Points redeemed",
+		"points_balance":         "Points balance",
+		"fully_settled":          "Fully Settled",
+		"settlement_transaction": "Account Settlement Transaction",
+		"combined_transaction":   "Combined Retail & Settlement Transaction",
+		"retail_transaction":     "Retail Transaction",
+		"retail_purchase":        "Retail Purchase",
+		"retail_subtotal":        "Retail Subtotal",
+		"payment_on_account":     "Payment on Account",
+		"settlement_subtotal":    "Settlement Subtotal",
+		"thank_you":              "Thank you for your purchase!",
+		"visit_again":            "Visit us again at %s",
+		"coupon_expires":         "Expires %s",
+		"refund_processed":       "Refund processed",
+		"keep_receipt":           "Please keep this receipt for your records",
+		"customer_signature":     "Customer signature",
+		"order":                  "Order",
+		"notes":                  "Notes",
+		"rental_agreement":       "Rental Agreement",
+		"agreement_number":       "Agreement No.",
+		"renter":                 "Renter",
+		"license_number":         "Driver's Licence",
+		"date_of_birth":          "Date of Birth",
+		"rental_period":          "Rental Period",
+		"equipment":              "Equipment",
+		"asset_tag":              "Asset Tag",
+		"quantity":               "Qty",
+		"rate":                   "Rate",
+		"deposit":                "Deposit",
+		"terms_and_conditions":   "Terms and Conditions",
+		"waiver":                 "Release of Liability",
+		"renter_signature":       "Renter's signature",
+		"date":                   "Date",
+		"invoice":                "Invoice",
+		"invoice_number":         "Invoice No.",
+		"bill_to":                "Bill To",
+		"billing_period":         "Billing Period",
+		"due_date":               "Due Date",
+		"payment_terms":          "Payment Terms",
+		"description":            "Description",
+		"unit_price":             "Unit Price",
+		"amount_paid":            "Amount Paid",
+		"balance_due":            "Balance Due",
+		"statement":              "Account Statement",
+		"statement_date":         "Statement Date",
+		"opening_balance":        "Opening Balance",
+		"closing_balance":        "Closing Balance",
+		"charges":                "Charges",
+		"payments":               "Payments",
+		"balance":                "Balance",
+		"balance_history":        "Balance History",
+		"reference":              "Reference",
+		"period":                 "Period",
+		"display_welcome":        "WELCOME",
+		"display_scan_id":        "PLEASE SCAN YOUR ID",
+		"display_thank_you":      "THANK YOU",
+	},
+	"fr": {
+		"receipt":                "Reçu",
+		"receipt_number":         "Reçu no",
+		"store":                  "Magasin",
+		"no_sale":                "AUCUNE VENTE",
+		"duplicate":              "DUPLICATA",
+		"copy_of":                "Copie %d sur %d",
+		"refund":                 "REMBOURSEMENT",
+		"customer":               "Client",
+		"served_by":              "Servi par",
+		"station":                "Poste",
+		"verified_id":            "Pièce d'identité vérifiée",
+		"transaction":            "Transaction",
+		"transaction_id":         "No de transaction",
+		"refund_id":              "No de remboursement",
+		"original_transaction":   "Transaction d'origine",
+		"payment":                "Paiement",
+		"items":                  "Articles",
+		"returned_items":         "Articles retournés",
+		"sku":                    "UGS",
+		"tax_exempt":             "Exonéré de taxes",
+		"tax_code":               "Code de taxe",
+		"taxable":                "Taxable",
+		"net":                    "Net",
+		"gross":                  "Brut",
+		"held":                   "Retenu",
+		"total_held":             "Total retenu",
+		"deposits_returned":      "Dépôts remboursés",
+		"total_returned":         "Total remboursé",
+		"item_rental":            "Location",
+		"item_waiver":            "Exonération des dommages",
+		"item_fee":               "Frais",
+		"out":                    "Sortie",
+		"due_back":               "Retour prévu",
+		"due_back_banner":        "À RETOURNER AVANT LE %s",
+		"unit_hour":              "h",
+		"unit_day":               "jour",
+		"unit_week":              "sem.",
+		"unit_month":             "mois",
+		"merchant_copy":          "COPIE DU MARCHAND",
+		"customer_copy":          "COPIE DU CLIENT",
+		"amount":                 "Montant",
+		"agree_to_pay":           "Je m'engage à payer le total ci-dessus selon l'entente avec l'émetteur de ma carte",
+		"subtotal":               "Sous-total",
+		"discount":               "Rabais",
+		"promo_discount":         "Rabais promotionnel",
+		"tax":                    "Taxes",
+		"tip":                    "Pourboire",
+		"account_settlement":     "Règlement de compte",
+		"total":                  "TOTAL",
+		"refund_total":           "TOTAL REMBOURSÉ",
+		"cash":                   "Comptant",
+		"cash_given":             "Montant reçu",
+		"cash_details":           "Détails comptant",
+		"change":                 "Monnaie",
+		"payment_details":        "Détails du paiement",
+		"refund_details":         "Détails du remboursement",
+		"payment_method":         "Mode de paiement",
+		"refunded_to":            "Remboursé sur",
+		"card":                   "Carte",
+		"auth_code":              "Code d'autorisation",
+		"terminal_id":            "No de terminal",
+		"account_information":    "Renseignements du compte",
+		"account_id":             "No de compte",
+		"account_name":           "Nom du compte",
+		"previous_balance":       "Solde précédent",
+		"new_balance":            "Nouveau solde",
+		"gl_summary":             "Sommaire des comptes GL",
+		"loyalty_points":         "Points fidélité",
+		"points_earned":          "Points gagnés",
+		"points_redeemed":        "Points utilisés",
+		"points_balance":         "Solde de points",
+		"fully_settled":          "Entièrement réglé",
+		"settlement_transaction": "Règlement de compte",
+		"combined_transaction":   "Achat et règlement combinés",
+		"retail_transaction":     "Vente au détail",
+		"retail_purchase":        "Achat au détail",
+		"retail_subtotal":        "Sous-total de l'achat",
+		"payment_on_account":     "Paiement au compte",
+		"settlement_subtotal":    "Sous-total du règlement",
+		"thank_you":              "Merci de votre achat!",
+		"visit_again":            "Au plaisir de vous revoir à %s",
+		"coupon_expires":         "Expire le %s",
+		"refund_processed":       "Remboursement effectué",
+		"keep_receipt":           "Veuillez conserver ce reçu",
+		"customer_signature":     "Signature du client",
+		"order":                  "Commande",
+		"notes":                  "Remarques",
+		"rental_agreement":       "Contrat de location",
+		"agreement_number":       "No de contrat",
+		"renter":                 "Locataire",
+		"license_number":         "Permis de conduire",
+		"date_of_birth":          "Date de naissance",
+		"rental_period":          "Période de location",
+		"equipment":              "Équipement",
+		"asset_tag":              "No d'inventaire",
+		"quantity":               "Qté",
+		"rate":                   "Tarif",
+		"deposit":                "Dépôt",
+		"terms_and_conditions":   "Conditions générales",
+		"waiver":                 "Renonciation de responsabilité",
+		"renter_signature":       "Signature du locataire",
+		"date":                   "Date",
+		"invoice":                "Facture",
+		"invoice_number":         "Facture no",
+		"bill_to":                "Facturer à",
+		"billing_period":         "Période de facturation",
+		"due_date":               "Date d'échéance",
+		"payment_terms":          "Conditions de paiement",
+		"description":            "Description",
+		"unit_price":             "Prix unitaire",
+		"amount_paid":            "Montant payé",
+		"balance_due":            "Solde dû",
+		"statement":              "Relevé de compte",
+		"statement_date":         "Date du relevé",
+		"opening_balance":        "Solde d'ouverture",
+		"closing_balance":        "Solde de clôture",
+		"charges":                "Débits",
+		"payments":               "Paiements",
+		"balance":                "Solde",
+		"balance_history":        "Historique du solde",
+		"reference":              "Référence",
+		"period":                 "Période",
+		"display_welcome":        "BIENVENUE",
+		"display_scan_id":        "SCANNEZ VOTRE ID",
+		"display_thank_you":      "MERCI",
+	},
+	"es": {
+		"receipt":                "Recibo",
+		"receipt_number":         "Recibo n.º",
+		"store":                  "Tienda",
+		"no_sale":                "SIN VENTA",
+		"duplicate":              "DUPLICADO",
+		"copy_of":                "Copia %d de %d",
+		"refund":                 "REEMBOLSO",
+		"customer":               "Cliente",
+		"served_by":              "Le atendió",
+		"station":                "Estación",
+		"verified_id":            "ID verificada",
+		"transaction":            "Transacción",
+		"transaction_id":         "ID de transacción",
+		"refund_id":              "ID de reembolso",
+		"original_transaction":   "Transacción original",
+		"payment":                "Pago",
+		"items":                  "Artículos",
+		"returned_items":         "Artículos devueltos",
+		"sku":                    "SKU",
+		"tax_exempt":             "Exento de impuestos",
+		"tax_code":               "Código de impuesto",
+		"taxable":                "Gravable",
+		"net":                    "Neto",
+		"gross":                  "Bruto",
+		"held":                   "Retenido",
+		"total_held":             "Total retenido",
+		"deposits_returned":      "Depósitos devueltos",
+		"total_returned":         "Total devuelto",
+		"item_rental":            "Alquiler",
+		"item_waiver":            "Exención por daños",
+		"item_fee":               "Cargo",
+		"out":                    "Salida",
+		"due_back":               "Devolución",
+		"due_back_banner":        "DEVOLVER ANTES DEL %s",
+		"unit_hour":              "h",
+		"unit_day":               "día",
+		"unit_week":              "sem.",
+		"unit_month":             "mes",
+		"merchant_copy":          "COPIA DEL COMERCIO",
+		"customer_copy":          "COPIA DEL CLIENTE",
+		"amount":                 "Importe",
+		"agree_to_pay":           "Acepto pagar el total anterior según el contrato con el emisor de mi tarjeta",
+		"subtotal":               "Subtotal",
+		"discount":               "Descuento",
+		"promo_discount":         "Descuento promocional",
+		"tax":                    "Impuestos",
+		"tip":                    "Propina",
+		"account_settlement":     "Liquidación de cuenta",
+		"total":                  "TOTAL",
+		"refund_total":           "TOTAL REEMBOLSADO",
+		"cash":                   "Efectivo",
+		"cash_given":             "Efectivo recibido",
+		"cash_details":           "Detalles en efectivo",
+		"change":                 "Cambio",
+		"payment_details":        "Detalles del pago",
+		"refund_details":         "Detalles del reembolso",
+		"payment_method":         "Método de pago",
+		"refunded_to":            "Reembolsado a",
+		"card":                   "Tarjeta",
+		"auth_code":              "Código de autorización",
+		"terminal_id":            "ID de terminal",
+		"account_information":    "Información de la cuenta",
+		"account_id":             "ID de cuenta",
+		"account_name":           "Nombre de la cuenta",
+		"previous_balance":       "Saldo anterior",
+		"new_balance":            "Saldo nuevo",
+		"gl_summary":             "Resumen de cuentas contables",
+		"loyalty_points":         "Puntos de fidelidad",
+		"points_earned":          "Puntos ganados",
+		"points_redeemed":        "Puntos canjeados",
+		"points_balance":         "Saldo de puntos",
+		"fully_settled":          "Liquidado",
+		"settlement_transaction": "Liquidación de cuenta",
+		"combined_transaction":   "Venta y liquidación combinadas",
+		"retail_transaction":     "Venta al por menor",
+		"retail_purchase":        "Compra minorista",
+		"retail_subtotal":        "Subtotal de la compra",
+		"payment_on_account":     "Pago a cuenta",
+		"settlement_subtotal":    "Subtotal de la liquidación",
+		"thank_you":              "¡Gracias por su compra!",
+		"visit_again":            "Vuelva pronto a %s",
+		"coupon_expires":         "Vence el %s",
+		"refund_processed":       "Reembolso procesado",
+		"keep_receipt":           "Conserve este recibo para sus registros",
+		"customer_signature":     "Firma del cliente",
+		"order":                  "Pedido",
+		"notes":                  "Notas",
+		"rental_agreement":       "Contrato de alquiler",
+		"agreement_number":       "N.º de contrato",
+		"renter":                 "Arrendatario",
+		"license_number":         "Licencia de conducir",
+		"date_of_birth":          "Fecha de nacimiento",
+		"rental_period":          "Período de alquiler",
+		"equipment":              "Equipo",
+		"asset_tag":              "N.º de inventario",
+		"quantity":               "Cant.",
+		"rate":                   "Tarifa",
+		"deposit":                "Depósito",
+		"terms_and_conditions":   "Términos y condiciones",
+		"waiver":                 "Exención de responsabilidad",
+		"renter_signature":       "Firma del arrendatario",
+		"date":                   "Fecha",
+		"invoice":                "Factura",
+		"invoice_number":         "Factura n.º",
+		"bill_to":                "Facturar a",
+		"billing_period":         "Período de facturación",
+		"due_date":               "Fecha de vencimiento",
+		"payment_terms":          "Condiciones de pago",
+		"description":            "Descripción",
+		"unit_price":             "Precio unitario",
+		"amount_paid":            "Importe pagado",
+		"balance_due":            "Saldo pendiente",
+		"statement":              "Estado de cuenta",
+		"statement_date":         "Fecha del estado",
+		"opening_balance":        "Saldo inicial",
+		"closing_balance":        "Saldo final",
+		"charges":                "Cargos",
+		"payments":               "Pagos",
+		"balance":                "Saldo",
+		"balance_history":        "Historial de saldo",
+		"reference":              "Referencia",
+		"period":                 "Período",
+		"display_welcome":        "BIENVENIDO",
+		"display_scan_id":        "ESCANEE SU ID",
+		"display_thank_you":      "GRACIAS",
+	},
+}
+
+// Translator looks up receipt strings in one language
+type Translator struct {
+	lang  string
+	table map[string]string
+}
+
+// New returns a translator for a language tag such as "fr" or "fr-CA".
+// Unknown languages fall back to English.
+func New(lang string) Translator {
+	tag := normalize(lang)
+	if table, ok := tables[tag]; ok {
+		return Translator{lang: tag, table: table}
+	}
+	// "fr-CA" uses the "fr" table unless a regional one was loaded
+	if i := strings.IndexByte(tag, '-'); i > 0 {
+		if table, ok := tables[tag[:i]]; ok {
+			return Translator{lang: tag[:i], table: table}
+		}
+	}
+	return Translator{lang: DefaultLanguage, table: tables[DefaultLanguage]}
+}
+
+// Language returns the language the translator actually uses
+func (t Translator) Language() string {
+	return t.lang
+}
+
+// T returns the string for key, formatted with args when given. Strings
+// missing from the language fall back to English, then to the key itself.
+func (t Translator) T(key string, args ...interface{}) string {
+	s, ok := t.table[key]
+	if !ok {
+		s, ok = tables[DefaultLanguage][key]
+		if !ok {
+			s = key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(s, args...)
+	}
+	return s
+}
+
+// Supported reports whether lang (or its base language) has a table
+func Supported(lang string) bool {
+	tag := normalize(lang)
+	used := New(tag).Language()
+	return used == tag || strings.HasPrefix(tag, used+"-")
+}
+
+// Languages lists the languages with a string table
+func Languages() []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Load reads extra or replacement strings from a JSON file of the form
+// {"fr": {"thank_you": "Merci!"}, "de": {...}}. Strings are merged into
+// the built-in tables, so a file only needs the strings it changes.
+// Load is meant to be called at startup, before receipts are rendered.
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read translations: %v", err)
+	}
+
+	var loaded map[string]map[string]string
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse translations %s: %v", path, err)
+	}
+
+	for lang, strs := range loaded {
+		tag := normalize(lang)
+		table, ok := tables[tag]
+		if !ok {
+			table = make(map[string]string, len(strs))
+			tables[tag] = table
+		}
+		for key, s := range strs {
+			table[key] = s
+		}
+	}
+	return nil
+}
+
+// normalize turns "fr_CA" or "FR-ca" into "fr-ca"
+func normalize(lang string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(lang), "_", "-"))
+}
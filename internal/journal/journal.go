@@ -0,0 +1,203 @@
+// Package journal records the agent's scans, print jobs, cash drawer
+// opens and failed requests for end-of-day reconciliation in an embedded
+// SQLite database. The columns the history endpoints filter on are
+// indexed; the rest of each entry is kept as JSON, so entries can gain
+// fields without a schema change.
+package journal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Pure Go, so the agent still builds without cgo
+)
+
+// Entry kinds
+const (
+	Scan   = "scan"
+	Print  = "print"
+	Drawer = "drawer" // The cash drawer opened for a no-sale
+	Error  = "error"  // A request the agent answered with an error
+)
+
+// Entry is one journaled event
+type Entry struct {
+	Time          time.Time `json:"time"`
+	JobID         string    `json:"jobId,omitempty"`     // Print jobs: the ID their documents are kept under
+	RequestID     string    `json:"requestId,omitempty"` // The API call that made it
+	Kind          string    `json:"kind"`
+	Status        string    `json:"status"`             // "success", "warning", "failed" or "manual_intervention_required"
+	Document      string    `json:"document,omitempty"` // Print jobs: "receipt" or "agreement"
+	TransactionID string    `json:"transactionId,omitempty"`
+	Copies        int       `json:"copies,omitempty"`
+	Printed       int       `json:"printed,omitempty"`
+	Printer       string    `json:"printer,omitempty"`
+	ScanID        string    `json:"scanId,omitempty"`
+	CardType      string    `json:"cardType,omitempty"`      // Scans: "license" or "generic"
+	LicenseNumber string    `json:"licenseNumber,omitempty"` // Always masked
+	State         string    `json:"state,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	DurationMs    int64     `json:"durationMs,omitempty"`
+	Operator      string    `json:"operator,omitempty"`  // Staff member, as the request named them
+	Station       string    `json:"station,omitempty"`   // Till or counter the request came from
+	Duplicate     bool      `json:"duplicate,omitempty"` // Print jobs: the same receipt printed again
+	ReceiptNumber int64     `json:"receiptNumber,omitempty"`
+	Output        string    `json:"output,omitempty"`     // Print jobs: "pdf", "thermal" or "html"
+	Request       string    `json:"request,omitempty"`    // Errors: method and path, e.g. "POST /v1/print/receipt"
+	HTTPStatus    int       `json:"httpStatus,omitempty"` // Errors: the status answered
+}
+
+// Query selects entries of one kind. From and To are inclusive; zero
+// values leave that end open. Operator, Station and RequestID, when set,
+// must match exactly.
+type Query struct {
+	Kind      string
+	From      time.Time
+	To        time.Time
+	Operator  string
+	Station   string
+	RequestID string
+	Offset    int
+	Limit     int
+}
+
+// Page is one page of query results, newest first
+type Page struct {
+	Total   int     `json:"total"`
+	Offset  int     `json:"offset"`
+	Limit   int     `json:"limit"`
+	Entries []Entry `json:"entries"`
+}
+
+// Journal is an open journal database
+type Journal struct {
+	db *sql.DB
+}
+
+// File the journal is kept in, within its directory
+const dbFile = "journal.db"
+
+const schema = `
+CREATE TABLE IF NOT EXISTS entries (
+	id         INTEGER PRIMARY KEY,
+	time       INTEGER NOT NULL, -- Unix nanoseconds
+	kind       TEXT NOT NULL,
+	operator   TEXT NOT NULL,
+	station    TEXT NOT NULL,
+	request_id TEXT NOT NULL,
+	data       TEXT NOT NULL     -- The whole entry, as JSON
+);
+CREATE INDEX IF NOT EXISTS entries_kind_time ON entries (kind, time);
+CREATE INDEX IF NOT EXISTS entries_request_id ON entries (request_id);
+`
+
+// Open opens (creating if needed) the journal in dir
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %v", err)
+	}
+	// WAL lets the history endpoints read while a scan or print is
+	// written; the busy timeout covers a second agent on the same files
+	dsn := "file:" + filepath.ToSlash(filepath.Join(dir, dbFile)) +
+		"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=synchronous(NORMAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	// One connection serialises writes, as the file lock did before
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open journal: %v", err)
+	}
+	return &Journal{db: db}, nil
+}
+
+// Close closes the journal database
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+// Record appends an entry, stamping the time if it is not set
+func (j *Journal) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.db.Exec(`INSERT INTO entries (time, kind, operator, station, request_id, data) VALUES (?, ?, ?, ?, ?, ?)`,
+		e.Time.UnixNano(), e.Kind, e.Operator, e.Station, e.RequestID, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to write journal: %v", err)
+	}
+	return nil
+}
+
+// Find returns a page of matching entries, newest first
+func (j *Journal) Find(q Query) (Page, error) {
+	var where []string
+	var args []interface{}
+	filter := func(clause string, value interface{}) {
+		where = append(where, clause)
+		args = append(args, value)
+	}
+	if q.Kind != "" {
+		filter("kind = ?", q.Kind)
+	}
+	if !q.From.IsZero() {
+		filter("time >= ?", q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		filter("time <= ?", q.To.UnixNano())
+	}
+	if q.Operator != "" {
+		filter("operator = ?", q.Operator)
+	}
+	if q.Station != "" {
+		filter("station = ?", q.Station)
+	}
+	if q.RequestID != "" {
+		filter("request_id = ?", q.RequestID)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := Page{Offset: q.Offset, Limit: q.Limit, Entries: []Entry{}}
+	if err := j.db.QueryRow(`SELECT COUNT(*) FROM entries`+cond, args...).Scan(&page.Total); err != nil {
+		return Page{}, fmt.Errorf("failed to read journal: %v", err)
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = -1 // No limit
+	}
+	rows, err := j.db.Query(`SELECT data FROM entries`+cond+` ORDER BY time DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, q.Offset)...)
+	if err != nil {
+		return Page{}, fmt.Errorf("failed to read journal: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return Page{}, fmt.Errorf("failed to read journal: %v", err)
+		}
+		var e Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return Page{}, fmt.Errorf("journal entry is not valid: %v", err)
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return Page{}, fmt.Errorf("failed to read journal: %v", err)
+	}
+	return page, nil
+}
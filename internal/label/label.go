@@ -0,0 +1,221 @@
+// Package label lays out barcode labels (SKU, shelf price, equipment asset
+// tags) and renders them as ZPL or EPL for Zebra-class label printers.
+package label
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"GoScanRentalTide/internal/sanitize"
+)
+
+// Printer languages
+const (
+	ZPL = "zpl"
+	EPL = "epl"
+)
+
+// Element is one piece of a label. Text is a text/template evaluated
+// against the label's fields, e.g. "{{.sku}}".
+type Element struct {
+	Type   string `json:"type"` // "text" or "barcode" (Code 128)
+	X      int    `json:"x"`    // Dots from the left edge
+	Y      int    `json:"y"`    // Dots from the top edge
+	Size   int    `json:"size"` // Text height in dots
+	Height int    `json:"height"`
+	Text   string `json:"text"`
+}
+
+// Layout describes a label stock and what goes on it. Sizes are in dots;
+// at 203 dpi a 2" x 1" label is 406 x 203.
+type Layout struct {
+	Name     string    `json:"name"`
+	Width    int       `json:"width"`
+	Height   int       `json:"height"`
+	Elements []Element `json:"elements"`
+}
+
+// Builtin returns the stock layouts for 2" x 1" labels at 203 dpi
+func Builtin() map[string]Layout {
+	return map[string]Layout{
+		"sku": {Name: "sku", Width: 406, Height: 203, Elements: []Element{
+			{Type: "text", X: 20, Y: 12, Size: 30, Text: "{{.name}}"},
+			{Type: "barcode", X: 20, Y: 50, Height: 70, Text: "{{.sku}}"},
+			{Type: "text", X: 20, Y: 152, Size: 40, Text: "{{.price}}"},
+		}},
+		"price": {Name: "price", Width: 406, Height: 203, Elements: []Element{
+			{Type: "text", X: 20, Y: 15, Size: 30, Text: "{{.name}}"},
+			{Type: "text", X: 20, Y: 65, Size: 90, Text: "{{.price}}"},
+			{Type: "text", X: 20, Y: 170, Size: 20, Text: "{{.sku}}"},
+		}},
+		"asset": {Name: "asset", Width: 406, Height: 203, Elements: []Element{
+			{Type: "text", X: 20, Y: 10, Size: 20, Text: "{{if .location}}PROPERTY OF {{.location}}{{end}}"},
+			{Type: "barcode", X: 20, Y: 38, Height: 90, Text: "{{.assetTag}}"},
+			{Type: "text", X: 20, Y: 165, Size: 28, Text: "{{.name}}{{if .serial}} S/N {{.serial}}{{end}}"},
+		}},
+	}
+}
+
+// LoadLayouts reads custom layouts from the *.json files in dir. A file's
+// layout is named after the file unless it sets "name".
+func LoadLayouts(dir string) (map[string]Layout, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	layouts := make(map[string]Layout, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read label layout: %v", err)
+		}
+		var layout Layout
+		if err := json.Unmarshal(data, &layout); err != nil {
+			return nil, fmt.Errorf("failed to parse label layout %s: %v", path, err)
+		}
+		if layout.Name == "" {
+			layout.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+		}
+		if err := layout.Validate(); err != nil {
+			return nil, fmt.Errorf("label layout %s: %v", path, err)
+		}
+		layouts[layout.Name] = layout
+	}
+	return layouts, nil
+}
+
+// Names lists layout names in order
+func Names(layouts map[string]Layout) []string {
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks the layout's size and that its element templates parse
+func (l Layout) Validate() error {
+	if l.Width <= 0 || l.Height <= 0 {
+		return fmt.Errorf("width and height must be positive")
+	}
+	for i, e := range l.Elements {
+		if e.Type != "text" && e.Type != "barcode" {
+			return fmt.Errorf("element %d: unknown type %q", i, e.Type)
+		}
+		if _, err := template.New("").Option("missingkey=zero").Parse(e.Text); err != nil {
+			return fmt.Errorf("element %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Render produces the printer commands for one label per entry in labels,
+// each printed copies times. Elements that come out empty are skipped.
+func (l Layout) Render(format string, labels []map[string]string, copies int) ([]byte, error) {
+	if copies <= 0 {
+		copies = 1
+	}
+	var b strings.Builder
+	for _, fields := range labels {
+		values, err := l.values(fields)
+		if err != nil {
+			return nil, err
+		}
+		switch format {
+		case ZPL:
+			l.renderZPL(&b, values, copies)
+		case EPL:
+			l.renderEPL(&b, values, copies)
+		default:
+			return nil, fmt.Errorf("unsupported label format %q (use zpl or epl)", format)
+		}
+	}
+	return []byte(b.String()), nil
+}
+
+// values evaluates each element's template against the fields
+func (l Layout) values(fields map[string]string) ([]string, error) {
+	values := make([]string, len(l.Elements))
+	for i, e := range l.Elements {
+		tmpl, err := template.New("").Option("missingkey=zero").Parse(e.Text)
+		if err != nil {
+			return nil, fmt.Errorf("label layout %s element %d: %v", l.Name, i, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, fields); err != nil {
+			return nil, fmt.Errorf("label layout %s element %d: %v", l.Name, i, err)
+		}
+		// One line with no control characters: a line break in EPL ends
+		// the command and starts another
+		values[i] = sanitize.Line(out.String(), sanitize.MaxName)
+	}
+	return values, nil
+}
+
+func (l Layout) renderZPL(b *strings.Builder, values []string, copies int) {
+	b.WriteString("^XA\n")
+	fmt.Fprintf(b, "^PW%d\n^LL%d\n^CI28\n", l.Width, l.Height) // ^CI28: UTF-8 text
+	for i, e := range l.Elements {
+		if values[i] == "" {
+			continue
+		}
+		switch e.Type {
+		case "text":
+			fmt.Fprintf(b, "^FO%d,%d^A0N,%d,%d^FH_^FD%s^FS\n", e.X, e.Y, e.Size, e.Size, zplEscape(values[i]))
+		case "barcode":
+			fmt.Fprintf(b, "^FO%d,%d^BY2^BCN,%d,Y,N,N^FH_^FD%s^FS\n", e.X, e.Y, e.Height, zplEscape(values[i]))
+		}
+	}
+	fmt.Fprintf(b, "^PQ%d\n^XZ\n", copies)
+}
+
+// zplEscape hex-encodes the characters ZPL treats as commands; fields are
+// sent with ^FH_ so "_5E" prints as "^"
+func zplEscape(s string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace(s)
+}
+
+func (l Layout) renderEPL(b *strings.Builder, values []string, copies int) {
+	b.WriteString("\nN\n") // Clear the image buffer
+	fmt.Fprintf(b, "q%d\nQ%d,24\n", l.Width, l.Height)
+	for i, e := range l.Elements {
+		if values[i] == "" {
+			continue
+		}
+		switch e.Type {
+		case "text":
+			font, mult := eplFont(e.Size)
+			fmt.Fprintf(b, "A%d,%d,0,%d,%d,%d,N,\"%s\"\n", e.X, e.Y, font, mult, mult, eplEscape(values[i]))
+		case "barcode":
+			fmt.Fprintf(b, "B%d,%d,0,1,2,4,%d,B,\"%s\"\n", e.X, e.Y, e.Height, eplEscape(values[i]))
+		}
+	}
+	fmt.Fprintf(b, "P%d\n", copies)
+}
+
+// eplFont picks the resident font (1-5, 12 to 48 dots tall at 203 dpi)
+// and multiplier that come closest to size without going over
+func eplFont(size int) (font, mult int) {
+	heights := []int{12, 16, 20, 24, 48}
+	font, mult = 1, 1
+	best := 0
+	for m := 1; m <= 8; m++ {
+		for i, h := range heights {
+			if h*m <= size && h*m > best {
+				best, font, mult = h*m, i+1, m
+			}
+		}
+	}
+	return font, mult
+}
+
+// eplEscape escapes backslashes and quotes inside EPL string data
+func eplEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
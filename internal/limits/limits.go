@@ -0,0 +1,113 @@
+// Package limits protects the printers from a client stuck in a loop
+// sending print jobs, and the agent and receipt server from oversized
+// requests: a token bucket rate limit per client IP and a cap on the size
+// of request bodies.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket per client IP
+type RateLimiter struct {
+	rate  float64 // Tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	clients map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows perMinute requests per client on average, with
+// bursts of up to burst requests. It returns nil (no limit) when perMinute
+// is zero.
+func NewRateLimiter(perMinute, burst int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(max(burst, 1)),
+		clients: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token for client, or reports how long until one is free
+func (l *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.clients[client]
+	if !ok {
+		// Forget clients whose buckets have refilled
+		for key, old := range l.clients {
+			if now.Sub(old.last).Seconds()*l.rate >= l.burst {
+				delete(l.clients, key)
+			}
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.clients[client] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// ClientIP is the address the request came from. Forwarding headers are
+// ignored: both servers are reached directly, not through a proxy.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ErrorWriter answers a request in the server's own error format
+type ErrorWriter func(w http.ResponseWriter, status int, err error)
+
+// Requests rate limits a handler per client and caps the size of request
+// bodies at maxBody bytes (no cap when zero). A client over the limit is
+// answered 429 through fail, with a Retry-After header.
+func Requests(limiter *RateLimiter, maxBody int64, fail ErrorWriter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if limiter != nil {
+			if ok, wait := limiter.Allow(ClientIP(r), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				fail(w, http.StatusTooManyRequests, errors.New("too many requests, slow down"))
+				return
+			}
+		}
+		if maxBody > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBody)
+		}
+		next(w, r)
+	}
+}
+
+// BodyError describes a request body that could not be read or parsed,
+// with the status to answer: 413 for bodies over the size limit, 400 for
+// the rest
+func BodyError(err error) (int, error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, fmt.Errorf("request body is larger than %d bytes", tooLarge.Limit)
+	}
+	return http.StatusBadRequest, fmt.Errorf("error parsing JSON data: %v", err)
+}
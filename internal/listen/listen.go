@@ -0,0 +1,106 @@
+// Package listen opens the HTTP port of a server, detecting when another
+// program already holds it, and records the port that was chosen so
+// clients can find a server that had to move.
+package listen
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// wsaeaddrinuse is WSAEADDRINUSE, which Windows returns instead of
+// EADDRINUSE
+const wsaeaddrinuse = syscall.Errno(10048)
+
+// InUseError reports that every port tried is taken
+type InUseError struct {
+	Port  int // First port tried
+	Tries int // Ports tried, counting up from Port
+}
+
+func (e *InUseError) Error() string {
+	if e.Tries <= 1 {
+		return fmt.Sprintf("port %d is already in use by another program (is another copy running?)", e.Port)
+	}
+	return fmt.Sprintf("ports %d-%d are all in use by other programs", e.Port, e.Port+e.Tries-1)
+}
+
+// Listen opens port on all interfaces. When the port is taken the next
+// fallback ports are tried in turn; with no fallback it fails with an
+// *InUseError.
+func Listen(port, fallback int) (net.Listener, error) {
+	for i := 0; i <= max(fallback, 0); i++ {
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", port+i))
+		if err == nil {
+			return l, nil
+		}
+		if !inUse(err) {
+			return nil, err
+		}
+	}
+	return nil, &InUseError{Port: port, Tries: max(fallback, 0) + 1}
+}
+
+// Port returns the port a listener is bound to
+func Port(l net.Listener) int {
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+func inUse(err error) bool {
+	var errno syscall.Errno
+	return errors.As(err, &errno) && (errno == syscall.EADDRINUSE || errno == wsaeaddrinuse)
+}
+
+// Registration is what a server writes to its port file
+type Registration struct {
+	Service string    `json:"service"`
+	Port    int       `json:"port"`
+	URL     string    `json:"url"`
+	PID     int       `json:"pid"` // Lets a reader spot a file left by a server that has exited
+	Started time.Time `json:"started"`
+}
+
+// Register writes the server's port to path, replacing the file
+// atomically so readers never see half of it
+func Register(path, service string, port int) error {
+	data, err := json.MarshalIndent(Registration{
+		Service: service,
+		Port:    port,
+		URL:     fmt.Sprintf("http://localhost:%d", port),
+		PID:     os.Getpid(),
+		Started: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Lookup reads a port file written by Register
+func Lookup(path string) (Registration, error) {
+	var r Registration
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("invalid port file %s: %v", path, err)
+	}
+	return r, nil
+}
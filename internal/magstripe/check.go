@@ -0,0 +1,130 @@
+package magstripe
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BadSwipeError is a swipe with a track the reader misread or cut short.
+// The card is usually fine: swiping it again, steadily, reads it.
+type BadSwipeError struct {
+	Track  int
+	Reason string
+}
+
+func (e *BadSwipeError) Error() string {
+	return fmt.Sprintf("track %d %s", e.Track, e.Reason)
+}
+
+// Check looks for a misread in the tracks of a swipe: a read error the
+// reader reported (%E?, ;E?, +E?), a track with no end sentinel,
+// characters outside the track's character set, or a longitudinal
+// redundancy check (LRC) character that doesn't match, when the reader
+// passes it on after the end sentinel. It returns nil for data with no
+// tracks, such as a barcode.
+func Check(raw string) error {
+	for i := 0; i < len(raw); i++ {
+		track, ok := trackAt(raw, i)
+		if !ok {
+			continue
+		}
+		end := strings.IndexByte(raw[i+1:], '?')
+		if end < 0 {
+			return &BadSwipeError{track, "has no end sentinel; the swipe was cut short"}
+		}
+		end += i + 1
+		data := raw[i+1 : end]
+		if strings.ContainsAny(data, nextTrack[track]) {
+			return &BadSwipeError{track, "has no end sentinel; the swipe was cut short"}
+		}
+		if data == "E" {
+			return &BadSwipeError{track, "could not be read"}
+		}
+		for j := 0; j < len(data); j++ {
+			if !inCharset(track, data[j]) {
+				return &BadSwipeError{track, fmt.Sprintf("has a character that can't be on a card (%q)", data[j])}
+			}
+		}
+		if end+1 < len(raw) && isLRC(track, raw[end+1]) && raw[end+1] != lrc(track, raw[i:end+1]) {
+			return &BadSwipeError{track, "failed its LRC check"}
+		}
+		i = end
+	}
+	return nil
+}
+
+// OpenTrack returns the track a swipe ends inside of, one that started
+// but has no end sentinel yet, as when the reader is still sending it
+func OpenTrack(raw string) (int, bool) {
+	for i := 0; i < len(raw); i++ {
+		track, ok := trackAt(raw, i)
+		if !ok {
+			continue
+		}
+		end := strings.IndexByte(raw[i+1:], '?')
+		if end < 0 {
+			return track, true
+		}
+		i += end + 1
+	}
+	return 0, false
+}
+
+// trackAt reports the track that starts at raw[i], if one does: % and a
+// format code letter for track 1, ; for track 2 and + for track 3
+func trackAt(raw string, i int) (int, bool) {
+	switch raw[i] {
+	case '%':
+		if i+1 < len(raw) && raw[i+1] >= 'A' && raw[i+1] <= 'Z' {
+			return 1, true
+		}
+	case ';':
+		return 2, true
+	case '+':
+		return 3, true
+	}
+	return 0, false
+}
+
+// Start sentinels of the tracks that can follow each track; finding one
+// before the end sentinel means the track was cut short
+var nextTrack = map[int]string{1: ";", 2: "%;+", 3: "%;+"}
+
+// inCharset reports whether c can be encoded on a track: the 6-bit set
+// (space to underscore) on track 1, the 4-bit set (0 to ?) on the others.
+// Sentinels and the end of the track are checked by the caller.
+func inCharset(track int, c byte) bool {
+	if track == 1 {
+		return c >= 0x20 && c <= 0x5F && c != '%' && c != '?'
+	}
+	return c >= 0x30 && c <= 0x3F && c != ';' && c != '?'
+}
+
+// isLRC reports whether c, following an end sentinel, is an LRC
+// character: one in the track's set that doesn't start another track or
+// line
+func isLRC(track int, c byte) bool {
+	switch c {
+	case '%', ';', '+', '_', '?':
+		return false
+	}
+	if track == 1 {
+		return c >= 0x20 && c <= 0x5F && c != ' '
+	}
+	return c >= 0x30 && c <= 0x3F
+}
+
+// lrc returns the LRC character of a track from its start sentinel to
+// its end sentinel: the exclusive or of the characters' values in the
+// track's set
+func lrc(track int, data string) byte {
+	base := byte(0x30)
+	if track == 1 {
+		base = 0x20
+	}
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum ^= data[i] - base
+	}
+	return sum + base
+}
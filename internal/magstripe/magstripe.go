@@ -0,0 +1,135 @@
+// Package magstripe parses ISO 7811 magnetic stripe tracks from cards that
+// are not driver's licenses: loyalty, membership, health and payment
+// cards. Payment card numbers are masked and their discretionary data is
+// dropped.
+package magstripe
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Card is the structured contents of a generic swipe
+type Card struct {
+	CardType      string `json:"cardType"` // Always "generic"
+	Number        string `json:"number"`
+	Masked        bool   `json:"masked"` // Number is a payment card number and was masked
+	Name          string `json:"name,omitempty"`
+	FirstName     string `json:"firstName,omitempty"`
+	LastName      string `json:"lastName,omitempty"`
+	Expiry        string `json:"expiry,omitempty"` // YYYY-MM
+	ServiceCode   string `json:"serviceCode,omitempty"`
+	Discretionary string `json:"discretionary,omitempty"`
+	Track3        string `json:"track3,omitempty"`
+	Tracks        []int  `json:"tracks"` // Tracks read, e.g. [1 2]
+}
+
+var (
+	// Track 1: %, format code, then ^-separated fields up to the ?
+	track1Pattern = regexp.MustCompile(`%([A-Z])([^?]*)\?`)
+	// Tracks 2 and 3: ; or +, digits and field separators up to the ?
+	numericPattern = regexp.MustCompile(`[;+]([0-9=]+)\?`)
+)
+
+// Parse reads the tracks in a swipe. It reports false when no track with
+// a card number was found.
+func Parse(raw string) (Card, bool) {
+	card := Card{CardType: "generic"}
+	var rest string
+
+	if m := track1Pattern.FindStringSubmatch(raw); m != nil {
+		fields := strings.Split(m[2], "^")
+		card.Tracks = append(card.Tracks, 1)
+		card.Number = strings.TrimSpace(fields[0])
+		if len(fields) > 1 {
+			card.Name = strings.TrimSpace(fields[1])
+			card.LastName, card.FirstName = splitName(card.Name)
+		}
+		if len(fields) > 2 {
+			rest = fields[2]
+		}
+	}
+
+	numeric := numericPattern.FindAllStringSubmatch(raw, 2)
+	if len(numeric) > 0 {
+		card.Tracks = append(card.Tracks, 2)
+		number, data, _ := strings.Cut(numeric[0][1], "=")
+		if card.Number == "" {
+			card.Number = number
+		}
+		if data != "" {
+			rest = data
+		}
+	}
+	if len(numeric) > 1 {
+		card.Tracks = append(card.Tracks, 3)
+		card.Track3 = numeric[1][1]
+	}
+
+	if card.Number == "" {
+		return Card{}, false
+	}
+
+	// Expiry (YYMM), service code (3 digits), then discretionary data
+	if len(rest) >= 4 && isDigits(rest[:4]) && rest[2:4] >= "01" && rest[2:4] <= "12" {
+		card.Expiry = "20" + rest[:2] + "-" + rest[2:4]
+		rest = rest[4:]
+		if len(rest) >= 3 && isDigits(rest[:3]) {
+			card.ServiceCode = rest[:3]
+			rest = rest[3:]
+		}
+	}
+	card.Discretionary = rest
+
+	if isPaymentCard(card.Number) {
+		card.Number = mask(card.Number)
+		card.Masked = true
+		card.Discretionary = ""
+		card.Track3 = ""
+	}
+	return card, true
+}
+
+// splitName splits the ISO "LAST/FIRST MIDDLE.TITLE" name format
+func splitName(name string) (last, first string) {
+	last, first, found := strings.Cut(name, "/")
+	if !found {
+		return "", ""
+	}
+	first, _, _ = strings.Cut(first, ".")
+	return strings.TrimSpace(last), strings.TrimSpace(first)
+}
+
+// isPaymentCard reports whether number looks like a payment card number:
+// 13 to 19 digits that pass the Luhn check
+func isPaymentCard(number string) bool {
+	if len(number) < 13 || len(number) > 19 || !isDigits(number) {
+		return false
+	}
+	sum := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if (len(number)-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// mask keeps only the last four digits
+func mask(number string) string {
+	return strings.Repeat("*", len(number)-4) + number[len(number)-4:]
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
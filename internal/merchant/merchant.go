@@ -0,0 +1,75 @@
+// Package merchant holds the legal details some provinces and countries
+// require on a receipt before it is valid: the business's registered name
+// and address, its tax registration numbers, and a tax summary in the
+// form the jurisdiction expects.
+package merchant
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// How the tax summary is printed in the legal block
+const (
+	Lines = "lines" // The receipt's usual tax lines only
+	Table = "table" // A net / tax / gross table per tax code, as VAT invoices need
+)
+
+// Registration is a tax registration number and the label it is printed
+// with, e.g. "GST/HST No." or "VAT No."
+type Registration struct {
+	Label  string `json:"label"`
+	Number string `json:"number"`
+}
+
+// Info is the merchant's legal block. The zero value prints nothing.
+type Info struct {
+	LegalName     string         `json:"legalName,omitempty"`
+	Address       []string       `json:"address,omitempty"` // One printed line each
+	Registrations []Registration `json:"registrations,omitempty"`
+	VATFormat     string         `json:"vatFormat,omitempty"` // Lines (the default) or Table
+}
+
+// Empty reports whether there is no legal block to print
+func (i Info) Empty() bool {
+	return i.LegalName == "" && len(i.Address) == 0 && len(i.Registrations) == 0
+}
+
+// ShowTable reports whether the tax summary is printed as a table
+func (i Info) ShowTable() bool {
+	return i.VATFormat == Table
+}
+
+// Load reads the merchant's legal details from a JSON file
+func Load(path string) (Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to read merchant config: %v", err)
+	}
+
+	var info Info
+	if err := json.Unmarshal(data, &info); err != nil {
+		return Info{}, fmt.Errorf("failed to parse merchant config %s: %v", path, err)
+	}
+	if err := info.Validate(); err != nil {
+		return Info{}, fmt.Errorf("invalid merchant config %s: %v", path, err)
+	}
+	return info, nil
+}
+
+// Validate checks that every registration has a label and a number and
+// that the tax summary format is known
+func (i Info) Validate() error {
+	for n, r := range i.Registrations {
+		if strings.TrimSpace(r.Label) == "" || strings.TrimSpace(r.Number) == "" {
+			return fmt.Errorf("registration %d needs a label and a number", n+1)
+		}
+	}
+	switch i.VATFormat {
+	case "", Lines, Table:
+		return nil
+	}
+	return fmt.Errorf("unknown vatFormat %q (use %s or %s)", i.VATFormat, Lines, Table)
+}
@@ -0,0 +1,92 @@
+// Package messages holds the custom blocks a store prints above and below
+// its receipts: a return policy, the Wi-Fi password, a survey link with a
+// QR code.
+package messages
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"GoScanRentalTide/internal/qr"
+	"GoScanRentalTide/internal/sanitize"
+)
+
+// Block is one custom message
+type Block struct {
+	Title string `json:"title,omitempty"`
+	Text  string `json:"text,omitempty"` // Line breaks are kept
+	QR    string `json:"qr,omitempty"`   // Printed as a QR code under the text, e.g. a survey URL
+}
+
+// Lines returns the text split at its line breaks
+func (b Block) Lines() []string {
+	if b.Text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(b.Text, "\r\n", "\n"), "\n")
+}
+
+// Config is the blocks printed on every receipt. A non-empty footer
+// replaces the thank-you lines.
+type Config struct {
+	Header []Block `json:"header"`
+	Footer []Block `json:"footer"`
+}
+
+// Load reads a messages config from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read messages config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse messages config %s: %v", path, err)
+	}
+	if err := Validate(cfg.Header); err != nil {
+		return Config{}, fmt.Errorf("invalid header in %s: %v", path, err)
+	}
+	if err := Validate(cfg.Footer); err != nil {
+		return Config{}, fmt.Errorf("invalid footer in %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that every block prints something and that its QR text
+// fits in a code
+func Validate(blocks []Block) error {
+	for i, b := range blocks {
+		if b.Title == "" && b.Text == "" && b.QR == "" {
+			return fmt.Errorf("message %d is empty", i+1)
+		}
+		if b.QR != "" {
+			if _, err := qr.Encode(b.QR); err != nil {
+				return fmt.Errorf("message %d: %v", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Clean removes control characters from the blocks, which come from
+// requests, and caps their length
+func Clean(blocks []Block) {
+	for i := range blocks {
+		b := &blocks[i]
+		b.Title = sanitize.Line(b.Title, sanitize.MaxName)
+		b.Text = sanitize.Text(b.Text, sanitize.MaxText)
+		b.QR = sanitize.Line(b.QR, sanitize.MaxURL)
+	}
+}
+
+// Pick returns the blocks a request asked for, or the configured ones
+// when it didn't ask for any
+func Pick(requested, configured []Block) []Block {
+	if len(requested) > 0 {
+		return requested
+	}
+	return configured
+}
@@ -0,0 +1,177 @@
+package money
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cents is an amount of money in hundredths of the currency unit. Receipt
+// arithmetic is done in Cents so long receipts don't drift the way sums
+// of float64 amounts do.
+type Cents int64
+
+// Tolerance is how far a receipt's total may be from the sum of its parts
+// before it is flagged; frontends that round each tax line separately can
+// be a cent or two off
+const Tolerance Cents = 2
+
+// MaxAmount is the largest amount either way: ten billion, beyond any
+// real price, and far enough below the limit of Cents that the total of
+// a receipt's amounts can't overflow
+const MaxAmount Cents = 10_000_000_000_00
+
+// ErrRange reports an amount beyond MaxAmount
+var ErrRange = errors.New("amount out of range")
+
+// Amounts are plain decimals. big.Rat would also take fractions ("1/3"),
+// hex and exponents, and expanding "1e999999" takes minutes.
+var decimalAmount = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)$`)
+
+// ParseCents parses a decimal amount such as "12.34", "-0.5" or "3",
+// rounding extra digits half away from zero ("1.005" is 1.01). Amounts
+// beyond MaxAmount are an error.
+func ParseCents(s string) (Cents, error) {
+	s = strings.TrimSpace(s)
+	if !decimalAmount.MatchString(s) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	c, err := RoundRat(r.Mul(r, big.NewRat(100, 1)))
+	if err != nil {
+		return 0, fmt.Errorf("amount %s is beyond %s either way", s, MaxAmount)
+	}
+	return c, nil
+}
+
+// FromFloat converts a float amount using its shortest decimal form, so
+// 1.005 becomes 1.01 rather than 1.00
+func FromFloat(f float64) Cents {
+	c, err := ParseCents(strconv.FormatFloat(f, 'f', -1, 64))
+	if err != nil {
+		return 0 // NaN, infinities and amounts beyond MaxAmount
+	}
+	return c
+}
+
+// RoundRat rounds an exact number of cents half away from zero. Beyond
+// MaxAmount either way, it returns MaxAmount with that sign and ErrRange,
+// as strconv does for numbers that don't fit.
+func RoundRat(r *big.Rat) (Cents, error) {
+	num := new(big.Int).Abs(r.Num())
+	q, rem := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if rem.Lsh(rem, 1).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	c, err := MaxAmount, ErrRange
+	if q.IsInt64() && q.Int64() <= int64(MaxAmount) {
+		c, err = Cents(q.Int64()), nil
+	}
+	if r.Sign() < 0 {
+		c = -c
+	}
+	return c, err
+}
+
+// Rat returns the amount in cents as an exact rational
+func (c Cents) Rat() *big.Rat {
+	return new(big.Rat).SetInt64(int64(c))
+}
+
+// Times multiplies a unit price by a quantity, which may be fractional
+// (e.g. 1.5 rental hours). A product beyond MaxAmount is capped at it,
+// and the receipt's totals check then flags it.
+func (c Cents) Times(qty float64) Cents {
+	q, ok := new(big.Rat).SetString(strconv.FormatFloat(qty, 'f', -1, 64))
+	if !ok {
+		return 0
+	}
+	product, _ := RoundRat(q.Mul(q, c.Rat()))
+	return product
+}
+
+// Float64 returns the amount in currency units, for display and for
+// callers that still work in floats
+func (c Cents) Float64() float64 {
+	return float64(c) / 100
+}
+
+// String formats the amount as a plain decimal, e.g. "-12.05"
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign = "-"
+		c = -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimals
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string. Numbers are
+// parsed from their decimal text, never through float64.
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 1 && data[0] == '"' {
+		unquoted, err := strconv.Unquote(string(data))
+		if err != nil {
+			return err
+		}
+		if unquoted == "" {
+			*c = 0
+			return nil
+		}
+		data = []byte(unquoted)
+	}
+	parsed, err := ParseCents(string(data))
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// CheckTotal compares a receipt's total with subtotal + tax + tip. Some
+// frontends send the subtotal before discounts, others after, so the sum
+// is also tried with discounts taken off. It returns how far the closer
+// sum is from the total and whether that's within Tolerance.
+func CheckTotal(subtotal, discounts, tax, tip, total Cents) (Cents, bool) {
+	diff := total - (subtotal + tax + tip)
+	if discounts != 0 {
+		if d := total - (subtotal - discounts + tax + tip); abs(d) < abs(diff) {
+			diff = d
+		}
+	}
+	return diff, abs(diff) <= Tolerance
+}
+
+// CheckTendered compares the tenders of a split payment with the total
+// they pay. It returns how far their sum is from the total and whether
+// that's within Tolerance.
+func CheckTendered(total Cents, tenders ...Cents) (Cents, bool) {
+	diff := -total
+	for _, t := range tenders {
+		diff += t
+	}
+	return diff, abs(diff) <= Tolerance
+}
+
+func abs(c Cents) Cents {
+	if c < 0 {
+		return -c
+	}
+	return c
+}
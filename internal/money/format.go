@@ -0,0 +1,147 @@
+// Package money formats and stores the amounts printed on receipts.
+package money
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format describes how amounts are printed for a currency and locale
+type Format struct {
+	Symbol             string `json:"symbol"`
+	DecimalSeparator   string `json:"decimalSeparator"`
+	ThousandsSeparator string `json:"thousandsSeparator"`
+	// SymbolPosition is "before" ($1.00) or "after" (1,00 €)
+	SymbolPosition string `json:"symbolPosition"`
+	// SymbolSpace puts a space between the symbol and the number
+	SymbolSpace bool `json:"symbolSpace,omitempty"`
+	Decimals    int  `json:"decimals"`
+}
+
+var presets = map[string]Format{
+	"en-CA": {Symbol: "$", DecimalSeparator: ".", ThousandsSeparator: ",", SymbolPosition: "before", Decimals: 2},
+	"en-US": {Symbol: "$", DecimalSeparator: ".", ThousandsSeparator: ",", SymbolPosition: "before", Decimals: 2},
+	"fr-CA": {Symbol: "$", DecimalSeparator: ",", ThousandsSeparator: " ", SymbolPosition: "after", SymbolSpace: true, Decimals: 2},
+	"en-GB": {Symbol: "£", DecimalSeparator: ".", ThousandsSeparator: ",", SymbolPosition: "before", Decimals: 2},
+	"en-AU": {Symbol: "$", DecimalSeparator: ".", ThousandsSeparator: ",", SymbolPosition: "before", Decimals: 2},
+	"fr-FR": {Symbol: "€", DecimalSeparator: ",", ThousandsSeparator: " ", SymbolPosition: "after", SymbolSpace: true, Decimals: 2},
+	"de-DE": {Symbol: "€", DecimalSeparator: ",", ThousandsSeparator: ".", SymbolPosition: "after", SymbolSpace: true, Decimals: 2},
+	"es-MX": {Symbol: "$", DecimalSeparator: ".", ThousandsSeparator: ",", SymbolPosition: "before", Decimals: 2},
+	"ja-JP": {Symbol: "¥", DecimalSeparator: ".", ThousandsSeparator: ",", SymbolPosition: "before", Decimals: 0},
+}
+
+// DefaultFormat returns the format receipts used before it was configurable
+func DefaultFormat() Format {
+	return presets["en-CA"]
+}
+
+// Preset returns the format for a locale name such as "fr-CA"
+func Preset(locale string) (Format, error) {
+	f, ok := presets[locale]
+	if !ok {
+		return Format{}, fmt.Errorf("unknown locale %q (known: %s)", locale, strings.Join(Locales(), ", "))
+	}
+	return f, nil
+}
+
+// Locales lists the preset locale names
+func Locales() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadFormat reads a currency format from a JSON file. Fields left out of
+// the file keep their en-CA defaults.
+func LoadFormat(path string) (Format, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Format{}, fmt.Errorf("failed to read currency config: %v", err)
+	}
+
+	f := DefaultFormat()
+	if err := json.Unmarshal(data, &f); err != nil {
+		return Format{}, fmt.Errorf("failed to parse currency config %s: %v", path, err)
+	}
+	if f.SymbolPosition != "before" && f.SymbolPosition != "after" {
+		return Format{}, fmt.Errorf("invalid currency config %s: symbolPosition must be \"before\" or \"after\"", path)
+	}
+	if f.Decimals < 0 || f.Decimals > 4 {
+		return Format{}, fmt.Errorf("invalid currency config %s: decimals must be between 0 and 4", path)
+	}
+	return f, nil
+}
+
+// Number formats an amount with the locale's separators but no symbol
+func (f Format) Number(amount float64) string {
+	s := strconv.FormatFloat(math.Abs(amount), 'f', f.Decimals, 64)
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+
+	if f.ThousandsSeparator != "" && len(intPart) > 3 {
+		var grouped strings.Builder
+		lead := len(intPart) % 3
+		if lead > 0 {
+			grouped.WriteString(intPart[:lead])
+		}
+		for i := lead; i < len(intPart); i += 3 {
+			if grouped.Len() > 0 {
+				grouped.WriteString(f.ThousandsSeparator)
+			}
+			grouped.WriteString(intPart[i : i+3])
+		}
+		intPart = grouped.String()
+	}
+
+	out := intPart
+	if fracPart != "" {
+		out += f.DecimalSeparator + fracPart
+	}
+	if f.isNegative(amount) {
+		out = "-" + out
+	}
+	return out
+}
+
+// isNegative reports whether amount is still below zero once rounded,
+// so tiny negative float errors don't print as "-0.00"
+func (f Format) isNegative(amount float64) bool {
+	scale := math.Pow(10, float64(f.Decimals))
+	return math.Round(amount*scale) < 0
+}
+
+// Format formats an amount with the currency symbol, e.g. "$1,234.50"
+// or "1 234,50 €". Negative amounts keep the sign in front: "-$5.00".
+func (f Format) Format(amount float64) string {
+	number := f.Number(math.Abs(amount))
+	space := ""
+	if f.SymbolSpace {
+		space = " "
+	}
+
+	var out string
+	if f.SymbolPosition == "after" {
+		out = number + space + f.Symbol
+	} else {
+		out = f.Symbol + space + number
+	}
+	if f.isNegative(amount) {
+		out = "-" + out
+	}
+	return out
+}
+
+// FormatCents formats an amount held in cents
+func (f Format) FormatCents(c Cents) string {
+	return f.Format(c.Float64())
+}
@@ -0,0 +1,420 @@
+// Package mqtt is a minimal MQTT 3.1.1 publisher: it connects, publishes
+// at QoS 0 or 1 and keeps the connection alive. It does not subscribe;
+// the agent only reports to a broker.
+package mqtt
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Packet types (upper nibble of the fixed header)
+const (
+	packetConnect    = 1
+	packetConnack    = 2
+	packetPublish    = 3
+	packetPuback     = 4
+	packetPingreq    = 12
+	packetPingresp   = 13
+	packetDisconnect = 14
+)
+
+// Message is one publication
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte // 0 or 1
+	Retain  bool
+}
+
+// Options configure a connection
+type Options struct {
+	// Broker is host:port, optionally prefixed with tcp:// or, for TLS,
+	// ssl:// or mqtts://. The port defaults to 1883 (8883 with TLS).
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration // Default 60s
+	Will      *Message      // Published by the broker if the connection drops
+	Timeout   time.Duration // Dial and acknowledgement timeout; default 10s
+}
+
+// Client is one connection to a broker. It is not safe for concurrent
+// use; Publisher serialises access.
+type Client struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	timeout  time.Duration
+	packetID uint16
+}
+
+// Connect dials the broker and completes the MQTT handshake
+func Connect(opts Options) (*Client, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 60 * time.Second
+	}
+
+	address, useTLS := brokerAddress(opts.Broker)
+	dialer := &net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(address)
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to broker %s: %v", address, err)
+	}
+
+	c := &Client{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if err := c.handshake(opts, keepAlive); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// brokerAddress strips the scheme and adds the default port
+func brokerAddress(broker string) (string, bool) {
+	useTLS := false
+	for _, scheme := range []string{"ssl://", "mqtts://", "tls://"} {
+		if strings.HasPrefix(broker, scheme) {
+			broker, useTLS = strings.TrimPrefix(broker, scheme), true
+		}
+	}
+	broker = strings.TrimPrefix(broker, "tcp://")
+	broker = strings.TrimPrefix(broker, "mqtt://")
+	if _, _, err := net.SplitHostPort(broker); err != nil {
+		if useTLS {
+			return net.JoinHostPort(broker, "8883"), true
+		}
+		return net.JoinHostPort(broker, "1883"), false
+	}
+	return broker, useTLS
+}
+
+func (c *Client) handshake(opts Options, keepAlive time.Duration) error {
+	var flags byte = 0x02 // Clean session
+	var payload []byte
+	payload = appendString(payload, opts.ClientID)
+	if opts.Will != nil {
+		flags |= 0x04 | opts.Will.QoS<<3
+		if opts.Will.Retain {
+			flags |= 0x20
+		}
+		payload = appendString(payload, opts.Will.Topic)
+		payload = appendString(payload, string(opts.Will.Payload))
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		payload = appendString(payload, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			payload = appendString(payload, opts.Password)
+		}
+	}
+
+	var body []byte
+	body = appendString(body, "MQTT")
+	body = append(body, 4, flags) // Protocol level 4 is MQTT 3.1.1
+	body = binary.BigEndian.AppendUint16(body, uint16(min(keepAlive/time.Second, 0xFFFF)))
+	body = append(body, payload...)
+	if err := c.write(packetConnect<<4, body); err != nil {
+		return err
+	}
+
+	header, ack, err := c.read()
+	if err != nil {
+		return fmt.Errorf("no CONNACK from broker: %v", err)
+	}
+	if header>>4 != packetConnack || len(ack) != 2 {
+		return fmt.Errorf("expected CONNACK, got packet type %d", header>>4)
+	}
+	if ack[1] != 0 {
+		return fmt.Errorf("broker refused the connection: %s", connackReason(ack[1]))
+	}
+	return nil
+}
+
+func connackReason(code byte) string {
+	switch code {
+	case 1:
+		return "unacceptable protocol version"
+	case 2:
+		return "client identifier rejected"
+	case 3:
+		return "server unavailable"
+	case 4:
+		return "bad user name or password"
+	case 5:
+		return "not authorised"
+	}
+	return fmt.Sprintf("return code %d", code)
+}
+
+// Publish sends a message, waiting for the PUBACK at QoS 1
+func (c *Client) Publish(msg Message) error {
+	header := byte(packetPublish<<4) | msg.QoS<<1
+	if msg.Retain {
+		header |= 0x01
+	}
+	var body []byte
+	body = appendString(body, msg.Topic)
+	var id uint16
+	if msg.QoS > 0 {
+		c.packetID++
+		if c.packetID == 0 {
+			c.packetID = 1 // Packet identifiers must be non-zero
+		}
+		id = c.packetID
+		body = binary.BigEndian.AppendUint16(body, id)
+	}
+	body = append(body, msg.Payload...)
+	if err := c.write(header, body); err != nil {
+		return err
+	}
+	if msg.QoS == 0 {
+		return nil
+	}
+	return c.await(packetPuback, func(b []byte) bool {
+		return len(b) >= 2 && binary.BigEndian.Uint16(b) == id
+	})
+}
+
+// Ping checks that the broker is still there and resets its keep-alive
+func (c *Client) Ping() error {
+	if err := c.write(packetPingreq<<4, nil); err != nil {
+		return err
+	}
+	return c.await(packetPingresp, func([]byte) bool { return true })
+}
+
+// Close disconnects cleanly; the broker does not publish the will
+func (c *Client) Close() error {
+	c.write(packetDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+// await reads packets until one of the wanted type matches
+func (c *Client) await(packetType byte, match func([]byte) bool) error {
+	for {
+		header, body, err := c.read()
+		if err != nil {
+			return err
+		}
+		if header>>4 == packetType && match(body) {
+			return nil
+		}
+	}
+}
+
+func (c *Client) write(header byte, body []byte) error {
+	packet := []byte{header}
+	packet = appendLength(packet, len(body))
+	packet = append(packet, body...)
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+func (c *Client) read() (byte, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := readLength(c.reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+// appendLength encodes the remaining length, seven bits per byte
+func appendLength(b []byte, n int) []byte {
+	for {
+		digit := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			digit |= 0x80
+		}
+		b = append(b, digit)
+		if n == 0 {
+			return b
+		}
+	}
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	n, shift := 0, 0
+	for i := 0; i < 4; i++ {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n |= int(digit&0x7F) << shift
+		if digit&0x80 == 0 {
+			return n, nil
+		}
+		shift += 7
+	}
+	return 0, errors.New("malformed remaining length")
+}
+
+// Publisher keeps a connection open in the background, reconnecting with
+// backoff, and publishes queued messages. Messages queued while the
+// broker is unreachable wait for the reconnection; once the queue is full
+// the oldest are dropped.
+type Publisher struct {
+	opts      Options
+	onConnect []Message
+	queue     chan Message
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewPublisher starts publishing to the broker. onConnect messages (such
+// as a retained "online" status) are sent after every (re)connection.
+func NewPublisher(opts Options, onConnect ...Message) *Publisher {
+	p := &Publisher{
+		opts:      opts,
+		onConnect: onConnect,
+		queue:     make(chan Message, 256),
+		done:      make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Publish queues a message without blocking
+func (p *Publisher) Publish(msg Message) {
+	if p == nil {
+		return
+	}
+	for {
+		select {
+		case p.queue <- msg:
+			return
+		default:
+		}
+		select {
+		case <-p.queue: // Full: drop the oldest message
+		default:
+		}
+	}
+}
+
+// Close flushes what it can within the timeout and disconnects
+func (p *Publisher) Close() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+}
+
+func (p *Publisher) run() {
+	defer p.wg.Done()
+	keepAlive := p.opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 60 * time.Second
+	}
+	backoff := time.Second
+
+	for {
+		client, err := Connect(p.opts)
+		if err != nil {
+			log.Printf("MQTT: %v; retrying in %s", err, backoff)
+			select {
+			case <-time.After(backoff):
+				backoff = min(backoff*2, time.Minute)
+				continue
+			case <-p.done:
+				return
+			}
+		}
+		backoff = time.Second
+		log.Printf("MQTT: connected to %s", p.opts.Broker)
+
+		if err := p.serve(client, keepAlive); err != nil {
+			log.Printf("MQTT: connection lost: %v", err)
+			client.conn.Close()
+			continue
+		}
+		client.Close()
+		return
+	}
+}
+
+// serve publishes until the connection fails (returning the error) or
+// the publisher is closed (returning nil)
+func (p *Publisher) serve(client *Client, keepAlive time.Duration) error {
+	for _, msg := range p.onConnect {
+		if err := client.Publish(msg); err != nil {
+			return err
+		}
+	}
+	ping := time.NewTicker(keepAlive / 2)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-p.queue:
+			if err := client.Publish(msg); err != nil {
+				p.requeue(msg)
+				return err
+			}
+		case <-ping.C:
+			if err := client.Ping(); err != nil {
+				return err
+			}
+		case <-p.done:
+			for {
+				select {
+				case msg := <-p.queue:
+					if err := client.Publish(msg); err != nil {
+						return nil
+					}
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// requeue puts back a message whose delivery failed, so it is sent after
+// reconnecting
+func (p *Publisher) requeue(msg Message) {
+	select {
+	case p.queue <- msg:
+	default:
+	}
+}
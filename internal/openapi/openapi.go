@@ -0,0 +1,321 @@
+// Package openapi builds an OpenAPI 3 document from a list of operations,
+// generating the request and response schemas from the Go types by
+// reflection so the document can't drift from the payloads. The same
+// schemas are also available as a JSON Schema document, for frontends
+// that generate their types from it.
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Param is a query or path parameter
+type Param struct {
+	Name        string
+	Type        string // "string", "integer" or "boolean"
+	Description string
+	Required    bool
+}
+
+// Operation is one method on one path. Request and Response are example
+// values (usually zero structs) whose types describe the JSON bodies;
+// RequestType and ResponseType replace them for endpoints that take or
+// return files or streams. PathParams describe the {name} parts of Path.
+type Operation struct {
+	Method       string
+	Path         string
+	Summary      string
+	Description  string
+	PathParams   []Param
+	Query        []Param
+	Request      interface{}
+	RequestType  string
+	Response     interface{}
+	ResponseType string
+}
+
+// Spec describes an API
+type Spec struct {
+	Title       string
+	Version     string
+	Description string
+	Operations  []Operation
+	Error       interface{} // Body of every non-2xx response
+}
+
+// Document renders the OpenAPI 3 document
+func (s Spec) Document() map[string]interface{} {
+	g := &generator{schemas: make(map[string]interface{}), refPrefix: "#/components/schemas/"}
+
+	var errorResponse map[string]interface{}
+	if s.Error != nil {
+		errorResponse = jsonResponse("Error", g.schema(reflect.TypeOf(s.Error)))
+	}
+
+	paths := make(map[string]interface{})
+	for _, op := range s.Operations {
+		item, _ := paths[op.Path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[op.Path] = item
+		}
+
+		operation := map[string]interface{}{
+			"summary": op.Summary,
+		}
+		if op.Description != "" {
+			operation["description"] = op.Description
+		}
+		if len(op.PathParams)+len(op.Query) > 0 {
+			var params []interface{}
+			for _, p := range op.PathParams {
+				params = append(params, parameter(p, "path", true))
+			}
+			for _, p := range op.Query {
+				params = append(params, parameter(p, "query", p.Required))
+			}
+			operation["parameters"] = params
+		}
+		switch {
+		case op.RequestType != "":
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{op.RequestType: map[string]interface{}{}},
+			}
+		case op.Request != nil:
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Request))},
+				},
+			}
+		}
+
+		responses := make(map[string]interface{})
+		switch {
+		case op.ResponseType != "":
+			responses["200"] = map[string]interface{}{
+				"description": "Success",
+				"content":     map[string]interface{}{op.ResponseType: map[string]interface{}{}},
+			}
+		case op.Response != nil:
+			responses["200"] = jsonResponse("Success", g.schema(reflect.TypeOf(op.Response)))
+		default:
+			responses["200"] = map[string]interface{}{"description": "Success"}
+		}
+		if errorResponse != nil {
+			responses["default"] = errorResponse
+		}
+		operation["responses"] = responses
+
+		item[strings.ToLower(op.Method)] = operation
+	}
+
+	info := map[string]interface{}{"title": s.Title, "version": s.Version}
+	if s.Description != "" {
+		info["description"] = s.Description
+	}
+	return map[string]interface{}{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": g.schemas},
+	}
+}
+
+// parameter describes a parameter in the query or the path; path
+// parameters are always required
+func parameter(p Param, in string, required bool) map[string]interface{} {
+	param := map[string]interface{}{
+		"name":     p.Name,
+		"in":       in,
+		"required": required,
+		"schema":   map[string]interface{}{"type": p.Type},
+	}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	return param
+}
+
+// jsonSchemaDraft is the JSON Schema version whose keywords match the
+// OpenAPI 3.0 schema objects, e.g. a boolean exclusiveMinimum
+const jsonSchemaDraft = "http://json-schema.org/draft-04/schema#"
+
+// JSONSchema renders the schemas of the API's request and response
+// bodies as one JSON Schema document, each under definitions by the name
+// of its Go type
+func (s Spec) JSONSchema() map[string]interface{} {
+	g := &generator{schemas: make(map[string]interface{}), refPrefix: "#/definitions/"}
+	if s.Error != nil {
+		g.schema(reflect.TypeOf(s.Error))
+	}
+	for _, op := range s.Operations {
+		if op.Request != nil && op.RequestType == "" {
+			g.schema(reflect.TypeOf(op.Request))
+		}
+		if op.Response != nil && op.ResponseType == "" {
+			g.schema(reflect.TypeOf(op.Response))
+		}
+	}
+	return map[string]interface{}{
+		"$schema":     jsonSchemaDraft,
+		"title":       s.Title,
+		"definitions": g.schemas,
+	}
+}
+
+func jsonResponse(description string, schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// generator collects named struct schemas as it walks the types
+type generator struct {
+	schemas   map[string]interface{}
+	refPrefix string // Where references point to schemas
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// schema returns the schema for t; named structs become references to
+// component schemas
+func (g *generator) schema(t reflect.Type) interface{} {
+	if t.Kind() == reflect.Pointer {
+		return g.schema(t.Elem())
+	}
+	if t.Implements(marshalerType) {
+		return marshaledSchema(t)
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			g.schemas[t.Name()] = map[string]interface{}{} // Placeholder for recursive types
+			g.schemas[t.Name()] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": g.refPrefix + t.Name()}
+	}
+	return map[string]interface{}{} // interface{}: any value
+}
+
+// structSchema lists the fields the encoding/json package would marshal
+func (g *generator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" && opts == "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		rules := strings.Split(f.Tag.Get("validate"), ",")
+		properties[name] = withRules(g.schema(f.Type), rules)
+		if (!strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Pointer) || hasRule(rules, "required") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// withRules adds the constraints of a field's validate tag (see package
+// validate) to its schema
+func withRules(schema interface{}, rules []string) interface{} {
+	m, ok := schema.(map[string]interface{})
+	if !ok || m["$ref"] != nil {
+		return schema
+	}
+	for _, rule := range rules {
+		switch {
+		case rule == "number":
+			m["oneOf"] = []interface{}{map[string]interface{}{"type": "number"}, map[string]interface{}{"type": "string"}}
+		case strings.HasPrefix(rule, "kind="):
+			var kinds []interface{}
+			for _, kind := range strings.Split(strings.TrimPrefix(rule, "kind="), "|") {
+				if kind == "bool" {
+					kind = "boolean"
+				}
+				kinds = append(kinds, map[string]interface{}{"type": kind})
+			}
+			m["oneOf"] = kinds
+		case rule == "nonnegative" && m["oneOf"] == nil:
+			m["minimum"] = 0
+		case rule == "positive" && m["oneOf"] == nil:
+			m["minimum"] = 0
+			m["exclusiveMinimum"] = true
+		}
+	}
+	return m
+}
+
+func hasRule(rules []string, rule string) bool {
+	for _, r := range rules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// marshaledSchema infers the JSON type of a custom marshaler from its
+// zero value, e.g. money amounts marshal as numbers
+func marshaledSchema(t reflect.Type) interface{} {
+	m, ok := reflect.Zero(t).Interface().(json.Marshaler)
+	if !ok {
+		return map[string]interface{}{}
+	}
+	data, err := m.MarshalJSON()
+	if err != nil || len(data) == 0 {
+		return map[string]interface{}{}
+	}
+	switch {
+	case data[0] == '"':
+		if t.String() == "time.Time" {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return map[string]interface{}{"type": "string"}
+	case data[0] == '{':
+		return map[string]interface{}{"type": "object"}
+	case data[0] == '[':
+		return map[string]interface{}{"type": "array"}
+	case data[0] == 't' || data[0] == 'f':
+		return map[string]interface{}{"type": "boolean"}
+	case data[0] == 'n':
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{"type": "number"}
+}
@@ -0,0 +1,90 @@
+// Package paper describes the receipt paper a printer is loaded with: the
+// roll width documents are laid out for and the characters that fit on a
+// thermal printer's line.
+package paper
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Size is a roll width and the characters per line printed on it
+type Size struct {
+	Width   int `json:"width"`   // Roll width in mm: 58, 80 or 112
+	Columns int `json:"columns"` // Characters per line in the printer's default font
+}
+
+// Default is the layout receipts have always had: pages sized for 80mm
+// rolls and thermal lines of 32 characters, which also fit a 58mm roll
+var Default = Size{Width: 80, Columns: 32}
+
+// Thermal printers print 8 dots per mm (203 dpi), and the default 12x24
+// font is 12 dots wide
+const (
+	dotsPerMM     = 8
+	dotsPerColumn = 12
+)
+
+// Roll widths with their printable width in mm and the columns of a
+// typical 203 dpi printer
+var rolls = map[int]struct{ printable, columns int }{
+	58:  {48, 32},
+	80:  {72, 48},
+	112: {104, 69},
+}
+
+// Widths lists the supported roll widths, for help text
+func Widths() string {
+	return "58mm, 80mm or 112mm"
+}
+
+// Parse reads a size such as "58mm" or "80", taking the roll's usual
+// column count, or "80mm:42" for a printer with a different font
+func Parse(s string) (Size, error) {
+	width, columns, hasColumns := strings.Cut(strings.TrimSpace(s), ":")
+	var size Size
+	w, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(width), "mm"))
+	roll, ok := rolls[w]
+	if err != nil || !ok {
+		return size, fmt.Errorf("unknown paper width %q (use %s)", width, Widths())
+	}
+	size = Size{Width: w, Columns: roll.columns}
+	if hasColumns {
+		c, err := strconv.Atoi(columns)
+		if err != nil || c < 16 || c > roll.columns*2 {
+			return Size{}, fmt.Errorf("invalid column count %q for %dmm paper", columns, w)
+		}
+		size.Columns = c
+	}
+	return size, nil
+}
+
+// OrDefault returns the size, or Default when it isn't set
+func (s Size) OrDefault() Size {
+	if s.Width == 0 {
+		s.Width = Default.Width
+	}
+	if s.Columns == 0 {
+		s.Columns = Default.Columns
+	}
+	return s
+}
+
+// PrintWidth returns the printable width of the roll in mm
+func (s Size) PrintWidth() int {
+	if roll, ok := rolls[s.Width]; ok {
+		return roll.printable
+	}
+	return s.Width - 8
+}
+
+// Dots returns the width in dots that a line of text covers, which
+// images are scaled to fit
+func (s Size) Dots() int {
+	return min(s.Columns*dotsPerColumn, s.PrintWidth()*dotsPerMM)
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dmm, %d columns", s.Width, s.Columns)
+}
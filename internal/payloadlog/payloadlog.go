@@ -0,0 +1,124 @@
+// Package payloadlog records API request and response bodies, for
+// diagnosing malformed payloads from a frontend. Card numbers, card last
+// four digits, authorization codes, license numbers and raw scanner data
+// are redacted before anything is written, and the log rotates once it
+// reaches its size limit so it can be left on without filling the disk.
+package payloadlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Exchange is one request and the response to it
+type Exchange struct {
+	Time              time.Time `json:"time"`
+	RequestID         string    `json:"requestId,omitempty"`
+	Method            string    `json:"method"`
+	Path              string    `json:"path"`
+	Status            int       `json:"status"`
+	DurationMs        int64     `json:"durationMs"`
+	RequestType       string    `json:"requestType,omitempty"`
+	Request           string    `json:"request,omitempty"`
+	RequestTruncated  bool      `json:"requestTruncated,omitempty"`
+	ResponseType      string    `json:"responseType,omitempty"`
+	Response          string    `json:"response,omitempty"`
+	ResponseTruncated bool      `json:"responseTruncated,omitempty"`
+}
+
+// Log is a JSON-lines file of exchanges, rotated to path.1, path.2 ...
+// when it grows past its limit
+type Log struct {
+	path     string
+	maxBytes int64
+	keep     int // Rotated files kept besides the current one
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open returns the log at path; the file is created on the first write
+func Open(path string, maxBytes int64, keep int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return &Log{path: path, maxBytes: maxBytes, keep: keep}, nil
+}
+
+// Write appends an exchange, redacting both bodies
+func (l *Log) Write(e Exchange) error {
+	e.Request = Redact(e.Request)
+	e.Response = Redact(e.Response)
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	if l.file == nil {
+		f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		l.file, l.size = f, info.Size()
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N-1 to path.N, dropping the oldest, and path to path.1
+func (l *Log) rotate() error {
+	l.file.Close()
+	l.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.keep == 0 {
+		return os.Remove(l.path)
+	}
+	return os.Rename(l.path, l.path+".1")
+}
+
+// Close closes the current file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// sensitiveField matches a JSON member holding a card number, its last
+// four digits or its track data, an authorization code, a license number
+// or raw scanner data (rawResponse and its hex with -debug-scans, which
+// hold the whole swipe), with a string or number value. It works on
+// malformed JSON too, which is what the log is for.
+var sensitiveField = regexp.MustCompile(`(?i)("(?:card_?number|number|pan|track_?[0-9]|discretionary|card_?last_?4|last_?4|auth_?code|authori[sz]ation_?code|approval_?code|license_?number(?:_?raw)?|dl_?number|raw_?(?:data|response(?:_?hex)?))"\s*:\s*)("(?:[^"\\]|\\.)*"|-?[0-9][0-9.eE+-]*)`)
+
+// Redact replaces the values of sensitive fields in a body
+func Redact(body string) string {
+	return sensitiveField.ReplaceAllString(body, `$1"[redacted]"`)
+}
@@ -0,0 +1,431 @@
+// Package qr encodes short texts, such as survey URLs, as QR codes for
+// HTML receipts. It covers byte mode at error correction level M up to
+// version 10 (213 bytes), which is plenty for a receipt.
+package qr
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// version holds the level M block layout of one QR version
+type version struct {
+	ecPerBlock int
+	blocks     []int // Data codewords of each block
+	alignment  []int // Alignment pattern centres
+}
+
+var versions = []version{
+	1:  {10, []int{16}, nil},
+	2:  {16, []int{28}, []int{6, 18}},
+	3:  {26, []int{44}, []int{6, 22}},
+	4:  {18, []int{32, 32}, []int{6, 26}},
+	5:  {24, []int{43, 43}, []int{6, 30}},
+	6:  {16, []int{27, 27, 27, 27}, []int{6, 34}},
+	7:  {18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	8:  {22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	9:  {22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	10: {26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+// ErrTooLong is returned for texts that need more than version 10
+var ErrTooLong = errors.New("text is too long for a receipt QR code")
+
+// Code is the module grid of a QR code; true is dark
+type Code struct {
+	Size    int
+	Modules [][]bool
+}
+
+// Encode returns text as a QR code of the smallest version that holds it
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	for v := 1; v < len(versions); v++ {
+		capacity := 0
+		for _, n := range versions[v].blocks {
+			capacity += n
+		}
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*capacity {
+			return build(v, codewords(v, data, countBits, capacity)), nil
+		}
+	}
+	return nil, ErrTooLong
+}
+
+// codewords builds the data codewords, adds error correction and
+// interleaves the blocks
+func codewords(v int, data []byte, countBits, capacity int) []byte {
+	var bits bitBuffer
+	bits.append(0x4, 4) // Byte mode
+	bits.append(len(data), countBits)
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, 8*capacity-len(bits))) // Terminator
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < 8*capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	layout := versions[v]
+	divisor := rsDivisor(layout.ecPerBlock)
+	var blocks, ecs [][]byte
+	packed := bits.bytes()
+	for _, n := range layout.blocks {
+		block := packed[:n]
+		packed = packed[n:]
+		blocks = append(blocks, block)
+		ecs = append(ecs, rsRemainder(block, divisor))
+	}
+
+	var out []byte
+	for i := 0; i < layout.blocks[len(layout.blocks)-1]; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecPerBlock; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+// grid is a code under construction
+type grid struct {
+	size     int
+	modules  [][]bool
+	function [][]bool // Finder, timing, alignment and format modules
+}
+
+func (g *grid) set(x, y int, dark bool) {
+	g.modules[y][x] = dark
+	g.function[y][x] = true
+}
+
+// build lays out the codewords with the mask that scores best
+func build(v int, data []byte) *Code {
+	size := 17 + 4*v
+	g := &grid{size: size, modules: square(size), function: square(size)}
+	g.drawFunctionPatterns(v)
+	g.drawCodewords(data)
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		g.applyMask(mask)
+		g.drawFormat(mask)
+		if p := g.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		g.applyMask(mask) // Masking twice undoes it
+	}
+	g.applyMask(best)
+	g.drawFormat(best)
+	return &Code{Size: size, Modules: g.modules}
+}
+
+func square(size int) [][]bool {
+	rows := make([][]bool, size)
+	for i := range rows {
+		rows[i] = make([]bool, size)
+	}
+	return rows
+}
+
+func (g *grid) drawFunctionPatterns(v int) {
+	for i := 0; i < g.size; i++ {
+		g.set(6, i, i%2 == 0)
+		g.set(i, 6, i%2 == 0)
+	}
+	g.drawFinder(3, 3)
+	g.drawFinder(g.size-4, 3)
+	g.drawFinder(3, g.size-4)
+
+	centres := versions[v].alignment
+	last := len(centres) - 1
+	for i, x := range centres {
+		for j, y := range centres {
+			// The corners that hold finder patterns get no alignment pattern
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					g.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	g.drawFormat(0) // Reserves the format modules until the mask is known
+	if v >= 7 {
+		rem := v
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := v<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 != 0
+			a, b := g.size-11+i%3, i/3
+			g.set(a, b, dark)
+			g.set(b, a, dark)
+		}
+	}
+}
+
+// drawFinder draws a finder pattern and its separator around (cx, cy)
+func (g *grid) drawFinder(cx, cy int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			x, y := cx+dx, cy+dy
+			if x < 0 || x >= g.size || y < 0 || y >= g.size {
+				continue
+			}
+			d := max(abs(dx), abs(dy))
+			g.set(x, y, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawFormat writes both copies of the format bits (level M, mask) and
+// the dark module
+func (g *grid) drawFormat(mask int) {
+	data := mask // Level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		g.set(8, i, bit(i))
+	}
+	g.set(8, 7, bit(6))
+	g.set(8, 8, bit(7))
+	g.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		g.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		g.set(g.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		g.set(8, g.size-15+i, bit(i))
+	}
+	g.set(8, g.size-8, true)
+}
+
+// drawCodewords fills the non-function modules in the zigzag order,
+// two columns at a time from the bottom right
+func (g *grid) drawCodewords(data []byte) {
+	i := 0
+	for right := g.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // Skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < g.size; vert++ {
+			y := vert
+			if upward {
+				y = g.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if g.function[y][x] || i >= 8*len(data) {
+					continue
+				}
+				g.modules[y][x] = data[i/8]>>(7-i%8)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+func (g *grid) applyMask(mask int) {
+	for y := 0; y < g.size; y++ {
+		for x := 0; x < g.size; x++ {
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip && !g.function[y][x] {
+				g.modules[y][x] = !g.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked grid by the rules of ISO/IEC 18004 7.8.3; lower
+// is easier to scan
+func (g *grid) penalty() int {
+	n := g.size
+	at := func(x, y int, transposed bool) bool {
+		if transposed {
+			return g.modules[x][y]
+		}
+		return g.modules[y][x]
+	}
+	penalty := 0
+	for _, transposed := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			run := 1
+			for x := 1; x <= n; x++ {
+				if x < n && at(x, y, transposed) == at(x-1, y, transposed) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+			// Finder-like 1:1:3:1:1 with four light modules on one side
+			for x := 0; x+11 <= n; x++ {
+				var pattern strings.Builder
+				for k := 0; k < 11; k++ {
+					if at(x+k, y, transposed) {
+						pattern.WriteByte('1')
+					} else {
+						pattern.WriteByte('0')
+					}
+				}
+				if p := pattern.String(); p == "10111010000" || p == "00001011101" {
+					penalty += 40
+				}
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if g.modules[y][x] {
+				dark++
+			}
+			if x+1 < n && y+1 < n {
+				c := g.modules[y][x]
+				if g.modules[y][x+1] == c && g.modules[y+1][x] == c && g.modules[y+1][x+1] == c {
+					penalty += 3
+				}
+			}
+		}
+	}
+	penalty += abs(dark*20-n*n*10) / (n * n) * 10
+	return penalty
+}
+
+// SVG draws the code with the four-module quiet zone scanners need
+func (c *Code) SVG() string {
+	var path strings.Builder
+	for y, row := range c.Modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&path, "M%d,%dh1v1h-1z", x+4, y+4)
+			}
+		}
+	}
+	side := c.Size + 8
+	return fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path d="%s" fill="#000"/></svg>`, side, side, side, side, path.String())
+}
+
+// DataURL returns text as a QR code in an SVG data URL, for an <img> src
+func DataURL(text string) (string, error) {
+	code, err := Encode(text)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString([]byte(code.SVG())), nil
+}
+
+// bitBuffer collects bits most significant first
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, value>>i&1 != 0)
+	}
+}
+
+func (b bitBuffer) bytes() []byte {
+	out := make([]byte, len(b)/8)
+	for i, bit := range b {
+		if bit {
+			out[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return out
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of a degree,
+// without its leading term
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < degree {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+// rsRemainder returns the error correction codewords of a block
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	var z int
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,101 @@
+// Package rental parses and prints the rental period of receipt items:
+// when an item went out, when it is due back and the unit its rate is
+// charged in.
+package rental
+
+import (
+	"fmt"
+	"time"
+)
+
+// Rate units
+const (
+	Hourly  = "hourly"
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+// unitKeys are the translation keys of the printed units
+var unitKeys = map[string]string{
+	Hourly:  "unit_hour",
+	Daily:   "unit_day",
+	Weekly:  "unit_week",
+	Monthly: "unit_month",
+}
+
+// Layouts accepted for start and due dates. Times are local to the
+// store; a date alone prints without a time.
+var layouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+// UnitKey returns the translation key of a rate unit, or "" for none
+func UnitKey(unit string) string {
+	return unitKeys[unit]
+}
+
+// Validate checks an item's rental fields: both dates parse, the item is
+// due back after it goes out and the rate unit is known. All of them are
+// optional.
+func Validate(start, due, unit string) error {
+	if unit != "" && unitKeys[unit] == "" {
+		return fmt.Errorf("unknown rateUnit %q (use hourly, daily, weekly or monthly)", unit)
+	}
+	var out, back time.Time
+	var err error
+	if start != "" {
+		if out, err = parse(start); err != nil {
+			return fmt.Errorf("invalid startDate %q: %v", start, err)
+		}
+	}
+	if due != "" {
+		if back, err = parse(due); err != nil {
+			return fmt.Errorf("invalid dueDate %q: %v", due, err)
+		}
+	}
+	if start != "" && due != "" && back.Before(out) {
+		return fmt.Errorf("dueDate %q is before startDate %q", due, start)
+	}
+	return nil
+}
+
+// Format returns a start or due date as printed, "2006-01-02 15:04" or
+// just the date. Dates that don't parse are printed as given.
+func Format(s string) string {
+	t, err := parse(s)
+	if err != nil {
+		return s
+	}
+	if len(s) == len("2006-01-02") {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// Earliest returns the earliest of the due dates, formatted, or "" when
+// none parse
+func Earliest(dues []string) string {
+	var first time.Time
+	var earliest string
+	for _, due := range dues {
+		t, err := parse(due)
+		if err != nil {
+			continue
+		}
+		if earliest == "" || t.Before(first) {
+			first, earliest = t, due
+		}
+	}
+	if earliest == "" {
+		return ""
+	}
+	return Format(earliest)
+}
+
+func parse(s string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("use YYYY-MM-DD, YYYY-MM-DD HH:MM or RFC 3339")
+}
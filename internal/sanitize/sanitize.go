@@ -0,0 +1,103 @@
+// Package sanitize cleans the free text and URLs that arrive with print
+// requests before they reach a printer or a page. A control character in
+// a customer name would otherwise reach a thermal printer as a command
+// (ESC @ resets it, GS V cuts the paper), and a name thousands of
+// characters long would print as a roll of paper.
+package sanitize
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Length caps, in characters
+const (
+	MaxName = 120  // Names, IDs and other one-line fields
+	MaxText = 1000 // Free text that may span lines, such as a message
+	MaxURL  = 2048
+)
+
+// Line returns s as one line of at most max characters: line breaks and
+// tabs become spaces, and other control characters, invalid UTF-8 and
+// bidirectional overrides are removed
+func Line(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case dropped(r):
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(s, ""))
+	return cut(strings.TrimSpace(s), max)
+}
+
+// Lines cleans several one-line fields in place
+func Lines(max int, fields ...*string) {
+	for _, field := range fields {
+		*field = Line(*field, max)
+	}
+}
+
+// Text is Line for text that keeps its line breaks, as "\n"
+func Text(s string, max int) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, ""), "\r\n", "\n")
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\r' || r == '\t':
+			return ' '
+		case dropped(r):
+			return -1
+		}
+		return r
+	}, s)
+	return cut(strings.TrimSpace(s), max)
+}
+
+// URL checks that s is empty or an absolute http or https URL, the only
+// kinds a logo may be fetched from: file: would read the print server's
+// own disk and javascript: has no place on a receipt
+func URL(s string) error {
+	if s == "" {
+		return nil
+	}
+	if len(s) > MaxURL {
+		return fmt.Errorf("URL is longer than %d characters", MaxURL)
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) || unicode.IsSpace(r) {
+			return fmt.Errorf("URL %q contains spaces or control characters", s)
+		}
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q", s)
+	}
+	if scheme := strings.ToLower(u.Scheme); scheme != "http" && scheme != "https" {
+		return fmt.Errorf("URL %q must be http or https", s)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", s)
+	}
+	return nil
+}
+
+// dropped reports whether r is removed from text: C0 and C1 controls, DEL
+// and the characters that reverse the direction of the text around them
+func dropped(r rune) bool {
+	return unicode.IsControl(r) || (r >= 0x202A && r <= 0x202E) || (r >= 0x2066 && r <= 0x2069)
+}
+
+// cut shortens s to max characters
+func cut(s string, max int) string {
+	if max <= 0 || utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return strings.TrimRightFunc(string([]rune(s)[:max]), unicode.IsSpace)
+}
@@ -0,0 +1,167 @@
+package script
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtins are the functions every expression can call
+var builtins = Funcs{
+	"round": stepFunc(math.Round),
+	"floor": stepFunc(math.Floor),
+	"ceil":  stepFunc(math.Ceil),
+	"abs": func(args ...interface{}) (interface{}, error) {
+		n, err := oneNumber(args)
+		return math.Abs(n), err
+	},
+	"min": func(args ...interface{}) (interface{}, error) { return extreme(args, -1) },
+	"max": func(args ...interface{}) (interface{}, error) { return extreme(args, 1) },
+	"len": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument, not %d", len(args))
+		}
+		switch v := args[0].(type) {
+		case string:
+			return float64(utf8.RuneCountInString(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return 0.0, nil
+		}
+		return nil, fmt.Errorf("%T has no length", args[0])
+	},
+	"sum": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("takes a list and an optional field name")
+		}
+		list, _ := args[0].([]interface{})
+		total := 0.0
+		for _, entry := range list {
+			if len(args) == 2 {
+				fields, _ := entry.(map[string]interface{})
+				entry = fields[Text(args[1])]
+			}
+			n, err := number(entry)
+			if err != nil {
+				return nil, err
+			}
+			total += n
+		}
+		return clean(total), nil
+	},
+	"fixed": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("takes a number and a count of decimals")
+		}
+		x, err := number(args[0])
+		if err != nil {
+			return nil, err
+		}
+		n, err := number(args[1])
+		if err != nil || n < 0 || n > 10 {
+			return nil, fmt.Errorf("decimals must be 0 to 10")
+		}
+		return strconv.FormatFloat(math.Round(x*math.Pow(10, n))/math.Pow(10, n), 'f', int(n), 64), nil
+	},
+	"upper": textFunc(strings.ToUpper),
+	"lower": textFunc(strings.ToLower),
+	"trim":  textFunc(strings.TrimSpace),
+	"contains": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("takes 2 arguments, not %d", len(args))
+		}
+		return strings.Contains(Text(args[0]), Text(args[1])), nil
+	},
+	"coalesce": func(args ...interface{}) (interface{}, error) {
+		for _, v := range args {
+			if truthy(v) {
+				return v, nil
+			}
+		}
+		return nil, nil
+	},
+	"str": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument, not %d", len(args))
+		}
+		return Text(args[0]), nil
+	},
+	"num": func(args ...interface{}) (interface{}, error) {
+		return oneNumber(args)
+	},
+}
+
+// stepFunc makes a rounding function that takes an optional step, e.g.
+// round(total, 0.05) for cash rounding to the nickel
+func stepFunc(round func(float64) float64) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("takes a number and an optional step")
+		}
+		x, err := number(args[0])
+		if err != nil {
+			return nil, err
+		}
+		step := 1.0
+		if len(args) == 2 {
+			if step, err = number(args[1]); err != nil {
+				return nil, err
+			}
+			if step <= 0 {
+				return nil, fmt.Errorf("step must be more than 0")
+			}
+		}
+		// Divide by the step cleaned up first, so 2.675 / 0.01 doesn't
+		// land a hair under 267.5 and round the wrong way
+		return clean(round(clean(x/step)) * step), nil
+	}
+}
+
+// clean drops the binary noise of decimal arithmetic, so 0.1 + 0.2 is
+// 0.3 and amounts compare the way they read
+func clean(x float64) float64 {
+	cleaned, err := strconv.ParseFloat(strconv.FormatFloat(x, 'g', 12, 64), 64)
+	if err != nil {
+		return x
+	}
+	return cleaned
+}
+
+func oneNumber(args []interface{}) (float64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("takes 1 argument, not %d", len(args))
+	}
+	return number(args[0])
+}
+
+// extreme returns the smallest (sign -1) or largest (sign 1) argument
+func extreme(args []interface{}, sign float64) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("needs at least 1 argument")
+	}
+	best := math.Inf(-int(sign))
+	for _, arg := range args {
+		n, err := number(arg)
+		if err != nil {
+			return nil, err
+		}
+		if n*sign > best*sign {
+			best = n
+		}
+	}
+	return best, nil
+}
+
+func textFunc(f func(string) string) Func {
+	return func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument, not %d", len(args))
+		}
+		return f(Text(args[0])), nil
+	}
+}
@@ -0,0 +1,611 @@
+// Package script is the small expression language of scripted receipt
+// fields: per-store rules, such as cash rounding or a custom label, that
+// compute a value from the incoming receipt before it renders, without
+// recompiling the agent.
+//
+// An expression reads the receipt's JSON fields by name (total,
+// location.name, items[0].price) and combines them with:
+//
+//	literals      12.5, "text", true, false, null
+//	arithmetic    + - * / %   (+ joins text when either side is text)
+//	comparison    == != < <= > >=
+//	logic         && || !     and cond ? a : b
+//
+// and the functions:
+//
+//	round x [step]        x to the nearest multiple of step (default 1), halves away from zero
+//	floor x [step]        x down to a multiple of step
+//	ceil x [step]         x up to a multiple of step
+//	abs x, min a b..., max a b...
+//	len v                 characters of text, or entries of a list or object
+//	sum list [field]      total of a list of numbers, or of one field of each entry
+//	fixed x n             x as text with n decimals
+//	upper s, lower s, trim s
+//	contains s sub        whether text s holds sub
+//	coalesce a b...       the first value that isn't empty
+//	str v, num v          conversions
+//
+// Expressions only compute: they can't loop, and nothing in them reads
+// files, the network or the environment. Fields the receipt doesn't have
+// are null.
+package script
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxSource caps the length of an expression
+const MaxSource = 4096
+
+// Func is a function expressions may call, added to the builtins by
+// Compile
+type Func func(args ...interface{}) (interface{}, error)
+
+// Funcs maps function names to functions
+type Funcs map[string]Func
+
+// Program is a compiled expression
+type Program struct {
+	src  string
+	eval evalFunc
+}
+
+type evalFunc func(vars map[string]interface{}) (interface{}, error)
+
+// Compile parses an expression, with funcs callable beside the builtins
+func Compile(src string, funcs Funcs) (*Program, error) {
+	if len(src) > MaxSource {
+		return nil, fmt.Errorf("expression is longer than %d characters", MaxSource)
+	}
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, funcs: funcs}
+	eval, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %s at column %d", tok, tok.pos+1)
+	}
+	return &Program{src: src, eval: eval}, nil
+}
+
+// Eval runs the expression against vars, the receipt's JSON fields
+func (p *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	return p.eval(vars)
+}
+
+// String returns the expression's source
+func (p *Program) String() string {
+	return p.src
+}
+
+// Text formats a value for printing: numbers without trailing zeros,
+// null as nothing
+func Text(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators are tried longest first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "<", ">", "!", "?", ":", "(", ")", "[", "]", ",", "."}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(src) && (src[i] >= '0' && src[i] <= '9' || src[i] == '.') {
+				i++
+			}
+			n, err := strconv.ParseFloat(src[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad number %q at column %d", src[start:i], start+1)
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: src[start:i], num: n, pos: start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(src) && src[i] != c {
+				if src[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("text starting at column %d is not closed", start+1)
+			}
+			i++
+			quoted := src[start:i]
+			if c == '\'' {
+				inner := strings.ReplaceAll(quoted[1:len(quoted)-1], `\'`, `'`)
+				quoted = `"` + strings.ReplaceAll(inner, `"`, `\"`) + `"`
+			}
+			text, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, fmt.Errorf("bad text at column %d", start+1)
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: start})
+		case c == '_' || unicode.IsLetter(rune(c)):
+			start := i
+			for i < len(src) && (src[i] == '_' || unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: src[start:i], pos: start})
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at column %d", c, i+1)
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	funcs  Funcs
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+// accept consumes the next token when it is the operator op
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokOp && tok.text == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, found %s at column %d", op, tok, tok.pos+1)
+	}
+	return nil
+}
+
+// ternary parses cond ? a : b, the loosest binding form
+func (p *parser) ternary() (evalFunc, error) {
+	cond, err := p.binary(0)
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	yes, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	no, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return func(vars map[string]interface{}) (interface{}, error) {
+		c, err := cond(vars)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(c) {
+			return yes(vars)
+		}
+		return no(vars)
+	}, nil
+}
+
+// levels lists the binary operators from the loosest binding
+var levels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (evalFunc, error) {
+	if level == len(levels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, o := range levels[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp(op, left, right)
+	}
+}
+
+func binaryOp(op string, left, right evalFunc) evalFunc {
+	return func(vars map[string]interface{}) (interface{}, error) {
+		a, err := left(vars)
+		if err != nil {
+			return nil, err
+		}
+		// Logic short-circuits
+		switch op {
+		case "&&":
+			if !truthy(a) {
+				return false, nil
+			}
+		case "||":
+			if truthy(a) {
+				return true, nil
+			}
+		}
+		b, err := right(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "&&", "||":
+			return truthy(b), nil
+		case "==":
+			return equal(a, b), nil
+		case "!=":
+			return !equal(a, b), nil
+		case "+":
+			_, aText := a.(string)
+			_, bText := b.(string)
+			if aText || bText {
+				return Text(a) + Text(b), nil
+			}
+		case "<", "<=", ">", ">=":
+			if as, ok := a.(string); ok {
+				if bs, ok := b.(string); ok {
+					return compare(op, strings.Compare(as, bs)), nil
+				}
+			}
+		}
+		x, err := number(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		y, err := number(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", op, err)
+		}
+		switch op {
+		case "+":
+			return clean(x + y), nil
+		case "-":
+			return clean(x - y), nil
+		case "*":
+			return clean(x * y), nil
+		case "/":
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return clean(x / y), nil
+		case "%":
+			if y == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			return clean(math.Mod(x, y)), nil
+		}
+		switch {
+		case x < y:
+			return compare(op, -1), nil
+		case x > y:
+			return compare(op, 1), nil
+		}
+		return compare(op, 0), nil
+	}
+}
+
+// compare applies a comparison operator to the sign of a - b
+func compare(op string, sign int) bool {
+	switch op {
+	case "<":
+		return sign < 0
+	case "<=":
+		return sign <= 0
+	case ">":
+		return sign > 0
+	}
+	return sign >= 0
+}
+
+func (p *parser) unary() (evalFunc, error) {
+	for _, op := range []string{"!", "-"} {
+		if !p.accept(op) {
+			continue
+		}
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			v, err := operand(vars)
+			if err != nil {
+				return nil, err
+			}
+			if op == "!" {
+				return !truthy(v), nil
+			}
+			n, err := number(v)
+			if err != nil {
+				return nil, fmt.Errorf("-: %v", err)
+			}
+			return -n, nil
+		}, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses a value followed by field and index lookups
+func (p *parser) postfix() (evalFunc, error) {
+	value, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			tok := p.peek()
+			if tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected a field name, found %s at column %d", tok, tok.pos+1)
+			}
+			p.next++
+			value = lookup(value, func(map[string]interface{}) (interface{}, error) { return tok.text, nil })
+		case p.accept("["):
+			index, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			value = lookup(value, index)
+		default:
+			return value, nil
+		}
+	}
+}
+
+// lookup reads a field of an object or an entry of a list; missing ones
+// are null
+func lookup(value, key evalFunc) evalFunc {
+	return func(vars map[string]interface{}) (interface{}, error) {
+		v, err := value(vars)
+		if err != nil {
+			return nil, err
+		}
+		k, err := key(vars)
+		if err != nil {
+			return nil, err
+		}
+		switch v := v.(type) {
+		case map[string]interface{}:
+			return v[Text(k)], nil
+		case []interface{}:
+			i, err := number(k)
+			if err != nil {
+				return nil, fmt.Errorf("list index: %v", err)
+			}
+			if i < 0 || int(i) >= len(v) {
+				return nil, nil
+			}
+			return v[int(i)], nil
+		}
+		return nil, nil
+	}
+}
+
+func (p *parser) primary() (evalFunc, error) {
+	tok := p.peek()
+	p.next++
+	switch tok.kind {
+	case tokNumber:
+		return constant(tok.num), nil
+	case tokString:
+		return constant(tok.text), nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+		if p.accept("(") {
+			return p.call(tok)
+		}
+		return func(vars map[string]interface{}) (interface{}, error) {
+			return vars[tok.text], nil
+		}, nil
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at column %d", tok, tok.pos+1)
+}
+
+// call parses the arguments of a function call, after its "("
+func (p *parser) call(name token) (evalFunc, error) {
+	fn, ok := p.funcs[name.text]
+	if !ok {
+		fn, ok = builtins[name.text]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at column %d", name.text, name.pos+1)
+	}
+	var args []evalFunc
+	if !p.accept(")") {
+		for {
+			arg, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(")") {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return func(vars map[string]interface{}) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(vars)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		v, err := fn(values...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name.text, err)
+		}
+		return v, nil
+	}, nil
+}
+
+func constant(v interface{}) evalFunc {
+	return func(map[string]interface{}) (interface{}, error) { return v, nil }
+}
+
+// truthy reports whether a value counts as true: anything but false,
+// null, zero and empty text, lists and objects
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
+
+// equal compares two values; numbers and numeric text compare as numbers
+func equal(a, b interface{}) bool {
+	if x, ok := a.(float64); ok {
+		y, err := number(b)
+		return err == nil && x == y
+	}
+	if y, ok := b.(float64); ok {
+		x, err := number(a)
+		return err == nil && x == y
+	}
+	switch a.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	switch b.(type) {
+	case []interface{}, map[string]interface{}:
+		return false
+	}
+	return a == b
+}
+
+// number converts a value to a number; numeric text counts, as the POS
+// sends some amounts as strings
+func number(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case float64:
+		return v, nil
+	case nil:
+		return 0, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("%T is not a number", v)
+}
@@ -0,0 +1,87 @@
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Field is one scripted field: a name and the expression computing it
+type Field struct {
+	Name  string `json:"name"`
+	Expr  string `json:"expr"`
+	Label string `json:"label,omitempty"` // Printed as "label: value" under the totals; unlabelled fields are only for templates and later fields
+
+	program *Program
+}
+
+// Config is the scripted fields computed for every receipt, in order;
+// each field can read the ones before it
+type Config struct {
+	Fields []Field `json:"fields"`
+}
+
+// Value is a computed field
+type Value struct {
+	Name  string
+	Label string
+	Value interface{} // A JSON value: float64, string, bool, nil, a list or an object
+}
+
+var fieldName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Load reads a JSON file of scripted fields and compiles them, with funcs
+// callable beside the builtins
+func Load(path string, funcs Funcs) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read scripted fields: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse scripted fields %s: %v", path, err)
+	}
+	if err := cfg.Compile(funcs); err != nil {
+		return Config{}, fmt.Errorf("invalid scripted fields %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Compile checks the field names and compiles their expressions
+func (c *Config) Compile(funcs Funcs) error {
+	for i := range c.Fields {
+		f := &c.Fields[i]
+		if !fieldName.MatchString(f.Name) {
+			return fmt.Errorf("field %d: name %q must be letters, digits and _", i+1, f.Name)
+		}
+		program, err := Compile(f.Expr, funcs)
+		if err != nil {
+			return fmt.Errorf("field %s: %v", f.Name, err)
+		}
+		f.program = program
+	}
+	return nil
+}
+
+// Apply computes the fields from vars, a receipt's JSON fields, adding
+// each one to vars for the fields after it. A field whose expression
+// fails is left out and its error returned beside the others' values.
+func (c Config) Apply(vars map[string]interface{}) ([]Value, []error) {
+	var values []Value
+	var errs []error
+	for _, f := range c.Fields {
+		if f.program == nil {
+			continue
+		}
+		v, err := f.program.Eval(vars)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", f.Name, err))
+			continue
+		}
+		vars[f.Name] = v
+		values = append(values, Value{Name: f.Name, Label: f.Label, Value: v})
+	}
+	return values, errs
+}
@@ -0,0 +1,144 @@
+// Package sequence hands out receipt numbers for jurisdictions that
+// require receipts to be numbered in sequence, with no number used twice
+// and none skipped. Each station counts on its own. The counters live in
+// one JSON file that is replaced, and synced to disk, before a number is
+// handed out, so a restart or a power cut never hands the same number out
+// twice. A station's receipts are numbered one at a time: the next one
+// waits until the last one printed or handed its number back, so a
+// receipt that fails to print never leaves a gap behind a later one.
+package sequence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Counters is an open sequence file
+type Counters struct {
+	path string
+	mu   sync.Mutex
+	last map[string]int64         // By station; "" is the agent's own
+	busy map[string]chan struct{} // Held by each station's reservation
+}
+
+// Reservation is a number handed out to a receipt that is printing
+type Reservation struct {
+	Number  int64
+	c       *Counters
+	station string
+	held    chan struct{}
+}
+
+// Open opens (creating if needed) the counters at path
+func Open(path string) (*Counters, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sequence directory: %v", err)
+	}
+	c := &Counters{path: path, last: make(map[string]int64), busy: make(map[string]chan struct{})}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipt numbers: %v", err)
+	}
+	// A file that can't be read must not quietly restart the numbering
+	if err := json.Unmarshal(data, &c.last); err != nil {
+		return nil, fmt.Errorf("receipt numbers in %s are corrupt: %v", path, err)
+	}
+	return c, nil
+}
+
+// Reserve returns the station's next number, saved before it is
+// returned. The station's other receipts wait in Reserve, or until ctx
+// ends, until Done is called with whether this one printed.
+func (c *Counters) Reserve(ctx context.Context, station string) (*Reservation, error) {
+	c.mu.Lock()
+	held, ok := c.busy[station]
+	if !ok {
+		held = make(chan struct{}, 1)
+		c.busy[station] = held
+	}
+	c.mu.Unlock()
+	select {
+	case held <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.last[station] + 1
+	if err := c.save(station, n); err != nil {
+		<-held
+		return nil, err
+	}
+	return &Reservation{Number: n, c: c, station: station, held: held}, nil
+}
+
+// Done ends a reservation. The number is kept when the receipt printed
+// (or is on its way to the printer); otherwise it is handed back, to be
+// the station's next number. A nil reservation does nothing.
+func (r *Reservation) Done(printed bool) error {
+	if r == nil || r.held == nil {
+		return nil
+	}
+	defer func() { <-r.held; r.held = nil }()
+	if printed {
+		return nil
+	}
+	r.c.mu.Lock()
+	defer r.c.mu.Unlock()
+	return r.c.save(r.station, r.Number-1)
+}
+
+// Last returns each station's last number
+func (c *Counters) Last() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := make(map[string]int64, len(c.last))
+	for station, n := range c.last {
+		last[station] = n
+	}
+	return last
+}
+
+// save writes the counters with the station at n. The file is written
+// under a temporary name, synced and renamed over the old one, so it is
+// never left half written.
+func (c *Counters) save(station string, n int64) error {
+	last := make(map[string]int64, len(c.last)+1)
+	for s, v := range c.last {
+		last[s] = v
+	}
+	last[station] = n
+	data, err := json.Marshal(last)
+	if err != nil {
+		return err
+	}
+
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save receipt numbers: %v", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save receipt numbers: %v", err)
+	}
+	c.last = last
+	return nil
+}
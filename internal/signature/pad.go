@@ -0,0 +1,58 @@
+package signature
+
+// Decoder turns the byte stream of a serial signature pad into strokes.
+// Pads report 5-byte absolute packets: a sync byte with the high bit set
+// (bit 0 is the pen-down flag), then X and Y as 14-bit values sent low
+// 7 bits first. Bytes outside a packet are skipped until the next sync.
+type Decoder struct {
+	packet []byte
+	down   bool
+}
+
+const packetSize = 5
+
+// Feed decodes data into sig, starting a new stroke each time the pen
+// touches down, and returns the number of points added
+func (d *Decoder) Feed(data []byte, sig *Signature) int {
+	added := 0
+	for _, b := range data {
+		if b&0x80 != 0 {
+			d.packet = append(d.packet[:0], b)
+			continue
+		}
+		if len(d.packet) == 0 {
+			continue // Out of sync
+		}
+		d.packet = append(d.packet, b)
+		if len(d.packet) < packetSize {
+			continue
+		}
+
+		down := d.packet[0]&0x01 != 0
+		p := Point{
+			X: int(d.packet[1]) | int(d.packet[2])<<7,
+			Y: int(d.packet[3]) | int(d.packet[4])<<7,
+		}
+		d.packet = d.packet[:0]
+
+		if down {
+			if !d.down {
+				sig.Strokes = append(sig.Strokes, nil)
+			}
+			last := len(sig.Strokes) - 1
+			sig.Strokes[last] = append(sig.Strokes[last], p)
+			added++
+		}
+		d.down = down
+	}
+	return added
+}
+
+// Packet encodes a pad packet, for emulating a pad
+func Packet(p Point, down bool) []byte {
+	sync := byte(0x80)
+	if down {
+		sync |= 0x01
+	}
+	return []byte{sync, byte(p.X & 0x7f), byte(p.X >> 7 & 0x7f), byte(p.Y & 0x7f), byte(p.Y >> 7 & 0x7f)}
+}
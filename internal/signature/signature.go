@@ -0,0 +1,167 @@
+// Package signature collects pen strokes from signature pads and renders
+// them as SVG or PNG for rental agreements and receipts.
+package signature
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// Point is a pen position in pad coordinates
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Signature is the pen strokes of one signature, in the order drawn
+type Signature struct {
+	Strokes [][]Point `json:"strokes"`
+}
+
+// Points returns the total number of points captured
+func (s Signature) Points() int {
+	n := 0
+	for _, stroke := range s.Strokes {
+		n += len(stroke)
+	}
+	return n
+}
+
+// Empty reports whether nothing was drawn
+func (s Signature) Empty() bool {
+	return s.Points() == 0
+}
+
+// Bounds returns the smallest rectangle containing every point
+func (s Signature) Bounds() image.Rectangle {
+	var r image.Rectangle
+	first := true
+	for _, stroke := range s.Strokes {
+		for _, p := range stroke {
+			pr := image.Rect(p.X, p.Y, p.X+1, p.Y+1)
+			if first {
+				r, first = pr, false
+			} else {
+				r = r.Union(pr)
+			}
+		}
+	}
+	return r
+}
+
+// SVG renders the strokes as an SVG document cropped to the signature
+func (s Signature) SVG() []byte {
+	bounds := s.Bounds()
+	margin := max(bounds.Dx(), bounds.Dy())/20 + 2
+	bounds = bounds.Inset(-margin)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="%d %d %d %d" width="%d" height="%d">`,
+		bounds.Min.X, bounds.Min.Y, bounds.Dx(), bounds.Dy(), bounds.Dx(), bounds.Dy())
+	b.WriteString("\n")
+	strokeWidth := max(bounds.Dx()/150, 2)
+	for _, stroke := range s.Strokes {
+		if len(stroke) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `<path d="M%d %d`, stroke[0].X, stroke[0].Y)
+		for _, p := range stroke[1:] {
+			fmt.Fprintf(&b, " L%d %d", p.X, p.Y)
+		}
+		if len(stroke) == 1 {
+			// A dot: draw a zero-length line so the round cap shows
+			fmt.Fprintf(&b, " L%d %d", stroke[0].X, stroke[0].Y)
+		}
+		fmt.Fprintf(&b, `" fill="none" stroke="black" stroke-width="%d" stroke-linecap="round" stroke-linejoin="round"/>`, strokeWidth)
+		b.WriteString("\n")
+	}
+	b.WriteString("</svg>\n")
+	return []byte(b.String())
+}
+
+// Image draws the signature in black on a transparent background, scaled
+// to width pixels and cropped to the signature with a small margin
+func (s Signature) Image(width int) *image.NRGBA {
+	bounds := s.Bounds()
+	margin := max(bounds.Dx(), bounds.Dy())/20 + 2
+	bounds = bounds.Inset(-margin)
+
+	scale := float64(width) / float64(bounds.Dx())
+	height := max(int(float64(bounds.Dy())*scale), 1)
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	pen := max(width/150, 1)
+
+	at := func(p Point) (int, int) {
+		return int(float64(p.X-bounds.Min.X) * scale), int(float64(p.Y-bounds.Min.Y) * scale)
+	}
+	for _, stroke := range s.Strokes {
+		for i := range stroke {
+			x0, y0 := at(stroke[i])
+			x1, y1 := x0, y0
+			if i+1 < len(stroke) {
+				x1, y1 = at(stroke[i+1])
+			}
+			drawLine(img, x0, y0, x1, y1, pen)
+		}
+	}
+	return img
+}
+
+// PNG encodes Image(width)
+func (s Signature) PNG(width int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, s.Image(width)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLine stamps a round pen of the given radius along a Bresenham line
+func drawLine(img *image.NRGBA, x0, y0, x1, y1, radius int) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	err := dx + dy
+	for {
+		stamp(img, x0, y0, radius)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * err
+		if e2 >= dy {
+			err += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			err += dx
+			y0 += sy
+		}
+	}
+}
+
+func stamp(img *image.NRGBA, cx, cy, radius int) {
+	ink := color.NRGBA{A: 255}
+	for y := cy - radius; y <= cy+radius; y++ {
+		for x := cx - radius; x <= cx+radius; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= radius*radius {
+				img.SetNRGBA(x, y, ink) // Out of bounds points are ignored
+			}
+		}
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
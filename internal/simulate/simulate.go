@@ -0,0 +1,208 @@
+// Package simulate stands in for the license scanner so the agent can
+// run on machines without hardware. The simulated scanner answers every
+// scan command with a canned swipe from a fictitious card.
+package simulate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+
+	"GoScanRentalTide/internal/transport"
+)
+
+// PortName is the only port the simulated scanner reports
+const PortName = "SIMULATED"
+
+// Cycle rotates through every fixture except "none"
+const Cycle = "cycle"
+
+// fixtures are swipes from fictitious cards, by name
+var fixtures = map[string]string{
+	// British Columbia magstripe licence
+	"bc": "%BCVANCOUVER^DOE,$JANE MARIE^123 MAIN ST$VANCOUVER BC  V6B 1A1^?" +
+		";6360281234567=271229900115=?" +
+		"_%0AV6B1A1  M180",
+	// California PDF417 licence
+	"ca": aamva("636014", []string{
+		"DCSSMITH", "DACJOHN", "DADQUINCY", "DBB19850704", "DBA20290704", "DBD20210704",
+		"DBC1", "DAU180 cm", "DAG42 ELM ST", "DAISACRAMENTO", "DAJCA", "DAK958140000",
+		"DAQD1234567", "DCAC",
+	}),
+	// Washington PDF417 licence
+	"wa": aamva("636045", []string{
+		"DCSSAMPLE", "DACALEX", "DADJORDAN", "DBB19920311", "DBA20300311", "DBD20220311",
+		"DBC2", "DAU170 cm", "DAG1500 PINE ST", "DAISEATTLE", "DAJWA", "DAK981010000",
+		"DAQWDL0ALEXS123", "DCAD",
+	}),
+	// A test payment card, read as a generic magstripe card
+	"card": "%B4111111111111111^DOE/JOHN Q.MR^2612101123456789?;4111111111111111=26121011234567890?",
+	// The customer never swipes; the scan times out
+	"none": "",
+}
+
+// aamva builds a PDF417 payload with a single DL subfile, with the
+// header offsets a real barcode carries
+func aamva(iin string, elements []string) string {
+	const headerLength = 21 + 10 // Header plus one subfile designator
+	body := "DL" + strings.Join(elements, "\n") + "\n"
+	return fmt.Sprintf("@\n\x1e\rANSI %s080001DL%04d%04d", iin, headerLength, len(body)) + body
+}
+
+// Fixtures lists the fixture names
+func Fixtures() []string {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Scanner is a simulated serial scanner
+type Scanner struct {
+	delay time.Duration
+	// Unprompted scanners swipe once the port opens, like a reader that is
+	// never sent a command
+	Unprompted bool
+
+	mu    sync.Mutex
+	names []string
+	next  int
+}
+
+// NewScanner answers scans with the named fixture, or with each fixture
+// in turn for Cycle, after delay (the time the customer takes to swipe)
+func NewScanner(fixture string, delay time.Duration) (*Scanner, error) {
+	s := &Scanner{delay: delay}
+	switch {
+	case fixture == Cycle:
+		for _, name := range Fixtures() {
+			if name != "none" {
+				s.names = append(s.names, name)
+			}
+		}
+	case fixtures[fixture] != "" || fixture == "none":
+		s.names = []string{fixture}
+	default:
+		return nil, fmt.Errorf("unknown fixture %q (choose from %s or %s)", fixture, strings.Join(Fixtures(), ", "), Cycle)
+	}
+	return s, nil
+}
+
+// Ports has the signature of serial.GetPortsList
+func (s *Scanner) Ports() ([]string, error) {
+	return []string{PortName}, nil
+}
+
+// Open opens the simulated scanner on any port name, making Scanner a
+// transport.Transport
+func (s *Scanner) Open(name string, mode *serial.Mode) (transport.Conn, error) {
+	p := &port{scanner: s, closed: make(chan struct{}), arrived: make(chan struct{}, 1)}
+	if s.Unprompted {
+		p.swipeLater()
+	}
+	return p, nil
+}
+
+// swipe returns the next fixture
+func (s *Scanner) swipe() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := s.names[s.next%len(s.names)]
+	s.next++
+	return fixtures[name]
+}
+
+type port struct {
+	scanner  *Scanner
+	mu       sync.Mutex
+	pending  []byte
+	deadline time.Time
+	closed   chan struct{}
+	arrived  chan struct{} // Signalled when data is queued
+	once     sync.Once
+}
+
+// Write answers scan commands (framed by SOH and EOT) once the simulated
+// customer has swiped; anything else is ignored
+func (p *port) Write(b []byte) (int, error) {
+	if len(b) < 2 || b[0] != 0x01 || b[len(b)-1] != 0x04 {
+		return len(b), nil
+	}
+	p.swipeLater()
+	return len(b), nil
+}
+
+// swipeLater delivers the next fixture after the customer's delay
+func (p *port) swipeLater() {
+	data := p.scanner.swipe()
+	if data == "" {
+		return
+	}
+	go func() {
+		select {
+		case <-time.After(p.scanner.delay):
+		case <-p.closed:
+			return
+		}
+		p.mu.Lock()
+		p.pending = append(p.pending, data...)
+		p.mu.Unlock()
+		select {
+		case p.arrived <- struct{}{}:
+		default:
+		}
+	}()
+}
+
+func (p *port) Read(b []byte) (int, error) {
+	var expired <-chan time.Time
+	for {
+		p.mu.Lock()
+		if len(p.pending) > 0 {
+			n := copy(b, p.pending)
+			p.pending = p.pending[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+		deadline := p.deadline
+		p.mu.Unlock()
+
+		if !deadline.IsZero() && expired == nil {
+			expired = time.After(time.Until(deadline))
+		}
+		select {
+		case <-p.closed:
+			return 0, errors.New("port closed")
+		case <-expired:
+			return 0, transport.ErrTimeout
+		case <-p.arrived:
+		}
+	}
+}
+
+func (p *port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	return nil
+}
+
+func (p *port) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+func (p *port) ResetInputBuffer() error { return nil }
+
+// Fixture returns the swipe of the named fixture
+func Fixture(name string) (string, bool) {
+	data, ok := fixtures[name]
+	return data, ok
+}
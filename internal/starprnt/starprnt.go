@@ -0,0 +1,140 @@
+// Package starprnt is the command set of Star Micronics printers (TSP100,
+// TSP650II, mC-Print and SM-series) in their StarPRNT emulation, which
+// cuts, aligns, sizes text and prints images with commands of its own
+// rather than ESC/POS ones.
+package starprnt
+
+import (
+	"strings"
+
+	"GoScanRentalTide/internal/escpos"
+)
+
+// Commands is the StarPRNT command set
+var Commands escpos.CommandSet = starPRNT{}
+
+// codePages are the n of ESC GS t n for the code pages of the escpos
+// package. Star printers have no PC850, so it prints as PC858, which only
+// differs in the euro sign.
+var codePages = map[string]byte{
+	"cp437":  1,
+	"cp850":  4,
+	"cp852":  5,
+	"cp858":  4,
+	"cp863":  8,
+	"cp866":  10,
+	"cp1252": 32,
+}
+
+type starPRNT struct{}
+
+func (starPRNT) Name() string { return "StarPRNT" }
+
+func (starPRNT) Init(page *escpos.CodePage) string {
+	return "\x1B@" + string([]byte{0x1B, 0x1D, 't', codePages[page.Name]})
+}
+
+func (starPRNT) Align(align escpos.Alignment) string {
+	return string([]byte{0x1B, 0x1D, 'a', byte(align)})
+}
+
+func (starPRNT) Bold(on bool) string {
+	if on {
+		return "\x1BE"
+	}
+	return "\x1BF"
+}
+
+func (starPRNT) Underline(on bool) string {
+	if on {
+		return "\x1B-\x01"
+	}
+	return "\x1B-\x00"
+}
+
+func (starPRNT) Invert(on bool) string {
+	if on {
+		return "\x1B4"
+	}
+	return "\x1B5"
+}
+
+// Size is ESC i, which expands characters up to 6 times, height first
+func (starPRNT) Size(width, height int) string {
+	width, height = min(max(width, 1), 6), min(max(height, 1), 6)
+	return string([]byte{0x1B, 'i', byte(height - 1), byte(width - 1)})
+}
+
+// Cut feeds the lines, then cuts with ESC d, which also feeds the paper
+// to the cutter
+func (starPRNT) Cut(cut escpos.Cut) string {
+	commands := strings.Repeat("\n", max(cut.Feed, 0))
+	switch cut.Mode {
+	case escpos.FullCut:
+		commands += "\x1Bd\x02"
+	case escpos.PartialCut:
+		commands += "\x1Bd\x03"
+	}
+	return commands
+}
+
+// Beep sets the buzzer's on and off times in 20ms steps with
+// ESC GS EM DC1, then sounds it with ESC GS EM DC2
+func (starPRNT) Beep(beep escpos.Beep) string {
+	if beep.Count <= 0 {
+		return ""
+	}
+	steps := byte(min(max(beep.Duration/20, 1), 255))
+	return string([]byte{
+		0x1B, 0x1D, 0x19, 0x11, 1, steps, steps,
+		0x1B, 0x1D, 0x19, 0x12, 1, byte(min(beep.Count, 9)), 0,
+	})
+}
+
+// Raster turns each GS v 0 band into ESC GS S, which carries the same
+// rows of dots
+func (starPRNT) Raster(raster []byte) []byte {
+	var out []byte
+	for len(raster) >= 8 && raster[0] == 0x1D && raster[1] == 'v' && raster[2] == '0' {
+		bytesPerRow := int(raster[4]) | int(raster[5])<<8
+		rows := int(raster[6]) | int(raster[7])<<8
+		end := min(8+bytesPerRow*rows, len(raster))
+		out = append(out, 0x1B, 0x1D, 'S', 1, raster[4], raster[5], raster[6], raster[7], 0)
+		out = append(out, raster[8:end]...)
+		raster = raster[end:]
+	}
+	return out
+}
+
+// QRCode prints data as a QR code (model 2, error correction level M) with
+// the printer's own encoder; moduleSize is clamped to the 1 to 8 dots
+// Star printers allow
+func (starPRNT) QRCode(data string, moduleSize int) []byte {
+	var cmd []byte
+	cmd = append(cmd, 0x1B, 0x1D, 'y', 'S', '0', 2)                                      // Model 2
+	cmd = append(cmd, 0x1B, 0x1D, 'y', 'S', '1', 1)                                      // Error correction M
+	cmd = append(cmd, 0x1B, 0x1D, 'y', 'S', '2', byte(min(max(moduleSize, 1), 8)))       // Module size
+	cmd = append(cmd, 0x1B, 0x1D, 'y', 'D', '1', 0, byte(len(data)), byte(len(data)>>8)) // Store the data
+	cmd = append(cmd, data...)
+	cmd = append(cmd, 0x1B, 0x1D, 'y', 'P') // Print it
+	return cmd
+}
+
+// Code128 prints data as a CODE128 barcode height dots tall, with its text
+// printed below, using ESC b
+func (starPRNT) Code128(data string, height int) ([]byte, error) {
+	if err := escpos.CheckCode128(data); err != nil {
+		return nil, err
+	}
+	cmd := []byte{0x1B, 'b', '6', '2', '1', byte(min(max(height, 1), 255))}
+	cmd = append(cmd, data...)
+	return append(cmd, 0x1E), nil
+}
+
+// DrawerKick drives the first drawer with BEL and the second with SUB
+func (starPRNT) DrawerKick(pin int) []byte {
+	if pin == 5 {
+		return []byte{0x1A}
+	}
+	return []byte{0x07}
+}
@@ -0,0 +1,267 @@
+// Package tax computes the tax lines printed on receipts from a
+// configurable set of named rates.
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"GoScanRentalTide/internal/money"
+)
+
+// Rate is a single named tax rate
+type Rate struct {
+	Code    string  `json:"code"`
+	Name    string  `json:"name"`
+	Percent float64 `json:"percent"`
+	// Compound rates are charged on the base plus every non-compound
+	// rate that applies to the same amount (e.g. Quebec QST before 2013)
+	Compound bool `json:"compound,omitempty"`
+}
+
+// Config holds the rates in print order and the per-item tax codes
+type Config struct {
+	Rates []Rate `json:"rates"`
+	// Codes maps an item tax code to the rate codes it attracts.
+	// A code mapped to an empty list is tax exempt.
+	Codes map[string][]string `json:"codes,omitempty"`
+	// DefaultCode is used for items that don't carry a tax code
+	DefaultCode string `json:"defaultCode,omitempty"`
+}
+
+// Line is one entry of a receipt's tax breakdown
+type Line struct {
+	Code   string      `json:"code,omitempty"`
+	Name   string      `json:"name"`
+	Rate   float64     `json:"rate,omitempty"`
+	Amount money.Cents `json:"amount"`
+}
+
+// Base is an amount subject to the rates of one tax code. Exempt
+// amounts attract no tax whatever their code.
+type Base struct {
+	Code   string
+	Amount money.Cents
+	Exempt bool
+}
+
+// Subtotal is the part of a receipt taxed under one tax code, with the
+// tax it attracts, for printing beside the tax lines
+type Subtotal struct {
+	Code   string      `json:"code,omitempty"`
+	Rates  []string    `json:"rates,omitempty"` // Names of the rates charged
+	Exempt bool        `json:"exempt,omitempty"`
+	Amount money.Cents `json:"amount"`
+	Tax    money.Cents `json:"tax"`
+}
+
+// Label names the rates of a subtotal, e.g. "GST+PST", prefixed by its
+// code when the item carried one: "FOOD (GST)". Exempt subtotals have no
+// label; receipts print their own translated marker.
+func (s Subtotal) Label() string {
+	if s.Exempt {
+		return ""
+	}
+	rates := strings.Join(s.Rates, "+")
+	if s.Code == "" {
+		return rates
+	}
+	if rates == "" {
+		return s.Code
+	}
+	return fmt.Sprintf("%s (%s)", s.Code, rates)
+}
+
+// Gross is the subtotal with its tax
+func (s Subtotal) Gross() money.Cents {
+	return s.Amount + s.Tax
+}
+
+// Label returns the printed name of the line, e.g. "GST (5%)"
+func (l Line) Label() string {
+	if l.Rate == 0 {
+		return l.Name
+	}
+	return fmt.Sprintf("%s (%s%%)", l.Name, strconv.FormatFloat(l.Rate, 'f', -1, 64))
+}
+
+// DefaultConfig returns the BC rates the receipts were originally
+// hard-coded with: GST 5% and PST 7%
+func DefaultConfig() Config {
+	return Config{
+		Rates: []Rate{
+			{Code: "GST", Name: "GST", Percent: 5},
+			{Code: "PST", Name: "PST", Percent: 7},
+		},
+	}
+}
+
+// Load reads a tax configuration from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read tax config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse tax config %s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid tax config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that every code refers to a configured rate
+func (c Config) Validate() error {
+	known := make(map[string]bool, len(c.Rates))
+	for _, r := range c.Rates {
+		if r.Code == "" {
+			return fmt.Errorf("rate %q has no code", r.Name)
+		}
+		if known[r.Code] {
+			return fmt.Errorf("duplicate rate code %q", r.Code)
+		}
+		known[r.Code] = true
+	}
+	for code, rates := range c.Codes {
+		for _, rc := range rates {
+			if !known[rc] {
+				return fmt.Errorf("tax code %q refers to unknown rate %q", code, rc)
+			}
+		}
+	}
+	if c.DefaultCode != "" && c.Codes != nil {
+		if _, ok := c.Codes[c.DefaultCode]; !ok {
+			return fmt.Errorf("default tax code %q is not defined", c.DefaultCode)
+		}
+	}
+	return nil
+}
+
+// ratesFor returns the rates charged on items with the given tax code.
+// Unknown and empty codes fall back to the default code, and when no
+// codes are configured every rate applies.
+func (c Config) ratesFor(code string) []Rate {
+	if code == "" {
+		code = c.DefaultCode
+	}
+	codes, ok := c.Codes[code]
+	if !ok {
+		codes, ok = c.Codes[c.DefaultCode]
+	}
+	if !ok {
+		return c.Rates
+	}
+
+	var rates []Rate
+	for _, r := range c.Rates {
+		for _, rc := range codes {
+			if r.Code == rc {
+				rates = append(rates, r)
+				break
+			}
+		}
+	}
+	return rates
+}
+
+// Breakdown computes one line per configured rate for a receipt whose
+// taxable amounts are split by tax code. Rates that don't apply to any
+// base are left out. Amounts are summed exactly and each line is rounded
+// to the cent once, at the end.
+func (c Config) Breakdown(bases []Base) []Line {
+	amounts := make(map[string]*big.Rat)
+	applied := make(map[string]bool)
+	add := func(code string, r *big.Rat) {
+		if amounts[code] == nil {
+			amounts[code] = new(big.Rat)
+		}
+		amounts[code].Add(amounts[code], r)
+	}
+
+	for _, b := range bases {
+		if b.Exempt {
+			continue
+		}
+		simple := new(big.Rat)
+		for _, r := range c.ratesFor(b.Code) {
+			applied[r.Code] = true
+			if r.Compound {
+				continue
+			}
+			t := percentOf(b.Amount.Rat(), r.Percent)
+			add(r.Code, t)
+			simple.Add(simple, t)
+		}
+		for _, r := range c.ratesFor(b.Code) {
+			if r.Compound {
+				add(r.Code, percentOf(new(big.Rat).Add(b.Amount.Rat(), simple), r.Percent))
+			}
+		}
+	}
+
+	var lines []Line
+	for _, r := range c.Rates {
+		if !applied[r.Code] {
+			continue
+		}
+		name := r.Name
+		if name == "" {
+			name = r.Code
+		}
+		var amount money.Cents
+		if amounts[r.Code] != nil {
+			amount, _ = money.RoundRat(amounts[r.Code]) // Capped at money.MaxAmount
+		}
+		lines = append(lines, Line{
+			Code:   r.Code,
+			Name:   name,
+			Rate:   r.Percent,
+			Amount: amount,
+		})
+	}
+	return lines
+}
+
+// Subtotals reports each base with the names of the rates it attracts
+// and its tax, rounded on its own (so the subtotals' tax can differ from
+// the breakdown by a cent)
+func (c Config) Subtotals(bases []Base) []Subtotal {
+	subtotals := make([]Subtotal, 0, len(bases))
+	for _, b := range bases {
+		st := Subtotal{Code: b.Code, Exempt: b.Exempt, Amount: b.Amount}
+		if !b.Exempt {
+			for _, r := range c.ratesFor(b.Code) {
+				name := r.Name
+				if name == "" {
+					name = r.Code
+				}
+				st.Rates = append(st.Rates, name)
+			}
+			for _, l := range c.Breakdown([]Base{b}) {
+				st.Tax += l.Amount
+			}
+			// A code mapped to no rates is an exempt code
+			st.Exempt = len(st.Rates) == 0
+		}
+		subtotals = append(subtotals, st)
+	}
+	return subtotals
+}
+
+// percentOf returns amount * percent / 100, taking the percentage from its
+// decimal form so rates like 9.975 aren't skewed by float representation
+func percentOf(amount *big.Rat, percent float64) *big.Rat {
+	p, ok := new(big.Rat).SetString(strconv.FormatFloat(percent, 'f', -1, 64))
+	if !ok {
+		return new(big.Rat)
+	}
+	p.Quo(p, big.NewRat(100, 1))
+	return p.Mul(p, amount)
+}
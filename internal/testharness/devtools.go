@@ -0,0 +1,213 @@
+package testharness
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// DevTools emulates a headless browser's DevTools endpoint, enough for
+// the warm renderer: it opens tabs, "loads" file:// URLs and prints them
+// to PDFs holding the same marker and source HTML as PDFPrinter's.
+type DevTools struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+	pages    map[string]string // Session -> URL navigated to
+	targets  int
+	renders  int
+}
+
+// NewDevTools listens on a free local port until the test finishes
+func NewDevTools(t testing.TB) *DevTools {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting DevTools emulator: %v", err)
+	}
+	d := &DevTools{listener: listener, pages: make(map[string]string)}
+	go http.Serve(listener, http.HandlerFunc(d.upgrade))
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// URL returns the ws:// address a browser prints on start
+func (d *DevTools) URL() string {
+	return "ws://" + d.listener.Addr().String() + "/devtools/browser/testharness"
+}
+
+// Renders returns how many PDFs were printed so far
+func (d *DevTools) Renders() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.renders
+}
+
+// Close drops every connection and stops listening, as if the browser
+// crashed
+func (d *DevTools) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, conn := range d.conns {
+		conn.Close()
+	}
+	d.conns = nil
+	return d.listener.Close()
+}
+
+// upgrade accepts a WebSocket connection and serves it
+func (d *DevTools) upgrade(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "not a WebSocket request", http.StatusBadRequest)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(sum[:]))
+	rw.Flush()
+
+	d.mu.Lock()
+	d.conns = append(d.conns, conn)
+	d.mu.Unlock()
+	d.serve(conn, rw.Reader)
+}
+
+// devToolsMessage is a command from the client, or a reply or event to it
+type devToolsMessage struct {
+	ID        int64                  `json:"id,omitempty"`
+	SessionID string                 `json:"sessionId,omitempty"`
+	Method    string                 `json:"method,omitempty"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	Result    interface{}            `json:"result,omitempty"`
+	Error     interface{}            `json:"error,omitempty"`
+}
+
+func (d *DevTools) serve(conn net.Conn, r *bufio.Reader) {
+	defer conn.Close()
+	send := func(m devToolsMessage) {
+		data, _ := json.Marshal(m)
+		writeWebSocketText(conn, data)
+	}
+	for {
+		data, err := readWebSocketMessage(r)
+		if err != nil {
+			return
+		}
+		var m devToolsMessage
+		if json.Unmarshal(data, &m) != nil {
+			continue
+		}
+
+		reply := devToolsMessage{ID: m.ID, SessionID: m.SessionID, Result: map[string]interface{}{}}
+		switch m.Method {
+		case "Target.createTarget":
+			d.mu.Lock()
+			d.targets++
+			reply.Result = map[string]interface{}{"targetId": fmt.Sprintf("target-%d", d.targets)}
+			d.mu.Unlock()
+		case "Target.attachToTarget":
+			reply.Result = map[string]interface{}{"sessionId": fmt.Sprintf("session-%v", m.Params["targetId"])}
+		case "Page.navigate":
+			address, _ := m.Params["url"].(string)
+			d.mu.Lock()
+			d.pages[m.SessionID] = address
+			d.mu.Unlock()
+			send(devToolsMessage{ID: m.ID, SessionID: m.SessionID, Result: map[string]interface{}{"frameId": "frame"}})
+			send(devToolsMessage{SessionID: m.SessionID, Method: "Page.loadEventFired", Params: map[string]interface{}{"timestamp": 1.0}})
+			continue
+		case "Page.printToPDF":
+			d.mu.Lock()
+			address := d.pages[m.SessionID]
+			d.mu.Unlock()
+			u, err := url.Parse(address)
+			var html []byte
+			if err == nil {
+				html, err = os.ReadFile(u.Path)
+			}
+			if err != nil {
+				reply.Result, reply.Error = nil, map[string]interface{}{"code": -32000, "message": fmt.Sprintf("failed to load %s", address)}
+				break
+			}
+			d.mu.Lock()
+			d.renders++
+			d.mu.Unlock()
+			reply.Result = map[string]interface{}{"data": base64.StdEncoding.EncodeToString(append([]byte(pdfMarker), html...))}
+		case "Browser.close":
+			send(reply)
+			return
+		}
+		send(reply)
+	}
+}
+
+// readWebSocketMessage reads one masked client message, without the
+// fragments and control frames the agent never sends
+func readWebSocketMessage(r *bufio.Reader) ([]byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	if head[0]&0x0F == 0x8 {
+		return nil, io.EOF
+	}
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(r, mask[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return payload, nil
+}
+
+// writeWebSocketText sends one unmasked text message, as servers do
+func writeWebSocketText(w io.Writer, data []byte) error {
+	header := []byte{0x81}
+	switch n := len(data); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_, err := w.Write(append(header, data...))
+	return err
+}
@@ -0,0 +1,106 @@
+// Package testharness runs the agent's HTTP servers in-process against
+// emulated hardware (serial scanner, network receipt printer, PDF print
+// pipeline) for end-to-end tests.
+package testharness
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Server is an in-process HTTP server under test
+type Server struct {
+	t      testing.TB
+	server *httptest.Server
+}
+
+// Response is a completed request with its body read
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Start serves handler on a local port until the test finishes
+func Start(t testing.TB, handler http.Handler) *Server {
+	t.Helper()
+	s := &Server{t: t, server: httptest.NewServer(handler)}
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// URL returns the server's base URL
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// Get issues a GET request for path
+func (s *Server) Get(path string) Response {
+	s.t.Helper()
+	return s.Do(http.MethodGet, path, nil)
+}
+
+// PostJSON issues a POST request with body encoded as JSON. A string or
+// []byte body is sent verbatim, so tests can post malformed payloads.
+func (s *Server) PostJSON(path string, body interface{}) Response {
+	s.t.Helper()
+	var data []byte
+	switch b := body.(type) {
+	case string:
+		data = []byte(b)
+	case []byte:
+		data = b
+	default:
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("encoding request body: %v", err)
+		}
+	}
+	return s.Do(http.MethodPost, path, data)
+}
+
+// Do issues a request and reads the whole response
+func (s *Server) Do(method, path string, body []byte) Response {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.server.URL+path, bytes.NewReader(body))
+	if err != nil {
+		s.t.Fatalf("building %s %s: %v", method, path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return s.Send(req)
+}
+
+// Send issues a prepared request, e.g. one with extra headers, and reads
+// the whole response
+func (s *Server) Send(req *http.Request) Response {
+	s.t.Helper()
+	resp, err := s.server.Client().Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("reading %s %s response: %v", req.Method, req.URL.Path, err)
+	}
+	return Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: data}
+}
+
+// JSON decodes the response body into a generic map, failing the test
+// if it isn't a JSON object
+func (r Response) JSON(t testing.TB) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := json.Unmarshal(r.Body, &out); err != nil {
+		t.Fatalf("response is not a JSON object: %v\n%s", err, r.Body)
+	}
+	return out
+}
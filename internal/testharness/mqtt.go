@@ -0,0 +1,162 @@
+package testharness
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MQTTMessage is a publication received by the broker emulator
+type MQTTMessage struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// MQTTBroker is an MQTT 3.1.1 broker that accepts every connection and
+// records what is published. It acknowledges QoS 1 publications and
+// answers pings; it does not route messages to subscribers.
+type MQTTBroker struct {
+	listener net.Listener
+	mu       sync.Mutex
+	clients  []string
+	messages []MQTTMessage
+	notify   chan struct{}
+}
+
+// NewMQTTBroker listens on a free local port until the test finishes
+func NewMQTTBroker(t testing.TB) *MQTTBroker {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting MQTT broker: %v", err)
+	}
+
+	b := &MQTTBroker{listener: listener, notify: make(chan struct{}, 1)}
+	go b.serve()
+	t.Cleanup(func() { listener.Close() })
+	return b
+}
+
+// Addr returns the host:port the broker listens on
+func (b *MQTTBroker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// Clients returns the client IDs that connected, in order
+func (b *MQTTBroker) Clients() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.clients...)
+}
+
+// Messages returns everything published so far
+func (b *MQTTBroker) Messages() []MQTTMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]MQTTMessage(nil), b.messages...)
+}
+
+// WaitForMessage blocks until a message is published to topic or the
+// timeout passes
+func (b *MQTTBroker) WaitForMessage(t testing.TB, topic string, timeout time.Duration) MQTTMessage {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		for _, m := range b.Messages() {
+			if m.Topic == topic {
+				return m
+			}
+		}
+		select {
+		case <-b.notify:
+		case <-deadline:
+			t.Fatalf("no MQTT message on %s", topic)
+		}
+	}
+}
+
+func (b *MQTTBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *MQTTBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT: protocol name, level, flags, keep-alive, client ID
+			if len(body) >= 12 {
+				n := int(binary.BigEndian.Uint16(body[10:]))
+				if len(body) >= 12+n {
+					b.mu.Lock()
+					b.clients = append(b.clients, string(body[12:12+n]))
+					b.mu.Unlock()
+				}
+			}
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			if len(body) < 2 {
+				return
+			}
+			n := int(binary.BigEndian.Uint16(body))
+			if len(body) < 2+n {
+				return
+			}
+			rest := body[2+n:]
+			msg := MQTTMessage{Topic: string(body[2 : 2+n]), Retain: header&0x01 != 0}
+			if qos := header >> 1 & 0x03; qos > 0 {
+				conn.Write([]byte{0x40, 0x02, rest[0], rest[1]})
+				rest = rest[2:]
+			}
+			msg.Payload = append([]byte(nil), rest...)
+			b.mu.Lock()
+			b.messages = append(b.messages, msg)
+			b.mu.Unlock()
+			select {
+			case b.notify <- struct{}{}:
+			default:
+			}
+		case 12: // PINGREQ
+			conn.Write([]byte{0xD0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, shift := 0, 0
+	for {
+		digit, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(digit&0x7F) << shift
+		if digit&0x80 == 0 {
+			break
+		}
+		shift += 7
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
@@ -0,0 +1,280 @@
+package testharness
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// PrinterEmulator is a raw TCP (port 9100 style) receipt printer that
+// captures the ESC/POS bytes of each connection as one job. It answers
+// DLE EOT status requests, as a healthy printer unless SetStatus says
+// otherwise.
+type PrinterEmulator struct {
+	listener net.Listener
+	mu       sync.Mutex
+	jobs     [][]byte
+	notify   chan struct{}
+	status   map[byte]byte
+}
+
+// healthyStatus are the DLE EOT n replies of a printer with nothing wrong
+var healthyStatus = map[byte]byte{1: 0x16, 2: 0x12, 3: 0x12, 4: 0x12}
+
+// NewPrinterEmulator listens on a free local port until the test finishes
+func NewPrinterEmulator(t testing.TB) *PrinterEmulator {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("starting printer emulator: %v", err)
+	}
+
+	p := &PrinterEmulator{listener: listener, notify: make(chan struct{}, 64)}
+	go p.serve(listener)
+	t.Cleanup(func() { listener.Close() })
+	return p
+}
+
+func (p *PrinterEmulator) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			data := p.read(conn)
+			if len(data) == 0 {
+				return // Connectivity probe or status request
+			}
+			p.mu.Lock()
+			p.jobs = append(p.jobs, data)
+			p.mu.Unlock()
+			p.notify <- struct{}{}
+		}(conn)
+	}
+}
+
+// read returns what a connection sends until it closes, answering and
+// dropping the DLE EOT status requests it starts with. Requests are only
+// looked for there, so image data in a job is never mistaken for one.
+func (p *PrinterEmulator) read(conn net.Conn) []byte {
+	var data []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		data = append(data, buf[:n]...)
+		for len(data) >= 3 && bytes.HasPrefix(data, []byte{0x10, 0x04}) {
+			p.mu.Lock()
+			reply, ok := p.status[data[2]]
+			if p.status == nil {
+				reply, ok = healthyStatus[data[2]]
+			}
+			p.mu.Unlock()
+			if ok {
+				conn.Write([]byte{reply})
+			}
+			data = data[3:]
+		}
+		if err != nil {
+			return data
+		}
+	}
+}
+
+// SetStatus changes the replies to DLE EOT n status requests, by n;
+// requests for an n without one go unanswered
+func (p *PrinterEmulator) SetStatus(status map[byte]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.status = status
+}
+
+// Host returns the address the emulator listens on
+func (p *PrinterEmulator) Host() string {
+	return p.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the TCP port the emulator listens on
+func (p *PrinterEmulator) Port() int {
+	return p.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops accepting connections, as if the printer went offline
+func (p *PrinterEmulator) Close() error {
+	return p.listener.Close()
+}
+
+// Reopen listens on the same port again, as if the printer came back
+// online
+func (p *PrinterEmulator) Reopen(t testing.TB) {
+	t.Helper()
+	listener, err := net.Listen("tcp", p.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("reopening printer emulator: %v", err)
+	}
+	p.listener = listener
+	go p.serve(listener)
+	t.Cleanup(func() { listener.Close() })
+}
+
+// Jobs returns the raw bytes received so far, one entry per connection
+func (p *PrinterEmulator) Jobs() [][]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.jobs...)
+}
+
+// WaitForJobs blocks until at least n jobs arrived or the timeout passes
+func (p *PrinterEmulator) WaitForJobs(t testing.TB, n int, timeout time.Duration) [][]byte {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		if jobs := p.Jobs(); len(jobs) >= n {
+			return jobs
+		}
+		select {
+		case <-p.notify:
+		case <-deadline:
+			t.Fatalf("printer emulator received %d jobs, want %d", len(p.Jobs()), n)
+		}
+	}
+}
+
+// PDFJob is a document sent through the emulated PDF print pipeline
+type PDFJob struct {
+	Printer string
+	Path    string
+	HTML    string // Source HTML the PDF was "rendered" from
+	Raw     []byte // Bytes sent with lp -o raw, e.g. ESC/POS, instead of a PDF
+}
+
+// PDFPrinter emulates the external programs of the HTML -> PDF -> printer
+// pipeline: headless browsers writing --print-to-pdf output and the lp
+// spooler. Its Run method has the signature of the agent's runCommand.
+type PDFPrinter struct {
+	mu       sync.Mutex
+	commands [][]string
+	jobs     []PDFJob
+	failing  map[string]bool
+	hanging  map[string]bool
+}
+
+// NewPDFPrinter returns an emulator where every command succeeds
+func NewPDFPrinter() *PDFPrinter {
+	return &PDFPrinter{failing: make(map[string]bool), hanging: make(map[string]bool)}
+}
+
+// Fail makes the named program (e.g. "chrome") fail from now on
+func (p *PDFPrinter) Fail(program string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing[program] = true
+}
+
+// Hang makes the named program run until it is killed, like a browser
+// stuck on a page
+func (p *PDFPrinter) Hang(program string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hanging[program] = true
+}
+
+// pdfMarker prefixes emulated PDFs, followed by the source HTML
+const pdfMarker = "%PDF-1.4 testharness\n"
+
+// Run executes an emulated command. Like exec.CommandContext, a command
+// is killed when ctx is cancelled.
+func (p *PDFPrinter) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.commands = append(p.commands, append([]string{name}, args...))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if p.hanging[name] {
+		p.mu.Unlock()
+		<-ctx.Done()
+		p.mu.Lock()
+		return nil, errors.New("signal: killed")
+	}
+
+	if p.failing[name] {
+		return []byte(name + ": not found"), fmt.Errorf("exec: %q: executable file not found", name)
+	}
+
+	var pdfPath string
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--print-to-pdf=") {
+			pdfPath = strings.TrimPrefix(arg, "--print-to-pdf=")
+		}
+	}
+	if pdfPath != "" && len(args) > 0 {
+		html, err := os.ReadFile(args[len(args)-1])
+		if err != nil {
+			return nil, err
+		}
+		return nil, os.WriteFile(pdfPath, append([]byte(pdfMarker), html...), 0644)
+	}
+
+	if name == "lp" {
+		job := PDFJob{}
+		raw := false
+		for i := 0; i < len(args); i++ {
+			if args[i] == "-d" && i+1 < len(args) {
+				job.Printer = args[i+1]
+				i++
+				continue
+			}
+			if args[i] == "-o" && i+1 < len(args) {
+				raw = raw || args[i+1] == "raw"
+				i++
+				continue
+			}
+			job.Path = args[i]
+		}
+		data, err := os.ReadFile(job.Path)
+		if err != nil {
+			return []byte("lp: unable to access file"), err
+		}
+		if raw {
+			job.Raw = data
+			p.jobs = append(p.jobs, job)
+			return []byte("request id is emulated-1"), nil
+		}
+		if !strings.HasPrefix(string(data), pdfMarker) {
+			return nil, errors.New("lp: not a PDF rendered by the emulator")
+		}
+		job.HTML = strings.TrimPrefix(string(data), pdfMarker)
+		p.jobs = append(p.jobs, job)
+		return []byte("request id is emulated-1"), nil
+	}
+
+	if name == "lpstat" {
+		return []byte("Receipt_Printer\nOffice_Printer\n"), nil
+	}
+
+	return nil, nil
+}
+
+// Commands returns every command run so far
+func (p *PDFPrinter) Commands() [][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]string(nil), p.commands...)
+}
+
+// Jobs returns the documents delivered to printers so far
+func (p *PDFPrinter) Jobs() []PDFJob {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PDFJob(nil), p.jobs...)
+}
@@ -0,0 +1,198 @@
+package testharness
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+
+	"go.bug.st/serial"
+
+	"GoScanRentalTide/internal/transport"
+)
+
+// MockSerial emulates a serial-attached license scanner. Every time the
+// port is opened and a command is written, the scanner answers with the
+// configured swipe data, then stays silent until the port is closed.
+type MockSerial struct {
+	mu       sync.Mutex
+	response []byte
+	answers  map[string][]byte
+	opened   []string
+	modes    []serial.Mode
+	written  [][]byte
+	openErr  error
+	ports    []*mockPort
+}
+
+// NewMockSerial returns a scanner that answers every command with response
+func NewMockSerial(response string) *MockSerial {
+	return &MockSerial{response: []byte(response)}
+}
+
+// SetResponse changes the data returned for subsequent scans
+func (m *MockSerial) SetResponse(response string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.response = []byte(response)
+}
+
+// Answer makes the scanner reply to commands containing command with
+// response instead of the swipe data; an empty response is no reply
+func (m *MockSerial) Answer(command, response string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.answers == nil {
+		m.answers = make(map[string][]byte)
+	}
+	m.answers[command] = []byte(response)
+}
+
+// FailOpen makes subsequent opens fail with err (nil restores them)
+func (m *MockSerial) FailOpen(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.openErr = err
+}
+
+// Open opens the scanner on any port name, making MockSerial a
+// transport.Transport
+func (m *MockSerial) Open(name string, mode *serial.Mode) (transport.Conn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.openErr != nil {
+		return nil, m.openErr
+	}
+	m.opened = append(m.opened, name)
+	if mode != nil {
+		m.modes = append(m.modes, *mode)
+	}
+	port := &mockPort{scanner: m, closed: make(chan struct{}), arrived: make(chan struct{}, 1)}
+	m.ports = append(m.ports, port)
+	return port, nil
+}
+
+// Send delivers data on every open port without a command being written
+// first, like a device that reports on its own (e.g. a signature pad)
+func (m *MockSerial) Send(data []byte) {
+	m.mu.Lock()
+	ports := append([]*mockPort(nil), m.ports...)
+	m.mu.Unlock()
+
+	for _, port := range ports {
+		select {
+		case <-port.closed:
+			continue
+		default:
+		}
+		port.queue(data)
+	}
+}
+
+// Ports has the signature of serial.GetPortsList
+func (m *MockSerial) Ports() ([]string, error) {
+	return []string{"COM4", "/dev/ttyUSB0"}, nil
+}
+
+// Opened returns the port names opened so far
+func (m *MockSerial) Opened() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.opened...)
+}
+
+// Modes returns the serial settings used for each open
+func (m *MockSerial) Modes() []serial.Mode {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]serial.Mode(nil), m.modes...)
+}
+
+// Written returns every command written to the scanner
+func (m *MockSerial) Written() [][]byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([][]byte(nil), m.written...)
+}
+
+type mockPort struct {
+	scanner  *MockSerial
+	mu       sync.Mutex
+	pending  []byte
+	deadline time.Time
+	closed   chan struct{}
+	arrived  chan struct{} // Signalled when data is queued
+	once     sync.Once
+}
+
+func (p *mockPort) queue(data []byte) {
+	p.mu.Lock()
+	p.pending = append(p.pending, data...)
+	p.mu.Unlock()
+	select {
+	case p.arrived <- struct{}{}:
+	default:
+	}
+}
+
+func (p *mockPort) Write(b []byte) (int, error) {
+	p.scanner.mu.Lock()
+	p.scanner.written = append(p.scanner.written, append([]byte(nil), b...))
+	response := p.scanner.response
+	for command, answer := range p.scanner.answers {
+		if bytes.Contains(b, []byte(command)) {
+			response = answer
+		}
+	}
+	p.scanner.mu.Unlock()
+
+	p.queue(response)
+	return len(b), nil
+}
+
+func (p *mockPort) Read(b []byte) (int, error) {
+	var expired <-chan time.Time
+	for {
+		p.mu.Lock()
+		if len(p.pending) > 0 {
+			n := copy(b, p.pending)
+			p.pending = p.pending[n:]
+			p.mu.Unlock()
+			return n, nil
+		}
+		deadline := p.deadline
+		p.mu.Unlock()
+
+		// Nothing to send yet: behave like an idle device
+		if !deadline.IsZero() && expired == nil {
+			expired = time.After(time.Until(deadline))
+		}
+		select {
+		case <-p.closed:
+			return 0, errors.New("port closed")
+		case <-expired:
+			return 0, transport.ErrTimeout
+		case <-p.arrived:
+		}
+	}
+}
+
+func (p *mockPort) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.deadline = t
+	return nil
+}
+
+func (p *mockPort) Close() error {
+	p.once.Do(func() { close(p.closed) })
+	return nil
+}
+
+// ResetInputBuffer drops data the scanner sent that hasn't been read
+func (p *mockPort) ResetInputBuffer() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = nil
+	return nil
+}
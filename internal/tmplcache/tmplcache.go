@@ -0,0 +1,189 @@
+// Package tmplcache parses the HTML document templates once and keeps
+// them, so a print doesn't re-parse its template. The t function that
+// looks up strings is bound when a template is parsed, so each template is
+// kept once per language.
+//
+// Templates that can be replaced by a file in a templates directory are
+// checked against that file: saving, adding or removing it drops the
+// parsed copies, and Watch re-parses them in the background so a broken
+// edit shows up in the log rather than on the next print.
+package tmplcache
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Funcs returns the functions templates are parsed with in a language
+type Funcs func(lang string) template.FuncMap
+
+// Cache holds parsed templates by name and language
+type Cache struct {
+	dir     string
+	builtin func(name string) (string, error)
+	funcs   Funcs
+
+	mu      sync.Mutex
+	parsed  map[key]*template.Template
+	stamps  map[string]stamp // Version of each name's file when it was parsed
+	stop    chan struct{}
+	stopped sync.Once
+}
+
+type key struct{ name, lang string }
+
+// stamp identifies one version of a file; the zero stamp is no file
+type stamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// New returns an empty cache of templates read from dir, where a file
+// replaces the built-in template of the same name, or from builtin when
+// dir is "" or has no such file
+func New(dir string, builtin func(name string) (string, error), funcs Funcs) *Cache {
+	return &Cache{
+		dir:     dir,
+		builtin: builtin,
+		funcs:   funcs,
+		parsed:  make(map[key]*template.Template),
+		stamps:  make(map[string]stamp),
+		stop:    make(chan struct{}),
+	}
+}
+
+// Get returns the template for name in lang, parsing it on first use or
+// when its file has changed. Parse errors aren't kept, so a fixed file is
+// picked up by the next call.
+func (c *Cache) Get(name, lang string) (*template.Template, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := key{name, lang}
+	if tmpl, ok := c.parsed[k]; ok {
+		if c.fileStamp(name) == c.stamps[name] {
+			return tmpl, nil
+		}
+		c.drop(name)
+	}
+	return c.parse(k)
+}
+
+// Preload parses each named template in lang, so a broken template stops
+// the program at startup instead of failing its first print
+func (c *Cache) Preload(lang string, names ...string) error {
+	for _, name := range names {
+		if _, err := c.Get(name, lang); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parse reads and parses a template; c.mu must be held. The file is
+// stamped before it's read, so a write during the read is seen as a
+// change next time.
+func (c *Cache) parse(k key) (*template.Template, error) {
+	current := c.fileStamp(k.name)
+	if s, ok := c.stamps[k.name]; !ok || s != current {
+		c.drop(k.name)
+		c.stamps[k.name] = current
+	}
+	text, err := c.read(k.name)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(k.name).Funcs(c.funcs(k.lang)).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	c.parsed[k] = tmpl
+	return tmpl, nil
+}
+
+// fileStamp returns the version of the file that overrides a template
+func (c *Cache) fileStamp(name string) stamp {
+	if c.dir == "" {
+		return stamp{}
+	}
+	info, err := os.Stat(filepath.Join(c.dir, name))
+	if err != nil {
+		return stamp{}
+	}
+	return stamp{info.ModTime(), info.Size()}
+}
+
+// read returns the text of a template
+func (c *Cache) read(name string) (string, error) {
+	if c.dir != "" {
+		data, err := os.ReadFile(filepath.Join(c.dir, name))
+		if err == nil {
+			return string(data), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("error reading template %s: %v", name, err)
+		}
+	}
+	return c.builtin(name)
+}
+
+// drop forgets every parsed copy of a template; c.mu must be held
+func (c *Cache) drop(name string) {
+	for k := range c.parsed {
+		if k.name == name {
+			delete(c.parsed, k)
+		}
+	}
+	delete(c.stamps, name)
+}
+
+// Watch checks the files of the parsed templates every interval until
+// Close, re-parsing changed ones and logging any that no longer parse
+func (c *Cache) Watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+				c.reload()
+			}
+		}
+	}()
+}
+
+// reload re-parses the templates whose files changed, in the languages
+// they were in use in
+func (c *Cache) reload() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changed := make(map[string][]string)
+	for k := range c.parsed {
+		if c.fileStamp(k.name) != c.stamps[k.name] {
+			changed[k.name] = append(changed[k.name], k.lang)
+		}
+	}
+	for name, langs := range changed {
+		c.drop(name)
+		log.Printf("Template %s changed, reloading", name)
+		for _, lang := range langs {
+			if _, err := c.parse(key{name, lang}); err != nil {
+				log.Printf("Warning: template %s no longer parses, documents using it will fail to print: %v", name, err)
+				break
+			}
+		}
+	}
+}
+
+// Close stops Watch
+func (c *Cache) Close() {
+	c.stopped.Do(func() { close(c.stop) })
+}
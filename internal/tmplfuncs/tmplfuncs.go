@@ -0,0 +1,249 @@
+// Package tmplfuncs is the function library of the HTML receipt and
+// agreement templates, shared by the agent and the receipt server.
+//
+// The functions only format the values they are given: none of them reads
+// files, the network or the environment, so a template dropped into a
+// templates directory can lay out its data and nothing more. Comparisons
+// are left to the template builtins (eq, ne, lt, gt, ...), which compare
+// strings as well as numbers; compare amounts in cents with integer
+// literals ({{if gt .Tip 0}}) and floats with float literals.
+package tmplfuncs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/qr"
+)
+
+// MaxOutput caps what one template renders, so a runaway loop in a
+// custom template fails instead of filling memory
+const MaxOutput = 4 << 20
+
+// ErrTooLarge is returned by Render for output over MaxOutput
+var ErrTooLarge = fmt.Errorf("template output is over %d bytes", MaxOutput)
+
+// dateLayouts are the forms dates arrive in from the POS, tried in order
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// New returns the functions, with money and number formatting amounts in
+// format:
+//
+//	money v               amount with the currency symbol; v is money.Cents or a number of units
+//	number v              amount with the locale's separators and no symbol (alias: formatPrice)
+//	multiply a b          product, in cents when either side is money.Cents: multiply .Price .Quantity
+//	date layout v         time.Time or a date string in a Go layout: .Date | date "Jan 2, 2006"
+//	now                   current time as 2006-01-02 15:04:05
+//	truncate n s          s cut to n characters, ending in "…" when cut
+//	padLeft n s           s right-aligned in n characters
+//	padRight n s          s left-aligned in n characters
+//	pluralize n one many  one when n is 1, many otherwise
+//	barcodeURL s          s as a QR code data URL, for an <img> src
+//	title, upper, lower   case changes
+//	contains s sub        whether s holds sub
+//	isString v            whether v is a string
+func New(format money.Format) template.FuncMap {
+	number := func(v any) (string, error) {
+		if c, ok := v.(money.Cents); ok {
+			return format.Number(c.Float64()), nil
+		}
+		f, err := toFloat(v)
+		return format.Number(f), err
+	}
+	return template.FuncMap{
+		"money": func(v any) (string, error) {
+			if c, ok := v.(money.Cents); ok {
+				return format.FormatCents(c), nil
+			}
+			f, err := toFloat(v)
+			return format.Format(f), err
+		},
+		"number":      number,
+		"formatPrice": number,
+		"multiply":    multiply,
+		"date":        date,
+		"now": func() string {
+			return time.Now().Format("2006-01-02 15:04:05")
+		},
+		"truncate":   truncate,
+		"padLeft":    func(n int, s string) string { return pad(n, s, true) },
+		"padRight":   func(n int, s string) string { return pad(n, s, false) },
+		"pluralize":  pluralize,
+		"barcodeURL": barcodeURL,
+		"title":      title,
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"contains":   strings.Contains,
+		"isString": func(v any) bool {
+			_, ok := v.(string)
+			return ok
+		},
+	}
+}
+
+// Render executes tmpl with data, failing once the output passes MaxOutput
+func Render(tmpl *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&limitedWriter{buf: &buf, left: MaxOutput}, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+type limitedWriter struct {
+	buf  *bytes.Buffer
+	left int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.left {
+		return 0, ErrTooLarge
+	}
+	w.left -= len(p)
+	return w.buf.Write(p)
+}
+
+// toFloat reads a number of any numeric type, or a numeric string
+func toFloat(v any) (float64, error) {
+	switch val := v.(type) {
+	case money.Cents:
+		return val.Float64(), nil
+	case json.Number:
+		return val.Float64()
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", val)
+		}
+		return f, nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return 0, fmt.Errorf("%v (%T) is not a number", v, v)
+}
+
+// multiply returns a*b, in cents when either side is an amount so a
+// line total keeps the receipt's rounding
+func multiply(a, b any) (any, error) {
+	if c, ok := a.(money.Cents); ok {
+		a, b = b, c
+	}
+	if c, ok := b.(money.Cents); ok {
+		if _, ok := a.(money.Cents); ok {
+			return nil, errors.New("multiply: can't multiply two amounts")
+		}
+		qty, err := toFloat(a)
+		return c.Times(qty), err
+	}
+	x, err := toFloat(a)
+	if err != nil {
+		return nil, err
+	}
+	y, err := toFloat(b)
+	return x * y, err
+}
+
+// date formats a time, or a date string in one of dateLayouts; strings
+// in another form are shown as they are rather than failing the receipt
+func date(layout string, v any) (string, error) {
+	switch val := v.(type) {
+	case time.Time:
+		if val.IsZero() {
+			return "", nil
+		}
+		return val.Format(layout), nil
+	case *time.Time:
+		if val == nil || val.IsZero() {
+			return "", nil
+		}
+		return val.Format(layout), nil
+	case string:
+		val = strings.TrimSpace(val)
+		for _, l := range dateLayouts {
+			if t, err := time.Parse(l, val); err == nil {
+				return t.Format(layout), nil
+			}
+		}
+		return val, nil
+	}
+	return "", fmt.Errorf("date: %v (%T) is not a date", v, v)
+}
+
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return strings.TrimRightFunc(string(runes[:n-1]), unicode.IsSpace) + "…"
+}
+
+func pad(n int, s string, left bool) string {
+	fill := n - utf8.RuneCountInString(s)
+	if fill <= 0 {
+		return s
+	}
+	if left {
+		return strings.Repeat(" ", fill) + s
+	}
+	return s + strings.Repeat(" ", fill)
+}
+
+func pluralize(n any, one, many string) (string, error) {
+	f, err := toFloat(n)
+	if err != nil {
+		return "", err
+	}
+	if f == 1 || f == -1 {
+		return one, nil
+	}
+	return many, nil
+}
+
+// barcodeURL returns a QR code image; an empty text gives an empty URL
+// so {{with}} can skip the <img>
+func barcodeURL(text string) (template.URL, error) {
+	if text == "" {
+		return "", nil
+	}
+	url, err := qr.DataURL(text)
+	return template.URL(url), err
+}
+
+// title upper-cases the first letter of each word
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) && prev != '\'' {
+			prev = r
+			return unicode.ToTitle(r)
+		}
+		prev = r
+		return r
+	}, s)
+}
@@ -0,0 +1,483 @@
+// Package tmpllint checks a receipt or document template before it goes
+// into the templates directory, so a typo shows up when the template is
+// written rather than on the first print of a busy morning.
+//
+// A template is checked twice. Its fields are followed through the type
+// of data it renders, every branch included: fields the data doesn't
+// have are reported, and so are fields read through a pointer or an
+// interface (a map entry, say) that a request can leave out, outside an
+// {{if}} or {{with}} that tests for it. Then it is rendered with sample
+// data, which catches what only shows up at run time.
+package tmpllint
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"GoScanRentalTide/internal/tmplfuncs"
+)
+
+// Kinds of problem
+const (
+	Syntax          = "syntax"           // The template doesn't parse
+	UnknownFunction = "unknown_function" // A function that isn't defined
+	UnknownTemplate = "unknown_template" // {{template}} of a name that isn't defined
+	MissingField    = "missing_field"    // A field the data doesn't have
+	NilDereference  = "nil_dereference"  // A field read through a value requests can leave out
+	Execute         = "execute"          // Rendering the sample failed
+)
+
+// maxUnknown bounds the parse retries that collect unknown functions
+const maxUnknown = 50
+
+// Problem is one thing wrong with a template
+type Problem struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+var (
+	undefinedFunc = regexp.MustCompile(`function "([^"]+)" not defined`)
+	errorLine     = regexp.MustCompile(`^(?:html/)?template: ?[^:]*:(\d+):(?:(\d+):)? ?(.*)$`)
+)
+
+// Lint parses text as the template name, with funcs, and checks it
+// against sample, the data it renders. It returns the problems found,
+// in the order they appear; none means the template is good to use.
+func Lint(name, text string, funcs template.FuncMap, sample interface{}) []Problem {
+	tmpl, problems := parseAll(name, text, funcs)
+	if tmpl == nil {
+		return problems
+	}
+
+	c := &checker{tmpl: tmpl, funcs: funcs, seen: map[string]bool{}, reported: map[string]bool{}}
+	root := reflect.TypeOf(sample)
+	c.walk(tmpl.Tree, tmpl.Tree.Root, scope{dot: root, vars: map[string]reflect.Type{"$": root}, guards: map[string]bool{}})
+	problems = append(problems, c.problems...)
+
+	// Only a template that parsed cleanly can be rendered
+	if !hasKind(problems, UnknownFunction) {
+		if _, err := tmplfuncs.Render(tmpl, sample); err != nil {
+			p := fromError(err, Execute)
+			switch {
+			case strings.Contains(p.Message, "can't evaluate field"):
+				p.Kind = MissingField
+			case strings.Contains(p.Message, "nil pointer evaluating"):
+				p.Kind = NilDereference
+			case strings.Contains(p.Message, "no such template"):
+				p.Kind = UnknownTemplate
+			}
+			if !c.reported[p.Kind+":"+strconv.Itoa(p.Line)] {
+				problems = append(problems, p)
+			}
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Line < problems[j].Line })
+	return problems
+}
+
+// parseAll parses the template, defining a stand-in for each unknown
+// function in turn, so every one is reported instead of only the first
+func parseAll(name, text string, funcs template.FuncMap) (*template.Template, []Problem) {
+	all := template.FuncMap{}
+	for k, v := range funcs {
+		all[k] = v
+	}
+	var problems []Problem
+	for range maxUnknown {
+		tmpl, err := template.New(name).Funcs(all).Parse(text)
+		if err == nil {
+			if len(problems) > 0 {
+				return tmpl, problems
+			}
+			return tmpl, nil
+		}
+		m := undefinedFunc.FindStringSubmatch(err.Error())
+		if m == nil {
+			return nil, append(problems, fromError(err, Syntax))
+		}
+		p := fromError(err, UnknownFunction)
+		p.Message = fmt.Sprintf("function %q is not defined", m[1])
+		problems = append(problems, p)
+		all[m[1]] = func(...interface{}) interface{} { return nil }
+	}
+	return nil, problems
+}
+
+// fromError turns a template error into a problem, with its line
+func fromError(err error, kind string) Problem {
+	p := Problem{Kind: kind, Message: err.Error()}
+	if m := errorLine.FindStringSubmatch(err.Error()); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+		p.Column, _ = strconv.Atoi(m[2])
+		p.Message = m[3]
+	}
+	return p
+}
+
+func hasKind(problems []Problem, kind string) bool {
+	for _, p := range problems {
+		if p.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// checker follows fields through the data type. A nil type is one that
+// isn't known until the template runs, such as a function's result;
+// nothing read from it is checked.
+type checker struct {
+	tmpl     *template.Template
+	funcs    template.FuncMap
+	seen     map[string]bool // Templates checked, with the type of their dot
+	reported map[string]bool // kind:line of problems found, reported once
+	problems []Problem
+}
+
+// scope is what's known at one point of the template
+type scope struct {
+	dot    reflect.Type
+	vars   map[string]reflect.Type
+	guards map[string]bool // Paths tested non-empty by an enclosing if or with
+}
+
+// with returns a copy of the scope, with extra guards
+func (s scope) with(guards ...string) scope {
+	vars := make(map[string]reflect.Type, len(s.vars))
+	for k, v := range s.vars {
+		vars[k] = v
+	}
+	g := make(map[string]bool, len(s.guards)+len(guards))
+	for k := range s.guards {
+		g[k] = true
+	}
+	for _, path := range guards {
+		if path != "" {
+			g[path] = true
+		}
+	}
+	return scope{dot: s.dot, vars: vars, guards: g}
+}
+
+// enter returns the scope inside a range, with or template call, where
+// dot is a new value; only the guards of variables still hold
+func (s scope) enter(dot reflect.Type, dotGuarded bool) scope {
+	inner := s.with()
+	inner.dot = dot
+	for path := range inner.guards {
+		if !strings.HasPrefix(path, "$") {
+			delete(inner.guards, path)
+		}
+	}
+	if dotGuarded {
+		inner.guards["."] = true
+	}
+	return inner
+}
+
+// guarded reports whether path, or a field under it, was tested
+func (s scope) guarded(path string) bool {
+	if s.guards[path] {
+		return true
+	}
+	for g := range s.guards {
+		if strings.HasPrefix(g, path+".") || path == "." && strings.HasPrefix(g, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *checker) report(tree *parse.Tree, node parse.Node, kind, format string, args ...interface{}) {
+	p := Problem{Kind: kind, Message: fmt.Sprintf(format, args...)}
+	location, _ := tree.ErrorContext(node)
+	if parts := strings.Split(location, ":"); len(parts) >= 3 {
+		p.Line, _ = strconv.Atoi(parts[len(parts)-2])
+		p.Column, _ = strconv.Atoi(parts[len(parts)-1])
+	}
+	key := kind + ":" + strconv.Itoa(p.Line) + ":" + p.Message
+	if c.reported[key] {
+		return
+	}
+	c.reported[key] = true
+	c.reported[kind+":"+strconv.Itoa(p.Line)] = true
+	c.problems = append(c.problems, p)
+}
+
+func (c *checker) walk(tree *parse.Tree, node parse.Node, s scope) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			c.walk(tree, child, s)
+		}
+	case *parse.ActionNode:
+		c.pipe(tree, n.Pipe, s)
+	case *parse.IfNode:
+		c.pipe(tree, n.Pipe, s)
+		c.walk(tree, n.List, s.with(guardsOf(n.Pipe)...))
+		c.walk(tree, n.ElseList, s)
+	case *parse.WithNode:
+		t := c.pipe(tree, n.Pipe, s)
+		c.walk(tree, n.List, s.enter(t, true))
+		c.walk(tree, n.ElseList, s)
+	case *parse.RangeNode:
+		t := c.pipe(tree, n.Pipe, s)
+		key, elem := rangeTypes(t)
+		inner := s.enter(elem, true)
+		switch len(n.Pipe.Decl) {
+		case 1:
+			inner.vars[n.Pipe.Decl[0].Ident[0]] = elem
+		case 2:
+			inner.vars[n.Pipe.Decl[0].Ident[0]] = key
+			inner.vars[n.Pipe.Decl[1].Ident[0]] = elem
+		}
+		c.walk(tree, n.List, inner)
+		c.walk(tree, n.ElseList, s)
+	case *parse.TemplateNode:
+		var dot reflect.Type
+		if n.Pipe != nil {
+			dot = c.pipe(tree, n.Pipe, s)
+		}
+		called := c.tmpl.Lookup(n.Name)
+		if called == nil || called.Tree == nil {
+			c.report(tree, n, UnknownTemplate, "template %q is not defined", n.Name)
+			return
+		}
+		key := n.Name + "\x00" + fmt.Sprint(dot)
+		if c.seen[key] {
+			return
+		}
+		c.seen[key] = true
+		c.walk(called.Tree, called.Tree.Root, scope{dot: dot, vars: map[string]reflect.Type{"$": dot}, guards: map[string]bool{}})
+	}
+}
+
+// pipe checks a pipeline and returns the type of its result. Variables
+// it declares are added to the scope.
+func (c *checker) pipe(tree *parse.Tree, pipe *parse.PipeNode, s scope) reflect.Type {
+	if pipe == nil {
+		return nil
+	}
+	var result reflect.Type
+	for _, cmd := range pipe.Cmds {
+		result = c.command(tree, cmd, s)
+	}
+	if len(pipe.Decl) == 1 && !pipe.IsAssign {
+		s.vars[pipe.Decl[0].Ident[0]] = result
+	}
+	return result
+}
+
+// command checks one command of a pipeline and returns its result type
+func (c *checker) command(tree *parse.Tree, cmd *parse.CommandNode, s scope) reflect.Type {
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+	switch first := cmd.Args[0].(type) {
+	case *parse.IdentifierNode:
+		// A function call: and and or stop at the first argument that
+		// decides them, so each argument guards the ones after it
+		args := s
+		for _, arg := range cmd.Args[1:] {
+			c.arg(tree, arg, args)
+			if first.Ident == "and" {
+				args = args.with(pathOf(arg))
+			}
+		}
+		return c.funcResult(first.Ident)
+	}
+	t := c.arg(tree, cmd.Args[0], s)
+	for _, arg := range cmd.Args[1:] {
+		c.arg(tree, arg, s)
+	}
+	return t
+}
+
+// arg checks one argument and returns its type
+func (c *checker) arg(tree *parse.Tree, node parse.Node, s scope) reflect.Type {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return s.dot
+	case *parse.FieldNode:
+		return c.fields(tree, n, s.dot, ".", n.Ident, s)
+	case *parse.VariableNode:
+		t, ok := s.vars[n.Ident[0]]
+		if !ok {
+			return nil
+		}
+		return c.fields(tree, n, t, n.Ident[0], n.Ident[1:], s)
+	case *parse.ChainNode:
+		t := c.arg(tree, n.Node, s)
+		return c.fields(tree, n, t, "", n.Field, s)
+	case *parse.PipeNode:
+		return c.pipe(tree, n, s.with())
+	case *parse.IdentifierNode:
+		return c.funcResult(n.Ident)
+	}
+	return nil
+}
+
+// fields follows a chain of field names from a value of type t at path,
+// reporting fields t doesn't have and nil values read through
+func (c *checker) fields(tree *parse.Tree, node parse.Node, t reflect.Type, path string, names []string, s scope) reflect.Type {
+	for _, name := range names {
+		if t == nil {
+			return nil
+		}
+		if nillable(t) && path != "" && !s.guarded(path) {
+			c.report(tree, node, NilDereference, "%s fails when %s is left out; test it first with {{if %s}} or {{with %s}}",
+				join(path, name), path, path, path)
+		}
+		next, ok := fieldType(t, name)
+		if !ok {
+			c.report(tree, node, MissingField, "%s has no field %s", typeName(t), name)
+			return nil
+		}
+		t = next
+		if path != "" {
+			path = join(path, name)
+		}
+	}
+	return t
+}
+
+// funcResult returns the type a function returns, nil when it can't be
+// known beforehand
+func (c *checker) funcResult(name string) reflect.Type {
+	fn, ok := c.funcs[name]
+	if !ok {
+		return nil // Builtins such as index, and stand-ins for unknown functions
+	}
+	t := reflect.TypeOf(fn)
+	if t.Kind() != reflect.Func || t.NumOut() == 0 || t.Out(0).Kind() == reflect.Interface {
+		return nil
+	}
+	return t.Out(0)
+}
+
+// fieldType returns the type of a field, method result or map entry of t
+func fieldType(t reflect.Type, name string) (reflect.Type, bool) {
+	if m, ok := t.MethodByName(name); ok {
+		return methodResult(m.Type), true
+	}
+	if t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+		if m, ok := reflect.PointerTo(t).MethodByName(name); ok {
+			return methodResult(m.Type), true
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		f, ok := t.FieldByName(name)
+		if !ok || !f.IsExported() {
+			return nil, false
+		}
+		return f.Type, true
+	case reflect.Map:
+		return t.Elem(), true
+	case reflect.Interface:
+		return nil, true
+	}
+	return nil, false
+}
+
+func methodResult(t reflect.Type) reflect.Type {
+	if t.NumOut() == 0 || t.Out(0).Kind() == reflect.Interface {
+		return nil
+	}
+	return t.Out(0)
+}
+
+// rangeTypes returns the key and element types of ranging over t
+func rangeTypes(t reflect.Type) (key, elem reflect.Type) {
+	if t == nil {
+		return nil, nil
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return reflect.TypeOf(0), t.Elem()
+	case reflect.Map:
+		return t.Key(), t.Elem()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return t, t
+	}
+	return nil, nil
+}
+
+// nillable reports whether a value of type t can be nil when a request
+// leaves it out
+func nillable(t reflect.Type) bool {
+	return t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface
+}
+
+// guardsOf returns the paths an if condition tests: its one argument, or
+// each argument of an and
+func guardsOf(pipe *parse.PipeNode) []string {
+	if pipe == nil || len(pipe.Cmds) != 1 {
+		return nil
+	}
+	args := pipe.Cmds[0].Args
+	if id, ok := args[0].(*parse.IdentifierNode); ok {
+		if id.Ident != "and" {
+			return nil
+		}
+		var paths []string
+		for _, arg := range args[1:] {
+			paths = append(paths, pathOf(arg))
+		}
+		return paths
+	}
+	if len(args) == 1 {
+		return []string{pathOf(args[0])}
+	}
+	return nil
+}
+
+// pathOf returns the path of a field or variable argument, "" for others
+func pathOf(node parse.Node) string {
+	switch n := node.(type) {
+	case *parse.DotNode:
+		return "."
+	case *parse.FieldNode:
+		return "." + strings.Join(n.Ident, ".")
+	case *parse.VariableNode:
+		return strings.Join(n.Ident, ".")
+	}
+	return ""
+}
+
+// join appends a field name to a path
+func join(path, name string) string {
+	if path == "." {
+		return "." + name
+	}
+	return path + "." + name
+}
+
+func typeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return t.String()
+}
@@ -0,0 +1,87 @@
+// Package tracing gives every API call of the agent and the receipt
+// server a request ID: the client's own, when it sends one in
+// X-Request-ID, so one ID follows a print from the till to the printer,
+// or one made up here. The ID is returned in the X-Request-ID header,
+// prefixes the log lines written while the request runs and is kept with
+// what the request printed or scanned.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Header carries the request ID, both ways
+const Header = "X-Request-ID"
+
+// IDs from clients are only taken when they are safe in log lines and
+// file names
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+type idKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or ""
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(idKey{}).(string)
+	return id
+}
+
+// Logf logs a line to logger, prefixed with the request ID in ctx if
+// there is one
+func Logf(logger *log.Logger, ctx context.Context, format string, args ...interface{}) {
+	if id := RequestID(ctx); id != "" {
+		format = "[" + id + "] " + format
+	}
+	logger.Printf(format, args...)
+}
+
+// Middleware gives each request its ID and logs how it ended to logger.
+// Successful GETs aren't logged: clients poll the status endpoints every
+// few seconds.
+func Middleware(next http.Handler, logger *log.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !idPattern.MatchString(id) {
+			var b [8]byte
+			rand.Read(b[:])
+			id = "req-" + hex.EncodeToString(b[:])
+		}
+		w.Header().Set(Header, id)
+
+		ctx := context.WithValue(r.Context(), idKey{}, id)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		if r.Method != http.MethodGet || recorder.status >= 400 {
+			Logf(logger, ctx, "%s %s %d in %v", r.Method, r.URL.Path, recorder.status, time.Since(start).Round(time.Millisecond))
+		}
+	})
+}
+
+// statusRecorder remembers the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps server-sent events, such as the signature stream, working
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// FlowControl is how a device that prints slower than the port runs
+// tells the sender to hold off, so a long job doesn't overrun its buffer
+type FlowControl string
+
+const (
+	NoFlowControl FlowControl = "none"
+	// RTSCTS: the device drops CTS while its buffer is full
+	RTSCTS FlowControl = "rtscts"
+	// XONXOFF: the device sends XOFF when its buffer is full and XON when
+	// it has room again
+	XONXOFF FlowControl = "xonxoff"
+)
+
+const (
+	xon  = 0x11
+	xoff = 0x13
+)
+
+// flowChunk is how much is written between checks that the device can
+// take more; smaller than the input buffer of common receipt printers
+const flowChunk = 256
+
+// ParseFlowControl reads a flow control setting; "" is none
+func ParseFlowControl(s string) (FlowControl, error) {
+	switch flow := FlowControl(strings.ToLower(strings.TrimSpace(s))); flow {
+	case "", NoFlowControl:
+		return NoFlowControl, nil
+	case RTSCTS, XONXOFF:
+		return flow, nil
+	}
+	return "", fmt.Errorf("unknown flow control %q (use none, rtscts or xonxoff)", s)
+}
+
+// ClearToSender is a Conn that reports the CTS line, as serial ports do
+type ClearToSender interface {
+	ClearToSend() (bool, error)
+}
+
+// ErrFlowTimeout is returned by WriteFlow when the device holds off for
+// longer than the timeout
+var ErrFlowTimeout = errors.New("device did not become ready")
+
+// WriteFlow writes data to conn in chunks, waiting before each one until
+// the device is ready for it. A device held off for longer than timeout
+// fails the write with ErrFlowTimeout. A Conn that doesn't report CTS is
+// always clear to send.
+func WriteFlow(conn Conn, data []byte, flow FlowControl, timeout time.Duration) error {
+	if flow == NoFlowControl || flow == "" {
+		_, err := conn.Write(data)
+		return err
+	}
+	ready := func() (bool, error) { return clearToSend(conn) }
+	if flow == XONXOFF {
+		paused := false
+		ready = func() (bool, error) {
+			var err error
+			paused, err = readXONXOFF(conn, paused)
+			return !paused, err
+		}
+	}
+	for len(data) > 0 {
+		if err := waitReady(ready, timeout); err != nil {
+			return err
+		}
+		n := min(flowChunk, len(data))
+		if _, err := conn.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// waitReady polls ready until it reports true or timeout passes
+func waitReady(ready func() (bool, error), timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := ready()
+		if err != nil || ok {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return ErrFlowTimeout
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func clearToSend(conn Conn) (bool, error) {
+	cts, ok := conn.(ClearToSender)
+	if !ok {
+		return true, nil
+	}
+	return cts.ClearToSend()
+}
+
+// readXONXOFF reads what the device has sent without waiting and returns
+// whether it is paused: by its last XOFF or XON, or as it was
+func readXONXOFF(conn Conn, paused bool) (bool, error) {
+	buf := make([]byte, 64)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+			return paused, err
+		}
+		n, err := conn.Read(buf)
+		for _, b := range buf[:n] {
+			switch b {
+			case xoff:
+				paused = true
+			case xon:
+				paused = false
+			}
+		}
+		if errors.Is(err, ErrTimeout) || (err == nil && n == 0) {
+			return paused, nil
+		}
+		if err != nil {
+			return paused, err
+		}
+	}
+}
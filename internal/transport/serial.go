@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Serial opens local serial ports (COM ports, /dev/tty*, Bluetooth SPP
+// ports) with an opener of serial.Open's signature
+type Serial func(name string, mode *serial.Mode) (serial.Port, error)
+
+// Open opens the named port with the given settings
+func (open Serial) Open(name string, mode *serial.Mode) (Conn, error) {
+	port, err := open(name, mode)
+	if err != nil {
+		return nil, err
+	}
+	return &serialConn{port: port}, nil
+}
+
+// serialConn turns a deadline into the port's own read timeout before
+// each read, so a read that times out leaves nothing behind to swallow
+// the next bytes
+type serialConn struct {
+	port     serial.Port
+	deadline time.Time
+}
+
+func (c *serialConn) Read(b []byte) (int, error) {
+	timeout := serial.NoTimeout
+	if !c.deadline.IsZero() {
+		if timeout = time.Until(c.deadline); timeout <= 0 {
+			return 0, ErrTimeout
+		}
+	}
+	if err := c.port.SetReadTimeout(timeout); err != nil {
+		return 0, err
+	}
+	n, err := c.port.Read(b)
+	if err == nil && n == 0 {
+		return 0, ErrTimeout
+	}
+	return n, err
+}
+
+func (c *serialConn) Write(b []byte) (int, error) {
+	return c.port.Write(b)
+}
+
+func (c *serialConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *serialConn) ResetInputBuffer() error {
+	return c.port.ResetInputBuffer()
+}
+
+func (c *serialConn) Close() error {
+	return c.port.Close()
+}
+
+// ClearToSend reports the port's CTS line, for RTS/CTS flow control
+func (c *serialConn) ClearToSend() (bool, error) {
+	status, err := c.port.GetModemStatusBits()
+	if err != nil {
+		return false, err
+	}
+	return status.CTS, nil
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// Scheme marks a port name as a network address: tcp://host:port
+const Scheme = "tcp://"
+
+// IsAddress reports whether a port name is a network address
+func IsAddress(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), Scheme)
+}
+
+// TCP reaches devices over the network: serial device servers (Moxa
+// NPort, Lantronix) in raw TCP mode, and scanners with an Ethernet port.
+// Line settings (baud rate, parity) belong to the device server's own
+// configuration, so the serial settings are ignored.
+type TCP struct {
+	DialTimeout time.Duration
+}
+
+// Open connects to the device at a tcp://host:port address
+func (t TCP) Open(name string, mode *serial.Mode) (Conn, error) {
+	if !IsAddress(name) {
+		return nil, errors.New("not a tcp:// address: " + name)
+	}
+	conn, err := net.DialTimeout("tcp", name[len(Scheme):], t.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &tcpConn{conn: conn}, nil
+}
+
+type tcpConn struct {
+	conn     net.Conn
+	deadline time.Time
+}
+
+func (c *tcpConn) Read(b []byte) (int, error) {
+	if err := c.conn.SetReadDeadline(c.deadline); err != nil {
+		return 0, err
+	}
+	n, err := c.conn.Read(b)
+	if isTimeout(err) {
+		if n > 0 {
+			return n, nil
+		}
+		return 0, ErrTimeout
+	}
+	return n, err
+}
+
+func (c *tcpConn) Write(b []byte) (int, error) {
+	return c.conn.Write(b)
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// ResetInputBuffer reads and drops what has already arrived
+func (c *tcpConn) ResetInputBuffer() error {
+	buf := make([]byte, 512)
+	for {
+		if err := c.conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+			return err
+		}
+		if _, err := c.conn.Read(buf); err != nil {
+			if isTimeout(err) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (c *tcpConn) Close() error {
+	return c.conn.Close()
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
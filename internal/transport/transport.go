@@ -0,0 +1,36 @@
+// Package transport is how the agent reaches its devices: a local serial
+// port, a TCP connection to a serial device server or Ethernet scanner,
+// or a stand-in for tests and -simulate. Reads wait until a deadline, so
+// code that talks to a device is written once for all of them.
+package transport
+
+import (
+	"errors"
+	"time"
+
+	"go.bug.st/serial"
+)
+
+// ErrTimeout is returned by Read when the deadline passes with nothing
+// received
+var ErrTimeout = errors.New("read timeout")
+
+// Conn is an open connection to a device
+type Conn interface {
+	// Read returns what the device has sent, waiting for data until the
+	// read deadline
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	// SetReadDeadline sets when reads give up with ErrTimeout; the zero
+	// time waits for data
+	SetReadDeadline(t time.Time) error
+	// ResetInputBuffer discards what the device sent that hasn't been read
+	ResetInputBuffer() error
+	Close() error
+}
+
+// Transport opens connections to devices by name. The serial settings
+// are ignored by transports whose devices have their own.
+type Transport interface {
+	Open(name string, mode *serial.Mode) (Conn, error)
+}
@@ -0,0 +1,102 @@
+// Package txstore is the agent's local transaction store. It records which
+// verified ID scan each printed receipt or agreement was issued against,
+// so an audit can trace a transaction back to the ID that was presented.
+// Only masked identity details are stored.
+package txstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const linksFile = "scan-links.jsonl"
+
+// Link associates a printed document with the scan that verified the
+// customer
+type Link struct {
+	TransactionID string    `json:"transactionId"`
+	Document      string    `json:"document"` // "receipt" or "agreement"
+	ScanID        string    `json:"scanId"`
+	CustomerName  string    `json:"customerName"`  // Masked, e.g. "JANE D."
+	LicenseNumber string    `json:"licenseNumber"` // Masked, e.g. "***4567"
+	State         string    `json:"state,omitempty"`
+	ScannedAt     time.Time `json:"scannedAt"`
+	PrintedAt     time.Time `json:"printedAt"`
+}
+
+// Store is an append-only journal of links
+type Store struct {
+	path  string
+	mu    sync.Mutex
+	links []Link
+}
+
+// Open opens (creating if needed) the store in dir and loads its journal
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create transaction store directory: %v", err)
+	}
+	s := &Store{path: filepath.Join(dir, linksFile)}
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transaction store: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var link Link
+		if err := json.Unmarshal(scanner.Bytes(), &link); err != nil {
+			continue // A torn last line after a crash is not fatal
+		}
+		s.links = append(s.links, link)
+	}
+	return s, scanner.Err()
+}
+
+// Record appends a link to the journal
+func (s *Store) Record(link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open transaction store: %v", err)
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write transaction store: %v", err)
+	}
+	s.links = append(s.links, link)
+	return nil
+}
+
+// Find lists the links recorded for a transaction, oldest first
+func (s *Store) Find(transactionID string) []Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found []Link
+	for _, link := range s.links {
+		if link.TransactionID == transactionID {
+			found = append(found, link)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].PrintedAt.Before(found[j].PrintedAt) })
+	return found
+}
@@ -0,0 +1,63 @@
+// Package usbprint writes raw printer commands (ESC/POS, ZPL) to printers
+// attached by USB, through the operating system's USB printer class
+// driver rather than a spooler: the usblp devices /dev/usb/lp* on Linux,
+// and the device interfaces of USB printing support on Windows. The
+// driver hands the bytes to the printer's bulk OUT endpoint as they are.
+package usbprint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrUnsupported is returned where the OS has no USB printer class driver
+// to write to
+var ErrUnsupported = errors.New("USB printing is not supported on this system")
+
+// Devices lists the device paths of the USB printers attached
+func Devices() ([]string, error) {
+	return devices()
+}
+
+// Find returns the device path of the printer named by device: its full
+// path, or a part of it such as lp1 or vid_04b8&pid_0202, in any case.
+// "" names the only USB printer attached.
+func Find(device string) (string, error) {
+	paths, err := devices()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, path := range paths {
+		if strings.Contains(strings.ToLower(path), strings.ToLower(device)) {
+			matches = append(matches, path)
+		}
+	}
+	switch {
+	case len(paths) == 0:
+		return "", errors.New("no USB printer is attached")
+	case len(matches) == 0:
+		return "", fmt.Errorf("no USB printer matches %q (attached: %s)", device, strings.Join(paths, ", "))
+	case len(matches) > 1 && device == "":
+		return "", fmt.Errorf("%d USB printers are attached, name one: %s", len(matches), strings.Join(matches, ", "))
+	case len(matches) > 1:
+		return "", fmt.Errorf("%q matches %d USB printers: %s", device, len(matches), strings.Join(matches, ", "))
+	}
+	return matches[0], nil
+}
+
+// Open finds the printer named by device, as Find does, and opens it for
+// writing
+func Open(device string) (io.WriteCloser, error) {
+	path, err := Find(device)
+	if err != nil {
+		return nil, err
+	}
+	w, err := open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open USB printer %s: %v", path, err)
+	}
+	return w, nil
+}
@@ -0,0 +1,18 @@
+package usbprint
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// devicePattern matches the devices of the usblp driver
+const devicePattern = "/dev/usb/lp*"
+
+func devices() ([]string, error) {
+	return filepath.Glob(devicePattern)
+}
+
+func open(path string) (io.WriteCloser, error) {
+	return os.OpenFile(path, os.O_WRONLY, 0)
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package usbprint
+
+import "io"
+
+func devices() ([]string, error) {
+	return nil, ErrUnsupported
+}
+
+func open(path string) (io.WriteCloser, error) {
+	return nil, ErrUnsupported
+}
@@ -0,0 +1,34 @@
+package usbprint
+
+import (
+	"io"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// usbPrintInterface is GUID_DEVINTERFACE_USBPRINT, the device interface
+// class that USB printing support registers each printer under
+var usbPrintInterface = windows.GUID{
+	Data1: 0x28d78fad,
+	Data2: 0x5a12,
+	Data3: 0x11d1,
+	Data4: [8]byte{0xae, 0x5b, 0x00, 0x00, 0xf8, 0x03, 0xa8, 0xc2},
+}
+
+func devices() ([]string, error) {
+	return windows.CM_Get_Device_Interface_List("", &usbPrintInterface, windows.CM_GET_DEVICE_INTERFACE_LIST_PRESENT)
+}
+
+func open(path string) (io.WriteCloser, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := windows.CreateFile(name, windows.GENERIC_WRITE, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil, windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
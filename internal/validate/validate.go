@@ -0,0 +1,303 @@
+// Package validate checks a JSON request against the struct it decodes
+// into, before it is decoded, so a client is told every field that is
+// wrong and where: `items[1].price: invalid amount "abc"` instead of the
+// decoder's first error, which doesn't say which item it was in.
+//
+// Fields are checked by their Go type: strings must be JSON strings,
+// numbers numbers, slices arrays, and types with their own UnmarshalJSON
+// (such as money.Cents) whatever that accepts. Unknown fields and nulls
+// are allowed. A validate tag adds rules, separated by commas:
+//
+//	required       the field must be present, and not null or ""
+//	nonnegative    the number can't be below zero
+//	positive       the number must be above zero
+//	number         an interface{} field must hold a number or numeric string
+//	kind=a|b       an interface{} field must hold one of these JSON kinds:
+//	               string, number, bool, object or array
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Error is a problem with one field
+type Error struct {
+	Field   string `json:"field"` // Path to the field, e.g. "items[1].price"
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Errors is every problem found in a request
+type Errors []Error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// JSON checks data against the type of v, a struct or pointer to one. It
+// returns Errors when fields are invalid, or the syntax error when data
+// isn't JSON.
+func JSON(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var doc interface{}
+	if err := d.Decode(&doc); err != nil {
+		return err
+	}
+	var errs Errors
+	check(&errs, "", doc, reflect.TypeOf(v), nil)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// check validates one JSON value against a Go type and the field's rules
+func check(errs *Errors, path string, value interface{}, t reflect.Type, rules []string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if value == nil {
+		if has(rules, "required") {
+			fail("is required")
+		}
+		return
+	}
+
+	if reflect.PointerTo(t).Implements(unmarshalerType) {
+		raw, _ := json.Marshal(value)
+		target := reflect.New(t)
+		if err := target.Interface().(json.Unmarshaler).UnmarshalJSON(raw); err != nil {
+			fail("%v", err)
+			return
+		}
+		if n, ok := numeric(target.Elem()); ok {
+			checkSign(fail, n, strings.Trim(string(raw), `"`), rules)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			fail("must be a string, not %s", kindOf(value))
+		} else if s == "" && has(rules, "required") {
+			fail("is required")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false, not %s", kindOf(value))
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be a whole number, not %s", kindOf(value))
+			return
+		}
+		i, err := strconv.ParseInt(n.String(), 10, 64)
+		if err != nil {
+			fail("%s is not a whole number", n)
+			return
+		}
+		checkSign(fail, float64(i), n.String(), rules)
+	case reflect.Float32, reflect.Float64:
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be a number, not %s", kindOf(value))
+			return
+		}
+		f, _ := n.Float64()
+		checkSign(fail, f, n.String(), rules)
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be a list, not %s", kindOf(value))
+			return
+		}
+		if len(items) == 0 && has(rules, "required") {
+			fail("is required")
+		}
+		for i, item := range items {
+			check(errs, fmt.Sprintf("%s[%d]", path, i), item, t.Elem(), nil)
+		}
+	case reflect.Map:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object, not %s", kindOf(value))
+			return
+		}
+		for _, key := range sortedKeys(fields) {
+			check(errs, join(path, key), fields[key], t.Elem(), nil)
+		}
+	case reflect.Struct:
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object, not %s", kindOf(value))
+			return
+		}
+		checkStruct(errs, path, fields, t)
+	case reflect.Interface:
+		checkAny(fail, value, rules)
+	}
+}
+
+// checkStruct checks the members of a JSON object against a struct's
+// fields, by their json names
+func checkStruct(errs *Errors, path string, fields map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			checkStruct(errs, path, fields, f.Type) // Promoted fields
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		var rules []string
+		if tag := f.Tag.Get("validate"); tag != "" {
+			rules = strings.Split(tag, ",")
+		}
+		check(errs, join(path, name), fields[name], f.Type, rules)
+	}
+}
+
+// checkAny applies the rules of an interface{} field
+func checkAny(fail func(string, ...interface{}), value interface{}, rules []string) {
+	for _, rule := range rules {
+		if kinds, ok := strings.CutPrefix(rule, "kind="); ok {
+			if !has(strings.Split(kinds, "|"), kindName(value)) {
+				fail("must be %s, not %s", strings.ReplaceAll(kinds, "|", " or "), kindOf(value))
+				return
+			}
+		}
+	}
+	if !has(rules, "number") {
+		return
+	}
+	var text string
+	switch v := value.(type) {
+	case json.Number:
+		text = v.String()
+	case string:
+		text = strings.TrimSpace(v)
+	default:
+		fail("must be a number, not %s", kindOf(value))
+		return
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		fail("%q is not a number", text)
+		return
+	}
+	checkSign(fail, f, text, rules)
+}
+
+// checkSign applies the positive and nonnegative rules to n, shown as text
+func checkSign(fail func(string, ...interface{}), n float64, text string, rules []string) {
+	switch {
+	case has(rules, "positive") && n <= 0:
+		fail("must be more than 0, not %s", text)
+	case has(rules, "nonnegative") && n < 0:
+		fail("can't be negative (%s)", text)
+	}
+}
+
+// numeric returns a decoded value as a number, when it is one
+func numeric(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// kindName is the JSON kind of a decoded value, as used in kind= rules
+func kindName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "bool"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "null"
+}
+
+// kindOf describes a decoded value for messages, e.g. `a string ("abc")`
+func kindOf(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("a string (%q)", v)
+	case json.Number:
+		return "a number (" + v.String() + ")"
+	case bool:
+		return fmt.Sprintf("%v", v)
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "a list"
+	}
+	return "null"
+}
+
+func has(list []string, s string) bool {
+	for _, item := range list {
+		if strings.TrimSpace(item) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
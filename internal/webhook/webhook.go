@@ -0,0 +1,343 @@
+// Package webhook delivers agent events to HTTP endpoints as signed JSON
+// POSTs, retrying failed deliveries in the background so the request that
+// raised the event never waits on the network. Each endpoint has its own
+// queue, so one that is down doesn't hold up deliveries to the others.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types. There is no paper-low event from the agent: it prints
+// through the operating system's spooler, which passes no printer status
+// back. Paper low is reported by the receipt server, which asks its
+// printers directly, as PrinterAlert with the "paper_low" alert.
+const (
+	ScanCompleted  = "scan.completed"
+	PrintFailed    = "print.failed"
+	PrinterOffline = "printer.offline"
+	PrinterOnline  = "printer.online"
+	PrintSpooled   = "print.spooled" // Receipt server: kept to print when the printer is back
+	SpoolFlushed   = "spool.flushed" // Receipt server: spooled receipts printed
+	// Receipt server: a printer reported paper low or out, its cover open
+	// or a cutter error, and the condition went away
+	PrinterAlert        = "printer.alert"
+	PrinterAlertCleared = "printer.alert_cleared"
+)
+
+// Endpoint is one webhook receiver
+type Endpoint struct {
+	URL string `json:"url"`
+	// Secret signs each delivery; the receiver recomputes
+	// HMAC-SHA256(secret, timestamp + "." + body) and compares it with the
+	// X-Webhook-Signature header
+	Secret string `json:"secret,omitempty"`
+	// Events limits the endpoint to these event types (default: all)
+	Events []string `json:"events,omitempty"`
+}
+
+// Config lists the endpoints and how hard to try each delivery
+type Config struct {
+	Station   string     `json:"station,omitempty"` // Default: the host name
+	Endpoints []Endpoint `json:"endpoints"`
+	Attempts  int        `json:"attempts,omitempty"`  // Default 5
+	Backoff   string     `json:"backoff,omitempty"`   // First retry delay, doubling each time; default "2s"
+	Timeout   string     `json:"timeout,omitempty"`   // Per attempt; default "10s"
+	QueueSize int        `json:"queueSize,omitempty"` // Events waiting for delivery to each endpoint; default 100
+}
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID      string      `json:"id"`
+	Type    string      `json:"type"`
+	Time    time.Time   `json:"time"`
+	Station string      `json:"station,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Load reads a webhook configuration from a JSON file
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read webhook config: %v", err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse webhook config %s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid webhook config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the endpoint URLs and durations
+func (c Config) Validate() error {
+	for _, e := range c.Endpoints {
+		u, err := url.Parse(e.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("endpoint %q is not an http(s) URL", e.URL)
+		}
+	}
+	for name, value := range map[string]string{"backoff": c.Backoff, "timeout": c.Timeout} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	if c.Attempts < 0 || c.QueueSize < 0 {
+		return fmt.Errorf("attempts and queueSize can't be negative")
+	}
+	return nil
+}
+
+// Dispatcher queues events and delivers them from a background goroutine
+// per endpoint
+type Dispatcher struct {
+	station  string
+	workers  []*worker
+	attempts int
+	backoff  time.Duration
+	client   *http.Client
+	wg       sync.WaitGroup
+
+	mu     sync.RWMutex // Held to send, and to close the queues
+	closed bool
+
+	// Cancelled when Close runs out of time: retries stop waiting, posts
+	// in flight are abandoned and the events left are dropped
+	abandon context.Context
+	cancel  context.CancelFunc
+	dropped atomic.Int64
+}
+
+// worker delivers the events queued for one endpoint, in order
+type worker struct {
+	endpoint Endpoint
+	queue    chan delivery
+}
+
+// delivery is an event queued for an endpoint, with its encoded body
+type delivery struct {
+	event Event
+	body  []byte
+}
+
+// New starts a dispatcher for a validated configuration
+func New(cfg Config) *Dispatcher {
+	queueSize := cfg.QueueSize
+	if queueSize == 0 {
+		queueSize = 100
+	}
+	d := &Dispatcher{
+		station:  cfg.Station,
+		attempts: cfg.Attempts,
+		backoff:  2 * time.Second,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	d.abandon, d.cancel = context.WithCancel(context.Background())
+	if d.station == "" {
+		d.station, _ = os.Hostname()
+	}
+	if d.attempts == 0 {
+		d.attempts = 5
+	}
+	if v, err := time.ParseDuration(cfg.Backoff); err == nil && v > 0 {
+		d.backoff = v
+	}
+	if v, err := time.ParseDuration(cfg.Timeout); err == nil && v > 0 {
+		d.client.Timeout = v
+	}
+
+	for _, endpoint := range cfg.Endpoints {
+		w := &worker{endpoint: endpoint, queue: make(chan delivery, queueSize)}
+		d.workers = append(d.workers, w)
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d
+}
+
+// Send queues an event for each endpoint that wants it. When an
+// endpoint's queue is full the event is dropped for that endpoint and
+// logged rather than blocking the caller. Events sent after Close are
+// dropped.
+func (d *Dispatcher) Send(eventType string, data interface{}) {
+	if d == nil {
+		return
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	event := Event{ID: newID(), Type: eventType, Time: time.Now().UTC(), Station: d.station, Data: data}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Webhook %s event %s can't be encoded: %v", event.Type, event.ID, err)
+		return
+	}
+	for _, w := range d.workers {
+		if !w.endpoint.wants(eventType) {
+			continue
+		}
+		select {
+		case w.queue <- delivery{event, body}:
+		default:
+			log.Printf("Webhook queue for %s full, dropped %s event %s", w.endpoint.URL, eventType, event.ID)
+		}
+	}
+}
+
+// Close delivers the queued events and stops the dispatcher. An endpoint
+// that is down could hold it up for minutes of retries, so when ctx ends
+// first the deliveries still running are abandoned and the events left
+// in the queues are dropped and logged, and Close returns an error
+// counting them.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		for _, w := range d.workers {
+			close(w.queue)
+		}
+	}
+	d.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		d.cancel()
+		<-finished // Quick now: nothing waits on the network
+	}
+	d.cancel()
+	if n := d.dropped.Load(); n > 0 {
+		return fmt.Errorf("%d webhook deliveries dropped at shutdown", n)
+	}
+	return nil
+}
+
+// run delivers one endpoint's events until its queue is closed
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for next := range w.queue {
+		if d.abandon.Err() != nil {
+			d.dropped.Add(1)
+			log.Printf("Webhook %s event %s to %s dropped: shutting down", next.event.Type, next.event.ID, w.endpoint.URL)
+			continue
+		}
+		if err := d.deliver(w.endpoint, next.event, next.body); err != nil {
+			if d.abandon.Err() != nil {
+				d.dropped.Add(1)
+			}
+			log.Printf("Webhook %s event %s to %s failed: %v", next.event.Type, next.event.ID, w.endpoint.URL, err)
+		}
+	}
+}
+
+func (e Endpoint) wants(eventType string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver posts the event, retrying network errors, 429s and 5xx
+// responses with exponential backoff
+func (d *Dispatcher) deliver(endpoint Endpoint, event Event, body []byte) error {
+	delay := d.backoff
+	var err error
+	for attempt := 1; attempt <= d.attempts; attempt++ {
+		var retry bool
+		if retry, err = d.post(endpoint, event, body); err == nil || !retry {
+			return err
+		}
+		if attempt < d.attempts {
+			select {
+			case <-time.After(delay):
+			case <-d.abandon.Done():
+				return fmt.Errorf("abandoned at shutdown after %d attempts: %v", attempt, err)
+			}
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %v", d.attempts, err)
+}
+
+// post makes one attempt and reports whether a failure is worth retrying
+func (d *Dispatcher) post(endpoint Endpoint, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(d.abandon, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "GoScanRentalTide-webhook")
+	req.Header.Set("X-Webhook-Event", event.Type)
+	req.Header.Set("X-Webhook-ID", event.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if endpoint.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+Sign(endpoint.Secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("receiver returned %s", resp.Status)
+	}
+	return false, fmt.Errorf("receiver returned %s", resp.Status)
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp + "." + body. Including
+// the timestamp lets receivers reject replayed deliveries.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "evt-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/merchant"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/tax"
+	"GoScanRentalTide/internal/tmplcache"
+	"GoScanRentalTide/internal/tmplfuncs"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// InvoiceItem is a line of an invoice
+type InvoiceItem struct {
+	Description string      `json:"description"`
+	Date        string      `json:"date,omitempty"` // When the charge was incurred, for monthly statements
+	Quantity    float64     `json:"quantity"`
+	UnitPrice   money.Cents `json:"unitPrice"`
+	TaxCode     string      `json:"taxCode,omitempty"`
+	TaxExempt   bool        `json:"taxExempt,omitempty"`
+
+	LineTotal money.Cents `json:"-"`
+}
+
+// InvoiceAccount is a charge account billed by invoice
+type InvoiceAccount struct {
+	ID      string   `json:"id,omitempty"`
+	Name    string   `json:"name"`
+	Address []string `json:"address,omitempty"` // One printed line each
+	Email   string   `json:"email,omitempty"`
+	Phone   string   `json:"phone,omitempty"`
+}
+
+// InvoiceData is a full-page invoice to print, for account customers
+// billed monthly rather than handed a receipt
+type InvoiceData struct {
+	InvoiceNumber string          `json:"invoiceNumber"`
+	TransactionID string          `json:"transactionId,omitempty"`
+	Date          string          `json:"date,omitempty"`
+	DueDate       string          `json:"dueDate,omitempty"`
+	PeriodStart   string          `json:"periodStart,omitempty"` // Billing period of a monthly invoice
+	PeriodEnd     string          `json:"periodEnd,omitempty"`
+	Account       *InvoiceAccount `json:"account,omitempty"`  // Billed party; takes precedence over customer
+	Customer      *LicenseData    `json:"customer,omitempty"` // As returned by /scanner/scan
+	Items         []InvoiceItem   `json:"items"`
+	AmountPaid    money.Cents     `json:"amountPaid,omitempty"`
+	PaymentTerms  string          `json:"paymentTerms,omitempty"` // e.g. "Net 30"
+	Terms         []string        `json:"terms,omitempty"`
+	Notes         string          `json:"notes,omitempty"`
+	Location      string          `json:"location,omitempty"`
+	LogoUrl       string          `json:"logoUrl,omitempty"`
+	PaperSize     string          `json:"paperSize,omitempty"` // "letter" (default) or "a4"
+	Copies        int             `json:"copies"`
+	Language      string          `json:"language,omitempty"`
+	ScanID        string          `json:"scanId,omitempty"`       // ID scan from /scanner/scan; fills in the customer
+	OperatorName  string          `json:"operatorName,omitempty"` // Staff member, journaled with the print job
+	StationID     string          `json:"stationId,omitempty"`    // Till or counter, journaled with the print job
+	Output        string          `json:"output,omitempty"`       // pdf (default) or html
+	Renderer      string          `json:"renderer,omitempty"`     // Browser for pdf output (default: -renderer)
+
+	// Derived fields (calculated before template rendering)
+	PageSize   string         `json:"-"`
+	BillTo     []string       `json:"-"` // Name first, then address and contact lines
+	Subtotal   money.Cents    `json:"-"`
+	TaxLines   []tax.Line     `json:"-"`
+	VATTable   []tax.Subtotal `json:"-"`
+	Tax        money.Cents    `json:"-"`
+	Total      money.Cents    `json:"-"`
+	BalanceDue money.Cents    `json:"-"`
+	Merchant   merchant.Info  `json:"-"`
+}
+
+// invoiceTaxBases groups the invoice's lines by tax code, with exempt
+// lines in a base of their own
+func invoiceTaxBases(items []InvoiceItem) []tax.Base {
+	var bases []tax.Base
+	index := make(map[tax.Base]int)
+	for _, item := range items {
+		key := tax.Base{Code: item.TaxCode}
+		if item.TaxExempt {
+			key = tax.Base{Exempt: true}
+		}
+		if i, ok := index[key]; ok {
+			bases[i].Amount += item.LineTotal
+			continue
+		}
+		index[key] = len(bases)
+		key.Amount = item.LineTotal
+		bases = append(bases, key)
+	}
+	return bases
+}
+
+// invoiceBillTo returns the lines of the bill-to block: the account's when
+// there is one, otherwise the customer's name and licence address
+func invoiceBillTo(invoice InvoiceData) []string {
+	var lines []string
+	if a := invoice.Account; a != nil {
+		lines = append(lines, a.Name)
+		lines = append(lines, a.Address...)
+		for _, contact := range []string{a.Phone, a.Email} {
+			if contact != "" {
+				lines = append(lines, contact)
+			}
+		}
+		return lines
+	}
+	c := invoice.Customer
+	lines = append(lines, c.FullName)
+	if c.Address != "" {
+		lines = append(lines, c.Address)
+	}
+	var city []string
+	for _, part := range []string{c.City, c.State} {
+		if part != "" {
+			city = append(city, part)
+		}
+	}
+	if place := strings.TrimSpace(strings.Join(city, ", ") + " " + c.Postal); place != "" {
+		lines = append(lines, place)
+	}
+	return lines
+}
+
+// generateHTMLInvoice renders an invoice with the invoice template
+func generateHTMLInvoice(invoice InvoiceData, templates *tmplcache.Cache) (string, error) {
+	language := invoice.Language
+	if language == "" {
+		language = receiptLanguage
+	}
+
+	tmpl, err := templates.Get("invoice.html", i18n.New(language).Language())
+	if err != nil {
+		return "", fmt.Errorf("error parsing invoice template: %v", err)
+	}
+
+	html, err := tmplfuncs.Render(tmpl, invoice)
+	if err != nil {
+		return "", fmt.Errorf("error executing invoice template: %v", err)
+	}
+	return html, nil
+}
+
+// prepareInvoice fills in the derived fields of an invoice. Tax is
+// computed from the tax config, per line tax code, like receipts.
+func prepareInvoice(invoice *InvoiceData) {
+	switch strings.ToLower(invoice.PaperSize) {
+	case "a4":
+		invoice.PageSize = "A4"
+	default:
+		invoice.PageSize = "letter"
+	}
+	if invoice.Date == "" {
+		invoice.Date = time.Now().Format("2006-01-02")
+	}
+	items := make([]InvoiceItem, len(invoice.Items))
+	for i, item := range invoice.Items {
+		if item.Quantity <= 0 {
+			item.Quantity = 1
+		}
+		item.LineTotal = item.UnitPrice.Times(item.Quantity)
+		invoice.Subtotal += item.LineTotal
+		items[i] = item
+	}
+	invoice.Items = items
+
+	bases := invoiceTaxBases(items)
+	invoice.TaxLines = taxConfig.Breakdown(bases)
+	for _, l := range invoice.TaxLines {
+		invoice.Tax += l.Amount
+	}
+	if merchantInfo.ShowTable() {
+		invoice.VATTable = taxConfig.Subtotals(bases)
+	}
+	invoice.Total = invoice.Subtotal + invoice.Tax
+	invoice.BalanceDue = invoice.Total - invoice.AmountPaid
+	invoice.BillTo = invoiceBillTo(*invoice)
+	invoice.Merchant = merchantInfo
+}
+
+// renderInvoice fills in the derived fields and renders the invoice
+func renderInvoice(invoice InvoiceData, opts agentOptions) (string, error) {
+	prepareInvoice(&invoice)
+	return generateHTMLInvoice(invoice, opts.Templates)
+}
+
+// printInvoice renders the invoice and prints it on the document printer
+// through the PDF pipeline with renderer
+func printInvoice(ctx context.Context, invoice InvoiceData, opts agentOptions, renderer string) error {
+	html, err := renderInvoice(invoice, opts)
+	if err != nil {
+		return err
+	}
+	return printHTMLDocument(ctx, html, "invoice", invoiceTransactionID(invoice), opts.AgreementPrinter, renderer)
+}
+
+// invoiceTransactionID is the transaction an invoice is archived and
+// journaled under: its own, or the invoice number
+func invoiceTransactionID(invoice InvoiceData) string {
+	if invoice.TransactionID != "" {
+		return invoice.TransactionID
+	}
+	return invoice.InvoiceNumber
+}
+
+// printInvoiceHandler prints an invoice on the document printer
+func printInvoiceHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var invoice InvoiceData
+	if err := json.NewDecoder(r.Body).Decode(&invoice); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if invoice.InvoiceNumber == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("invoice number is required"))
+		return
+	}
+
+	// A referenced scan supplies the customer, with the licence number masked
+	var scan verifiedScan
+	if invoice.ScanID != "" {
+		var err error
+		scan, err = lookupScan(invoice.ScanID)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if invoice.Customer == nil || (invoice.Customer.LastName == "" && invoice.Customer.FirstName == "") {
+			customer := scan.License
+			customer.RawData = ""
+			invoice.Customer = &customer
+		}
+		invoice.Customer.LicenseNumber = maskLicenseNumber(scan.License.LicenseNumber)
+		invoice.Customer.LicenseNumberRaw = maskLicenseNumber(scan.License.LicenseNumberRaw)
+	}
+	switch {
+	case invoice.Account != nil:
+		if strings.TrimSpace(invoice.Account.Name) == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("account name is required"))
+			return
+		}
+	case invoice.Customer != nil && (invoice.Customer.LastName != "" || invoice.Customer.FirstName != ""):
+		if invoice.Customer.FullName == "" {
+			nameLicense(invoice.Customer)
+		}
+	default:
+		writeJSONError(w, http.StatusBadRequest, errors.New("an account or a customer to bill is required"))
+		return
+	}
+	if len(invoice.Items) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("at least one item is required"))
+		return
+	}
+	if err := copiesError(invoice.Copies); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if invoice.Copies <= 0 {
+		invoice.Copies = 1
+	}
+	pipeline, err := parsePipeline(invoice.Output, invoice.Renderer, opts.Pipeline.forDocuments())
+	if err == nil && pipeline.Output == outputThermal {
+		err = errors.New("invoices can't be printed on a thermal printer (use pdf or html output)")
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	transactionID := invoiceTransactionID(invoice)
+	ctx, jobID := startPrintJob(r.Context())
+	printEntry := journal.Entry{
+		JobID:         jobID,
+		RequestID:     tracing.RequestID(ctx),
+		Kind:          journal.Print,
+		Status:        "success",
+		Document:      "invoice",
+		TransactionID: transactionID,
+		Copies:        invoice.Copies,
+		Printer:       opts.AgreementPrinter,
+		ScanID:        invoice.ScanID,
+		Operator:      invoice.OperatorName,
+		Station:       invoice.StationID,
+		Output:        pipeline.Output,
+	}
+
+	// A caller that prints the invoice itself gets the HTML back
+	if pipeline.Output == outputHTML {
+		html, err := renderInvoice(invoice, opts)
+		if err != nil {
+			printEntry.Status, printEntry.Error = "failed", err.Error()
+			recordJournal(printEntry)
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		retainJobArtifact(ctx, "html", transactionID, []byte(html))
+		printEntry.Printer, printEntry.Printed = "", invoice.Copies
+		recordJournal(printEntry)
+		if invoice.ScanID != "" {
+			recordScanLink(transactionID, "invoice", scan)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Rendered invoice for printing",
+			"jobId":   jobID,
+			"html":    html,
+		})
+		return
+	}
+
+	for i := 1; i <= invoice.Copies; i++ {
+		logf(r.Context(), "Printing invoice %s copy %d/%d", invoice.InvoiceNumber, i, invoice.Copies)
+		if err := printInvoice(ctx, invoice, opts, pipeline.Renderer); err != nil {
+			logf(r.Context(), "Invoice %s failed to print: %v", invoice.InvoiceNumber, err)
+			printEntry.Status, printEntry.Error = "failed", err.Error()
+			var manual *manualPrintError
+			if errors.As(err, &manual) {
+				printEntry.Status = manualPrint
+				recordJournal(printEntry)
+				writeManualPrint(w, manual)
+				return
+			}
+			recordJournal(printEntry)
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("printed %d/%d copies: %v", i-1, invoice.Copies, err))
+			return
+		}
+		printEntry.Printed = i
+	}
+	recordJournal(printEntry)
+
+	if invoice.ScanID != "" {
+		recordScanLink(transactionID, "invoice", scan)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Printed %d/%d copies successfully", invoice.Copies, invoice.Copies),
+		"jobId":   jobID,
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"GoScanRentalTide/internal/archive"
+)
+
+// Every print request is a job with its own ID, journaled and returned to
+// the caller. With -job-artifact-days set, the exact document each job
+// produced (the HTML, the PDF sent to the printer or the ESC/POS bytes) is
+// kept for that many days, so a dispute about what a receipt said can be
+// settled with the receipt itself.
+var jobArtifacts *archive.Archive
+
+// Job IDs are used in archive names, so only these are accepted
+var jobIDPattern = regexp.MustCompile(`^job-[0-9a-f]{16}$`)
+
+// Artifact kinds of a job, the one closest to what was printed first
+var jobArtifactKinds = []string{"pdf", "escpos", "html"}
+
+type printJobKey struct{}
+
+// startPrintJob gives a print request its job ID, carried in the returned
+// context to the functions that render and print its document
+func startPrintJob(ctx context.Context) (context.Context, string) {
+	var b [8]byte
+	rand.Read(b[:])
+	id := "job-" + hex.EncodeToString(b[:])
+	return context.WithValue(ctx, printJobKey{}, id), id
+}
+
+// printJobID returns the job ID startPrintJob put in ctx, or ""
+func printJobID(ctx context.Context) string {
+	id, _ := ctx.Value(printJobKey{}).(string)
+	return id
+}
+
+// retainJobArtifact keeps a document the job in ctx produced. kind is the
+// file extension without the dot. Failures are logged but never fail the
+// print.
+func retainJobArtifact(ctx context.Context, kind, transactionID string, data []byte) {
+	id := printJobID(ctx)
+	if jobArtifacts == nil || id == "" {
+		return
+	}
+	if _, err := jobArtifacts.Store(id+"."+kind, transactionID, data, time.Now()); err != nil {
+		logf(ctx, "Error keeping %s of print job %s: %v", kind, id, err)
+	}
+}
+
+// runJobArtifactExpiry drops job artifacts older than retention, hourly
+func runJobArtifactExpiry(retention time.Duration) {
+	for {
+		if expired, err := jobArtifacts.Expire(retention); err != nil {
+			log.Printf("Expiring print job artifacts failed: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d print job artifacts", expired)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// jobArtifactHandler returns what a print job produced: by default the
+// document the printer was sent, or one kind (?kind=html, pdf or escpos)
+func jobArtifactHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only GET method is allowed"))
+		return
+	}
+	if jobArtifacts == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, errors.New("print job artifacts are not kept (set -job-artifact-days)"))
+		return
+	}
+	id := r.PathValue("id")
+	if !jobIDPattern.MatchString(id) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid print job ID %q", id))
+		return
+	}
+
+	kinds := jobArtifactKinds
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		kinds = []string{kind}
+	}
+	for _, kind := range kinds {
+		entry, data, err := jobArtifacts.Get(id + "." + kind)
+		if errors.Is(err, archive.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		switch entry.Kind {
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		default:
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", entry.Name))
+		if entry.TransactionID != "" {
+			w.Header().Set("X-Transaction-Id", entry.TransactionID)
+		}
+		w.Write(data)
+		return
+	}
+	writeJSONError(w, http.StatusNotFound, fmt.Errorf("no artifact kept for print job %s", id))
+}
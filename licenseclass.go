@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"GoScanRentalTide/internal/validate"
+)
+
+// Vehicle categories a rental can require. The agent knows which license
+// classes cover each in every jurisdiction, so the frontend doesn't.
+const (
+	vehicleCar            = "car"
+	vehicleMotorcycle     = "motorcycle"
+	vehicleTruck          = "truck"           // Straight trucks past what a car license allows
+	vehicleBus            = "bus"             // Passenger vans and buses
+	vehicleTractorTrailer = "tractor_trailer" // Semi-trailers and heavy towing
+)
+
+var vehicleCategories = []string{vehicleCar, vehicleMotorcycle, vehicleTruck, vehicleBus, vehicleTractorTrailer}
+
+// classSystem is how a jurisdiction names its license classes
+type classSystem struct {
+	code    *regexp.Regexp      // One class in the class element, which may list several: "56", "G M2"
+	covers  map[string][]string // Vehicle categories each class allows
+	learner map[string]bool     // Learner's classes, which don't allow driving alone
+	// A motorcycle endorsement (M) on another class allows motorcycles
+	motorcycleEndorsement bool
+}
+
+var (
+	// Most provinces and territories number their classes: 1 semi-trailers,
+	// 2 buses, 3 trucks, 4 taxis and ambulances, 5 cars, 6 motorcycles.
+	// A trailing L marks a learner (BC 7L); BC's novice class 7 drives a
+	// car alone.
+	numberedClasses = classSystem{
+		code: regexp.MustCompile(`\d[A-Z]?`),
+		covers: map[string][]string{
+			"1": {vehicleCar, vehicleTruck, vehicleBus, vehicleTractorTrailer},
+			"2": {vehicleCar, vehicleBus},
+			"3": {vehicleCar, vehicleTruck},
+			"4": {vehicleCar},
+			"5": {vehicleCar},
+			"6": {vehicleMotorcycle},
+			"7": {vehicleCar}, "7N": {vehicleCar},
+			// Quebec's 4A-4C and 6A-6E
+			"4A": {vehicleCar}, "4B": {vehicleCar, vehicleBus}, "4C": {vehicleCar},
+			"6A": {vehicleMotorcycle}, "6B": {vehicleMotorcycle}, "6C": {vehicleMotorcycle},
+			"5L": {vehicleCar}, "6L": {vehicleMotorcycle}, "7L": {vehicleCar}, "8L": {vehicleMotorcycle},
+		},
+		learner: map[string]bool{"5L": true, "6L": true, "7L": true, "8L": true},
+	}
+	// Alberta's class 7 is the learner's class
+	albertaClasses = classSystem{
+		code:    numberedClasses.code,
+		covers:  numberedClasses.covers,
+		learner: map[string]bool{"7": true, "5L": true, "6L": true, "7L": true, "8L": true},
+	}
+	// Ontario: G cars (G1 learner, G2 novice), M motorcycles (M1
+	// learner), A tractor-trailers, B-F buses and trucks
+	ontarioClasses = classSystem{
+		code: regexp.MustCompile(`[A-Z]\d?`),
+		covers: map[string][]string{
+			"A": {vehicleCar, vehicleTruck, vehicleTractorTrailer},
+			"B": {vehicleCar, vehicleTruck, vehicleBus},
+			"C": {vehicleCar, vehicleTruck, vehicleBus},
+			"D": {vehicleCar, vehicleTruck},
+			"E": {vehicleCar, vehicleBus},
+			"F": {vehicleCar, vehicleBus},
+			"G": {vehicleCar}, "G1": {vehicleCar}, "G2": {vehicleCar},
+			"M": {vehicleMotorcycle}, "M1": {vehicleMotorcycle}, "M2": {vehicleMotorcycle},
+		},
+		learner: map[string]bool{"G1": true, "M1": true},
+	}
+	// US states: A-C commercial (C a car in California and states
+	// without commercial classes), D and E cars, M motorcycles
+	stateClasses = classSystem{
+		code: regexp.MustCompile(`[A-Z]\d?`),
+		covers: map[string][]string{
+			"A": {vehicleCar, vehicleTruck, vehicleTractorTrailer},
+			"B": {vehicleCar, vehicleTruck, vehicleBus},
+			"C": {vehicleCar},
+			"D": {vehicleCar},
+			"E": {vehicleCar},
+			"M": {vehicleMotorcycle}, "M1": {vehicleMotorcycle}, "M2": {vehicleMotorcycle},
+		},
+		motorcycleEndorsement: true,
+	}
+)
+
+// licenseClassSystem returns the class system of a license's jurisdiction
+func licenseClassSystem(jurisdiction string) classSystem {
+	switch jurisdiction {
+	case "AB":
+		return albertaClasses
+	case "ON":
+		return ontarioClasses
+	case "BC", "SK", "MB", "QC", "NB", "NS", "PE", "NL", "YT", "NT", "NU":
+		return numberedClasses
+	}
+	return stateClasses
+}
+
+// LicenseValidateRequest is what a rental requires of the driver, for
+// /scanner/validate
+type LicenseValidateRequest struct {
+	ScanID       string   `json:"scanId,omitempty"`          // The scan to check; without one the agent scans now
+	Class        string   `json:"class" validate:"required"` // car, motorcycle, truck, bus or tractor_trailer
+	Endorsements []string `json:"endorsements,omitempty"`    // Endorsement codes as the jurisdiction prints them, e.g. M
+	AllowLearner bool     `json:"allowLearner,omitempty"`    // Accept a learner's license
+}
+
+// LicenseValidateResponse says whether a license meets a rental's
+// requirement. Verified is false when the card's class couldn't be read
+// or isn't one the agent knows; staff then check the card themselves.
+type LicenseValidateResponse struct {
+	Status       string   `json:"status"`
+	ScanID       string   `json:"scanId"`
+	Eligible     bool     `json:"eligible"`
+	Verified     bool     `json:"verified"`
+	Jurisdiction string   `json:"jurisdiction"`
+	LicenseClass string   `json:"licenseClass"`
+	Covers       []string `json:"covers"`            // Vehicle categories the license's classes allow
+	Reasons      []string `json:"reasons,omitempty"` // Why the license isn't eligible or couldn't be verified
+}
+
+// validateLicenseHandler checks a scanned license against what a rental
+// requires. Without a scanId it scans first, answering with the scan's
+// error if the scan fails.
+func validateLicenseHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if err := validate.JSON(body, LicenseValidateRequest{}); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	var req LicenseValidateRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error parsing JSON data: %v", err))
+		return
+	}
+	if !slices.Contains(vehicleCategories, req.Class) {
+		writeValidationError(w, validate.Errors{{Field: "class", Message: fmt.Sprintf("must be one of %s", strings.Join(vehicleCategories, ", "))}})
+		return
+	}
+
+	scanID := req.ScanID
+	if scanID == "" {
+		// Scan as /scanner/scan would, passing on its ?timeout and ?queue
+		scanned, err := scanCard(r, opts)
+		if err != nil {
+			writeScanError(w, err)
+			return
+		}
+		if scanned.ScanID == "" {
+			writeJSONError(w, http.StatusUnprocessableEntity, errors.New("the card scanned is not a driver's license"))
+			return
+		}
+		scanID = scanned.ScanID
+	}
+	scan, err := lookupScan(scanID)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := checkLicenseClass(scan.License, req)
+	resp.Status, resp.ScanID = "success", scanID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// checkLicenseClass compares a license's classes and endorsements with
+// what a rental requires
+func checkLicenseClass(license LicenseData, req LicenseValidateRequest) LicenseValidateResponse {
+	jurisdiction := licenseJurisdiction(license.RawData, license)
+	resp := LicenseValidateResponse{Jurisdiction: jurisdiction, LicenseClass: license.LicenseClass, Covers: []string{}}
+
+	class := strings.ToUpper(license.LicenseClass)
+	if class == "" || class == "NA" {
+		resp.Reasons = append(resp.Reasons, "the card doesn't encode its license class; check the class printed on it")
+		return resp
+	}
+	system := licenseClassSystem(jurisdiction)
+	known, learnerOnly := false, true
+	for _, code := range system.code.FindAllString(class, -1) {
+		covers, ok := system.covers[code]
+		if !ok {
+			continue
+		}
+		known = true
+		for _, category := range covers {
+			if !slices.Contains(resp.Covers, category) {
+				resp.Covers = append(resp.Covers, category)
+			}
+			if category == req.Class && !system.learner[code] {
+				learnerOnly = false
+			}
+		}
+	}
+	if system.motorcycleEndorsement && slices.Contains(license.Endorsements, "M") && !slices.Contains(resp.Covers, vehicleMotorcycle) {
+		resp.Covers = append(resp.Covers, vehicleMotorcycle)
+		if req.Class == vehicleMotorcycle {
+			learnerOnly = false
+		}
+	}
+	if !known {
+		resp.Reasons = append(resp.Reasons, fmt.Sprintf("class %s is not one the agent knows for %q licenses; check the card", license.LicenseClass, jurisdiction))
+		return resp
+	}
+	resp.Verified = true
+
+	switch {
+	case !slices.Contains(resp.Covers, req.Class):
+		resp.Reasons = append(resp.Reasons, fmt.Sprintf("class %s does not allow driving a %s", license.LicenseClass, strings.ReplaceAll(req.Class, "_", "-")))
+	case learnerOnly && !req.AllowLearner:
+		resp.Reasons = append(resp.Reasons, fmt.Sprintf("class %s is a learner's license", license.LicenseClass))
+	}
+	for _, endorsement := range req.Endorsements {
+		if !slices.Contains(license.Endorsements, strings.ToUpper(strings.TrimSpace(endorsement))) {
+			resp.Reasons = append(resp.Reasons, fmt.Sprintf("the license has no %s endorsement", strings.ToUpper(endorsement)))
+		}
+	}
+	resp.Eligible = len(resp.Reasons) == 0
+	return resp
+}
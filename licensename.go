@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+)
+
+// Generational suffixes, as cards write them without periods
+var nameSuffixes = map[string]bool{
+	"JR": true, "SR": true,
+	"I": true, "II": true, "III": true, "IV": true, "V": true,
+	"VI": true, "VII": true, "VIII": true, "IX": true,
+	"1ST": true, "2ND": true, "3RD": true, "4TH": true, "5TH": true,
+	"6TH": true, "7TH": true, "8TH": true, "9TH": true,
+}
+
+// Suffixes that can't also be a middle initial, so they're taken off the
+// end of the given names as well
+var unambiguousSuffixes = map[string]bool{"JR": true, "SR": true, "II": true, "III": true, "IV": true}
+
+// AAMVA name truncation indicators (DDE, DDF, DDG) and the field each
+// one is about
+var truncationElements = map[string]string{"DDE": "lastName", "DDF": "firstName", "DDG": "middleName"}
+
+// nameLicense tidies the suffix, moves one that was written into the
+// family name ("SMITH JR") or after the given names ("JANE MARIE JR") to
+// the suffix field, and composes the full name
+func nameLicense(license *LicenseData) {
+	license.Suffix = normalizeSuffix(license.Suffix)
+	if license.Suffix == "" {
+		if rest, suffix, ok := cutSuffix(license.LastName, nameSuffixes); ok && rest != "" {
+			license.LastName, license.Suffix = rest, suffix
+		} else if rest, suffix, ok := cutSuffix(license.MiddleName, unambiguousSuffixes); ok {
+			license.MiddleName, license.Suffix = rest, suffix
+		} else if rest, suffix, ok := cutSuffix(license.FirstName, unambiguousSuffixes); ok && rest != "" {
+			license.FirstName, license.Suffix = rest, suffix
+		}
+	}
+	license.FullName = fullName(*license)
+}
+
+// cutSuffix splits a suffix off the end of a name, after a space or comma
+func cutSuffix(name string, suffixes map[string]bool) (rest, suffix string, ok bool) {
+	name = strings.TrimSpace(name)
+	i := strings.LastIndexAny(name, " ,")
+	if suffix = normalizeSuffix(name[i+1:]); !suffixes[suffix] {
+		return name, "", false
+	}
+	if i < 0 {
+		return "", suffix, true
+	}
+	return strings.TrimRight(name[:i], " ,"), suffix, true
+}
+
+// normalizeSuffix writes a suffix as cards do: upper case, no periods
+func normalizeSuffix(suffix string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(suffix), ".", ""))
+}
+
+// fullName is the legal name in reading order: given names, family name,
+// then the suffix, as in JOHN QUINCY SMITH JR
+func fullName(license LicenseData) string {
+	var parts []string
+	for _, part := range []string{license.FirstName, license.MiddleName, license.LastName, license.Suffix} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseAAMVAFullName splits the version 1 full name element (DAA), written
+// LAST,FIRST,MIDDLE,SUFFIX, or LAST,FIRST MIDDLE by some jurisdictions
+func parseAAMVAFullName(name string, data map[string]string) {
+	fields := strings.Split(name, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	data["lastName"] = fields[0]
+	if len(fields) > 1 {
+		first, middle, _ := strings.Cut(fields[1], " ")
+		data["firstName"], data["middleName"] = first, strings.TrimSpace(middle)
+	}
+	if len(fields) > 2 && fields[2] != "" {
+		data["middleName"] = fields[2]
+	}
+	if len(fields) > 3 {
+		data["suffix"] = fields[3]
+	}
+}
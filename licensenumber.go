@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Issuer identification numbers (IIN) of the jurisdictions whose license
+// numbers have a normal form, as they appear on track 2 of a swipe and in
+// the header of a barcode
+var jurisdictionIINs = map[string]string{
+	"636028": "BC",
+	"604432": "AB",
+	"636012": "ON",
+	"636045": "WA",
+}
+
+var (
+	track2IINPattern = regexp.MustCompile(`;((?:636|604)\d{3})`)
+	headerIINPattern = regexp.MustCompile(`ANSI (\d{6})`)
+	ontarioPattern   = regexp.MustCompile(`^[A-Z]\d{14}$`)
+)
+
+// licenseJurisdiction returns the jurisdiction that issued a scanned
+// license: the one its IIN belongs to, or else the state it was read with
+func licenseJurisdiction(raw string, license LicenseData) string {
+	for _, pattern := range []*regexp.Regexp{headerIINPattern, track2IINPattern} {
+		if m := pattern.FindStringSubmatch(raw); m != nil {
+			if jurisdiction, ok := jurisdictionIINs[m[1]]; ok {
+				return jurisdiction
+			}
+		}
+	}
+	return strings.ToUpper(strings.TrimSpace(license.State))
+}
+
+// normalizeLicense keeps the license number as it was encoded in
+// LicenseNumberRaw and puts it in its jurisdiction's usual form, the one
+// printed on the card, in LicenseNumber
+func normalizeLicense(license *LicenseData, raw string) {
+	if license.LicenseNumber == "" {
+		return
+	}
+	if license.LicenseNumberRaw == "" {
+		license.LicenseNumberRaw = license.LicenseNumber
+	}
+	if number, ok := normalizeLicenseNumber(licenseJurisdiction(raw, *license), license.LicenseNumberRaw); ok {
+		license.LicenseNumber = number
+	}
+}
+
+// normalizeLicenseNumber returns a license number as encoded in the form
+// its jurisdiction prints it:
+//
+//	BC  7 digits, the end of the track 2 number on a swipe
+//	AB  9 digits as 123456-789, likewise
+//	ON  a letter and 14 digits as A1234-56789-01234
+//	WA  upper case without spaces or dashes: WDL1234567AB
+//
+// It reports false for other jurisdictions, and for numbers that don't
+// fit their jurisdiction's form, which are left as read.
+func normalizeLicenseNumber(jurisdiction, encoded string) (string, bool) {
+	switch jurisdiction {
+	case "BC":
+		digits := onlyDigits(encoded)
+		if len(digits) < 7 {
+			return "", false
+		}
+		return digits[len(digits)-7:], true
+	case "AB":
+		digits := onlyDigits(encoded)
+		if len(digits) < 9 {
+			return "", false
+		}
+		digits = digits[len(digits)-9:]
+		return digits[:6] + "-" + digits[6:], true
+	case "ON":
+		compact := strings.ToUpper(strings.Map(dropSeparator, encoded))
+		if !ontarioPattern.MatchString(compact) {
+			return "", false
+		}
+		return compact[:5] + "-" + compact[5:10] + "-" + compact[10:], true
+	case "WA":
+		compact := strings.ToUpper(strings.Map(dropSeparator, encoded))
+		return compact, compact != ""
+	}
+	return "", false
+}
+
+// dropSeparator removes the spaces and dashes a number may be written with
+func dropSeparator(r rune) rune {
+	if r == '-' || unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < '0' || r > '9' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+
+	"GoScanRentalTide/internal/limits"
+)
+
+// limitRequests rate limits a handler per client and caps the size of
+// request bodies at maxBody bytes (no cap when zero)
+func limitRequests(limiter *limits.RateLimiter, maxBody int64, next http.HandlerFunc) http.HandlerFunc {
+	return limits.Requests(limiter, maxBody, writeJSONError, next)
+}
+
+// writeBodyError reports a request body that could not be read or parsed,
+// with 413 for bodies over the size limit
+func writeBodyError(w http.ResponseWriter, err error) {
+	status, err := limits.BodyError(err)
+	writeJSONError(w, status, err)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -10,6 +12,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -18,93 +21,376 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"flag"
 	"go.bug.st/serial"
+
+	"GoScanRentalTide/internal/aamva"
+	"GoScanRentalTide/internal/apiversion"
+	"GoScanRentalTide/internal/archive"
+	"GoScanRentalTide/internal/audit"
+	"GoScanRentalTide/internal/coupons"
+	"GoScanRentalTide/internal/dedupe"
+	"GoScanRentalTide/internal/display"
+	"GoScanRentalTide/internal/glcode"
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/limits"
+	"GoScanRentalTide/internal/listen"
+	"GoScanRentalTide/internal/magstripe"
+	"GoScanRentalTide/internal/merchant"
+	"GoScanRentalTide/internal/messages"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/mqtt"
+	"GoScanRentalTide/internal/paper"
+	"GoScanRentalTide/internal/payloadlog"
+	"GoScanRentalTide/internal/qr"
+	"GoScanRentalTide/internal/rental"
+	"GoScanRentalTide/internal/sanitize"
+	"GoScanRentalTide/internal/script"
+	"GoScanRentalTide/internal/seal"
+	"GoScanRentalTide/internal/sequence"
+	"GoScanRentalTide/internal/simulate"
+	"GoScanRentalTide/internal/tax"
+	"GoScanRentalTide/internal/tmplcache"
+	"GoScanRentalTide/internal/tmplfuncs"
+	"GoScanRentalTide/internal/tracing"
+	"GoScanRentalTide/internal/transport"
+	"GoScanRentalTide/internal/txstore"
+	"GoScanRentalTide/internal/validate"
+	"GoScanRentalTide/internal/webhook"
 )
 
 // LicenseData type for driver's license data
 type LicenseData struct {
-	FirstName     string `json:"firstName"`
-	MiddleName    string `json:"middleName"`
-	LastName      string `json:"lastName"`
-	Address       string `json:"address"`
-	City          string `json:"city"`
-	State         string `json:"state"`
-	Postal        string `json:"postal"`
-	LicenseNumber string `json:"licenseNumber"`
-	IssueDate     string `json:"issueDate"`
-	ExpiryDate    string `json:"expiryDate"`
-	Height        string `json:"height"`
-	Sex           string `json:"sex"`
-	LicenseClass  string `json:"licenseClass"`
-	Dob           string `json:"dob"`
-	RawData       string `json:"rawData,omitempty"` // Added to show raw data for debugging
+	FirstName        string   `json:"firstName"`
+	MiddleName       string   `json:"middleName"`
+	LastName         string   `json:"lastName"`
+	Suffix           string   `json:"suffix,omitempty"`    // JR, SR, III, ...
+	FullName         string   `json:"fullName,omitempty"`  // Legal name in reading order, suffix last, for contracts
+	Truncated        []string `json:"truncated,omitempty"` // Name fields the card says it cut short to fit
+	Address          string   `json:"address"`
+	City             string   `json:"city"`
+	State            string   `json:"state"`
+	Postal           string   `json:"postal"`
+	LicenseNumber    string   `json:"licenseNumber"`
+	LicenseNumberRaw string   `json:"licenseNumberRaw,omitempty"` // As encoded on the card; licenseNumber is in the jurisdiction's usual form
+	IssueDate        string   `json:"issueDate"`
+	ExpiryDate       string   `json:"expiryDate"`
+	Height           string   `json:"height"`               // As on the card: 180cm, 070in
+	HeightCm         int      `json:"heightCm,omitempty"`   // Height in both units, for waiver forms
+	HeightFtIn       string   `json:"heightFtIn,omitempty"` // 5'11"
+	WeightKg         int      `json:"weightKg,omitempty"`   // Weight in both units, when the card has it
+	WeightLb         int      `json:"weightLb,omitempty"`
+	EyeColor         string   `json:"eyeColor,omitempty"` // Brown, Blue, ...
+	Sex              string   `json:"sex"`
+	LicenseClass     string   `json:"licenseClass"`
+	Endorsements     []string `json:"endorsements,omitempty"` // Endorsement codes (DCD), e.g. M for motorcycles
+	Restrictions     []string `json:"restrictions,omitempty"` // Restriction codes (DCB), e.g. B for corrective lenses
+	OrganDonor       bool     `json:"organDonor,omitempty"`
+	Veteran          bool     `json:"veteran,omitempty"`
+	Dob              string   `json:"dob"`
+	RawData          string   `json:"rawData,omitempty"` // Added to show raw data for debugging; only sent with -debug-scans
 }
 
 // ReceiptItem represents an item on a receipt
 type ReceiptItem struct {
-	Name     string      `json:"name"`
-	Quantity interface{} `json:"quantity"` // Can be int or float64
-	Price    float64     `json:"price"`
-	SKU      string      `json:"sku,omitempty"`
+	Name      string      `json:"name" validate:"required"`
+	Quantity  interface{} `json:"quantity" validate:"number,nonnegative"` // A number or a numeric string
+	Price     money.Cents `json:"price"`
+	SKU       string      `json:"sku,omitempty"`
+	TaxCode   string      `json:"taxCode,omitempty"`   // Selects the rates applied to this item
+	TaxExempt bool        `json:"taxExempt,omitempty"` // No tax whatever the code
+	ItemType  string      `json:"itemType,omitempty"`  // sale (default), rental, deposit, waiver or fee
+	StartDate string      `json:"startDate,omitempty"` // Rentals: when the item goes out
+	DueDate   string      `json:"dueDate,omitempty"`   // Rentals: when it is due back
+	RateUnit  string      `json:"rateUnit,omitempty"`  // Rentals: hourly, daily, weekly or monthly
+	LineTotal money.Cents `json:"-"`                   // Price x quantity, set before rendering
+}
+
+// Item types. Deposits are held rather than charged: they are never taxed
+// and print in a section of their own.
+const (
+	itemSale    = "sale"
+	itemRental  = "rental"
+	itemDeposit = "deposit"
+	itemWaiver  = "waiver"
+	itemFee     = "fee"
+)
+
+// IsDeposit reports whether the item is a held deposit
+func (item ReceiptItem) IsDeposit() bool {
+	return item.ItemType == itemDeposit
+}
+
+// TypeKey returns the translation key of the marker printed under the
+// item; sales and deposits have none
+func (item ReceiptItem) TypeKey() string {
+	switch item.ItemType {
+	case itemRental, itemWaiver, itemFee:
+		return "item_" + item.ItemType
+	}
+	return ""
+}
+
+// Out returns when a rental goes out, as printed
+func (item ReceiptItem) Out() string {
+	return rental.Format(item.StartDate)
+}
+
+// DueBack returns when a rental is due back, as printed
+func (item ReceiptItem) DueBack() string {
+	return rental.Format(item.DueDate)
+}
+
+// UnitKey returns the translation key of the unit the price is charged
+// per, or "" when it isn't a rate
+func (item ReceiptItem) UnitKey() string {
+	return rental.UnitKey(item.RateUnit)
+}
+
+// itemTypeError rejects items with an unknown type or a rental period
+// that doesn't make sense
+func itemTypeError(items []ReceiptItem) error {
+	for i, item := range items {
+		switch item.ItemType {
+		case "", itemSale, itemRental, itemDeposit, itemWaiver, itemFee:
+		default:
+			return fmt.Errorf("item %d has unknown itemType %q (use sale, rental, deposit, waiver or fee)", i+1, item.ItemType)
+		}
+		if err := rental.Validate(item.StartDate, item.DueDate, item.RateUnit); err != nil {
+			return fmt.Errorf("item %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// earliestDueBack returns when the first rental on the receipt is due
+// back, for the banner
+func earliestDueBack(items []ReceiptItem) string {
+	var dues []string
+	for _, item := range items {
+		if item.DueDate != "" {
+			dues = append(dues, item.DueDate)
+		}
+	}
+	return rental.Earliest(dues)
+}
+
+// Payment is one tender of a split payment
+type Payment struct {
+	Type        string                 `json:"type" validate:"required"` // cash, credit, debit, account, ...
+	Amount      money.Cents            `json:"amount" validate:"positive"`
+	CardDetails map[string]interface{} `json:"cardDetails,omitempty"` // Card tenders: cardBrand, cardLast4, authCode
+}
+
+// Card returns the card a tender was paid with, e.g. "Visa **** 1234", or
+// "" when the tender has no card details
+func (p Payment) Card() string {
+	brand, _ := p.CardDetails["cardBrand"].(string)
+	last4, _ := p.CardDetails["cardLast4"].(string)
+	card := strings.Title(brand)
+	if last4 != "" {
+		card = strings.TrimSpace(card + " **** " + last4)
+	}
+	return card
+}
+
+// AuthCode returns the authorization code of a card tender
+func (p Payment) AuthCode() string {
+	code, _ := p.CardDetails["authCode"].(string)
+	return code
+}
+
+// paymentsError rejects split payments with a tender that has no type or
+// no amount
+func paymentsError(payments []Payment) error {
+	for i, p := range payments {
+		if p.Type == "" {
+			return fmt.Errorf("payment %d has no type", i+1)
+		}
+		if p.Amount <= 0 {
+			return fmt.Errorf("payment %d (%s) must have a positive amount", i+1, p.Type)
+		}
+	}
+	return nil
+}
+
+// messageBlock is a custom message as rendered, with its QR code drawn
+type messageBlock struct {
+	messages.Block
+	QRImage template.URL
+}
+
+// messageBlocks draws the QR codes of custom messages
+func messageBlocks(blocks []messages.Block) []messageBlock {
+	var rendered []messageBlock
+	for _, b := range blocks {
+		block := messageBlock{Block: b}
+		if b.QR != "" {
+			url, err := qr.DataURL(b.QR)
+			if err != nil {
+				log.Printf("Printing message %q without its QR code: %v", b.Title, err)
+			}
+			block.QRImage = template.URL(url)
+		}
+		rendered = append(rendered, block)
+	}
+	return rendered
+}
+
+// sanitizeReceipt cleans the text of a receipt before it's rendered and
+// caps its length. The logo must be an http(s) URL: a file: URL would
+// put a file from this computer on the receipt.
+func sanitizeReceipt(receipt *ReceiptData) error {
+    if err := sanitize.URL(receipt.LogoUrl); err != nil {
+        return fmt.Errorf("logoUrl: %v", err)
+    }
+    sanitize.Lines(sanitize.MaxName, &receipt.TransactionID, &receipt.CustomerName, &receipt.Date, &receipt.PaymentType,
+        &receipt.Type, &receipt.Timestamp, &receipt.Language, &receipt.OriginalTransactionID, &receipt.RefundMethod,
+        &receipt.TerminalId, &receipt.OperatorName, &receipt.StationID, &receipt.AccountId, &receipt.SignatureID, &receipt.ScanID)
+    switch location := receipt.Location.(type) {
+    case string:
+        receipt.Location = sanitize.Line(location, sanitize.MaxName)
+    case map[string]interface{}:
+        sanitizeFields(location)
+    }
+    sanitizeFields(receipt.CardDetails)
+    for i := range receipt.Items {
+        item := &receipt.Items[i]
+        sanitize.Lines(sanitize.MaxName, &item.Name, &item.SKU, &item.TaxCode, &item.ItemType, &item.StartDate, &item.DueDate, &item.RateUnit)
+    }
+    for i := range receipt.Payments {
+        sanitize.Lines(sanitize.MaxName, &receipt.Payments[i].Type)
+        sanitizeFields(receipt.Payments[i].CardDetails)
+    }
+    for i := range receipt.TaxBreakdown {
+        sanitize.Lines(sanitize.MaxName, &receipt.TaxBreakdown[i].Code, &receipt.TaxBreakdown[i].Name)
+    }
+    for i := range receipt.GLCodeSummary {
+        sanitize.Lines(sanitize.MaxName, &receipt.GLCodeSummary[i].Code, &receipt.GLCodeSummary[i].Description)
+    }
+    messages.Clean(receipt.HeaderMessages)
+    messages.Clean(receipt.FooterMessages)
+    coupons.Clean(receipt.Coupons)
+    return nil
+}
+
+// sanitizeFields cleans the text values of a free-form JSON object
+func sanitizeFields(fields map[string]interface{}) {
+    for key, value := range fields {
+        if text, ok := value.(string); ok {
+            fields[key] = sanitize.Line(text, sanitize.MaxName)
+        }
+    }
 }
 
 // ReceiptData represents the data for a receipt
 type ReceiptData struct {
 	TransactionID      string        `json:"transactionId"`
 	Items              []ReceiptItem `json:"items"`
-	Subtotal           float64       `json:"subtotal"`
-	Tax                float64       `json:"tax"`
-	Total              float64       `json:"total"`
-	Tip                float64       `json:"tip,omitempty"`
+	Subtotal           money.Cents       `json:"subtotal"`
+	Tax                money.Cents       `json:"tax"`
+	Total              money.Cents       `json:"total"`
+	Tip                money.Cents       `json:"tip,omitempty" validate:"nonnegative"`
 	CustomerName       string        `json:"customerName,omitempty"`
 	Date               string        `json:"date"`
-	Location           interface{}   `json:"location"` // Can be a string or an object with a name field
+	Location           interface{}   `json:"location" validate:"kind=string|object"` // Can be a string or an object with a name field
 	PaymentType        string        `json:"paymentType"`
-	RefundAmount       float64       `json:"refundAmount,omitempty"`
-	DiscountAmount     float64       `json:"discountAmount,omitempty"`
-	DiscountPercentage float64       `json:"discountPercentage,omitempty"`
-	PromoAmount        float64       `json:"promoAmount,omitempty"`
-	CashGiven          float64       `json:"cashGiven,omitempty"`
-	ChangeDue          float64       `json:"changeDue,omitempty"`
-	Copies             int           `json:"copies"`
-	Type               string        `json:"type,omitempty"`      // Added for 'noSale' type
+	Payments           []Payment     `json:"payments,omitempty"` // Split payments, one per tender
+	RefundAmount       money.Cents       `json:"refundAmount,omitempty"`
+	DiscountAmount     money.Cents       `json:"discountAmount,omitempty"`
+	DiscountPercentage float64       `json:"discountPercentage,omitempty" validate:"nonnegative"`
+	PromoAmount        money.Cents       `json:"promoAmount,omitempty"`
+	CashGiven          money.Cents       `json:"cashGiven,omitempty" validate:"nonnegative"`
+	ChangeDue          money.Cents       `json:"changeDue,omitempty"`
+	Copies             int           `json:"copies" validate:"nonnegative"`
+	Type               string        `json:"type,omitempty"`      // Added for 'noSale' and 'refund' types
 	Timestamp          string        `json:"timestamp,omitempty"` // Added for timestamp
+	Language           string        `json:"language,omitempty"`  // Receipt language, e.g. "fr" (default: -language)
+
+	// Refund fields (used when Type is "refund")
+	OriginalTransactionID string `json:"originalTransactionId,omitempty"`
+	RefundMethod          string `json:"refundMethod,omitempty"`
 	
 	// Enhanced fields
 	TerminalId           string                 `json:"terminalId,omitempty"`
+	OperatorName         string                 `json:"operatorName,omitempty"` // Printed as "Served by"
+	StationID            string                 `json:"stationId,omitempty"`    // Till or counter the sale was rung up on
 	CardDetails          map[string]interface{} `json:"cardDetails,omitempty"`
 	AccountId            string                 `json:"accountId,omitempty"`
-	AccountBalanceBefore float64                `json:"accountBalanceBefore,omitempty"`
-	AccountBalanceAfter  float64                `json:"accountBalanceAfter,omitempty"`
-	SettlementAmount     float64                `json:"settlementAmount,omitempty"`
-	TransactionFee       float64                `json:"transactionFee,omitempty"`
-	InterchangeFee       float64                `json:"interchangeFee,omitempty"`
-	GLCodeSummary        []map[string]interface{} `json:"glCodeSummary,omitempty"`
+	AccountBalanceBefore money.Cents            `json:"accountBalanceBefore,omitempty"`
+	AccountBalanceAfter  money.Cents            `json:"accountBalanceAfter,omitempty"`
+	SettlementAmount     money.Cents            `json:"settlementAmount,omitempty"`
+	TransactionFee       money.Cents            `json:"transactionFee,omitempty"`
+	InterchangeFee       money.Cents            `json:"interchangeFee,omitempty"`
+	GLCodeSummary        []glcode.Line          `json:"glCodeSummary,omitempty"` // Printed in the settlement section
 	IsSettlement         bool                   `json:"isSettlement,omitempty"`
 	IsRetail             bool                   `json:"isRetail,omitempty"`
 	HasCombinedTransaction bool                 `json:"hasCombinedTransaction,omitempty"`
 	SkipTaxCalculation   bool                   `json:"skipTaxCalculation,omitempty"`
 	HasNoTax             bool                   `json:"hasNoTax,omitempty"`
 	LogoUrl              string                 `json:"logoUrl,omitempty"`
+	TaxBreakdown         []tax.Line             `json:"taxBreakdown,omitempty"` // Optional, computed from the tax config when absent
+	SignatureID          string                 `json:"signatureId,omitempty"`  // Captured signature to print above the signature line
+	ScanID               string                 `json:"scanId,omitempty"`       // ID scan from /scanner/scan to stamp on the receipt
+	HeaderMessages       []messages.Block       `json:"headerMessages,omitempty"` // Replace the -messages header on this receipt
+	FooterMessages       []messages.Block       `json:"footerMessages,omitempty"` // Replace the -messages footer on this receipt
+	Coupons              []coupons.Coupon       `json:"coupons,omitempty"`        // Replace the -coupons rotation on this receipt
+	LoyaltyPointsEarned   int64                 `json:"loyaltyPointsEarned,omitempty" validate:"nonnegative"`
+	LoyaltyPointsRedeemed int64                 `json:"loyaltyPointsRedeemed,omitempty" validate:"nonnegative"`
+	LoyaltyPointsBalance  int64                 `json:"loyaltyPointsBalance,omitempty"` // Customer's balance after this sale
+	Output               string                 `json:"output,omitempty"`   // pdf, thermal or html (default: -output)
+	Renderer             string                 `json:"renderer,omitempty"` // e.g. chrome for pdf output (default: -renderer)
 	
 	// Derived fields (calculated before template rendering)
 	ShowTaxBreakdown    bool                   `json:"-"`
+	TaxSubtotals        []tax.Subtotal         `json:"-"` // Per tax code, when items carry codes or exemptions
+	VATTable            []tax.Subtotal         `json:"-"` // Net, tax and gross per tax code, for a table -merchant
+	Merchant            merchant.Info          `json:"-"` // Legal block, from -merchant
+	GLCodeTotal         money.Cents            `json:"-"`
+	RetailTotal         money.Cents            `json:"-"` // Purchase part of a combined transaction
+	Deposits            []ReceiptItem          `json:"-"` // Held, or returned on a refund
+	DepositTotal        money.Cents            `json:"-"`
+	DueBack             string                 `json:"-"` // Earliest rental due date, for the banner
+	Header              []messageBlock         `json:"-"`
+	Footer              []messageBlock         `json:"-"` // Replaces the thank-you lines when set
+	CouponBlocks        []couponBlock          `json:"-"` // After the footer, on sales
+	HasLoyalty          bool                   `json:"-"` // Any loyalty points to summarize
+	Scripted            map[string]interface{} `json:"-"` // -scripted-fields values, by name
+	ScriptedLines       []scriptedLine         `json:"-"` // Labelled -scripted-fields, printed with the totals
+	Paper               paper.Size             `json:"-"`
+	IsNoSale            bool                   `json:"-"`
+	IsRefund            bool                   `json:"-"`
+	IsDuplicate         bool                   `json:"-"` // Printed again within -duplicate-window
+	ReceiptNumber       int64                  `json:"-"` // Station's sequence number, with -receipt-numbers
+	RefundTotal         money.Cents            `json:"-"`
+	RefundMethodDisplay string                 `json:"-"`
+	SignatureImage      template.URL           `json:"-"`
+	VerifiedName        string                 `json:"-"` // Masked, from the referenced scan
+	VerifiedLicense     string                 `json:"-"`
 }
 
+// receiptTemplates holds the receipt template parsed once per language;
+// main points it at the templates directory, where receipt.html replaces
+// the built-in one
+var receiptTemplates = newDocumentTemplates("")
+
 // HTML template for the receipt
 const receiptTemplate = `
 <!DOCTYPE html>
-<html>
+<html lang="{{lang}}">
 <head>
     <meta charset="UTF-8">
-    <title>Receipt</title>
+    <title>{{t "receipt"}}</title>
     <style>
+        {{fonts}}
         body {
-            font-family: 'Courier New', monospace;
+            font-family: 'Receipt Mono', 'Courier New', monospace;
             font-size: 12px;
-            width: 80mm;
+            width: {{.Paper.Width}}mm;
+            box-sizing: border-box;
             margin: 0;
             padding: 10px;
         }
@@ -130,6 +416,44 @@ const receiptTemplate = `
             text-align: center;
             margin-top: 20px;
         }
+        .message {
+            text-align: center;
+            margin: 10px 0;
+        }
+        .coupon {
+            text-align: center;
+            border: 1px dashed #000;
+            margin: 10px 0;
+            padding: 6px;
+        }
+        .coupon img {
+            width: 100%;
+            height: 12mm;
+        }
+        .message img {
+            width: 30mm;
+            height: 30mm;
+        }
+        .loyalty {
+            border: 1px solid #000;
+            margin: 10px 0;
+            padding: 5px;
+        }
+        .legal {
+            text-align: center;
+            font-size: 11px;
+        }
+        .vat-table {
+            width: 100%;
+            margin-top: 5px;
+            border-collapse: collapse;
+        }
+        .vat-table td, .vat-table th {
+            text-align: right;
+        }
+        .vat-table td:first-child {
+            text-align: left;
+        }
         .right-align {
             text-align: right;
         }
@@ -139,9 +463,12 @@ const receiptTemplate = `
     </style>
 </head>
 <body>
-    {{if eq .Type "noSale"}}
+    {{if .IsDuplicate}}
+    <div class="header bold" style="font-size: 16px; border: 2px dashed #000; padding: 4px;">*** {{t "duplicate"}} ***</div>
+    {{end}}
+    {{if .IsNoSale}}
     <div class="header bold">
-        <div style="font-size: 16px;">NO SALE</div>
+        <div style="font-size: 16px;">{{t "no_sale"}}</div>
         <div>{{if .Timestamp}}{{.Timestamp}}{{else}}{{now}}{{end}}</div>
         {{if .Location}}
         {{if isString .Location}}
@@ -150,130 +477,327 @@ const receiptTemplate = `
         <div>{{.Location.name}}</div>
         {{end}}
         {{end}}
+        {{if .OperatorName}}<div>{{t "served_by"}}: {{.OperatorName}}</div>{{end}}
+        {{if .StationID}}<div>{{t "station"}}: {{.StationID}}</div>{{end}}
     </div>
-    {{else}}
+    {{else if .IsRefund}}
     <div class="header">
+        <div class="bold" style="font-size: 16px; border: 2px solid #000; padding: 4px;">*** {{t "refund"}} ***</div>
         {{if isString .Location}}
         <div class="bold">{{.Location}}</div>
+        {{else if .Location}}
+        <div class="bold">{{.Location.name}}</div>
+        {{end}}
+        {{if .CustomerName}}<div>{{t "customer"}}: {{.CustomerName}}</div>{{end}}
+        {{if .VerifiedName}}<div>{{t "verified_id"}}: {{.VerifiedName}}</div><div>{{t "license_number"}}: {{.VerifiedLicense}}</div>{{end}}
+        {{if .OperatorName}}<div>{{t "served_by"}}: {{.OperatorName}}</div>{{end}}
+        {{if .StationID}}<div>{{t "station"}}: {{.StationID}}</div>{{end}}
+        <div>{{.Date}}</div>
+    </div>
+
+    {{template "messages" .Header}}
+
+    <div>{{t "refund_id"}}: {{.TransactionID}}</div>
+    {{if .ReceiptNumber}}<div>{{t "receipt_number"}}: {{printf "%06d" .ReceiptNumber}}</div>{{end}}
+    {{if .OriginalTransactionID}}<div>{{t "original_transaction"}}: {{.OriginalTransactionID}}</div>{{end}}
+
+    <div class="bold" style="margin-top: 10px;">{{upper (t "returned_items")}}</div>
+    <div class="divider"></div>
+
+    {{range .Items}}{{if not .IsDeposit}}
+    <div class="item">
+        <div>{{.Name}}</div>
+        <div style="display: flex; justify-content: space-between;">
+            <span>{{.Quantity}} x {{money .Price}}{{with .UnitKey}} / {{t .}}{{end}}</span>
+            <span>-{{money .LineTotal}}</span>
+        </div>
+        {{if .SKU}}<div>{{t "sku"}}: {{.SKU}}</div>{{end}}
+        {{with .TypeKey}}<div>{{t .}}</div>{{end}}
+        {{if .DueDate}}<div>{{if .StartDate}}{{t "out"}}: {{.Out}} {{end}}{{t "due_back"}}: {{.DueBack}}</div>{{end}}
+        {{if .TaxExempt}}<div>({{t "tax_exempt"}})</div>{{else if .TaxCode}}<div>{{t "tax_code"}}: {{.TaxCode}}</div>{{end}}
+    </div>
+    {{end}}{{end}}
+
+    <div class="divider"></div>
+
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "subtotal"}}:</span>
+        <span>-{{money .Subtotal}}</span>
+    </div>
+
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "tax"}}:</span>
+        <span>-{{money .Tax}}</span>
+    </div>
+
+    <div class="total" style="display: flex; justify-content: space-between; margin-top: 10px;">
+        <span>{{t "refund_total"}}:</span>
+        <span>-{{money .RefundTotal}}</span>
+    </div>
+
+    {{if .Deposits}}
+    <div class="divider"></div>
+    <div class="bold">{{upper (t "deposits_returned")}}</div>
+    {{range .Deposits}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{.Name}}</span>
+        <span>-{{money .LineTotal}}</span>
+    </div>
+    {{end}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "total_returned"}}:</span>
+        <span>-{{money .DepositTotal}}</span>
+    </div>
+    {{end}}
+
+    <div class="divider"></div>
+
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "refunded_to"}}:</span>
+        <span>{{.RefundMethodDisplay}}</span>
+    </div>
+    {{if .CardDetails}}
+    {{if index .CardDetails "cardLast4"}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "card"}}:</span>
+        <span>**** {{index .CardDetails "cardLast4"}}</span>
+    </div>
+    {{end}}
+    {{end}}
+
+    {{template "scripted" .ScriptedLines}}
+
+    {{template "loyalty" .}}
+
+    {{template "legal" .}}
+
+    <div class="footer">
+        <div>{{t "refund_processed"}}. {{t "keep_receipt"}}.</div>
+        {{template "messages" .Footer}}
+        {{if .SignatureImage}}
+        <div style="margin-top: 20px;">
+            <img src="{{.SignatureImage}}" alt="" style="max-width: 100%; max-height: 60px;">
+            <div>____________________</div>
+            <div>{{t "customer_signature"}}</div>
+        </div>
         {{else}}
+        <div style="margin-top: 20px;">{{t "customer_signature"}}: ____________________</div>
+        {{end}}
+    </div>
+    {{else}}
+    <div class="header">
+        {{if isString .Location}}
+        <div class="bold">{{.Location}}</div>
+        {{else if .Location}}
         <div class="bold">{{.Location.name}}</div>
         {{end}}
-        {{if .CustomerName}}<div>Customer: {{.CustomerName}}</div>{{end}}
+        {{if .CustomerName}}<div>{{t "customer"}}: {{.CustomerName}}</div>{{end}}
+        {{if .VerifiedName}}<div>{{t "verified_id"}}: {{.VerifiedName}}</div><div>{{t "license_number"}}: {{.VerifiedLicense}}</div>{{end}}
+        {{if .OperatorName}}<div>{{t "served_by"}}: {{.OperatorName}}</div>{{end}}
+        {{if .StationID}}<div>{{t "station"}}: {{.StationID}}</div>{{end}}
         <div>{{.Date}}</div>
     </div>
     
-    <div>Transaction ID: {{.TransactionID}}</div>
-    <div>Payment: {{title .PaymentType}}</div>
+    {{template "messages" .Header}}
+    
+    <div>{{t "transaction_id"}}: {{.TransactionID}}</div>
+    {{if .ReceiptNumber}}<div>{{t "receipt_number"}}: {{printf "%06d" .ReceiptNumber}}</div>{{end}}
+    <div>{{t "payment"}}: {{title .PaymentType}}</div>
+    
+    {{if .DueBack}}
+    <div class="bold" style="font-size: 16px; border: 2px solid #000; padding: 4px; margin-top: 10px; text-align: center;">{{t "due_back_banner" .DueBack}}</div>
+    {{end}}
     
-    <div class="bold" style="margin-top: 10px;">ITEMS</div>
+    <div class="bold" style="margin-top: 10px;">{{if .HasCombinedTransaction}}{{upper (t "retail_purchase")}}{{else}}{{upper (t "items")}}{{end}}</div>
     <div class="divider"></div>
     
-    {{range .Items}}
+    {{range .Items}}{{if not .IsDeposit}}
     <div class="item">
         <div>{{.Name}}</div>
         <div style="display: flex; justify-content: space-between;">
-            <span>{{.Quantity}} x ${{printf "%.2f" .Price}}</span>
-            <span>${{printf "%.2f" (multiply .Quantity .Price)}}</span>
+            <span>{{.Quantity}} x {{money .Price}}{{with .UnitKey}} / {{t .}}{{end}}</span>
+            <span>{{money .LineTotal}}</span>
         </div>
-        {{if .SKU}}<div>SKU: {{.SKU}}</div>{{end}}
+        {{if .SKU}}<div>{{t "sku"}}: {{.SKU}}</div>{{end}}
+        {{with .TypeKey}}<div>{{t .}}</div>{{end}}
+        {{if .DueDate}}<div>{{if .StartDate}}{{t "out"}}: {{.Out}} {{end}}{{t "due_back"}}: {{.DueBack}}</div>{{end}}
+        {{if .TaxExempt}}<div>({{t "tax_exempt"}})</div>{{else if .TaxCode}}<div>{{t "tax_code"}}: {{.TaxCode}}</div>{{end}}
     </div>
-    {{end}}
+    {{end}}{{end}}
     
     <div class="divider"></div>
     
     <div style="display: flex; justify-content: space-between;">
-        <span>Subtotal:</span>
-        <span>${{printf "%.2f" .Subtotal}}</span>
+        <span>{{t "subtotal"}}:</span>
+        <span>{{money .Subtotal}}</span>
     </div>
     
-    {{if and (gt .DiscountPercentage 0) (gt .DiscountAmount 0)}}
+    {{if and (gt .DiscountPercentage 0.0) (gt .DiscountAmount 0)}}
     <div style="display: flex; justify-content: space-between;">
-        <span>Discount ({{printf "%.0f" .DiscountPercentage}}%):</span>
-        <span>-${{printf "%.2f" .DiscountAmount}}</span>
+        <span>{{t "discount"}} ({{printf "%.0f" .DiscountPercentage}}%):</span>
+        <span>-{{money .DiscountAmount}}</span>
     </div>
     {{end}}
     
     {{if gt .PromoAmount 0}}
     <div style="display: flex; justify-content: space-between;">
-        <span>Promo Discount:</span>
-        <span>-${{printf "%.2f" .PromoAmount}}</span>
+        <span>{{t "promo_discount"}}:</span>
+        <span>-{{money .PromoAmount}}</span>
     </div>
     {{end}}
 
     <div style="display: flex; justify-content: space-between;">
-        <span>Tax:</span>
-        <span>${{printf "%.2f" .Tax}}</span>
+        <span>{{t "tax"}}:</span>
+        <span>{{money .Tax}}</span>
     </div>
     
     <!-- Tax Breakdown - Only show for non-settlement transactions -->
     {{if .ShowTaxBreakdown}}
     <div style="margin-left: 10px;">
+        {{range .TaxBreakdown}}
         <div style="display: flex; justify-content: space-between;">
-            <span>GST (5%):</span>
-            <span>${{printf "%.2f" (multiply .Subtotal 0.05)}}</span>
+            <span>{{.Label}}:</span>
+            <span>{{money .Amount}}</span>
         </div>
+        {{end}}
+    </div>
+    {{end}}
+    {{if .TaxSubtotals}}
+    <div style="margin-left: 10px; font-size: 11px;">
+        {{range .TaxSubtotals}}
         <div style="display: flex; justify-content: space-between;">
-            <span>PST (7%):</span>
-            <span>${{printf "%.2f" (multiply .Subtotal 0.07)}}</span>
+            {{if .Exempt}}
+            <span>{{t "tax_exempt"}}{{if .Code}} ({{.Code}}){{end}}:</span>
+            <span>{{money .Amount}}</span>
+            {{else}}
+            <span>{{t "taxable"}} {{.Label}}:</span>
+            <span>{{money .Amount}} ({{t "tax"}} {{money .Tax}})</span>
+            {{end}}
         </div>
+        {{end}}
     </div>
     {{end}}
 
     {{if gt .Tip 0}}
     <div style="display: flex; justify-content: space-between;">
-        <span>Tip:</span>
-        <span>${{printf "%.2f" .Tip}}</span>
+        <span>{{t "tip"}}:</span>
+        <span>{{money .Tip}}</span>
     </div>
     {{end}}
 
-    {{if gt .SettlementAmount 0}}
+    {{if .HasCombinedTransaction}}
+    <div class="bold" style="display: flex; justify-content: space-between;">
+        <span>{{t "retail_subtotal"}}:</span>
+        <span>{{money .RetailTotal}}</span>
+    </div>
+    
+    <!-- The settlement is kept apart from the purchase, as on the POS -->
+    <div class="bold" style="margin-top: 10px;">{{upper (t "account_settlement")}}</div>
+    <div class="divider"></div>
+    {{if .AccountId}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "account_id"}}:</span>
+        <span>{{.AccountId}}</span>
+    </div>
+    {{end}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "payment_on_account"}}:</span>
+        <span>{{money .SettlementAmount}}</span>
+    </div>
+    <div class="divider"></div>
+    <div class="bold" style="display: flex; justify-content: space-between;">
+        <span>{{t "settlement_subtotal"}}:</span>
+        <span>{{money .SettlementAmount}}</span>
+    </div>
+    {{else if gt .SettlementAmount 0}}
     <div style="display: flex; justify-content: space-between;">
-        <span>Account Settlement:</span>
-        <span>${{printf "%.2f" .SettlementAmount}}</span>
+        <span>{{t "account_settlement"}}:</span>
+        <span>{{money .SettlementAmount}}</span>
     </div>
     {{end}}
     
     <div class="total" style="display: flex; justify-content: space-between; margin-top: 10px;">
-        <span>TOTAL:</span>
-        <span>${{printf "%.2f" .Total}}</span>
+        <span>{{t "total"}}:</span>
+        <span>{{money .Total}}</span>
+    </div>
+
+    {{if .Deposits}}
+    <div class="divider"></div>
+    <div class="bold">{{upper (t "held")}}</div>
+    {{range .Deposits}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{.Name}}</span>
+        <span>{{money .LineTotal}}</span>
+    </div>
+    {{end}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{t "total_held"}}:</span>
+        <span>{{money .DepositTotal}}</span>
     </div>
+    {{end}}
     
     {{if and (eq .PaymentType "cash") (gt .CashGiven 0)}}
     <div style="display: flex; justify-content: space-between;">
-        <span>Cash:</span>
-        <span>${{printf "%.2f" .CashGiven}}</span>
+        <span>{{t "cash"}}:</span>
+        <span>{{money .CashGiven}}</span>
     </div>
     <div style="display: flex; justify-content: space-between;">
-        <span>Change:</span>
-        <span>${{printf "%.2f" .ChangeDue}}</span>
+        <span>{{t "change"}}:</span>
+        <span>{{money .ChangeDue}}</span>
     </div>
     {{end}}
     
     <div class="divider"></div>
     
     <div style="margin-top: 10px;">
-        <div style="font-weight: bold;">Payment Details</div>
+        <div style="font-weight: bold;">{{t "payment_details"}}</div>
         
+        {{if .Payments}}
+        {{range .Payments}}
+        <div style="display: flex; justify-content: space-between;">
+            <span>{{title .Type}}:</span>
+            <span>{{money .Amount}}</span>
+        </div>
+        {{with .Card}}
+        <div style="display: flex; justify-content: space-between; padding-left: 10px;">
+            <span>{{t "card"}}:</span>
+            <span>{{.}}</span>
+        </div>
+        {{end}}
+        {{with .AuthCode}}
+        <div style="display: flex; justify-content: space-between; padding-left: 10px;">
+            <span>{{t "auth_code"}}:</span>
+            <span>{{.}}</span>
+        </div>
+        {{end}}
+        {{end}}
+        {{if .TerminalId}}
         <div style="display: flex; justify-content: space-between;">
-            <span>Payment Method:</span>
+            <span>{{t "terminal_id"}}:</span>
+            <span>{{.TerminalId}}</span>
+        </div>
+        {{end}}
+        {{else}}
+        <div style="display: flex; justify-content: space-between;">
+            <span>{{t "payment_method"}}:</span>
             <span>{{title .PaymentType}}</span>
         </div>
         
           {{if or (contains .PaymentType "credit") (contains .PaymentType "debit")}}
 
             <div style="display: flex; justify-content: space-between;">
-              <span>Card:</span>
+              <span>{{t "card"}}:</span>
               <span style="font-weight: medium;">
                 {{if index .CardDetails "cardBrand"}}
                   {{with index .CardDetails "cardBrand"}}
                     {{if isString .}}
                       {{title .}}
                     {{else}}
-                      Card
+                      {{t "card"}}
                     {{end}}
                   {{end}}
                 {{else}}
-                  Card
+                  {{t "card"}}
                 {{end}}
                 {{if index .CardDetails "cardLast4"}}
                   {{with index .CardDetails "cardLast4"}}
@@ -287,7 +811,7 @@ const receiptTemplate = `
 
             {{if index .CardDetails "authCode"}}
             <div style="display: flex; justify-content: space-between;">
-              <span>Auth Code:</span>
+              <span>{{t "auth_code"}}:</span>
               <span>
                 {{index .CardDetails "authCode"}}
               </span>
@@ -296,7 +820,7 @@ const receiptTemplate = `
 
             {{if .TerminalId}}
             <div style="display: flex; justify-content: space-between;">
-              <span>Terminal ID:</span>
+              <span>{{t "terminal_id"}}:</span>
               <span>
                 {{.TerminalId}}
               </span>
@@ -304,54 +828,202 @@ const receiptTemplate = `
             {{end}}
 
           {{end}}
+        {{end}}
     </div>
     
     {{if .AccountId}}
     <div style="margin-top: 10px;">
-        <div style="font-weight: bold;">Account Information</div>
+        <div style="font-weight: bold;">{{t "account_information"}}</div>
         
         <div style="display: flex; justify-content: space-between;">
-            <span>Account ID:</span>
+            <span>{{t "account_id"}}:</span>
             <span>{{.AccountId}}</span>
         </div>
         
         {{if or .IsSettlement .HasCombinedTransaction}}
         <div style="display: flex; justify-content: space-between;">
-            <span>Previous Balance:</span>
-            <span>${{printf "%.2f" .AccountBalanceBefore}}</span>
+            <span>{{t "previous_balance"}}:</span>
+            <span>{{money .AccountBalanceBefore}}</span>
         </div>
         
         <div style="display: flex; justify-content: space-between;">
-            <span>New Balance:</span>
-            <span>${{printf "%.2f" .AccountBalanceAfter}}</span>
+            <span>{{t "new_balance"}}:</span>
+            <span>{{money .AccountBalanceAfter}}</span>
+        </div>
+        {{end}}
+    </div>
+    {{end}}
+    
+    {{if .GLCodeSummary}}
+    <div class="gl-summary" style="margin-top: 10px;">
+        <div style="font-weight: bold;">{{t "gl_summary"}}</div>
+        {{range .GLCodeSummary}}
+        <div style="display: flex; justify-content: space-between;">
+            <span>{{.Code}}{{with .Description}} {{.}}{{end}}</span>
+            <span>{{money .Amount}}</span>
         </div>
         {{end}}
+        <div style="display: flex; justify-content: space-between; font-weight: bold; border-top: 1px dashed #000;">
+            <span>{{t "subtotal"}}:</span>
+            <span>{{money .GLCodeTotal}}</span>
+        </div>
     </div>
     {{end}}
     
+    {{if .SignatureImage}}
+    <div style="margin-top: 15px; text-align: center;">
+        <img src="{{.SignatureImage}}" alt="" style="max-width: 100%; max-height: 60px;">
+        <div>____________________</div>
+        <div>{{t "customer_signature"}}</div>
+    </div>
+    {{end}}
+    
+    {{template "scripted" .ScriptedLines}}
+
+    {{template "loyalty" .}}
+
+    {{template "legal" .}}
+    
     <div class="footer">
-        <div>Thank you for your purchase!</div>
-        {{if isString .Location}}
-        <div>Visit us again at {{.Location}}</div>
+        {{if .Footer}}
+        {{template "messages" .Footer}}
         {{else}}
-        <div>Visit us again at {{.Location.name}}</div>
+        <div>{{t "thank_you"}}</div>
+        {{if isString .Location}}
+        <div>{{t "visit_again" .Location}}</div>
+        {{else if .Location}}
+        <div>{{t "visit_again" .Location.name}}</div>
+        {{end}}
         {{end}}
     </div>
+    {{template "coupons" .CouponBlocks}}
     {{end}}
 </body>
 </html>
+{{define "messages"}}{{range .}}
+    <div class="message">
+        {{with .Title}}<div class="bold">{{.}}</div>{{end}}
+        {{range .Lines}}<div>{{.}}</div>{{end}}
+        {{with .QRImage}}<img src="{{.}}" alt="">{{end}}
+    </div>
+{{end}}{{end}}
+{{define "coupons"}}{{range .}}
+    <div class="coupon">
+        {{with .Title}}<div class="bold">{{.}}</div>{{end}}
+        {{range .Lines}}<div>{{.}}</div>{{end}}
+        {{with .BarcodeImage}}<img src="{{.}}" alt="">{{end}}
+        {{with .Barcode}}<div>{{.}}</div>{{end}}
+        {{with .Expiry}}<div>{{t "coupon_expires" .}}</div>{{end}}
+    </div>
+{{end}}{{end}}
+{{define "scripted"}}{{range .}}
+    <div style="display: flex; justify-content: space-between;">
+        <span>{{.Label}}:</span>
+        <span>{{.Value}}</span>
+    </div>
+{{end}}{{end}}
+{{define "loyalty"}}{{if .HasLoyalty}}
+    <div class="loyalty">
+        <div class="bold">{{t "loyalty_points"}}</div>
+        {{if .LoyaltyPointsEarned}}
+        <div style="display: flex; justify-content: space-between;">
+            <span>{{t "points_earned"}}:</span>
+            <span>{{.LoyaltyPointsEarned}}</span>
+        </div>
+        {{end}}
+        {{if .LoyaltyPointsRedeemed}}
+        <div style="display: flex; justify-content: space-between;">
+            <span>{{t "points_redeemed"}}:</span>
+            <span>-{{.LoyaltyPointsRedeemed}}</span>
+        </div>
+        {{end}}
+        <div style="display: flex; justify-content: space-between;" class="bold">
+            <span>{{t "points_balance"}}:</span>
+            <span>{{.LoyaltyPointsBalance}}</span>
+        </div>
+    </div>
+{{end}}{{end}}
+{{define "legal"}}{{if or (not .Merchant.Empty) .VATTable}}
+    <div class="divider"></div>
+    <div class="legal">
+        {{with .Merchant.LegalName}}<div class="bold">{{.}}</div>{{end}}
+        {{range .Merchant.Address}}<div>{{.}}</div>{{end}}
+        {{range .Merchant.Registrations}}<div>{{.Label}}: {{.Number}}</div>{{end}}
+        {{if .VATTable}}
+        <table class="vat-table">
+            <tr><th></th><th>{{t "net"}}</th><th>{{t "tax"}}</th><th>{{t "gross"}}</th></tr>
+            {{range .VATTable}}
+            <tr>
+                <td>{{if .Exempt}}{{t "tax_exempt"}}{{if .Code}} ({{.Code}}){{end}}{{else}}{{.Label}}{{end}}</td>
+                <td>{{if $.IsRefund}}-{{end}}{{money .Amount}}</td>
+                <td>{{if $.IsRefund}}-{{end}}{{money .Tax}}</td>
+                <td>{{if $.IsRefund}}-{{end}}{{money .Gross}}</td>
+            </tr>
+            {{end}}
+        </table>
+        {{end}}
+    </div>
+{{end}}{{end}}
 `
 
-// ensureAppDirectory creates and returns the application's dedicated directory
-func ensureAppDirectory() (string, error) {
-    var appDir string
+// Tax rates used for receipt tax breakdowns (BC GST/PST unless -tax-config is given)
+var taxConfig = tax.DefaultConfig()
+
+// Custom header and footer blocks printed on every receipt (-messages)
+var receiptMessages messages.Config
+
+// Merchant's legal name, address and tax registrations, printed in a
+// legal block on sale and refund receipts (-merchant)
+var merchantInfo merchant.Info
+
+// Paper in the receipt printer, which receipts are laid out for (-paper)
+var receiptPaper = paper.Default
+
+// portFileName records the HTTP port in the app directory, so clients can
+// find an agent that fell back to another port
+const portFileName = "agent-port.json"
+
+// Hardware and process seams, replaced by the end-to-end tests
+var (
+	serialTransport transport.Transport = transport.Serial(serial.Open)
+	listSerialPorts                     = serial.GetPortsList
+	runCommand                          = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, name, args...)
+		inProcessGroup(cmd)
+		return cmd.CombinedOutput()
+	}
+	lookPath = exec.LookPath
+)
+
+// Application directory override (-app-dir); empty uses the platform default
+var appDirOverride string
+
+// Compressed archive of rendered receipts (nil when it couldn't be opened)
+var receiptArchive *archive.Archive
+
+// Language for receipt strings when the request doesn't name one
+var receiptLanguage = i18n.DefaultLanguage
+
+// Currency format used for receipt amounts (en-CA unless -locale or -currency-config is given)
+var currencyFormat = money.DefaultFormat()
+
+// appDirPath returns the application's dedicated directory without
+// creating it
+func appDirPath() string {
+    if appDirOverride != "" {
+        return appDirOverride
+    }
     if runtime.GOOS == "windows" {
         // On Windows, ensure we have a backslash after the drive letter
-        appDir = "C:\\GoScanRentalTide-main"
-    } else {
-        // On other systems, use standard path joining
-        appDir = filepath.Join("/", "opt", "GoScanRentalTide-main")
+        return "C:\\GoScanRentalTide-main"
     }
+    // On other systems, use standard path joining
+    return filepath.Join("/", "opt", "GoScanRentalTide-main")
+}
+
+// ensureAppDirectory creates and returns the application's dedicated directory
+func ensureAppDirectory() (string, error) {
+    appDir := appDirPath()
     
     // Create directories if they don't exist
     if err := os.MkdirAll(appDir, 0755); err != nil {
@@ -417,71 +1089,15 @@ func toFloat64(v interface{}) float64 {
         if err == nil {
             return f
         }
+    case money.Cents:
+        return val.Float64()
     }
     return 0
 }
 
-// Template functions
-var templateFuncs = template.FuncMap{
-    "multiply": func(a interface{}, b interface{}) float64 {
-        // Convert operands to float64 regardless of their original type
-        var aFloat, bFloat float64
-        
-        switch v := a.(type) {
-        case int:
-            aFloat = float64(v)
-        case float64:
-            aFloat = v
-        default:
-            aFloat = 0
-        }
-        
-        switch v := b.(type) {
-        case int:
-            bFloat = float64(v)
-        case float64:
-            bFloat = v
-        default:
-            bFloat = 0
-        }
-        
-        return aFloat * bFloat
-    },
-    "title": strings.Title,
-    "now": func() string {
-        return time.Now().Format("2006-01-02 15:04:05")
-    },
-    "isString": func(v interface{}) bool {
-        _, ok := v.(string)
-        return ok
-    },
-    "contains": strings.Contains,
-    "gt": func(a, b interface{}) bool {
-        aFloat := toFloat64(a)
-        bFloat := toFloat64(b)
-        return aFloat > bFloat
-    },
-    "lt": func(a, b interface{}) bool {
-        aFloat := toFloat64(a)
-        bFloat := toFloat64(b)
-        return aFloat < bFloat
-    },
-    "eq": func(a, b interface{}) bool {
-        aFloat := toFloat64(a)
-        bFloat := toFloat64(b)
-        return aFloat == bFloat
-    },
-    "and": func(a, b bool) bool {
-        return a && b
-    },
-    "or": func(a, b bool) bool {
-        return a || b
-    },
-}
-
 func parseBCLicenseData(raw string) LicenseData {
 	fmt.Println("Parsing BC license data from raw input:")
-	fmt.Println(raw)
+	fmt.Println(redact(raw))
 
 	license := LicenseData{
 		RawData:      raw,
@@ -536,10 +1152,12 @@ func parseBCLicenseData(raw string) LicenseData {
 		}
 	}
 
-	// License number: extract last 7 digits after semicolon
+	// License number: extract last 7 digits after semicolon; the whole
+	// track 2 number is kept for normalizeLicense
 	licenseNumMatch := regexp.MustCompile(`;(\d{13,16})=`).FindStringSubmatch(raw)
 	if len(licenseNumMatch) > 1 {
 		full := licenseNumMatch[1]
+		license.LicenseNumberRaw = full
 		if len(full) >= 7 {
 			license.LicenseNumber = full[len(full)-7:]
 		}
@@ -590,7 +1208,7 @@ func parseBCLicenseData(raw string) LicenseData {
 // Original AAMVA format parser for other jurisdictions
 func parseAAMVALicenseData(raw string) LicenseData {
 	fmt.Println("Parsing AAMVA license data from raw input:")
-	fmt.Println(raw)
+	fmt.Println(redact(raw))
 	
 	// Remove any NAK (0x15) character at the beginning
 	raw = strings.TrimPrefix(raw, "\x15")
@@ -601,41 +1219,53 @@ func parseAAMVALicenseData(raw string) LicenseData {
 		trimmed := strings.TrimSpace(line)
 		if trimmed != "" {
 			parsedLines = append(parsedLines, trimmed)
-			fmt.Println("Parsed line:", trimmed)
+			fmt.Println("Parsed line:", redact(trimmed))
 		}
 	}
 
 	data := make(map[string]string)
 	var licenseClass string
+	var truncated []string
 
 	for _, line := range parsedLines {
 		switch {
 		case strings.HasPrefix(line, "DCS"):
 			data["lastName"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found lastName:", data["lastName"])
+			fmt.Println("Found lastName:", redact(data["lastName"]))
 		case strings.HasPrefix(line, "DAC"):
 			data["firstName"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found firstName:", data["firstName"])
+			fmt.Println("Found firstName:", redact(data["firstName"]))
 		case strings.HasPrefix(line, "DAD"):
 			data["middleName"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found middleName:", data["middleName"])
+			fmt.Println("Found middleName:", redact(data["middleName"]))
+		case strings.HasPrefix(line, "DCU"):
+			data["suffix"] = strings.TrimSpace(line[3:])
+			fmt.Println("Found suffix:", redact(data["suffix"]))
+		case strings.HasPrefix(line, "DAA"):
+			// Version 1 cards have the whole name in one element
+			data["name"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DDE"), strings.HasPrefix(line, "DDF"), strings.HasPrefix(line, "DDG"):
+			// T: truncated, N: not truncated, U: unknown
+			if strings.TrimSpace(line[3:]) == "T" {
+				truncated = append(truncated, truncationElements[line[:3]])
+			}
 		case strings.HasPrefix(line, "DBA"):
 			d := strings.TrimSpace(line[3:])
 			if len(d) >= 8 {
 				data["expiryDate"] = fmt.Sprintf("%s/%s/%s", d[0:4], d[4:6], d[6:8])
-				fmt.Println("Found expiryDate:", data["expiryDate"])
+				fmt.Println("Found expiryDate:", redact(data["expiryDate"]))
 			}
 		case strings.HasPrefix(line, "DBD"):
 			d := strings.TrimSpace(line[3:])
 			if len(d) >= 8 {
 				data["issueDate"] = fmt.Sprintf("%s/%s/%s", d[0:4], d[4:6], d[6:8])
-				fmt.Println("Found issueDate:", data["issueDate"])
+				fmt.Println("Found issueDate:", redact(data["issueDate"]))
 			}
 		case strings.HasPrefix(line, "DBB"):
 			d := strings.TrimSpace(line[3:])
 			if len(d) >= 8 {
 				data["dob"] = fmt.Sprintf("%s/%s/%s", d[0:4], d[4:6], d[6:8])
-				fmt.Println("Found dob:", data["dob"])
+				fmt.Println("Found dob:", redact(data["dob"]))
 			}
 		case strings.HasPrefix(line, "DBC"):
 			s := strings.TrimSpace(line[3:])
@@ -646,36 +1276,54 @@ func parseAAMVALicenseData(raw string) LicenseData {
 			} else {
 				data["sex"] = s
 			}
-			fmt.Println("Found sex:", data["sex"])
+			fmt.Println("Found sex:", redact(data["sex"]))
 		case strings.HasPrefix(line, "DAU"):
 			data["height"] = strings.ReplaceAll(strings.TrimSpace(line[3:]), " ", "")
-			fmt.Println("Found height:", data["height"])
+			fmt.Println("Found height:", redact(data["height"]))
+		case strings.HasPrefix(line, "DAW"):
+			data["weightLb"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DAX"):
+			data["weightKg"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DAY"):
+			data["eyeColor"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DCA"):
+			licenseClass = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DCD"):
+			data["endorsements"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DCB"):
+			data["restrictions"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DDK"):
+			data["organDonor"] = strings.TrimSpace(line[3:])
+		case strings.HasPrefix(line, "DDL"):
+			data["veteran"] = strings.TrimSpace(line[3:])
 		case strings.HasPrefix(line, "DAG"):
 			data["address"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found address:", data["address"])
+			fmt.Println("Found address:", redact(data["address"]))
 		case strings.HasPrefix(line, "DAI"):
 			data["city"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found city:", data["city"])
+			fmt.Println("Found city:", redact(data["city"]))
 		case strings.HasPrefix(line, "DAJ"):
 			data["state"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found state:", data["state"])
+			fmt.Println("Found state:", redact(data["state"]))
 		case strings.HasPrefix(line, "DAK"):
 			data["postal"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found postal:", data["postal"])
+			fmt.Println("Found postal:", redact(data["postal"]))
 		case strings.HasPrefix(line, "DCF"):
 			data["licenseNumber"] = strings.TrimSpace(line[3:])
-			fmt.Println("Found licenseNumber (DCF):", data["licenseNumber"])
+			fmt.Println("Found licenseNumber (DCF):", redactLicense(data["licenseNumber"]))
 		
 		case strings.HasPrefix(line, "DAQ"):
 			if _, exists := data["licenseNumber"]; !exists {
 				data["licenseNumber"] = strings.TrimSpace(line[3:])
-				fmt.Println("Found licenseNumber (DAQ fallback):", data["licenseNumber"])
+				fmt.Println("Found licenseNumber (DAQ fallback):", redactLicense(data["licenseNumber"]))
 			}
 		
 		}
 
+		// Some scanners send the class as DCAG<class>; Ontario's G2 and
+		// G1 (DCAG2) are read from DCA as they are
 		if strings.Contains(line, "DCAG") {
-			re := regexp.MustCompile(`DCAG(\w+)`)
+			re := regexp.MustCompile(`DCAG([A-Z]+)$`)
 			matches := re.FindStringSubmatch(line)
 			if len(matches) > 1 {
 				licenseClass = matches[1]
@@ -687,11 +1335,19 @@ func parseAAMVALicenseData(raw string) LicenseData {
 	if licenseClass == "" {
 		licenseClass = "NA"
 	}
+	if data["lastName"] == "" && data["firstName"] == "" && data["name"] != "" {
+		parseAAMVAFullName(data["name"], data)
+	}
+
+	weightLb, _ := strconv.Atoi(data["weightLb"])
+	weightKg, _ := strconv.Atoi(data["weightKg"])
 
 	return LicenseData{
 		FirstName:     data["firstName"],
 		MiddleName:    data["middleName"],
 		LastName:      data["lastName"],
+		Suffix:        data["suffix"],
+		Truncated:     truncated,
 		Address:       data["address"],
 		City:          data["city"],
 		State:         data["state"],
@@ -700,42 +1356,58 @@ func parseAAMVALicenseData(raw string) LicenseData {
 		IssueDate:     data["issueDate"],
 		ExpiryDate:    data["expiryDate"],
 		Height:        data["height"],
+		WeightLb:      weightLb,
+		WeightKg:      weightKg,
+		EyeColor:      data["eyeColor"],
 		Sex:           data["sex"],
 		LicenseClass:  licenseClass,
+		Endorsements:  licenseCodes(data["endorsements"]),
+		Restrictions:  licenseCodes(data["restrictions"]),
+		OrganDonor:    data["organDonor"] == "1",
+		Veteran:       data["veteran"] == "1",
 		Dob:           data["dob"],
 		RawData:       raw,
 	}
 }
 
+// AAMVA issuer numbers on license track 2 start with 636 (US and
+// Canadian jurisdictions) or 604
+var licenseTrack2Pattern = regexp.MustCompile(`;(636|604)\d{3}`)
+
+// isLicenseFormat reports whether a swipe or barcode is a driver's license
+// (or provincial ID) rather than some other magstripe card
+func isLicenseFormat(raw string) bool {
+	cleanRaw := strings.TrimPrefix(raw, "\x15")
+	return strings.Contains(cleanRaw, "%BC") ||
+		strings.Contains(cleanRaw, "%AB") ||
+		strings.Contains(cleanRaw, "ANSI ") ||
+		strings.Contains(cleanRaw, "DCS") ||
+		strings.Contains(cleanRaw, "DAQ") ||
+		licenseTrack2Pattern.MatchString(cleanRaw)
+}
+
 // Main parser that determines which format to use
 func parseLicenseData(raw string) LicenseData {
-	// Remove any NAK (0x15) character from the beginning for format detection
-	cleanRaw := strings.TrimPrefix(raw, "\x15")
-	
-	// Determine the format of the license data
-	if strings.Contains(cleanRaw, "%BC") {
-		// This is a BC driver's license format
-		return parseBCLicenseData(raw)
-	} else if strings.Contains(cleanRaw, "%AB") {
-		// This is an Alberta driver's license (also uses BC format parser)
-		return parseBCLicenseData(raw)
-	} else if strings.Contains(cleanRaw, "ANSI ") {
-		// This is an AAMVA format license
-		return parseAAMVALicenseData(raw)
-	} else if strings.Contains(cleanRaw, "DCS") || strings.Contains(cleanRaw, "DAQ") {
-		// This is likely an AAMVA format license
-		return parseAAMVALicenseData(raw)
-	} else {
+	var license LicenseData
+	switch licenseFormat(raw) {
+	case formatBC, formatAlberta:
+		// BC and Alberta driver's licenses share the BC magstripe layout
+		license = parseBCLicenseData(raw)
+	case formatAAMVA, formatAAMVABare:
+		license = parseAAMVALicenseData(raw)
+	default:
 		// Try BC format by default
-		license := parseBCLicenseData(raw)
+		license = parseBCLicenseData(raw)
 		
 		// If we couldn't extract basic info, try AAMVA as a fallback
 		if license.FirstName == "" && license.LastName == "" && license.LicenseNumber == "" {
-			return parseAAMVALicenseData(raw)
+			license = parseAAMVALicenseData(raw)
 		}
-		
-		return license
 	}
+	normalizeLicense(&license, raw)
+	describeLicense(&license)
+	nameLicense(&license)
+	return license
 }
 
 func findScannerPort(portOverride string) (string, error) {
@@ -745,7 +1417,7 @@ func findScannerPort(portOverride string) (string, error) {
 		return portOverride, nil
 	}
 
-	ports, err := serial.GetPortsList()
+	ports, err := listSerialPorts()
 	if err != nil {
 		return "", err
 	}
@@ -763,6 +1435,11 @@ func findScannerPort(portOverride string) (string, error) {
 		}
 	}
 	
+	// Under -simulate the only port is the simulated scanner
+	if len(ports) == 1 && ports[0] == simulate.PortName {
+		return ports[0], nil
+	}
+	
 	// If COM4 not found, fall back to first COM port
 	for _, port := range ports {
 		fmt.Println("Checking port:", port)
@@ -774,33 +1451,32 @@ func findScannerPort(portOverride string) (string, error) {
 			return port, nil
 		}
 	}
+	// Then a scanner paired over Bluetooth
+	if port, ok := bluetoothPortFor(ports); ok {
+		fmt.Println("Using Bluetooth scanner port:", port)
+		return port, nil
+	}
 	return "", errors.New("no compatible port found")
 }
 
-func readWithTimeout(port serial.Port, buf []byte, timeout time.Duration) (int, error) {
-	type readResult struct {
-		n   int
-		err error
-	}
-	ch := make(chan readResult, 1)
-	go func() {
-		n, err := port.Read(buf)
-		ch <- readResult{n, err}
-	}()
-	select {
-	case res := <-ch:
-		return res.n, res.err
-	case <-time.After(timeout):
-		return 0, errors.New("read timeout")
+// readWithTimeout reads what the port has within timeout, failing with
+// transport.ErrTimeout when nothing arrives. The timeout is the port's own
+// read deadline, so a read that times out leaves nothing running behind
+// it to swallow the next bytes.
+func readWithTimeout(port transport.Conn, buf []byte, timeout time.Duration) (int, error) {
+	if err := port.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
 	}
+	return port.Read(buf)
 }
 
-func sendScannerCommand(commandStr string, portOverride string, useMacSettings bool, readTimeout time.Duration) (string, error) {
-	portName, err := findScannerPort(portOverride)
-	if err != nil {
-		return "", err
-	}
+// Largest response read from the scanner; barcodes with a portrait are a
+// few tens of kilobytes
+const maxScanBytes = 256 << 10
 
+// scannerMode returns the serial settings of the scanner: 9600 baud and 8
+// data bits with -mac, otherwise 1200 baud and 7 data bits
+func scannerMode(useMacSettings bool) *serial.Mode {
 	var mode *serial.Mode
 	if useMacSettings {
 		// Use settings from the Mac version
@@ -821,46 +1497,86 @@ func sendScannerCommand(commandStr string, portOverride string, useMacSettings b
 		}
 		fmt.Println("Using Windows settings: BaudRate=1200, DataBits=7")
 	}
+	return mode
+}
+
+// sendScannerCommand asks the scanner for a scan, sending it command (a
+// framed trigger, or nothing for scanners that send swipes unprompted),
+// and collects the reply. It waits up to readTimeout for the customer to swipe; once data arrives
+// the reply ends after idleTimeout without further data. A reply that
+// stops mid-record, inside a track or short of the length its AAMVA
+// header gives, is waited on until readTimeout has passed, and a barcode
+// ends as soon as its last subfile is in. Cancelling ctx, as a client
+// that hangs up does, closes the port and ends the scan at once.
+func sendScannerCommand(ctx context.Context, command []byte, portOverride string, useMacSettings bool, readTimeout, idleTimeout time.Duration, link bluetoothLink) (string, error) {
+	portName, err := findScannerPort(portOverride)
+	if err != nil {
+		return "", err
+	}
+
+	mode := scannerMode(useMacSettings)
 	
-	fmt.Printf("Opening port %s with settings: BaudRate=%d, DataBits=%d\n", 
-		portName, mode.BaudRate, mode.DataBits)
-	
-	port, err := serial.Open(portName, mode)
+	logf(ctx, "Opening port %s with settings: BaudRate=%d, DataBits=%d", 
+		portName, mode.BaudRate, mode.DataBits)
+	
+	port, err := openScannerPort(portName, mode, link)
 	if err != nil {
 		return "", fmt.Errorf("open port %s failed: %w", portName, err)
 	}
 	defer port.Close()
-
-	cmd := append([]byte{0x01}, append([]byte(commandStr), 0x04)...)
-	fmt.Printf("Sending raw bytes (hex): %s\n", hex.EncodeToString(cmd))
-	fmt.Printf("Sending raw bytes (human-readable): %q\n", string(cmd))
-	
-	if _, err := port.Write(cmd); err != nil {
-		return "", err
+	// Closing the port wakes the read in progress
+	stop := context.AfterFunc(ctx, func() { port.Close() })
+	defer stop()
+
+	if len(command) == 0 {
+		// A passive reader sends swipes whenever they happen; one left
+		// over from before this request isn't this customer's
+		port.ResetInputBuffer()
+		logf(ctx, "Passive scanner: waiting for a swipe without sending a command")
+	} else {
+		logf(ctx, "Sending raw bytes (hex): %s", hex.EncodeToString(command))
+		logf(ctx, "Sending raw bytes (human-readable): %q", string(command))
+		if _, err := port.Write(command); err != nil {
+			return "", err
+		}
 	}
 
 	var responseBuffer bytes.Buffer
-	maxWaitTime := 3 * time.Second  // Maximum overall wait time
-	deadline := time.Now().Add(maxWaitTime)
+	deadline := time.Now().Add(readTimeout)
 	tmp := make([]byte, 128)
 
-	fmt.Printf("Waiting for response... (timeout: %v, max wait: %v)\n", 
-		readTimeout, maxWaitTime)
-	fmt.Println("PLEASE SCAN YOUR LICENSE NOW - You have 10 seconds")
+	logf(ctx, "Waiting for response... (timeout: %v, idle timeout: %v)", 
+		readTimeout, idleTimeout)
+	logf(ctx, "PLEASE SCAN YOUR LICENSE NOW - You have %d seconds", int(readTimeout.Seconds()))
 	
 	hasReceivedData := false
 
-	for time.Now().Before(deadline) {
-		n, err := readWithTimeout(port, tmp, 3*time.Second)
+	for responseBuffer.Len() < maxScanBytes {
+		// A swipe that has started is read to the end even past the deadline
+		wait := idleTimeout
+		if !hasReceivedData {
+			wait = time.Until(deadline)
+			if wait <= 0 {
+				break
+			}
+		}
+		n, err := readWithTimeout(port, tmp, wait)
+		if err != nil && ctx.Err() != nil {
+			return "", fmt.Errorf("scan cancelled: %w", ctx.Err())
+		}
 		if err != nil {
-			if err.Error() == "read timeout" {
+			if errors.Is(err, transport.ErrTimeout) {
 				// If we've received some data but hit a timeout, consider it complete
+				if hasReceivedData && !recordComplete(responseBuffer.Bytes()) && time.Now().Before(deadline) {
+					logf(ctx, "Read timeout in the middle of a record, still reading...")
+					continue
+				}
 				if hasReceivedData {
-					fmt.Println("Read timeout reached after receiving data")
+					logf(ctx, "Read timeout reached after receiving data")
 					break
 				}
 				// Otherwise keep waiting until the overall deadline
-				fmt.Println("Read timeout, still waiting for scan...")
+				logf(ctx, "Read timeout, still waiting for scan...")
 				continue
 			}
 			return "", err
@@ -870,7 +1586,7 @@ func sendScannerCommand(commandStr string, portOverride string, useMacSettings b
 		responseBuffer.Write(tmp[:n])
 		
 		// Enhanced debugging of received data
-		fmt.Printf("Received %d bytes (hex): %s\n", n, hex.EncodeToString(tmp[:n]))
+		logf(ctx, "Received %d bytes (hex): %s", n, redact(hex.EncodeToString(tmp[:n])))
 		
 		// Try to display as readable text, but safely handle binary data
 		var readable string
@@ -881,64 +1597,280 @@ func sendScannerCommand(commandStr string, portOverride string, useMacSettings b
 				readable += fmt.Sprintf("\\x%02x", b)
 			}
 		}
-		fmt.Printf("Received %d bytes (human-readable): %s\n", n, readable)
+		logf(ctx, "Received %d bytes (human-readable): %s", n, redact(readable))
+
+		if length, ok := aamva.Length(responseBuffer.Bytes()); ok && responseBuffer.Len() >= length {
+			logf(ctx, "Barcode complete")
+			break
+		}
 	}
 	
 	if !hasReceivedData {
-		fmt.Println("No data received from scanner during timeout period")
+		logf(ctx, "No data received from scanner during timeout period")
 	}
 	
 	result := responseBuffer.String()
-	fmt.Println("===== COMPLETE RESPONSE =====")
-	fmt.Printf("Raw response (hex): %s\n", hex.EncodeToString(responseBuffer.Bytes()))
-	fmt.Printf("Raw response (string): %q\n", result)
-	fmt.Println("===== END RESPONSE =====")
+	logf(ctx, "===== COMPLETE RESPONSE =====")
+	logf(ctx, "Raw response (hex): %s", redact(hex.EncodeToString(responseBuffer.Bytes())))
+	logf(ctx, "Raw response (string): %q", redact(result))
+	logf(ctx, "===== END RESPONSE =====")
 	
 	return result, nil
 }
 
+// taxBases groups the receipt's taxable amounts by item tax code, with
+// exempt items in a base of their own. Receipts without item tax codes
+// are taxed on the subtotal so discounts are honoured.
+func taxBases(receipt ReceiptData) []tax.Base {
+	hasCodes := false
+	var exempt money.Cents
+	for _, item := range receipt.Items {
+		if item.TaxExempt || item.IsDeposit() {
+			exempt += item.Price.Times(toFloat64(item.Quantity))
+		} else if item.TaxCode != "" {
+			hasCodes = true
+		}
+	}
+	if !hasCodes {
+		if exempt == 0 {
+			return []tax.Base{{Amount: receipt.Subtotal}}
+		}
+		return []tax.Base{{Amount: max(receipt.Subtotal-exempt, 0)}, {Amount: exempt, Exempt: true}}
+	}
+
+	var bases []tax.Base
+	index := make(map[tax.Base]int)
+	for _, item := range receipt.Items {
+		key := tax.Base{Code: item.TaxCode}
+		if item.TaxExempt || item.IsDeposit() {
+			key = tax.Base{Exempt: true}
+		}
+		amount := item.Price.Times(toFloat64(item.Quantity))
+		if i, ok := index[key]; ok {
+			bases[i].Amount += amount
+			continue
+		}
+		index[key] = len(bases)
+		key.Amount = amount
+		bases = append(bases, key)
+	}
+	return bases
+}
+
+// taxSubtotals breaks the tax down by tax code when the receipt mixes
+// codes or exempt items; a single taxable base needs no subtotals
+func taxSubtotals(bases []tax.Base) []tax.Subtotal {
+	if len(bases) < 2 && (len(bases) == 0 || !bases[0].Exempt) {
+		return nil
+	}
+	return taxConfig.Subtotals(bases)
+}
+
+// totalsWarning checks that subtotal + tax + tip adds up to the total, and
+// that split payments add up to it, returning a warning for the response
+// when they don't. No-sale, refund and
+// settlement receipts follow other rules and aren't checked.
+func totalsWarning(receipt ReceiptData) string {
+    if receipt.Type == "noSale" || receipt.Type == "refund" || receipt.IsSettlement || receipt.HasCombinedTransaction {
+        return ""
+    }
+    var warnings []string
+    if diff, ok := money.CheckTotal(receipt.Subtotal, receipt.DiscountAmount+receipt.PromoAmount,
+        receipt.Tax, receipt.Tip, receipt.Total); !ok {
+        warnings = append(warnings, fmt.Sprintf("total %s does not match subtotal %s + tax %s + tip %s (off by %s)",
+            receipt.Total, receipt.Subtotal, receipt.Tax, receipt.Tip, diff))
+    }
+    if len(receipt.Payments) > 0 {
+        tenders := make([]money.Cents, len(receipt.Payments))
+        for i, p := range receipt.Payments {
+            tenders[i] = p.Amount
+        }
+        if diff, ok := money.CheckTendered(receipt.Total, tenders...); !ok {
+            warnings = append(warnings, fmt.Sprintf("payments add up to %s, not the total %s", receipt.Total+diff, receipt.Total))
+        }
+    }
+    return strings.Join(warnings, "; ")
+}
+
 // generateHTMLReceipt creates an HTML receipt from ReceiptData
 func generateHTMLReceipt(receipt ReceiptData) (string, error) {
-    // Parse the template
-    tmpl, err := template.New("receipt").Funcs(templateFuncs).Parse(receiptTemplate)
+    language := receipt.Language
+    if language == "" {
+        language = receiptLanguage
+    }
+    tmpl, err := receiptTemplates.Get("receipt.html", i18n.New(language).Language())
     if err != nil {
         return "", fmt.Errorf("error parsing template: %v", err)
     }
 
-    // Create a buffer to store the rendered HTML
-    var buf bytes.Buffer
-    if err := tmpl.Execute(&buf, receipt); err != nil {
+    html, err := tmplfuncs.Render(tmpl, receipt)
+    if err != nil {
         return "", fmt.Errorf("error executing template: %v", err)
     }
 
-    return buf.String(), nil
+    return html, nil
 }
 
-// printReceipt generates HTML, converts to PDF, and prints
-func printReceipt(receipt ReceiptData, printerName string) error {
-    // Calculate derived fields
-    receipt.ShowTaxBreakdown = !receipt.IsSettlement && !receipt.SkipTaxCalculation && !receipt.HasNoTax
+// printReceipt renders a receipt for the pipeline's output and prints all
+// its copies as one job: HTML converted to PDF once, or ESC/POS sent raw to
+// a thermal printer
+func printReceipt(ctx context.Context, receipt ReceiptData, printerName string, pipeline renderPipeline) error {
+    tr := i18n.New(receipt.Language)
+    if receipt.Language == "" {
+        tr = i18n.New(receiptLanguage)
+    }
+    
+    if pipeline.Output == outputThermal {
+        prepareReceipt(&receipt)
+        data := thermalCopies(formatThermalReceipt(receipt), receipt.Copies, tr)
+        return printRaw(ctx, data, "receipt", receipt.TransactionID, printerName)
+    }
+    
+    html, err := renderReceipt(receipt)
+    if err != nil {
+        return err
+    }
+    return printHTMLDocument(ctx, htmlCopies(html, receipt.Copies, tr), "receipt", receipt.TransactionID, printerName, pipeline.Renderer)
+}
+
+// maxCopies is the most copies one request may print. Every copy goes
+// into the one document that is rendered, or sent to the printer, at once.
+const maxCopies = 20
+
+// copiesError reports a copies count over maxCopies as a validation error
+func copiesError(copies int) error {
+    if copies > maxCopies {
+        return validate.Errors{{Field: "copies", Message: fmt.Sprintf("can't be more than %d (%d)", maxCopies, copies)}}
+    }
+    return nil
+}
+
+// htmlCopies repeats the body of a rendered document count times, each
+// copy headed "Copy i of n" and starting a new page, so the browser
+// renders them all in one pass. A single copy is left as it is.
+func htmlCopies(html string, count int, tr i18n.Translator) string {
+    lower := strings.ToLower(html)
+    start := strings.Index(lower, "<body")
+    end := strings.LastIndex(lower, "</body>")
+    if count <= 1 || start < 0 || end < start {
+        return html
+    }
+    start += strings.Index(lower[start:], ">") + 1
+    body := html[start:end]
     
-    // Generate HTML receipt
+    var b strings.Builder
+    b.WriteString(html[:start])
+    for i := 1; i <= count; i++ {
+        if i > 1 {
+            b.WriteString(`<div style="break-before: page;"></div>`)
+        }
+        fmt.Fprintf(&b, `<div class="copy-label" style="text-align: center; font-weight: bold;">%s</div>`,
+            template.HTMLEscapeString(tr.T("copy_of", i, count)))
+        b.WriteString(body)
+    }
+    b.WriteString(html[end:])
+    return b.String()
+}
+
+// renderReceipt fills in a receipt's derived fields and renders it to HTML
+func renderReceipt(receipt ReceiptData) (string, error) {
+    prepareReceipt(&receipt)
     html, err := generateHTMLReceipt(receipt)
     if err != nil {
-        return fmt.Errorf("error generating HTML receipt: %v", err)
+        return "", fmt.Errorf("error generating HTML receipt: %v", err)
     }
+    return html, nil
+}
 
+// prepareReceipt calculates the fields derived for rendering
+func prepareReceipt(receipt *ReceiptData) {
+    // Calculate derived fields
+    for i, item := range receipt.Items {
+        receipt.Items[i].LineTotal = item.Price.Times(toFloat64(item.Quantity))
+        if item.IsDeposit() {
+            receipt.Deposits = append(receipt.Deposits, receipt.Items[i])
+            receipt.DepositTotal += receipt.Items[i].LineTotal
+        }
+    }
+    receipt.DueBack = earliestDueBack(receipt.Items)
+    receipt.Paper = receiptPaper
+    receipt.Header = messageBlocks(messages.Pick(receipt.HeaderMessages, receiptMessages.Header))
+    receipt.Footer = messageBlocks(messages.Pick(receipt.FooterMessages, receiptMessages.Footer))
+    receipt.CouponBlocks = couponBlocks(receipt.Coupons)
+    receipt.ShowTaxBreakdown = !receipt.IsSettlement && !receipt.SkipTaxCalculation && !receipt.HasNoTax
+    if len(receipt.TaxBreakdown) > 0 {
+        // An explicit breakdown from the frontend always wins
+        receipt.ShowTaxBreakdown = true
+    } else if receipt.ShowTaxBreakdown {
+        bases := taxBases(*receipt)
+        receipt.TaxBreakdown = taxConfig.Breakdown(bases)
+        receipt.TaxSubtotals = taxSubtotals(bases)
+        if merchantInfo.ShowTable() {
+            // The table replaces the subtotals, with a row even for a
+            // single base
+            receipt.VATTable = taxConfig.Subtotals(bases)
+            receipt.TaxSubtotals = nil
+        }
+    }
+    receipt.Merchant = merchantInfo
+    receipt.GLCodeTotal = glcode.Total(receipt.GLCodeSummary)
+    if receipt.HasCombinedTransaction {
+        // Whatever isn't the settlement was spent on the purchase
+        receipt.RetailTotal = receipt.Total - receipt.SettlementAmount
+    }
+    receipt.HasLoyalty = receipt.LoyaltyPointsEarned != 0 || receipt.LoyaltyPointsRedeemed != 0 || receipt.LoyaltyPointsBalance != 0
+    receipt.IsNoSale = receipt.Type == "noSale"
+    receipt.IsRefund = receipt.Type == "refund"
+    if receipt.IsRefund {
+        // Prefer the explicit refund amount; older frontends only send the total
+        receipt.RefundTotal = receipt.RefundAmount
+        if receipt.RefundTotal <= 0 {
+            receipt.RefundTotal = receipt.Total
+        }
+        receipt.RefundMethodDisplay = strings.Title(receipt.RefundMethod)
+        if receipt.RefundMethodDisplay == "" {
+            receipt.RefundMethodDisplay = strings.Title(receipt.PaymentType)
+        }
+    }
+    
+    // Stamp a captured signature above the signature line
+    if receipt.SignatureID != "" {
+        appDir, err := ensureAppDirectory()
+        if err == nil {
+            receipt.SignatureImage, err = signatureDataURL(appDir, receipt.SignatureID)
+        }
+        if err != nil {
+            log.Printf("Printing receipt %s without its signature: %v", receipt.TransactionID, err)
+        }
+    }
+}
+
+// printHTMLDocument converts a rendered HTML document to PDF with a
+// headless browser (renderer, or the first one found) and prints it. kind
+// names the files ("receipt", "agreement") and both files are archived
+// against transactionID.
+// Cancelling ctx, as a client that hangs up or a restart does, kills the
+// browser or print command still running.
+func printHTMLDocument(ctx context.Context, html, kind, transactionID, printerName, renderer string) error {
     // Get app directory
     appDir, err := ensureAppDirectory()
     if err != nil {
         return fmt.Errorf("error ensuring app directory: %v", err)
     }
     
+    if simulatedPrintDir != "" {
+        retainJobArtifact(ctx, "html", transactionID, []byte(html))
+        return writeSimulatedPrint(html, kind, transactionID, printerName)
+    }
+    
     // Create temporary file paths in our app directory
     timestamp := time.Now().Format("20060102-150405")
     var htmlPath, pdfPath string
     
     if runtime.GOOS == "windows" {
         // Use proper Windows path format
-        htmlPath = filepath.Join(appDir, "temp", fmt.Sprintf("receipt-%s.html", timestamp))
-        pdfPath = filepath.Join(appDir, "temp", fmt.Sprintf("receipt-%s.pdf", timestamp))
+        htmlPath = filepath.Join(appDir, "temp", fmt.Sprintf("%s-%s.html", kind, timestamp))
+        pdfPath = filepath.Join(appDir, "temp", fmt.Sprintf("%s-%s.pdf", kind, timestamp))
         
         // Ensure paths are using Windows backslashes
         htmlPath = strings.ReplaceAll(htmlPath, "/", "\\")
@@ -951,167 +1883,130 @@ func printReceipt(receipt ReceiptData, printerName string) error {
         }
         
         // Log the exact paths
-        log.Printf("Windows file paths: HTML=%s, PDF=%s", htmlPath, pdfPath)
+        logf(ctx, "Windows file paths: HTML=%s, PDF=%s", htmlPath, pdfPath)
     } else {
         // Unix-style paths
-        htmlPath = filepath.Join(appDir, "temp", fmt.Sprintf("receipt-%s.html", timestamp))
-        pdfPath = filepath.Join(appDir, "temp", fmt.Sprintf("receipt-%s.pdf", timestamp))
+        htmlPath = filepath.Join(appDir, "temp", fmt.Sprintf("%s-%s.html", kind, timestamp))
+        pdfPath = filepath.Join(appDir, "temp", fmt.Sprintf("%s-%s.pdf", kind, timestamp))
     }
     
     // Write HTML to file
-    log.Printf("Writing HTML to file: %s", htmlPath)
+    logf(ctx, "Writing HTML to file: %s", htmlPath)
     err = ioutil.WriteFile(htmlPath, []byte(html), 0644)
     if err != nil {
-        log.Printf("Error writing HTML file: %v", err)
+        logf(ctx, "Error writing HTML file: %v", err)
         return fmt.Errorf("error writing HTML to file: %v", err)
     }
     
     // Verify the HTML file was created
     if fileInfo, err := os.Stat(htmlPath); os.IsNotExist(err) {
-        log.Printf("HTML file not created at: %s", htmlPath)
+        logf(ctx, "HTML file not created at: %s", htmlPath)
         return fmt.Errorf("HTML file was not created at: %s", htmlPath)
     } else {
-        log.Printf("HTML file created successfully: %s (size: %d bytes)", htmlPath, fileInfo.Size())
+        logf(ctx, "HTML file created successfully: %s (size: %d bytes)", htmlPath, fileInfo.Size())
     }
     
     // Convert HTML to PDF using headless browser
-    fmt.Printf("Converting HTML to PDF using browser: %s\n", htmlPath)
-    log.Printf("Converting HTML to PDF: %s -> %s\n", htmlPath, pdfPath)
-    
-    // Try different browsers in order of preference
-    var cmd *exec.Cmd
-    var output []byte
-    var browserErr error
+    logf(ctx, "Converting HTML to PDF using browser: %s", htmlPath)
+    logf(ctx, "Converting HTML to PDF: %s -> %s\n", htmlPath, pdfPath)
     
-    // Start with Chrome
-    chromeArgs := []string{
-        "--headless",
-        "--disable-gpu",
-        "--no-margins",
-        "--print-to-pdf=" + pdfPath,
-        htmlPath,
+    // Try different browsers in order of preference, or only the
+    // requested one
+    if err := convertToPDF(ctx, htmlPath, pdfPath, renderer); err != nil {
+        return err
     }
     
-    // Try Microsoft Edge (Windows)
-    if runtime.GOOS == "windows" {
-        edgePath := "C:\\Program Files (x86)\\Microsoft\\Edge\\Application\\msedge.exe"
-        if _, err := os.Stat(edgePath); os.IsNotExist(err) {
-            // Try the other common location
-            edgePath = "C:\\Program Files\\Microsoft\\Edge\\Application\\msedge.exe"
-        }
-        
-        // Check if Edge exists
-        if _, err := os.Stat(edgePath); err == nil {
-            fmt.Println("Using Microsoft Edge for PDF conversion")
-            log.Println("Using Microsoft Edge for PDF conversion")
-            cmd = exec.Command(edgePath, "--headless", "--disable-gpu", "--no-margins", "--print-to-pdf="+pdfPath, htmlPath)
-            output, browserErr = cmd.CombinedOutput()
-            if browserErr == nil {
-                // Edge worked!
-                fmt.Printf("PDF successfully generated with Edge: %s\n", pdfPath)
-                log.Printf("PDF successfully generated with Edge: %s\n", pdfPath)
-                goto PrintPDF
-            } else {
-                fmt.Printf("Edge failed: %v\n", browserErr)
-                log.Printf("Edge failed: %v\n%s", browserErr, string(output))
-            }
-        }
-    }
+    logf(ctx, "PDF generated: %s", pdfPath)
+    logf(ctx, "PDF generated: %s\n", pdfPath)
     
-    // Try Chrome
-    cmd = exec.Command("chrome", chromeArgs...)
-    output, browserErr = cmd.CombinedOutput()
-    if browserErr == nil {
-        fmt.Printf("PDF successfully generated with Chrome: %s\n", pdfPath)
-        log.Printf("PDF successfully generated with Chrome: %s\n", pdfPath)
-        goto PrintPDF
-    } else {
-        log.Printf("Chrome failed: %v\n%s", browserErr, string(output))
+    // Keep compressed copies; the loose files are removed by the compaction job
+    archiveReceiptFiles(transactionID, htmlPath, pdfPath)
+    retainJobArtifact(ctx, "html", transactionID, []byte(html))
+    if pdf, err := os.ReadFile(pdfPath); err == nil {
+        retainJobArtifact(ctx, "pdf", transactionID, pdf)
     }
     
-    // Try Google Chrome
-    cmd = exec.Command("google-chrome", chromeArgs...)
-    output, browserErr = cmd.CombinedOutput()
-    if browserErr == nil {
-        fmt.Printf("PDF successfully generated with Google Chrome: %s\n", pdfPath)
-        log.Printf("PDF successfully generated with Google Chrome: %s\n", pdfPath)
-        goto PrintPDF
-    } else {
-        log.Printf("Google Chrome failed: %v\n%s", browserErr, string(output))
+    // Add a small delay to ensure the file is fully written and accessible
+    select {
+    case <-time.After(500 * time.Millisecond):
+    case <-ctx.Done():
+        return fmt.Errorf("printing cancelled: %w", ctx.Err())
     }
     
-    // Try Chromium
-    cmd = exec.Command("chromium-browser", chromeArgs...)
-    output, browserErr = cmd.CombinedOutput()
-    if browserErr == nil {
-        fmt.Printf("PDF successfully generated with Chromium: %s\n", pdfPath)
-        log.Printf("PDF successfully generated with Chromium: %s\n", pdfPath)
-        goto PrintPDF
+    // Verify the PDF file exists
+    fileInfo, err := os.Stat(pdfPath)
+    if err != nil {
+        logf(ctx, "Warning - PDF file access issue: %v (will continue anyway)", err)
     } else {
-        log.Printf("Chromium failed: %v\n%s", browserErr, string(output))
+        logf(ctx, "PDF file verified: %s (size: %d bytes)", pdfPath, fileInfo.Size())
     }
-    
-    // If we get here, all browsers failed
-    return fmt.Errorf("error converting HTML to PDF: no compatible browser found\nLast error: %v\nOutput: %s", 
-        browserErr, string(output))
 
-PrintPDF:
-    fmt.Printf("PDF generated: %s\n", pdfPath)
-    log.Printf("PDF generated: %s\n", pdfPath)
+    // Print the PDF silently, or on Windows, when no silent method works,
+    // open it for someone to print by hand
+    err = printPDF(ctx, pdfPath, kind, printerName)
+    if err == nil || runtime.GOOS != "windows" || ctx.Err() != nil {
+        return err
+    }
     
-    // Add a small delay to ensure the file is fully written and accessible
-    time.Sleep(500 * time.Millisecond)
+    // Method 5: Last resort - open the PDF for manual printing
+    logf(ctx, "Method 5: Opening PDF for manual printing...")
     
-    // Verify the PDF file exists
-    fileInfo, err := os.Stat(pdfPath)
-    if err != nil {
-        log.Printf("Warning - PDF file access issue: %v (will continue anyway)", err)
+    openCmd := exec.Command("cmd", "/c", "start", "", pdfPath)
+    openErr := openCmd.Start()
+    
+    if openErr == nil {
+        logf(ctx, "Opened PDF file for manual printing")
+        manual := &manualPrintError{JobID: printJobID(ctx), Path: pdfPath, Kind: kind, TransactionID: transactionID, Printer: printerName, err: err}
+        rememberManualPrint(manual)
+        return manual
     } else {
-        log.Printf("PDF file verified: %s (size: %d bytes)", pdfPath, fileInfo.Size())
+        logf(ctx, "Error opening PDF: %v", openErr)
+        return fmt.Errorf("all printing methods failed. PDF saved at: %s", pdfPath)
     }
+}
 
-    // Print the PDF silently based on OS
+// printPDF prints a PDF silently: through lp on macOS and Linux, and on
+// Windows through the first of several methods that works
+func printPDF(ctx context.Context, pdfPath, kind, printerName string) error {
     if runtime.GOOS == "windows" {
         // Log the file existence and size
         fileInfo, err := os.Stat(pdfPath)
         if err != nil {
-            log.Printf("Error checking PDF file: %v", err)
+            logf(ctx, "Error checking PDF file: %v", err)
         } else {
-            log.Printf("PDF file exists at %s (size: %d bytes)", pdfPath, fileInfo.Size())
+            logf(ctx, "PDF file exists at %s (size: %d bytes)", pdfPath, fileInfo.Size())
         }
 
         // For Windows, try several printing methods in order of reliability
         
         // Method 1: Print using ShellExecute with verb "print"
-        log.Printf("Method 1: Using ShellExecute with 'print' verb...")
-        shellCmd := exec.Command("cmd", "/c", "start", "", "/wait", "/b", "powershell", "-Command", 
+        logf(ctx, "Method 1: Using ShellExecute with 'print' verb...")
+        shellOutput, shellErr := runCommand(ctx, "cmd", "/c", "start", "", "/wait", "/b", "powershell", "-Command", 
             fmt.Sprintf("(New-Object -ComObject WScript.Shell).ShellExecute('%s', '', '', 'print', 1)", pdfPath))
-        shellOutput, shellErr := shellCmd.CombinedOutput()
         
         if shellErr == nil {
-            log.Printf("Successfully printed with ShellExecute")
-            fmt.Printf("Successfully printed receipt\n")
+            logf(ctx, "Successfully printed with ShellExecute")
+            logf(ctx, "Successfully printed %s", kind)
             return nil  // Return nil to indicate success
         } else {
-            log.Printf("ShellExecute printing error: %v\n%s", shellErr, string(shellOutput))
+            logf(ctx, "ShellExecute printing error: %v\n%s", shellErr, string(shellOutput))
         }
         
         // Method 2: Use direct system command line printer
-        log.Printf("Method 2: Using direct system print command...")
+        logf(ctx, "Method 2: Using direct system print command...")
         
-        sysCmd := exec.Command("cmd", "/c", "print", pdfPath)
-        sysOutput, sysErr := sysCmd.CombinedOutput()
+        sysOutput, sysErr := runCommand(ctx, "cmd", "/c", "print", pdfPath)
         
         if sysErr == nil {
-            log.Printf("Successfully printed with system print command")
-            fmt.Printf("Successfully printed receipt using system command\n")
+            logf(ctx, "Successfully printed with system print command")
+            logf(ctx, "Successfully printed %s using system command", kind)
             return nil
         } else {
-            log.Printf("System print command error: %v\n%s", sysErr, string(sysOutput))
+            logf(ctx, "System print command error: %v\n%s", sysErr, string(sysOutput))
         }
         
         // Method 3: Try AcroRd32.exe if Adobe Reader is installed
-        log.Printf("Method 3: Checking for Adobe Reader...")
+        logf(ctx, "Method 3: Checking for Adobe Reader...")
         
         adobePaths := []string{
             "C:\\Program Files (x86)\\Adobe\\Acrobat Reader DC\\Reader\\AcroRd32.exe",
@@ -1122,18 +2017,17 @@ PrintPDF:
         
         for _, adobePath := range adobePaths {
             if _, err := os.Stat(adobePath); err == nil {
-                log.Printf("Found Adobe Reader at: %s", adobePath)
+                logf(ctx, "Found Adobe Reader at: %s", adobePath)
                 
                 // Print silently with Adobe Reader
-                adobeCmd := exec.Command(adobePath, "/t", pdfPath, printerName)
-                adobeOutput, adobeErr := adobeCmd.CombinedOutput()
+                adobeOutput, adobeErr := runCommand(ctx, adobePath, "/t", pdfPath, printerName)
                 
                 if adobeErr == nil {
-                    log.Printf("Successfully printed with Adobe Reader")
-                    fmt.Printf("Successfully printed receipt using Adobe Reader\n")
+                    logf(ctx, "Successfully printed with Adobe Reader")
+                    logf(ctx, "Successfully printed %s using Adobe Reader", kind)
                     return nil
                 } else {
-                    log.Printf("Adobe Reader printing error: %v\n%s", adobeErr, string(adobeOutput))
+                    logf(ctx, "Adobe Reader printing error: %v\n%s", adobeErr, string(adobeOutput))
                 }
                 
                 break
@@ -1141,7 +2035,7 @@ PrintPDF:
         }
         
         // Method 4: Try SumatraPDF if available
-        log.Printf("Method 4: Checking for SumatraPDF...")
+        logf(ctx, "Method 4: Checking for SumatraPDF...")
         
         sumatraPaths := []string{
             "C:\\Program Files\\SumatraPDF\\SumatraPDF.exe",
@@ -1150,67 +2044,58 @@ PrintPDF:
         
         for _, sumatraPath := range sumatraPaths {
             if _, err := os.Stat(sumatraPath); err == nil {
-                log.Printf("Found SumatraPDF at: %s", sumatraPath)
+                logf(ctx, "Found SumatraPDF at: %s", sumatraPath)
                 
                 // Print silently with SumatraPDF
-                var sumatraCmd *exec.Cmd
+                var sumatraArgs []string
                 
                 if printerName != "" {
-                    sumatraCmd = exec.Command(sumatraPath, "-print-to", printerName, "-silent", pdfPath)
+                    sumatraArgs = []string{"-print-to", printerName, "-silent", pdfPath}
                 } else {
-                    sumatraCmd = exec.Command(sumatraPath, "-print-to-default", "-silent", pdfPath)
+                    sumatraArgs = []string{"-print-to-default", "-silent", pdfPath}
                 }
                 
-                sumatraOutput, sumatraErr := sumatraCmd.CombinedOutput()
+                sumatraOutput, sumatraErr := runCommand(ctx, sumatraPath, sumatraArgs...)
                 
                 if sumatraErr == nil {
-                    log.Printf("Successfully printed with SumatraPDF")
-                    fmt.Printf("Successfully printed receipt using SumatraPDF\n")
+                    logf(ctx, "Successfully printed with SumatraPDF")
+                    logf(ctx, "Successfully printed %s using SumatraPDF", kind)
                     return nil
                 } else {
-                    log.Printf("SumatraPDF printing error: %v\n%s", sumatraErr, string(sumatraOutput))
+                    logf(ctx, "SumatraPDF printing error: %v\n%s", sumatraErr, string(sumatraOutput))
                 }
                 
                 break
             }
         }
         
-        // Method 5: Last resort - open the PDF for manual printing
-        log.Printf("Method 5: Opening PDF for manual printing...")
-        
-        openCmd := exec.Command("cmd", "/c", "start", "", pdfPath)
-        openErr := openCmd.Start()
-        
-        if openErr == nil {
-            log.Printf("Opened PDF file for manual printing")
-            return fmt.Errorf("automatic printing failed, opened PDF for manual printing at: %s", pdfPath)
-        } else {
-            log.Printf("Error opening PDF: %v", openErr)
-            return fmt.Errorf("all printing methods failed. PDF saved at: %s", pdfPath)
-        }
+        return fmt.Errorf("no silent printing method could print %s", pdfPath)
     } else if runtime.GOOS == "darwin" {
         // macOS: use lp command
-        cmd = exec.Command("lp", "-d", printerName, pdfPath)
-        fmt.Printf("Printing PDF using lp command on macOS to printer: %s\n", printerName)
-        log.Printf("Printing PDF using lp command on macOS to printer: %s\n", printerName)
+        logf(ctx, "Printing PDF using lp command on macOS to printer: %s", printerName)
+        logf(ctx, "Printing PDF using lp command on macOS to printer: %s\n", printerName)
     } else {
         // Linux: use lp command
-        cmd = exec.Command("lp", "-d", printerName, pdfPath)
-        fmt.Printf("Printing PDF using lp command on Linux to printer: %s\n", printerName)
-        log.Printf("Printing PDF using lp command on Linux to printer: %s\n", printerName)
+        logf(ctx, "Printing PDF using lp command on Linux to printer: %s", printerName)
+        logf(ctx, "Printing PDF using lp command on Linux to printer: %s\n", printerName)
     }
 
     // For macOS and Linux only, execute the command
     if runtime.GOOS == "darwin" || runtime.GOOS == "linux" {
-        output, err := cmd.CombinedOutput()
+        // Without a printer name lp uses the system default printer
+        lpArgs := []string{pdfPath}
+        if printerName != "" {
+            lpArgs = []string{"-d", printerName, pdfPath}
+        }
+        output, err := runCommand(ctx, "lp", lpArgs...)
         if err != nil {
-            log.Printf("Printing error: %v\n%s", err, string(output))
+            logf(ctx, "Printing error: %v\n%s", err, string(output))
             return fmt.Errorf("error printing PDF: %v\nOutput: %s", err, string(output))
         }
     }
 
-    fmt.Printf("Successfully printed receipt\n")
-    log.Printf("Successfully printed receipt\n")
+    logf(ctx, "Successfully printed %s", kind)
+    logf(ctx, "Successfully printed %s\n", kind)
     
     // We'll keep the files for debugging purposes
     // They're in our dedicated app directory, so they won't clutter the temp folder
@@ -1218,20 +2103,130 @@ PrintPDF:
     return nil
 }
 
+// archiveReceiptFiles stores compressed copies of rendered receipt files.
+// Failures are logged but never fail the print.
+func archiveReceiptFiles(transactionID string, paths ...string) {
+	if receiptArchive == nil {
+		return
+	}
+	for _, path := range paths {
+		entry, err := receiptArchive.StoreFile(path, transactionID)
+		if err != nil {
+			log.Printf("Error archiving %s: %v", path, err)
+			continue
+		}
+		log.Printf("Archived %s (%d -> %d bytes)", entry.Name, entry.Size, entry.CompressedSize)
+	}
+}
+
+// runArchiveCompaction periodically moves loose rendered receipts from the
+// temp directory into the archive and applies the retention period
+func runArchiveCompaction(tempDir string, minAge, retention time.Duration) {
+	for {
+		result, err := receiptArchive.Compact(tempDir, []string{".html", ".pdf", thermalFile}, minAge, retention)
+		if err != nil {
+			log.Printf("Archive compaction failed: %v", err)
+		} else if result.Archived+result.Removed+result.Expired > 0 {
+			log.Printf("Archive compaction: archived %d, removed %d loose files, expired %d entries",
+				result.Archived, result.Removed, result.Expired)
+		}
+		time.Sleep(time.Hour)
+	}
+}
+
+// statsHandler reports storage used by the app directory
+func statsHandler(w http.ResponseWriter, r *http.Request, appDir string) {
+	tempFiles, tempBytes := archive.DirSize(filepath.Join(appDir, "temp"))
+	logFiles, logBytes := archive.DirSize(filepath.Join(appDir, "logs"))
+	
+	stats := map[string]interface{}{
+		"temp": map[string]interface{}{"files": tempFiles, "bytes": tempBytes},
+		"logs": map[string]interface{}{"files": logFiles, "bytes": logBytes},
+		"time": time.Now().Format(time.RFC3339),
+	}
+	if receiptArchive != nil {
+		stats["archive"] = receiptArchive.Usage()
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// archivedReceiptHandler returns an archived receipt by file name, or the
+// latest one for a transaction (?transactionId=...&kind=pdf)
+func archivedReceiptHandler(w http.ResponseWriter, r *http.Request) {
+	if receiptArchive == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, errors.New("receipt archive is not available"))
+		return
+	}
+	
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		transactionID := r.URL.Query().Get("transactionId")
+		if transactionID == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("name or transactionId is required"))
+			return
+		}
+		kind := r.URL.Query().Get("kind")
+		if kind == "" {
+			kind = "pdf"
+		}
+		entries := receiptArchive.Find(transactionID, kind)
+		if len(entries) == 0 {
+			writeJSONError(w, http.StatusNotFound, archive.ErrNotFound)
+			return
+		}
+		name = entries[0].Name
+	}
+	
+	entry, data, err := receiptArchive.Get(name)
+	if errors.Is(err, archive.ErrNotFound) {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	
+	if entry.Kind == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", entry.Name))
+	w.Write(data)
+}
+
 func writeJSONError(w http.ResponseWriter, status int, err error) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "error",
-		"message": err.Error(),
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Status:    "error",
+		Message:   err.Error(),
+		RequestID: w.Header().Get(tracing.Header),
 	})
 }
 
+// writeValidationError reports a request body that isn't JSON, or one
+// with invalid fields, listing each field under "errors"
+func writeValidationError(w http.ResponseWriter, err error) {
+	var errs validate.Errors
+	if !errors.As(err, &errs) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error parsing JSON data: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ErrorResponse{Status: "error", Message: errs.Error(), Errors: errs, RequestID: w.Header().Get(tracing.Header)})
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+operatorHeader+", "+tracing.Header)
+		w.Header().Set("Access-Control-Expose-Headers", tracing.Header+", Deprecation, Link")
 		if r.Method == http.MethodOptions {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -1240,39 +2235,170 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func scannerHandler(w http.ResponseWriter, r *http.Request, portOverride string, scannerPort string, useSimpleCommand bool, useMacSettings bool, readTimeout time.Duration) {
-	var command string
-	if useSimpleCommand {
-		command = "<TXPING>"
-		fmt.Println("Using simple command format: <TXPING>")
-	} else {
-		command = fmt.Sprintf("<TXPING,%s>", scannerPort)
-		fmt.Printf("Using port-specific command format: <TXPING,%s>\n", scannerPort)
+// scanError is a scan that failed, with the status it is answered with
+type scanError struct {
+	status     int
+	err        error
+	swipeAgain bool // A misread: the frontend should ask for the card again
+}
+
+func (e *scanError) Error() string {
+	return e.err.Error()
+}
+
+// writeScanError answers a request whose scan failed
+func writeScanError(w http.ResponseWriter, err error) {
+	var failed *scanError
+	switch {
+	case !errors.As(err, &failed):
+		writeJSONError(w, http.StatusInternalServerError, err)
+	case failed.swipeAgain:
+		writeSwipeAgain(w, failed.err)
+	default:
+		writeJSONError(w, failed.status, failed.err)
 	}
-	
-	fmt.Printf("Sending command: %s via port: %s\n", command, portOverride)
-	result, err := sendScannerCommand(command, portOverride, useMacSettings, readTimeout)
+}
 
+func scannerHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	resp, err := scanCard(r, opts)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		writeJSONError(w, http.StatusInternalServerError, err)
+		writeScanError(w, err)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// scanCard scans a card for a request to /scanner/scan, honouring its
+// ?timeout, ?queue and ?photo, and parses what the scanner sent. A failed
+// scan returns a *scanError.
+func scanCard(r *http.Request, opts agentOptions) (ScanResponse, error) {
+	// Customers who need longer to swipe can be given ?timeout=30,
+	// bounded by -max-timeout
+	readTimeout := opts.ReadTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds <= 0 {
+			return ScanResponse{}, &scanError{status: http.StatusBadRequest, err: fmt.Errorf("invalid timeout %q: must be a whole number of seconds", v)}
+		}
+		// Compared in seconds, so a huge value can't overflow a Duration
+		readTimeout = opts.MaxScanTimeout
+		if int64(seconds) < int64(opts.MaxScanTimeout/time.Second) {
+			readTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+
+	// One scan at a time per scanner. A second request gets a 409 unless
+	// it asks to wait its turn with ?queue=true.
+	device := scannerDevice(opts.PortOverride)
+	if queue, _ := strconv.ParseBool(r.URL.Query().Get("queue")); queue {
+		ctx, cancel := context.WithTimeout(r.Context(), opts.MaxScanTimeout)
+		err := device.acquire(ctx)
+		cancel()
+		if err != nil {
+			return ScanResponse{}, &scanError{status: http.StatusConflict, err: errors.New("timed out waiting for the scan in progress to finish")}
+		}
+	} else if !device.tryAcquire() {
+		return ScanResponse{}, &scanError{status: http.StatusConflict, err: errors.New("a scan is already in progress on this scanner")}
+	}
+	defer device.release()
+
+	// Every scan attempt is journaled, with who asked for it when the
+	// request says; the exits below fill in the outcome
+	scanEntry := journal.Entry{
+		Kind:      journal.Scan,
+		RequestID: tracing.RequestID(r.Context()),
+		Status:    "failed",
+		Operator:  sanitize.Line(r.URL.Query().Get("operatorName"), sanitize.MaxName),
+		Station:   sanitize.Line(r.URL.Query().Get("stationId"), sanitize.MaxName),
+	}
+	started := time.Now()
+	defer func() {
+		scanEntry.DurationMs = time.Since(started).Milliseconds()
+		recordJournal(scanEntry)
+	}()
+
+	showPrompt(r.Context(), display.PromptScanID, "", display.PromptData{})
+	var command []byte
+	if profile := opts.scanner(); profile.Trigger != "" {
+		command = profile.frame(scannerCommand(profile.Trigger, opts))
+		logf(r.Context(), "Sending command: %s via port: %s", command, opts.PortOverride)
+	}
+	result, err := sendScannerCommand(r.Context(), command, opts.PortOverride, opts.UseMacSettings, readTimeout, opts.ScanIdleTimeout, opts.Bluetooth)
+
+	if err != nil {
+		logf(r.Context(), "Scan failed: %v", err)
+		scanEntry.Error = err.Error()
+		return ScanResponse{}, &scanError{status: http.StatusInternalServerError, err: err}
+	}
 	
+	resp, err := parseScan(r, result, &scanEntry)
+	if err == nil && resp.Status == "success" {
+		showPrompt(r.Context(), display.PromptWelcome, "", display.PromptData{})
+	}
+	return resp, err
+}
+
+// scannerCommand writes a command in the scanner's format: <NAME> with
+// -simple-command, otherwise <NAME,port> addressed to the scanner's port.
+// Profiles without bracketed commands send it as it is.
+func scannerCommand(name string, opts agentOptions) string {
+	if !opts.scanner().Bracketed {
+		return name
+	}
+	if opts.UseSimpleCommand {
+		fmt.Printf("Using simple command format: <%s>\n", name)
+		return "<" + name + ">"
+	}
+	fmt.Printf("Using port-specific command format: <%s,%s>\n", name, opts.ScannerPort)
+	return fmt.Sprintf("<%s,%s>", name, opts.ScannerPort)
+}
+
+// parseScan parses what the scanner sent into the scan response, filling
+// in the journal entry's outcome. A scan that read nothing or misread
+// returns a *scanError.
+func parseScan(r *http.Request, result string, entry *journal.Entry) (ScanResponse, error) {
 	// Check if the response is empty
 	if strings.TrimSpace(result) == "" {
-		writeJSONError(w, http.StatusNotFound, errors.New("empty response from scanner"))
-		return
+		entry.Error = "empty response from scanner"
+		return ScanResponse{}, &scanError{status: http.StatusNotFound, err: errors.New(entry.Error)}
 	}
 	
 	// Check for NAK (0x15) only response (scanner didn't return data)
 	trimmedResult := strings.TrimSpace(result)
 	if trimmedResult == string(byte(0x15)) || (len(trimmedResult) <= 2 && strings.HasPrefix(trimmedResult, "\x15")) {
-		writeJSONError(w, http.StatusNotFound, errors.New("no license scanned (NAK received)"))
-		return
+		entry.Error = "no license scanned (NAK received)"
+		return ScanResponse{}, &scanError{status: http.StatusNotFound, err: errors.New(entry.Error)}
+	}
+
+	// A misread swipe or barcode is turned away rather than putting a
+	// garbled name on a rental contract
+	if err := checkScan(result); err != nil {
+		entry.Error = "misread: " + err.Error()
+		return ScanResponse{}, &scanError{status: http.StatusUnprocessableEntity, err: err, swipeAgain: true}
+	}
+
+	// Loyalty, membership and other non-license cards
+	if !isLicenseFormat(result) {
+		if card, ok := magstripe.Parse(result); ok {
+			logf(r.Context(), "Read generic card ending %s (tracks %v)", card.Number[max(len(card.Number)-4, 0):], card.Tracks)
+			entry.Status, entry.CardType = "success", card.CardType
+			return ScanResponse{
+				Status:   "success",
+				CardType: card.CardType,
+				CardData: &card,
+				Complete: recordComplete([]byte(result)),
+			}, nil
+		}
 	}
 
 	licenseData := parseLicenseData(result)
+	if format := licenseFormat(result); format == formatBC || format == formatAlberta {
+		if err := checkLicenseDates(licenseData); err != nil {
+			entry.Error = "misread: " + err.Error()
+			return ScanResponse{}, &scanError{status: http.StatusUnprocessableEntity, err: err, swipeAgain: true}
+		}
+	}
 	
 	// Check if all fields are empty (except licenseClass which defaults to "NA")
 	allFieldsEmpty := licenseData.FirstName == "" && 
@@ -1281,30 +2407,53 @@ func scannerHandler(w http.ResponseWriter, r *http.Request, portOverride string,
 		licenseData.City == "" && 
 		licenseData.LicenseNumber == ""
 	
+	public := publicLicense(licenseData)
 	if allFieldsEmpty {
+		resp := ScanResponse{
+			Status:      "warning",
+			Message:     "Received data but no license fields were populated",
+			LicenseData: &public,
+			Complete:    recordComplete([]byte(result)),
+			Diagnostics: diagnoseScan(result, licenseData),
+		}
+		entry.Status, entry.Error = "warning", "no license fields were populated"
 		// Include the raw data for debugging
-		resp := map[string]interface{}{
-			"status":        "warning",
-			"message":       "Received data but no license fields were populated",
-			"licenseData":   licenseData,
-			"rawResponse":   result,
-			"rawResponseHex": hex.EncodeToString([]byte(result)),
+		if debugScans.Load() {
+			resp.RawResponse = result
+			resp.RawResponseHex = hex.EncodeToString([]byte(result))
 		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(resp)
-		return
+		return resp, nil
 	}
 
-	resp := map[string]interface{}{
-		"status":      "success",
-		"licenseData": licenseData,
+	scanID := rememberScan(licenseData)
+	resp := ScanResponse{
+		Status:      "success",
+		CardType:    "license",
+		LicenseData: &public,
+		ScanID:      scanID, // Reference it from /print/receipt or /print/agreement
+		Complete:    recordComplete([]byte(result)),
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	entry.Status, entry.CardType, entry.ScanID = "success", "license", scanID
+	entry.LicenseNumber = maskLicenseNumber(licenseData.LicenseNumber)
+	entry.State = licenseData.State
+	if incompleteLicense(licenseData) {
+		resp.Diagnostics = diagnoseScan(result, licenseData)
+	}
+	
+	// The portrait is only extracted on request (?photo=true); it can be
+	// several kilobytes
+	if photo, _ := strconv.ParseBool(r.URL.Query().Get("photo")); photo {
+		if portrait, ok := aamva.Portrait([]byte(result)); ok {
+			resp.Portrait = base64.StdEncoding.EncodeToString(portrait)
+			resp.PortraitType = "image/jpeg"
+		}
+	}
+	return resp, nil
 }
 
-// printReceiptHandler handles the receipt printing functionality
-func printReceiptHandler(w http.ResponseWriter, r *http.Request, printerName string) {
+// printReceiptHandler handles the receipt printing functionality. defaults
+// is the output and renderer used when the request doesn't name them.
+func printReceiptHandler(w http.ResponseWriter, r *http.Request, printerName string, recentPrints *dedupe.Window, defaults renderPipeline) {
     // Only allow POST method
     if r.Method != http.MethodPost {
         writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
@@ -1314,11 +2463,23 @@ func printReceiptHandler(w http.ResponseWriter, r *http.Request, printerName str
     // Read the request body
     body, err := ioutil.ReadAll(r.Body)
     if err != nil {
+        var tooLarge *http.MaxBytesError
+        if errors.As(err, &tooLarge) {
+            writeBodyError(w, err)
+            return
+        }
         writeJSONError(w, http.StatusBadRequest, errors.New("error reading request body"))
         return
     }
     defer r.Body.Close()
     
+    // Check every field first, so the frontend hears about all of them
+    // at once rather than the first one the decoder trips on
+    if err := validate.JSON(body, ReceiptData{}); err != nil {
+        writeValidationError(w, err)
+        return
+    }
+    
     // Parse the JSON data with more flexible number handling
     var receipt ReceiptData
     d := json.NewDecoder(strings.NewReader(string(body)))
@@ -1328,46 +2489,212 @@ func printReceiptHandler(w http.ResponseWriter, r *http.Request, printerName str
         return
     }
     
+    if err := sanitizeReceipt(&receipt); err != nil {
+        writeJSONError(w, http.StatusBadRequest, err)
+        return
+    }
+    
     // Validate receipt - skip validation for 'noSale' type
     if receipt.Type != "noSale" && receipt.TransactionID == "" {
         writeJSONError(w, http.StatusBadRequest, errors.New("transaction ID is required"))
         return
     }
+    if err := itemTypeError(receipt.Items); err != nil {
+        writeJSONError(w, http.StatusBadRequest, err)
+        return
+    }
+    if err := paymentsError(receipt.Payments); err != nil {
+        writeJSONError(w, http.StatusBadRequest, err)
+        return
+    }
+    if err := messages.Validate(receipt.HeaderMessages); err != nil {
+        writeJSONError(w, http.StatusBadRequest, fmt.Errorf("headerMessages: %v", err))
+        return
+    }
+    if err := messages.Validate(receipt.FooterMessages); err != nil {
+        writeJSONError(w, http.StatusBadRequest, fmt.Errorf("footerMessages: %v", err))
+        return
+    }
+    if err := coupons.Validate(receipt.Coupons); err != nil {
+        writeJSONError(w, http.StatusBadRequest, fmt.Errorf("coupons: %v", err))
+        return
+    }
+    // A no-sale opens the drawer, so it fails closed without an audit log
+    if receipt.Type == "noSale" && auditLog == nil {
+        writeJSONError(w, http.StatusServiceUnavailable, errors.New("no-sales are refused while the audit log can't record them"))
+        return
+    }
+    pipeline, err := parsePipeline(receipt.Output, receipt.Renderer, defaults)
+    if err != nil {
+        writeJSONError(w, http.StatusBadRequest, err)
+        return
+    }
     
+    if err := copiesError(receipt.Copies); err != nil {
+        writeValidationError(w, err)
+        return
+    }
     // Set default copies if not specified
     if receipt.Copies <= 0 {
         receipt.Copies = 1
     }
     
-    // Print the requested number of copies
+    // The same receipt again soon after it printed is most likely a
+    // double click or a retry: mark it DUPLICATE, or refuse it
+    if printed, ok := recentPrints.Check(receipt.TransactionID, body); ok {
+        if recentPrints.Action == dedupe.Reject {
+            writeJSONError(w, http.StatusConflict, fmt.Errorf("receipt %s was already printed at %s", receipt.TransactionID, printed.Format("15:04:05")))
+            return
+        }
+        logf(r.Context(), "Receipt %s is a duplicate of one printed at %s", receipt.TransactionID, printed.Format("15:04:05"))
+        receipt.IsDuplicate = true
+    }
+    
+    number, err := numberReceipt(r.Context(), &receipt)
+    if err != nil {
+        writeJSONError(w, http.StatusInternalServerError, err)
+        return
+    }
+    // The number is kept once the receipt printed, and handed back
+    // otherwise
+    numbered := false
+    defer func() { finishReceiptNumber(r.Context(), number, numbered) }()
+    pickCoupons(&receipt)
+    
+    // Stamp the verified customer from a referenced ID scan
+    var scan verifiedScan
+    if receipt.ScanID != "" {
+        scan, err = lookupScan(receipt.ScanID)
+        if err != nil {
+            writeJSONError(w, http.StatusBadRequest, err)
+            return
+        }
+        receipt.VerifiedName = scan.maskedName()
+        receipt.VerifiedLicense = maskLicenseNumber(scan.License.LicenseNumber)
+    }
+    
+    // Mismatched totals still print, but the frontend is told about it
+    warning := totalsWarning(receipt)
+    if warning != "" {
+        logf(r.Context(), "Receipt %s: %s", receipt.TransactionID, warning)
+    }
+    
+    // Store rules run on the totals as checked, e.g. rounding the total
+    // for cash
+    applyScriptedFields(&receipt)
+    
+    // Print the requested number of copies, or hand the HTML back to a
+    // caller that prints it itself
+    ctx, jobID := startPrintJob(r.Context())
+    showPrompt(ctx, display.PromptTotal, locationName(receipt.Location), display.PromptData{Total: currencyFormat.FormatCents(receipt.Total)})
     successCount := 0
     var lastError error
+    var html string
+    if pipeline.Output == outputHTML {
+        printerName = ""
+        if html, lastError = renderReceipt(receipt); lastError == nil {
+            retainJobArtifact(ctx, "html", receipt.TransactionID, []byte(html))
+            successCount = receipt.Copies
+        }
+    }
     
-    for i := 0; i < receipt.Copies; i++ {
-        fmt.Printf("Printing copy %d/%d\n", i+1, receipt.Copies)
-        if err := printReceipt(receipt, printerName); err != nil {
-            // If the error message contains "opened PDF for manual printing" or 
-            // mentions ShellExecute or any indication of successful printing,
-            // consider it a partial success
-            if strings.Contains(err.Error(), "opened PDF for manual printing") || 
-               strings.Contains(err.Error(), "ShellExecute") ||
-               strings.Contains(err.Error(), "successfully printed") {
-                successCount++
-                log.Printf("Counted as success despite error: %v", err)
-            } else {
-                log.Printf("Print error (copy %d/%d): %v", i+1, receipt.Copies, err)
-                lastError = err
-            }
+    // The copies are rendered once and sent as one job, so they print or
+    // fail together. A PDF opened for printing by hand printed nothing yet.
+    var manual *manualPrintError
+    if pipeline.Output != outputHTML {
+        logf(r.Context(), "Printing %d copies", receipt.Copies)
+        if err := printReceipt(ctx, receipt, printerName, pipeline); errors.As(err, &manual) {
+            logf(r.Context(), "Receipt %s needs printing by hand: %v", receipt.TransactionID, err)
+        } else if err != nil {
+            logf(r.Context(), "Print error (%d copies): %v", receipt.Copies, err)
+            lastError = err
         } else {
-            successCount++
+            successCount = receipt.Copies
+        }
+    }
+    
+    printEntry := journal.Entry{
+        JobID:         jobID,
+        RequestID:     tracing.RequestID(ctx),
+        Kind:          journal.Print,
+        Status:        "success",
+        Document:      "receipt",
+        TransactionID: receipt.TransactionID,
+        Copies:        receipt.Copies,
+        Printed:       successCount,
+        Printer:       printerName,
+        ScanID:        receipt.ScanID,
+        Operator:      receipt.OperatorName,
+        Station:       receipt.StationID,
+        Duplicate:     receipt.IsDuplicate,
+        ReceiptNumber: receipt.ReceiptNumber,
+        Output:        pipeline.Output,
+    }
+    if successCount < receipt.Copies {
+        printEntry.Status = "failed"
+        if lastError != nil {
+            printEntry.Error = lastError.Error()
         }
     }
+    if manual != nil {
+        printEntry.Status, printEntry.Error = manualPrint, manual.Error()
+    }
+    recordJournal(printEntry)
+    if receipt.Type == "noSale" {
+        recordJournal(journal.Entry{
+            JobID:         jobID,
+            RequestID:     printEntry.RequestID,
+            Kind:          journal.Drawer,
+            Status:        printEntry.Status,
+            TransactionID: receipt.TransactionID,
+            Printer:       printerName,
+            Operator:      receipt.OperatorName,
+            Station:       receipt.StationID,
+            Error:         printEntry.Error,
+        })
+    }
+    
+    // The receipt is on screen with its number, so the number is kept and
+    // printing it again counts as a duplicate
+    if manual != nil {
+        numbered = true
+        auditReceipt(r, receipt, 0)
+        recentPrints.Record(receipt.TransactionID, body)
+        if receipt.ScanID != "" {
+            recordScanLink(receipt.TransactionID, "receipt", scan)
+        }
+        writeManualPrint(w, manual)
+        return
+    }
+    if successCount > 0 {
+        numbered = true
+        showPrompt(ctx, display.PromptThankYou, locationName(receipt.Location), display.PromptData{})
+        auditReceipt(r, receipt, successCount)
+        recentPrints.Record(receipt.TransactionID, body)
+    }
     
     // Return response
     if successCount > 0 {
+        if receipt.ScanID != "" {
+            recordScanLink(receipt.TransactionID, "receipt", scan)
+        }
         resp := map[string]interface{}{
             "status":  "success",
             "message": fmt.Sprintf("Printed %d/%d copies successfully", successCount, receipt.Copies),
+            "jobId":   jobID,
+        }
+        if warning != "" {
+            resp["warning"] = warning
+        }
+        if receipt.IsDuplicate {
+            resp["duplicate"] = true
+        }
+        if receipt.ReceiptNumber != 0 {
+            resp["receiptNumber"] = receipt.ReceiptNumber
+        }
+        if pipeline.Output == outputHTML {
+            resp["message"] = "Rendered receipt for printing"
+            resp["html"] = html
         }
         w.Header().Set("Content-Type", "application/json")
         json.NewEncoder(w).Encode(resp)
@@ -1382,16 +2709,282 @@ func printReceiptHandler(w http.ResponseWriter, r *http.Request, printerName str
     }
 }
 
+// agentOptions holds the command-line settings the HTTP handlers need
+type agentOptions struct {
+	PortOverride     string
+	ScannerPort      string
+	UseSimpleCommand bool
+	UseMacSettings   bool
+	ScannerProfile   scannerProfile // Command framing and trigger; unset is the tx profile
+	Bluetooth        bluetoothLink  // Connecting to a scanner paired over Bluetooth SPP
+	ReadTimeout      time.Duration  // How long to wait for a swipe
+	ScanIdleTimeout  time.Duration  // Pause that ends a swipe once data arrives
+	MaxScanTimeout   time.Duration  // Upper bound for ?timeout on /scanner/scan
+	PrintRateLimit   int            // Print requests per minute per client; 0 disables the limit
+	PrintBurst       int
+	MaxBodyBytes     int64         // Largest print request body; 0 disables the limit
+	DuplicateWindow  time.Duration // How long a printed receipt counts as a duplicate; 0 turns detection off
+	DuplicateAction  string        // dedupe.Banner or dedupe.Reject
+	Pipeline         renderPipeline // Output and renderer of print requests that don't name them
+	PrinterName      string
+	AppDir           string
+	DisplayPort      string // Customer pole display; empty when there isn't one
+	DisplayBaud      int
+	DisplayWidth     int
+	SignaturePort    string // Serial signature pad; empty when strokes come from the frontend
+	SignatureBaud    int
+	AgreementPrinter string // Document printer for agreements and invoices; empty uses the system default
+	PrinterFallback  string // -printer when it isn't installed and PrinterName fell back to the system default
+	AgreementFallback string // The same for -agreement-printer
+	TemplatesDir     string // Overrides for the built-in document templates
+	Templates        *tmplcache.Cache // Document templates, parsed from TemplatesDir
+	AdminToken       string // Bearer token for /admin; empty disables the admin endpoints
+}
+
+// setupRoutes registers the agent's HTTP endpoints
+func setupRoutes(opts agentOptions) *http.ServeMux {
+	mux := http.NewServeMux()
+	// API routes are served under /v1 and, deprecated, at their old paths;
+	// the documentation, /healthz and /readyz are not versioned
+	api := apiversion.New(mux, log.Default())
+	
+	// Scanner endpoint
+	api.HandleFunc("/scanner/scan", func(w http.ResponseWriter, r *http.Request) {
+		scannerHandler(w, r, opts)
+	})
+	api.HandleFunc("/scanner/status", func(w http.ResponseWriter, r *http.Request) {
+		scannerStatusHandler(w, r, opts)
+	})
+	api.HandleFunc("/scanner/info", func(w http.ResponseWriter, r *http.Request) {
+		scannerInfoHandler(w, r, opts)
+	})
+	api.HandleFunc("/scanner/simulate", scanReplayHandler)
+	api.HandleFunc("/scanner/validate", func(w http.ResponseWriter, r *http.Request) {
+		validateLicenseHandler(w, r, opts)
+	})
+	
+	// API documentation
+	mux.HandleFunc("/openapi.json", openAPIHandler)
+	mux.HandleFunc("/docs", docsHandler)
+	api.HandleFunc("/schema", schemaHandler)
+	
+	// Receipt printing endpoint
+	// Print jobs share one rate limit per client
+	printLimiter := limits.NewRateLimiter(opts.PrintRateLimit, opts.PrintBurst)
+	recentPrints := dedupe.New(opts.DuplicateWindow, opts.DuplicateAction)
+	api.HandleFunc("/print/receipt", limitRequests(printLimiter, opts.MaxBodyBytes, func(w http.ResponseWriter, r *http.Request) {
+		printReceiptHandler(w, r, opts.PrinterName, recentPrints, opts.Pipeline)
+	}))
+	
+	// Add a status endpoint
+	api.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "ok",
+			"version": agentVersion,
+			"appDir": opts.AppDir,
+			"printerFallback": opts.PrinterFallback != "",
+			"time": time.Now().Format(time.RFC3339),
+		})
+	})
+	
+	// Liveness and readiness for service recovery and monitoring
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		readyzHandler(w, r, opts)
+	})
+	
+	// Storage usage, including the receipt archive
+	api.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		statsHandler(w, r, opts.AppDir)
+	})
+	
+	// Archived receipt retrieval
+	api.HandleFunc("/archive/receipt", archivedReceiptHandler)
+	api.HandleFunc("/print/jobs/{id}/artifact", jobArtifactHandler)
+	api.HandleFunc("/print/jobs/{id}/retry", retryPrintJobHandler)
+	api.HandleFunc("/transactions/scans", scanLinksHandler)
+	
+	// Fleet management, behind the admin token
+	api.HandleFunc("/admin/config", requireAdmin(opts.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		adminConfigHandler(w, r, opts)
+	}))
+	api.HandleFunc("/admin/version", requireAdmin(opts.AdminToken, adminVersionHandler))
+	api.HandleFunc("/admin/logs/tail", requireAdmin(opts.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		adminLogsTailHandler(w, r, opts)
+	}))
+	api.HandleFunc("/admin/restart", requireAdmin(opts.AdminToken, adminRestartHandler))
+	api.HandleFunc("/admin/audit", requireAdmin(opts.AdminToken, auditHandler))
+	api.HandleFunc("/admin/benchmark", requireAdmin(opts.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		adminBenchmarkHandler(w, r, opts)
+	}))
+	
+	// Journal of scans, print jobs, drawer opens and failed requests for
+	// reconciliation
+	api.HandleFunc("/history/prints", func(w http.ResponseWriter, r *http.Request) {
+		historyHandler(w, r, journal.Print)
+	})
+	api.HandleFunc("/history/scans", func(w http.ResponseWriter, r *http.Request) {
+		historyHandler(w, r, journal.Scan)
+	})
+	api.HandleFunc("/history/drawer", func(w http.ResponseWriter, r *http.Request) {
+		historyHandler(w, r, journal.Drawer)
+	})
+	api.HandleFunc("/history/errors", func(w http.ResponseWriter, r *http.Request) {
+		historyHandler(w, r, journal.Error)
+	})
+	
+	// Customer pole display
+	api.HandleFunc("/display/show", func(w http.ResponseWriter, r *http.Request) {
+		displayShowHandler(w, r, opts)
+	})
+	api.HandleFunc("/display/clear", func(w http.ResponseWriter, r *http.Request) {
+		displayClearHandler(w, r, opts)
+	})
+	
+	// Signature capture
+	api.HandleFunc("/signature/start", func(w http.ResponseWriter, r *http.Request) {
+		signatureStartHandler(w, r, opts)
+	})
+	api.HandleFunc("/signature/points", signaturePointsHandler)
+	api.HandleFunc("/signature/stream", signatureStreamHandler)
+	api.HandleFunc("/signature/finish", func(w http.ResponseWriter, r *http.Request) {
+		signatureFinishHandler(w, r, opts)
+	})
+	api.HandleFunc("/signature/cancel", signatureCancelHandler)
+	
+	// Rental agreement printing
+	api.HandleFunc("/print/agreement", limitRequests(printLimiter, opts.MaxBodyBytes, func(w http.ResponseWriter, r *http.Request) {
+		printAgreementHandler(w, r, opts)
+	}))
+	
+	// Full-page invoices for account customers, on the same printer
+	api.HandleFunc("/print/invoice", limitRequests(printLimiter, opts.MaxBodyBytes, func(w http.ResponseWriter, r *http.Request) {
+		printInvoiceHandler(w, r, opts)
+	}))
+	api.HandleFunc("/print/statement", limitRequests(printLimiter, opts.MaxBodyBytes, func(w http.ResponseWriter, r *http.Request) {
+		printStatementHandler(w, r, opts)
+	}))
+	
+	// Checks a template before it is dropped into -templates
+	api.HandleFunc("/templates/validate", limitRequests(printLimiter, opts.MaxBodyBytes, validateTemplateHandler))
+	api.HandleFunc("/templates/{name}", requireAdmin(opts.AdminToken, func(w http.ResponseWriter, r *http.Request) {
+		templateFileHandler(w, r, opts)
+	}))
+	api.HandleFunc("/signature/image", func(w http.ResponseWriter, r *http.Request) {
+		signatureImageHandler(w, r, opts)
+	})
+	
+	return mux
+}
+
 func main() {
 	scannerPortFlag := flag.String("scanner-port", "CON3", "Scanner port (e.g., CON3, CON4)")
-	portFlag := flag.String("port", "COM4", "Serial port to connect to (e.g., COM1, /dev/ttyUSB0)")
+	portFlag := flag.String("port", "COM4", "Serial port to connect to (e.g., COM1, /dev/ttyUSB0), or tcp://host:port for a network scanner or serial device server")
 	httpPortFlag := flag.Int("http-port", 3500, "HTTP server port")
+	portFallbackFlag := flag.Int("port-fallback", 0, "When the HTTP port is taken, try this many following ports; the port used is written to <app dir>/"+portFileName)
 	useSimpleCommandFlag := flag.Bool("simple-command", true, "Use simple command format without port parameter")
+	flag.String("scanner-profile", defaultScannerProfile, "How the scanner is told to scan ("+scannerProfileNames()+"); -scan-prefix, -scan-suffix and -scan-trigger override its parts")
+	flag.String("scan-prefix", "", "Bytes sent before each scanner command, in hex (e.g. 01, or \"\" for none)")
+	flag.String("scan-suffix", "", "Bytes sent after each scanner command, in hex (e.g. 04, 0d0a)")
+	flag.String("scan-trigger", "", "Command that starts a scan, sent as it is between the prefix and suffix")
+	bluetoothFlag := flag.Bool("bluetooth", false, "The scanner is paired over Bluetooth SPP; needed on Windows, where its virtual COM port looks like any other (rfcomm and -SerialPort ports are recognised)")
+	bluetoothTimeoutFlag := flag.Duration("bluetooth-connect-timeout", 15*time.Second, "How long to keep trying to connect to a Bluetooth scanner that is asleep or reconnecting")
 	useMacSettingsFlag := flag.Bool("mac-settings", true, "Use Mac serial port settings (9600 baud, 8 data bits)")
-	readTimeoutFlag := flag.Int("timeout", 10, "Read timeout in seconds")
-	printerNameFlag := flag.String("printer", "Receipt1", "Printer name (default: Receipt1)")
+	readTimeoutFlag := flag.Int("timeout", 10, "Seconds to wait for a swipe; requests can ask for longer with ?timeout=")
+	maxTimeoutFlag := flag.Int("max-timeout", 60, "Longest ?timeout= a scan request may ask for, in seconds")
+	idleTimeoutFlag := flag.Duration("idle-timeout", 3*time.Second, "Pause in scanner data that ends a swipe (e.g. 500ms, 3s)")
+	printerNameFlag := flag.String("printer", "Receipt1", "Printer name, or a -printers alias (default: Receipt1)")
+	flag.Func("printers", `Printer aliases naming one printer on each OS, as JSON: {"receipt": {"windowsName": "...", "cupsName": "..."}}`, func(value string) error {
+		aliases, err := parsePrinterAliases(value)
+		if err != nil {
+			return err
+		}
+		printerAliases = aliases
+		return nil
+	})
+	taxConfigFlag := flag.String("tax-config", "", "Path to a JSON tax configuration (default: BC GST 5% / PST 7%)")
+	localeFlag := flag.String("locale", "en-CA", "Currency locale for receipt amounts ("+strings.Join(money.Locales(), ", ")+")")
+	currencyConfigFlag := flag.String("currency-config", "", "Path to a JSON currency format (symbol, separators, symbol position); overrides -locale")
+	languageFlag := flag.String("language", i18n.DefaultLanguage, "Default receipt language ("+strings.Join(i18n.Languages(), ", ")+"); requests can override it with \"language\"")
+	translationsFlag := flag.String("translations", "", "Path to a JSON file of extra or replacement receipt strings per language")
+	flag.Func("paper", "Receipt printer paper width, "+paper.Widths()+" (default: 80mm)", func(value string) error {
+		size, err := paper.Parse(value)
+		if err != nil {
+			return err
+		}
+		receiptPaper = size
+		return nil
+	})
+	messagesFlag := flag.String("messages", "", "Path to a JSON file of header and footer blocks (return policy, Wi-Fi, survey QR code) for every receipt")
+	scriptedFieldsFlag := flag.String("scripted-fields", "", "Path to a JSON file of scripted fields computed from each receipt before it prints, such as cash rounding or custom labels")
+	couponsFlag := flag.String("coupons", "", "Path to a JSON file of promotional coupons (text, barcode, expiry) printed after the footer of sales, with rotation rules")
+	merchantFlag := flag.String("merchant", "", "Path to a JSON file of the merchant's legal name, address, tax registration numbers and tax summary format, printed on every receipt")
+	archiveAfterFlag := flag.Int("archive-after-hours", 24, "Compress rendered receipts into the archive and delete the loose files after this many hours")
+	archiveRetentionFlag := flag.Int("archive-retention-days", 0, "Delete archived receipts older than this many days (0 keeps them forever)")
+	jobArtifactDaysFlag := flag.Int("job-artifact-days", 0, "Keep the exact document each print job produced (HTML, PDF or ESC/POS) for this many days, at /print/jobs/{id}/artifact (0 keeps none)")
+	displayPortFlag := flag.String("display-port", "", "Serial port of the customer pole display (e.g., COM5, /dev/ttyUSB1); empty disables /display")
+	displayBaudFlag := flag.Int("display-baud", 9600, "Customer display baud rate")
+	displayWidthFlag := flag.Int("display-width", display.DefaultWidth, "Characters per line on the customer display")
+	displayPromptsFlag := flag.String("display-prompts", "", "Path to a JSON file of the bilingual prompts shown on the customer display as IDs are scanned and receipts print (languages, per-prompt and per-location templates); empty shows none")
+	signaturePortFlag := flag.String("signature-port", "", "Serial port of the signature pad; empty takes strokes from the frontend (HID or on-screen pads)")
+	signatureBaudFlag := flag.Int("signature-baud", 19200, "Signature pad baud rate")
+	agreementPrinterFlag := flag.String("agreement-printer", "", "Printer for rental agreements and invoices (default: the system default printer)")
+	templatesFlag := flag.String("templates", "", "Directory of template overrides, e.g. receipt.html or agreement.html (default: <app dir>/templates)")
+	fontsFlag := flag.String("fonts", "", "Directory of the fonts embedded in printed documents: receipt-mono and receipt-sans, with optional -bold variants, as .woff2, .woff, .ttf or .otf; the bundled fonts fill in any missing (default: <app dir>/fonts)")
+	flag.StringVar(&appDirOverride, "app-dir", "", "Application directory for logs, temp files and the archive (default: C:\\GoScanRentalTide-main or /opt/GoScanRentalTide-main)")
+	printRateFlag := flag.Int("print-rate-limit", 30, "Print requests allowed per minute from one client (0 disables the limit)")
+	printBurstFlag := flag.Int("print-burst", 10, "Print requests one client may send at once before the rate limit applies")
+	maxBodyFlag := flag.Int("max-body-kb", 512, "Largest print request body in kilobytes")
+	duplicateWindowFlag := flag.Duration("duplicate-window", 2*time.Minute, "How long after a receipt prints that the same receipt again counts as a duplicate (0 turns detection off)")
+	receiptNumbersFlag := flag.Bool("receipt-numbers", false, "Number receipts in sequence, counting each station (stationId) separately, for jurisdictions that require it")
+	duplicateActionFlag := flag.String("duplicate-action", dedupe.Banner, "What to do with a duplicate receipt: banner (print it marked DUPLICATE) or reject")
+	outputFlag := flag.String("output", outputPDF, "Receipt output when a request doesn't name one: pdf (through a browser to the printer), thermal (ESC/POS sent raw) or html (returned to the caller)")
+	rendererFlag := flag.String("renderer", "", "Renderer when a request doesn't name one: edge, chrome, google-chrome or chromium for pdf output (default: the first one found)")
+	flag.DurationVar(&renderTimeout, "render-timeout", renderTimeout, "Longest a browser may take to convert a document to PDF before it is killed")
+	renderConcurrencyFlag := flag.Int("render-concurrency", cap(renderSlots), "Documents converted to PDF at once; more prints wait their turn")
+	flag.BoolVar(&warmRenderer, "warm-renderer", false, "Keep a headless browser running and print each PDF in a new tab over the DevTools protocol, for sub-second rendering")
+	flag.BoolFunc("privacy", "Mask license numbers in scan responses and keep personal details out of the logs", storeBoolFlag(&privacyMode))
+	flag.BoolFunc("debug-scans", "Include raw scanner data in scan responses", storeBoolFlag(&debugScans))
+	flag.BoolFunc("debug-payloads", "Log request and response bodies, with card, authorization and license details redacted, to <app dir>/logs/payloads.jsonl", storeBoolFlag(&debugPayloads))
+	scanKeyFileFlag := flag.String("scan-key-file", "", "File holding a 64 hex character key; when set, scans are saved encrypted under <app dir>/scans")
+	mqttBrokerFlag := flag.String("mqtt-broker", "", "MQTT broker to publish scans, print jobs and heartbeats to (e.g., tcp://broker:1883, ssl://broker:8883); empty disables MQTT")
+	mqttTopicFlag := flag.String("mqtt-topic", "rentaltide", "MQTT topic prefix; messages go under <prefix>/<station>/")
+	mqttStationFlag := flag.String("mqtt-station", "", "Station name in MQTT topics and the client ID (default: the host name)")
+	mqttUserFlag := flag.String("mqtt-user", "", "MQTT user name")
+	mqttPasswordFileFlag := flag.String("mqtt-password-file", "", "File holding the MQTT password")
+	mqttHeartbeatFlag := flag.Duration("mqtt-heartbeat", time.Minute, "Interval between MQTT health heartbeats")
+	trayFlag := flag.Bool("tray", false, "Show a status icon in the Windows notification area")
+	adminTokenFileFlag := flag.String("admin-token-file", "", "File holding the bearer token for the /admin endpoints; empty disables them")
+	webhookConfigFlag := flag.String("webhook-config", "", "Path to a JSON webhook configuration (endpoints, secrets, events); empty disables webhooks")
+	simulateFlag := flag.Bool("simulate", false, "Run without hardware: scans return canned swipes and documents are written to <app dir>/simulated-prints")
+	simulateFixtureFlag := flag.String("simulate-fixture", "bc", "Card the simulated scanner returns ("+strings.Join(simulate.Fixtures(), ", ")+", or "+simulate.Cycle+" for each in turn)")
+	simulateDelayFlag := flag.Duration("simulate-delay", time.Second, "Time the simulated customer takes to swipe")
+	configFlag := flag.String("config", "", "Settings file of flag values, written by `setup` (default: <app dir>/config.json); command-line flags override it")
+	
+	// `GoScanRentalTide setup [flags]` runs the interactive first-run setup
+	setupMode := len(os.Args) > 1 && os.Args[1] == "setup"
+	if setupMode {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 	flag.Parse()
 	
+	configPath := *configFlag
+	if configPath == "" {
+		configPath = filepath.Join(appDirPath(), configFileName)
+	}
+	if setupMode {
+		if err := runSetup(os.Stdin, os.Stdout, configPath); err != nil {
+			fmt.Printf("Setup failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := applyConfigFile(configPath, flag.CommandLine); err != nil {
+		fmt.Printf("Error loading settings: %v\n", err)
+		os.Exit(1)
+	}
+	
 	// Set up our application directory and logging
 	logFile, err := setupLogging()
 	if err != nil {
@@ -1405,44 +2998,382 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error creating app directory: %v", err)
 	}
+	payloadLog, err = payloadlog.Open(filepath.Join(appDir, "logs", "payloads.jsonl"), payloadLogMaxBytes, payloadLogKeep)
+	if err != nil {
+		log.Printf("Warning: payload logging unavailable: %v", err)
+	} else {
+		defer payloadLog.Close()
+	}
+	
+	
+	scanner, err := scannerProfileFromFlags(flag.CommandLine)
+	if err != nil {
+		log.Fatalf("Error in the scanner settings: %v", err)
+	}
+	duplicateAction, err := dedupe.ParseAction(*duplicateActionFlag)
+	if err != nil {
+		log.Fatalf("Error in -duplicate-action: %v", err)
+	}
+	if *renderConcurrencyFlag < 1 {
+		log.Fatalf("-render-concurrency must be at least 1")
+	}
+	renderSlots = make(chan struct{}, *renderConcurrencyFlag)
+	pipeline, err := parsePipeline(*outputFlag, *rendererFlag, renderPipeline{})
+	if err != nil {
+		log.Fatalf("Error in -output/-renderer: %v", err)
+	}
+	if *simulateFlag {
+		if err := startSimulation(*simulateFixtureFlag, *simulateDelayFlag, appDir, scanner.Trigger == ""); err != nil {
+			log.Fatalf("Error starting simulation: %v", err)
+		}
+		*portFlag = simulate.PortName
+	}
+	
+	if *taxConfigFlag != "" {
+		cfg, err := tax.Load(*taxConfigFlag)
+		if err != nil {
+			log.Fatalf("Error loading tax config: %v", err)
+		}
+		taxConfig = cfg
+		log.Printf("Loaded tax config from %s (%d rates)", *taxConfigFlag, len(cfg.Rates))
+	}
+	
+	if *messagesFlag != "" {
+		cfg, err := messages.Load(*messagesFlag)
+		if err != nil {
+			log.Fatalf("Error loading messages: %v", err)
+		}
+		receiptMessages = cfg
+		log.Printf("Loaded receipt messages from %s (%d header, %d footer)", *messagesFlag, len(cfg.Header), len(cfg.Footer))
+	}
+	
+	if *merchantFlag != "" {
+		info, err := merchant.Load(*merchantFlag)
+		if err != nil {
+			log.Fatalf("Error loading merchant config: %v", err)
+		}
+		merchantInfo = info
+		log.Printf("Loaded merchant legal details from %s (%d registrations)", *merchantFlag, len(info.Registrations))
+	}
+	
+	if *couponsFlag != "" {
+		cfg, err := coupons.Load(*couponsFlag)
+		if err != nil {
+			log.Fatalf("Error loading coupons: %v", err)
+		}
+		receiptCoupons = coupons.NewRotator(cfg)
+		log.Printf("Loaded %d coupons from %s", len(cfg.Coupons), *couponsFlag)
+	}
+	
+	if *scriptedFieldsFlag != "" {
+		cfg, err := script.Load(*scriptedFieldsFlag, scriptFuncs)
+		if err != nil {
+			log.Fatalf("Error loading scripted fields: %v", err)
+		}
+		scriptedFields = cfg
+		log.Printf("Loaded %d scripted fields from %s", len(cfg.Fields), *scriptedFieldsFlag)
+	}
+	
+	if *currencyConfigFlag != "" {
+		format, err := money.LoadFormat(*currencyConfigFlag)
+		if err != nil {
+			log.Fatalf("Error loading currency config: %v", err)
+		}
+		currencyFormat = format
+	} else {
+		format, err := money.Preset(*localeFlag)
+		if err != nil {
+			log.Fatalf("Error selecting locale: %v", err)
+		}
+		currencyFormat = format
+	}
+	log.Printf("Receipt amounts formatted like %s", currencyFormat.Format(1234.5))
+	
+	if *translationsFlag != "" {
+		if err := i18n.Load(*translationsFlag); err != nil {
+			log.Fatalf("Error loading translations: %v", err)
+		}
+		log.Printf("Loaded translations from %s", *translationsFlag)
+	}
+	if !i18n.Supported(*languageFlag) {
+		log.Printf("Warning: no strings for language %q, receipts will print in English", *languageFlag)
+	}
+	receiptLanguage = *languageFlag
+	
+	receiptArchive, err = archive.Open(filepath.Join(appDir, "archive"))
+	if err != nil {
+		log.Printf("Warning: receipt archive disabled: %v", err)
+	} else {
+		go runArchiveCompaction(filepath.Join(appDir, "temp"),
+			time.Duration(*archiveAfterFlag)*time.Hour,
+			time.Duration(*archiveRetentionFlag)*24*time.Hour)
+	}
+	if *jobArtifactDaysFlag > 0 {
+		jobArtifacts, err = archive.Open(filepath.Join(appDir, "jobs"))
+		if err != nil {
+			log.Printf("Warning: print job artifacts are not kept: %v", err)
+		} else {
+			go runJobArtifactExpiry(time.Duration(*jobArtifactDaysFlag) * 24 * time.Hour)
+		}
+	}
+	
+	if *scanKeyFileFlag != "" {
+		scanKey, err = seal.LoadKey(*scanKeyFileFlag)
+		if err != nil {
+			log.Fatalf("Error loading scan key: %v", err)
+		}
+		scanStoreDir = filepath.Join(appDir, "scans")
+	}
+	if privacyMode.Load() {
+		log.Printf("Privacy mode: license numbers are masked and personal details are not logged")
+	}
+	
+	transactionStore, err = txstore.Open(filepath.Join(appDir, "transactions"))
+	if err != nil {
+		log.Printf("Warning: scan linkage will not be recorded: %v", err)
+	}
+	eventJournal, err = journal.Open(filepath.Join(appDir, "journal"))
+	if err != nil {
+		log.Printf("Warning: scans and print jobs will not be journaled: %v", err)
+	}
+	auditLog, err = audit.Open(filepath.Join(appDir, "audit", "agent.jsonl"))
+	if err != nil {
+		log.Printf("Warning: no-sales will be refused and refunds will not be audited: %v", err)
+	}
+	if *receiptNumbersFlag {
+		// Receipts must not print unnumbered where numbers are required
+		receiptNumbers, err = sequence.Open(filepath.Join(appDir, receiptNumbersFile))
+		if err != nil {
+			log.Fatalf("Error opening receipt numbers: %v", err)
+		}
+	}
+	if *webhookConfigFlag != "" {
+		cfg, err := webhook.Load(*webhookConfigFlag)
+		if err != nil {
+			log.Fatalf("Error loading webhook config: %v", err)
+		}
+		webhooks = webhook.New(cfg)
+		log.Printf("Loaded webhook config from %s (%d endpoints)", *webhookConfigFlag, len(cfg.Endpoints))
+	}
+	var adminToken string
+	if *adminTokenFileFlag != "" {
+		adminToken, err = loadAdminToken(*adminTokenFileFlag)
+		if err != nil {
+			log.Fatalf("Error loading admin token: %v", err)
+		}
+	}
+	if *mqttBrokerFlag != "" {
+		station := *mqttStationFlag
+		if station == "" {
+			station, _ = os.Hostname()
+		}
+		if station == "" || strings.ContainsAny(station, "/+#") {
+			log.Fatalf("Invalid MQTT station name %q: set -mqtt-station", station)
+		}
+		if *mqttHeartbeatFlag <= 0 {
+			log.Fatalf("-mqtt-heartbeat must be positive")
+		}
+		var password string
+		if *mqttPasswordFileFlag != "" {
+			data, err := os.ReadFile(*mqttPasswordFileFlag)
+			if err != nil {
+				log.Fatalf("Error reading MQTT password: %v", err)
+			}
+			password = strings.TrimSpace(string(data))
+		}
+		topic := strings.TrimSuffix(*mqttTopicFlag, "/") + "/" + station
+		startMQTT(mqtt.Options{
+			Broker:   *mqttBrokerFlag,
+			ClientID: "goscan-" + station,
+			Username: *mqttUserFlag,
+			Password: password,
+		}, topic)
+		log.Printf("Publishing to MQTT broker %s under %s/", *mqttBrokerFlag, topic)
+	}
 	
 	readTimeout := time.Duration(*readTimeoutFlag) * time.Second
+	if *idleTimeoutFlag <= 0 {
+		log.Fatalf("-idle-timeout must be positive")
+	}
+	
+	templatesDir := *templatesFlag
+	if templatesDir == "" {
+		templatesDir = filepath.Join(appDir, "templates")
+	}
+	
+	fontsDir := *fontsFlag
+	if fontsDir == "" {
+		fontsDir = filepath.Join(appDir, "fonts")
+	} else if info, err := os.Stat(fontsDir); err != nil || !info.IsDir() {
+		log.Fatalf("Error loading fonts: -fonts %s is not a directory", fontsDir)
+	}
+	fonts, found, bundled, err := loadFonts(fontsDir)
+	if err != nil {
+		log.Fatalf("Error loading fonts: %v", err)
+	}
+	embeddedFonts = fonts
+	switch {
+	case len(found) == 0:
+		log.Printf("No fonts in %s; embedding the bundled fonts", fontsDir)
+	case len(bundled) > 0:
+		log.Printf("Embedding fonts from %s: %s", fontsDir, strings.Join(found, ", "))
+		log.Printf("Warning: %s has no %s; the bundled fonts stand in for them", fontsDir, strings.Join(bundled, ", "))
+	default:
+		log.Printf("Embedding fonts from %s: %s", fontsDir, strings.Join(found, ", "))
+	}
 	
 	log.Printf("Application directory: %s", appDir)
 	log.Printf("Starting with scanner port: %s, serial port: %s, HTTP port: %d, read timeout: %d seconds", 
 		*scannerPortFlag, *portFlag, *httpPortFlag, *readTimeoutFlag)
 	log.Printf("Simple command: %v, Mac settings: %v", *useSimpleCommandFlag, *useMacSettingsFlag)
-	log.Printf("Using printer: %s", *printerNameFlag)
-	
-	mux := http.NewServeMux()
+	log.Printf("Scanner profile: %s (prefix %x, suffix %x, trigger %q)", scanner.Name, scanner.Prefix, scanner.Suffix, scanner.Trigger)
+	log.Printf("Using printer: %s", printerLabel(*printerNameFlag, systemPrinterName(*printerNameFlag)))
+	printerName, printerFallback := resolvePrinter(*printerNameFlag)
+	agreementPrinter, agreementFallback := resolvePrinter(*agreementPrinterFlag)
+	if *displayPortFlag != "" {
+		log.Printf("Customer display: %s at %d baud", *displayPortFlag, *displayBaudFlag)
+	}
 	
-	// Scanner endpoint
-	mux.HandleFunc("/scanner/scan", func(w http.ResponseWriter, r *http.Request) {
-		scannerHandler(w, r, *portFlag, *scannerPortFlag, *useSimpleCommandFlag, *useMacSettingsFlag, readTimeout)
-	})
+	opts := agentOptions{
+		PortOverride:     *portFlag,
+		ScannerPort:      *scannerPortFlag,
+		UseSimpleCommand: *useSimpleCommandFlag,
+		UseMacSettings:   *useMacSettingsFlag,
+		ScannerProfile:   scanner,
+		Bluetooth:        bluetoothLink{Always: *bluetoothFlag, ConnectTimeout: *bluetoothTimeoutFlag},
+		ReadTimeout:      readTimeout,
+		ScanIdleTimeout:  *idleTimeoutFlag,
+		MaxScanTimeout:   max(time.Duration(*maxTimeoutFlag)*time.Second, readTimeout),
+		PrintRateLimit:   *printRateFlag,
+		PrintBurst:       *printBurstFlag,
+		MaxBodyBytes:     int64(*maxBodyFlag) << 10,
+		DuplicateWindow:  *duplicateWindowFlag,
+		DuplicateAction:  duplicateAction,
+		Pipeline:         pipeline,
+		PrinterName:      printerName,
+		AppDir:           appDir,
+		DisplayPort:      *displayPortFlag,
+		DisplayBaud:      *displayBaudFlag,
+		DisplayWidth:     *displayWidthFlag,
+		SignaturePort:    *signaturePortFlag,
+		SignatureBaud:    *signatureBaudFlag,
+		AgreementPrinter: agreementPrinter,
+		TemplatesDir:     templatesDir,
+		Templates:        newDocumentTemplates(templatesDir),
+		AdminToken:       adminToken,
+	}
+	if printerFallback {
+		opts.PrinterFallback = *printerNameFlag
+	}
+	if *displayPromptsFlag != "" {
+		if opts.DisplayPort == "" {
+			log.Fatalf("-display-prompts needs a customer display (-display-port)")
+		}
+		prompter, err := display.LoadPrompts(*displayPromptsFlag, opts.DisplayWidth)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		workflowPrompts = &promptDisplay{prompter: prompter, opts: opts}
+		log.Printf("Customer display prompts: %s", *displayPromptsFlag)
+	}
+	if agreementFallback {
+		opts.AgreementFallback = *agreementPrinterFlag
+	}
+	// Parse the templates now, so a mistake shows up here rather than on
+	// the first print. A broken custom template only stops its own
+	// documents, until the file is fixed.
+	receiptTemplates = opts.Templates
+	if err := receiptTemplates.Preload(receiptLanguage, "receipt.html"); err != nil {
+		log.Printf("Warning: receipts will fail to print: %v", err)
+	}
+	if err := opts.Templates.Preload(receiptLanguage, "agreement.html"); err != nil {
+		log.Printf("Warning: agreements will fail to print: %v", err)
+	}
+	if err := opts.Templates.Preload(receiptLanguage, "invoice.html"); err != nil {
+		log.Printf("Warning: invoices will fail to print: %v", err)
+	}
+	if err := opts.Templates.Preload(receiptLanguage, "statement.html"); err != nil {
+		log.Printf("Warning: statements will fail to print: %v", err)
+	}
+	opts.Templates.Watch(2 * time.Second)
 	
-	// Receipt printing endpoint
-	mux.HandleFunc("/print/receipt", func(w http.ResponseWriter, r *http.Request) {
-		printReceiptHandler(w, r, *printerNameFlag)
-	})
+	mux := setupRoutes(opts)
 	
-	// Add a status endpoint
-	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"status": "ok",
-			"version": "1.0.0",
-			"appDir": appDir,
-			"time": time.Now().Format(time.RFC3339),
-		})
-	})
+	// Another program (often a second copy of the agent) may hold the port
+	listener, err := listen.Listen(*httpPortFlag, *portFallbackFlag)
+	if err != nil {
+		log.Fatalf("Error starting server: %v", err)
+	}
+	if port := listen.Port(listener); port != *httpPortFlag {
+		log.Printf("Warning: port %d is in use, using port %d instead", *httpPortFlag, port)
+		*httpPortFlag = port
+	}
+	if err := listen.Register(filepath.Join(appDir, portFileName), "GoScanRentalTide", *httpPortFlag); err != nil {
+		log.Printf("Warning: failed to record the port in %s: %v", portFileName, err)
+	}
 	
 	log.Printf("Starting server on http://localhost:%d", *httpPortFlag)
-	log.Printf("Scanner endpoint: http://localhost:%d/scanner/scan", *httpPortFlag)
-	log.Printf("Receipt printer endpoint: http://localhost:%d/print/receipt", *httpPortFlag)
-	log.Printf("Status endpoint: http://localhost:%d/status", *httpPortFlag)
+	log.Printf("Scanner endpoint: http://localhost:%d/v1/scanner/scan", *httpPortFlag)
+	log.Printf("Receipt printer endpoint: http://localhost:%d/v1/print/receipt", *httpPortFlag)
+	log.Printf("Status endpoint: http://localhost:%d/v1/status", *httpPortFlag)
+	log.Printf("Health endpoints: http://localhost:%d/healthz, /readyz", *httpPortFlag)
+	log.Printf("Stats endpoint: http://localhost:%d/v1/stats", *httpPortFlag)
+	log.Printf("Customer display endpoints: http://localhost:%d/v1/display/show, /v1/display/clear", *httpPortFlag)
+	log.Printf("Signature endpoints: http://localhost:%d/v1/signature/start, /stream, /finish", *httpPortFlag)
+	log.Printf("Agreement endpoint: http://localhost:%d/v1/print/agreement", *httpPortFlag)
+	log.Printf("Invoice endpoint: http://localhost:%d/v1/print/invoice", *httpPortFlag)
+	log.Printf("Statement endpoint: http://localhost:%d/v1/print/statement", *httpPortFlag)
+	log.Printf("Template endpoints: http://localhost:%d/v1/templates/validate, /v1/templates/{name}", *httpPortFlag)
+	log.Printf("API documentation: http://localhost:%d/docs", *httpPortFlag)
+	log.Printf("Scan audit endpoint: http://localhost:%d/v1/transactions/scans?transactionId=...", *httpPortFlag)
+	
+	if *trayFlag {
+		go func() {
+			if err := runTray(opts); err != nil {
+				log.Printf("Warning: no tray icon: %v", err)
+			}
+		}()
+	}
+	if mqttPublisher != nil {
+		go runHeartbeats(*mqttHeartbeatFlag, *portFlag)
+	}
+	
+	if adminToken != "" {
+		log.Printf("Admin endpoints: http://localhost:%d/v1/admin/config, /version, /logs/tail, /restart, /audit, /benchmark", *httpPortFlag)
+	}
 	
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", *httpPortFlag), corsMiddleware(mux)); err != nil {
+	// Requests run under a context the restart cancels, so scans and
+	// prints still running give up their devices instead of holding it up
+	requests, cancelRequests := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler:     corsMiddleware(tracing.Middleware(journalErrors(payloadMiddleware(mux)), log.Default())),
+		BaseContext: func(net.Listener) context.Context { return requests },
+	}
+	restarting := make(chan struct{})
+	var restartOnce sync.Once
+	restartAgent = func() {
+		restartOnce.Do(func() {
+			close(restarting)
+			cancelRequests()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+		})
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
+	closeWarmBrowsers()
+	// Queued webhook events get a few seconds; a receiver that is down
+	// mustn't hold the restart up through its retries
+	drain, cancelDrain := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := webhooks.Close(drain); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	cancelDrain()
+	select {
+	case <-restarting:
+		restartProcess()
+	default:
+	}
 }
\ No newline at end of file
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"GoScanRentalTide/internal/apiversion"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// manualPrint is the status of a print job whose PDF no silent method could
+// print, and was opened on screen for someone to print by hand instead
+const manualPrint = "manual_intervention_required"
+
+// manualPrintError is returned by printHTMLDocument when it opened the PDF
+// for manual printing. The job is remembered, so /print/jobs/{id}/retry can
+// try printing it silently again once the printer is sorted out.
+type manualPrintError struct {
+	JobID         string
+	Path          string
+	Kind          string
+	TransactionID string
+	Printer       string
+	err           error // Why silent printing failed
+}
+
+func (e *manualPrintError) Error() string {
+	return fmt.Sprintf("automatic printing failed, opened PDF for manual printing at: %s", e.Path)
+}
+
+func (e *manualPrintError) Unwrap() error {
+	return e.err
+}
+
+var (
+	manualPrintsMu sync.Mutex
+	manualPrints   = make(map[string]*manualPrintError)
+)
+
+// rememberManualPrint keeps a job that needs manual printing for a retry
+func rememberManualPrint(e *manualPrintError) {
+	if e.JobID == "" {
+		return
+	}
+	manualPrintsMu.Lock()
+	defer manualPrintsMu.Unlock()
+	manualPrints[e.JobID] = e
+}
+
+// writeManualPrint answers a print request whose PDF was opened for manual
+// printing: accepted, but not printed
+func writeManualPrint(w http.ResponseWriter, e *manualPrintError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   manualPrint,
+		"message":  fmt.Sprintf("The %s could not be printed automatically and was opened for printing by hand", e.Kind),
+		"jobId":    e.JobID,
+		"pdfPath":  e.Path,
+		"retryUrl": apiversion.Prefix + "/print/jobs/" + e.JobID + "/retry",
+	})
+}
+
+// retryPrintJobHandler tries again to print silently the PDF of a job that
+// was opened for manual printing
+func retryPrintJobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+	id := r.PathValue("id")
+	manualPrintsMu.Lock()
+	job, ok := manualPrints[id]
+	manualPrintsMu.Unlock()
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("print job %s is not waiting for manual printing", id))
+		return
+	}
+	if _, err := os.Stat(job.Path); err != nil {
+		manualPrintsMu.Lock()
+		delete(manualPrints, id)
+		manualPrintsMu.Unlock()
+		writeJSONError(w, http.StatusGone, fmt.Errorf("the PDF of print job %s is gone; print the %s again", id, job.Kind))
+		return
+	}
+
+	entry := journal.Entry{
+		JobID:         id,
+		RequestID:     tracing.RequestID(r.Context()),
+		Kind:          journal.Print,
+		Status:        "success",
+		Document:      job.Kind,
+		TransactionID: job.TransactionID,
+		Printer:       job.Printer,
+		Output:        outputPDF,
+	}
+	if err := printPDF(r.Context(), job.Path, job.Kind, job.Printer); err != nil {
+		logf(r.Context(), "Retrying print job %s failed: %v", id, err)
+		entry.Status, entry.Error = "failed", err.Error()
+		recordJournal(entry)
+		writeJSONError(w, http.StatusBadGateway, fmt.Errorf("print job %s still can't be printed silently: %v", id, err))
+		return
+	}
+	manualPrintsMu.Lock()
+	delete(manualPrints, id)
+	manualPrintsMu.Unlock()
+	recordJournal(entry)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Printed %s %s", job.Kind, job.TransactionID),
+		"jobId":   id,
+	})
+}
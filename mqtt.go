@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/mqtt"
+)
+
+// mqttPublisher reports to a broker for central monitoring; nil when
+// -mqtt-broker is not set. Messages go under mqttTopic, which is
+// "<prefix>/<station>":
+//
+//	<topic>/status     "online", or "offline" (retained, also the will)
+//	<topic>/scan       every journaled scan
+//	<topic>/print      every journaled print job
+//	<topic>/heartbeat  agent health at -mqtt-heartbeat intervals
+var (
+	mqttPublisher *mqtt.Publisher
+	mqttTopic     string
+)
+
+// startMQTT connects to the broker in the background
+func startMQTT(opts mqtt.Options, topic string) {
+	mqttTopic = topic
+	status := topic + "/status"
+	opts.Will = &mqtt.Message{Topic: status, Payload: []byte("offline"), QoS: 1, Retain: true}
+	mqttPublisher = mqtt.NewPublisher(opts, mqtt.Message{Topic: status, Payload: []byte("online"), QoS: 1, Retain: true})
+}
+
+// publishMQTT queues v as JSON on a subtopic of the station topic
+func publishMQTT(subtopic string, v interface{}) {
+	if mqttPublisher == nil {
+		return
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("MQTT: can't encode %s message: %v", subtopic, err)
+		return
+	}
+	mqttPublisher.Publish(mqtt.Message{Topic: mqttTopic + "/" + subtopic, Payload: payload, QoS: 1})
+}
+
+// publishJournalEntry sends a scan or print job to its topic
+func publishJournalEntry(e journal.Entry) {
+	publishMQTT(e.Kind, e)
+}
+
+// runHeartbeats publishes the agent's health until the process exits
+func runHeartbeats(interval time.Duration, scannerPort string) {
+	for {
+		d := scannerDevice(scannerPort)
+		d.mu.Lock()
+		scanning, queued := !d.started.IsZero(), d.queued
+		d.mu.Unlock()
+
+		publishMQTT("heartbeat", map[string]interface{}{
+			"time":          time.Now().Format(time.RFC3339),
+			"version":       agentVersion,
+			"uptimeSeconds": int(time.Since(agentStarted).Seconds()),
+			"scanning":      scanning,
+			"scanQueue":     queued,
+		})
+		time.Sleep(interval)
+	}
+}
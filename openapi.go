@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"GoScanRentalTide/internal/archive"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/magstripe"
+	"GoScanRentalTide/internal/openapi"
+	"GoScanRentalTide/internal/signature"
+	"GoScanRentalTide/internal/txstore"
+	"GoScanRentalTide/internal/validate"
+)
+
+// Response bodies that the handlers build inline, described here for the
+// OpenAPI document
+
+// ErrorResponse is the body of every error
+type ErrorResponse struct {
+	Status    string          `json:"status"` // "error"
+	Message   string          `json:"message"`
+	Errors    validate.Errors `json:"errors,omitempty"`    // Every invalid field, when a request fails validation
+	Code      string          `json:"code,omitempty"`      // "swipe_again" when a scan was misread
+	RequestID string          `json:"requestId,omitempty"` // Also in the X-Request-ID header; quote it when reporting a failure
+}
+
+// ScanResponse is the result of /scanner/scan. Licenses fill in
+// licenseData and scanId; other magstripe cards fill in cardData.
+type ScanResponse struct {
+	Status       string          `json:"status"`             // "success", or "warning" when no fields were recognised
+	CardType     string          `json:"cardType,omitempty"` // "license" or "generic"; not set on a warning
+	LicenseData  *LicenseData    `json:"licenseData,omitempty"`
+	ScanID       string          `json:"scanId,omitempty"`
+	CardData     *magstripe.Card `json:"cardData,omitempty"`
+	Portrait     string          `json:"portrait,omitempty"` // Base64, with ?photo=true
+	PortraitType string          `json:"portraitType,omitempty"`
+	Complete     bool            `json:"complete"`          // Whether the scan ended with a whole record rather than mid-track or short of its AAMVA length
+	Message      string          `json:"message,omitempty"` // Why a scan is a warning
+	// How the scan was read, when no fields or not all of name, license
+	// number, birth and expiry dates could be
+	Diagnostics *ScanDiagnostics `json:"diagnostics,omitempty"`
+	// What the scanner sent, on a warning with -debug-scans
+	RawResponse    string `json:"rawResponse,omitempty"`
+	RawResponseHex string `json:"rawResponseHex,omitempty"`
+}
+
+// ScannerStatusResponse is the result of /scanner/status
+type ScannerStatusResponse struct {
+	Status    string `json:"status"`
+	Port      string `json:"port"`
+	Scanning  bool   `json:"scanning"`
+	Queued    int    `json:"queued"`
+	StartedAt string `json:"startedAt,omitempty"`
+}
+
+// PrintResponse is the result of the print endpoints
+type PrintResponse struct {
+	Status    string `json:"status"` // "success", or "manual_intervention_required"
+	Message   string `json:"message"`
+	JobID     string `json:"jobId,omitempty"`     // For /print/jobs/{id}/artifact
+	Warning   string `json:"warning,omitempty"`   // Receipt totals that don't add up
+	Duplicate bool   `json:"duplicate,omitempty"` // Printed with a DUPLICATE banner
+	// The number printed on the receipt, with -receipt-numbers
+	ReceiptNumber int64 `json:"receiptNumber,omitempty"`
+	// The rendered document, for html output, which the caller prints
+	HTML string `json:"html,omitempty"`
+	// With status manual_intervention_required: no silent printing method
+	// worked, so the PDF was opened on screen. POST to retryUrl to try
+	// printing it silently again.
+	PDFPath  string `json:"pdfPath,omitempty"`
+	RetryURL string `json:"retryUrl,omitempty"`
+}
+
+// StatusResponse is the result of /status
+type StatusResponse struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	AppDir  string `json:"appDir"`
+	Time    string `json:"time"`
+
+	// The configured printer isn't installed, so receipts go to the
+	// system default printer
+	PrinterFallback bool `json:"printerFallback"`
+}
+
+// StatsResponse is the result of /stats
+type StatsResponse struct {
+	Temp    DirUsage       `json:"temp"`
+	Logs    DirUsage       `json:"logs"`
+	Archive *archive.Usage `json:"archive,omitempty"`
+	Time    string         `json:"time"`
+}
+
+// DirUsage is the size of a directory
+type DirUsage struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// ScanLinksResponse is the result of /transactions/scans
+type ScanLinksResponse struct {
+	Status string         `json:"status"`
+	Links  []txstore.Link `json:"links"`
+}
+
+// HistoryResponse is the result of the /history endpoints
+type HistoryResponse struct {
+	Status  string          `json:"status"`
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Entries []journal.Entry `json:"entries"`
+}
+
+// DisplayResponse is the result of /display/show
+type DisplayResponse struct {
+	Status string `json:"status"`
+	Line1  string `json:"line1"`
+	Line2  string `json:"line2"`
+}
+
+// SignatureRequest names a signature capture
+type SignatureRequest struct {
+	ID string `json:"id"`
+}
+
+// SignaturePointsRequest adds strokes from a HID or on-screen pad
+type SignaturePointsRequest struct {
+	ID      string              `json:"id"`
+	Strokes [][]signature.Point `json:"strokes"`
+}
+
+// SignatureStartResponse is the result of /signature/start
+type SignatureStartResponse struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	Source string `json:"source"` // "serial" or "client"
+}
+
+// SignaturePointsResponse is the result of /signature/points
+type SignaturePointsResponse struct {
+	Status string `json:"status"`
+	Points int    `json:"points"`
+}
+
+// SignatureFinishResponse is the result of /signature/finish
+type SignatureFinishResponse struct {
+	Status  string `json:"status"`
+	ID      string `json:"id"`
+	Strokes int    `json:"strokes"`
+	PNG     string `json:"png"` // Base64
+	SVG     string `json:"svg"`
+}
+
+// AdminVersionResponse is the result of /admin/version
+type AdminVersionResponse struct {
+	Status        string `json:"status"`
+	Version       string `json:"version"`
+	GoVersion     string `json:"goVersion"`
+	OS            string `json:"os"`
+	Arch          string `json:"arch"`
+	StartedAt     string `json:"startedAt"`
+	UptimeSeconds int    `json:"uptimeSeconds"`
+	Revision      string `json:"revision,omitempty"` // From the VCS stamp of the build
+	BuildTime     string `json:"buildTime,omitempty"`
+}
+
+// AdminLogsResponse is the result of /admin/logs/tail
+type AdminLogsResponse struct {
+	Status string   `json:"status"`
+	File   string   `json:"file"`
+	Lines  []string `json:"lines"`
+}
+
+// MessageResponse is the result of endpoints that report success with a
+// message
+type MessageResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// SuccessResponse is the result of endpoints that only report success
+type SuccessResponse struct {
+	Status string `json:"status"`
+}
+
+// historyParams are the filters shared by the history endpoints
+var historyParams = []openapi.Param{
+	{Name: "from", Type: "string", Description: "Start date (YYYY-MM-DD) or RFC 3339 time"},
+	{Name: "to", Type: "string", Description: "End date (YYYY-MM-DD, inclusive) or RFC 3339 time"},
+	{Name: "operator", Type: "string", Description: "Only entries by this operatorName"},
+	{Name: "station", Type: "string", Description: "Only entries from this stationId"},
+	{Name: "requestId", Type: "string", Description: "Only entries made by the API call with this X-Request-ID"},
+	{Name: "limit", Type: "integer", Description: "Page size, default 50, at most 500"},
+	{Name: "offset", Type: "integer", Description: "Entries to skip"},
+}
+
+// templateNameParam is the {name} of the /templates endpoints
+var templateNameParam = []openapi.Param{
+	{Name: "name", Type: "string", Description: "receipt.html, agreement.html, invoice.html or statement.html"},
+}
+
+// adminAuth describes the admin endpoints' authentication
+const adminAuth = "Requires the -admin-token-file token as `Authorization: Bearer <token>`."
+
+// apiDescription introduces the API in the OpenAPI document
+const apiDescription = "Local agent for license scanning, receipt and agreement printing, the customer display and signature capture. " +
+	"Every /v1 path is also served without the prefix for older frontends, with Deprecation and Link headers pointing at the /v1 path."
+
+// apiSpec lists the agent's endpoints
+var apiSpec = openapi.Spec{
+	Title:       "GoScanRentalTide agent",
+	Version:     agentVersion,
+	Description: apiDescription,
+	Error:       ErrorResponse{},
+	Operations: []openapi.Operation{
+		{Method: "POST", Path: "/v1/scanner/scan", Summary: "Scan a license or magstripe card",
+			Query: []openapi.Param{
+				{Name: "timeout", Type: "integer", Description: "Seconds to wait for the swipe, up to -max-timeout"},
+				{Name: "queue", Type: "boolean", Description: "Wait for a scan in progress instead of failing with 409"},
+				{Name: "photo", Type: "boolean", Description: "Return the portrait embedded in the barcode"},
+				{Name: "operatorName", Type: "string", Description: "Staff member scanning, for the journal"},
+				{Name: "stationId", Type: "string", Description: "Till or counter scanning, for the journal"},
+			},
+			Response: ScanResponse{}},
+		{Method: "POST", Path: "/v1/scanner/simulate", Summary: "Parse scanner data as if it had been scanned",
+			Query:    []openapi.Param{{Name: "photo", Type: "boolean", Description: "Return the portrait embedded in the barcode"}},
+			Request:  ScanReplayRequest{},
+			Response: ScanResponse{}},
+		{Method: "POST", Path: "/v1/scanner/validate", Summary: "Check a license's class and endorsements against what a rental requires",
+			Description: "Checks the license of scanId, or scans one first when scanId is left out. Class codes differ by jurisdiction; the agent maps them to the vehicle categories car, motorcycle, truck, bus and tractor_trailer.",
+			Query: []openapi.Param{
+				{Name: "timeout", Type: "integer", Description: "Seconds to wait for the swipe when scanning, up to -max-timeout"},
+				{Name: "queue", Type: "boolean", Description: "Wait for a scan in progress instead of failing with 409"},
+			},
+			Request:  LicenseValidateRequest{},
+			Response: LicenseValidateResponse{}},
+		{Method: "GET", Path: "/v1/scanner/info", Summary: "Ask the scanner for its model, firmware and configuration", Response: ScannerInfoResponse{}},
+		{Method: "GET", Path: "/v1/scanner/status", Summary: "Report whether a scan is in progress", Response: ScannerStatusResponse{}},
+		{Method: "POST", Path: "/v1/print/receipt", Summary: "Print a receipt",
+			Description: "No-sale and refund receipts are written to the audit log, with the staff member named in the X-Operator-Id header. The same receipt sent again within -duplicate-window prints with a DUPLICATE banner, or fails with 409 when -duplicate-action is reject. output picks how the receipt is printed: pdf (converted by a browser, the renderer), thermal (ESC/POS sent raw) or html (returned in the response); -output and -renderer apply when it is left out. Sales end with coupons: the request's own, or the next ones of the -coupons rotation.",
+			Request:     ReceiptData{}, Response: PrintResponse{}},
+		{Method: "POST", Path: "/v1/print/agreement", Summary: "Print a rental agreement",
+			Description: "Agreements print as pdf, with the browser named by renderer, or are returned as html.",
+			Request:     AgreementData{}, Response: PrintResponse{}},
+		{Method: "POST", Path: "/v1/print/invoice", Summary: "Print a full-page invoice",
+			Description: "Invoices print on the -agreement-printer, billed to account when given or else to customer (or the customer of scanId). Tax is computed per line from the tax config; a table -merchant adds the VAT table. Like agreements, they print as pdf or are returned as html.",
+			Request:     InvoiceData{}, Response: PrintResponse{}},
+		{Method: "POST", Path: "/v1/print/statement", Summary: "Print an account statement",
+			Description: "Lists the period's transactions and payments by date with a running balance from openingBalance, and the balance history. pdf output prints a full page on the -agreement-printer, thermal output prints on the receipt printer, and html output is returned.",
+			Request:     StatementData{}, Response: PrintResponse{}},
+		{Method: "POST", Path: "/v1/templates/validate", Summary: "Check a template before putting it in the templates directory",
+			Description: "Parses the template with the template functions and follows its fields through the data it renders, every branch included. Reports unknown functions, fields the data doesn't have, and fields read through a value a request can leave out (such as location or a cardDetails entry) outside an {{if}} or {{with}} that tests for it, then renders sample, or a built-in sample, to catch the rest. Problems come back with a 200; valid is true when there are none.",
+			Request:     TemplateValidateRequest{}, Response: TemplateValidateResponse{}},
+		{Method: "GET", Path: "/v1/templates/{name}", Summary: "Fetch a template as the agent uses it",
+			Description:  adminAuth + " Returns the templates directory's copy, or the built-in template when there is none; the X-Template-Source header says custom or builtin.",
+			PathParams:   templateNameParam,
+			ResponseType: "text/html"},
+		{Method: "PUT", Path: "/v1/templates/{name}", Summary: "Replace a template in the templates directory",
+			Description: adminAuth + " The body is the template text. It is checked as /templates/validate checks it, with the built-in sample, and refused with 422 and the problems found unless it passes. Prints use the new template from the next one on.",
+			PathParams:  templateNameParam,
+			RequestType: "text/html",
+			Response:    TemplateUploadResponse{}},
+		{Method: "GET", Path: "/v1/status", Summary: "Agent status", Response: StatusResponse{}},
+		{Method: "GET", Path: "/healthz", Summary: "Liveness: the agent is running", Response: HealthResponse{}},
+		{Method: "GET", Path: "/readyz", Summary: "Readiness: scanner, printers, renderer and disk are usable (503 when not)", Response: ReadinessResponse{}},
+		{Method: "GET", Path: "/v1/stats", Summary: "Storage used by the app directory", Response: StatsResponse{}},
+		{Method: "GET", Path: "/v1/archive/receipt", Summary: "Fetch an archived receipt",
+			Query: []openapi.Param{
+				{Name: "name", Type: "string", Description: "Archived file name"},
+				{Name: "transactionId", Type: "string", Description: "Latest receipt for a transaction, when name is not given"},
+				{Name: "kind", Type: "string", Description: "pdf (default) or html"},
+			},
+			ResponseType: "application/pdf"},
+		{Method: "GET", Path: "/v1/print/jobs/{id}/artifact", Summary: "Fetch the exact document a print job produced, with -job-artifact-days",
+			PathParams:   []openapi.Param{{Name: "id", Type: "string", Required: true, Description: "The jobId of the print response"}},
+			Query:        []openapi.Param{{Name: "kind", Type: "string", Description: "html, pdf or escpos; default the one sent to the printer"}},
+			ResponseType: "application/pdf"},
+		{Method: "POST", Path: "/v1/print/jobs/{id}/retry", Summary: "Print silently a job that was opened for manual printing",
+			PathParams: []openapi.Param{{Name: "id", Type: "string", Required: true, Description: "The jobId of the print response"}},
+			Response:   PrintResponse{}},
+		{Method: "GET", Path: "/v1/transactions/scans", Summary: "List the ID scans recorded for a transaction",
+			Query:    []openapi.Param{{Name: "transactionId", Type: "string", Required: true}},
+			Response: ScanLinksResponse{}},
+		{Method: "GET", Path: "/v1/history/prints", Summary: "List journaled print jobs, newest first", Query: historyParams, Response: HistoryResponse{}},
+		{Method: "GET", Path: "/v1/history/scans", Summary: "List journaled scans, newest first", Query: historyParams, Response: HistoryResponse{}},
+		{Method: "GET", Path: "/v1/history/drawer", Summary: "List journaled cash drawer opens, newest first", Query: historyParams, Response: HistoryResponse{}},
+		{Method: "GET", Path: "/v1/history/errors", Summary: "List journaled failed requests, newest first", Query: historyParams, Response: HistoryResponse{}},
+		{Method: "POST", Path: "/v1/display/show", Summary: "Show two lines on the customer display", Request: DisplayRequest{}, Response: DisplayResponse{}},
+		{Method: "POST", Path: "/v1/display/clear", Summary: "Clear the customer display", Response: SuccessResponse{}},
+		{Method: "POST", Path: "/v1/signature/start", Summary: "Start a signature capture", Response: SignatureStartResponse{}},
+		{Method: "POST", Path: "/v1/signature/points", Summary: "Add strokes from a HID or on-screen pad", Request: SignaturePointsRequest{}, Response: SignaturePointsResponse{}},
+		{Method: "GET", Path: "/v1/signature/stream", Summary: "Stream strokes as server-sent events",
+			Query:        []openapi.Param{{Name: "id", Type: "string"}},
+			ResponseType: "text/event-stream"},
+		{Method: "POST", Path: "/v1/signature/finish", Summary: "Finish and save the signature", Request: SignatureRequest{}, Response: SignatureFinishResponse{}},
+		{Method: "POST", Path: "/v1/signature/cancel", Summary: "Abandon the signature capture", Response: SuccessResponse{}},
+		{Method: "GET", Path: "/v1/signature/image", Summary: "Fetch a saved signature",
+			Query: []openapi.Param{
+				{Name: "id", Type: "string", Required: true},
+				{Name: "format", Type: "string", Description: "png (default) or svg"},
+			},
+			ResponseType: "image/png"},
+		{Method: "GET", Path: "/v1/admin/config", Summary: "Report the agent's configuration", Description: adminAuth, Response: AdminConfig{}},
+		{Method: "POST", Path: "/v1/admin/config", Summary: "Change the settings that apply without a restart", Description: adminAuth,
+			Request: AdminConfigUpdate{}, Response: AdminConfig{}},
+		{Method: "GET", Path: "/v1/admin/version", Summary: "Report the agent build and platform", Description: adminAuth, Response: AdminVersionResponse{}},
+		{Method: "GET", Path: "/v1/admin/logs/tail", Summary: "Fetch the end of a day's log", Description: adminAuth,
+			Query: []openapi.Param{
+				{Name: "lines", Type: "integer", Description: "Lines to return, default 100, at most 2000"},
+				{Name: "date", Type: "string", Description: "Log date (YYYY-MM-DD), default today"},
+			},
+			Response: AdminLogsResponse{}},
+		{Method: "POST", Path: "/v1/admin/restart", Summary: "Restart the agent", Description: adminAuth, Response: MessageResponse{}},
+		{Method: "GET", Path: "/v1/admin/audit", Summary: "Fetch the audit log of no-sales and refunds and check it for tampering", Description: adminAuth, Response: AuditResponse{}},
+		{Method: "POST", Path: "/v1/admin/benchmark", Summary: "Render, and optionally print, a batch of synthetic receipts and report p50/p95 latency per backend",
+			Description: adminAuth, Request: BenchmarkRequest{}, Response: BenchmarkResponse{}},
+		{Method: "GET", Path: "/v1/schema", Summary: "JSON Schemas of the request and response bodies, for generating frontend types",
+			Query:        []openapi.Param{{Name: "type", Type: "string", Description: "One body by its schema name, e.g. ReceiptData; default all of them"}},
+			ResponseType: "application/schema+json"},
+		{Method: "GET", Path: "/openapi.json", Summary: "This document", ResponseType: "application/json"},
+		{Method: "GET", Path: "/docs", Summary: "Interactive API documentation", ResponseType: "text/html"},
+	},
+}
+
+// openAPIHandler serves the OpenAPI document
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(apiSpec.Document())
+}
+
+// schemaHandler serves the schemas of the OpenAPI document as JSON Schema,
+// for the frontends to generate their types from instead of keeping
+// copies of LicenseData, ReceiptData and the rest by hand
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	doc := apiSpec.JSONSchema()
+	if name := r.URL.Query().Get("type"); name != "" {
+		if _, ok := doc["definitions"].(map[string]interface{})[name]; !ok {
+			writeJSONError(w, http.StatusNotFound, fmt.Errorf("no schema named %q", name))
+			return
+		}
+		doc["title"] = name
+		doc["$ref"] = "#/definitions/" + name
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(doc)
+}
+
+// Swagger UI is loaded from a CDN; without internet access /openapi.json
+// can still be read directly
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>GoScanRentalTide agent API</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"><p>Loading… The raw document is at <a href="/openapi.json">/openapi.json</a>.</p></div>
+    <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+    <script>
+        window.onload = function () {
+            SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});
+        };
+    </script>
+</body>
+</html>
+`
+
+// docsHandler serves a minimal Swagger UI for the OpenAPI document
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(docsPage))
+}
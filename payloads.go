@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"GoScanRentalTide/internal/payloadlog"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// With -debug-payloads, or once it is switched on through /admin/config,
+// the bodies of every API request and response are written to
+// <app dir>/logs/payloads.jsonl, with card, authorization and license
+// details redacted, to diagnose a frontend that sends malformed payloads.
+// It is meant to be switched off again once the problem is found.
+var (
+	debugPayloads atomic.Bool
+	payloadLog    *payloadlog.Log // nil when the log couldn't be opened
+)
+
+const (
+	maxPayloadCapture  = 64 << 10 // Bytes of each body logged
+	payloadLogMaxBytes = 10 << 20 // Size the log is rotated at
+	payloadLogKeep     = 3        // Rotated logs kept
+)
+
+// payloadMiddleware logs the bodies of requests and their responses
+// while payload logging is on
+func payloadMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !debugPayloads.Load() || payloadLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		exchange := payloadlog.Exchange{
+			Time:        time.Now(),
+			RequestID:   tracing.RequestID(r.Context()),
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			RequestType: r.Header.Get("Content-Type"),
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			// The handler still reads the whole body, including what
+			// wasn't logged
+			captured, _ := io.ReadAll(io.LimitReader(r.Body, maxPayloadCapture+1))
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(captured), r.Body), r.Body}
+			if len(captured) > maxPayloadCapture {
+				captured, exchange.RequestTruncated = captured[:maxPayloadCapture], true
+			}
+			exchange.Request = loggableBody(exchange.RequestType, captured)
+		}
+
+		recorder := &payloadRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		exchange.Status = recorder.status
+		exchange.DurationMs = time.Since(exchange.Time).Milliseconds()
+		exchange.ResponseType = w.Header().Get("Content-Type")
+		exchange.Response = loggableBody(exchange.ResponseType, recorder.body.Bytes())
+		exchange.ResponseTruncated = recorder.truncated
+		if err := payloadLog.Write(exchange); err != nil {
+			logf(r.Context(), "Error logging payloads: %v", err)
+		}
+	})
+}
+
+// loggableBody returns a body as it is logged: text as it is (the log
+// redacts it), anything else, such as PDFs and images, only by size
+func loggableBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	textual := mediaType == "" || strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml") ||
+		mediaType == "application/x-www-form-urlencoded"
+	if !textual || !utf8.Valid(body) {
+		return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+	}
+	return string(body)
+}
+
+// payloadRecorder keeps the status and the start of the body of a
+// response as it is written
+type payloadRecorder struct {
+	http.ResponseWriter
+	status    int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (p *payloadRecorder) WriteHeader(status int) {
+	p.status = status
+	p.ResponseWriter.WriteHeader(status)
+}
+
+func (p *payloadRecorder) Write(data []byte) (int, error) {
+	if room := maxPayloadCapture - p.body.Len(); room < len(data) {
+		p.body.Write(data[:max(room, 0)])
+		p.truncated = true
+	} else {
+		p.body.Write(data)
+	}
+	return p.ResponseWriter.Write(data)
+}
+
+// Flush keeps server-sent events, such as the signature stream, working
+func (p *payloadRecorder) Flush() {
+	if flusher, ok := p.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection
+func (p *payloadRecorder) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
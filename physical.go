@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AAMVA eye color codes (DAY), as waiver forms spell them out
+var eyeColors = map[string]string{
+	"BLK": "Black",
+	"BLU": "Blue",
+	"BRO": "Brown",
+	"DIC": "Dichromatic",
+	"GRY": "Gray",
+	"GRN": "Green",
+	"HAZ": "Hazel",
+	"MAR": "Maroon",
+	"PNK": "Pink",
+	"UNK": "Unknown",
+}
+
+var (
+	// 070 in, 175 cm, 180cm, or a bare number
+	heightPattern = regexp.MustCompile(`^(\d{1,3})\s*(in|cm)?$`)
+	// 5-11, 5'11, 5'11" or 5 11
+	feetInchesPattern = regexp.MustCompile(`^(\d)\s*['\- ]\s*(\d{1,2})"?$`)
+)
+
+// describeLicense fills in the height in both centimetres and feet and
+// inches, the weight in both kilograms and pounds, and spells out the eye
+// color, from whichever form the card gave them in
+func describeLicense(license *LicenseData) {
+	if inches, ok := parseHeight(license.Height); ok {
+		license.HeightCm = int(math.Round(inches * 2.54))
+		whole := int(math.Round(inches))
+		license.HeightFtIn = fmt.Sprintf("%d'%d\"", whole/12, whole%12)
+	}
+	switch {
+	case license.WeightLb > 0 && license.WeightKg == 0:
+		license.WeightKg = int(math.Round(float64(license.WeightLb) * 0.45359237))
+	case license.WeightKg > 0 && license.WeightLb == 0:
+		license.WeightLb = int(math.Round(float64(license.WeightKg) / 0.45359237))
+	}
+	if name, ok := eyeColors[strings.ToUpper(license.EyeColor)]; ok {
+		license.EyeColor = name
+	}
+}
+
+// parseHeight reads a height as cards encode it, returning it in inches.
+// A bare number is taken as inches up to 96 and centimetres from 100 to
+// 272; a bare three-digit number past that, such as 511, is feet and
+// inches.
+func parseHeight(height string) (float64, bool) {
+	height = strings.ToLower(strings.TrimSpace(height))
+	if m := feetInchesPattern.FindStringSubmatch(height); m != nil {
+		feet, _ := strconv.Atoi(m[1])
+		inches, _ := strconv.Atoi(m[2])
+		if inches < 12 {
+			return float64(feet*12 + inches), true
+		}
+		return 0, false
+	}
+	m := heightPattern.FindStringSubmatch(height)
+	if m == nil {
+		return 0, false
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch {
+	case n == 0:
+		return 0, false
+	case m[2] == "in":
+		return float64(n), true
+	case m[2] == "cm":
+		return float64(n) / 2.54, true
+	case n <= 96:
+		return float64(n), true
+	case n >= 100 && n <= 272:
+		return float64(n) / 2.54, true
+	case len(m[1]) == 3 && n%100 < 12:
+		return float64(n/100*12 + n%100), true
+	}
+	return 0, false
+}
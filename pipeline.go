@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Outputs a print request can ask for with "output"
+const (
+	outputPDF     = "pdf"     // HTML converted to PDF by a browser and printed
+	outputThermal = "thermal" // ESC/POS text sent raw to a receipt printer
+	outputHTML    = "html"    // HTML returned to the caller, which prints it
+)
+
+// renderers lists the renderers of each output. Without one named, PDFs
+// are converted with the first browser that works, in this order.
+var renderers = map[string][]string{
+	outputPDF:     {"edge", "chrome", "google-chrome", "chromium"},
+	outputThermal: {"escpos"},
+	outputHTML:    {"html"},
+}
+
+// renderPipeline is how a document reaches the printer. The zero value is
+// the PDF pipeline with the first browser found.
+type renderPipeline struct {
+	Output   string
+	Renderer string // Empty picks the output's first working renderer
+}
+
+// parsePipeline checks an output and renderer, filling in what the
+// request left out from the configured defaults. A default renderer only
+// applies to its own output.
+func parsePipeline(output, renderer string, defaults renderPipeline) (renderPipeline, error) {
+	p := renderPipeline{Output: strings.ToLower(output), Renderer: strings.ToLower(renderer)}
+	if p.Output == "" {
+		p.Output = defaults.Output
+	}
+	if p.Output == "" {
+		p.Output = outputPDF
+	}
+	names, ok := renderers[p.Output]
+	if !ok {
+		return renderPipeline{}, fmt.Errorf("unknown output %q (use %s, %s or %s)", output, outputPDF, outputThermal, outputHTML)
+	}
+	if p.Renderer == "" && slices.Contains(names, defaults.Renderer) {
+		p.Renderer = defaults.Renderer
+	}
+	if p.Renderer != "" && !slices.Contains(names, p.Renderer) {
+		return renderPipeline{}, fmt.Errorf("renderer %q can't produce %s output (use %s)", renderer, p.Output, strings.Join(names, ", "))
+	}
+	return p, nil
+}
+
+// forDocuments returns the defaults of documents that only print as PDF
+// or HTML, such as agreements: PDF, with the configured renderer when it
+// is a browser
+func (p renderPipeline) forDocuments() renderPipeline {
+	if slices.Contains(renderers[outputPDF], p.Renderer) {
+		return renderPipeline{Output: outputPDF, Renderer: p.Renderer}
+	}
+	return renderPipeline{Output: outputPDF}
+}
+
+// pdfBrowser is a browser printHTMLDocument can convert HTML with
+type pdfBrowser struct {
+	renderer string // Name in renderers
+	label    string // For the logs
+	command  string
+}
+
+// pdfBrowsers returns the browsers to try for renderer, in order; an
+// empty renderer tries them all. Edge is only tried unasked on Windows,
+// where it is always installed.
+func pdfBrowsers(renderer string) []pdfBrowser {
+	var browsers []pdfBrowser
+	if runtime.GOOS == "windows" {
+		edgePath := "C:\\Program Files (x86)\\Microsoft\\Edge\\Application\\msedge.exe"
+		if _, err := os.Stat(edgePath); err != nil {
+			// Try the other common location
+			edgePath = "C:\\Program Files\\Microsoft\\Edge\\Application\\msedge.exe"
+		}
+		if _, err := os.Stat(edgePath); err == nil {
+			browsers = append(browsers, pdfBrowser{"edge", "Edge", edgePath})
+		}
+	} else if renderer == "edge" {
+		browsers = append(browsers, pdfBrowser{"edge", "Edge", "microsoft-edge"})
+	}
+	browsers = append(browsers,
+		pdfBrowser{"chrome", "Chrome", "chrome"},
+		pdfBrowser{"google-chrome", "Google Chrome", "google-chrome"},
+		pdfBrowser{"chromium", "Chromium", "chromium-browser"},
+	)
+	if renderer == "" {
+		return browsers
+	}
+	var picked []pdfBrowser
+	for _, b := range browsers {
+		if b.renderer == renderer {
+			picked = append(picked, b)
+		}
+	}
+	return picked
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+)
+
+// printerAlias is one logical printer under the names each spooler knows
+// it by, so a single config serves Windows and CUPS tills alike
+type printerAlias struct {
+	WindowsName string `json:"windowsName,omitempty"` // As listed by Get-Printer
+	CupsName    string `json:"cupsName,omitempty"`    // CUPS queue on macOS and Linux
+}
+
+// printerAliases are the -printers aliases, by logical name. -printer and
+// -agreement-printer may name one.
+var printerAliases map[string]printerAlias
+
+// parsePrinterAliases reads the -printers value, a JSON object such as
+//
+//	{"receipt": {"windowsName": "EPSON TM-T88V Receipt", "cupsName": "TM-T88V"}}
+func parsePrinterAliases(value string) (map[string]printerAlias, error) {
+	var aliases map[string]printerAlias
+	if err := json.Unmarshal([]byte(value), &aliases); err != nil {
+		return nil, fmt.Errorf("printers must be a JSON object of aliases: %v", err)
+	}
+	for name, alias := range aliases {
+		if alias.WindowsName == "" && alias.CupsName == "" {
+			return nil, fmt.Errorf("printer %q has neither a windowsName nor a cupsName", name)
+		}
+	}
+	return aliases, nil
+}
+
+// systemPrinterName returns the spooler's name for a configured printer:
+// its alias on this OS, or the name itself
+func systemPrinterName(name string) string {
+	alias, ok := printerAliases[name]
+	if !ok {
+		return name
+	}
+	system := alias.CupsName
+	if runtime.GOOS == "windows" {
+		system = alias.WindowsName
+	}
+	if system == "" {
+		return name
+	}
+	return system
+}
+
+// resolvePrinter returns the printer to send a configured printer's jobs
+// to. A printer the spooler doesn't list falls back to the system default
+// (""), with a warning, rather than failing every print; fellBack reports
+// it for /status and /readyz. A spooler that can't be asked is trusted.
+func resolvePrinter(name string) (printer string, fellBack bool) {
+	printer = systemPrinterName(name)
+	if printer == "" || simulatedPrintDir != "" {
+		return printer, false
+	}
+	installed, err := listPrinters()
+	if err != nil {
+		log.Printf("Warning: could not check that printer %s is installed: %v", printer, err)
+		return printer, false
+	}
+	for _, p := range installed {
+		// Windows printer names aren't case sensitive
+		if p == printer || (runtime.GOOS == "windows" && strings.EqualFold(p, printer)) {
+			return p, false
+		}
+	}
+	log.Printf("Warning: printer %s is not installed; printing to the system default printer instead", printerLabel(name, printer))
+	return "", true
+}
+
+// printerLabel names a configured printer with its alias on this OS
+func printerLabel(name, system string) string {
+	if system == name {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, system)
+}
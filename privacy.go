@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// Privacy settings. With -privacy, license numbers are masked in scan
+// responses and personal details are left out of the logs. Raw scanner
+// data is only returned with -debug-scans. Both can be changed at runtime
+// through /admin/config.
+var (
+	privacyMode atomic.Bool
+	debugScans  atomic.Bool
+)
+
+// redact hides a personal detail in logs when privacy mode is on
+func redact(value string) string {
+	if privacyMode.Load() && value != "" {
+		return "[redacted]"
+	}
+	return value
+}
+
+// redactLicense masks a license number in logs when privacy mode is on
+func redactLicense(number string) string {
+	if privacyMode.Load() {
+		return maskLicenseNumber(number)
+	}
+	return number
+}
+
+// publicLicense returns the license as it may be sent to the frontend
+func publicLicense(license LicenseData) LicenseData {
+	if !debugScans.Load() {
+		license.RawData = ""
+	}
+	if privacyMode.Load() {
+		license.LicenseNumber = maskLicenseNumber(license.LicenseNumber)
+		license.LicenseNumberRaw = maskLicenseNumber(license.LicenseNumberRaw)
+	}
+	return license
+}
+
+// storeBoolFlag sets an atomic flag from the command line
+func storeBoolFlag(b *atomic.Bool) func(string) error {
+	return func(value string) error {
+		v, err := strconv.ParseBool(value)
+		b.Store(v)
+		return err
+	}
+}
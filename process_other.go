@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// inProcessGroup runs cmd in a process group of its own and, when its
+// context ends, kills the whole group: a browser leaves GPU, renderer and
+// crashpad helpers behind if only the main process is killed. cmd must come
+// from exec.CommandContext, or it won't start
+func inProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 5 * time.Second
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"GoScanRentalTide/internal/sequence"
+)
+
+// receiptNumbers numbers receipts in sequence per station with
+// -receipt-numbers; nil when receipts aren't numbered
+var receiptNumbers *sequence.Counters
+
+// receiptNumbersFile holds the counters, in the app directory
+const receiptNumbersFile = "receipt-numbers.json"
+
+// numberReceipt gives a receipt the next number of its station
+// (stationId, or the agent's own when it has none). No-sales aren't
+// receipts of a sale, so they aren't numbered. The station's other
+// receipts wait until finishReceiptNumber says whether this one printed.
+func numberReceipt(ctx context.Context, receipt *ReceiptData) (*sequence.Reservation, error) {
+	if receiptNumbers == nil || receipt.Type == "noSale" {
+		return nil, nil
+	}
+	number, err := receiptNumbers.Reserve(ctx, receipt.StationID)
+	if err != nil {
+		return nil, fmt.Errorf("receipt not printed, it could not be numbered: %v", err)
+	}
+	receipt.ReceiptNumber = number.Number
+	return number, nil
+}
+
+// finishReceiptNumber keeps the number of a receipt that printed and
+// hands back the number of one that didn't, so the sequence doesn't skip
+// it
+func finishReceiptNumber(ctx context.Context, number *sequence.Reservation, printed bool) {
+	if err := number.Done(printed); err != nil {
+		logf(ctx, "Error releasing receipt number %d: %v", number.Number, err)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/cdp"
+)
+
+// Browsers converting HTML to PDF are the slowest and least predictable
+// part of printing. Each conversion runs under -render-timeout, in its own
+// process group so a browser stuck on a page is killed with every helper
+// process it started, and at most -render-concurrency run at once so a
+// burst of prints can't start a browser per request.
+var (
+	renderTimeout = 60 * time.Second
+	renderSlots   = make(chan struct{}, 2)
+)
+
+// With -warm-renderer, one headless browser per renderer is kept running
+// and each document is printed in a new tab over the DevTools protocol,
+// which takes well under a second instead of several. A browser that
+// fails or exits is replaced on the next print, and a conversion it
+// can't do falls back to starting the browser for the document.
+var (
+	warmRenderer  bool
+	warmBrowserMu sync.Mutex
+	warmBrowsers  = make(map[string]*cdp.Browser) // By command
+
+	// Warm browsers outlive the print that started them: they run under
+	// this context, which closeWarmBrowsers cancels to kill their process
+	// groups on shutdown
+	warmBrowserCtx, stopWarmBrowsers = context.WithCancel(context.Background())
+)
+
+// launchWarmBrowser starts a browser for the warm renderer, with
+// warmBrowserMu held; replaced by the end-to-end tests
+var launchWarmBrowser = func(ctx context.Context, command string) (*cdp.Browser, error) {
+	cmd := exec.CommandContext(warmBrowserCtx, command)
+	inProcessGroup(cmd)
+	return cdp.Launch(ctx, cmd)
+}
+
+// acquireRenderSlot waits for one of the -render-concurrency conversions
+// to finish, or for ctx to end
+func acquireRenderSlot(ctx context.Context) (release func(), err error) {
+	select {
+	case renderSlots <- struct{}{}:
+		return func() { <-renderSlots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// convertToPDF renders htmlPath to pdfPath with the first of renderer's
+// browsers that works
+func convertToPDF(ctx context.Context, htmlPath, pdfPath, renderer string) error {
+	browsers := pdfBrowsers(renderer)
+	if len(browsers) == 0 {
+		return fmt.Errorf("error converting HTML to PDF: renderer %s is not installed", renderer)
+	}
+	release, err := acquireRenderSlot(ctx)
+	if err != nil {
+		return fmt.Errorf("PDF conversion cancelled: %w", err)
+	}
+	defer release()
+
+	if warmRenderer {
+		for _, browser := range browsers {
+			if _, err := lookPath(browser.command); err != nil {
+				continue
+			}
+			err := renderWarm(ctx, browser, htmlPath, pdfPath)
+			if err == nil {
+				logf(ctx, "PDF successfully generated with warm %s: %s", browser.label, pdfPath)
+				return nil
+			}
+			if ctx.Err() != nil {
+				return fmt.Errorf("PDF conversion cancelled: %w", ctx.Err())
+			}
+			logf(ctx, "Warm %s failed, starting it for this document: %v", browser.label, err)
+			break
+		}
+	}
+
+	var output []byte
+	var browserErr error
+	for _, browser := range browsers {
+		runCtx, cancel := context.WithTimeout(ctx, renderTimeout)
+		output, browserErr = runCommand(runCtx, browser.command, "--headless", "--disable-gpu", "--no-margins", "--print-to-pdf="+pdfPath, htmlPath)
+		timedOut := errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+		if browserErr == nil {
+			logf(ctx, "PDF successfully generated with %s: %s", browser.label, pdfPath)
+			logf(ctx, "PDF successfully generated with %s: %s\n", browser.label, pdfPath)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("PDF conversion cancelled: %w", ctx.Err())
+		}
+		if timedOut {
+			browserErr = fmt.Errorf("%s timed out after %v", browser.label, renderTimeout)
+		}
+		logf(ctx, "%s failed: %v\n%s", browser.label, browserErr, string(output))
+	}
+	return fmt.Errorf("error converting HTML to PDF: no compatible browser found\nLast error: %v\nOutput: %s",
+		browserErr, string(output))
+}
+
+// renderWarm prints htmlPath to pdfPath in a tab of the browser's warm
+// instance, starting it if it isn't running
+func renderWarm(ctx context.Context, browser pdfBrowser, htmlPath, pdfPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, renderTimeout)
+	defer cancel()
+
+	warmBrowserMu.Lock()
+	b := warmBrowsers[browser.command]
+	if b != nil && !b.Alive() {
+		b.Close()
+		b = nil
+	}
+	if b == nil {
+		var err error
+		b, err = launchWarmBrowser(ctx, browser.command)
+		if err != nil {
+			warmBrowserMu.Unlock()
+			return err
+		}
+		logf(ctx, "Started warm %s for PDF rendering", browser.label)
+		warmBrowsers[browser.command] = b
+	}
+	warmBrowserMu.Unlock()
+
+	pdf, err := b.PrintToPDF(ctx, fileURL(htmlPath), cdp.PDFOptions{})
+	if err != nil {
+		// A browser that stopped answering is replaced rather than reused
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, cdp.ErrClosed) {
+			warmBrowserMu.Lock()
+			if warmBrowsers[browser.command] == b {
+				delete(warmBrowsers, browser.command)
+				go b.Close()
+			}
+			warmBrowserMu.Unlock()
+		}
+		return err
+	}
+	return os.WriteFile(pdfPath, pdf, 0644)
+}
+
+// closeWarmBrowsers stops the warm renderer's browsers, on shutdown
+func closeWarmBrowsers() {
+	warmBrowserMu.Lock()
+	defer warmBrowserMu.Unlock()
+	for command, b := range warmBrowsers {
+		b.Close()
+		delete(warmBrowsers, command)
+	}
+	stopWarmBrowsers()
+	warmBrowserCtx, stopWarmBrowsers = context.WithCancel(context.Background())
+}
+
+// fileURL is the file:// URL of a local path, as a browser opens it
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.ToSlash(path)
+	if path[0] != '/' {
+		path = "/" + path // C:/... on Windows
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// scanDevice serialises scans on one scanner port. Two scans at once
+// would both try to open the port and the second would fail confusingly.
+type scanDevice struct {
+	token chan struct{} // Holds a value while a scan runs
+
+	mu      sync.Mutex
+	started time.Time
+	queued  int
+}
+
+var (
+	scanDevicesMu sync.Mutex
+	scanDevices   = make(map[string]*scanDevice)
+)
+
+// scannerDevice returns the lock for a port; "" is the auto-detected port
+func scannerDevice(port string) *scanDevice {
+	scanDevicesMu.Lock()
+	defer scanDevicesMu.Unlock()
+	d, ok := scanDevices[port]
+	if !ok {
+		d = &scanDevice{token: make(chan struct{}, 1)}
+		scanDevices[port] = d
+	}
+	return d
+}
+
+// tryAcquire starts a scan if none is running
+func (d *scanDevice) tryAcquire() bool {
+	select {
+	case d.token <- struct{}{}:
+		d.begin()
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire waits for the running scan to finish, until ctx is done
+func (d *scanDevice) acquire(ctx context.Context) error {
+	d.mu.Lock()
+	d.queued++
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.queued--
+		d.mu.Unlock()
+	}()
+
+	select {
+	case d.token <- struct{}{}:
+		d.begin()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *scanDevice) begin() {
+	d.mu.Lock()
+	d.started = time.Now()
+	d.mu.Unlock()
+}
+
+// release ends the scan
+func (d *scanDevice) release() {
+	d.mu.Lock()
+	d.started = time.Time{}
+	d.mu.Unlock()
+	<-d.token
+}
+
+// scannerStatusHandler reports whether a scan is in progress
+func scannerStatusHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	d := scannerDevice(opts.PortOverride)
+	d.mu.Lock()
+	started, queued := d.started, d.queued
+	d.mu.Unlock()
+
+	port := opts.PortOverride
+	if port == "" {
+		port = "auto"
+	}
+	resp := map[string]interface{}{
+		"status":   "success",
+		"port":     port,
+		"scanning": !started.IsZero(),
+		"queued":   queued,
+	}
+	if !started.IsZero() {
+		resp["startedAt"] = started.Format(time.RFC3339)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/transport"
+)
+
+// Queries the scanner answers about itself, in its command protocol
+var scannerInfoQueries = []struct {
+	field, command string
+}{
+	{"model", "TXMODEL"},
+	{"firmware", "TXVER"},
+	{"configuration", "TXCFG"},
+}
+
+// How long the scanner has to start answering a query
+const scannerInfoTimeout = time.Second
+
+// ScannerInfoResponse is the result of /scanner/info: what the scanner
+// says it is, and how the agent talks to it, for support tickets
+type ScannerInfoResponse struct {
+	Status        string            `json:"status"`            // "success", or "warning" when the scanner answered none of the queries
+	Message       string            `json:"message,omitempty"` // Why it is a warning
+	Port          string            `json:"port"`
+	BaudRate      int               `json:"baudRate"`
+	DataBits      int               `json:"dataBits"`
+	CommandFormat string            `json:"commandFormat"` // "simple" (<CMD>), "port" (<CMD,port>) or "passive" (no commands)
+	Model         string            `json:"model,omitempty"`
+	Firmware      string            `json:"firmware,omitempty"`
+	Configuration map[string]string `json:"configuration,omitempty"` // Settings the scanner reported as name=value
+	Replies       map[string]string `json:"replies"`                 // Each query and the scanner's reply as sent, "" when it didn't answer
+}
+
+// scannerInfoHandler asks the scanner for its model, firmware and
+// configuration. It takes the scanner like a scan does, so it fails with
+// 409 while one is in progress.
+func scannerInfoHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only GET method is allowed"))
+		return
+	}
+	device := scannerDevice(opts.PortOverride)
+	if !device.tryAcquire() {
+		writeJSONError(w, http.StatusConflict, errors.New("a scan is in progress on this scanner"))
+		return
+	}
+	defer device.release()
+
+	portName, err := findScannerPort(opts.PortOverride)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	mode := scannerMode(opts.UseMacSettings)
+	port, err := openScannerPort(portName, mode, opts.Bluetooth)
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("open port %s failed: %w", portName, err))
+		return
+	}
+	defer port.Close()
+
+	resp := ScannerInfoResponse{
+		Status:        "success",
+		Port:          portName,
+		BaudRate:      mode.BaudRate,
+		DataBits:      mode.DataBits,
+		CommandFormat: "port",
+		Replies:       make(map[string]string),
+	}
+	if opts.UseSimpleCommand {
+		resp.CommandFormat = "simple"
+	}
+	if opts.scanner().Trigger == "" {
+		// Passive readers only ever send swipes
+		resp.CommandFormat, resp.Status = "passive", "warning"
+		resp.Message = "the scanner is passive: it takes no commands, so it can't be asked about itself"
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	answered := 0
+	for _, query := range scannerInfoQueries {
+		command := scannerCommand(query.command, opts)
+		reply, err := queryScanner(port, opts.scanner().frame(command), opts.ScanIdleTimeout)
+		if err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("querying the scanner on %s: %w", portName, err))
+			return
+		}
+		resp.Replies[command] = reply
+		value := scannerReplyText(reply)
+		if value == "" {
+			continue
+		}
+		answered++
+		switch query.field {
+		case "model":
+			resp.Model = value
+		case "firmware":
+			resp.Firmware = value
+		case "configuration":
+			resp.Configuration = scannerSettings(value)
+		}
+	}
+	if answered == 0 {
+		resp.Status = "warning"
+		resp.Message = "the scanner did not answer any query; it may not support them, or the serial settings may be wrong"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// queryScanner sends a command and returns the reply, which ends when the
+// scanner goes quiet for idleTimeout. It returns "" when the scanner
+// doesn't start answering within scannerInfoTimeout.
+func queryScanner(port transport.Conn, command []byte, idleTimeout time.Duration) (string, error) {
+	if _, err := port.Write(command); err != nil {
+		return "", err
+	}
+	var reply bytes.Buffer
+	buf := make([]byte, 128)
+	wait := scannerInfoTimeout
+	for reply.Len() < maxScanBytes {
+		n, err := readWithTimeout(port, buf, wait)
+		if err != nil {
+			if errors.Is(err, transport.ErrTimeout) {
+				break
+			}
+			return "", err
+		}
+		reply.Write(buf[:n])
+		wait = idleTimeout
+	}
+	return reply.String(), nil
+}
+
+// scannerReplyText strips the framing and control characters from a
+// reply; a NAK alone, a refused command, leaves nothing
+func scannerReplyText(reply string) string {
+	text := strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' {
+			return -1
+		}
+		return r
+	}, reply)
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "<") && strings.HasSuffix(text, ">") {
+		text = strings.TrimSpace(text[1 : len(text)-1])
+	}
+	return text
+}
+
+// scannerSettings reads a configuration reply: name=value (or name:value)
+// pairs separated by commas, semicolons or lines
+func scannerSettings(text string) map[string]string {
+	settings := make(map[string]string)
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ';' || r == '\n' }) {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			name, value, ok = strings.Cut(field, ":")
+		}
+		if ok {
+			settings[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return settings
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"go.bug.st/serial"
+
+	"GoScanRentalTide/internal/transport"
+)
+
+// Scanners can also be reached over the network, as tcp://host:port:
+// through a serial device server in raw TCP mode, or an Ethernet scanner.
+// The server's own settings fix the baud rate and framing.
+const networkDialTimeout = 5 * time.Second
+
+// scannerTransport returns how a scanner port is reached: a tcp://
+// address over the network, anything else as a serial port
+func scannerTransport(name string) transport.Transport {
+	if transport.IsAddress(name) {
+		return transport.TCP{DialTimeout: networkDialTimeout}
+	}
+	return serialTransport
+}
+
+// openScannerPort opens the scanner's port. Bluetooth ports, those named
+// like one or any with -bluetooth, are retried until the link's connect
+// timeout has passed, backing off from a quarter second to two seconds
+// between attempts.
+func openScannerPort(name string, mode *serial.Mode, link bluetoothLink) (transport.Conn, error) {
+	tr := scannerTransport(name)
+	port, err := tr.Open(name, mode)
+	if err == nil || transport.IsAddress(name) || !(link.Always || isBluetoothPort(name)) {
+		return port, err
+	}
+	deadline := time.Now().Add(link.ConnectTimeout)
+	backoff := 250 * time.Millisecond
+	for time.Now().Add(backoff).Before(deadline) {
+		fmt.Printf("Bluetooth scanner on %s is not connected yet (%v), retrying in %v\n", name, err, backoff)
+		time.Sleep(backoff)
+		if port, err = tr.Open(name, mode); err == nil {
+			fmt.Printf("Bluetooth scanner on %s connected\n", name)
+			return port, nil
+		}
+		backoff = min(backoff*2, 2*time.Second)
+	}
+	return nil, fmt.Errorf("the Bluetooth scanner on %s did not connect within %v; check that it is on, in range and paired: %w", name, link.ConnectTimeout, err)
+}
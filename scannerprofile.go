@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// scannerProfile is how a brand of scanner is told to scan: the bytes
+// framing each command and the command that triggers a scan
+type scannerProfile struct {
+	Name      string
+	Prefix    []byte // Sent before every command
+	Suffix    []byte // Sent after it
+	Trigger   string // Starts a scan; "" for scanners that send swipes without being asked
+	Bracketed bool   // Commands are written <NAME>, or <NAME,port> without -simple-command
+}
+
+// Built-in profiles. tx is the TX command set the agent was written for:
+// <TXPING> between SOH and EOT. passive scanners send every swipe on
+// their own, so nothing is written to them.
+var scannerProfiles = map[string]scannerProfile{
+	"tx":      {Name: "tx", Prefix: []byte{0x01}, Suffix: []byte{0x04}, Trigger: "TXPING", Bracketed: true},
+	"passive": {Name: "passive"},
+}
+
+const defaultScannerProfile = "tx"
+
+// scannerProfileNames lists the built-in profiles for flag help
+func scannerProfileNames() string {
+	names := make([]string, 0, len(scannerProfiles))
+	for name := range scannerProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// scanner returns the scanner's profile: the one configured, or tx
+func (opts agentOptions) scanner() scannerProfile {
+	if opts.ScannerProfile.Name == "" {
+		return scannerProfiles[defaultScannerProfile]
+	}
+	return opts.ScannerProfile
+}
+
+// frame wraps a command in the profile's prefix and suffix
+func (p scannerProfile) frame(command string) []byte {
+	framed := append([]byte(nil), p.Prefix...)
+	framed = append(framed, command...)
+	return append(framed, p.Suffix...)
+}
+
+// scannerProfileFromFlags builds the scanner profile from
+// -scanner-profile and whichever of -scan-prefix, -scan-suffix and
+// -scan-trigger were set, on the command line or in the config file
+func scannerProfileFromFlags(flags *flag.FlagSet) (scannerProfile, error) {
+	name := flags.Lookup("scanner-profile").Value.String()
+	profile, ok := scannerProfiles[name]
+	if !ok {
+		return scannerProfile{}, fmt.Errorf("unknown scanner profile %q (choose from %s)", name, scannerProfileNames())
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		switch f.Name {
+		case "scan-prefix":
+			profile.Name = "custom"
+			if profile.Prefix, err = hexBytes(f.Value.String()); err != nil {
+				err = fmt.Errorf("invalid -scan-prefix: %v", err)
+			}
+		case "scan-suffix":
+			profile.Name = "custom"
+			if profile.Suffix, err = hexBytes(f.Value.String()); err != nil {
+				err = fmt.Errorf("invalid -scan-suffix: %v", err)
+			}
+		case "scan-trigger":
+			// A trigger given in full is sent as it is
+			profile.Name, profile.Trigger, profile.Bracketed = "custom", f.Value.String(), false
+		}
+	})
+	return profile, err
+}
+
+// hexBytes reads bytes written in hex, such as 01, 0x0d0a or "1b 40"
+func hexBytes(s string) ([]byte, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return hex.DecodeString(s)
+}
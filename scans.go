@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/seal"
+	"GoScanRentalTide/internal/txstore"
+)
+
+// How long a scan can be referenced by a print request. A checkout that
+// takes longer has to scan the ID again.
+const scanRetention = 30 * time.Minute
+
+// verifiedScan is a license read by /scanner/scan, kept in memory so a
+// following print request can reference it by ID
+type verifiedScan struct {
+	ID      string      `json:"id"`
+	License LicenseData `json:"license"`
+	Scanned time.Time   `json:"scanned"`
+}
+
+var (
+	scansMu     sync.Mutex
+	recentScans = make(map[string]verifiedScan)
+)
+
+// Scans are persisted only when a key is configured (-scan-key-file), and
+// then always encrypted, so they survive an agent restart and can be
+// produced for an audit
+var (
+	scanKey      []byte
+	scanStoreDir string
+)
+
+// Scan IDs are used in file names, so only these are accepted
+var scanIDPattern = regexp.MustCompile(`^scan-[0-9a-f]{16}$`)
+
+// transactionStore records which scan each printed document was issued
+// against; nil when the store could not be opened
+var transactionStore *txstore.Store
+
+// rememberScan keeps a license for later print requests and returns its
+// scan ID
+func rememberScan(license LicenseData) string {
+	var b [8]byte
+	rand.Read(b[:])
+	scan := verifiedScan{
+		ID:      "scan-" + hex.EncodeToString(b[:]),
+		License: license,
+		Scanned: time.Now(),
+	}
+
+	scansMu.Lock()
+	defer scansMu.Unlock()
+	for id, s := range recentScans {
+		if time.Since(s.Scanned) > scanRetention {
+			delete(recentScans, id)
+		}
+	}
+	recentScans[scan.ID] = scan
+
+	if scanKey != nil && scanStoreDir != "" {
+		if err := persistScan(scan); err != nil {
+			log.Printf("Error saving scan %s: %v", scan.ID, err)
+		}
+	}
+	return scan.ID
+}
+
+// persistScan writes an encrypted copy of a scan
+func persistScan(scan verifiedScan) error {
+	data, err := json.Marshal(scan)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal.Seal(scanKey, data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(scanStoreDir, 0700); err != nil {
+		return fmt.Errorf("failed to create scan directory: %v", err)
+	}
+	return os.WriteFile(filepath.Join(scanStoreDir, scan.ID+".sealed"), sealed, 0600)
+}
+
+// loadPersistedScan reads a scan saved by persistScan
+func loadPersistedScan(id string) (verifiedScan, bool) {
+	if scanKey == nil || scanStoreDir == "" || !scanIDPattern.MatchString(id) {
+		return verifiedScan{}, false
+	}
+	sealed, err := os.ReadFile(filepath.Join(scanStoreDir, id+".sealed"))
+	if err != nil {
+		return verifiedScan{}, false
+	}
+	data, err := seal.Open(scanKey, sealed)
+	if err != nil {
+		log.Printf("Error reading saved scan %s: %v", id, err)
+		return verifiedScan{}, false
+	}
+	var scan verifiedScan
+	if err := json.Unmarshal(data, &scan); err != nil {
+		log.Printf("Error reading saved scan %s: %v", id, err)
+		return verifiedScan{}, false
+	}
+	return scan, true
+}
+
+// lookupScan returns a scan that has not expired
+func lookupScan(id string) (verifiedScan, error) {
+	scansMu.Lock()
+	defer scansMu.Unlock()
+	scan, ok := recentScans[id]
+	if !ok {
+		scan, ok = loadPersistedScan(id)
+	}
+	if !ok || time.Since(scan.Scanned) > scanRetention {
+		return verifiedScan{}, fmt.Errorf("scan %q not found or expired, scan the ID again", id)
+	}
+	return scan, nil
+}
+
+// maskLicenseNumber keeps only the last four characters
+func maskLicenseNumber(number string) string {
+	if len(number) <= 4 {
+		return strings.Repeat("*", len(number))
+	}
+	return "***" + number[len(number)-4:]
+}
+
+// maskedName returns the first name and last initial, e.g. "JANE D."
+func (s verifiedScan) maskedName() string {
+	name := s.License.FirstName
+	if last := []rune(s.License.LastName); len(last) > 0 {
+		name = strings.TrimSpace(name + " " + string(last[0]) + ".")
+	}
+	return name
+}
+
+// recordScanLink stores the association between a printed document and
+// the scan it was verified with. Failures are logged but never fail the
+// print.
+func recordScanLink(transactionID, document string, scan verifiedScan) {
+	if transactionStore == nil {
+		return
+	}
+	err := transactionStore.Record(txstore.Link{
+		TransactionID: transactionID,
+		Document:      document,
+		ScanID:        scan.ID,
+		CustomerName:  scan.maskedName(),
+		LicenseNumber: maskLicenseNumber(scan.License.LicenseNumber),
+		State:         scan.License.State,
+		ScannedAt:     scan.Scanned,
+		PrintedAt:     time.Now(),
+	})
+	if err != nil {
+		log.Printf("Error recording scan %s for %s %s: %v", scan.ID, document, transactionID, err)
+	}
+}
+
+// scanLinksHandler lists the verified scans recorded for a transaction
+func scanLinksHandler(w http.ResponseWriter, r *http.Request) {
+	if transactionStore == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, errors.New("transaction store is not available"))
+		return
+	}
+	transactionID := r.URL.Query().Get("transactionId")
+	if transactionID == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("transactionId is required"))
+		return
+	}
+
+	links := transactionStore.Find(transactionID)
+	if links == nil {
+		links = []txstore.Link{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"links":  links,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"GoScanRentalTide/internal/script"
+)
+
+// scriptedFields is the -scripted-fields config; empty when none is set
+var scriptedFields script.Config
+
+// scriptFuncs are the agent's functions for scripted fields, beside the
+// builtins of the script package
+var scriptFuncs = script.Funcs{
+	// money formats an amount with the receipt currency format
+	"money": func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("takes 1 argument, not %d", len(args))
+		}
+		amount, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("%v is not an amount", args[0])
+		}
+		return currencyFormat.Format(amount), nil
+	},
+}
+
+// scriptedLine is a labelled scripted field, printed with the totals
+type scriptedLine struct {
+	Label string
+	Value string
+}
+
+// receiptFields holds the JSON names of the request fields of ReceiptData,
+// which scripted fields of the same name replace
+var receiptFields = func() map[string]bool {
+	fields := map[string]bool{}
+	t := reflect.TypeOf(ReceiptData{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}()
+
+// applyScriptedFields computes the -scripted-fields of a receipt from its
+// request fields. A field named after a request field (total, say, for
+// cash rounding) replaces it; the others are kept for the template as
+// .Scripted.<name>, and printed when they have a label. A field that
+// fails is logged and left out, so a bad rule never stops a receipt.
+func applyScriptedFields(receipt *ReceiptData) {
+	if len(scriptedFields.Fields) == 0 {
+		return
+	}
+	var vars map[string]interface{}
+	data, err := json.Marshal(receipt)
+	if err == nil {
+		err = json.Unmarshal(data, &vars)
+	}
+	if err != nil {
+		log.Printf("Receipt %s: skipping scripted fields: %v", receipt.TransactionID, err)
+		return
+	}
+
+	values, errs := scriptedFields.Apply(vars)
+	for _, err := range errs {
+		log.Printf("Receipt %s: scripted field %v", receipt.TransactionID, err)
+	}
+	overrides := map[string]interface{}{}
+	receipt.Scripted = map[string]interface{}{}
+	receipt.ScriptedLines = nil
+	for _, v := range values {
+		receipt.Scripted[v.Name] = v.Value
+		if receiptFields[v.Name] {
+			overrides[v.Name] = v.Value
+		}
+		if v.Label != "" {
+			receipt.ScriptedLines = append(receipt.ScriptedLines, scriptedLine{Label: v.Label, Value: script.Text(v.Value)})
+		}
+	}
+	if len(overrides) == 0 {
+		return
+	}
+
+	// Replace the fields on a copy, so a value of the wrong type leaves
+	// the receipt as it was
+	data, err = json.Marshal(overrides)
+	if err != nil {
+		log.Printf("Receipt %s: scripted fields not applied: %v", receipt.TransactionID, err)
+		return
+	}
+	updated := *receipt
+	if err := json.Unmarshal(data, &updated); err != nil {
+		log.Printf("Receipt %s: scripted fields not applied: %v", receipt.TransactionID, err)
+		return
+	}
+	*receipt = updated
+}
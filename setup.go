@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// setupPrompt asks questions on a terminal
+type setupPrompt struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask prints a question and returns the trimmed answer, or def when the
+// answer is empty
+func (p *setupPrompt) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	if !p.in.Scan() {
+		fmt.Fprintln(p.out)
+		return def
+	}
+	if answer := strings.TrimSpace(p.in.Text()); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks a yes/no question
+func (p *setupPrompt) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	answer := strings.ToLower(p.ask(question+" ("+hint+")", ""))
+	if answer == "" {
+		return def
+	}
+	return strings.HasPrefix(answer, "y")
+}
+
+// choose lists options and takes either a number or a typed value
+func (p *setupPrompt) choose(title string, options []string, def string) string {
+	fmt.Fprintf(p.out, "\n%s:\n", title)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+	}
+	answer := p.ask("Choose a number or type a name", def)
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+		return options[n-1]
+	}
+	return answer
+}
+
+// listPrinters asks the OS for its installed printers
+func listPrinters() ([]string, error) {
+	var output []byte
+	var err error
+	if runtime.GOOS == "windows" {
+		output, err = runCommand(context.Background(), "powershell", "-NoProfile", "-Command", "Get-Printer | Select-Object -ExpandProperty Name")
+	} else {
+		output, err = runCommand(context.Background(), "lpstat", "-e")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list printers: %v", err)
+	}
+	var printers []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			printers = append(printers, name)
+		}
+	}
+	return printers, nil
+}
+
+// runSetup walks through choosing the scanner port and printers, sends a
+// test print and saves the answers to the config file
+func runSetup(in io.Reader, out io.Writer, configPath string) error {
+	current, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	setting := func(name, def string) string {
+		if v, ok := current[name]; ok {
+			return fmt.Sprint(v)
+		}
+		return def
+	}
+	p := &setupPrompt{in: bufio.NewScanner(in), out: out}
+	fmt.Fprintf(out, "GoScanRentalTide setup\nSettings are saved to %s\n", configPath)
+
+	// Scanner: "auto" clears the port so it is detected on every scan
+	ports, err := listSerialPorts()
+	if err != nil {
+		fmt.Fprintf(out, "Could not list serial ports: %v\n", err)
+	}
+	port := setting("port", "COM4")
+	if port == "" {
+		port = "auto"
+	}
+	port = p.choose("Scanner serial port (\"auto\" detects it on each scan)", append(ports, "auto"), port)
+	if port == "auto" {
+		port = ""
+	}
+
+	printers, err := listPrinters()
+	if err != nil {
+		fmt.Fprintf(out, "%v\n", err)
+	}
+	var printer string
+	for {
+		printer = p.choose("Receipt printer", printers, setting("printer", "Receipt1"))
+		if !p.confirm("Send a test page to "+printer+"?", true) {
+			break
+		}
+		if err := printTestPage(context.Background(), agentOptions{PrinterName: printer}); err != nil {
+			fmt.Fprintf(out, "Test print failed: %v\n", err)
+			if p.confirm("Choose the receipt printer again?", true) {
+				continue
+			}
+			break
+		}
+		if p.confirm("Did the test page print?", true) {
+			break
+		}
+	}
+
+	// "default" leaves agreements on the system default printer
+	agreementPrinter := setting("agreement-printer", "")
+	if agreementPrinter == "" {
+		agreementPrinter = "default"
+	}
+	agreementPrinter = p.choose("Agreement printer (\"default\" uses the system default)", append(printers, "default"), agreementPrinter)
+	if agreementPrinter == "default" {
+		agreementPrinter = ""
+	}
+
+	updates := map[string]interface{}{
+		"port":              port,
+		"printer":           printer,
+		"agreement-printer": agreementPrinter,
+	}
+	fmt.Fprintln(out, "\nNew settings:")
+	for _, name := range configKeys(updates) {
+		fmt.Fprintf(out, "  %s = %q\n", name, updates[name])
+	}
+	if !p.confirm("Save them?", true) {
+		fmt.Fprintln(out, "Nothing was saved.")
+		return nil
+	}
+	if err := writeConfigFile(configPath, updates); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Saved %s. Restart the agent to use the new settings.\n", configPath)
+	return nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"GoScanRentalTide/internal/signature"
+	"GoScanRentalTide/internal/transport"
+
+	"go.bug.st/serial"
+)
+
+// Width in pixels of rendered signature PNGs
+const signatureImageWidth = 600
+
+// signatureSession is one signature being captured. Serial pads are read
+// by the agent; HID and on-screen pads are read by the frontend, which
+// posts the strokes to /signature/points.
+type signatureSession struct {
+	ID      string
+	Source  string // "serial" or "client"
+	Started time.Time
+
+	mu      sync.Mutex
+	sig     signature.Signature
+	updated chan struct{} // Closed and replaced whenever strokes arrive
+	done    bool
+
+	stop    chan struct{}
+	stopped chan struct{} // Closed once the pad reader exits
+}
+
+var (
+	signatureMu      sync.Mutex
+	currentSignature *signatureSession
+)
+
+// Signature IDs are used in file names, so only these are accepted
+var signatureIDPattern = regexp.MustCompile(`^sig-[0-9]{8}-[0-9]{6}-[0-9]{3}$`)
+
+func newSignatureSession(source string) *signatureSession {
+	now := time.Now()
+	return &signatureSession{
+		ID:      fmt.Sprintf("sig-%s-%03d", now.Format("20060102-150405"), now.Nanosecond()/int(time.Millisecond)),
+		Source:  source,
+		Started: now,
+		updated: make(chan struct{}),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// snapshot returns a copy of the strokes so far, whether capture has
+// ended, and a channel closed on the next update
+func (s *signatureSession) snapshot() (signature.Signature, bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	strokes := make([][]signature.Point, len(s.sig.Strokes))
+	for i, stroke := range s.sig.Strokes {
+		strokes[i] = append([]signature.Point(nil), stroke...)
+	}
+	return signature.Signature{Strokes: strokes}, s.done, s.updated
+}
+
+// update changes the strokes under the lock and wakes stream readers
+func (s *signatureSession) update(change func(sig *signature.Signature)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&s.sig)
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// end stops the pad reader and marks the capture as finished
+func (s *signatureSession) end() {
+	s.mu.Lock()
+	if s.done {
+		s.mu.Unlock()
+		return
+	}
+	s.done = true
+	close(s.updated)
+	s.updated = make(chan struct{})
+	s.mu.Unlock()
+
+	close(s.stop)
+	<-s.stopped
+}
+
+// readSignaturePad decodes pad packets into the session until it ends
+func readSignaturePad(session *signatureSession, port transport.Conn) {
+	defer close(session.stopped)
+	defer port.Close()
+
+	var decoder signature.Decoder
+	buf := make([]byte, 256)
+	for {
+		select {
+		case <-session.stop:
+			return
+		default:
+		}
+		// Wake up regularly to notice the session ending
+		n, err := readWithTimeout(port, buf, 200*time.Millisecond)
+		if errors.Is(err, transport.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			select {
+			case <-session.stop:
+			default:
+				log.Printf("Signature pad read failed: %v", err)
+			}
+			return
+		}
+		if n > 0 {
+			session.update(func(sig *signature.Signature) {
+				decoder.Feed(buf[:n], sig)
+			})
+		}
+	}
+}
+
+// activeSignature returns the session with the given ID
+func activeSignature(id string) (*signatureSession, error) {
+	signatureMu.Lock()
+	defer signatureMu.Unlock()
+	if currentSignature == nil || (id != "" && currentSignature.ID != id) {
+		return nil, errors.New("no signature capture in progress")
+	}
+	return currentSignature, nil
+}
+
+// signatureDir is where finished signatures are saved
+func signatureDir(appDir string) string {
+	return filepath.Join(appDir, "signatures")
+}
+
+// loadSignaturePNG reads a saved signature for embedding in documents
+func loadSignaturePNG(appDir, id string) ([]byte, error) {
+	if !signatureIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid signature ID %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(signatureDir(appDir), id+".png"))
+	if err != nil {
+		return nil, fmt.Errorf("signature %s not found: %v", id, err)
+	}
+	return data, nil
+}
+
+// signatureDataURL returns a saved signature as a data URL for templates
+func signatureDataURL(appDir, id string) (template.URL, error) {
+	data, err := loadSignaturePNG(appDir, id)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// signatureStartHandler begins a capture, cancelling any capture left
+// running by a previous checkout
+func signatureStartHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	signatureMu.Lock()
+	previous := currentSignature
+	currentSignature = nil
+	signatureMu.Unlock()
+	if previous != nil {
+		previous.end()
+	}
+
+	var session *signatureSession
+	if opts.SignaturePort == "" {
+		session = newSignatureSession("client")
+		close(session.stopped)
+	} else {
+		port, err := serialTransport.Open(opts.SignaturePort, &serial.Mode{
+			BaudRate: opts.SignatureBaud,
+			DataBits: 8,
+			Parity:   serial.NoParity,
+			StopBits: serial.OneStopBit,
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, fmt.Errorf("open signature pad %s failed: %w", opts.SignaturePort, err))
+			return
+		}
+		session = newSignatureSession("serial")
+		go readSignaturePad(session, port)
+	}
+
+	signatureMu.Lock()
+	currentSignature = session
+	signatureMu.Unlock()
+	logf(r.Context(), "Signature capture %s started (%s)", session.ID, session.Source)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"id":     session.ID,
+		"source": session.Source,
+	})
+}
+
+// signaturePointsHandler adds strokes from HID or on-screen pads
+func signaturePointsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var req struct {
+		ID      string              `json:"id"`
+		Strokes [][]signature.Point `json:"strokes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error parsing JSON data: %v", err))
+		return
+	}
+	session, err := activeSignature(req.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+
+	session.update(func(sig *signature.Signature) {
+		for _, stroke := range req.Strokes {
+			if len(stroke) > 0 {
+				sig.Strokes = append(sig.Strokes, stroke)
+			}
+		}
+	})
+	sig, _, _ := session.snapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"points": sig.Points(),
+	})
+}
+
+// signatureStreamHandler streams the strokes as server-sent events: an
+// "update" event with all strokes so far whenever they change, then a
+// "done" event when the capture is finished or cancelled
+func signatureStreamHandler(w http.ResponseWriter, r *http.Request) {
+	session, err := activeSignature(r.URL.Query().Get("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	sent := -1
+	for {
+		sig, done, updated := session.snapshot()
+		if points := sig.Points(); points != sent {
+			data, _ := json.Marshal(map[string]interface{}{"id": session.ID, "strokes": sig.Strokes, "points": points})
+			fmt.Fprintf(w, "event: update\ndata: %s\n\n", data)
+			sent = points
+		}
+		if done {
+			fmt.Fprintf(w, "event: done\ndata: {\"id\":%q}\n\n", session.ID)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// signatureFinishHandler ends the capture, saves the signature as PNG and
+// SVG and returns both
+func signatureFinishHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("error parsing JSON data: %v", err))
+		return
+	}
+	session, err := activeSignature(req.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+
+	session.end()
+	signatureMu.Lock()
+	if currentSignature == session {
+		currentSignature = nil
+	}
+	signatureMu.Unlock()
+
+	sig, _, _ := session.snapshot()
+	if sig.Empty() {
+		writeJSONError(w, http.StatusBadRequest, errors.New("no signature was captured"))
+		return
+	}
+
+	pngData, err := sig.PNG(signatureImageWidth)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("error rendering signature: %v", err))
+		return
+	}
+	svgData := sig.SVG()
+
+	dir := signatureDir(opts.AppDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("error creating signature directory: %v", err))
+		return
+	}
+	for ext, data := range map[string][]byte{".png": pngData, ".svg": svgData} {
+		if err := os.WriteFile(filepath.Join(dir, session.ID+ext), data, 0644); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("error saving signature: %v", err))
+			return
+		}
+	}
+	logf(r.Context(), "Signature %s captured (%d strokes, %d points)", session.ID, len(sig.Strokes), sig.Points())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"id":      session.ID,
+		"strokes": len(sig.Strokes),
+		"png":     base64.StdEncoding.EncodeToString(pngData),
+		"svg":     string(svgData),
+	})
+}
+
+// signatureCancelHandler abandons the capture in progress
+func signatureCancelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	signatureMu.Lock()
+	session := currentSignature
+	currentSignature = nil
+	signatureMu.Unlock()
+	if session != nil {
+		session.end()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+	})
+}
+
+// signatureImageHandler serves a saved signature as PNG (default) or SVG
+func signatureImageHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	id := r.URL.Query().Get("id")
+	if !signatureIDPattern.MatchString(id) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid signature ID %q", id))
+		return
+	}
+	ext, contentType := ".png", "image/png"
+	if r.URL.Query().Get("format") == "svg" {
+		ext, contentType = ".svg", "image/svg+xml"
+	}
+	data, err := os.ReadFile(filepath.Join(signatureDir(opts.AppDir), id+ext))
+	if err != nil {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("signature %s not found", id))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
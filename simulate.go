@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/simulate"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// simulatedPrintDir receives documents instead of the printers when the
+// agent runs with -simulate
+var simulatedPrintDir string
+
+// startSimulation replaces the scanner with canned swipes and the
+// printers with files under <app dir>/simulated-prints. A passive scanner
+// swipes without being sent a command.
+func startSimulation(fixture string, delay time.Duration, appDir string, passive bool) error {
+	scanner, err := simulate.NewScanner(fixture, delay)
+	if err != nil {
+		return err
+	}
+	scanner.Unprompted = passive
+	dir := filepath.Join(appDir, "simulated-prints")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+	serialTransport = scanner
+	listSerialPorts = scanner.Ports
+	simulatedPrintDir = dir
+	log.Printf("SIMULATION MODE: scans return the %q fixture, documents are written to %s", fixture, dir)
+	return nil
+}
+
+// writeSimulatedPrint saves a rendered document where the printer would
+// have received it. The printer name is kept in a comment at the top.
+func writeSimulatedPrint(html, kind, transactionID, printerName string) error {
+	name := fmt.Sprintf("%s-%s-%s.html", kind, fileSafe(transactionID), time.Now().Format("20060102-150405.000"))
+	path := filepath.Join(simulatedPrintDir, name)
+	content := fmt.Sprintf("<!-- printer: %s -->\n%s", printerKey(printerName), html)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return fmt.Errorf("error writing simulated print: %v", err)
+	}
+	log.Printf("Simulated print of %s %s to %s: %s", kind, transactionID, printerKey(printerName), path)
+	return nil
+}
+
+// writeSimulatedRaw saves a raw (ESC/POS) document where the printer would
+// have received it
+func writeSimulatedRaw(data []byte, kind, transactionID, printerName string) error {
+	name := fmt.Sprintf("%s-%s-%s%s", kind, fileSafe(transactionID), time.Now().Format("20060102-150405.000"), thermalFile)
+	path := filepath.Join(simulatedPrintDir, name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing simulated print: %v", err)
+	}
+	log.Printf("Simulated raw print of %s %s to %s: %s", kind, transactionID, printerKey(printerName), path)
+	return nil
+}
+
+// fileSafe keeps letters, digits, '-' and '_' so a transaction ID can be
+// used in a file name
+func fileSafe(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// ScanReplayRequest is the scanner data for /scanner/simulate; exactly
+// one field is given
+type ScanReplayRequest struct {
+	Data    string `json:"data,omitempty"`    // Raw magstripe or AAMVA text as the scanner sends it
+	Hex     string `json:"hex,omitempty"`     // The same, hex encoded (the rawResponseHex of a -debug-scans response)
+	Fixture string `json:"fixture,omitempty"` // One of the -simulate fixtures
+}
+
+// scanReplayHandler parses scanner data from the request and answers
+// exactly as /scanner/scan would have, so licenses that failed in the
+// field can be replayed. Replays are not journaled.
+func scanReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+	var req ScanReplayRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4*maxScanBytes)).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	given := 0
+	for _, v := range []string{req.Data, req.Hex, req.Fixture} {
+		if v != "" {
+			given++
+		}
+	}
+	if given != 1 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("give exactly one of data, hex or fixture"))
+		return
+	}
+
+	data := req.Data
+	switch {
+	case req.Hex != "":
+		raw, err := hex.DecodeString(strings.ReplaceAll(req.Hex, " ", ""))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid hex: %v", err))
+			return
+		}
+		data = string(raw)
+	case req.Fixture != "":
+		fixture, ok := simulate.Fixture(req.Fixture)
+		if !ok {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown fixture %q (choose from %s)", req.Fixture, strings.Join(simulate.Fixtures(), ", ")))
+			return
+		}
+		data = fixture
+	}
+	if len(data) > maxScanBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("scanner data is larger than %d bytes", maxScanBytes))
+		return
+	}
+
+	resp, err := parseScan(r, data, &journal.Entry{Kind: journal.Scan, RequestID: tracing.RequestID(r.Context())})
+	if err != nil {
+		writeScanError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/merchant"
+	"GoScanRentalTide/internal/money"
+	"GoScanRentalTide/internal/sanitize"
+	"GoScanRentalTide/internal/tmplcache"
+	"GoScanRentalTide/internal/tmplfuncs"
+	"GoScanRentalTide/internal/tracing"
+)
+
+// StatementEntry is a charge or a payment on an account
+type StatementEntry struct {
+	Date        string      `json:"date"` // YYYY-MM-DD, so entries sort by date
+	Description string      `json:"description,omitempty"`
+	Reference   string      `json:"reference,omitempty"` // Transaction or receipt number
+	Amount      money.Cents `json:"amount"`
+}
+
+// StatementBalance is the account's balance at the end of an earlier period
+type StatementBalance struct {
+	Period  string      `json:"period"` // e.g. "2025-05"
+	Balance money.Cents `json:"balance"`
+}
+
+// StatementLine is an entry of the statement with the balance after it
+type StatementLine struct {
+	StatementEntry
+	Charge  money.Cents
+	Payment money.Cents
+	Balance money.Cents
+}
+
+// StatementData is an account statement to print: the period's charges
+// and payments against the opening balance, where a settlement receipt
+// only shows one payment
+type StatementData struct {
+	AccountID      string             `json:"accountId"`
+	Account        *InvoiceAccount    `json:"account,omitempty"` // Name and address to print
+	StatementDate  string             `json:"statementDate,omitempty"`
+	PeriodStart    string             `json:"periodStart,omitempty"`
+	PeriodEnd      string             `json:"periodEnd,omitempty"`
+	DueDate        string             `json:"dueDate,omitempty"`
+	OpeningBalance money.Cents        `json:"openingBalance,omitempty"`
+	Transactions   []StatementEntry   `json:"transactions,omitempty"` // Charged to the account
+	Payments       []StatementEntry   `json:"payments,omitempty"`
+	BalanceHistory []StatementBalance `json:"balanceHistory,omitempty"` // Earlier periods, oldest first
+	Notes          string             `json:"notes,omitempty"`
+	Location       string             `json:"location,omitempty"`
+	LogoUrl        string             `json:"logoUrl,omitempty"`
+	PaperSize      string             `json:"paperSize,omitempty"` // "letter" (default) or "a4"
+	Copies         int                `json:"copies"`
+	Language       string             `json:"language,omitempty"`
+	OperatorName   string             `json:"operatorName,omitempty"` // Staff member, journaled with the print job
+	StationID      string             `json:"stationId,omitempty"`    // Till or counter, journaled with the print job
+	Output         string             `json:"output,omitempty"`       // pdf (default), thermal or html
+	Renderer       string             `json:"renderer,omitempty"`     // Browser for pdf output (default: -renderer)
+
+	// Derived fields (calculated before rendering)
+	PageSize       string          `json:"-"`
+	Lines          []StatementLine `json:"-"` // Charges and payments by date
+	TotalCharges   money.Cents     `json:"-"`
+	TotalPayments  money.Cents     `json:"-"`
+	ClosingBalance money.Cents     `json:"-"`
+	Merchant       merchant.Info   `json:"-"`
+}
+
+// sanitizeStatement cleans the text of a statement before it is rendered,
+// so control characters don't reach the receipt printer as commands, and
+// checks its logo URL
+func sanitizeStatement(statement *StatementData) error {
+	if err := sanitize.URL(statement.LogoUrl); err != nil {
+		return fmt.Errorf("logoUrl: %v", err)
+	}
+	sanitize.Lines(sanitize.MaxName, &statement.AccountID, &statement.StatementDate, &statement.PeriodStart, &statement.PeriodEnd,
+		&statement.DueDate, &statement.Location, &statement.PaperSize, &statement.Language, &statement.OperatorName, &statement.StationID)
+	if account := statement.Account; account != nil {
+		sanitize.Lines(sanitize.MaxName, &account.ID, &account.Name, &account.Email, &account.Phone)
+		for i := range account.Address {
+			sanitize.Lines(sanitize.MaxName, &account.Address[i])
+		}
+	}
+	for _, entries := range [][]StatementEntry{statement.Transactions, statement.Payments} {
+		for i := range entries {
+			sanitize.Lines(sanitize.MaxName, &entries[i].Date, &entries[i].Description, &entries[i].Reference)
+		}
+	}
+	for i := range statement.BalanceHistory {
+		sanitize.Lines(sanitize.MaxName, &statement.BalanceHistory[i].Period)
+	}
+	statement.Notes = sanitize.Text(statement.Notes, sanitize.MaxText)
+	return nil
+}
+
+// prepareStatement merges the charges and payments by date, charges
+// first on the same day, and keeps the running balance
+func prepareStatement(statement *StatementData) {
+	switch strings.ToLower(statement.PaperSize) {
+	case "a4":
+		statement.PageSize = "A4"
+	default:
+		statement.PageSize = "letter"
+	}
+	lines := make([]StatementLine, 0, len(statement.Transactions)+len(statement.Payments))
+	for _, e := range statement.Transactions {
+		lines = append(lines, StatementLine{StatementEntry: e, Charge: e.Amount})
+	}
+	for _, e := range statement.Payments {
+		lines = append(lines, StatementLine{StatementEntry: e, Payment: e.Amount})
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Date < lines[j].Date })
+
+	balance := statement.OpeningBalance
+	statement.TotalCharges, statement.TotalPayments = 0, 0
+	for i := range lines {
+		balance += lines[i].Charge - lines[i].Payment
+		lines[i].Balance = balance
+		statement.TotalCharges += lines[i].Charge
+		statement.TotalPayments += lines[i].Payment
+	}
+	statement.Lines = lines
+	statement.ClosingBalance = balance
+	statement.Merchant = merchantInfo
+}
+
+// generateHTMLStatement renders a statement with the statement template
+func generateHTMLStatement(statement StatementData, templates *tmplcache.Cache) (string, error) {
+	language := statement.Language
+	if language == "" {
+		language = receiptLanguage
+	}
+
+	tmpl, err := templates.Get("statement.html", i18n.New(language).Language())
+	if err != nil {
+		return "", fmt.Errorf("error parsing statement template: %v", err)
+	}
+
+	html, err := tmplfuncs.Render(tmpl, statement)
+	if err != nil {
+		return "", fmt.Errorf("error executing statement template: %v", err)
+	}
+	return html, nil
+}
+
+// renderStatement fills in the derived fields and renders the statement
+func renderStatement(statement StatementData, opts agentOptions) (string, error) {
+	prepareStatement(&statement)
+	return generateHTMLStatement(statement, opts.Templates)
+}
+
+// formatThermalStatement lays out a prepared statement as ESC/POS for the
+// receipt printer, one entry over two lines
+func formatThermalStatement(statement StatementData) []byte {
+	b := newThermalReceipt(statement.Language)
+	tr := b.tr
+	format := currencyFormat.FormatCents
+
+	b.WriteString(escCenter + escBoldOn)
+	if name := statement.Merchant.LegalName; name != "" {
+		b.text(name)
+	} else if statement.Location != "" {
+		b.text(statement.Location)
+	}
+	b.text(strings.ToUpper(tr.T("statement")))
+	b.WriteString(escBoldOff)
+	b.text(statement.StatementDate)
+	b.WriteString(escLeft)
+	b.divider("-")
+	b.line(tr.T("account_id")+":", statement.AccountID)
+	if statement.Account != nil && statement.Account.Name != "" {
+		b.line(tr.T("account_name")+":", statement.Account.Name)
+	}
+	if statement.PeriodStart != "" {
+		b.line(tr.T("billing_period")+":", statement.PeriodStart+" - "+statement.PeriodEnd)
+	}
+	b.divider("-")
+
+	b.line(tr.T("opening_balance")+":", format(statement.OpeningBalance))
+	for _, l := range statement.Lines {
+		b.text(strings.TrimSpace(l.Date + " " + l.Description))
+		amount := format(l.Charge)
+		if l.Payment != 0 {
+			amount = "-" + format(l.Payment)
+		}
+		b.line("  "+l.Reference, amount+" "+format(l.Balance))
+	}
+	b.divider("-")
+	b.line(tr.T("charges")+":", format(statement.TotalCharges))
+	b.line(tr.T("payments")+":", "-"+format(statement.TotalPayments))
+	b.WriteString(escBoldOn)
+	b.line(tr.T("closing_balance")+":", format(statement.ClosingBalance))
+	b.WriteString(escBoldOff)
+	if statement.DueDate != "" {
+		b.line(tr.T("due_date")+":", statement.DueDate)
+	}
+
+	if len(statement.BalanceHistory) > 0 {
+		b.divider("-")
+		b.text(strings.ToUpper(tr.T("balance_history")))
+		for _, h := range statement.BalanceHistory {
+			b.line(h.Period, format(h.Balance))
+		}
+	}
+	if statement.Notes != "" {
+		b.divider("-")
+		b.text(statement.Notes)
+	}
+	b.WriteString("\n")
+	return b.bytes()
+}
+
+// printStatement prints a statement through the pipeline: full page on the
+// document printer, or raw on the receipt printer for thermal output
+func printStatement(ctx context.Context, statement StatementData, opts agentOptions, pipeline renderPipeline) error {
+	if pipeline.Output == outputThermal {
+		prepareStatement(&statement)
+		return printRaw(ctx, formatThermalStatement(statement), "statement", statementID(statement), opts.PrinterName)
+	}
+
+	html, err := renderStatement(statement, opts)
+	if err != nil {
+		return err
+	}
+	return printHTMLDocument(ctx, html, "statement", statementID(statement), opts.AgreementPrinter, pipeline.Renderer)
+}
+
+// statementID is what a statement is archived and journaled under: the
+// account and the statement date
+func statementID(statement StatementData) string {
+	return statement.AccountID + "-" + statement.StatementDate
+}
+
+// printStatementHandler prints an account statement
+func printStatementHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var statement StatementData
+	if err := json.NewDecoder(r.Body).Decode(&statement); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	if err := sanitizeStatement(&statement); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if statement.AccountID == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("account ID is required"))
+		return
+	}
+	for _, entries := range [][]StatementEntry{statement.Transactions, statement.Payments} {
+		for _, e := range entries {
+			if e.Amount < 0 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("entry %q has a negative amount; list refunds as payments", e.Reference))
+				return
+			}
+		}
+	}
+	if statement.StatementDate == "" {
+		statement.StatementDate = time.Now().Format("2006-01-02")
+	}
+	if err := copiesError(statement.Copies); err != nil {
+		writeValidationError(w, err)
+		return
+	}
+	if statement.Copies <= 0 {
+		statement.Copies = 1
+	}
+	pipeline, err := parsePipeline(statement.Output, statement.Renderer, opts.Pipeline.forDocuments())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	printer := opts.AgreementPrinter
+	if pipeline.Output == outputThermal {
+		printer = opts.PrinterName
+	}
+	ctx, jobID := startPrintJob(r.Context())
+	printEntry := journal.Entry{
+		JobID:         jobID,
+		RequestID:     tracing.RequestID(ctx),
+		Kind:          journal.Print,
+		Status:        "success",
+		Document:      "statement",
+		TransactionID: statementID(statement),
+		Copies:        statement.Copies,
+		Printer:       printer,
+		Operator:      statement.OperatorName,
+		Station:       statement.StationID,
+		Output:        pipeline.Output,
+	}
+
+	// A caller that prints the statement itself gets the HTML back
+	if pipeline.Output == outputHTML {
+		html, err := renderStatement(statement, opts)
+		if err != nil {
+			printEntry.Status, printEntry.Error = "failed", err.Error()
+			recordJournal(printEntry)
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		retainJobArtifact(ctx, "html", statementID(statement), []byte(html))
+		printEntry.Printer, printEntry.Printed = "", statement.Copies
+		recordJournal(printEntry)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "success",
+			"message": "Rendered statement for printing",
+			"jobId":   jobID,
+			"html":    html,
+		})
+		return
+	}
+
+	for i := 1; i <= statement.Copies; i++ {
+		logf(r.Context(), "Printing statement for account %s copy %d/%d", statement.AccountID, i, statement.Copies)
+		if err := printStatement(ctx, statement, opts, pipeline); err != nil {
+			logf(r.Context(), "Statement for account %s failed to print: %v", statement.AccountID, err)
+			printEntry.Status, printEntry.Error = "failed", err.Error()
+			var manual *manualPrintError
+			if errors.As(err, &manual) {
+				printEntry.Status = manualPrint
+				recordJournal(printEntry)
+				writeManualPrint(w, manual)
+				return
+			}
+			recordJournal(printEntry)
+			writeJSONError(w, http.StatusInternalServerError, fmt.Errorf("printed %d/%d copies: %v", i-1, statement.Copies, err))
+			return
+		}
+		printEntry.Printed = i
+	}
+	recordJournal(printEntry)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"message": fmt.Sprintf("Printed %d/%d copies successfully", statement.Copies, statement.Copies),
+		"jobId":   jobID,
+	})
+}
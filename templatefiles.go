@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"GoScanRentalTide/internal/tmpllint"
+)
+
+// TemplateUploadResponse reports a template saved by PUT /templates/{name}
+type TemplateUploadResponse struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+	Bytes  int    `json:"bytes"`
+}
+
+// templateFileHandler serves the templates of the templates directory:
+// GET returns the template in use, the directory's or else the built-in
+// one (X-Template-Source says which), and PUT replaces it. A template is
+// only saved when /templates/validate would find nothing wrong with it,
+// checked against the built-in sample, so a push can't break printing.
+// The template caches pick up the new file on their next use.
+func templateFileHandler(w http.ResponseWriter, r *http.Request, opts agentOptions) {
+	name := r.PathValue("name")
+	if _, ok := templateSamples[name]; !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Errorf("unknown template %q (use %s)", name, templateNames()))
+		return
+	}
+	path := filepath.Join(opts.TemplatesDir, name)
+
+	switch r.Method {
+	case http.MethodGet:
+		source := "custom"
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			source = "builtin"
+			var text string
+			text, err = builtinTemplate(name)
+			data = []byte(text)
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Template-Source", source)
+		w.Write(data)
+	case http.MethodPut:
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, opts.MaxBodyBytes))
+		if err != nil {
+			writeBodyError(w, err)
+			return
+		}
+		if len(data) == 0 {
+			writeJSONError(w, http.StatusBadRequest, errors.New("template is empty"))
+			return
+		}
+		sample, err := templateSamples[name]([]byte(builtinSamples[name]))
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if problems := tmpllint.Lint(name, string(data), templateFuncs(receiptLanguage), sample); len(problems) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(TemplateValidateResponse{Status: "error", Problems: problems})
+			return
+		}
+		if err := saveTemplate(path, data); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		logAdmin(r, "replaced template %s (%d bytes)", name, len(data))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TemplateUploadResponse{Status: "success", Name: name, Bytes: len(data)})
+	default:
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only GET and PUT methods are allowed"))
+	}
+}
+
+// saveTemplate writes a template file whole, so a print never reads half
+// of one
+func saveTemplate(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create templates directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write template: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write template: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/tmpllint"
+)
+
+// TemplateValidateRequest is a template to check before it goes into the
+// templates directory
+type TemplateValidateRequest struct {
+	Name     string      `json:"name,omitempty"`                          // receipt.html (the default), agreement.html, invoice.html or statement.html
+	Template string      `json:"template" validate:"required"`            // The template text
+	Sample   interface{} `json:"sample,omitempty" validate:"kind=object"` // Data to render it with, as its print endpoint takes it (default: a built-in sample)
+	Language string      `json:"language,omitempty"`                      // Language of the t function (default: -language)
+}
+
+// TemplateValidateResponse lists what's wrong with a template; a valid
+// template has no problems
+type TemplateValidateResponse struct {
+	Status   string             `json:"status"`
+	Valid    bool               `json:"valid"`
+	Problems []tmpllint.Problem `json:"problems"`
+}
+
+// templateSample decodes the sample data of one kind of template and
+// fills in its derived fields, as printing it would
+type templateSample func(data []byte) (interface{}, error)
+
+// templateSamples are the templates that can be checked, by name
+var templateSamples = map[string]templateSample{
+	"receipt.html": func(data []byte) (interface{}, error) {
+		var receipt ReceiptData
+		if err := json.Unmarshal(data, &receipt); err != nil {
+			return nil, err
+		}
+		if err := sanitizeReceipt(&receipt); err != nil {
+			return nil, err
+		}
+		prepareReceipt(&receipt)
+		return receipt, nil
+	},
+	"agreement.html": func(data []byte) (interface{}, error) {
+		var agreement AgreementData
+		if err := json.Unmarshal(data, &agreement); err != nil {
+			return nil, err
+		}
+		prepareAgreement(&agreement)
+		return agreement, nil
+	},
+	"invoice.html": func(data []byte) (interface{}, error) {
+		var invoice InvoiceData
+		if err := json.Unmarshal(data, &invoice); err != nil {
+			return nil, err
+		}
+		prepareInvoice(&invoice)
+		return invoice, nil
+	},
+	"statement.html": func(data []byte) (interface{}, error) {
+		var statement StatementData
+		if err := json.Unmarshal(data, &statement); err != nil {
+			return nil, err
+		}
+		prepareStatement(&statement)
+		return statement, nil
+	},
+}
+
+// builtinSamples are rendered when a request doesn't send its own: a
+// sale with the common optional parts, and documents of one line each
+var builtinSamples = map[string]string{
+	"receipt.html": `{"transactionId": "TXN-SAMPLE", "date": "2025-06-14", "location": "Main Street",
+		"items": [{"name": "Kayak rental", "quantity": 1, "price": 40.00}],
+		"subtotal": 40.00, "tax": 4.80, "total": 44.80, "paymentType": "card",
+		"cardDetails": {"cardType": "VISA", "cardLast4": "4242"}, "operatorName": "Sam"}`,
+	"agreement.html": `{"agreementNumber": "AG-SAMPLE", "customer": {"firstName": "Alex", "lastName": "Sample"},
+		"items": [{"name": "Kayak", "quantity": 1, "rate": 40.00, "rateUnit": "day"}],
+		"rentalStart": "2025-06-14", "rentalEnd": "2025-06-15"}`,
+	"invoice.html": `{"invoiceNumber": "INV-SAMPLE", "account": {"name": "Sample Co."},
+		"items": [{"description": "Kayak rental", "quantity": 1, "unitPrice": 40.00}]}`,
+	"statement.html": `{"accountId": "ACC-SAMPLE", "statementDate": "2025-06-30",
+		"transactions": [{"date": "2025-06-14", "description": "Kayak rental", "amount": 44.80}]}`,
+}
+
+// templateNames lists the templates that can be checked
+func templateNames() string {
+	names := make([]string, 0, len(templateSamples))
+	for name := range templateSamples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// validateTemplateHandler checks a candidate template: it must parse with
+// the template functions, name only fields its data has, test values a
+// request can leave out before reading through them, and render a sample
+func validateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only POST method is allowed"))
+		return
+	}
+
+	var req TemplateValidateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if req.Template == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("template is required"))
+		return
+	}
+	if req.Name == "" {
+		req.Name = "receipt.html"
+	}
+	decode, ok := templateSamples[req.Name]
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("unknown template %q (use %s)", req.Name, templateNames()))
+		return
+	}
+	data := []byte(builtinSamples[req.Name])
+	if req.Sample != nil {
+		data, _ = json.Marshal(req.Sample)
+	}
+	sample, err := decode(data)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("sample: %v", err))
+		return
+	}
+	language := req.Language
+	if language == "" {
+		language = receiptLanguage
+	}
+
+	problems := tmpllint.Lint(req.Name, req.Template, templateFuncs(i18n.New(language).Language()), sample)
+	if problems == nil {
+		problems = []tmpllint.Problem{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TemplateValidateResponse{
+		Status:   "success",
+		Valid:    len(problems) == 0,
+		Problems: problems,
+	})
+}
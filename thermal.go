@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"GoScanRentalTide/internal/escpos"
+	"GoScanRentalTide/internal/i18n"
+	"GoScanRentalTide/internal/money"
+)
+
+// ESC/POS commands of the thermal receipt
+const (
+	escInit    = "\x1B@"
+	escBoldOn  = "\x1BE\x01"
+	escBoldOff = "\x1BE\x00"
+	escCenter  = "\x1Ba\x01"
+	escLeft    = "\x1Ba\x00"
+)
+
+// thermalFile is the extension of raw documents in the temp directory
+const thermalFile = ".escpos"
+
+// thermalReceipt lays a receipt out in lines of the receipt paper's width
+type thermalReceipt struct {
+	strings.Builder
+	page    *escpos.CodePage
+	columns int
+	tr      i18n.Translator
+}
+
+// line writes label and value on one line, value right-aligned, or on two
+// when they don't fit
+func (b *thermalReceipt) line(label, value string) {
+	label, value = b.page.Transliterate(label), b.page.Transliterate(value)
+	padding := b.columns - utf8.RuneCountInString(label) - utf8.RuneCountInString(value)
+	if padding < 1 {
+		b.WriteString(label + "\n")
+		padding = max(b.columns-utf8.RuneCountInString(value), 0)
+		label = ""
+	}
+	b.WriteString(label + strings.Repeat(" ", padding) + value + "\n")
+}
+
+// text writes text on a line of its own
+func (b *thermalReceipt) text(text string) {
+	b.WriteString(text + "\n")
+}
+
+// divider draws a line of char across the paper
+func (b *thermalReceipt) divider(char string) {
+	b.WriteString(strings.Repeat(char, b.columns) + "\n")
+}
+
+// newThermalReceipt starts a document on the receipt paper in language,
+// or the receipt language when it is empty
+func newThermalReceipt(language string) *thermalReceipt {
+	if language == "" {
+		language = receiptLanguage
+	}
+	page, _ := escpos.LookupCodePage(escpos.DefaultCodePage)
+	return &thermalReceipt{page: page, columns: receiptPaper.Columns, tr: i18n.New(language)}
+}
+
+// bytes cuts the paper and returns the document in its code page
+func (b *thermalReceipt) bytes() []byte {
+	b.WriteString(escpos.DefaultCut.Commands())
+	// The code page is selected after the reset, which clears it
+	return append([]byte(escInit+b.page.Select()), b.page.Encode(b.String())...)
+}
+
+// thermalCopies repeats a document from thermalReceipt.bytes count times,
+// each copy headed "Copy i of n", so one raw job prints and cuts them all
+func thermalCopies(doc []byte, count int, tr i18n.Translator) []byte {
+	if count <= 1 {
+		return doc
+	}
+	page, _ := escpos.LookupCodePage(escpos.DefaultCodePage)
+	prefix := []byte(escInit + page.Select())
+	body := bytes.TrimPrefix(doc, prefix)
+
+	var out []byte
+	for i := 1; i <= count; i++ {
+		label := escCenter + escBoldOn + page.Transliterate(tr.T("copy_of", i, count)) + "\n" + escBoldOff + escLeft
+		out = append(out, prefix...)
+		out = append(out, page.Encode(label)...)
+		out = append(out, body...)
+	}
+	return out
+}
+
+// formatThermalReceipt lays out a prepared receipt as ESC/POS for a
+// thermal printer. It has the sections of the HTML receipt, without
+// images: logos and signatures only print on the PDF output.
+func formatThermalReceipt(receipt ReceiptData) []byte {
+	b := newThermalReceipt(receipt.Language)
+	tr := b.tr
+	format := currencyFormat.FormatCents
+	sign := ""
+	if receipt.IsRefund {
+		sign = "-"
+	}
+
+	b.WriteString(escCenter)
+	if receipt.IsDuplicate {
+		b.WriteString(escBoldOn)
+		b.text("*** " + tr.T("duplicate") + " ***")
+		b.WriteString(escBoldOff)
+	}
+	b.WriteString(escBoldOn)
+	switch {
+	case receipt.IsNoSale:
+		b.text(tr.T("no_sale"))
+	case receipt.IsRefund:
+		b.text("*** " + tr.T("refund") + " ***")
+	}
+	if name := locationName(receipt.Location); name != "" {
+		b.text(name)
+	}
+	b.WriteString(escBoldOff)
+	if receipt.CustomerName != "" {
+		b.text(tr.T("customer") + ": " + receipt.CustomerName)
+	}
+	if receipt.VerifiedName != "" {
+		b.text(tr.T("verified_id") + ": " + receipt.VerifiedName)
+		b.text(tr.T("license_number") + ": " + receipt.VerifiedLicense)
+	}
+	if receipt.OperatorName != "" {
+		b.text(tr.T("served_by") + ": " + receipt.OperatorName)
+	}
+	if receipt.StationID != "" {
+		b.text(tr.T("station") + ": " + receipt.StationID)
+	}
+	switch {
+	case receipt.IsNoSale && receipt.Timestamp != "":
+		b.text(receipt.Timestamp)
+	case receipt.IsNoSale:
+		b.text(time.Now().Format("2006-01-02 15:04:05"))
+	default:
+		b.text(receipt.Date)
+	}
+	b.WriteString(escLeft)
+
+	if !receipt.IsNoSale {
+		b.writeMessages(receipt.Header)
+		b.divider("-")
+		if receipt.IsRefund {
+			b.line(tr.T("refund_id")+":", receipt.TransactionID)
+		} else {
+			b.line(tr.T("transaction_id")+":", receipt.TransactionID)
+		}
+		if receipt.ReceiptNumber != 0 {
+			b.line(tr.T("receipt_number")+":", fmt.Sprintf("%06d", receipt.ReceiptNumber))
+		}
+		if receipt.OriginalTransactionID != "" {
+			b.line(tr.T("original_transaction")+":", receipt.OriginalTransactionID)
+		}
+		b.divider("-")
+
+		if receipt.HasCombinedTransaction {
+			b.text(strings.ToUpper(tr.T("retail_purchase")))
+		}
+		for _, item := range receipt.Items {
+			if item.IsDeposit() {
+				continue
+			}
+			b.text(item.Name)
+			b.line(fmt.Sprintf("  %v x %s", item.Quantity, format(item.Price)), sign+format(item.LineTotal))
+		}
+		b.divider("-")
+
+		b.line(tr.T("subtotal")+":", sign+format(receipt.Subtotal))
+		if receipt.DiscountPercentage > 0 && receipt.DiscountAmount > 0 {
+			b.line(fmt.Sprintf("%s (%.0f%%):", tr.T("discount"), receipt.DiscountPercentage), "-"+format(receipt.DiscountAmount))
+		}
+		if receipt.PromoAmount > 0 {
+			b.line(tr.T("promo_discount")+":", "-"+format(receipt.PromoAmount))
+		}
+		b.line(tr.T("tax")+":", sign+format(receipt.Tax))
+		if receipt.ShowTaxBreakdown {
+			for _, l := range receipt.TaxBreakdown {
+				b.line("  "+l.Label()+":", sign+format(l.Amount))
+			}
+		}
+		if receipt.Tip > 0 {
+			b.line(tr.T("tip")+":", format(receipt.Tip))
+		}
+		if receipt.HasCombinedTransaction {
+			b.WriteString(escBoldOn)
+			b.line(tr.T("retail_subtotal")+":", format(receipt.RetailTotal))
+			b.WriteString(escBoldOff)
+			b.divider("-")
+			b.text(strings.ToUpper(tr.T("account_settlement")))
+			if receipt.AccountId != "" {
+				b.line(tr.T("account_id")+":", receipt.AccountId)
+			}
+			b.line(tr.T("payment_on_account")+":", format(receipt.SettlementAmount))
+			b.WriteString(escBoldOn)
+			b.line(tr.T("settlement_subtotal")+":", format(receipt.SettlementAmount))
+			b.WriteString(escBoldOff)
+			b.divider("-")
+		} else if receipt.SettlementAmount > 0 {
+			b.line(tr.T("account_settlement")+":", format(receipt.SettlementAmount))
+		}
+		b.WriteString(escBoldOn)
+		if receipt.IsRefund {
+			b.line(tr.T("refund_total")+":", "-"+format(receipt.RefundTotal))
+		} else {
+			b.line(tr.T("total")+":", format(receipt.Total))
+		}
+		b.WriteString(escBoldOff)
+
+		if len(receipt.Deposits) > 0 {
+			b.divider("-")
+			if receipt.IsRefund {
+				b.text(strings.ToUpper(tr.T("deposits_returned")))
+			} else {
+				b.text(strings.ToUpper(tr.T("held")))
+			}
+			for _, item := range receipt.Deposits {
+				b.line(item.Name, sign+format(item.LineTotal))
+			}
+		}
+		b.divider("=")
+
+		switch {
+		case receipt.IsRefund:
+			b.line(tr.T("refunded_to")+":", receipt.RefundMethodDisplay)
+		case len(receipt.Payments) > 0:
+			for _, p := range receipt.Payments {
+				b.line(strings.Title(p.Type)+":", format(p.Amount))
+				if card := p.Card(); card != "" {
+					b.line("  "+tr.T("card")+":", card)
+				}
+			}
+		default:
+			b.line(tr.T("payment_method")+":", strings.Title(receipt.PaymentType))
+		}
+		if !receipt.IsRefund && receipt.PaymentType == "cash" && receipt.CashGiven > 0 {
+			b.line(tr.T("cash")+":", format(receipt.CashGiven))
+			b.line(tr.T("change")+":", format(receipt.ChangeDue))
+		}
+		for _, l := range receipt.ScriptedLines {
+			b.line(l.Label+":", l.Value)
+		}
+		if receipt.HasLoyalty {
+			b.divider("-")
+			b.text(strings.ToUpper(tr.T("loyalty_points")))
+			if receipt.LoyaltyPointsEarned != 0 {
+				b.line(tr.T("points_earned")+":", fmt.Sprint(receipt.LoyaltyPointsEarned))
+			}
+			if receipt.LoyaltyPointsRedeemed != 0 {
+				b.line(tr.T("points_redeemed")+":", fmt.Sprint(-receipt.LoyaltyPointsRedeemed))
+			}
+			b.WriteString(escBoldOn)
+			b.line(tr.T("points_balance")+":", fmt.Sprint(receipt.LoyaltyPointsBalance))
+			b.WriteString(escBoldOff)
+		}
+		if len(receipt.GLCodeSummary) > 0 {
+			b.divider("-")
+			b.text(strings.ToUpper(tr.T("gl_summary")))
+			for _, l := range receipt.GLCodeSummary {
+				b.line(strings.TrimSpace(l.Code+" "+l.Description), format(l.Amount))
+			}
+			b.line(tr.T("subtotal")+":", format(receipt.GLCodeTotal))
+		}
+		b.writeLegal(receipt, sign)
+	}
+
+	b.WriteString(escCenter + "\n")
+	switch {
+	case receipt.IsNoSale:
+	case receipt.IsRefund:
+		b.text(tr.T("refund_processed") + ". " + tr.T("keep_receipt") + ".")
+		b.writeMessages(receipt.Footer)
+		b.WriteString("\n")
+		b.text(tr.T("customer_signature") + ": ______________")
+	case len(receipt.Footer) > 0:
+		b.writeMessages(receipt.Footer)
+	default:
+		b.text(tr.T("thank_you"))
+		if name := locationName(receipt.Location); name != "" {
+			b.text(tr.T("visit_again", name))
+		}
+	}
+	b.WriteString(escLeft)
+	b.writeCoupons(receipt.CouponBlocks)
+	return b.bytes()
+}
+
+// writeMessages writes custom message blocks, centered, with the text of
+// their QR codes in place of the codes
+func (b *thermalReceipt) writeMessages(blocks []messageBlock) {
+	b.WriteString(escCenter)
+	for _, block := range blocks {
+		if block.Title != "" {
+			b.WriteString(escBoldOn)
+			b.text(block.Title)
+			b.WriteString(escBoldOff)
+		}
+		for _, line := range block.Lines() {
+			b.text(line)
+		}
+		if block.QR != "" {
+			b.text(block.QR)
+		}
+	}
+	b.WriteString(escLeft)
+}
+
+// writeLegal writes the -merchant legal block and VAT table
+func (b *thermalReceipt) writeLegal(receipt ReceiptData, sign string) {
+	info := receipt.Merchant
+	if info.Empty() && len(receipt.VATTable) == 0 {
+		return
+	}
+	b.divider("-")
+	b.WriteString(escCenter)
+	if info.LegalName != "" {
+		b.WriteString(escBoldOn)
+		b.text(info.LegalName)
+		b.WriteString(escBoldOff)
+	}
+	for _, line := range info.Address {
+		b.text(line)
+	}
+	for _, r := range info.Registrations {
+		b.text(r.Label + ": " + r.Number)
+	}
+	b.WriteString(escLeft)
+	width := b.columns / 3
+	if len(receipt.VATTable) > 0 {
+		b.WriteString(fmt.Sprintf("%*s%*s%*s\n", width, b.tr.T("net"), width, b.tr.T("tax"), width, b.tr.T("gross")))
+	}
+	for _, row := range receipt.VATTable {
+		label := row.Label()
+		if row.Exempt {
+			label = b.tr.T("tax_exempt")
+		}
+		b.text(label)
+		amounts := []money.Cents{row.Amount, row.Tax, row.Gross()}
+		for _, amount := range amounts {
+			b.WriteString(fmt.Sprintf("%*s", width, b.page.Transliterate(sign+currencyFormat.FormatCents(amount))))
+		}
+		b.WriteString("\n")
+	}
+}
+
+// locationName returns the receipt's location, sent as a string or as an
+// object with a name
+func locationName(location interface{}) string {
+	switch l := location.(type) {
+	case string:
+		return l
+	case map[string]interface{}:
+		name, _ := l["name"].(string)
+		return name
+	}
+	return ""
+}
+
+// printRaw sends a document to the printer as is, bypassing its driver,
+// as ESC/POS printers need. The file is kept in the temp directory and
+// archived like rendered PDFs. On Windows the printer must be shared
+// under its own name.
+func printRaw(ctx context.Context, data []byte, kind, transactionID, printerName string) error {
+	retainJobArtifact(ctx, "escpos", transactionID, data)
+	if simulatedPrintDir != "" {
+		return writeSimulatedRaw(data, kind, transactionID, printerName)
+	}
+	appDir, err := ensureAppDirectory()
+	if err != nil {
+		return fmt.Errorf("error ensuring app directory: %v", err)
+	}
+	dir := filepath.Join(appDir, "temp")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error ensuring temp directory exists: %v", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%s-%s%s", kind, time.Now().Format("20060102-150405"), thermalFile))
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing %s: %v", kind, err)
+	}
+	archiveReceiptFiles(transactionID, path)
+
+	var output []byte
+	if runtime.GOOS == "windows" {
+		if printerName == "" {
+			return fmt.Errorf("thermal output on Windows needs -printer, shared under that name")
+		}
+		output, err = runCommand(ctx, "cmd", "/c", "copy", "/b", path, `\\localhost\`+printerName)
+	} else {
+		args := []string{"-o", "raw", path}
+		if printerName != "" {
+			args = []string{"-d", printerName, "-o", "raw", path}
+		}
+		output, err = runCommand(ctx, "lp", args...)
+	}
+	if err != nil {
+		logf(ctx, "Raw printing error: %v\n%s", err, string(output))
+		return fmt.Errorf("error printing %s: %v\nOutput: %s", kind, err, string(output))
+	}
+	logf(ctx, "Successfully printed %s raw to %s", kind, printerKey(printerName))
+	return nil
+}
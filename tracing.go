@@ -0,0 +1,14 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"GoScanRentalTide/internal/tracing"
+)
+
+// logf logs a line prefixed with the ID of the request ctx belongs to
+// (see internal/tracing), if there is one
+func logf(ctx context.Context, format string, args ...interface{}) {
+	tracing.Logf(log.Default(), ctx, format, args...)
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// runTray is only implemented on Windows, where the tills run
+func runTray(opts agentOptions) error {
+	return errors.New("the tray icon is only available on Windows")
+}
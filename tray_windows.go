@@ -0,0 +1,334 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// The tray icon talks to the Win32 API directly so the agent stays a
+// single dependency-free executable
+
+var (
+	user32   = syscall.NewLazyDLL("user32.dll")
+	shell32  = syscall.NewLazyDLL("shell32.dll")
+	kernel32 = syscall.NewLazyDLL("kernel32.dll")
+
+	procRegisterClassExW       = user32.NewProc("RegisterClassExW")
+	procCreateWindowExW        = user32.NewProc("CreateWindowExW")
+	procDefWindowProcW         = user32.NewProc("DefWindowProcW")
+	procGetMessageW            = user32.NewProc("GetMessageW")
+	procTranslateMessage       = user32.NewProc("TranslateMessage")
+	procDispatchMessageW       = user32.NewProc("DispatchMessageW")
+	procPostMessageW           = user32.NewProc("PostMessageW")
+	procPostQuitMessage        = user32.NewProc("PostQuitMessage")
+	procRegisterWindowMessageW = user32.NewProc("RegisterWindowMessageW")
+	procLoadIconW              = user32.NewProc("LoadIconW")
+	procCreatePopupMenu        = user32.NewProc("CreatePopupMenu")
+	procAppendMenuW            = user32.NewProc("AppendMenuW")
+	procTrackPopupMenu         = user32.NewProc("TrackPopupMenu")
+	procDestroyMenu            = user32.NewProc("DestroyMenu")
+	procGetCursorPos           = user32.NewProc("GetCursorPos")
+	procSetForegroundWindow    = user32.NewProc("SetForegroundWindow")
+	procShellNotifyIconW       = shell32.NewProc("Shell_NotifyIconW")
+	procGetModuleHandleW       = kernel32.NewProc("GetModuleHandleW")
+)
+
+const (
+	wmNull      = 0x0000
+	wmDestroy   = 0x0002
+	wmLButtonUp = 0x0202
+	wmRButtonUp = 0x0205
+	wmTrayIcon  = 0x8000 + 1 // WM_APP + 1
+
+	nimAdd    = 0
+	nimModify = 1
+	nimDelete = 2
+
+	nifMessage = 0x01
+	nifIcon    = 0x02
+	nifTip     = 0x04
+	nifInfo    = 0x10
+
+	niifInfo  = 0x01
+	niifError = 0x03
+
+	mfString    = 0x0000
+	mfGrayed    = 0x0001
+	mfSeparator = 0x0800
+
+	tpmRightButton = 0x0002
+	tpmBottomAlign = 0x0020
+	tpmReturnCmd   = 0x0100
+
+	idiApplication = 32512
+)
+
+// Tray menu commands
+const (
+	trayTestPrint = iota + 1
+	trayRescan
+	trayOpenLogs
+	trayQuit
+)
+
+// How often the tooltip is refreshed
+const trayRefreshInterval = 30 * time.Second
+
+type wndClassEx struct {
+	Size       uint32
+	Style      uint32
+	WndProc    uintptr
+	ClsExtra   int32
+	WndExtra   int32
+	Instance   uintptr
+	Icon       uintptr
+	Cursor     uintptr
+	Background uintptr
+	MenuName   *uint16
+	ClassName  *uint16
+	IconSm     uintptr
+}
+
+type point struct {
+	X, Y int32
+}
+
+type msg struct {
+	Hwnd     uintptr
+	Message  uint32
+	WParam   uintptr
+	LParam   uintptr
+	Time     uint32
+	Pt       point
+	LPrivate uint32
+}
+
+type guid struct {
+	Data1 uint32
+	Data2 uint16
+	Data3 uint16
+	Data4 [8]byte
+}
+
+// notifyIconData is NOTIFYICONDATAW
+type notifyIconData struct {
+	Size            uint32
+	Wnd             uintptr
+	ID              uint32
+	Flags           uint32
+	CallbackMessage uint32
+	Icon            uintptr
+	Tip             [128]uint16
+	State           uint32
+	StateMask       uint32
+	Info            [256]uint16
+	Version         uint32
+	InfoTitle       [64]uint16
+	InfoFlags       uint32
+	GuidItem        guid
+	BalloonIcon     uintptr
+}
+
+type trayIcon struct {
+	opts           agentOptions
+	hwnd           uintptr
+	taskbarCreated uintptr
+
+	mu   sync.Mutex
+	data notifyIconData
+}
+
+// runTray shows the status icon and runs its message loop; it only
+// returns if the icon can't be created
+func runTray(opts agentOptions) error {
+	// Win32 windows belong to the thread that created them
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	t := &trayIcon{opts: opts}
+	instance, _, _ := procGetModuleHandleW.Call(0)
+	className, _ := syscall.UTF16PtrFromString("GoScanRentalTideTray")
+	wc := wndClassEx{
+		WndProc:   syscall.NewCallback(t.wndProc),
+		Instance:  instance,
+		ClassName: className,
+	}
+	wc.Size = uint32(unsafe.Sizeof(wc))
+	if r, _, err := procRegisterClassExW.Call(uintptr(unsafe.Pointer(&wc))); r == 0 {
+		return fmt.Errorf("failed to register the tray window class: %v", err)
+	}
+	title, _ := syscall.UTF16PtrFromString("GoScanRentalTide")
+	t.hwnd, _, _ = procCreateWindowExW.Call(0, uintptr(unsafe.Pointer(className)), uintptr(unsafe.Pointer(title)),
+		0, 0, 0, 0, 0, 0, 0, instance, 0)
+	if t.hwnd == 0 {
+		return errors.New("failed to create the tray window")
+	}
+	taskbarCreated, _ := syscall.UTF16PtrFromString("TaskbarCreated")
+	t.taskbarCreated, _, _ = procRegisterWindowMessageW.Call(uintptr(unsafe.Pointer(taskbarCreated)))
+
+	icon, _, _ := procLoadIconW.Call(0, idiApplication)
+	t.data = notifyIconData{
+		Wnd:             t.hwnd,
+		ID:              1,
+		Flags:           nifMessage | nifIcon | nifTip,
+		CallbackMessage: wmTrayIcon,
+		Icon:            icon,
+	}
+	t.data.Size = uint32(unsafe.Sizeof(t.data))
+	setUTF16(t.data.Tip[:], trayTip(deviceSummary(opts)))
+	if !t.notify(nimAdd) {
+		return errors.New("failed to add the tray icon")
+	}
+
+	go func() {
+		for range time.Tick(trayRefreshInterval) {
+			t.refresh(deviceSummary(opts))
+		}
+	}()
+
+	var m msg
+	for {
+		r, _, _ := procGetMessageW.Call(uintptr(unsafe.Pointer(&m)), 0, 0, 0)
+		if int32(r) <= 0 {
+			return nil
+		}
+		procTranslateMessage.Call(uintptr(unsafe.Pointer(&m)))
+		procDispatchMessageW.Call(uintptr(unsafe.Pointer(&m)))
+	}
+}
+
+func (t *trayIcon) wndProc(hwnd, message, wParam, lParam uintptr) uintptr {
+	switch message {
+	case wmTrayIcon:
+		if lParam == wmLButtonUp || lParam == wmRButtonUp {
+			t.showMenu()
+			return 0
+		}
+	case t.taskbarCreated:
+		// Explorer restarted; the icon has to be added again
+		t.notify(nimAdd)
+	case wmDestroy:
+		procPostQuitMessage.Call(0)
+		return 0
+	}
+	r, _, _ := procDefWindowProcW.Call(hwnd, message, wParam, lParam)
+	return r
+}
+
+func (t *trayIcon) showMenu() {
+	menu, _, _ := procCreatePopupMenu.Call()
+	if menu == 0 {
+		return
+	}
+	defer procDestroyMenu.Call(menu)
+
+	for _, line := range deviceSummary(t.opts) {
+		appendMenu(menu, mfString|mfGrayed, 0, line)
+	}
+	appendMenu(menu, mfSeparator, 0, "")
+	appendMenu(menu, mfString, trayTestPrint, "Test print")
+	appendMenu(menu, mfString, trayRescan, "Rescan devices")
+	appendMenu(menu, mfString, trayOpenLogs, "Open logs folder")
+	appendMenu(menu, mfSeparator, 0, "")
+	appendMenu(menu, mfString, trayQuit, "Quit")
+
+	var pt point
+	procGetCursorPos.Call(uintptr(unsafe.Pointer(&pt)))
+	// The menu only closes when clicking elsewhere if the window is in
+	// the foreground
+	procSetForegroundWindow.Call(t.hwnd)
+	cmd, _, _ := procTrackPopupMenu.Call(menu, tpmReturnCmd|tpmRightButton|tpmBottomAlign,
+		uintptr(pt.X), uintptr(pt.Y), 0, t.hwnd, 0)
+	procPostMessageW.Call(t.hwnd, wmNull, 0, 0)
+
+	switch cmd {
+	case trayTestPrint:
+		go func() {
+			if err := printTestPage(context.Background(), t.opts); err != nil {
+				t.balloon("Test print failed", err.Error(), true)
+			} else {
+				t.balloon("Test print sent", "Sent a test page to "+printerKey(t.opts.PrinterName), false)
+			}
+			t.refresh(deviceSummary(t.opts))
+		}()
+	case trayRescan:
+		go func() {
+			lines := deviceSummary(t.opts)
+			t.refresh(lines)
+			t.balloon("Devices", strings.Join(lines, "\n"), false)
+		}()
+	case trayOpenLogs:
+		logs := filepath.Join(t.opts.AppDir, "logs")
+		if err := exec.Command("explorer", logs).Start(); err != nil {
+			log.Printf("Tray: failed to open %s: %v", logs, err)
+		}
+	case trayQuit:
+		log.Printf("Quit from the tray icon")
+		t.notify(nimDelete)
+		os.Exit(0)
+	}
+}
+
+// refresh updates the tooltip
+func (t *trayIcon) refresh(lines []string) {
+	t.mu.Lock()
+	setUTF16(t.data.Tip[:], trayTip(lines))
+	t.mu.Unlock()
+	t.notify(nimModify)
+}
+
+// balloon shows a notification next to the icon
+func (t *trayIcon) balloon(title, text string, isError bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.data.Flags |= nifInfo
+	t.data.InfoFlags = niifInfo
+	if isError {
+		t.data.InfoFlags = niifError
+	}
+	setUTF16(t.data.InfoTitle[:], title)
+	setUTF16(t.data.Info[:], text)
+	procShellNotifyIconW.Call(nimModify, uintptr(unsafe.Pointer(&t.data)))
+	t.data.Flags &^= nifInfo
+}
+
+func (t *trayIcon) notify(action uintptr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, _, _ := procShellNotifyIconW.Call(action, uintptr(unsafe.Pointer(&t.data)))
+	return r != 0
+}
+
+func trayTip(lines []string) string {
+	return "GoScanRentalTide\n" + strings.Join(lines, "\n")
+}
+
+func appendMenu(menu uintptr, flags, id uintptr, text string) {
+	var item uintptr
+	if text != "" {
+		p, _ := syscall.UTF16PtrFromString(text)
+		item = uintptr(unsafe.Pointer(p))
+	}
+	procAppendMenuW.Call(menu, flags, id, item)
+}
+
+// setUTF16 copies s into a fixed-size buffer, truncating it and keeping
+// the terminating NUL
+func setUTF16(dst []uint16, s string) {
+	u := syscall.StringToUTF16(strings.ReplaceAll(s, "\x00", ""))
+	n := copy(dst[:len(dst)-1], u)
+	dst[n] = 0
+}
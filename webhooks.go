@@ -0,0 +1,39 @@
+package main
+
+import (
+	"GoScanRentalTide/internal/journal"
+	"GoScanRentalTide/internal/webhook"
+)
+
+// webhooks delivers events to the configured receivers; nil when
+// -webhook-config is not set. Paper low isn't among the agent's events:
+// the spooler it prints through reports no paper status (see
+// internal/webhook).
+var webhooks *webhook.Dispatcher
+
+// notifyWebhooks raises the webhook events for a journaled scan or print
+// job. printerChanged is set when the job took its printer offline or
+// brought it back (see trackPrinter).
+func notifyWebhooks(e journal.Entry, printerChanged bool) {
+	if webhooks == nil {
+		return
+	}
+	switch e.Kind {
+	case journal.Scan:
+		if e.Status == "success" {
+			webhooks.Send(webhook.ScanCompleted, e)
+		}
+	case journal.Print:
+		printer := printerKey(e.Printer)
+		failed := e.Status == "failed"
+		if failed {
+			webhooks.Send(webhook.PrintFailed, e)
+		}
+		switch {
+		case printerChanged && failed:
+			webhooks.Send(webhook.PrinterOffline, map[string]string{"printer": printer, "error": e.Error})
+		case printerChanged:
+			webhooks.Send(webhook.PrinterOnline, map[string]string{"printer": printer})
+		}
+	}
+}